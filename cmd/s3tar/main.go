@@ -7,20 +7,37 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"filippo.io/age"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/middleware"
-	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+	s3tar "github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar"
+	"github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar/s3tarpb"
 	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
 )
 
 var (
@@ -29,16 +46,62 @@ var (
 	VersionMsg       = fmt.Sprintf("%s-%s", Version, Commit)
 	newArchiveClient = s3tar.NewArchiveClient
 	listAllObjects   = s3tar.ListAllObjects
-	loadCSV          = s3tar.LoadCSV
+	loadManifest     = s3tar.LoadManifest
+	openManifest     = s3tar.OpenManifest
 )
 
 const (
 	maxSize = 1024 * 1024 * 1024 * 1024 * 5
 )
 
+// Exit codes of the outcomes a wrapper can act on, see Exit codes in the
+// README. Invalid flags exit with the codes of their checks.
+const (
+	exitInterrupted  = 12
+	exitGroupsFailed = 14
+	exitOverLimits   = 15
+	exitPartial      = 16
+	exitThrottled    = 17
+	exitPreflight    = 18
+)
+
+// partialError is the result of an archive complete without some of its
+// objects, e.g. invalid keys with --check-keys skip.
+type partialError struct {
+	skipped int64
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("complete, %d objects were skipped", e.skipped)
+}
+
+// partialResult returns a *partialError when the job succeeded but skipped
+// objects.
+func partialResult(skipped *s3tar.SkipCounter, err error) error {
+	if err == nil && skipped.Skipped() > 0 {
+		return &partialError{skipped: skipped.Skipped()}
+	}
+	return err
+}
+
 func main() {
 	err := run(os.Args)
-	if err != nil {
+	var groupsErr *s3tar.GroupsFailedError
+	var limitErr *s3tar.SelectionLimitError
+	var partialErr *partialError
+	if errors.Is(err, s3tar.ErrInterrupted) {
+		exitError(exitInterrupted, "%s\n", err.Error())
+	} else if errors.As(err, &groupsErr) {
+		exitError(exitGroupsFailed, "%s\n", err.Error())
+	} else if errors.As(err, &limitErr) {
+		exitError(exitOverLimits, "%s\n", err.Error())
+	} else if errors.Is(err, s3tar.ErrPreflight) {
+		exitError(exitPreflight, "%s\n", err.Error())
+	} else if s3tar.IsThrottled(err) {
+		exitError(exitThrottled, "throttled by Amazon S3, try again later or with fewer --goroutines: %s\n", err.Error())
+	} else if errors.As(err, &partialErr) {
+		exitError(exitPartial, "%s\n", err.Error())
+	} else if err != nil {
 		log.Fatal(err.Error())
 	}
 }
@@ -50,6 +113,8 @@ func run(args []string) error {
 	var list bool
 	var generateToc bool
 	var generateManifest bool
+	var selfTest bool
+	var bsdtarPath string
 	var region string
 	var endpointUrl string
 	var archiveFile string // file flag
@@ -61,20 +126,160 @@ func run(args []string) error {
 	var extended bool
 	var externalToc string
 	var storageClass string
+	var acl string
 	var sizeLimit int64
 	var maxAttempts int
+	var retryBudget int
 	var concatInMemory bool
 	var urlDecode bool
 	var userPartMaxSize int64
 	var awsProfile string
+	var srcProfile string
+	var dstProfile string
+	var srcRoleARN string
+	var dstRoleARN string
 	var tagSetInput string
 	var kmsKeyID string
 	var sseAlgo string
+	var sseBucketKey bool
+	var template string
 	var preservePosixMetadata bool
+	var concurrentArchives int
+	var dedup bool
+	var strictUSTARChecksum bool
+	var sha256Digests bool
+	var manifestAttributes bool
+	var manifestColumns string
+	var manifestDelimiter string
+	var manifestLazyQuotes bool
+	var manifestChunkSize int
+	var stats bool
+	var statsJSON bool
+	var resume bool
+	var onInterrupt string
+	var checkKeys string
+	var onConflict string
+	var skipPreflight bool
+	var checkPermissions bool
+	var checkNetwork bool
+	var requireGatewayEndpoint bool
+	var noncurrentVersions bool
+	var allVersions bool
+	var groupByDelimiter string
+	var groupByRegex string
+	var shards int
+	var maxObjects int
+	var maxBytes int64
+	var splitOverLimits bool
+	var deleteVersions bool
+	var overwrite bool
+	var keepIntermediates bool
+	var metricsAddr string
+	var listenAddr string
+	var grpcListenAddr string
+	var workers int
+	var queueSize int
+	var jobRetention time.Duration
+	var queueURL string
+	var visibilityTimeout int
+	var statusPrefix string
+	var statusTable string
+	var tenantLimits s3tar.TenantLimits
+	var tenantOverrides cli.StringSlice
+	var objectLambdaArchive string
+	var runtimeAPI string
+	var planPath string
+	var planChunk int
+	var planExport string
+	var planMaxConcurrency int
+	var benchOpts s3tar.BenchOptions
+	var benchBaseline string
+	var benchOutput string
+	var benchTolerance float64
+	var httpOptions s3tar.HTTPClientOptions
+	var useFIPSEndpoint bool
+	var maxBandwidth int64
+	var autoTune bool
+	var encryptKMSKey string
+	var encryptAgeRecipients cli.StringSlice
+	var ageIdentity string
+	var signKMSKey string
+	var signKey string
+	var publicKey string
+	var decryptOutput string
+	var masterIndexPath string
+	var auditLogPath string
+	var archiveRoot string
+	var clusterByPrefix bool
+	var stageHeaders bool
+	var verifySizes bool
+	var trustListing bool
+	var align string
+	var heartbeatInterval time.Duration
+	var presignExpiry time.Duration
+	var htmlReport bool
+	var listCheckpoint time.Duration
+	var maxRuntime time.Duration
+	var traceObjects string
+	var appID string
+	var userAgentTags cli.StringSlice
+	var manifestMeta cli.StringSlice
+	var noEmbeddedManifest bool
+	var restoreIndex string
+	var restoreKeys string
+	var metadataSidecars bool
+	var restoreMetadata bool
+	var extractPartSize int64
+	var padSize int64
+	var eofPadding string
+	var tocMemoryLimit int64
+
+	var normalizePrefixes bool
+	var logLevelName string
+	var quiet bool
 
 	var tagSet types.Tagging
 	var err error
 
+	// normalizeURL applies --normalize-prefixes to an S3 URL given by the
+	// user, local paths are left as is
+	normalizeURL := func(s string) string {
+		if normalizePrefixes && strings.HasPrefix(s, "s3://") {
+			return s3tar.NormalizePrefix(s)
+		}
+		return s
+	}
+
+	// sourceObjects lists src or loads -m for the commands that look at the
+	// objects without archiving them
+	sourceObjects := func(ctx context.Context, svc *s3.Client, src string) ([]*s3tar.S3Obj, error) {
+		columns, err := s3tar.ParseManifestColumns(manifestColumns)
+		if err != nil {
+			exitError(11, "invalid --manifest-columns: %s\n", err.Error())
+		}
+		delimiter, err := parseDelimiter(manifestDelimiter)
+		if err != nil {
+			exitError(11, "invalid --manifest-delimiter: %s\n", err.Error())
+		}
+		var objectList []*s3tar.S3Obj
+		if manifestPath != "" {
+			objectList, _, err = loadManifest(ctx, svc, manifestPath, s3tar.ManifestOptions{
+				SkipHeader: skipManifestHeader,
+				UrlDecode:  urlDecode,
+				Columns:    columns,
+				Delimiter:  delimiter,
+				LazyQuotes: manifestLazyQuotes,
+			})
+		} else {
+			bucket, prefix := s3tar.ExtractBucketAndPath(normalizeURL(src))
+			if bucket == "" {
+				exitError(4, "source directory or manifest file is required.\n")
+			}
+			objectList, _, err = listAllObjects(ctx, svc, bucket, prefix)
+		}
+		return objectList, err
+	}
+
 	cli.VersionFlag = &cli.BoolFlag{
 		Name:    "print-version",
 		Aliases: []string{"V"},
@@ -82,6 +287,54 @@ func run(args []string) error {
 	}
 	app := &cli.App{
 		UseShortOptionHandling: true,
+		Before: func(cCtx *cli.Context) error {
+			if template != "" {
+				if err := applyTemplate(cCtx, template); err != nil {
+					exitError(13, "%s\n", err.Error())
+				}
+			}
+			if httpOptions.MaxIdleConnsPerHost == 0 {
+				httpOptions.MaxIdleConnsPerHost = threads
+			}
+			if logLevelName != "" {
+				if _, err := s3tar.ParseLogLevel(logLevelName); err != nil {
+					exitError(13, "invalid --log-level: %s\n", err.Error())
+				}
+			}
+			if maxBandwidth < 0 {
+				exitError(13, "--max-bandwidth must be positive\n")
+			}
+			if retryBudget < 0 {
+				exitError(13, "--retry-budget should be >= 0\n")
+			}
+			if eofPadding != "" && eofPadding != s3tar.EOFPaddingCompat && eofPadding != s3tar.EOFPaddingPOSIX {
+				exitError(13, "--eof-padding must be %s or %s\n", s3tar.EOFPaddingCompat, s3tar.EOFPaddingPOSIX)
+			}
+			ctx = s3tar.WithMaxBandwidth(ctx, maxBandwidth*1024*1024)
+			archiveFile = normalizeURL(archiveFile)
+			destination = normalizeURL(destination)
+			manifestPath = normalizeURL(manifestPath)
+			masterIndexPath = normalizeURL(masterIndexPath)
+			auditLogPath = normalizeURL(auditLogPath)
+			traceObjects = normalizeURL(traceObjects)
+			if autoTune {
+				ctx = s3tar.WithConcurrencyTuner(ctx, s3tar.NewConcurrencyTuner(1, threads))
+			}
+			for _, tag := range userAgentTags.Value() {
+				if _, _, err := s3tar.ParseUserAgentTag(tag); err != nil {
+					exitError(13, "invalid --user-agent-tag: %s\n", err.Error())
+				}
+			}
+			if useFIPSEndpoint {
+				if endpointUrl != "" {
+					exitError(13, "--use-fips-endpoint can't be used with --endpointUrl\n")
+				}
+				if s3tar.Partition(region) == "aws-cn" {
+					exitError(13, "there are no FIPS endpoints in %s\n", region)
+				}
+			}
+			return nil
+		},
 		Authors: []*cli.Author{
 			&cli.Author{
 				Name:  "Yanko Bolanos",
@@ -126,12 +379,33 @@ func run(args []string) error {
 				Usage:       "lists objects in an S3 Path and generates a for creating an archive later",
 				Destination: &generateManifest,
 			},
+			&cli.BoolFlag{
+				Name:        "self-test",
+				Usage:       "create archives of generated objects under the -f s3://bucket/prefix/, read them back with archive/tar and bsdtar and delete them",
+				Destination: &selfTest,
+			},
+			&cli.StringFlag{
+				Name:        "bsdtar",
+				Usage:       "bsdtar command the --self-test archives are extracted with, looked up in PATH by default",
+				Destination: &bsdtarPath,
+			},
 			&cli.BoolFlag{
 				Name:    "verbose",
 				Value:   false,
 				Usage:   "verbose level v, vv, vvv",
 				Aliases: []string{"v"},
 			},
+			&cli.StringFlag{
+				Name:        "log-level",
+				Usage:       "debug, info, warn or error. overrides -v",
+				Destination: &logLevelName,
+			},
+			&cli.BoolFlag{
+				Name:        "quiet",
+				Aliases:     []string{"q"},
+				Usage:       "log nothing, the error ending the run is still printed",
+				Destination: &quiet,
+			},
 			&cli.StringFlag{
 				Name:        "region",
 				Value:       "",
@@ -173,14 +447,15 @@ func run(args []string) error {
 			&cli.StringFlag{
 				Name:        "manifest",
 				Value:       "",
-				Usage:       "manifest file with bucket,key per line to process",
+				Usage:       "manifest file with bucket,key per line to process. Local path or s3://bucket/key, optionally gzip compressed",
 				Destination: &manifestPath,
 				Aliases:     []string{"m"},
 			},
 			&cli.StringFlag{
 				Name:        "format",
 				Value:       "pax",
-				Usage:       "tar format can be either pax or gnu",
+				Usage:       "tar format can be ustar, pax or gnu. zip creates a store-only zip archive instead",
+				Aliases:     []string{"tar-format"},
 				Destination: &tarFormat,
 			},
 			&cli.BoolFlag{
@@ -189,6 +464,11 @@ func run(args []string) error {
 				Usage:       "--extended prints out manifest with: name,byte location,content-length,Etag",
 				Destination: &extended,
 			},
+			&cli.StringFlag{
+				Name:        "template",
+				Usage:       templateUsage(),
+				Destination: &template,
+			},
 			&cli.StringFlag{
 				Name:        "external-toc",
 				Value:       "",
@@ -201,18 +481,100 @@ func run(args []string) error {
 				Usage:       "storage class of the object",
 				Destination: &storageClass,
 			},
+			&cli.StringFlag{
+				Name:        "acl",
+				Value:       string(types.ObjectCannedACLBucketOwnerFullControl),
+				Usage:       "canned ACL of the archives and the other objects written, or none to send no ACL",
+				Destination: &acl,
+			},
 			&cli.Int64Flag{
 				Name:        "size-limit",
 				Value:       maxSize,
 				Usage:       "limit the size of tars and break them into several parts (byte units). default 5TB",
 				Destination: &sizeLimit,
 			},
+			&cli.IntFlag{
+				Name:        "max-objects",
+				Usage:       "stop before archiving anything when the source has more objects, e.g. a typo in the prefix. 0 is no limit",
+				Destination: &maxObjects,
+			},
+			&cli.Int64Flag{
+				Name:        "max-bytes",
+				Usage:       "stop before archiving anything when the objects of the source are larger in total (byte units). 0 is no limit",
+				Destination: &maxBytes,
+			},
+			&cli.BoolFlag{
+				Name:        "split-over-limits",
+				Usage:       "split a source over --max-objects or --max-bytes into archives within the limits instead of stopping",
+				Destination: &splitOverLimits,
+			},
 			&cli.IntFlag{
 				Name:        "max-attempts",
 				Value:       10,
 				Usage:       "number of maxAttempts for AWS Go SDK. 0 is unlimited",
 				Destination: &maxAttempts,
 			},
+			&cli.IntFlag{
+				Name:        "retry-budget",
+				Value:       100,
+				Usage:       "number of retries the requests of a client can spend, a timeout counts twice and every request that succeeds at the first attempt gives a fifth of a retry back. Once spent requests fail at their first error. 0 is unlimited",
+				Destination: &retryBudget,
+			},
+			&cli.BoolFlag{
+				Name:        "use-fips-endpoint",
+				Usage:       "send the requests to the FIPS 140-3 endpoints, e.g. s3-fips.us-gov-west-1.amazonaws.com",
+				Destination: &useFIPSEndpoint,
+			},
+			&cli.StringFlag{
+				Name:        "app-id",
+				Usage:       "application id added to the user agent of the requests as app/<id>, e.g. the name of the job, recorded by the server access logs and CloudTrail",
+				Destination: &appID,
+			},
+			&cli.StringSliceFlag{
+				Name:        "user-agent-tag",
+				Usage:       "key=value added to the user agent of the requests as key/value, e.g. cost-center=1234, can be repeated",
+				Destination: &userAgentTags,
+			},
+			&cli.Int64Flag{
+				Name:        "max-bandwidth",
+				Usage:       "limit the data downloaded and uploaded by s3tar, e.g. with --concat-in-memory, zip, --sha256 or extraction, in MB per second. server-side copies aren't limited",
+				Destination: &maxBandwidth,
+			},
+			&cli.BoolFlag{
+				Name:        "auto-tune",
+				Usage:       "adapt the S3 requests in flight to the bucket, up to --goroutines: more while UploadPartCopy is fast, half as many when S3 answers SlowDown",
+				Destination: &autoTune,
+			},
+			&cli.BoolFlag{
+				Name:        "normalize-prefixes",
+				Usage:       "turn the backslashes of the s3:// urls given to s3tar into slashes and drop the empty path elements, e.g. for prefixes typed on Windows",
+				Destination: &normalizePrefixes,
+			},
+			&cli.DurationFlag{
+				Name:        "http-timeout",
+				Usage:       "time limit of each S3 request, including reading the response body, e.g. 10m. 0 is no limit",
+				Destination: &httpOptions.Timeout,
+			},
+			&cli.DurationFlag{
+				Name:        "response-header-timeout",
+				Usage:       "time to wait for S3 to respond once a request is sent, e.g. 5m. 0 is no limit",
+				Destination: &httpOptions.ResponseHeaderTimeout,
+			},
+			&cli.IntFlag{
+				Name:        "max-conns-per-host",
+				Usage:       "number of idle connections kept open to S3. defaults to --goroutines",
+				Destination: &httpOptions.MaxIdleConnsPerHost,
+			},
+			&cli.StringFlag{
+				Name:        "proxy-url",
+				Usage:       "send the requests through this proxy instead of the one in HTTPS_PROXY, e.g. http://proxy.example.com:3128",
+				Destination: &httpOptions.ProxyURL,
+			},
+			&cli.StringFlag{
+				Name:        "ca-bundle",
+				Usage:       "PEM file with additional certificate authorities to trust, e.g. of a TLS inspecting proxy",
+				Destination: &httpOptions.CABundle,
+			},
 			&cli.BoolFlag{
 				Name:        "concat-in-memory",
 				Value:       false,
@@ -237,6 +599,26 @@ func run(args []string) error {
 				Usage:       "",
 				Destination: &awsProfile,
 			},
+			&cli.StringFlag{
+				Name:        "src-profile",
+				Usage:       "read the source with the credentials of this profile instead of --profile",
+				Destination: &srcProfile,
+			},
+			&cli.StringFlag{
+				Name:        "dst-profile",
+				Usage:       "write the destination with the credentials of this profile instead of --profile",
+				Destination: &dstProfile,
+			},
+			&cli.StringFlag{
+				Name:        "src-role-arn",
+				Usage:       "assume this role to read the source",
+				Destination: &srcRoleARN,
+			},
+			&cli.StringFlag{
+				Name:        "dst-role-arn",
+				Usage:       "assume this role to write the destination",
+				Destination: &dstRoleARN,
+			},
 			&cli.StringFlag{
 				Name:        "tagging",
 				Usage:       "pass a tag value following awscli syntax: --tagging='{\"TagSet\": [{ \"Key\": \"transition-to\", \"Value\": \"GDA\" }]}'",
@@ -252,127 +634,1401 @@ func run(args []string) error {
 				Usage:       "aws:kms or AES256",
 				Destination: &sseAlgo,
 			},
+			&cli.BoolFlag{
+				Name:        "sse-bucket-key",
+				Usage:       "encrypt the archive with an S3 Bucket Key of --sse-kms-key-id to cut the KMS requests of the parts. Requires --sse-algo aws:kms",
+				Destination: &sseBucketKey,
+			},
 			&cli.BoolFlag{
 				Name:        "preserve-posix-metadata",
 				Usage:       "Preserve POSIX permisions, uid and gid if present in S3 object metadata. See https://docs.aws.amazon.com/fsx/latest/LustreGuide/posix-metadata-support.html",
 				Destination: &preservePosixMetadata,
 			},
+			&cli.IntFlag{
+				Name:        "concurrent-archives",
+				Value:       1,
+				Usage:       "number of archives built at the same time when --size-limit or --manifest-chunk-size splits the output. --goroutines is shared between them",
+				Destination: &concurrentArchives,
+			},
+			&cli.BoolFlag{
+				Name:        "dedup",
+				Usage:       "store objects with the same ETag and size once, the duplicates are added as hardlinks",
+				Destination: &dedup,
+			},
+			&cli.BoolFlag{
+				Name:        "strict-ustar-checksum",
+				Usage:       "validate every tar header with both signed and unsigned checksums so 7-Zip and Windows tar accept the archive",
+				Destination: &strictUSTARChecksum,
+			},
+			&cli.BoolFlag{
+				Name:        "sha256",
+				Usage:       "record the sha256 of every object in the TOC, extraction verifies the copies against it",
+				Destination: &sha256Digests,
+			},
+			&cli.BoolFlag{
+				Name:        "metadata-sidecars",
+				Usage:       "add a <key>.metadata.json entry after every object with its Content-Type, tags, ACL and user metadata, for extractors that can't read PAX records",
+				Destination: &metadataSidecars,
+			},
+			&cli.Int64Flag{
+				Name:        "extract-part-size",
+				Usage:       "use with -x to copy entries larger than this in several parts, in MB. default and max 5120",
+				Destination: &extractPartSize,
+			},
+			&cli.Int64Flag{
+				Name:        "pad-size",
+				Usage:       "minimum part size of the destination in MB, the size of the pad in front of the parts smaller than it. default 5, for S3 compatible stores with a different minimum",
+				Destination: &padSize,
+			},
+			&cli.StringFlag{
+				Name:        "eof-padding",
+				Usage:       "end of archive blocks, compat adds a zero block to an archive ending on a block (default), posix writes exactly two zero blocks after the last entry for the strict readers",
+				Destination: &eofPadding,
+			},
+			&cli.Int64Flag{
+				Name:        "toc-memory-limit",
+				Usage:       "largest TOC in MB kept in memory, larger ones are written to a temporary object under the parts prefix. default 64",
+				Destination: &tocMemoryLimit,
+			},
+			&cli.BoolFlag{
+				Name:        "restore-metadata",
+				Usage:       "use with -x to set the Content-Type, Cache-Control and user metadata of the extracted objects from the .metadata.json sidecars and PAX records",
+				Destination: &restoreMetadata,
+			},
+			&cli.BoolFlag{
+				Name:        "manifest-attributes",
+				Usage:       "use with --generate-manifest to add storage class, parts count, crc32 and sha256 columns from GetObjectAttributes",
+				Destination: &manifestAttributes,
+			},
+			&cli.StringFlag{
+				Name:        "manifest-columns",
+				Usage:       "column order of the manifest, e.g. bucket,key,versionId,-,-,size. Known columns: bucket, key, size, etag, versionId, lastModified, offset, length. Other names are skipped",
+				Destination: &manifestColumns,
+			},
+			&cli.StringFlag{
+				Name:        "manifest-delimiter",
+				Value:       ",",
+				Usage:       "field delimiter of the manifest, use '\\t' for tab separated files",
+				Destination: &manifestDelimiter,
+			},
+			&cli.BoolFlag{
+				Name:        "manifest-lazy-quotes",
+				Usage:       "allow quotes in unquoted fields and unescaped quotes in quoted fields of the manifest",
+				Destination: &manifestLazyQuotes,
+			},
+			&cli.IntFlag{
+				Name:        "manifest-chunk-size",
+				Usage:       "read the manifest this many objects at a time and create one archive per chunk (archive.00000.tar, archive.00001.tar...) instead of loading the whole manifest in memory",
+				Destination: &manifestChunkSize,
+			},
+			&cli.BoolFlag{
+				Name:        "stats",
+				Usage:       "print a report with the object count, size histogram, smallest and largest objects, the time spent and S3 requests sent in each stage once the archive is created",
+				Destination: &stats,
+			},
+			&cli.BoolFlag{
+				Name:        "stats-json",
+				Usage:       "print the --stats report as JSON, with the retries and throttled retries of every stage and S3 operation",
+				Destination: &statsJSON,
+			},
+			&cli.StringSliceFlag{
+				Name:        "manifest-meta",
+				Usage:       "key=value describing the archive, e.g. owner=data-team or ticket=OPS-1234, written to a toc.meta.json entry after the TOC, can be repeated",
+				Destination: &manifestMeta,
+			},
+			&cli.BoolFlag{
+				Name:        "no-embedded-manifest",
+				Usage:       "leave the toc.csv entry out of the archive, which only has the objects. The TOC is written next to it to <archive>.toc.csv",
+				Destination: &noEmbeddedManifest,
+			},
+			&cli.StringFlag{
+				Name:        "check-keys",
+				Usage:       "check the keys before creating the archive, e.g. for control characters or .. segments. error fails the archive, skip leaves the objects out",
+				Destination: &checkKeys,
+			},
+			&cli.StringFlag{
+				Name:        "on-conflict",
+				Usage:       "what to do with the objects archived under the name of another entry, e.g. the same key from two buckets: suffix renames them, fail fails the archive, keep-first leaves them out",
+				Destination: &onConflict,
+			},
+			&cli.StringFlag{
+				Name:        "archive-root",
+				Usage:       "a top-level directory to place every entry under, e.g. backup-2024-01/",
+				Destination: &archiveRoot,
+			},
+			&cli.BoolFlag{
+				Name:        "cluster-by-prefix",
+				Usage:       "order the entries by their directory so the entries under a prefix are adjacent and restored with a single range",
+				Destination: &clusterByPrefix,
+			},
+			&cli.BoolFlag{
+				Name:        "stage-headers",
+				Usage:       "upload the tar headers of the small objects once, in a single object, and copy them from it into the groups",
+				Destination: &stageHeaders,
+			},
+			&cli.DurationFlag{
+				Name:        "heartbeat",
+				Usage:       "write the stage and percent complete of the job to <archive>.heartbeat.json at this interval, e.g. 1m, for monitors without access to the logs",
+				Destination: &heartbeatInterval,
+			},
+			&cli.DurationFlag{
+				Name:        "presign",
+				Usage:       "print a pre-signed GET URL of the archive valid this long, e.g. 24h, and the byte range of its TOC, for consumers without AWS credentials. Up to 168h",
+				Destination: &presignExpiry,
+			},
+			&cli.BoolFlag{
+				Name:        "html-report",
+				Usage:       "write a static HTML page listing the entries of the archive, with a search box, to <archive>.html",
+				Destination: &htmlReport,
+			},
+			&cli.DurationFlag{
+				Name:        "list-checkpoint",
+				Usage:       "write the objects listed so far and the continuation token next to the archive at this interval, e.g. 1m, so a restarted run goes on listing the prefix rather than beginning again",
+				Destination: &listCheckpoint,
+			},
+			&cli.StringFlag{
+				Name:        "plan",
+				Usage:       "create the archive of a chunk of the plan written by s3tar plan, s3://bucket/archive.tar.plan/plan.json. -c, -f and -m are taken from the plan",
+				Destination: &planPath,
+			},
+			&cli.IntFlag{
+				Name:        "plan-chunk",
+				Value:       -1,
+				Usage:       "index of the chunk of --plan to create, the index of the job in an AWS Batch array job by default",
+				EnvVars:     []string{"S3TAR_PLAN_CHUNK", "AWS_BATCH_JOB_ARRAY_INDEX"},
+				Destination: &planChunk,
+			},
+			&cli.StringFlag{
+				Name:        "align",
+				Usage:       "pad the tar headers so the data of every entry starts at a multiple of 512, 4096, 1M... bytes, for readers reading the archive by aligned blocks",
+				Destination: &align,
+			},
+			&cli.BoolFlag{
+				Name:        "verify-sizes",
+				Usage:       "check the size of every source object with a HeadObject before creating the archive, e.g. for objects with a Content-Encoding served decoded by a gateway",
+				Destination: &verifySizes,
+			},
+			&cli.BoolFlag{
+				Name:        "trust-listing",
+				Usage:       "don't send a HeadObject per source object, write the sizes of the listing or the manifest as is. The size of the archive is still checked, and the sources are only checked when it is wrong. Turns --verify-sizes off",
+				Destination: &trustListing,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-preflight",
+				Usage:       "don't check the buckets, permissions and KMS key before creating the archive",
+				Destination: &skipPreflight,
+			},
+			&cli.StringFlag{
+				Name:        "group-by-delimiter",
+				Usage:       "create one archive per sub-prefix of the source, up to this delimiter, e.g. / for one archive per customer_id/. The archives are named <archive>.<group>.tar or with {group} in the archive name",
+				Destination: &groupByDelimiter,
+			},
+			&cli.StringFlag{
+				Name:        "group-by-regex",
+				Usage:       "create one archive per value of the first capture group of this regular expression in the keys, e.g. 'year=(\\d{4})/'. Keys it doesn't match are left out",
+				Destination: &groupByRegex,
+			},
+			&cli.IntFlag{
+				Name:        "shards",
+				Usage:       "split the output into this many archives by a hash of the keys, so every archive gets a balanced mix of small and large objects",
+				Destination: &shards,
+			},
+			&cli.BoolFlag{
+				Name:        "noncurrent-versions",
+				Usage:       "archive the noncurrent versions under the source prefix of a versioned bucket instead of the current objects, named key.versions/<date>-<versionId>",
+				Destination: &noncurrentVersions,
+			},
+			&cli.BoolFlag{
+				Name:        "all-versions",
+				Usage:       "archive every version under the source prefix of a versioned bucket, the current ones too, named key.versions/<date>-<versionId>, for compliance captures",
+				Destination: &allVersions,
+			},
+			&cli.BoolFlag{
+				Name:        "delete-versions",
+				Usage:       "use with --noncurrent-versions to permanently delete the archived versions and the noncurrent delete markers once the archive is complete",
+				Destination: &deleteVersions,
+			},
+			&cli.BoolFlag{
+				Name:        "check-permissions",
+				Usage:       "add a dry write to the pre-flight checks: write and delete an empty object, and copy a byte of the first object to a multipart upload under the parts prefix, complete it and delete it",
+				Destination: &checkPermissions,
+			},
+			&cli.BoolFlag{
+				Name:        "check-network",
+				Usage:       "add a network check to the pre-flight checks: resolve and connect to the S3 endpoint, and warn when the requests go through a NAT instead of an S3 gateway endpoint",
+				Destination: &checkNetwork,
+			},
+			&cli.BoolFlag{
+				Name:        "require-gateway-endpoint",
+				Usage:       "fail the pre-flight checks unless the requests go through an S3 gateway endpoint, implies --check-network",
+				Destination: &requireGatewayEndpoint,
+			},
+			&cli.BoolFlag{
+				Name:        "overwrite",
+				Usage:       "replace the archive if it already exists, otherwise creating it fails",
+				Destination: &overwrite,
+			},
+			&cli.BoolFlag{
+				Name:        "keep-intermediates",
+				Usage:       "leave the intermediate objects under <archive>.parts/ in place, to debug a failed run",
+				Destination: &keepIntermediates,
+			},
+			&cli.StringFlag{
+				Name:        "master-index",
+				Usage:       "where to write the index of every key in the archives when --size-limit or --manifest-chunk-size split the output (default <archive>.index.csv)",
+				Destination: &masterIndexPath,
+			},
+			&cli.StringFlag{
+				Name:        "audit-log",
+				Usage:       "s3://bucket/prefix to write a JSON Lines audit log of the job to: its parameters, every object archived or skipped, the retries and the result",
+				Destination: &auditLogPath,
+			},
+			&cli.StringFlag{
+				Name:        "trace-objects",
+				Usage:       "s3://bucket/key.jsonl to write a JSON Lines trace of every entry to: its header and data offsets, the part of the archive and the requests that read or copied it",
+				Destination: &traceObjects,
+			},
+			&cli.StringFlag{
+				Name:        "encrypt-kms-key",
+				Usage:       "encrypt the archive client-side with a data key generated by this KMS key. Requires --concat-in-memory or an archive smaller than 5MB, decrypt it with the decrypt command",
+				Destination: &encryptKMSKey,
+			},
+			&cli.StringFlag{
+				Name:        "sign-kms-key",
+				Usage:       "sign the TOC of the archive with this asymmetric KMS key, the signature is written to <archive>.signature.json. Check it with the verify-signature command",
+				Destination: &signKMSKey,
+			},
+			&cli.StringFlag{
+				Name:        "sign-key",
+				Usage:       "sign the TOC of the archive with the Ed25519, ECDSA or RSA private key of this PEM file",
+				Destination: &signKey,
+			},
+			&cli.StringSliceFlag{
+				Name:        "encrypt-age-recipient",
+				Usage:       "encrypt the archive client-side with a data key wrapped for this age recipient (age1...), can be repeated. Requires --concat-in-memory or an archive smaller than 5MB",
+				Destination: &encryptAgeRecipients,
+			},
+			&cli.BoolFlag{
+				Name:        "resume",
+				Usage:       "resume an interrupted archive from its checkpoint, reusing the groups it completed. With -x, skip the entries extracted by the failed run",
+				Destination: &resume,
+			},
+			&cli.StringFlag{
+				Name:        "on-interrupt",
+				Value:       s3tar.InterruptAbort,
+				Usage:       "what to do with the multipart uploads in flight on SIGINT or SIGTERM: abort or keep",
+				Destination: &onInterrupt,
+			},
+			&cli.DurationFlag{
+				Name:        "max-runtime",
+				Usage:       "stop after this long, e.g. 6h, like on SIGTERM: the checkpoint is written and the run can be resumed with --resume",
+				Destination: &maxRuntime,
+			},
+			&cli.StringFlag{
+				Name:        "metrics-addr",
+				Usage:       "serve Prometheus metrics on this address while running, e.g. :9090. The metrics are at /metrics",
+				Destination: &metricsAddr,
+			},
 		},
-		Action: func(cCtx *cli.Context) error {
-			logLevel := parseLogLevel(cCtx.Count("verbose"))
-			if region == "" && !generateToc {
-				exitError(1, "region is missing\n")
-			}
-			if archiveFile == "" {
-				exitError(2, "-f is a required flag\n")
-			}
-			if sizeLimit > maxSize {
-				sizeLimit = maxSize
-			}
-
-			if tagSetInput != "" {
-				tagSet, err = parseTagValues(tagSetInput)
-				if err != nil {
-					exitError(10, "invalid format for tags")
-				}
-			}
-
-			var loadOption config.LoadOptionsFunc
-			if endpointUrl != "" {
-				loadOption = config.WithEndpointResolverWithOptions(
-					aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-						return aws.Endpoint{
-							URL:               endpointUrl,
-							HostnameImmutable: true,
-							SigningRegion:     region,
-							Source:            aws.EndpointSourceCustom,
-						}, nil
-					}))
-			} else {
-				loadOption = config.WithRegion(region)
-			}
-
-			retryOption := config.WithRetryer(func() aws.Retryer {
-				return retry.AddWithMaxAttempts(retry.NewStandard(), maxAttempts)
-			})
-
-			optFns := []func(*config.LoadOptions) error{
-				loadOption,
-				retryOption,
-			}
-			if awsProfile != "" {
-				optFns = append(optFns, config.WithSharedConfigProfile(awsProfile))
-			}
-
-			svc := s3Client(ctx, optFns...)
-
-			if create {
-				src := cCtx.Args().First() // TODO implement dir list
-
-				if userPartMaxSize > 0 && (userPartMaxSize < 5 || userPartMaxSize > 5000) {
-					exitError(6, "max-part-size should be >= 5 and < 5000")
-				}
-
-				s3opts := &s3tar.S3TarS3Options{
-					SrcManifest:           manifestPath,
-					SkipManifestHeader:    skipManifestHeader,
-					Threads:               threads,
-					DeleteSource:          false,
-					Region:                region,
-					EndpointUrl:           endpointUrl,
-					ConcatInMemory:        concatInMemory,
-					UrlDecode:             urlDecode,
-					UserMaxPartSize:       userPartMaxSize,
-					ObjectTags:            tagSet,
-					PreservePOSIXMetadata: preservePosixMetadata,
-				}
-				s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(archiveFile)
-				s3opts.DstPrefix = filepath.Dir(s3opts.DstKey)
-				s3opts.SrcBucket, s3opts.SrcPrefix = s3tar.ExtractBucketAndPath(src)
-				if s3opts.SrcBucket == "" && manifestPath == "" {
-					exitError(4, "source directory or manifest file is required.\n")
-				}
-
-				ctx = s3tar.SetLogLevel(ctx, logLevel)
-				archiveClient := newArchiveClient(svc)
-
-				var objectList []*s3tar.S3Obj
-				var estimatedSize int64
-				var err error
-				if s3opts.SrcManifest != "" {
-					objectList, estimatedSize, err = loadCSV(ctx, svc, s3opts.SrcManifest, s3opts.SkipManifestHeader, s3opts.UrlDecode)
-				} else {
-					objectList, estimatedSize, err = listAllObjects(ctx, svc, s3opts.SrcBucket, s3opts.SrcPrefix)
-				}
-				if err != nil {
-					return err
-				}
-
-				s3tar.Infof(ctx, "estimated tar size: %d", estimatedSize)
-				if estimatedSize > sizeLimit {
-					archiveList := s3tar.BreakUpList(objectList, sizeLimit)
-					s3tar.Infof(ctx, "breaking up tar into %d parts", len(archiveList))
-					padWidth := getPadWidth(len(archiveList))
-					for i, archive := range archiveList {
-						fn := fmt.Sprintf("%s.%0*d.tar", archiveFile[:len(archiveFile)-4], padWidth, i)
-						s3tar.Infof(ctx, "creating %s", fn)
-						s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(fn)
-						s3opts.DstPrefix = filepath.Dir(s3opts.DstKey)
-						err := archiveClient.CreateFromList(ctx, archive, s3opts,
-							s3tar.WithStorageClass(storageClass),
-							s3tar.WithTarFormat(tarFormat),
-							s3tar.WithKMS(kmsKeyID, sseAlgo))
-						if err != nil {
-							return err
-						}
+		Commands: []*cli.Command{
+			{
+				Name:      "serve",
+				Usage:     "run an HTTP job API to submit, query and cancel archive and extract jobs",
+				UsageText: "s3tar --region us-west-2 serve [--listen :8080] [--grpc-listen :9090] [--workers 2] [--queue-size 100] [--tenant-max-jobs 1] [--tenant-rps 500]",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:        "listen",
+						Value:       ":8080",
+						Usage:       "address to listen on",
+						Destination: &listenAddr,
+					},
+					&cli.StringFlag{
+						Name:        "grpc-listen",
+						Usage:       "address to serve the gRPC job API on, in addition to the HTTP API",
+						Destination: &grpcListenAddr,
+					},
+					&cli.IntFlag{
+						Name:        "workers",
+						Value:       2,
+						Usage:       "number of jobs running at the same time. --goroutines is shared between them",
+						Destination: &workers,
+					},
+					&cli.IntFlag{
+						Name:        "queue-size",
+						Value:       100,
+						Usage:       "number of jobs waiting for a worker before new jobs are rejected",
+						Destination: &queueSize,
+					},
+					&cli.DurationFlag{
+						Name:        "job-retention",
+						Value:       time.Hour,
+						Usage:       "how long the status of a finished job is kept before it is removed",
+						Destination: &jobRetention,
+					},
+				}, tenantFlags(&tenantLimits, &tenantOverrides)...),
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
 					}
-					return nil
-				} else {
-					return archiveClient.CreateFromList(ctx, objectList, s3opts,
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					if workers < 1 {
+						workers = 1
+					}
+					threadsPerJob := threads / workers
+					if threadsPerJob < 1 {
+						threadsPerJob = 1
+					}
+					if err := parseTenantLimits(&tenantLimits, tenantOverrides.Value()); err != nil {
+						exitError(11, "%s\n", err.Error())
+					}
+					return serve(ctx, newArchiveClient(svc), listenAddr, grpcListenAddr, s3tar.ServerOptions{
+						Workers:      workers,
+						QueueSize:    queueSize,
+						Tenants:      tenantLimits,
+						JobRetention: jobRetention,
+						Options: s3tar.S3TarS3Options{
+							Region:      region,
+							EndpointUrl: endpointUrl,
+							Threads:     threadsPerJob,
+						},
+					})
+				},
+			},
+			{
+				Name:      "worker",
+				Usage:     "poll an SQS queue for archive and extract jobs",
+				UsageText: "s3tar --region us-west-2 worker --queue-url https://sqs.us-west-2.amazonaws.com/123456789012/s3tar-jobs [--workers 2] [--status-prefix s3://bucket/status/ | --status-table s3tar-jobs] [--tenant-max-jobs 1]",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:        "queue-url",
+						Usage:       "url of the SQS queue with the job messages",
+						Required:    true,
+						Destination: &queueURL,
+					},
+					&cli.IntFlag{
+						Name:        "workers",
+						Value:       2,
+						Usage:       "number of jobs running at the same time. --goroutines is shared between them",
+						Destination: &workers,
+					},
+					&cli.IntFlag{
+						Name:        "visibility-timeout",
+						Value:       300,
+						Usage:       "visibility timeout in seconds, extended every half timeout while a job runs",
+						Destination: &visibilityTimeout,
+					},
+					&cli.StringFlag{
+						Name:        "status-prefix",
+						Usage:       "write the status of every job to s3://bucket/prefix/<message id>.json",
+						Destination: &statusPrefix,
+					},
+					&cli.StringFlag{
+						Name:        "status-table",
+						Usage:       "write the status of every job to this DynamoDB table, partition key id (string)",
+						Destination: &statusTable,
+					},
+				}, tenantFlags(&tenantLimits, &tenantOverrides)...),
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					ctx = pauseOnSignals(ctx, s3tar.NewPauseControl())
+
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					// SQS and DynamoDB don't use the S3 endpoint override
+					cfg, err := config.LoadDefaultConfig(ctx, s3ConfigOptions(region, "", awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					if err != nil {
+						return err
+					}
+					var status s3tar.JobStatusWriter
+					if statusPrefix != "" {
+						bucket, prefix := s3tar.ExtractBucketAndPath(normalizeURL(statusPrefix))
+						status = &s3tar.S3StatusWriter{Client: svc, Bucket: bucket, Prefix: prefix}
+					} else if statusTable != "" {
+						status = &s3tar.DynamoDBStatusWriter{Client: dynamodb.NewFromConfig(cfg), Table: statusTable}
+					}
+					if workers < 1 {
+						workers = 1
+					}
+					threadsPerJob := threads / workers
+					if threadsPerJob < 1 {
+						threadsPerJob = 1
+					}
+					if err := parseTenantLimits(&tenantLimits, tenantOverrides.Value()); err != nil {
+						exitError(11, "%s\n", err.Error())
+					}
+					worker := s3tar.NewSQSWorker(newArchiveClient(svc), sqs.NewFromConfig(cfg), s3tar.SQSWorkerOptions{
+						QueueURL:          queueURL,
+						Workers:           workers,
+						VisibilityTimeout: int32(visibilityTimeout),
+						Status:            status,
+						Tenants:           tenantLimits,
+						Options: s3tar.S3TarS3Options{
+							Region:      region,
+							EndpointUrl: endpointUrl,
+							Threads:     threadsPerJob,
+						},
+					})
+					s3tar.Infof(ctx, "polling %s", queueURL)
+					return worker.Run(ctx)
+				},
+			},
+			{
+				Name:      "object-lambda",
+				Usage:     "run as the Lambda function of an S3 Object Lambda access point, answering GetObject for the keys in an archive with ranged reads of the archive",
+				UsageText: "s3tar object-lambda [--archive s3://bucket/archive.tar]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "archive",
+						Usage:       "archive to serve when the access point has no payload, the payload {\"archive\":\"s3://bucket/archive.tar\"} takes precedence",
+						Destination: &objectLambdaArchive,
+					},
+					&cli.StringFlag{
+						Name:        "runtime-api",
+						Usage:       "address of the Lambda runtime API",
+						EnvVars:     []string{"AWS_LAMBDA_RUNTIME_API"},
+						Destination: &runtimeAPI,
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						// set by Lambda
+						region = os.Getenv("AWS_REGION")
+					}
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					if runtimeAPI == "" {
+						exitError(1, "--runtime-api is missing, object-lambda runs in AWS Lambda\n")
+					}
+					if objectLambdaArchive != "" {
+						objectLambdaArchive = normalizeURL(objectLambdaArchive)
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					handler := s3tar.NewObjectLambdaHandler(svc)
+					handler.Archive = objectLambdaArchive
+					return handler.ServeLambdaRuntime(ctx, runtimeAPI)
+				},
+			},
+			{
+				Name:      "estimate",
+				Usage:     "estimate the S3 requests and cost of creating an archive without creating it",
+				UsageText: "s3tar --region us-west-2 [--concat-in-memory] [--preserve-posix-metadata] [-m manifest.csv] estimate [s3://bucket/prefix]",
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					objectList, err := sourceObjects(ctx, svc, cCtx.Args().First())
+					if err != nil {
+						return err
+					}
+					estimate := s3tar.EstimateCost(objectList, &s3tar.S3TarS3Options{
+						Region:                region,
+						ConcatInMemory:        concatInMemory,
+						PreservePOSIXMetadata: preservePosixMetadata,
+						SHA256Digests:         sha256Digests,
+						MetadataSidecars:      metadataSidecars,
+						UserMaxPartSize:       userPartMaxSize,
+						PadSize:               padSize * 1024 * 1024,
+						VerifySizes:           verifySizes,
+						TrustListing:          trustListing,
+					}, manifestPath == "", s3tar.PricesForRegion(region))
+					return estimate.WriteReport(os.Stdout)
+				},
+			},
+			{
+				Name:      "bench",
+				Usage:     "measure the grouping, header generation and concatenation planning of synthetic objects, no request is sent to S3",
+				UsageText: "s3tar bench [--objects 100000] [--baseline bench.json] [--output bench.json]",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:        "objects",
+						Value:       100000,
+						Usage:       "number of synthetic objects",
+						Destination: &benchOpts.Objects,
+					},
+					&cli.Int64Flag{
+						Name:        "object-size",
+						Value:       64 * 1024,
+						Usage:       "size of the synthetic objects in bytes",
+						Destination: &benchOpts.ObjectSize,
+					},
+					&cli.IntFlag{
+						Name:        "archive-objects",
+						Value:       50,
+						Usage:       "number of objects archived end to end in memory, 0 to skip",
+						Destination: &benchOpts.ArchiveObjects,
+					},
+					&cli.IntFlag{
+						Name:        "rounds",
+						Value:       3,
+						Usage:       "number of runs of every stage, the fastest is reported",
+						Destination: &benchOpts.Rounds,
+					},
+					&cli.StringFlag{
+						Name:        "baseline",
+						Usage:       "report written by a previous --output, fail when a stage is slower",
+						Destination: &benchBaseline,
+					},
+					&cli.Float64Flag{
+						Name:        "tolerance",
+						Value:       0.2,
+						Usage:       "how much slower than --baseline a stage can be, 0.2 for 20%",
+						Destination: &benchTolerance,
+					},
+					&cli.StringFlag{
+						Name:        "output",
+						Usage:       "write the report as JSON to this file",
+						Destination: &benchOutput,
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if benchOpts.Objects < 1 || benchOpts.ObjectSize < 0 || benchOpts.ArchiveObjects < 0 {
+						exitError(11, "--objects should be > 0, --object-size and --archive-objects >= 0\n")
+					}
+					if benchTolerance < 0 || benchTolerance >= 1 {
+						exitError(11, "--tolerance should be between 0 and 1\n")
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					benchOpts.Threads = threads
+					report, err := s3tar.RunBenchmark(ctx, benchOpts)
+					if err != nil {
+						return err
+					}
+					if err := report.WriteReport(os.Stdout); err != nil {
+						return err
+					}
+					if benchOutput != "" {
+						f, err := os.Create(benchOutput)
+						if err != nil {
+							return err
+						}
+						err = report.WriteJSON(f)
+						if cerr := f.Close(); err == nil {
+							err = cerr
+						}
+						if err != nil {
+							return err
+						}
+					}
+					if benchBaseline == "" {
+						return nil
+					}
+					f, err := os.Open(benchBaseline)
+					if err != nil {
+						return err
+					}
+					baseline, err := s3tar.LoadBenchReport(f)
+					f.Close()
+					if err != nil {
+						return err
+					}
+					if regressions := report.Compare(baseline, benchTolerance); len(regressions) > 0 {
+						return fmt.Errorf("slower than %s:\n%s", benchBaseline, strings.Join(regressions, "\n"))
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "plan",
+				Usage:     "split an archive in chunks of up to --size-limit bytes, write the manifest of every chunk next to the archive and print a CloudFormation template building a chunk per AWS Batch job",
+				UsageText: "s3tar --region us-west-2 --size-limit 107374182400 [-m manifest.csv] -f s3://bucket/archive.tar plan --export stepfunctions|batch [s3://bucket/prefix]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "export",
+						Usage:       "stepfunctions for a state machine submitting a job per chunk, batch for a job definition run as an array job",
+						Required:    true,
+						Destination: &planExport,
+					},
+					&cli.IntFlag{
+						Name:        "max-concurrency",
+						Value:       10,
+						Usage:       "number of jobs the state machine runs at the same time, 0 for no limit",
+						Destination: &planMaxConcurrency,
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					if archiveFile == "" {
+						exitError(2, "-f is a required flag\n")
+					}
+					if planExport != s3tar.PlanExportStepFunctions && planExport != s3tar.PlanExportBatch {
+						exitError(11, "--export must be %s or %s\n", s3tar.PlanExportStepFunctions, s3tar.PlanExportBatch)
+					}
+					if planMaxConcurrency < 0 {
+						exitError(11, "--max-concurrency should be >= 0\n")
+					}
+					if sizeLimit <= 0 || sizeLimit > maxSize {
+						sizeLimit = maxSize
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					objectList, err := sourceObjects(ctx, svc, cCtx.Args().First())
+					if err != nil {
+						return err
+					}
+					bucket, key := s3tar.ExtractBucketAndPath(archiveFile)
+					plan, planURL, err := s3tar.CreatePlan(ctx, svc, objectList, bucket, key, sizeLimit, region)
+					if err != nil {
+						return err
+					}
+					s3tar.Infof(ctx, "wrote the plan of %d archives to %s", len(plan.Chunks), planURL)
+					template, err := plan.Export(planExport, planURL, planMaxConcurrency)
+					if err != nil {
+						return err
+					}
+					_, err = fmt.Fprintln(os.Stdout, string(template))
+					return err
+				},
+			},
+			{
+				Name:      "restore",
+				Usage:     "copy keys out of split archives, finding them in the master index",
+				UsageText: "s3tar --region us-west-2 restore --index s3://bucket/archive.index.csv --keys keys.txt s3://bucket/restored/",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "index",
+						Usage:       "master index written when the archives were created, local or in Amazon S3",
+						Required:    true,
+						Destination: &restoreIndex,
+					},
+					&cli.StringFlag{
+						Name:        "keys",
+						Usage:       "file with the keys to restore, one per line, local or in Amazon S3",
+						Required:    true,
+						Destination: &restoreKeys,
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					destination := normalizeURL(cCtx.Args().First())
+					if destination == "" {
+						exitError(4, "destination is required\n")
+					}
+					if !strings.HasSuffix(destination, "/") {
+						destination += "/"
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					keys, err := s3tar.LoadKeys(ctx, svc, normalizeURL(restoreKeys))
+					if err != nil {
+						return err
+					}
+					s3opts := &s3tar.S3TarS3Options{
+						Threads:               threads,
+						Region:                region,
+						EndpointUrl:           endpointUrl,
+						PreservePOSIXMetadata: preservePosixMetadata,
+					}
+					s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(destination)
+					s3opts.DstPrefix = s3tar.KeyDir(s3opts.DstKey)
+					results, err := s3tar.Restore(ctx, svc, normalizeURL(restoreIndex), keys, s3opts)
+					if err != nil {
+						return err
+					}
+					failed := 0
+					for _, r := range results {
+						if r.Err != nil {
+							failed++
+							fmt.Printf("failed %s: %s\n", r.Key, r.Err.Error())
+						} else {
+							fmt.Printf("restored %s from %s to %s\n", r.Key, r.Archive, r.Destination)
+						}
+					}
+					if failed > 0 {
+						return fmt.Errorf("%d of %d keys failed", failed, len(results))
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "merge",
+				Usage:     "merge archives created by s3tar into the archive given with -f, server-side",
+				UsageText: "s3tar --region us-west-2 [--storage-class STANDARD] [--overwrite] -f s3://bucket/monthly.tar merge s3://bucket/day1.tar s3://bucket/day2.tar",
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					if archiveFile == "" {
+						exitError(2, "-f is a required flag\n")
+					}
+					if cCtx.Args().Len() < 2 {
+						exitError(4, "at least two archives are required\n")
+					}
+					if tarFormat == "zip" {
+						exitError(11, "zip archives can't be merged\n")
+					}
+					if tagSetInput != "" {
+						tagSet, err = parseTagValues(tagSetInput)
+						if err != nil {
+							exitError(10, "invalid format for tags")
+						}
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					ctx = pauseOnSignals(ctx, s3tar.NewPauseControl())
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					s3opts := &s3tar.S3TarS3Options{
+						Threads:           threads,
+						Region:            region,
+						EndpointUrl:       endpointUrl,
+						ObjectTags:        tagSet,
+						Overwrite:         overwrite,
+						KeepIntermediates: keepIntermediates,
+						PadSize:           padSize * 1024 * 1024,
+						EOFPadding:        eofPadding,
+					}
+					s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(archiveFile)
+					s3opts.DstPrefix = s3tar.KeyDir(s3opts.DstKey)
+					archives := cCtx.Args().Slice()
+					for i := range archives {
+						archives[i] = normalizeURL(archives[i])
+					}
+					return s3tar.MergeArchives(ctx, svc, archives, s3opts,
+						s3tar.WithStorageClass(storageClass),
+						s3tar.WithTarFormat(tarFormat))
+				},
+			},
+			{
+				Name:      "split",
+				Usage:     "split an archive created by s3tar into archives of up to --size-limit bytes, server-side",
+				UsageText: "s3tar --region us-west-2 --size-limit 10737418240 -f s3://bucket/small.tar split s3://bucket/large.tar",
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					if archiveFile == "" {
+						exitError(2, "-f is a required flag\n")
+					}
+					if cCtx.Args().First() == "" {
+						exitError(4, "the archive to split is required\n")
+					}
+					if sizeLimit <= 0 || sizeLimit >= maxSize {
+						exitError(11, "--size-limit is required\n")
+					}
+					if tarFormat == "zip" {
+						exitError(11, "zip archives can't be split\n")
+					}
+					if tagSetInput != "" {
+						tagSet, err = parseTagValues(tagSetInput)
+						if err != nil {
+							exitError(10, "invalid format for tags")
+						}
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					ctx = pauseOnSignals(ctx, s3tar.NewPauseControl())
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					s3opts := &s3tar.S3TarS3Options{
+						Threads:           threads,
+						Region:            region,
+						EndpointUrl:       endpointUrl,
+						ObjectTags:        tagSet,
+						Overwrite:         overwrite,
+						KeepIntermediates: keepIntermediates,
+						PadSize:           padSize * 1024 * 1024,
+						EOFPadding:        eofPadding,
+					}
+					s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(archiveFile)
+					s3opts.DstPrefix = s3tar.KeyDir(s3opts.DstKey)
+					archives, err := s3tar.SplitArchive(ctx, svc, normalizeURL(cCtx.Args().First()), sizeLimit, s3opts,
+						s3tar.WithStorageClass(storageClass),
+						s3tar.WithTarFormat(tarFormat))
+					for _, archive := range archives {
+						fmt.Println(archive)
+					}
+					return err
+				},
+			},
+			{
+				Name:      "decrypt",
+				Usage:     "decrypt an archive encrypted with --encrypt-kms-key or --encrypt-age-recipient",
+				UsageText: "s3tar --region us-west-2 decrypt [--age-identity key.txt] [-o archive.tar] s3://bucket/archive.tar",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "age-identity",
+						Usage:       "file with the age identities (AGE-SECRET-KEY-1...) to unwrap the data key with. Not needed for KMS",
+						Destination: &ageIdentity,
+					},
+					&cli.StringFlag{
+						Name:        "output",
+						Aliases:     []string{"o"},
+						Usage:       "write the tar to this file instead of stdout",
+						Destination: &decryptOutput,
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					bucket, key := s3tar.ExtractBucketAndPath(normalizeURL(cCtx.Args().First()))
+					if bucket == "" || key == "" {
+						exitError(5, "file is missing")
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					enc, err := clientEncryption(ctx, s3ConfigOptions(region, "", awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value()), "", nil)
+					if err != nil {
+						return err
+					}
+					if ageIdentity != "" {
+						f, err := os.Open(ageIdentity)
+						if err != nil {
+							return err
+						}
+						enc.AgeIdentities, err = age.ParseIdentities(f)
+						f.Close()
+						if err != nil {
+							exitError(11, "invalid --age-identity: %s\n", err.Error())
+						}
+					}
+					var w io.Writer = os.Stdout
+					if decryptOutput != "" {
+						f, err := os.Create(decryptOutput)
+						if err != nil {
+							return err
+						}
+						defer f.Close()
+						w = f
+					}
+					return s3tar.DecryptArchive(ctx, svc, bucket, key, enc, w)
+				},
+			},
+			{
+				Name:      "verify-signature",
+				Usage:     "check an archive against the signature written by --sign-kms-key or --sign-key",
+				UsageText: "s3tar --region us-west-2 verify-signature [--public-key key.pem] s3://bucket/archive.tar",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "public-key",
+						Usage:       "PEM file with the public key to check the signature with, also for a KMS key. Without it the signature of a KMS key is checked with kms:Verify",
+						Destination: &publicKey,
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if region == "" {
+						exitError(1, "region is missing\n")
+					}
+					bucket, key := s3tar.ExtractBucketAndPath(normalizeURL(cCtx.Args().First()))
+					if bucket == "" || key == "" {
+						exitError(5, "file is missing")
+					}
+					ctx = s3tar.SetLogLevel(ctx, parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet))
+					svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+					verifier := &s3tar.ArchiveVerifier{}
+					if publicKey != "" {
+						data, err := os.ReadFile(publicKey)
+						if err != nil {
+							return err
+						}
+						verifier.PublicKey, err = s3tar.ParsePublicKey(data)
+						if err != nil {
+							exitError(11, "invalid --public-key: %s\n", err.Error())
+						}
+					} else {
+						cfg, err := config.LoadDefaultConfig(ctx, s3ConfigOptions(region, "", awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+						if err != nil {
+							return err
+						}
+						verifier.KMS = kms.NewFromConfig(cfg)
+					}
+					sig, err := s3tar.VerifySignature(ctx, svc, bucket, key, verifier)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("s3://%s/%s: valid signature of %s, signed %s\n", bucket, key, sig.KeyID, sig.SignedAt.Format(time.RFC3339))
+					return nil
+				},
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			logLevel := parseLogLevel(cCtx.Count("verbose"), logLevelName, quiet)
+			if region == "" && !generateToc {
+				exitError(1, "region is missing\n")
+			}
+			if archiveFile == "" && planPath == "" {
+				exitError(2, "-f is a required flag\n")
+			}
+			if sizeLimit > maxSize {
+				sizeLimit = maxSize
+			}
+
+			if tagSetInput != "" {
+				tagSet, err = parseTagValues(tagSetInput)
+				if err != nil {
+					exitError(10, "invalid format for tags")
+				}
+			}
+
+			cannedACL, err := s3tar.ParseCannedACL(acl)
+			if err != nil {
+				exitError(11, "invalid --acl: %s\n", err.Error())
+			}
+
+			svc := s3Client(ctx, s3ConfigOptions(region, endpointUrl, awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())...)
+			if cannedACL != types.ObjectCannedACLBucketOwnerFullControl {
+				svc = s3.New(svc.Options(), s3tar.WithCannedACL(cannedACL))
+			}
+
+			if metricsAddr != "" {
+				serveMetrics(metricsAddr)
+			}
+
+			if planPath != "" {
+				plan, err := s3tar.LoadPlan(ctx, svc, normalizeURL(planPath))
+				if err != nil {
+					return err
+				}
+				if planChunk == -1 && len(plan.Chunks) == 1 {
+					planChunk = 0
+				}
+				if planChunk < 0 || planChunk >= len(plan.Chunks) {
+					exitError(11, "--plan-chunk should be one of the %d chunks of the plan, from 0\n", len(plan.Chunks))
+				}
+				chunk := plan.Chunks[planChunk]
+				s3tar.Infof(ctx, "creating chunk %d of %d of %s", planChunk, len(plan.Chunks), planPath)
+				create, archiveFile, manifestPath = true, chunk.Archive, chunk.Manifest
+				skipManifestHeader, urlDecode, manifestColumns, manifestDelimiter = true, false, "", ","
+			}
+
+			if srcProfile != "" || dstProfile != "" || srcRoleARN != "" || dstRoleARN != "" {
+				if !create && !extract {
+					exitError(11, "--src-profile, --dst-profile, --src-role-arn and --dst-role-arn are only supported with --create and --extract\n")
+				}
+				configOptions := func(profile string) []func(*config.LoadOptions) error {
+					if profile == "" {
+						profile = awsProfile
+					}
+					return s3ConfigOptions(region, "", profile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value())
+				}
+				dst, err := roleCredentials(ctx, configOptions(dstProfile), dstRoleARN)
+				if err != nil {
+					exitError(11, "unable to load the destination credentials: %s\n", err.Error())
+				}
+				src, err := roleCredentials(ctx, configOptions(srcProfile), srcRoleARN)
+				if err != nil {
+					exitError(11, "unable to load the source credentials: %s\n", err.Error())
+				}
+				dstBucket, _ := s3tar.ExtractBucketAndPath(archiveFile)
+				if extract {
+					dstBucket, _ = s3tar.ExtractBucketAndPath(destination)
+				}
+				svc = s3.New(svc.Options(), func(o *s3.Options) { o.Credentials = dst }, s3tar.WithSourceCredentials(src, dstBucket), s3tar.WithCredentialsRefresh)
+			}
+
+			if create {
+				src := normalizeURL(cCtx.Args().First()) // TODO implement dir list
+
+				if userPartMaxSize > 0 && (userPartMaxSize < 5 || userPartMaxSize > 5000) {
+					exitError(6, "max-part-size should be >= 5 and < 5000")
+				}
+
+				columns, err := s3tar.ParseManifestColumns(manifestColumns)
+				if err != nil {
+					exitError(11, "invalid --manifest-columns: %s\n", err.Error())
+				}
+				delimiter, err := parseDelimiter(manifestDelimiter)
+				if err != nil {
+					exitError(11, "invalid --manifest-delimiter: %s\n", err.Error())
+				}
+				if checkKeys != "" && checkKeys != s3tar.CheckKeysError && checkKeys != s3tar.CheckKeysSkip {
+					exitError(11, "--check-keys must be %s or %s\n", s3tar.CheckKeysError, s3tar.CheckKeysSkip)
+				}
+				switch onConflict {
+				case "", s3tar.ConflictSuffix, s3tar.ConflictFail, s3tar.ConflictKeepFirst:
+				default:
+					exitError(11, "--on-conflict must be %s, %s or %s\n", s3tar.ConflictSuffix, s3tar.ConflictFail, s3tar.ConflictKeepFirst)
+				}
+				var alignBytes int64
+				if align != "" {
+					if alignBytes, err = s3tar.ParseAlign(align); err != nil {
+						exitError(11, "invalid --align: %s\n", err.Error())
+					}
+				}
+				if archiveRoot != "" {
+					if err := s3tar.CheckArchiveRoot(archiveRoot); err != nil {
+						exitError(11, "invalid --archive-root: %s\n", err.Error())
+					}
+				}
+				if onInterrupt != s3tar.InterruptAbort && onInterrupt != s3tar.InterruptKeep {
+					exitError(11, "--on-interrupt must be %s or %s\n", s3tar.InterruptAbort, s3tar.InterruptKeep)
+				}
+				if padSize < 0 || padSize > 5120 {
+					exitError(11, "--pad-size should be >= 1 and <= 5120\n")
+				}
+				if deleteVersions && !noncurrentVersions {
+					exitError(11, "--delete-versions requires --noncurrent-versions\n")
+				}
+				if noncurrentVersions && (manifestPath != "" || manifestChunkSize > 0) {
+					exitError(11, "--noncurrent-versions lists the source prefix, it can't be used with a manifest\n")
+				}
+				if allVersions && (manifestPath != "" || manifestChunkSize > 0 || noncurrentVersions) {
+					exitError(11, "--all-versions lists the source prefix, it can't be used with a manifest or --noncurrent-versions\n")
+				}
+				if checkPermissions && skipPreflight {
+					exitError(11, "--check-permissions can't be used with --skip-preflight\n")
+				}
+				if (checkNetwork || requireGatewayEndpoint) && skipPreflight {
+					exitError(11, "--check-network and --require-gateway-endpoint can't be used with --skip-preflight\n")
+				}
+				if tocMemoryLimit < 0 {
+					exitError(11, "--toc-memory-limit should be >= 1\n")
+				}
+				if listCheckpoint < 0 {
+					exitError(11, "--list-checkpoint should be positive\n")
+				}
+				if listCheckpoint > 0 && (manifestPath != "" || noncurrentVersions || allVersions) {
+					exitError(11, "--list-checkpoint checkpoints the listing of a prefix, it can't be used with a manifest, --noncurrent-versions or --all-versions\n")
+				}
+				if shards < 0 {
+					exitError(11, "--shards should be >= 1\n")
+				}
+				if maxRuntime < 0 {
+					exitError(11, "--max-runtime should be positive\n")
+				}
+				if sseBucketKey && (kmsKeyID == "" || sseAlgo != "aws:kms") {
+					exitError(11, "--sse-bucket-key requires --sse-kms-key-id and --sse-algo aws:kms\n")
+				}
+				if noEmbeddedManifest && (align != "" || tarFormat == "zip") {
+					exitError(11, "--no-embedded-manifest can't be used with --align or --format zip\n")
+				}
+				if trustListing && (preservePosixMetadata || metadataSidecars) {
+					exitError(11, "--trust-listing can't be used with --preserve-posix-metadata or --metadata-sidecars, they read the metadata of every object with a HeadObject\n")
+				}
+				var archiveMeta map[string]string
+				for _, kv := range manifestMeta.Value() {
+					k, v, err := s3tar.ParseArchiveMeta(kv)
+					if err != nil {
+						exitError(11, "invalid --manifest-meta: %s\n", err.Error())
+					}
+					if archiveMeta == nil {
+						archiveMeta = map[string]string{}
+					}
+					archiveMeta[k] = v
+				}
+				if shards > 0 && (groupByDelimiter != "" || groupByRegex != "" || manifestChunkSize > 0) {
+					exitError(11, "--shards can't be used with --group-by-delimiter, --group-by-regex or --manifest-chunk-size\n")
+				}
+				if maxObjects < 0 || maxBytes < 0 {
+					exitError(11, "--max-objects and --max-bytes should be >= 0\n")
+				}
+				limits := s3tar.SelectionLimits{MaxObjects: maxObjects, MaxBytes: maxBytes}
+				hasLimits := maxObjects > 0 || maxBytes > 0
+				if hasLimits && manifestChunkSize > 0 {
+					exitError(11, "--max-objects and --max-bytes can't be used with --manifest-chunk-size\n")
+				}
+				if splitOverLimits && (!hasLimits || shards > 0 || groupByDelimiter != "" || groupByRegex != "") {
+					exitError(11, "--split-over-limits requires --max-objects or --max-bytes, it can't be used with --shards, --group-by-delimiter or --group-by-regex\n")
+				}
+				var groupFn func(string) (string, bool)
+				if groupByDelimiter != "" || groupByRegex != "" {
+					if groupByDelimiter != "" && groupByRegex != "" {
+						exitError(11, "--group-by-delimiter and --group-by-regex can't be used together\n")
+					}
+					if manifestChunkSize > 0 {
+						exitError(11, "--manifest-chunk-size can't be used with --group-by-delimiter or --group-by-regex\n")
+					}
+					if groupByRegex != "" {
+						re, err := regexp.Compile(groupByRegex)
+						if err != nil {
+							exitError(11, "invalid --group-by-regex: %s\n", err.Error())
+						}
+						groupFn = s3tar.GroupByRegex(re)
+					} else {
+						_, srcPrefix := s3tar.ExtractBucketAndPath(src)
+						groupFn = s3tar.GroupByDelimiter(srcPrefix, groupByDelimiter)
+					}
+				}
+
+				s3opts := &s3tar.S3TarS3Options{
+					SrcManifest:            manifestPath,
+					ManifestColumns:        columns,
+					ManifestDelimiter:      delimiter,
+					ManifestLazyQuotes:     manifestLazyQuotes,
+					SkipManifestHeader:     skipManifestHeader,
+					Threads:                threads,
+					DeleteSource:           false,
+					Region:                 region,
+					EndpointUrl:            endpointUrl,
+					ConcatInMemory:         concatInMemory,
+					UrlDecode:              urlDecode,
+					UserMaxPartSize:        userPartMaxSize,
+					ObjectTags:             tagSet,
+					PreservePOSIXMetadata:  preservePosixMetadata,
+					Dedup:                  dedup,
+					StrictUSTARChecksum:    strictUSTARChecksum,
+					SHA256Digests:          sha256Digests,
+					InterruptPolicy:        onInterrupt,
+					Resume:                 resume,
+					CheckKeys:              checkKeys,
+					OnConflict:             onConflict,
+					BucketKeyEnabled:       sseBucketKey,
+					ArchiveMeta:            archiveMeta,
+					NoEmbeddedTOC:          noEmbeddedManifest,
+					ArchiveRoot:            archiveRoot,
+					ClusterByPrefix:        clusterByPrefix,
+					StageHeaders:           stageHeaders,
+					VerifySizes:            verifySizes,
+					TrustListing:           trustListing,
+					Align:                  alignBytes,
+					HeartbeatInterval:      heartbeatInterval,
+					SkipPreflight:          skipPreflight,
+					CheckPermissions:       checkPermissions,
+					CheckNetwork:           checkNetwork,
+					RequireGatewayEndpoint: requireGatewayEndpoint,
+					NoncurrentVersions:     noncurrentVersions,
+					AllVersions:            allVersions,
+					MetadataSidecars:       metadataSidecars,
+					Overwrite:              overwrite,
+					KeepIntermediates:      keepIntermediates,
+					PadSize:                padSize * 1024 * 1024,
+					EOFPadding:             eofPadding,
+					TOCMemoryLimit:         tocMemoryLimit * 1024 * 1024,
+					HTMLReport:             htmlReport,
+				}
+				if stats || statsJSON {
+					s3opts.Stats = os.Stdout
+					s3opts.StatsJSON = statsJSON
+				}
+				if presignExpiry != 0 {
+					if presignExpiry < time.Second || presignExpiry > s3tar.MaxPresignExpiry {
+						exitError(11, "--presign must be between 1s and %s\n", s3tar.MaxPresignExpiry)
+					}
+					s3opts.Presigned = os.Stdout
+					s3opts.PresignExpiry = presignExpiry
+				}
+				if encryptKMSKey != "" || len(encryptAgeRecipients.Value()) > 0 {
+					if encryptKMSKey != "" && len(encryptAgeRecipients.Value()) > 0 {
+						exitError(11, "--encrypt-kms-key and --encrypt-age-recipient can't be used together\n")
+					}
+					s3opts.ClientEncryption, err = clientEncryption(ctx, s3ConfigOptions(region, "", awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value()), encryptKMSKey, encryptAgeRecipients.Value())
+					if err != nil {
+						exitError(11, "invalid client-side encryption: %s\n", err.Error())
+					}
+				}
+				if signKMSKey != "" || signKey != "" {
+					s3opts.Signer, err = archiveSigner(ctx, s3ConfigOptions(region, "", awsProfile, maxAttempts, retryBudget, httpOptions, useFIPSEndpoint, appID, userAgentTags.Value()), signKMSKey, signKey)
+					if err != nil {
+						exitError(11, "invalid archive signing: %s\n", err.Error())
+					}
+				}
+				s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(archiveFile)
+				s3opts.DstPrefix = s3tar.KeyDir(s3opts.DstKey)
+				s3opts.SrcBucket, s3opts.SrcPrefix = s3tar.ExtractBucketAndPath(src)
+				if s3opts.SrcBucket == "" && manifestPath == "" {
+					exitError(4, "source directory or manifest file is required.\n")
+				}
+
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				warnStaticCredentials(ctx, svc)
+				if stats || statsJSON {
+					ctx = s3tar.WithStats(ctx)
+				}
+				skipped := &s3tar.SkipCounter{}
+				ctx = s3tar.WithSkipCounter(ctx, skipped)
+				// stop scheduling parts on SIGTERM so a checkpoint is written
+				ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				if maxRuntime > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = s3tar.WithMaxRuntime(ctx, maxRuntime)
+					defer cancel()
+				}
+				ctx = pauseOnSignals(ctx, s3tar.NewPauseControl())
+				archiveClient := newArchiveClient(svc)
+				auditLog := newAuditLog(ctx, svc, auditLogPath, archiveFile, src, s3opts.SrcManifest)
+				if auditLog != nil {
+					ctx = s3tar.WithAuditLog(ctx, auditLog)
+				}
+				if traceObjects != "" {
+					bucket, key := s3tar.ExtractBucketAndPath(traceObjects)
+					if bucket == "" || key == "" {
+						exitError(11, "--trace-objects must be an s3://bucket/key url\n")
+					}
+					traceLog := s3tar.NewTraceLog(svc, bucket, key)
+					ctx = s3tar.WithTraceLog(ctx, traceLog)
+					// the trace is a debugging aid, it doesn't fail the job
+					defer func() {
+						if err := traceLog.Close(ctx); err != nil {
+							s3tar.Errorf(ctx, "%s", err.Error())
+						}
+					}()
+				}
+
+				manifestOpts := s3tar.ManifestOptions{
+					SkipHeader: s3opts.SkipManifestHeader,
+					UrlDecode:  s3opts.UrlDecode,
+					Columns:    s3opts.ManifestColumns,
+					Delimiter:  s3opts.ManifestDelimiter,
+					LazyQuotes: s3opts.ManifestLazyQuotes,
+				}
+				if manifestChunkSize > 0 {
+					if s3opts.SrcManifest == "" {
+						exitError(11, "--manifest-chunk-size requires a manifest\n")
+					}
+					manifest, err := openManifest(ctx, svc, s3opts.SrcManifest, manifestOpts)
+					if err != nil {
+						return err
+					}
+					defer manifest.Close()
+					index := masterIndex(svc, archiveFile, masterIndexPath, concatInMemory || tarFormat == "zip")
+					err = s3tar.RunManifestChunks(s3tar.WithMasterIndex(ctx, index), archiveClient, manifest, manifestChunkSize, s3opts, s3tar.SchedulerOptions{
+						MaxConcurrentArchives: concurrentArchives,
+						TotalThreads:          threads,
+					},
+						s3tar.WithStorageClass(storageClass),
+						s3tar.WithTarFormat(tarFormat),
+						s3tar.WithKMS(kmsKeyID, sseAlgo))
+					return partialResult(skipped, closeAuditLog(ctx, auditLog, closeMasterIndex(ctx, index, err)))
+				}
+
+				var objectList []*s3tar.S3Obj
+				var deleteMarkers []types.ObjectIdentifier
+				var estimatedSize int64
+				if s3opts.SrcManifest != "" {
+					objectList, estimatedSize, err = loadManifest(ctx, svc, s3opts.SrcManifest, manifestOpts)
+				} else if noncurrentVersions {
+					objectList, deleteMarkers, estimatedSize, err = s3tar.ListNoncurrentVersions(ctx, svc, s3opts.SrcBucket, s3opts.SrcPrefix)
+				} else if allVersions {
+					objectList, estimatedSize, err = s3tar.ListAllVersions(ctx, svc, s3opts.SrcBucket, s3opts.SrcPrefix)
+				} else {
+					listCtx := ctx
+					if listCheckpoint > 0 {
+						listCtx = s3tar.WithListCheckpoint(listCtx, s3opts.DstBucket, s3opts.DstKey, listCheckpoint)
+					}
+					if hasLimits && !splitOverLimits {
+						// stop listing once over the limits
+						listCtx = s3tar.WithSelectionLimits(listCtx, limits)
+					}
+					objectList, estimatedSize, err = listAllObjects(listCtx, svc, s3opts.SrcBucket, s3opts.SrcPrefix)
+				}
+				if err != nil {
+					return err
+				}
+				overLimits := false
+				if hasLimits {
+					if err := limits.Check(objectList); err != nil {
+						if !splitOverLimits {
+							return err
+						}
+						overLimits = true
+					}
+				}
+
+				s3tar.Infof(ctx, "estimated tar size: %d", estimatedSize)
+				if groupFn != nil {
+					groups, ungrouped := s3tar.GroupObjects(objectList, groupFn)
+					if len(ungrouped) > 0 {
+						s3tar.Warnf(ctx, "%d objects are in no group and are left out, e.g. %s", len(ungrouped), *ungrouped[0].Key)
+						skipped.Add(len(ungrouped))
+					}
+					if auditLog != nil {
+						for _, o := range ungrouped {
+							auditLog.Record(ctx, s3tar.AuditRecord{Event: s3tar.AuditSkipped, Bucket: o.Bucket, Key: *o.Key, VersionId: o.VersionId, Size: *o.Size, Reason: "in no group"})
+						}
+					}
+					s3tar.Infof(ctx, "grouped %d objects into %d archives", len(objectList)-len(ungrouped), len(groups))
+					objectList = nil
+					var jobs []s3tar.ArchiveJob
+					for _, group := range groups {
+						key := s3tar.GroupedArchiveName(archiveFile, group.Name)
+						// a group over --size-limit is split like the whole list
+						archiveList := s3tar.BreakUpList(group.Objects, sizeLimit)
+						for i, archive := range archiveList {
+							fn := key
+							if len(archiveList) > 1 {
+								fn = s3tar.ChunkedArchiveName(key, i)
+							}
+							jobOpts := s3opts.Copy()
+							jobOpts.DstBucket, jobOpts.DstKey = s3tar.ExtractBucketAndPath(fn)
+							jobOpts.DstPrefix = s3tar.KeyDir(jobOpts.DstKey)
+							jobs = append(jobs, s3tar.ArchiveJob{ObjectList: archive, Options: &jobOpts})
+						}
+						objectList = append(objectList, group.Objects...)
+					}
+					// s3://bucket/{group}.tar has its index in s3://bucket/groups.index.csv
+					index := masterIndex(svc, strings.ReplaceAll(archiveFile, "{group}", "groups"), masterIndexPath, concatInMemory || tarFormat == "zip")
+					err = s3tar.RunArchiveJobs(s3tar.WithMasterIndex(ctx, index), archiveClient, jobs, s3tar.SchedulerOptions{
+						MaxConcurrentArchives: concurrentArchives,
+						TotalThreads:          threads,
+					},
+						s3tar.WithStorageClass(storageClass),
+						s3tar.WithTarFormat(tarFormat),
+						s3tar.WithKMS(kmsKeyID, sseAlgo))
+					err = closeMasterIndex(ctx, index, err)
+				} else if estimatedSize > sizeLimit || shards > 0 || overLimits {
+					var archiveList [][]*s3tar.S3Obj
+					if shards > 0 {
+						if estimatedSize/int64(shards) > sizeLimit {
+							exitError(11, "%d shards of %d bytes are over --size-limit, use more shards\n", shards, estimatedSize/int64(shards))
+						}
+						archiveList = s3tar.ShardByHash(objectList, shards)
+						s3tar.Infof(ctx, "sharding tar into %d parts", len(archiveList))
+					} else if overLimits {
+						for _, list := range s3tar.SplitByLimits(objectList, limits) {
+							for _, archive := range s3tar.BreakUpList(list, sizeLimit) {
+								if len(archive) > 0 {
+									archiveList = append(archiveList, archive)
+								}
+							}
+						}
+						s3tar.Infof(ctx, "breaking up tar into %d parts within --max-objects and --max-bytes", len(archiveList))
+					} else {
+						archiveList = s3tar.BreakUpList(objectList, sizeLimit)
+						s3tar.Infof(ctx, "breaking up tar into %d parts", len(archiveList))
+					}
+					padWidth := getPadWidth(len(archiveList))
+					jobs := make([]s3tar.ArchiveJob, 0, len(archiveList))
+					for i, archive := range archiveList {
+						if len(archive) == 0 {
+							// a shard can be empty with few objects
+							continue
+						}
+						fn := fmt.Sprintf("%s.%0*d.tar", archiveFile[:len(archiveFile)-4], padWidth, i)
+						jobOpts := s3opts.Copy()
+						jobOpts.DstBucket, jobOpts.DstKey = s3tar.ExtractBucketAndPath(fn)
+						jobOpts.DstPrefix = s3tar.KeyDir(jobOpts.DstKey)
+						jobs = append(jobs, s3tar.ArchiveJob{ObjectList: archive, Options: &jobOpts})
+					}
+					index := masterIndex(svc, archiveFile, masterIndexPath, concatInMemory || tarFormat == "zip")
+					err = s3tar.RunArchiveJobs(s3tar.WithMasterIndex(ctx, index), archiveClient, jobs, s3tar.SchedulerOptions{
+						MaxConcurrentArchives: concurrentArchives,
+						TotalThreads:          threads,
+					},
 						s3tar.WithStorageClass(storageClass),
 						s3tar.WithTarFormat(tarFormat),
 						s3tar.WithKMS(kmsKeyID, sseAlgo))
+					err = closeMasterIndex(ctx, index, err)
+				} else {
+					err = archiveClient.CreateFromList(ctx, objectList, s3opts,
+						s3tar.WithStorageClass(storageClass),
+						s3tar.WithTarFormat(tarFormat),
+						s3tar.WithKMS(kmsKeyID, sseAlgo))
+				}
+				if err == nil && deleteVersions {
+					// the versions are only deleted once every archive is complete
+					err = s3tar.DeleteVersions(ctx, svc, s3opts.SrcBucket, objectList, deleteMarkers)
+				}
+				if err == nil && listCheckpoint > 0 {
+					// the listing is only needed again to resume the archives
+					if err := s3tar.DeleteListCheckpoint(ctx, svc, s3opts.DstBucket, s3opts.DstKey); err != nil {
+						s3tar.Warnf(ctx, "unable to delete the listing checkpoint: %s", err.Error())
+					}
 				}
+				return partialResult(skipped, closeAuditLog(ctx, auditLog, err))
 
 			} else if extract {
 
@@ -387,6 +2043,9 @@ func run(args []string) error {
 					destination = destination + "/"
 					fmt.Printf("appending '/' to destination path\n")
 				}
+				if extractPartSize != 0 && (extractPartSize < 5 || extractPartSize > 5120) {
+					exitError(6, "extract-part-size should be >= 5 and <= 5120")
+				}
 				s3opts := &s3tar.S3TarS3Options{
 					Threads:               threads,
 					DeleteSource:          false,
@@ -394,12 +2053,24 @@ func run(args []string) error {
 					EndpointUrl:           endpointUrl,
 					ExternalToc:           externalToc,
 					PreservePOSIXMetadata: preservePosixMetadata,
+					RestoreMetadata:       restoreMetadata,
+					Resume:                resume,
+					ExtractPartSize:       extractPartSize * 1024 * 1024,
 				}
 				s3opts.SrcBucket, s3opts.SrcKey = s3tar.ExtractBucketAndPath(archiveFile)
-				s3opts.SrcPrefix = filepath.Dir(s3opts.SrcKey)
+				s3opts.SrcPrefix = s3tar.KeyDir(s3opts.SrcKey)
 				s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(destination)
-				s3opts.DstPrefix = filepath.Dir(s3opts.DstKey)
+				s3opts.DstPrefix = s3tar.KeyDir(s3opts.DstKey)
 				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				// stop copying entries on SIGTERM so the progress is written
+				ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				if maxRuntime > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = s3tar.WithMaxRuntime(ctx, maxRuntime)
+					defer cancel()
+				}
+				ctx = pauseOnSignals(ctx, s3tar.NewPauseControl())
 				archiveClient := newArchiveClient(svc)
 				return archiveClient.Extract(ctx, s3opts, s3tar.WithExtractPrefix(prefix))
 			} else if list {
@@ -416,7 +2087,9 @@ func run(args []string) error {
 					log.Fatal(err.Error())
 				}
 				for _, f := range toc {
-					if extended {
+					if extended && f.SHA256 != "" {
+						fmt.Printf("%s,%d,%d,%s,%s\n", f.Filename, f.Start, f.Size, f.Etag, f.SHA256)
+					} else if extended {
 						fmt.Printf("%s,%d,%d,%s\n", f.Filename, f.Start, f.Size, f.Etag)
 					} else {
 						fmt.Printf("%s\n", f.Filename)
@@ -444,6 +2117,11 @@ func run(args []string) error {
 				if err != nil {
 					log.Fatal(err.Error())
 				}
+				if manifestAttributes {
+					if err := s3tar.FetchObjectAttributes(ctx, svc, objectList, threads); err != nil {
+						return err
+					}
+				}
 
 				f, err := os.Create(destination)
 				if err != nil {
@@ -456,11 +2134,41 @@ func run(args []string) error {
 				for _, obj := range objectList {
 					size := strconv.FormatInt(*obj.Size, 10)
 					etag := *obj.ETag
-					err = w.Write([]string{obj.Bucket, *obj.Key, size, etag[1 : len(etag)-1]})
+					record := []string{obj.Bucket, *obj.Key, size, etag[1 : len(etag)-1]}
+					if a := obj.Attributes; a != nil {
+						record = append(record, string(a.StorageClass), strconv.Itoa(int(a.PartsCount)), a.ChecksumCRC32, a.ChecksumSHA256)
+					}
+					err = w.Write(record)
 					if err != nil {
 						return err
 					}
 				}
+			} else if selfTest {
+				bucket, prefix := s3tar.ExtractBucketAndPath(archiveFile)
+				if bucket == "" {
+					exitError(11, "--self-test writes its objects under -f s3://bucket/prefix/\n")
+				}
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				var formats []string
+				if cCtx.IsSet("format") {
+					if tarFormat != "pax" && tarFormat != "gnu" {
+						exitError(11, "--self-test supports --format pax and gnu\n")
+					}
+					formats = []string{tarFormat}
+				}
+				results, err := s3tar.SelfTest(ctx, svc, s3tar.SelfTestOptions{
+					Bucket:  bucket,
+					Prefix:  prefix,
+					Region:  region,
+					Formats: formats,
+					PadSize: padSize * 1024 * 1024,
+					Bsdtar:  bsdtarPath,
+					Threads: threads,
+				})
+				if werr := s3tar.WriteSelfTestReport(os.Stdout, results); err == nil {
+					err = werr
+				}
+				return err
 			} else {
 				exitError(3, "operation not implemented, provide create or extract flag\n")
 			}
@@ -471,6 +2179,252 @@ func run(args []string) error {
 	return app.Run(args)
 }
 
+// serve runs the job server until SIGINT or SIGTERM.
+func serve(ctx context.Context, archiver s3tar.Archiver, addr, grpcAddr string, options s3tar.ServerOptions) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	jobServer := s3tar.NewJobServer(archiver, options)
+	pauseOnSignals(ctx, jobServer.PauseControl())
+	jobServer.Start(ctx)
+	if grpcAddr != "" {
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			return err
+		}
+		gs := grpc.NewServer()
+		s3tarpb.RegisterJobServiceServer(gs, jobServer.GRPCService())
+		go func() {
+			<-ctx.Done()
+			// the WatchJob streams of running jobs don't end by themselves
+			gs.Stop()
+		}()
+		go func() {
+			if err := gs.Serve(lis); err != nil {
+				log.Printf("gRPC server: %s", err.Error())
+			}
+		}()
+		s3tar.Infof(ctx, "gRPC listening on %s", grpcAddr)
+	}
+	srv := &http.Server{Addr: addr, Handler: jobServer.Handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	s3tar.Infof(ctx, "listening on %s", addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// serveMetrics serves the Prometheus metrics in the background.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s3tar.MetricsHandler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("metrics server: %s", err.Error())
+		}
+	}()
+}
+
+// s3ConfigOptions returns the options to load the AWS config with.
+func s3ConfigOptions(region, endpointUrl, awsProfile string, maxAttempts, retryBudget int, httpOptions s3tar.HTTPClientOptions, useFIPSEndpoint bool, appID string, userAgentTags []string) []func(*config.LoadOptions) error {
+	var loadOption config.LoadOptionsFunc
+	if endpointUrl != "" {
+		loadOption = config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{
+					URL:               endpointUrl,
+					HostnameImmutable: true,
+					SigningRegion:     region,
+					Source:            aws.EndpointSourceCustom,
+				}, nil
+			}))
+	} else {
+		loadOption = config.WithRegion(region)
+	}
+
+	retryOption := config.WithRetryer(func() aws.Retryer {
+		return s3tar.NewRetryer(maxAttempts, retryBudget)
+	})
+
+	httpOption := func(o *config.LoadOptions) error {
+		client, err := s3tar.NewHTTPClient(httpOptions)
+		if err != nil {
+			return err
+		}
+		o.HTTPClient = client
+		return nil
+	}
+
+	optFns := []func(*config.LoadOptions) error{
+		loadOption,
+		retryOption,
+		httpOption,
+	}
+	if awsProfile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(awsProfile))
+	}
+	if useFIPSEndpoint {
+		optFns = append(optFns, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if appID != "" {
+		optFns = append(optFns, config.WithAppID(appID))
+	}
+	if len(userAgentTags) > 0 {
+		var apiOptions []func(*smithymiddleware.Stack) error
+		for _, tag := range userAgentTags {
+			key, value, _ := s3tar.ParseUserAgentTag(tag) // checked in Before
+			apiOptions = append(apiOptions, middleware.AddUserAgentKeyValue(key, value))
+		}
+		optFns = append(optFns, config.WithAPIOptions(apiOptions))
+	}
+	return optFns
+}
+
+// masterIndex returns the index of a split run, next to the archives unless
+// path is set. Archives without a TOC aren't indexed.
+func masterIndex(svc *s3.Client, archiveFile, path string, noTOC bool) *s3tar.MasterIndex {
+	if noTOC {
+		return nil
+	}
+	if path == "" {
+		path = strings.TrimSuffix(archiveFile, filepath.Ext(archiveFile)) + ".index.csv"
+	}
+	bucket, key := s3tar.ExtractBucketAndPath(path)
+	return s3tar.NewMasterIndex(svc, bucket, key)
+}
+
+// newAuditLog returns the audit log of a create job, written under the
+// prefix path, and records the parameters of the job. The key has the name
+// of the archive and the start time of the job.
+func newAuditLog(ctx context.Context, svc *s3.Client, path, archiveFile, src, manifest string) *s3tar.AuditLog {
+	if path == "" {
+		return nil
+	}
+	bucket, prefix := s3tar.ExtractBucketAndPath(path)
+	if bucket == "" {
+		exitError(11, "--audit-log must be an s3://bucket/prefix url\n")
+	}
+	now := time.Now().UTC()
+	name := strings.ReplaceAll(filepath.Base(archiveFile), "{group}", "groups")
+	key := strings.TrimPrefix(s3tar.JoinKey(prefix, fmt.Sprintf("%s.%s.audit.jsonl", name, now.Format("20060102T150405Z"))), "/")
+	auditLog := s3tar.NewAuditLog(svc, bucket, key)
+	auditLog.Record(ctx, s3tar.AuditRecord{
+		Time:    now,
+		Event:   s3tar.AuditJob,
+		Archive: archiveFile,
+		Params: map[string]string{
+			"source":   src,
+			"manifest": manifest,
+			"args":     strings.Join(os.Args[1:], " "),
+			"version":  Version,
+		},
+	})
+	return auditLog
+}
+
+// closeAuditLog records the result of the job and writes the audit log.
+// The error of the job is returned first.
+func closeAuditLog(ctx context.Context, auditLog *s3tar.AuditLog, err error) error {
+	if auditLog == nil {
+		return err
+	}
+	if logErr := auditLog.Close(ctx, err); logErr != nil {
+		if err != nil {
+			s3tar.Warnf(ctx, "%s", logErr.Error())
+			return err
+		}
+		return logErr
+	}
+	return err
+}
+
+// closeMasterIndex completes the index once every archive is created.
+func closeMasterIndex(ctx context.Context, index *s3tar.MasterIndex, err error) error {
+	if index == nil {
+		return err
+	}
+	if err != nil {
+		index.Abort(context.WithoutCancel(ctx))
+		return err
+	}
+	return index.Close(ctx)
+}
+
+// clientEncryption returns the client-side encryption of an archive. The KMS
+// client doesn't use the S3 endpoint override.
+func clientEncryption(ctx context.Context, opts []func(*config.LoadOptions) error, kmsKeyID string, recipients []string) (*s3tar.ClientEncryption, error) {
+	enc := &s3tar.ClientEncryption{KMSKeyID: kmsKeyID}
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, err
+		}
+		enc.AgeRecipients = append(enc.AgeRecipients, recipient)
+	}
+	if len(recipients) == 0 {
+		cfg, err := config.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		enc.KMS = kms.NewFromConfig(cfg)
+	}
+	return enc, nil
+}
+
+// archiveSigner returns the signer of the archives, with the KMS key or the
+// PEM private key file. The KMS client doesn't use the S3 endpoint override.
+func archiveSigner(ctx context.Context, opts []func(*config.LoadOptions) error, kmsKeyID, keyFile string) (*s3tar.ArchiveSigner, error) {
+	if kmsKeyID != "" && keyFile != "" {
+		return nil, fmt.Errorf("--sign-kms-key and --sign-key can't be used together")
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := s3tar.ParsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return &s3tar.ArchiveSigner{Key: key}, nil
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &s3tar.ArchiveSigner{KMS: kms.NewFromConfig(cfg), KMSKeyID: kmsKeyID}, nil
+}
+
+// warnStaticCredentials warns when the credentials are a session token that
+// can't be refreshed, e.g. from AWS_SESSION_TOKEN: a run that outlives it
+// stops with a checkpoint.
+func warnStaticCredentials(ctx context.Context, svc *s3.Client) {
+	creds, err := svc.Options().Credentials.Retrieve(ctx)
+	if err != nil || creds.SessionToken == "" || creds.CanExpire {
+		return
+	}
+	s3tar.Warnf(ctx, "the session token of %s can't be refreshed, a run that outlives it stops and can be resumed with --resume. Use a profile with a role_arn, SSO or a credential_process, or the instance profile, for long runs", creds.Source)
+}
+
+// roleCredentials returns the credentials of the config loaded with opts, or
+// of roleARN assumed with them.
+func roleCredentials(ctx context.Context, opts []func(*config.LoadOptions) error, roleARN string) (aws.CredentialsProvider, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if roleARN == "" {
+		return cfg.Credentials, nil
+	}
+	return aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "s3tar"
+	})), nil
+}
+
 func s3Client(ctx context.Context, opts ...func(*config.LoadOptions) error) *s3.Client {
 
 	uaVersion := Version
@@ -478,7 +2432,7 @@ func s3Client(ctx context.Context, opts ...func(*config.LoadOptions) error) *s3.
 		uaVersion = "dev-" + Commit
 	}
 	ua := func(options *s3.Options) {
-		options.APIOptions = append(options.APIOptions, middleware.AddUserAgentKeyValue("s3tar", Version))
+		options.APIOptions = append(options.APIOptions, middleware.AddUserAgentKeyValue("s3tar", uaVersion))
 	}
 
 	cfg, err := config.LoadDefaultConfig(ctx, opts...)
@@ -486,7 +2440,7 @@ func s3Client(ctx context.Context, opts ...func(*config.LoadOptions) error) *s3.
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	return s3.NewFromConfig(cfg, ua)
+	return s3.NewFromConfig(cfg, ua, s3tar.WithJobUserAgent, s3tar.WithRequestMetrics, s3tar.WithDirectoryBuckets, s3tar.WithPause, s3tar.WithTuning, s3tar.WithRequestRate, s3tar.WithCredentialsRefresh)
 
 }
 
@@ -499,15 +2453,24 @@ func parseTagValues(tagSet string) (types.Tagging, error) {
 	return tags, nil
 }
 
-func parseLogLevel(count int) int {
-	verboseCount := count
-	if verboseCount < 0 {
-		verboseCount = 0
+// parseLogLevel returns the log level of -v, -vv or -vvv, unless --quiet or
+// --log-level is set.
+func parseLogLevel(count int, name string, quiet bool) int {
+	if quiet {
+		return s3tar.LogLevelQuiet
+	}
+	if name != "" {
+		level, _ := s3tar.ParseLogLevel(name)
+		return level
 	}
-	if verboseCount > 3 {
-		verboseCount = 3
+	switch {
+	case count <= 0:
+		return s3tar.LogLevelError
+	case count < 3:
+		return s3tar.LogLevelInfo
+	default:
+		return s3tar.LogLevelDebug
 	}
-	return verboseCount
 }
 
 func exitError(code int, format string, v ...any) {
@@ -515,6 +2478,72 @@ func exitError(code int, format string, v ...any) {
 	os.Exit(code)
 }
 
+// tenantFlags are the per-tenant limits of the serve and worker commands.
+func tenantFlags(limits *s3tar.TenantLimits, overrides *cli.StringSlice) []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:        "tenant-max-jobs",
+			Usage:       "number of jobs of a tenant, the destination bucket, running at the same time. 0 for no limit",
+			Destination: &limits.MaxJobs,
+		},
+		&cli.Float64Flag{
+			Name:        "tenant-rps",
+			Usage:       "S3 requests per second of all the jobs of a tenant together, retries included. 0 for no limit",
+			Destination: &limits.RequestsPerSecond,
+		},
+		&cli.IntFlag{
+			Name:        "tenant-prefix-depth",
+			Usage:       "add the first n directories of the destination key to the tenant, e.g. 1 for s3://bucket/customer/",
+			Destination: &limits.PrefixDepth,
+		},
+		&cli.StringSliceFlag{
+			Name:        "tenant-limit",
+			Usage:       "limits of a tenant as tenant=max-jobs,rps, e.g. big-bucket=1,100. Can be repeated",
+			Destination: overrides,
+		},
+	}
+}
+
+// parseTenantLimits validates the tenant flags and adds the --tenant-limit
+// overrides to limits.
+func parseTenantLimits(limits *s3tar.TenantLimits, overrides []string) error {
+	if limits.MaxJobs < 0 || limits.RequestsPerSecond < 0 || limits.PrefixDepth < 0 {
+		return fmt.Errorf("--tenant-max-jobs, --tenant-rps and --tenant-prefix-depth should be >= 0")
+	}
+	for _, o := range overrides {
+		tenant, value, ok := strings.Cut(o, "=")
+		jobs, rps, ok2 := strings.Cut(value, ",")
+		if !ok || !ok2 || tenant == "" {
+			return fmt.Errorf("invalid --tenant-limit %q, expected tenant=max-jobs,rps", o)
+		}
+		maxJobs, err := strconv.Atoi(jobs)
+		if err != nil || maxJobs < 0 {
+			return fmt.Errorf("invalid max jobs in --tenant-limit %q", o)
+		}
+		requests, err := strconv.ParseFloat(rps, 64)
+		if err != nil || requests < 0 {
+			return fmt.Errorf("invalid requests per second in --tenant-limit %q", o)
+		}
+		if limits.Overrides == nil {
+			limits.Overrides = map[string]s3tar.TenantLimits{}
+		}
+		limits.Overrides[tenant] = s3tar.TenantLimits{MaxJobs: maxJobs, RequestsPerSecond: requests}
+	}
+	return nil
+}
+
+// parseDelimiter accepts a single character or the \t escape.
+func parseDelimiter(s string) (rune, error) {
+	if s == "\\t" || s == "tab" {
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 {
+		return 0, fmt.Errorf("delimiter must be a single character")
+	}
+	return r[0], nil
+}
+
 func getPadWidth(length int) int {
 	padWidth := len(strconv.Itoa(length))
 	if padWidth == 1 {
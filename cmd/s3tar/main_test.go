@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+	s3tar "github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar"
 	"os"
 	"testing"
 )
@@ -113,7 +113,7 @@ func mockListAllObjects(ctx context.Context, client *s3.Client, Bucket, Prefix s
 	return []*s3tar.S3Obj{}, 0, nil
 }
 
-func mockLoadCSV(ctx context.Context, svc *s3.Client, fpath string, skipHeader, urlDecode bool) ([]*s3tar.S3Obj, int64, error) {
+func mockLoadManifest(ctx context.Context, svc *s3.Client, fpath string, opts s3tar.ManifestOptions) ([]*s3tar.S3Obj, int64, error) {
 	return []*s3tar.S3Obj{}, 0, nil
 }
 
@@ -127,7 +127,7 @@ func Test_cli(t *testing.T) {
 		name               string
 		archiveInitializer func(*s3.Client) s3tar.Archiver
 		listObjFun         func(context.Context, *s3.Client, string, string, ...func(types.Object) bool) ([]*s3tar.S3Obj, int64, error)
-		listObjManifest    func(context.Context, *s3.Client, string, bool, bool) ([]*s3tar.S3Obj, int64, error)
+		listObjManifest    func(context.Context, *s3.Client, string, s3tar.ManifestOptions) ([]*s3tar.S3Obj, int64, error)
 		args               args
 		wantErr            bool
 	}{
@@ -135,7 +135,7 @@ func Test_cli(t *testing.T) {
 			name:               "create-simple-small",
 			archiveInitializer: newMockArchive,
 			listObjFun:         mockListAllObjects,
-			listObjManifest:    mockLoadCSV,
+			listObjManifest:    mockLoadManifest,
 			args: args{
 				[]string{firstArgs,
 					"--region", testRegion,
@@ -149,7 +149,7 @@ func Test_cli(t *testing.T) {
 			name:               "create-with-manifest",
 			archiveInitializer: newMockArchiveManifest,
 			listObjFun:         mockListAllObjects,
-			listObjManifest:    mockLoadCSV,
+			listObjManifest:    mockLoadManifest,
 			args: args{
 				[]string{firstArgs,
 					"--region", testRegion,
@@ -159,16 +159,29 @@ func Test_cli(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:               "estimate",
+			archiveInitializer: newMockArchive,
+			listObjFun:         mockListAllObjects,
+			listObjManifest:    mockLoadManifest,
+			args: args{
+				[]string{firstArgs,
+					"--region", testRegion,
+					"estimate", srcPath,
+				},
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			newArchiveClient = tt.archiveInitializer
 			listAllObjects = tt.listObjFun
-			loadCSV = tt.listObjManifest
+			loadManifest = tt.listObjManifest
 			defer func() {
 				newArchiveClient = s3tar.NewArchiveClient
 				listAllObjects = s3tar.ListAllObjects
-				loadCSV = s3tar.LoadCSV
+				loadManifest = s3tar.LoadManifest
 			}()
 			if err := run(tt.args.args); (err != nil) != tt.wantErr {
 				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
@@ -0,0 +1,40 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	s3tar "github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar"
+)
+
+// pauseOnSignals pauses p on SIGUSR1 and resumes it on SIGUSR2 until ctx is
+// done, and returns a context whose jobs are paused by p.
+func pauseOnSignals(ctx context.Context, p *s3tar.PauseControl) context.Context {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case s := <-sig:
+				if s == syscall.SIGUSR1 {
+					p.Pause()
+					s3tar.Infof(ctx, "paused, the requests in flight finish. send SIGUSR2 to resume")
+				} else {
+					p.Resume()
+					s3tar.Infof(ctx, "resumed")
+				}
+			}
+		}
+	}()
+	return s3tar.WithPauseControl(ctx, p)
+}
@@ -0,0 +1,18 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"context"
+
+	s3tar "github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar"
+)
+
+// pauseOnSignals returns a context whose jobs are paused by p. Windows has
+// no SIGUSR1 and SIGUSR2, the jobs can only be paused in server mode.
+func pauseOnSignals(ctx context.Context, p *s3tar.PauseControl) context.Context {
+	return s3tar.WithPauseControl(ctx, p)
+}
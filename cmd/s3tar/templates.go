@@ -0,0 +1,86 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// jobTemplate presets the flags of a common workflow. The flags given on the
+// command line override the ones of the template.
+type jobTemplate struct {
+	description string
+	// flags are the names of the flags and their values
+	flags [][2]string
+}
+
+var jobTemplates = map[string]jobTemplate{
+	// s3://bucket/logs/2024/01/15/app.log goes into the archive of its day,
+	// e.g. -f s3://bucket/archives/{group}.tar writes archives/2024/01/15.tar
+	"daily-logs": {
+		description: "one archive per day of the date in the keys, YYYY-MM-DD or YYYY/MM/DD, in GLACIER_IR with SHA-256 digests in the TOC",
+		flags: [][2]string{
+			{"group-by-regex", `(\d{4}[-/]\d{2}[-/]\d{2})`},
+			{"storage-class", "GLACIER_IR"},
+			{"sha256", "true"},
+			{"check-keys", "skip"},
+			{"concurrent-archives", "4"},
+		},
+	},
+	// small objects cost 40KB of metadata each in GLACIER, compacted they
+	// are read back with a ranged restore of the archive
+	"glacier-compaction": {
+		description: "archives of up to 100GiB in GLACIER with SHA-256 digests in the TOC, the sizes verified and the duplicate names renamed",
+		flags: [][2]string{
+			{"storage-class", "GLACIER"},
+			{"size-limit", "107374182400"},
+			{"sha256", "true"},
+			{"verify-sizes", "true"},
+			{"on-conflict", "suffix"},
+			{"concurrent-archives", "4"},
+		},
+	},
+}
+
+// templateNames returns the names of the templates, sorted.
+func templateNames() []string {
+	names := make([]string, 0, len(jobTemplates))
+	for name := range jobTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templateUsage describes the templates for --help.
+func templateUsage() string {
+	var b strings.Builder
+	b.WriteString("preset the flags of a common workflow, the flags given override the template:")
+	for _, name := range templateNames() {
+		fmt.Fprintf(&b, "\n\t%s: %s", name, jobTemplates[name].description)
+	}
+	return b.String()
+}
+
+// applyTemplate sets the flags of the template name that weren't given on
+// the command line.
+func applyTemplate(cCtx *cli.Context, name string) error {
+	t, ok := jobTemplates[name]
+	if !ok {
+		return fmt.Errorf("unknown template %q, use %s", name, strings.Join(templateNames(), ", "))
+	}
+	for _, f := range t.flags {
+		if cCtx.IsSet(f[0]) {
+			continue
+		}
+		if err := cCtx.Set(f[0], f[1]); err != nil {
+			return fmt.Errorf("template %s: --%s %s: %w", name, f[0], f[1], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// ACLNone leaves the ACL out of the uploads, for buckets with the ACLs
+// disabled that reject any other than bucket-owner-full-control.
+const ACLNone = "none"
+
+// ParseCannedACL returns the canned ACL named name, or no ACL for none.
+func ParseCannedACL(name string) (types.ObjectCannedACL, error) {
+	if name == ACLNone {
+		return "", nil
+	}
+	var names []string
+	for _, acl := range types.ObjectCannedACL("").Values() {
+		if string(acl) == name {
+			return acl, nil
+		}
+		names = append(names, string(acl))
+	}
+	return "", fmt.Errorf("unknown canned ACL %q, use %s or %s", name, strings.Join(names, ", "), ACLNone)
+}
+
+// WithCannedACL replaces the bucket-owner-full-control ACL s3tar sets on the
+// archives and the other objects it writes with acl, "" for no ACL. The
+// directory buckets are left alone, they don't support ACLs. Use it when
+// creating the client:
+//
+//	svc := s3.NewFromConfig(cfg, s3tar.WithCannedACL(types.ObjectCannedACLPublicRead))
+func WithCannedACL(acl types.ObjectCannedACL) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3TarCannedACL",
+				func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					switch input := in.Parameters.(type) {
+					case *s3.CreateMultipartUploadInput:
+						if !isDirectoryBucket(*input.Bucket) {
+							input.ACL = acl
+						}
+					case *s3.PutObjectInput:
+						if !isDirectoryBucket(*input.Bucket) {
+							input.ACL = acl
+						}
+					case *s3.CopyObjectInput:
+						if !isDirectoryBucket(*input.Bucket) {
+							input.ACL = acl
+						}
+					}
+					return next.HandleInitialize(ctx, in)
+				}), middleware.Before)
+		})
+	}
+}
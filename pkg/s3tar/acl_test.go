@@ -0,0 +1,68 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// aclHTTPClient records the x-amz-acl header of every request.
+type aclHTTPClient struct {
+	acls *[]string
+}
+
+func (c aclHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	*c.acls = append(*c.acls, r.Header.Get("x-amz-acl"))
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    r,
+	}, nil
+}
+
+func TestCannedACL(t *testing.T) {
+	if acl, err := ParseCannedACL("bucket-owner-read"); err != nil || acl != types.ObjectCannedACLBucketOwnerRead {
+		t.Errorf("got %q, %v", acl, err)
+	}
+	if acl, err := ParseCannedACL(ACLNone); err != nil || acl != "" {
+		t.Errorf("got %q, %v", acl, err)
+	}
+	if _, err := ParseCannedACL("owner-only"); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	for _, acl := range []types.ObjectCannedACL{types.ObjectCannedACLBucketOwnerRead, ""} {
+		var acls []string
+		svc := s3.New(s3.Options{
+			Region:       "us-east-1",
+			Credentials:  aws.AnonymousCredentials{},
+			HTTPClient:   aclHTTPClient{acls: &acls},
+			Retryer:      aws.NopRetryer{},
+			UsePathStyle: true,
+		}, WithCannedACL(acl))
+		svc.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String("dst-bucket"),
+			Key:    aws.String("archive.tar"),
+			Body:   strings.NewReader("data"),
+			ACL:    types.ObjectCannedACLBucketOwnerFullControl,
+		})
+		svc.CreateMultipartUpload(context.Background(), &s3.CreateMultipartUploadInput{
+			Bucket: aws.String("dst-bucket"),
+			Key:    aws.String("archive.tar"),
+			ACL:    types.ObjectCannedACLBucketOwnerFullControl,
+		})
+		if len(acls) != 2 || acls[0] != string(acl) || acls[1] != string(acl) {
+			t.Errorf("WithCannedACL(%q): got %q", acl, acls)
+		}
+	}
+}
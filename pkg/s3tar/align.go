@@ -0,0 +1,110 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxAlign is the largest alignment, every entry can be preceded by up to
+// that many bytes of padding.
+const maxAlign = 64 * 1024 * 1024
+
+// ParseAlign parses an alignment like 512, 4096, 4K or 1M. It must be a
+// multiple of the 512 bytes tar block.
+func ParseAlign(s string) (int64, error) {
+	mult := int64(1)
+	num := strings.TrimSpace(s)
+	switch {
+	case strings.HasSuffix(strings.ToUpper(num), "K"):
+		mult, num = 1024, num[:len(num)-1]
+	case strings.HasSuffix(strings.ToUpper(num), "M"):
+		mult, num = 1024*1024, num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid alignment %q", s)
+	}
+	align := n * mult
+	if align%blockSize != 0 || align > maxAlign {
+		return 0, fmt.Errorf("alignment %q must be a multiple of %d up to %dM", s, blockSize, maxAlign/1024/1024)
+	}
+	return align, nil
+}
+
+// alignEntries sets the padding of the tar headers of entries so the data
+// of every entry starts at a multiple of align in the archive. The padding
+// is a PAX comment record, tar readers skip it. The offsets of the entries
+// are in the TOC before them, they are computed until the size of the TOC
+// doesn't change.
+func (c jobConfig) alignEntries(entries []*S3Obj, align int64) error {
+	for _, o := range entries {
+		o.alignPad = 0
+	}
+	base := int64(-1)
+	for {
+		headers, err := c.entryHeaders(entries)
+		if err != nil {
+			return err
+		}
+		size, err := c.tocSize(headers, entries)
+		if err != nil {
+			return err
+		}
+		if size == base {
+			return nil
+		}
+		base = size
+		pos := base + tarHeaderSize(c.format)
+		pos += findPadding(pos)
+		for i, o := range entries {
+			start := pos + *headers[i].Size - o.alignPad
+			o.alignPad = (align - start%align) % align
+			pos = start + o.alignPad + *o.Size
+		}
+	}
+}
+
+// alignHeader rewrites the header of hdr written at headerStart in buff with
+// a PAX comment record making it extra bytes larger. The PAX extended
+// header is rounded up to the 512 bytes block, the record fills it up to
+// the block extra bytes after its end.
+func alignHeader(buff *bytes.Buffer, headerStart int, hdr *tar.Header, extra int64) error {
+	data := buff.Bytes()[headerStart:]
+	want := int64(len(data)) + extra
+	if hdr.Format != tar.FormatPAX || data[156] != tar.TypeXHeader {
+		return fmt.Errorf("%s: only PAX headers can be aligned", hdr.Name)
+	}
+	paxSize, err := strconv.ParseInt(strings.Trim(string(data[124:136]), " \x00"), 8, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", hdr.Name, err)
+	}
+	// the xattrs of the entry are kept
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = map[string]string{}
+	}
+	hdr.PAXRecords["comment"] = alignmentRecord(findPadding(paxSize) + extra)
+	buff.Truncate(headerStart)
+	tw := tar.NewWriter(buff)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("%s: %w", hdr.Name, err)
+	}
+	// the entry has no data here, like headerData
+	tw.Flush()
+	if got := int64(buff.Len() - headerStart); got != want {
+		return fmt.Errorf("%s: aligned header is %d bytes, expected %d", hdr.Name, got, want)
+	}
+	return nil
+}
+
+// alignmentRecord returns the value of a PAX comment record of n bytes.
+func alignmentRecord(n int64) string {
+	// a record is "<length> comment=<value>\n", its length included
+	digits := int64(len(strconv.FormatInt(n, 10)))
+	return strings.Repeat("0", int(n-digits)-len(" comment=\n"))
+}
@@ -0,0 +1,92 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestParseAlign(t *testing.T) {
+	for s, want := range map[string]int64{"512": 512, "4096": 4096, "4K": 4096, "1M": 1024 * 1024, "1m": 1024 * 1024} {
+		if got, err := ParseAlign(s); err != nil || got != want {
+			t.Errorf("ParseAlign(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "0", "100", "-512", "1G", "128M", "4KB"} {
+		if _, err := ParseAlign(s); err == nil {
+			t.Errorf("ParseAlign(%q) should fail", s)
+		}
+	}
+}
+
+func TestAlignEntries(t *testing.T) {
+	contents := map[string][]byte{
+		"a.txt":     []byte("hello"),
+		"dir/b.bin": bytes.Repeat([]byte("b"), 5000),
+		"c.txt":     bytes.Repeat([]byte("c"), 4096),
+		"empty.txt": {},
+	}
+	keys := []string{"a.txt", "dir/b.bin", "c.txt", "empty.txt"}
+	for _, align := range []int64{512, 4096, 64 * 1024} {
+		var entries []*S3Obj
+		for _, key := range keys {
+			entries = append(entries, NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(int64(len(contents[key]))), WithETag("etag")))
+		}
+		if err := defaultJobConfig.alignEntries(entries, align); err != nil {
+			t.Fatal(err)
+		}
+
+		tocObj, _, err := buildToc(context.TODO(), entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := testFirstPart(t, defaultJobConfig, tocObj.Data)
+		for i, o := range entries {
+			var prev *S3Obj
+			if i > 0 {
+				prev = entries[i-1]
+			}
+			archive = append(archive, testHeaderData(t, defaultJobConfig, o, prev)...)
+			archive = append(archive, contents[*o.Key]...)
+		}
+		archive = append(archive, make([]byte, defaultJobConfig.lastBlockSize(int64(len(archive))))...)
+
+		offsets, err := ComputeOffsets(entries, &S3TarS3Options{Align: align})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range offsets {
+			if e.Start%align != 0 {
+				t.Errorf("align %d: %s starts at %d", align, e.Key, e.Start)
+			}
+			if got := archive[e.Start : e.Start+e.Size]; !bytes.Equal(got, contents[e.Key]) {
+				t.Errorf("align %d: %s data = %q", align, e.Key, got)
+			}
+		}
+		// the padding is skipped by tar readers
+		tr := tar.NewReader(bytes.NewReader(archive))
+		var names []string
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("align %d: %s", align, err)
+			}
+			data, _ := io.ReadAll(tr)
+			if hdr.Name != "toc.csv" && !bytes.Equal(data, contents[hdr.Name]) {
+				t.Errorf("align %d: %s read %d bytes", align, hdr.Name, len(data))
+			}
+			names = append(names, hdr.Name)
+		}
+		if len(names) != len(keys)+1 {
+			t.Errorf("align %d: read %v", align, names)
+		}
+	}
+}
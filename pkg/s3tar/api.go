@@ -34,7 +34,15 @@ func (a *ArchiveClient) Create(ctx context.Context, options *S3TarS3Options, opt
 	if err != nil {
 		return err
 	}
-	return ServerSideTar(ctx, a.client, opts)
+	if !opts.SkipPreflight {
+		if err := Preflight(ctx, a.client, opts); err != nil {
+			return err
+		}
+	}
+	done := startJob()
+	err = ServerSideTar(ctx, a.client, opts)
+	done(err)
+	return err
 
 }
 
@@ -45,7 +53,34 @@ func (a *ArchiveClient) CreateFromList(ctx context.Context, objectList []*S3Obj,
 		return err
 	}
 
-	return createFromList(ctx, a.client, objectList, opts)
+	if !opts.SkipPreflight {
+		var sample *S3Obj
+		if len(objectList) > 0 {
+			sample = objectList[0]
+		}
+		if err := preflight(ctx, a.client, opts, sample, nil); err != nil {
+			return err
+		}
+	}
+	done := startJob()
+	err = createFromList(ctx, a.client, objectList, opts)
+	done(err)
+	return err
+}
+
+// CreateFromListWithBackend creates an archive of objectList like
+// CreateFromList, with the objects and the archive in backend, e.g. a
+// MemoryBackend. There are no pre-flight checks, they are specific to
+// Amazon S3.
+func CreateFromListWithBackend(ctx context.Context, backend Backend, objectList []*S3Obj, options *S3TarS3Options, optFns ...func(*S3TarS3Options)) error {
+	opts, err := (&ArchiveClient{}).checkArgs(options, optFns)
+	if err != nil {
+		return err
+	}
+	done := startJob()
+	err = createFromList(ctx, backend, objectList, opts)
+	done(err)
+	return err
 }
 
 func (a *ArchiveClient) checkArgs(options *S3TarS3Options, optFns []func(s3Options *S3TarS3Options)) (*S3TarS3Options, error) {
@@ -59,7 +94,20 @@ func (a *ArchiveClient) checkArgs(options *S3TarS3Options, optFns []func(s3Optio
 	for _, fn := range optFns {
 		fn(&opts)
 	}
+	if opts.formatErr != nil {
+		return nil, opts.formatErr
+	}
 
+	// the KMS key is set by WithKMS
+	if opts.BucketKeyEnabled && (opts.KMSKeyID == "" || opts.SSEAlgo != types.ServerSideEncryptionAwsKms) {
+		return nil, fmt.Errorf("BucketKeyEnabled requires a KMS key with aws:kms, DSSE-KMS has no bucket keys")
+	}
+	if err := checkDirectoryBuckets(&opts); err != nil {
+		return nil, err
+	}
+	if err := checkOutposts(&opts); err != nil {
+		return nil, err
+	}
 	if err := validateStorageClass(&opts); err != nil {
 		return nil, err
 	}
@@ -79,7 +127,10 @@ func (a *ArchiveClient) Extract(ctx context.Context, options *S3TarS3Options, op
 		fn(&opts)
 	}
 
-	return Extract(ctx, a.client, opts.extractPrefix, &opts)
+	done := startJob()
+	err := Extract(ctx, a.client, opts.extractPrefix, &opts)
+	done(err)
+	return err
 }
 
 func (a *ArchiveClient) List(ctx context.Context, archiveS3Url string, options *S3TarS3Options, optFns ...func(options *S3TarS3Options)) (TOC, error) {
@@ -134,12 +185,25 @@ func WithTarFormat(format string) func(options *S3TarS3Options) {
 			opts.tarFormat = tar.FormatPAX
 		case "gnu":
 			opts.tarFormat = tar.FormatGNU
+		case "ustar":
+			opts.tarFormat = tar.FormatUSTAR
+		case "zip":
+			opts.zipFormat = true
 		default:
-			Fatalf(context.TODO(), "tar format not supported")
+			// reported by the calls taking the options
+			opts.formatErr = fmt.Errorf("tar format %q not supported", format)
 		}
 	}
 }
 
+// checkTarFormat returns an error when format isn't one WithTarFormat
+// supports.
+func checkTarFormat(format string) error {
+	var opts S3TarS3Options
+	WithTarFormat(format)(&opts)
+	return opts.formatErr
+}
+
 func WithKMS(kmsKeyID, sseAlgo string) func(options *S3TarS3Options) {
 	return func(opts *S3TarS3Options) {
 		if kmsKeyID == "" {
@@ -169,6 +233,47 @@ func checkCreateArgs(opts *S3TarS3Options) error {
 	if opts.Threads == 0 {
 		opts.Threads = 100
 	}
+	if opts.NoncurrentVersions && opts.SrcManifest != "" {
+		return fmt.Errorf("NoncurrentVersions lists a bucket, it can't be used with a manifest")
+	}
+	if opts.AllVersions && (opts.SrcManifest != "" || opts.NoncurrentVersions) {
+		return fmt.Errorf("AllVersions lists a bucket, it can't be used with a manifest or NoncurrentVersions")
+	}
+	if opts.CheckKeys != "" && opts.CheckKeys != CheckKeysError && opts.CheckKeys != CheckKeysSkip {
+		return fmt.Errorf("CheckKeys must be %s or %s", CheckKeysError, CheckKeysSkip)
+	}
+	for k := range opts.ArchiveMeta {
+		if k == "" {
+			return fmt.Errorf("the keys of ArchiveMeta can't be empty")
+		}
+	}
+	switch opts.OnConflict {
+	case "", ConflictSuffix, ConflictFail, ConflictKeepFirst:
+	default:
+		return fmt.Errorf("OnConflict must be %s, %s or %s", ConflictSuffix, ConflictFail, ConflictKeepFirst)
+	}
+	if opts.Align > 0 && (opts.Align%blockSize != 0 || opts.Align > maxAlign) {
+		return fmt.Errorf("Align must be a multiple of %d up to %d", blockSize, maxAlign)
+	}
+	switch opts.EOFPadding {
+	case "", EOFPaddingCompat, EOFPaddingPOSIX:
+	default:
+		return fmt.Errorf("EOFPadding must be %s or %s", EOFPaddingCompat, EOFPaddingPOSIX)
+	}
+	if opts.TrustListing && (opts.PreservePOSIXMetadata || opts.MetadataSidecars) {
+		return fmt.Errorf("TrustListing can't be used with PreservePOSIXMetadata or MetadataSidecars, they read the metadata of every object with a HeadObject")
+	}
+	if opts.Align > 0 && opts.NoEmbeddedTOC {
+		return fmt.Errorf("Align can't be used with NoEmbeddedTOC, the entries are aligned after the TOC")
+	}
+	if opts.Presigned != nil && (opts.PresignExpiry <= 0 || opts.PresignExpiry > MaxPresignExpiry) {
+		return fmt.Errorf("PresignExpiry must be between 1s and %s", MaxPresignExpiry)
+	}
+	if opts.ArchiveRoot != "" {
+		if err := CheckArchiveRoot(opts.ArchiveRoot); err != nil {
+			return err
+		}
+	}
 	opts.tarFormat = tar.FormatPAX
 	return nil
 }
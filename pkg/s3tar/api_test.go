@@ -359,6 +359,23 @@ func TestArchiveClient_ExtractManifest(t *testing.T) {
 	}
 }
 
+// TestSelfTest_S3 runs the self-test against the test bucket, the archives
+// are read back with archive/tar and bsdtar when it is installed.
+func TestSelfTest_S3(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	ctx = SetLogLevel(ctx, 0)
+	results, err := SelfTest(ctx, client, SelfTestOptions{
+		Bucket: testBucket,
+		Prefix: "self-test/",
+		Region: testRegion,
+	})
+	if err != nil {
+		var buf bytes.Buffer
+		WriteSelfTestReport(&buf, results)
+		t.Fatalf("%s\n%s", err, buf.String())
+	}
+}
+
 func TestArchiveClient_List(t *testing.T) {
 	ctx := SetupLogger(context.Background())
 	ctx = SetLogLevel(ctx, 0)
@@ -0,0 +1,49 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// archiveMetaName is the entry holding S3TarS3Options.ArchiveMeta, listed in
+// the TOC after toc.csv.
+const archiveMetaName = "toc.meta.json"
+
+// ParseArchiveMeta parses a key=value pair of ArchiveMeta, e.g.
+// ticket=OPS-1234. The value can be empty, the key can't.
+func ParseArchiveMeta(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid archive metadata %q, use key=value", s)
+	}
+	return key, value, nil
+}
+
+// archiveMetaEntry returns the toc.meta.json entry of meta in bucket. The
+// keys are sorted, the entry is the same for the same metadata and a resumed
+// run reuses the groups it is in.
+func archiveMetaEntry(bucket string, meta map[string]string) (*S3Obj, error) {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	entry := NewS3ObjOptions(WithBucketAndKey(bucket, archiveMetaName))
+	entry.AddData(append(data, '\n'))
+	return entry, nil
+}
+
+// withArchiveMeta returns objectList after the toc.meta.json entry of meta.
+func withArchiveMeta(objectList []*S3Obj, meta map[string]string) ([]*S3Obj, error) {
+	if len(meta) == 0 || len(objectList) == 0 {
+		return objectList, nil
+	}
+	entry, err := archiveMetaEntry(objectList[0].Bucket, meta)
+	if err != nil {
+		return nil, err
+	}
+	return append([]*S3Obj{entry}, objectList...), nil
+}
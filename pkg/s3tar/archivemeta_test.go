@@ -0,0 +1,75 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestArchiveMeta(t *testing.T) {
+	if _, _, err := ParseArchiveMeta("=value"); err == nil {
+		t.Errorf("an empty key should be refused")
+	}
+	if k, v, err := ParseArchiveMeta("retention=7y=84m"); err != nil || k != "retention" || v != "7y=84m" {
+		t.Errorf("got %q %q %v", k, v, err)
+	}
+
+	ctx := context.Background()
+	m := NewMemoryBackend()
+	var objectList []*S3Obj
+	for _, key := range []string{"a.txt", archiveMetaName} {
+		data := bytes.Repeat([]byte("x"), 1000)
+		m.Put("src-bucket", key, data)
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src-bucket", key), WithSize(int64(len(data)))))
+	}
+	opts := &S3TarS3Options{
+		SrcBucket:   "src-bucket",
+		DstBucket:   "dst-bucket",
+		DstKey:      "archive.tar",
+		Region:      "us-east-1",
+		Threads:     4,
+		OnConflict:  ConflictSuffix,
+		ArchiveMeta: map[string]string{"owner": "data-team", "ticket": "OPS-1234"},
+	}
+	if err := CreateFromListWithBackend(ctx, m, objectList, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(m.Get("dst-bucket", "archive.tar")))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == "toc.csv" {
+			continue
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == archiveMetaName {
+			var meta map[string]string
+			if err := json.NewDecoder(tr).Decode(&meta); err != nil || meta["ticket"] != "OPS-1234" || len(meta) != 2 {
+				t.Errorf("got %v, %v", meta, err)
+			}
+		}
+	}
+	want := []string{archiveMetaName, "a.txt", "toc.meta.1.json"}
+	if len(names) != len(want) {
+		t.Fatalf("got entries %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("got entries %v, want %v", names, want)
+			break
+		}
+	}
+}
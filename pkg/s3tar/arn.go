@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// splitBucketARN splits s3://<arn>/key, where the ARN is an access point,
+// arn:aws:s3:region:account:accesspoint/<name>, a Multi-Region Access Point,
+// arn:aws:s3::account:accesspoint/<alias>.mrap, or an S3 on Outposts access
+// point, arn:aws:s3-outposts:region:account:outpost/<outpost-id>/accesspoint/<name>.
+// The SDK takes the ARN wherever it takes a bucket name.
+func splitBucketARN(s string) (bucket, path string, ok bool) {
+	if !arn.IsARN(s) {
+		return "", "", false
+	}
+	a, err := arn.Parse(s)
+	if err != nil {
+		return "", "", false
+	}
+	var n int
+	segments := strings.SplitN(a.Resource, "/", 5)
+	switch {
+	case a.Service == "s3" && len(segments) >= 2 && segments[0] == "accesspoint":
+		n = 2
+	case a.Service == "s3-outposts" && len(segments) >= 4 && segments[0] == "outpost" && segments[2] == "accesspoint":
+		n = 4
+	default:
+		return "", "", false
+	}
+	path = strings.Join(segments[n:], "/")
+	a.Resource = strings.Join(segments[:n], "/")
+	return a.String(), path, true
+}
+
+// isOutpostsBucket reports whether bucket is an S3 on Outposts access point.
+func isOutpostsBucket(bucket string) bool {
+	a, err := arn.Parse(bucket)
+	return err == nil && a.Service == "s3-outposts"
+}
+
+// copySourceBucket is what CopySource starts with for the objects of bucket.
+// Objects behind an access point are copied with their ARN,
+// arn:aws:s3:region:account:accesspoint/<name>/object/<key>, and
+// arn:aws:s3-outposts:region:account:outpost/<outpost-id>/object/<key> on
+// Outposts.
+func copySourceBucket(bucket string) string {
+	a, err := arn.Parse(bucket)
+	if err != nil {
+		return bucket
+	}
+	segments := strings.SplitN(a.Resource, "/", 3)
+	if len(segments) < 2 {
+		return bucket
+	}
+	switch a.Service {
+	case "s3", "s3-outposts":
+		a.Resource = segments[0] + "/" + segments[1] + "/object"
+		return a.String()
+	}
+	return bucket
+}
+
+// checkOutposts sets the OUTPOSTS storage class of an archive created on
+// Outposts, it is the only one they support.
+func checkOutposts(opts *S3TarS3Options) error {
+	if !isOutpostsBucket(opts.DstBucket) {
+		return nil
+	}
+	switch opts.storageClass {
+	case "", types.StorageClassStandard:
+		opts.storageClass = types.StorageClassOutposts
+	case types.StorageClassOutposts:
+	default:
+		return fmt.Errorf("%s only supports the %s storage class", opts.DstBucket, types.StorageClassOutposts)
+	}
+	return nil
+}
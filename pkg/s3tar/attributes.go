@@ -0,0 +1,139 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// ObjectAttributes is the per-object metadata returned by GetObjectAttributes.
+// Checksums are base64 encoded, as returned by Amazon S3.
+type ObjectAttributes struct {
+	StorageClass   types.StorageClass
+	PartsCount     int32
+	ChecksumCRC32  string
+	ChecksumCRC32C string
+	ChecksumSHA1   string
+	ChecksumSHA256 string
+}
+
+// FullObjectChecksum reports whether the checksums cover the whole object.
+// Objects uploaded in parts have a checksum of the part checksums instead.
+func (a *ObjectAttributes) FullObjectChecksum() bool {
+	return a.PartsCount == 0
+}
+
+// attributesCache avoids asking for the attributes of the same object twice
+// during a job, e.g. when computing the digests and the zip CRCs. Every job
+// has its own in its jobConfig, it is dropped with the job.
+type attributesCache struct {
+	mu sync.Mutex
+	m  map[string]*ObjectAttributes
+}
+
+func newAttributesCache() *attributesCache {
+	return &attributesCache{m: map[string]*ObjectAttributes{}}
+}
+
+// get returns the attributes cached under key. A nil cache, outside a job,
+// caches nothing.
+func (c *attributesCache) get(key string) (*ObjectAttributes, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	attrs, ok := c.m[key]
+	return attrs, ok
+}
+
+func (c *attributesCache) put(key string, attrs *ObjectAttributes) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[key] = attrs
+}
+
+func attributesCacheKey(obj *S3Obj) string {
+	etag := ""
+	if obj.ETag != nil {
+		etag = *obj.ETag
+	}
+	return obj.Bucket + "/" + *obj.Key + "@" + etag
+}
+
+// FetchObjectAttributes gets the attributes of every object in the list with
+// at most threads requests in flight. The attributes are stored in
+// S3Obj.Attributes.
+func FetchObjectAttributes(ctx context.Context, svc *s3.Client, objectList []*S3Obj, threads int) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for _, o := range objectList {
+		o := o
+		if o.Attributes != nil || len(o.Data) > 0 {
+			continue
+		}
+		g.Go(func() error {
+			_, err := getObjectAttributes(ctx, svc, o)
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+func getObjectAttributes(ctx context.Context, svc Backend, obj *S3Obj) (*ObjectAttributes, error) {
+	if obj.Attributes != nil {
+		return obj.Attributes, nil
+	}
+	cache := jobConfigFromContext(ctx).attributes
+	cacheKey := attributesCacheKey(obj)
+	if cached, ok := cache.get(cacheKey); ok {
+		obj.Attributes = cached
+		return cached, nil
+	}
+
+	input := &s3.GetObjectAttributesInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    obj.Key,
+		ObjectAttributes: []types.ObjectAttributes{
+			types.ObjectAttributesChecksum,
+			types.ObjectAttributesObjectParts,
+			types.ObjectAttributesStorageClass,
+		},
+	}
+	if obj.VersionId != "" {
+		input.VersionId = aws.String(obj.VersionId)
+	}
+	api, ok := svc.(attributesBackend)
+	if !ok {
+		return nil, fmt.Errorf("unable to get attributes of s3://%s/%s: not supported by the backend", obj.Bucket, *obj.Key)
+	}
+	output, err := api.GetObjectAttributes(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get attributes of s3://%s/%s: %w", obj.Bucket, *obj.Key, err)
+	}
+	attrs := &ObjectAttributes{StorageClass: output.StorageClass}
+	if output.ObjectParts != nil && output.ObjectParts.TotalPartsCount != nil {
+		attrs.PartsCount = *output.ObjectParts.TotalPartsCount
+	}
+	if c := output.Checksum; c != nil {
+		attrs.ChecksumCRC32 = aws.ToString(c.ChecksumCRC32)
+		attrs.ChecksumCRC32C = aws.ToString(c.ChecksumCRC32C)
+		attrs.ChecksumSHA1 = aws.ToString(c.ChecksumSHA1)
+		attrs.ChecksumSHA256 = aws.ToString(c.ChecksumSHA256)
+	}
+
+	cache.put(cacheKey, attrs)
+	obj.Attributes = attrs
+	return attrs, nil
+}
@@ -0,0 +1,59 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// countingAttributesBackend counts the GetObjectAttributes requests.
+type countingAttributesBackend struct {
+	*MemoryBackend
+	calls int32
+}
+
+func (b *countingAttributesBackend) GetObjectAttributes(ctx context.Context, params *s3.GetObjectAttributesInput, optFns ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	atomic.AddInt32(&b.calls, 1)
+	return &s3.GetObjectAttributesOutput{}, nil
+}
+
+func TestAttributesCache(t *testing.T) {
+	svc := &countingAttributesBackend{MemoryBackend: NewMemoryBackend()}
+	newObj := func() *S3Obj {
+		return NewS3ObjOptions(WithBucketAndKey("bucket", "key"), WithETag("etag"))
+	}
+
+	job := withJobConfig(context.Background(), newJobConfig(&S3TarS3Options{}))
+	for i := 0; i < 2; i++ {
+		if _, err := getObjectAttributes(job, svc, newObj()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if svc.calls != 1 {
+		t.Errorf("%d requests in the same job, want 1", svc.calls)
+	}
+
+	// another job asks again
+	other := withJobConfig(context.Background(), newJobConfig(&S3TarS3Options{}))
+	if _, err := getObjectAttributes(other, svc, newObj()); err != nil {
+		t.Fatal(err)
+	}
+	if svc.calls != 2 {
+		t.Errorf("%d requests, want 2", svc.calls)
+	}
+
+	// nothing is kept outside a job
+	for i := 0; i < 2; i++ {
+		if _, err := getObjectAttributes(context.Background(), svc, newObj()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if svc.calls != 4 {
+		t.Errorf("%d requests, want 4", svc.calls)
+	}
+}
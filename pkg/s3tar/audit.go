@@ -0,0 +1,329 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	contextKeyAuditLog    = contextKey("audit-log")
+	contextKeySkipCounter = contextKey("skip-counter")
+
+	// jsonLinesPartSize is the size of the parts the audit and trace logs are
+	// uploaded with, the objects of a large job aren't held in memory.
+	jsonLinesPartSize = 8 * 1024 * 1024
+)
+
+// The events of the audit log.
+const (
+	// AuditJob records the parameters of the job, it is the first record.
+	AuditJob = "job"
+	// AuditObject records an object that went into Archive.
+	AuditObject = "object"
+	// AuditSkipped records an object left out of the job and the reason.
+	AuditSkipped = "skipped"
+	// AuditRetry records a failed step tried again.
+	AuditRetry = "retry"
+	// AuditArchive records a complete archive, AuditFailed one that failed.
+	AuditArchive = "archive"
+	AuditFailed  = "failed"
+	// AuditResult records the result of the job, it is the last record.
+	AuditResult = "result"
+)
+
+// AuditRecord is a line of the audit log.
+type AuditRecord struct {
+	Time      time.Time         `json:"time"`
+	Event     string            `json:"event"`
+	Archive   string            `json:"archive,omitempty"`
+	Bucket    string            `json:"bucket,omitempty"`
+	Key       string            `json:"key,omitempty"`
+	VersionId string            `json:"versionId,omitempty"`
+	ETag      string            `json:"etag,omitempty"`
+	Size      int64             `json:"size,omitempty"`
+	Objects   int               `json:"objects,omitempty"`
+	Attempt   int               `json:"attempt,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+}
+
+// AuditLog writes a JSON Lines record of a job to s3://bucket/key: its
+// parameters, every object archived, skipped or retried, every archive and
+// the result. The records are uploaded in parts as they are written, a job
+// that crashes leaves its upload to the lifecycle rules of the bucket.
+type AuditLog struct {
+	mu       sync.Mutex
+	w        jsonLines
+	archives int
+	failed   int
+	objects  int
+}
+
+// NewAuditLog returns an audit log written to s3://bucket/key by Close.
+func NewAuditLog(svc *s3.Client, bucket, key string) *AuditLog {
+	return &AuditLog{w: jsonLines{name: "audit log", svc: svc, bucket: bucket, key: key}}
+}
+
+// WithAuditLog returns a context that records the archives created with it
+// in log.
+func WithAuditLog(ctx context.Context, log *AuditLog) context.Context {
+	return context.WithValue(ctx, contextKeyAuditLog, log)
+}
+
+func auditLogFromContext(ctx context.Context) *AuditLog {
+	if l, ok := ctx.Value(contextKeyAuditLog).(*AuditLog); ok {
+		return l
+	}
+	return nil
+}
+
+// SkipCounter counts the objects left out of a job, with or without an audit
+// log, to tell a complete job from one that archived some of its objects.
+// It keeps the keys of the skipped versions, DeleteVersions leaves them
+// alone.
+type SkipCounter struct {
+	n atomic.Int64
+
+	mu       sync.Mutex
+	versions map[string]bool
+	keys     map[string]bool
+}
+
+// WithSkipCounter returns a context that counts the objects skipped by the
+// jobs created with it in c.
+func WithSkipCounter(ctx context.Context, c *SkipCounter) context.Context {
+	return context.WithValue(ctx, contextKeySkipCounter, c)
+}
+
+// Add counts n objects left out of the job by the caller.
+func (c *SkipCounter) Add(n int) {
+	c.n.Add(int64(n))
+}
+
+// Skipped returns the objects left out so far.
+func (c *SkipCounter) Skipped() int64 {
+	return c.n.Load()
+}
+
+// skip counts the skipped object of r and keeps its version.
+func (c *SkipCounter) skip(r AuditRecord) {
+	c.Add(1)
+	if r.VersionId == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.versions == nil {
+		c.versions, c.keys = map[string]bool{}, map[string]bool{}
+	}
+	c.versions[r.Key+"?versionId="+r.VersionId] = true
+	c.keys[r.Key] = true
+}
+
+// skippedVersion reports whether the version of key was skipped.
+func (c *SkipCounter) skippedVersion(key, versionId string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.versions[key+"?versionId="+versionId]
+}
+
+// skippedKey reports whether a version of key was skipped.
+func (c *SkipCounter) skippedKey(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keys[key]
+}
+
+// audit adds r to the audit log of ctx, if there is one.
+func audit(ctx context.Context, r AuditRecord) {
+	if c, ok := ctx.Value(contextKeySkipCounter).(*SkipCounter); ok && r.Event == AuditSkipped {
+		c.skip(r)
+	}
+	if l := auditLogFromContext(ctx); l != nil {
+		l.Record(ctx, r)
+	}
+}
+
+// auditObject is the record of an object of an archive.
+func auditObject(event, archive string, o *S3Obj) AuditRecord {
+	r := AuditRecord{
+		Event:     event,
+		Archive:   archive,
+		Bucket:    o.Bucket,
+		Key:       aws.ToString(o.Key),
+		VersionId: o.VersionId,
+		ETag:      aws.ToString(o.ETag),
+		Size:      aws.ToInt64(o.Size),
+	}
+	if len(o.Data) > 0 {
+		// generated entries, e.g. metadata sidecars, have no source
+		r.Bucket, r.ETag = "", ""
+	}
+	return r
+}
+
+// auditArchive records the objects of an archive once it is complete, or
+// the error it failed with.
+func auditArchive(ctx context.Context, archive string, objectList []*S3Obj, size int64, err error) {
+	l := auditLogFromContext(ctx)
+	if l == nil {
+		return
+	}
+	if err != nil {
+		l.Record(ctx, AuditRecord{Event: AuditFailed, Archive: archive, Objects: len(objectList), Error: err.Error()})
+		return
+	}
+	for _, o := range objectList {
+		l.Record(ctx, auditObject(AuditObject, archive, o))
+	}
+	l.Record(ctx, AuditRecord{Event: AuditArchive, Archive: archive, Objects: len(objectList), Size: size})
+}
+
+// Record appends r to the log, with the current time when r has none. The
+// first error uploading the log is returned by Close, the job isn't
+// stopped by it.
+func (l *AuditLog) Record(ctx context.Context, r AuditRecord) {
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch r.Event {
+	case AuditObject:
+		l.objects++
+	case AuditArchive:
+		l.archives++
+	case AuditFailed:
+		l.failed++
+	}
+	l.w.write(ctx, r)
+}
+
+// Close records the result of the job, jobErr or success, and writes the
+// log. The log is written for failed jobs as well.
+func (l *AuditLog) Close(ctx context.Context, jobErr error) error {
+	ctx = context.WithoutCancel(ctx)
+	l.mu.Lock()
+	result := AuditRecord{Event: AuditResult, Objects: l.objects, Reason: "success"}
+	l.mu.Unlock()
+	if jobErr != nil {
+		result.Reason, result.Error = "failed", jobErr.Error()
+	}
+	l.Record(ctx, result)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.close(ctx); err != nil {
+		return err
+	}
+	Infof(ctx, "audit log s3://%s/%s: %d objects in %d archives, %d failed", l.w.bucket, l.w.key, l.objects, l.archives, l.failed)
+	return nil
+}
+
+// jsonLines writes JSON Lines to s3://bucket/key, uploaded in parts of
+// jsonLinesPartSize as they are written. It isn't safe for concurrent use.
+type jsonLines struct {
+	// name is the name of the file in the errors, e.g. audit log
+	name   string
+	svc    *s3.Client
+	bucket string
+	key    string
+
+	buf      bytes.Buffer
+	uploadId *string
+	parts    []types.CompletedPart
+	err      error
+}
+
+// write appends v as a line. The first error uploading a part is returned
+// by close.
+func (j *jsonLines) write(ctx context.Context, v any) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	j.buf.Write(line)
+	j.buf.WriteByte('\n')
+	if j.buf.Len() >= jsonLinesPartSize && j.err == nil {
+		j.err = j.flush(ctx)
+	}
+}
+
+// flush uploads the buffered lines as the next part.
+func (j *jsonLines) flush(ctx context.Context) error {
+	if j.uploadId == nil {
+		output, err := j.svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:            aws.String(j.bucket),
+			Key:               aws.String(j.key),
+			ACL:               types.ObjectCannedACLBucketOwnerFullControl,
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			ContentType:       aws.String("application/x-ndjson"),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create the %s: %w", j.name, err)
+		}
+		j.uploadId = output.UploadId
+	}
+	partNum := int32(len(j.parts) + 1)
+	output, err := uploadPart(ctx, j.svc, *j.uploadId, j.bucket, j.key, j.buf.Bytes(), &partNum)
+	if err != nil {
+		return fmt.Errorf("unable to upload part %d of the %s: %w", partNum, j.name, err)
+	}
+	j.parts = append(j.parts, types.CompletedPart{
+		ETag:           output.ETag,
+		PartNumber:     aws.Int32(partNum),
+		ChecksumSHA256: output.ChecksumSHA256,
+	})
+	j.buf.Reset()
+	return nil
+}
+
+// close writes the lines with a PutObject, or uploads the last part and
+// completes the upload when parts were uploaded.
+func (j *jsonLines) close(ctx context.Context) error {
+	if j.err != nil {
+		return j.err
+	}
+	if j.uploadId == nil {
+		_, err := j.svc.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:            aws.String(j.bucket),
+			Key:               aws.String(j.key),
+			ACL:               types.ObjectCannedACLBucketOwnerFullControl,
+			Body:              bytes.NewReader(j.buf.Bytes()),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			ContentType:       aws.String("application/x-ndjson"),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write the %s: %w", j.name, err)
+		}
+		return nil
+	}
+	if j.buf.Len() > 0 {
+		if err := j.flush(ctx); err != nil {
+			return err
+		}
+	}
+	_, err := j.svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(j.bucket),
+		Key:             aws.String(j.key),
+		UploadId:        j.uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: j.parts},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to complete the %s: %w", j.name, err)
+	}
+	return nil
+}
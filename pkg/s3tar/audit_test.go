@@ -0,0 +1,120 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// auditHTTPClient keeps the body of the last PutObject.
+type auditHTTPClient struct {
+	body *bytes.Buffer
+}
+
+func (c auditHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	if r.Method == http.MethodPut {
+		c.body.Reset()
+		io.Copy(c.body, r.Body)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    r,
+	}, nil
+}
+
+func TestAuditLog(t *testing.T) {
+	var body bytes.Buffer
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   auditHTTPClient{body: &body},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	log := NewAuditLog(svc, "audit-bucket", "logs/archive.tar.audit.jsonl")
+	ctx := WithAuditLog(context.Background(), log)
+	log.Record(ctx, AuditRecord{Event: AuditJob, Params: map[string]string{"source": "s3://src-bucket/prefix/"}})
+
+	objectList := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("src-bucket", "prefix/a.txt"), WithSize(10), WithETag("etag-a")),
+		NewS3ObjOptions(WithBucketAndKey("src-bucket", "prefix/../b.txt"), WithSize(20)),
+	}
+	valid, err := checkKeys(ctx, objectList, CheckKeysSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditArchive(ctx, "s3://dst-bucket/archive.tar", valid, 1024, nil)
+	auditArchive(ctx, "s3://dst-bucket/archive.1.tar", valid, 0, errors.New("access denied"))
+	if err := log.Close(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []AuditRecord
+	s := bufio.NewScanner(&body)
+	for s.Scan() {
+		// the body is sent aws-chunked with its checksum
+		if !bytes.HasPrefix(s.Bytes(), []byte("{")) {
+			continue
+		}
+		var r AuditRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("%s: %s", s.Text(), err)
+		}
+		records = append(records, r)
+	}
+	var events []string
+	for _, r := range records {
+		events = append(events, r.Event)
+	}
+	want := "job,skipped,object,archive,failed,result"
+	if got := strings.Join(events, ","); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if r := records[1]; r.Key != "prefix/../b.txt" || !strings.Contains(r.Reason, "invalid key") {
+		t.Errorf("unexpected skipped record %+v", r)
+	}
+	if r := records[2]; r.Archive != "s3://dst-bucket/archive.tar" || r.Key != "prefix/a.txt" || r.ETag != "etag-a" || r.Size != 10 {
+		t.Errorf("unexpected object record %+v", r)
+	}
+	if r := records[4]; r.Error != "access denied" {
+		t.Errorf("unexpected failed record %+v", r)
+	}
+	if r := records[5]; r.Reason != "success" || r.Objects != 1 || r.Time.IsZero() {
+		t.Errorf("unexpected result record %+v", r)
+	}
+}
+
+func TestSkipCounter(t *testing.T) {
+	skipped := &SkipCounter{}
+	ctx := WithSkipCounter(context.Background(), skipped)
+	objectList := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("bucket", "a.txt")),
+		NewS3ObjOptions(WithBucketAndKey("bucket", "../b.txt")),
+		NewS3ObjOptions(WithBucketAndKey("other", "a.txt")),
+	}
+	objectList, err := checkKeys(ctx, objectList, CheckKeysSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveConflicts(ctx, objectList, ConflictKeepFirst); err != nil {
+		t.Fatal(err)
+	}
+	audit(ctx, AuditRecord{Event: AuditObject})
+	if skipped.Skipped() != 2 {
+		t.Errorf("got %d objects skipped", skipped.Skipped())
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const contextKeyTuner = contextKey("tuner")
+
+// ConcurrencyTuner limits the requests that move data in flight and adapts
+// the limit to the bucket: it grows by one every round of healthy
+// UploadPartCopy requests and is halved when S3 answers SlowDown. The
+// latency is compared per MB copied, so parts of different sizes don't
+// look like a busier bucket. The goroutines above the limit wait for a free
+// slot.
+type ConcurrencyTuner struct {
+	mu       sync.Mutex
+	min, max int
+	limit    int
+	inFlight int
+	wake     chan struct{}
+	// healthy requests since the limit last changed
+	healthy int
+	// moving average and lowest average of the UploadPartCopy latency per
+	// MB copied
+	latency  time.Duration
+	baseline time.Duration
+	// the requests sent before a decrease don't decrease it again
+	decreased time.Time
+}
+
+// NewConcurrencyTuner returns a ConcurrencyTuner adapting the limit between
+// min and max, starting at a quarter of max.
+func NewConcurrencyTuner(min, max int) *ConcurrencyTuner {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	limit := max / 4
+	if limit < min {
+		limit = min
+	}
+	return &ConcurrencyTuner{min: min, max: max, limit: limit, wake: make(chan struct{})}
+}
+
+// Limit returns the current number of requests allowed in flight.
+func (t *ConcurrencyTuner) Limit() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// acquire waits for a free slot.
+func (t *ConcurrencyTuner) acquire(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		if t.inFlight < t.limit {
+			t.inFlight++
+			t.mu.Unlock()
+			return nil
+		}
+		wake := t.wake
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// release frees the slot of a request sent at sent, adapting the limit to
+// its outcome. latency is the latency per MB, only set for the requests it
+// is measured on. It returns the limit before and after.
+func (t *ConcurrencyTuner) release(sent time.Time, latency time.Duration, throttled bool) (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	before := t.limit
+	switch {
+	case throttled:
+		if sent.After(t.decreased) {
+			t.limit = t.limit / 2
+			if t.limit < t.min {
+				t.limit = t.min
+			}
+			t.decreased = time.Now()
+			t.healthy = 0
+		}
+	case latency > 0:
+		if t.latency == 0 {
+			t.latency = latency
+		} else {
+			t.latency = (t.latency*7 + latency) / 8
+		}
+		if t.baseline == 0 || t.latency < t.baseline {
+			t.baseline = t.latency
+		}
+		// requests slower than twice the best average mean the bucket is
+		// already busy
+		if t.latency > 2*t.baseline {
+			t.healthy = 0
+			break
+		}
+		t.healthy++
+		if t.healthy >= t.limit && t.limit < t.max {
+			t.limit++
+			t.healthy = 0
+		}
+	}
+	close(t.wake)
+	t.wake = make(chan struct{})
+	return before, t.limit
+}
+
+// copiedSize returns the number of bytes copied by an UploadPartCopy
+// request, 0 when it copies a whole object of unknown size.
+func copiedSize(request interface{}) int64 {
+	req, ok := request.(*smithyhttp.Request)
+	if !ok {
+		return 0
+	}
+	return copyRangeSize(req.Header.Get("X-Amz-Copy-Source-Range"))
+}
+
+// perMB returns latency per MB of a request moving size bytes. The requests
+// under the minimum part size count as a whole part, their latency is
+// mostly the round trip.
+func perMB(latency time.Duration, size int64) time.Duration {
+	if size < partSizeMin {
+		size = partSizeMin
+	}
+	return time.Duration(float64(latency) * float64(1024*1024) / float64(size))
+}
+
+// WithConcurrencyTuner returns a context whose S3 requests that move data
+// are limited by t. The client must be created with WithTuning.
+func WithConcurrencyTuner(ctx context.Context, t *ConcurrencyTuner) context.Context {
+	return context.WithValue(ctx, contextKeyTuner, t)
+}
+
+// isSlowDown reports whether err is S3 throttling the request.
+// IsThrottled returns whether err is a request throttled by Amazon S3, a
+// SlowDown or 503 error the retries of the SDK didn't get through.
+func IsThrottled(err error) bool {
+	return isSlowDown(err)
+}
+
+func isSlowDown(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "SlowDown" {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusServiceUnavailable
+}
+
+// WithTuning limits the requests that move data with the ConcurrencyTuner
+// of their context. It runs for every attempt, a throttled attempt frees
+// its slot while the SDK waits to retry it. Use it when creating the
+// client:
+//
+//	svc := s3.NewFromConfig(cfg, s3tar.WithTuning)
+func WithTuning(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		if _, ok := stack.Finalize.Get("Retry"); !ok {
+			// pre-signed requests are not sent
+			return nil
+		}
+		return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("S3TarTuning",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				t, ok := ctx.Value(contextKeyTuner).(*ConcurrencyTuner)
+				operation := awsmiddleware.GetOperationName(ctx)
+				if !ok || !pausedOperations[operation] {
+					return next.HandleFinalize(ctx, in)
+				}
+				if err := t.acquire(ctx); err != nil {
+					return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+				}
+				sent := time.Now()
+				out, metadata, err := next.HandleFinalize(ctx, in)
+				var latency time.Duration
+				if size := copiedSize(in.Request); err == nil && operation == "UploadPartCopy" && size > 0 {
+					latency = perMB(time.Since(sent), size)
+				}
+				if before, after := t.release(sent, latency, isSlowDown(err)); before != after {
+					Debugf(ctx, "concurrency %d -> %d", before, after)
+				}
+				return out, metadata, err
+			}), "Retry", middleware.After)
+	})
+}
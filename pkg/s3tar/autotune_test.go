@@ -0,0 +1,125 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestConcurrencyTuner(t *testing.T) {
+	ctx := context.Background()
+	tuner := NewConcurrencyTuner(1, 16)
+	if tuner.Limit() != 4 {
+		t.Fatalf("limit = %d, want 4", tuner.Limit())
+	}
+
+	// a round of healthy requests raises the limit by one
+	for i := 0; i < 4; i++ {
+		tuner.acquire(ctx)
+		tuner.release(time.Now(), 100*time.Millisecond, false)
+	}
+	if tuner.Limit() != 5 {
+		t.Fatalf("limit = %d, want 5", tuner.Limit())
+	}
+
+	// slow requests don't
+	for i := 0; i < 10; i++ {
+		tuner.acquire(ctx)
+		tuner.release(time.Now(), time.Second, false)
+	}
+	if tuner.Limit() != 5 {
+		t.Fatalf("limit = %d, want 5", tuner.Limit())
+	}
+
+	// requests throttled together halve it once
+	sent := time.Now()
+	for i := 0; i < 3; i++ {
+		tuner.acquire(ctx)
+		tuner.release(sent, 0, true)
+	}
+	if tuner.Limit() != 2 {
+		t.Fatalf("limit = %d, want 2", tuner.Limit())
+	}
+	tuner.acquire(ctx)
+	tuner.release(time.Now(), 0, true)
+	if tuner.Limit() != 1 {
+		t.Fatalf("limit = %d, want 1", tuner.Limit())
+	}
+
+	// a request over the limit waits for a free slot
+	tuner.acquire(ctx)
+	canceled, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := tuner.acquire(canceled); err == nil {
+		t.Error("expected the second request to wait")
+	}
+}
+
+func TestPerMB(t *testing.T) {
+	// a part twice as large takes twice as long on the same bucket
+	if a, b := perMB(time.Second, 8*1024*1024), perMB(2*time.Second, 16*1024*1024); a != b || a != time.Second/8 {
+		t.Errorf("perMB = %s and %s, want %s", a, b, time.Second/8)
+	}
+	// the small requests count as a whole part
+	if got := perMB(time.Second, 1); got != time.Second/5 {
+		t.Errorf("perMB = %s, want %s", got, time.Second/5)
+	}
+
+	req := smithyhttp.NewStackRequest().(*smithyhttp.Request)
+	if got := copiedSize(req); got != 0 {
+		t.Errorf("copiedSize = %d, want 0", got)
+	}
+	req.Header.Set("X-Amz-Copy-Source-Range", "bytes=10-5242889")
+	if got := copiedSize(req); got != 5242880 {
+		t.Errorf("copiedSize = %d, want 5242880", got)
+	}
+}
+
+// slowDownHTTPClient answers every request with a SlowDown error.
+type slowDownHTTPClient struct{}
+
+func (slowDownHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	body := `<?xml version="1.0" encoding="UTF-8"?><Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`
+	return &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"Content-Type": []string{"application/xml"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+func TestWithTuning(t *testing.T) {
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   slowDownHTTPClient{},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	}, WithTuning)
+	tuner := NewConcurrencyTuner(1, 32)
+	ctx := WithConcurrencyTuner(SetupLogger(context.Background()), tuner)
+
+	_, err := svc.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key"), Body: strings.NewReader("data")})
+	if !isSlowDown(err) {
+		t.Fatalf("expected SlowDown, got %v", err)
+	}
+	if tuner.Limit() != 4 {
+		t.Errorf("limit = %d, want 4", tuner.Limit())
+	}
+
+	// the requests that don't move data aren't limited
+	svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key")})
+	if tuner.Limit() != 4 {
+		t.Errorf("limit = %d, want 4", tuner.Limit())
+	}
+}
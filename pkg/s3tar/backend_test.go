@@ -0,0 +1,188 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestMemoryBackendMultipart(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBackend()
+	m.MinPartSize = 10
+	m.Put("bucket", "src", []byte("0123456789abcdef"))
+	mpu, _ := m.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String("bucket"), Key: aws.String("dst")})
+	small, _ := m.UploadPart(ctx, &s3.UploadPartInput{UploadId: mpu.UploadId, PartNumber: aws.Int32(1), Body: bytes.NewReader([]byte("tiny"))})
+	copied, err := m.UploadPartCopy(ctx, &s3.UploadPartCopyInput{UploadId: mpu.UploadId, PartNumber: aws.Int32(2), CopySource: aws.String("bucket/src"), CopySourceRange: aws.String("bytes=10-15")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.UploadPartCopy(ctx, &s3.UploadPartCopyInput{UploadId: mpu.UploadId, PartNumber: aws.Int32(3), CopySource: aws.String("bucket/src"), CopySourceRange: aws.String("bytes=10-16")}); err == nil {
+		t.Errorf("expected an invalid range")
+	}
+	parts := []types.CompletedPart{{PartNumber: aws.Int32(1), ETag: small.ETag}, {PartNumber: aws.Int32(2), ETag: copied.CopyPartResult.ETag}}
+	_, err = m.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{UploadId: mpu.UploadId, MultipartUpload: &types.CompletedMultipartUpload{Parts: parts}})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "EntityTooSmall" {
+		t.Errorf("expected EntityTooSmall, got %v", err)
+	}
+	m.MinPartSize = 4
+	if _, err := m.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{UploadId: mpu.UploadId, MultipartUpload: &types.CompletedMultipartUpload{Parts: parts}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(m.Get("bucket", "dst")); got != "tinyabcdef" {
+		t.Errorf("got %q", got)
+	}
+	head, err := m.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dst"), PartNumber: aws.Int32(1)})
+	if err != nil || aws.ToInt64(head.ContentLength) != 4 || aws.ToInt32(head.PartsCount) != 2 {
+		t.Errorf("unexpected head %+v %v", head, err)
+	}
+}
+
+// TestMemoryBackendArchives creates archives in memory through the large
+// files, small files and in-memory paths and reads them back with
+// archive/tar and their TOC. The archives are built at the same time with
+// different formats and pad sizes.
+func TestMemoryBackendArchives(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name   string
+		sizes  []int
+		format string
+		opts   S3TarS3Options
+	}{
+		{"large files", []int{6 * mb, 5*mb + 1, 7 * mb}, "pax", S3TarS3Options{}},
+		{"small files", []int{1000, 3 * mb, 0, 4 * mb, 512, 2*mb + 7}, "pax", S3TarS3Options{}},
+		{"gnu", []int{1000, 3 * mb, 0, 4 * mb, 6 * mb}, "gnu", S3TarS3Options{}},
+		{"small pad", []int{1000, 300 * 1024, 2 * mb, 100, 700 * 1024}, "pax", S3TarS3Options{PadSize: mb}},
+		{"aligned", []int{1000, 3 * mb, 100, 4 * mb}, "pax", S3TarS3Options{Align: 4096}},
+		{"in memory", []int{1000, 10, 70000}, "pax", S3TarS3Options{}},
+		{"no embedded toc", []int{6 * mb, 5*mb + 1, 7 * mb}, "pax", S3TarS3Options{NoEmbeddedTOC: true}},
+		{"posix eof", []int{1000, 3 * mb, 4 * mb}, "pax", S3TarS3Options{EOFPadding: EOFPaddingPOSIX}},
+		{"posix eof large files", []int{6 * mb, 7 * mb}, "gnu", S3TarS3Options{EOFPadding: EOFPaddingPOSIX}},
+		{"no embedded toc small files", []int{1000, 3 * mb, 0, 4 * mb, 512}, "pax", S3TarS3Options{NoEmbeddedTOC: true}},
+		{"mixed", []int{1000, 6 * mb, 100, 200, 7 * mb, 5*mb + 1, 3 * mb, 10, 6 * mb}, "pax", S3TarS3Options{}},
+		{"mixed large first", []int{6 * mb, 100, 7 * mb, 8 * mb, 2 * mb, 3 * mb, 1}, "gnu", S3TarS3Options{}},
+		{"mixed aligned", []int{1000, 6 * mb, 100, 7 * mb, 10}, "pax", S3TarS3Options{Align: 4096}},
+		{"mixed no embedded toc", []int{6 * mb, 100, 7 * mb, 10}, "pax", S3TarS3Options{NoEmbeddedTOC: true}},
+		{"staged headers", []int{1000, 3 * mb, 0, 4 * mb, 512, 2*mb + 7}, "pax", S3TarS3Options{StageHeaders: true}},
+		{"mixed staged headers", []int{1000, 6 * mb, 100, 7 * mb, 10}, "pax", S3TarS3Options{StageHeaders: true, Align: 4096}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			m := NewMemoryBackend()
+			if tt.opts.PadSize > 0 {
+				m.MinPartSize = tt.opts.PadSize
+			}
+			contents := map[string][]byte{}
+			var objectList []*S3Obj
+			for i, size := range tt.sizes {
+				key := fmt.Sprintf("files/%d.bin", i)
+				data := bytes.Repeat([]byte{byte('a' + i)}, size)
+				contents[key] = data
+				m.Put("src-bucket", key, data)
+				o := NewS3ObjOptions(WithBucketAndKey("src-bucket", key), WithSize(int64(size)))
+				o.ETag = aws.String(fmt.Sprintf("%x", i))
+				objectList = append(objectList, o)
+			}
+			opts := tt.opts
+			opts.SrcBucket, opts.DstBucket, opts.DstKey, opts.Threads = "src-bucket", "dst-bucket", "archives/archive.tar", 4
+			opts.DstPrefix, opts.Region = KeyDir(opts.DstKey), "us-east-1"
+			if err := CreateFromListWithBackend(ctx, m, objectList, &opts, WithTarFormat(tt.format)); err != nil {
+				t.Fatal(err)
+			}
+			archive := m.Get("dst-bucket", opts.DstKey)
+			want := 1
+			if opts.NoEmbeddedTOC {
+				// the archive and its TOC
+				want = 2
+			}
+			if keys := m.Keys("dst-bucket", ""); len(keys) != want {
+				t.Errorf("the intermediate objects are left: %v", keys)
+			}
+
+			if opts.EOFPadding == EOFPaddingPOSIX {
+				// the last entry ends on a block, only two zero blocks follow
+				end := len(archive) - 2*int(blockSize)
+				if archive[end-1] == 0 || len(bytes.Trim(archive[end:], "\x00")) > 0 {
+					t.Errorf("the archive doesn't end with the last entry and two zero blocks")
+				}
+			}
+			tr := tar.NewReader(bytes.NewReader(archive))
+			var toc []byte
+			read := 0
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hdr.Name == "toc.csv" {
+					if opts.NoEmbeddedTOC {
+						t.Errorf("the archive has a toc.csv entry")
+					}
+					toc = data
+					continue
+				}
+				if !bytes.Equal(data, contents[hdr.Name]) {
+					t.Errorf("%s: read %d bytes, want %d", hdr.Name, len(data), len(contents[hdr.Name]))
+				}
+				read++
+			}
+			if read != len(contents) {
+				t.Errorf("read %d entries, want %d", read, len(contents))
+			}
+			if tt.name == "in memory" {
+				return
+			}
+			if opts.NoEmbeddedTOC {
+				toc = m.Get("dst-bucket", ExternalTOCKey(opts.DstKey))
+			}
+			lines, err := csv.NewReader(bytes.NewReader(toc)).ReadAll()
+			if err != nil || len(lines) != len(contents) {
+				t.Fatalf("invalid TOC %q: %v", toc, err)
+			}
+			// the offsets computed before the archive is created are the TOC
+			offsets, err := ComputeOffsets(objectList, &tt.opts, WithTarFormat(tt.format))
+			if err != nil || len(offsets) != len(lines) {
+				t.Fatalf("ComputeOffsets() = %d offsets, %v", len(offsets), err)
+			}
+			for i, line := range lines {
+				start, _ := strconv.ParseInt(line[1], 10, 64)
+				size, _ := strconv.ParseInt(line[2], 10, 64)
+				if e := offsets[i]; e.Key != line[0] || e.Start != start || e.Size != size {
+					t.Errorf("%s: ComputeOffsets() = %+v, the TOC has %d %d", line[0], e, start, size)
+				}
+				if !bytes.Equal(archive[start:start+size], contents[line[0]]) {
+					t.Errorf("%s: the TOC offset %d is wrong", line[0], start)
+				}
+				if opts.Align > 0 && start%opts.Align != 0 {
+					t.Errorf("%s starts at %d", line[0], start)
+				}
+			}
+		})
+	}
+}
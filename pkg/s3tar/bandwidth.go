@@ -0,0 +1,120 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const contextKeyBandwidth = contextKey("bandwidth")
+
+// rateLimiter is a token bucket. The bandwidth limiter is shared by every
+// download and upload that goes through s3tar, e.g. in-memory mode, zip,
+// digests and extraction, the server-side copies don't count. The request
+// rate of a tenant is limited with one too, see TenantLimits.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second, bytes for the bandwidth
+	tokens float64
+	last   time.Time
+}
+
+// WithMaxBandwidth returns a context that limits the data downloaded and
+// uploaded by s3tar to bytesPerSecond, shared by all the archives created
+// with it. Server-side copies aren't limited since they don't go through the
+// client.
+func WithMaxBandwidth(ctx context.Context, bytesPerSecond int64) context.Context {
+	if bytesPerSecond <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKeyBandwidth, newRateLimiter(float64(bytesPerSecond)))
+}
+
+// newRateLimiter returns a token bucket of rate tokens per second, full.
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// waitBandwidth blocks until n bytes can be transferred.
+func waitBandwidth(ctx context.Context, n int) error {
+	l, ok := ctx.Value(contextKeyBandwidth).(*rateLimiter)
+	if !ok || n <= 0 {
+		return nil
+	}
+	return l.wait(ctx, float64(n))
+}
+
+// wait blocks until n tokens are available.
+func (l *rateLimiter) wait(ctx context.Context, n float64) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		// at most a second of burst
+		l.tokens = l.rate
+	}
+	l.last = now
+	// taking the tokens now makes the callers queue up behind each other
+	l.tokens -= n
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// limitReader limits the reads of r with the limiter in ctx, if any.
+func limitReader(ctx context.Context, r io.ReadCloser) io.ReadCloser {
+	if _, ok := ctx.Value(contextKeyBandwidth).(*rateLimiter); !ok {
+		return r
+	}
+	return &limitedReader{ReadCloser: r, ctx: ctx}
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if werr := waitBandwidth(r.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// limitBody limits the reads of the body of an upload with the limiter in
+// ctx, if any, as the SDK sends it. The body stays seekable, the SDK seeks
+// back to retry a request and the bytes sent again count again.
+func limitBody(ctx context.Context, r io.ReadSeeker) io.ReadSeeker {
+	if _, ok := ctx.Value(contextKeyBandwidth).(*rateLimiter); !ok {
+		return r
+	}
+	return &limitedBody{ReadSeeker: r, ctx: ctx}
+}
+
+type limitedBody struct {
+	io.ReadSeeker
+	ctx context.Context
+}
+
+func (r *limitedBody) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	if werr := waitBandwidth(r.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
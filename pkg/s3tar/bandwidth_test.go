@@ -0,0 +1,70 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMaxBandwidth(t *testing.T) {
+	ctx := context.Background()
+	if WithMaxBandwidth(ctx, 0) != ctx {
+		t.Errorf("a zero limit should return ctx")
+	}
+	if r := limitReader(ctx, io.NopCloser(bytes.NewReader(nil))); r == nil {
+		t.Errorf("limitReader without a limiter returned nil")
+	}
+
+	ctx = WithMaxBandwidth(ctx, 10000)
+	start := time.Now()
+	// the first second is a burst
+	if err := waitBandwidth(ctx, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("burst took %s", d)
+	}
+
+	r := limitReader(ctx, io.NopCloser(bytes.NewReader(make([]byte, 3000))))
+	n, err := io.Copy(io.Discard, r)
+	if err != nil || n != 3000 {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("13000 bytes at 10000 bytes/s took %s", d)
+	}
+
+	// the body of an upload is limited as it is read, and seeks back to be
+	// sent again
+	start = time.Now()
+	body := limitBody(ctx, bytes.NewReader(make([]byte, 3000)))
+	if _, ok := body.(*limitedBody); !ok {
+		t.Fatalf("the body isn't limited")
+	}
+	if n, err := io.Copy(io.Discard, body); err != nil || n != 3000 {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(io.Discard, body); err != nil || n != 3000 {
+		t.Fatalf("read %d bytes again: %v", n, err)
+	}
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Errorf("6000 bytes at 10000 bytes/s took %s", d)
+	}
+	if r := limitBody(context.Background(), bytes.NewReader(nil)); r == nil {
+		t.Errorf("limitBody without a limiter returned nil")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := waitBandwidth(canceled, 100000); err == nil {
+		t.Errorf("expected an error from a canceled context")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// BenchOptions is the synthetic workload of RunBenchmark.
+type BenchOptions struct {
+	// Objects is the number of synthetic objects of the planning stages,
+	// ObjectSize their size. No data is allocated for them.
+	Objects    int
+	ObjectSize int64
+	// ArchiveObjects of the objects are archived with a MemoryBackend, their
+	// data is held in memory. 0 skips the archive stage. Above 5MB the
+	// archive is concatenated and the MemoryBackend hashes every part, keep
+	// it small to measure the pipeline rather than MD5.
+	ArchiveObjects int
+	// Rounds is how many times every stage runs, the fastest is kept.
+	Rounds  int
+	Threads int
+}
+
+// BenchResult is the time a stage of RunBenchmark took.
+type BenchResult struct {
+	Stage    string        `json:"stage"`
+	Objects  int           `json:"objects"`
+	Duration time.Duration `json:"duration"`
+}
+
+// ObjectsPerSecond is the throughput of the stage.
+func (r BenchResult) ObjectsPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Objects) / r.Duration.Seconds()
+}
+
+// BenchReport is the result of RunBenchmark.
+type BenchReport struct {
+	Objects    int           `json:"objects"`
+	ObjectSize int64         `json:"objectSize"`
+	Results    []BenchResult `json:"results"`
+}
+
+// RunBenchmark measures the stages of a run that don't depend on S3: the
+// grouping of the keys, the generation of the tar headers and the TOC, the
+// planning of the concatenation of the small objects and the split of a
+// large list, on synthetic objects. The archive stage creates an archive
+// with a MemoryBackend end to end. No request is sent to S3, the results
+// can be compared before and after a change, see Compare.
+func RunBenchmark(ctx context.Context, opts BenchOptions) (*BenchReport, error) {
+	if opts.Objects < 1 || opts.ObjectSize < 0 || opts.ArchiveObjects < 0 {
+		return nil, fmt.Errorf("the benchmark needs at least one object and sizes >= 0")
+	}
+	if opts.Rounds < 1 {
+		opts.Rounds = 1
+	}
+	cfg := newJobConfig(&S3TarS3Options{Threads: opts.Threads})
+	ctx = withJobConfig(ctx, cfg)
+	objectList := benchObjects(opts.Objects, opts.ObjectSize)
+	report := &BenchReport{Objects: opts.Objects, ObjectSize: opts.ObjectSize}
+
+	groupFn := GroupByRegex(regexp.MustCompile(`(\d{4}-\d{2}-\d{2})`))
+	stages := []struct {
+		name string
+		run  func() error
+	}{
+		{"group-by", func() error {
+			GroupObjects(objectList, groupFn)
+			return nil
+		}},
+		{"headers", func() error {
+			_, err := cfg.entryHeaders(objectList)
+			return err
+		}},
+		{"toc", func() error {
+			headers, err := cfg.entryHeaders(objectList)
+			if err != nil {
+				return err
+			}
+			_, _, err = cfg.tocLength(headers, objectList)
+			return err
+		}},
+		{"concat-plan", func() error {
+			indexList, _, err := planGroups(ctx, objectList)
+			if err != nil {
+				return err
+			}
+			cfg.mergeSmallGroups(indexList)
+			return nil
+		}},
+		{"size-limit", func() error {
+			// archives of about 100 objects
+			BreakUpList(objectList, 100*opts.ObjectSize+1)
+			return nil
+		}},
+	}
+	for _, st := range stages {
+		d, err := benchRounds(opts.Rounds, st.run)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", st.name, err)
+		}
+		report.Results = append(report.Results, BenchResult{Stage: st.name, Objects: opts.Objects, Duration: d})
+	}
+
+	if opts.ArchiveObjects > 0 {
+		d, err := benchRounds(opts.Rounds, func() error {
+			return benchArchive(ctx, opts)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("archive: %w", err)
+		}
+		report.Results = append(report.Results, BenchResult{Stage: "archive", Objects: opts.ArchiveObjects, Duration: d})
+	}
+	return report, nil
+}
+
+// benchRounds runs fn rounds times and returns the fastest run.
+func benchRounds(rounds int, fn func() error) (time.Duration, error) {
+	var fastest time.Duration
+	for i := 0; i < rounds; i++ {
+		start := time.Now()
+		if err := fn(); err != nil {
+			return 0, err
+		}
+		if d := time.Since(start); i == 0 || d < fastest {
+			fastest = d
+		}
+	}
+	return fastest, nil
+}
+
+// benchObjects returns n objects of size bytes, named like daily logs so
+// they can be grouped by date.
+func benchObjects(n int, size int64) []*S3Obj {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	objectList := make([]*S3Obj, n)
+	for i := range objectList {
+		key := fmt.Sprintf("logs/2024-01-%02d/host-%03d/app-%08d.log", i%28+1, i%100, i)
+		o := NewS3ObjOptions(WithBucketAndKey("bench-bucket", key), WithSize(size), WithETag(fmt.Sprintf("%032x", i)))
+		o.LastModified = aws.Time(modTime)
+		objectList[i] = o
+	}
+	return objectList
+}
+
+// benchArchive creates an archive of opts.ArchiveObjects objects with a
+// MemoryBackend.
+func benchArchive(ctx context.Context, opts BenchOptions) error {
+	m := NewMemoryBackend()
+	objectList := benchObjects(opts.ArchiveObjects, opts.ObjectSize)
+	data := bytes.Repeat([]byte{'x'}, int(opts.ObjectSize))
+	for _, o := range objectList {
+		m.Put(o.Bucket, *o.Key, data)
+	}
+	archiveOpts := &S3TarS3Options{
+		SrcBucket: "bench-bucket",
+		DstBucket: "bench-bucket",
+		DstPrefix: "bench",
+		DstKey:    "bench/archive.tar",
+		Region:    "us-east-1",
+		Threads:   opts.Threads,
+	}
+	return CreateFromListWithBackend(ctx, m, objectList, archiveOpts)
+}
+
+// WriteReport writes a human readable report of the results to w.
+func (r *BenchReport) WriteReport(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "benchmark: %d objects of %s\n", r.Objects, formatBytes(r.ObjectSize))
+	for _, res := range r.Results {
+		fmt.Fprintf(&buf, "  %-12s %10d objects %12s %14.0f objects/s\n", res.Stage, res.Objects, res.Duration.Round(time.Microsecond), res.ObjectsPerSecond())
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteJSON writes the report as JSON, the baseline read by LoadBenchReport.
+func (r *BenchReport) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// LoadBenchReport reads a report written by WriteJSON.
+func LoadBenchReport(r io.Reader) (*BenchReport, error) {
+	report := &BenchReport{}
+	if err := json.NewDecoder(r).Decode(report); err != nil {
+		return nil, fmt.Errorf("invalid benchmark report: %w", err)
+	}
+	return report, nil
+}
+
+// Compare returns the stages of r slower than in baseline by more than
+// tolerance, e.g. 0.2 for 20%. The stages are compared by their throughput,
+// the stages missing from baseline are skipped.
+func (r *BenchReport) Compare(baseline *BenchReport, tolerance float64) []string {
+	base := map[string]BenchResult{}
+	for _, res := range baseline.Results {
+		base[res.Stage] = res
+	}
+	var regressions []string
+	for _, res := range r.Results {
+		b, ok := base[res.Stage]
+		if !ok || b.ObjectsPerSecond() == 0 {
+			continue
+		}
+		if ratio := res.ObjectsPerSecond() / b.ObjectsPerSecond(); ratio < 1-tolerance {
+			regressions = append(regressions, fmt.Sprintf("%s: %.0f objects/s, %.0f in the baseline (%.0f%% slower)", res.Stage, res.ObjectsPerSecond(), b.ObjectsPerSecond(), (1-ratio)*100))
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,116 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// The benchmarks run with the fake backend, no S3 endpoint is needed:
+//
+//	go test -run ^$ -bench . ./pkg/s3tar/
+const benchObjectCount = 10000
+
+func BenchmarkGroupObjects(b *testing.B) {
+	objectList := benchObjects(benchObjectCount, 64*1024)
+	groupFn := GroupByRegex(regexp.MustCompile(`(\d{4}-\d{2}-\d{2})`))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GroupObjects(objectList, groupFn)
+	}
+}
+
+func BenchmarkEntryHeaders(b *testing.B) {
+	for _, format := range []string{"pax", "gnu"} {
+		b.Run(format, func(b *testing.B) {
+			opts := &S3TarS3Options{}
+			WithTarFormat(format)(opts)
+			cfg := newJobConfig(opts)
+			objectList := benchObjects(benchObjectCount, 64*1024)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				testEntryHeaders(b, cfg, objectList)
+			}
+		})
+	}
+}
+
+func BenchmarkTOCLength(b *testing.B) {
+	objectList := benchObjects(benchObjectCount, 64*1024)
+	headers := testEntryHeaders(b, defaultJobConfig, objectList)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := defaultJobConfig.tocLength(headers, objectList); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConcatPlan(b *testing.B) {
+	ctx := SetLogLevel(context.Background(), LogLevelWarn)
+	objectList := benchObjects(benchObjectCount, 64*1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexList, _, err := planGroups(ctx, objectList)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defaultJobConfig.mergeSmallGroups(indexList)
+	}
+}
+
+func BenchmarkMemoryBackendArchive(b *testing.B) {
+	ctx := SetLogLevel(context.Background(), LogLevelWarn)
+	opts := BenchOptions{ArchiveObjects: 50, ObjectSize: 64 * 1024, Threads: 8}
+	b.SetBytes(int64(opts.ArchiveObjects) * opts.ObjectSize)
+	for i := 0; i < b.N; i++ {
+		if err := benchArchive(ctx, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRunBenchmark(t *testing.T) {
+	ctx := SetLogLevel(context.Background(), LogLevelWarn)
+	report, err := RunBenchmark(ctx, BenchOptions{Objects: 500, ObjectSize: 64 * 1024, ArchiveObjects: 20, Rounds: 2, Threads: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stages []string
+	for _, r := range report.Results {
+		stages = append(stages, r.Stage)
+		if r.Duration <= 0 || r.ObjectsPerSecond() <= 0 {
+			t.Errorf("%s: %s", r.Stage, r.Duration)
+		}
+	}
+	if len(stages) != 6 || stages[0] != "group-by" || stages[5] != "archive" {
+		t.Errorf("stages = %v", stages)
+	}
+	if _, err := RunBenchmark(ctx, BenchOptions{}); err == nil {
+		t.Errorf("a benchmark without objects should be refused")
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := LoadBenchReport(&buf)
+	if err != nil || len(baseline.Results) != 6 {
+		t.Fatalf("got %v %v", baseline, err)
+	}
+	if regressions := report.Compare(baseline, 0.2); len(regressions) != 0 {
+		t.Errorf("the report regressed from itself: %v", regressions)
+	}
+	slower := *report
+	slower.Results = append([]BenchResult(nil), report.Results...)
+	slower.Results[1].Duration *= 2
+	slower.Results = append(slower.Results, BenchResult{Stage: "new", Objects: 1, Duration: time.Second})
+	if regressions := slower.Compare(baseline, 0.2); len(regressions) != 1 {
+		t.Errorf("regressions = %v", regressions)
+	}
+}
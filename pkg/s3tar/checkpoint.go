@@ -0,0 +1,336 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrInterrupted is returned when the context of a run is canceled, e.g. on
+// SIGTERM. A checkpoint has been written next to the archive and the run can
+// be resumed with S3TarS3Options.Resume.
+var ErrInterrupted = errors.New("interrupted")
+
+// ErrMaxRuntime is the cause of the cancellation of a run past its max
+// runtime, see WithMaxRuntime.
+var ErrMaxRuntime = errors.New("max runtime reached")
+
+// WithMaxRuntime returns a copy of ctx canceled after d with ErrMaxRuntime,
+// e.g. to fit a run in a maintenance window. Like on SIGTERM, a run past its
+// max runtime writes its checkpoint and returns ErrInterrupted.
+func WithMaxRuntime(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeoutCause(ctx, d, ErrMaxRuntime)
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(context.Cause(ctx), ErrMaxRuntime) {
+			Warnf(ctx, "the max runtime of %s is reached, stopping", d)
+		}
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Interrupt policies for the multipart uploads in flight when a run is
+// interrupted.
+const (
+	InterruptAbort = "abort"
+	InterruptKeep  = "keep"
+)
+
+const contextKeyCheckpoint = contextKey("checkpoint")
+
+// FailedGroup is a group of small files that failed to concatenate, the
+// objects Start to End of the list.
+type FailedGroup struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	First string `json:"first"`
+	Last  string `json:"last"`
+	Error string `json:"error"`
+}
+
+// GroupsFailedError is returned when groups of small files failed while the
+// other groups completed. A checkpoint with the completed groups has been
+// written next to the archive, the run resumed with S3TarS3Options.Resume
+// only retries the failed ones.
+type GroupsFailedError struct {
+	Archive string
+	Groups  []FailedGroup
+	Total   int
+}
+
+func (e *GroupsFailedError) Error() string {
+	g := e.Groups[0]
+	return fmt.Sprintf("%d of %d groups failed, %s can be resumed with --resume to retry them. objects %d-%d (%s to %s): %s",
+		len(e.Groups), e.Total, e.Archive, g.Start, g.End, g.First, g.Last, g.Error)
+}
+
+// CheckpointUpload is a multipart upload that was in flight.
+type CheckpointUpload struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	UploadId string `json:"uploadId"`
+}
+
+// CheckpointObject is an intermediate object that was completed.
+type CheckpointObject struct {
+	Key  string `json:"key"`
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// Checkpoint is the state of an interrupted run. It is written to
+// s3://DstBucket/DstKey.checkpoint.json.
+type Checkpoint struct {
+	Archive   string             `json:"archive"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Uploads   []CheckpointUpload `json:"uploads"`
+	// UploadsAborted is true when the uploads were aborted, per the
+	// InterruptAbort policy.
+	UploadsAborted bool `json:"uploadsAborted"`
+	// Groups are the completed groups of small files by the index of their
+	// first object. They are reused when the run is resumed.
+	Groups map[int]CheckpointObject `json:"groups"`
+	// JobID is the suffix of the intermediate keys of the run, the resumed
+	// run writes to the same keys.
+	JobID string `json:"jobId,omitempty"`
+	// FailedGroups are the groups that failed when the run stopped on a
+	// GroupsFailedError.
+	FailedGroups []FailedGroup `json:"failedGroups,omitempty"`
+	// ListHash is the objectListHash of the objects of the run. The groups
+	// are found by the index of their first object, a run with other
+	// objects can't reuse them.
+	ListHash string `json:"listHash"`
+}
+
+type checkpointTracker struct {
+	mu      sync.Mutex
+	uploads map[string]CheckpointUpload
+	groups  map[int]CheckpointObject
+	failed  []FailedGroup
+	// resume is the checkpoint of the run being resumed, if any
+	resume *Checkpoint
+	// listHash is the objectListHash of the run
+	listHash string
+}
+
+func newCheckpointTracker() *checkpointTracker {
+	return &checkpointTracker{uploads: map[string]CheckpointUpload{}, groups: map[int]CheckpointObject{}}
+}
+
+func trackerFromContext(ctx context.Context) *checkpointTracker {
+	if t, ok := ctx.Value(contextKeyCheckpoint).(*checkpointTracker); ok {
+		return t
+	}
+	return nil
+}
+
+// trackUpload records a multipart upload in flight.
+func trackUpload(ctx context.Context, bucket, key, uploadId string) {
+	if t := trackerFromContext(ctx); t != nil {
+		t.mu.Lock()
+		t.uploads[uploadId] = CheckpointUpload{Bucket: bucket, Key: key, UploadId: uploadId}
+		t.mu.Unlock()
+	}
+}
+
+// untrackUpload forgets a completed multipart upload.
+func untrackUpload(ctx context.Context, uploadId string) {
+	if t := trackerFromContext(ctx); t != nil {
+		t.mu.Lock()
+		delete(t.uploads, uploadId)
+		t.mu.Unlock()
+	}
+}
+
+// trackGroup records a completed group of small files.
+func trackGroup(ctx context.Context, start int, obj *S3Obj) {
+	if t := trackerFromContext(ctx); t != nil {
+		t.mu.Lock()
+		t.groups[start] = CheckpointObject{Key: *obj.Key, ETag: aws.ToString(obj.ETag), Size: *obj.Size}
+		t.mu.Unlock()
+	}
+}
+
+// trackFailedGroup records a group of small files that failed.
+func trackFailedGroup(ctx context.Context, g FailedGroup) {
+	if t := trackerFromContext(ctx); t != nil {
+		t.mu.Lock()
+		t.failed = append(t.failed, g)
+		t.mu.Unlock()
+	}
+}
+
+// resumedGroup returns the group starting at start completed by the run
+// being resumed, if it was stored at key and still exists.
+func resumedGroup(ctx context.Context, svc Backend, bucket, key string, start int) *S3Obj {
+	t := trackerFromContext(ctx)
+	if t == nil || t.resume == nil {
+		return nil
+	}
+	g, ok := t.resume.Groups[start]
+	if !ok || g.Key != key {
+		return nil
+	}
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &g.Key, IfMatch: &g.ETag})
+	if err != nil {
+		Debugf(ctx, "group %d of the checkpoint is gone: %s", start, err.Error())
+		return nil
+	}
+	obj := &S3Obj{Bucket: bucket, Object: types.Object{Key: aws.String(g.Key), ETag: head.ETag, Size: aws.Int64(g.Size)}}
+	trackGroup(ctx, start, obj)
+	return obj
+}
+
+// objectListHash is the SHA-256 of the buckets, keys, versions, sizes and
+// ETags of objectList, in order.
+func objectListHash(objectList []*S3Obj) string {
+	h := sha256.New()
+	for _, o := range objectList {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%s\n", o.Bucket, aws.ToString(o.Key), o.VersionId, aws.ToInt64(o.Size), aws.ToString(o.ETag))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func checkpointKey(opts *S3TarS3Options) string {
+	return opts.DstKey + ".checkpoint.json"
+}
+
+// localCheckpointPath is where the checkpoint is written in the working
+// directory when it can't be written to S3 because the credentials expired.
+func localCheckpointPath(opts *S3TarS3Options) string {
+	return filepath.Base(checkpointKey(opts))
+}
+
+// loadCheckpoint reads the checkpoint of a previous run. It returns nil when
+// there is none.
+func loadCheckpoint(ctx context.Context, svc Backend, opts *S3TarS3Options) (*Checkpoint, error) {
+	r, err := getObject(ctx, svc, opts.DstBucket, checkpointKey(opts))
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if errors.As(err, &notFound) {
+			cp, err := loadLocalCheckpoint(opts)
+			if cp != nil {
+				Infof(ctx, "using the checkpoint %s of the working directory", localCheckpointPath(opts))
+			}
+			return cp, err
+		}
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint s3://%s/%s: %w", opts.DstBucket, checkpointKey(opts), err)
+	}
+	return cp, nil
+}
+
+// loadLocalCheckpoint reads the checkpoint written to the working directory
+// by a run whose credentials expired. It returns nil when there is none, or
+// when it is the checkpoint of another archive with the same name.
+func loadLocalCheckpoint(opts *S3TarS3Options) (*Checkpoint, error) {
+	data, err := os.ReadFile(localCheckpointPath(opts))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", localCheckpointPath(opts), err)
+	}
+	if cp.Archive != fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey) {
+		return nil, nil
+	}
+	return cp, nil
+}
+
+// writeCheckpoint aborts or keeps the uploads in flight per the interrupt
+// policy and writes the checkpoint. ctx must not be the canceled context of
+// the run.
+func (t *checkpointTracker) writeCheckpoint(ctx context.Context, svc Backend, opts *S3TarS3Options) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cp := &Checkpoint{
+		Archive:   fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey),
+		CreatedAt: time.Now(),
+		Groups:    t.groups,
+		JobID:     opts.jobID,
+		ListHash:  t.listHash,
+	}
+	cp.FailedGroups = append(cp.FailedGroups, t.failed...)
+	sort.Slice(cp.FailedGroups, func(i, j int) bool { return cp.FailedGroups[i].Start < cp.FailedGroups[j].Start })
+	for _, u := range t.uploads {
+		cp.Uploads = append(cp.Uploads, u)
+	}
+	sort.Slice(cp.Uploads, func(i, j int) bool { return cp.Uploads[i].UploadId < cp.Uploads[j].UploadId })
+
+	if opts.InterruptPolicy != InterruptKeep {
+		cp.UploadsAborted = true
+		for _, u := range cp.Uploads {
+			_, err := svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(u.Bucket),
+				Key:      aws.String(u.Key),
+				UploadId: aws.String(u.UploadId),
+			})
+			if err != nil {
+				Warnf(ctx, "unable to abort upload %s of s3://%s/%s: %s", u.UploadId, u.Bucket, u.Key, err.Error())
+				cp.UploadsAborted = false
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	location := fmt.Sprintf("s3://%s/%s", opts.DstBucket, checkpointKey(opts))
+	if _, err := putObject(ctx, svc, opts.DstBucket, checkpointKey(opts), data); errors.Is(err, ErrCredentialsExpired) {
+		// the run is resumed from the same directory with new credentials
+		location = localCheckpointPath(opts)
+		if err := os.WriteFile(location, data, 0o600); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if len(cp.FailedGroups) > 0 {
+		Warnf(ctx, "%d groups failed and %d completed. checkpoint: %s", len(cp.FailedGroups), len(cp.Groups), location)
+		return nil
+	}
+	Warnf(ctx, "interrupted with %d uploads in flight and %d groups completed. checkpoint: %s", len(cp.Uploads), len(cp.Groups), location)
+	return nil
+}
+
+// deleteCheckpoint removes the checkpoint once the archive is complete.
+func deleteCheckpoint(ctx context.Context, svc Backend, opts *S3TarS3Options) {
+	_, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &opts.DstBucket, Key: aws.String(checkpointKey(opts))})
+	if err != nil {
+		Warnf(ctx, "unable to delete the checkpoint s3://%s/%s: %s", opts.DstBucket, checkpointKey(opts), err.Error())
+	}
+	if cp, _ := loadLocalCheckpoint(opts); cp != nil {
+		os.Remove(localCheckpointPath(opts))
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestCheckpointTracker(t *testing.T) {
+	ctx := context.Background()
+	// no tracker in ctx, nothing is recorded
+	trackUpload(ctx, "my-bucket", "archive.tar", "upload-0")
+	if resumedGroup(ctx, nil, "my-bucket", "archive.tar.parts/iteration.batch.0-9", 0) != nil {
+		t.Errorf("resumedGroup without a tracker should be nil")
+	}
+
+	tracker := newCheckpointTracker()
+	ctx = context.WithValue(ctx, contextKeyCheckpoint, tracker)
+	trackUpload(ctx, "my-bucket", "archive.tar", "upload-1")
+	trackUpload(ctx, "my-bucket", "archive.tar.parts/a", "upload-2")
+	untrackUpload(ctx, "upload-1")
+	obj := NewS3ObjOptions(WithBucketAndKey("my-bucket", "archive.tar.parts/iteration.batch.0-9"), WithSize(1024), WithETag("\"abc\""))
+	trackGroup(ctx, 0, obj)
+
+	if len(tracker.uploads) != 1 || tracker.uploads["upload-2"].Key != "archive.tar.parts/a" {
+		t.Errorf("unexpected uploads %+v", tracker.uploads)
+	}
+	if resumedGroup(ctx, nil, "my-bucket", *obj.Key, 0) != nil {
+		t.Errorf("resumedGroup without a checkpoint should be nil")
+	}
+
+	data, err := json.Marshal(&Checkpoint{Archive: "s3://my-bucket/archive.tar", Groups: tracker.groups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		t.Fatal(err)
+	}
+	g, ok := cp.Groups[0]
+	if !ok || g.Key != *obj.Key || g.ETag != "\"abc\"" || g.Size != 1024 {
+		t.Errorf("unexpected groups %+v", cp.Groups)
+	}
+
+	// a group stored under another key, e.g. a different grouping, is not reused
+	tracker.resume = cp
+	if resumedGroup(ctx, nil, "my-bucket", "archive.tar.parts/iteration.batch.0-5", 0) != nil {
+		t.Errorf("resumedGroup with a different key should be nil")
+	}
+}
+
+func TestGroupsFailedError(t *testing.T) {
+	tracker := newCheckpointTracker()
+	ctx := context.WithValue(context.Background(), contextKeyCheckpoint, tracker)
+	g := FailedGroup{Start: 10, End: 19, First: "dir/a.txt", Last: "dir/j.txt", Error: "AccessDenied"}
+	trackFailedGroup(ctx, g)
+	if len(tracker.failed) != 1 || tracker.failed[0] != g {
+		t.Fatalf("unexpected failed groups %+v", tracker.failed)
+	}
+
+	var err error = &GroupsFailedError{Archive: "s3://my-bucket/archive.tar", Groups: tracker.failed, Total: 4}
+	var groupsErr *GroupsFailedError
+	if !errors.As(fmt.Errorf("archive: %w", err), &groupsErr) {
+		t.Fatal("GroupsFailedError isn't unwrapped")
+	}
+	want := "1 of 4 groups failed, s3://my-bucket/archive.tar can be resumed with --resume to retry them. objects 10-19 (dir/a.txt to dir/j.txt): AccessDenied"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+
+	data, err := json.Marshal(&Checkpoint{Archive: "s3://my-bucket/archive.tar", FailedGroups: tracker.failed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.FailedGroups) != 1 || cp.FailedGroups[0] != g {
+		t.Errorf("unexpected failed groups %+v", cp.FailedGroups)
+	}
+}
+
+func TestExtractProgressTracker(t *testing.T) {
+	a := &FileMetadata{Filename: "a.txt", Start: 1536}
+	b := &FileMetadata{Filename: "b.txt", Start: 4096}
+	resume := &ExtractProgress{ETag: "\"abc\"", Entries: []ExtractedEntry{{Filename: "a.txt", Start: 1536}}}
+	tracker := newExtractProgressTracker("\"abc\"", resume)
+	if !tracker.extracted(a) || tracker.extracted(b) {
+		t.Errorf("extracted(a) = %v, extracted(b) = %v", tracker.extracted(a), tracker.extracted(b))
+	}
+	// an entry of the same name elsewhere in the archive, e.g. appended later
+	if tracker.extracted(&FileMetadata{Filename: "a.txt", Start: 8192}) {
+		t.Errorf("an entry at another offset should not be skipped")
+	}
+	tracker.add(b)
+	if len(tracker.done) != 2 {
+		t.Errorf("done = %v", tracker.done)
+	}
+
+	opts := &S3TarS3Options{SrcKey: "prefix/archive.tar", DstPrefix: "restored"}
+	if key := extractProgressKey(opts); key != "restored/archive.tar.extract-progress.json" {
+		t.Errorf("extractProgressKey = %s", key)
+	}
+	if size := extractPartSize(opts); size != partSizeMax {
+		t.Errorf("extractPartSize = %d", size)
+	}
+}
+
+func TestPartsKey(t *testing.T) {
+	a := &S3TarS3Options{DstKey: "archive.tar", jobID: "0a1b2c3d"}
+	b := &S3TarS3Options{DstKey: "archive.tar", jobID: "4e5f6a7b"}
+	if partsKey(a) != "archive.tar.parts/0a1b2c3d" || partsKey(a) == partsKey(b) {
+		t.Errorf("partsKey = %s, %s", partsKey(a), partsKey(b))
+	}
+	cp, err := json.Marshal(&Checkpoint{JobID: a.jobID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOverwrite(context.Background(), nil, &S3TarS3Options{Overwrite: true}); err != nil {
+		t.Errorf("checkOverwrite with Overwrite = %v", err)
+	}
+	var resumed Checkpoint
+	if err := json.Unmarshal(cp, &resumed); err != nil || resumed.JobID != a.jobID {
+		t.Errorf("resumed job id = %q, %v", resumed.JobID, err)
+	}
+}
+
+func TestWithMaxRuntime(t *testing.T) {
+	ctx, cancel := WithMaxRuntime(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	if !errors.Is(context.Cause(ctx), ErrMaxRuntime) {
+		t.Errorf("got cause %v", context.Cause(ctx))
+	}
+
+	ctx, cancel = WithMaxRuntime(context.Background(), time.Hour)
+	cancel()
+	if errors.Is(context.Cause(ctx), ErrMaxRuntime) || ctx.Err() == nil {
+		t.Errorf("canceled before the max runtime: got cause %v", context.Cause(ctx))
+	}
+}
+
+// expiredPutBackend fails every PutObject with expired credentials.
+type expiredPutBackend struct {
+	*MemoryBackend
+}
+
+func (b expiredPutBackend) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return nil, fmt.Errorf("%w: ExpiredToken", ErrCredentialsExpired)
+}
+
+func TestLocalCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	m := NewMemoryBackend()
+	opts := &S3TarS3Options{DstBucket: "bucket", DstKey: "archives/archive.tar", InterruptPolicy: InterruptKeep}
+	tracker := newCheckpointTracker()
+	tracker.groups[0] = CheckpointObject{Key: "archives/archive.tar.parts/iteration.batch.0-9", ETag: "\"abc\"", Size: 1024}
+	if err := tracker.writeCheckpoint(ctx, expiredPutBackend{m}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("archive.tar.checkpoint.json"); err != nil {
+		t.Fatalf("the checkpoint wasn't written to the working directory: %v", err)
+	}
+
+	cp, err := loadCheckpoint(ctx, m, opts)
+	if err != nil || cp == nil || cp.Groups[0].Size != 1024 {
+		t.Fatalf("loadCheckpoint() = %+v, %v", cp, err)
+	}
+	// the checkpoint of another archive with the same name
+	other := &S3TarS3Options{DstBucket: "other", DstKey: "archive.tar"}
+	if cp, err := loadCheckpoint(ctx, m, other); cp != nil || err != nil {
+		t.Errorf("loadCheckpoint() of another archive = %+v, %v", cp, err)
+	}
+	deleteCheckpoint(ctx, m, other)
+	if _, err := os.Stat("archive.tar.checkpoint.json"); err != nil {
+		t.Errorf("the checkpoint of another archive was deleted")
+	}
+	deleteCheckpoint(ctx, m, opts)
+	if _, err := os.Stat("archive.tar.checkpoint.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the checkpoint wasn't deleted: %v", err)
+	}
+}
+
+func TestResumeChangedObjects(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBackend()
+	var list []*S3Obj
+	for _, key := range []string{"a.txt", "b.txt"} {
+		m.Put("bucket", key, []byte(key))
+		o := NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(5), WithETag("etag-"+key))
+		o.LastModified = aws.Time(time.Now())
+		list = append(list, o)
+	}
+	opts := &S3TarS3Options{SrcBucket: "bucket", DstBucket: "bucket", DstKey: "archive.tar", Region: "us-east-1", Threads: 2, Resume: true}
+
+	// the checkpoint of a run with another version of b.txt
+	changed := *list[1]
+	changed.ETag = aws.String("etag-b.txt-2")
+	data, err := json.Marshal(&Checkpoint{Archive: "s3://bucket/archive.tar", ListHash: objectListHash([]*S3Obj{list[0], &changed})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Put("bucket", checkpointKey(opts), data)
+	if err := CreateFromListWithBackend(ctx, m, list, opts); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Fatalf("resuming with other objects = %v, want an error", err)
+	}
+
+	data, err = json.Marshal(&Checkpoint{Archive: "s3://bucket/archive.tar", ListHash: objectListHash(list)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Put("bucket", checkpointKey(opts), data)
+	if err := CreateFromListWithBackend(ctx, m, list, opts); err != nil {
+		t.Fatalf("resuming with the same objects = %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// RecursiveConcat concatenates S3 objects of any size into one object with
+// multipart uploads. Objects under the minimum part size are appended to a
+// block of zeros that is trimmed once the result is large enough, the block
+// and the other intermediate objects are written under PartsKey.
+type RecursiveConcat struct {
+	Client      Backend
+	Region      string
+	EndpointUrl string
+	Bucket      string
+	DstPrefix   string
+	DstKey      string
+	PartsKey    string
+	// MinPartSize is the size of the block of zeros, the minimum part size
+	// of the store.
+	MinPartSize int64
+	// MaxAttempts is the number of times a merge is tried before its error
+	// is returned.
+	MaxAttempts int
+	// DeleteIntermediates deletes the objects under PartsKey once they are
+	// merged, instead of leaving them to the cleanup of the job.
+	DeleteIntermediates bool
+	block               S3Obj
+}
+
+// RecursiveConcatOptions configures NewRecursiveConcat. Client, Bucket,
+// DstPrefix and Region are required.
+type RecursiveConcatOptions struct {
+	Client      Backend
+	Region      string
+	EndpointUrl string
+	Bucket      string
+	DstPrefix   string
+	DstKey      string
+	// PartsKey is the prefix of the intermediate objects, DstKey.parts by
+	// default.
+	PartsKey string
+	// MinPartSize is the minimum part size of the store, the size of the
+	// padding of the objects too small to be a part. 5MB, or --pad-size, by
+	// default.
+	MinPartSize int64
+	// MaxAttempts is the number of times a failed merge is tried, 1 by
+	// default. The SDK retries the requests themselves, this also retries
+	// the multipart uploads that fail to complete.
+	MaxAttempts int
+	// DeleteIntermediates deletes the intermediate objects as soon as they
+	// are merged, and the block of zeros on Close.
+	DeleteIntermediates bool
+}
+
+// CreateFirstBlock uploads the block of zeros small objects are appended to.
+func (r *RecursiveConcat) CreateFirstBlock(ctx context.Context) error {
+	key := JoinKey(r.partsKey(), "min-size-block")
+	now := time.Now()
+	output, err := putObject(ctx, r.Client, r.Bucket, key, make([]byte, r.MinPartSize))
+	if err != nil {
+		return fmt.Errorf("unable to upload s3://%s/%s: %w", r.Bucket, key, err)
+	}
+	r.block = S3Obj{
+		Bucket: r.Bucket,
+		Object: types.Object{
+			Key:          &key,
+			Size:         aws.Int64(r.MinPartSize),
+			LastModified: &now,
+			ETag:         output.ETag,
+		},
+	}
+	return nil
+}
+
+// Close deletes the block of zeros when DeleteIntermediates is set, the
+// RecursiveConcat can't be used after.
+func (r *RecursiveConcat) Close(ctx context.Context) error {
+	if !r.DeleteIntermediates || r.block.Key == nil {
+		return nil
+	}
+	_, err := r.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    r.block.Key,
+	})
+	return err
+}
+
+func (r *RecursiveConcat) partsKey() string {
+	if r.PartsKey != "" {
+		return r.PartsKey
+	}
+	return JoinKey(r.DstPrefix, r.DstKey+".parts")
+}
+
+// deleteMerged deletes the intermediate objects of objectList, the ones
+// under PartsKey, once the object they were merged into is complete.
+func (r *RecursiveConcat) deleteMerged(ctx context.Context, objectList []*S3Obj, result *S3Obj) {
+	if !r.DeleteIntermediates {
+		return
+	}
+	prefix := r.partsKey() + "/"
+	for _, o := range objectList {
+		// a range, e.g. a header of the staged headers, is still used by
+		// the other merges
+		if o == &r.block || o.Slice || len(o.Data) > 0 || o.Bucket != r.Bucket || !strings.HasPrefix(*o.Key, prefix) || *o.Key == *result.Key {
+			continue
+		}
+		_, err := r.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(o.Bucket),
+			Key:    o.Key,
+		})
+		if err != nil {
+			Warnf(ctx, "unable to delete intermediate object s3://%s/%s: %s", o.Bucket, *o.Key, err.Error())
+		}
+	}
+}
+
+// NewRecursiveConcat returns a RecursiveConcat writing to options.Bucket and
+// uploads its block of zeros. optFns change the options after they are
+// checked.
+func NewRecursiveConcat(ctx context.Context, options RecursiveConcatOptions, optFns ...func(*RecursiveConcatOptions)) (*RecursiveConcat, error) {
+
+	options = options.Copy()
+
+	if err := checkRequiredArgs(&options); err != nil {
+		return nil, err
+	}
+
+	for _, fn := range optFns {
+		fn(&options)
+	}
+	if options.MinPartSize <= 0 {
+		options.MinPartSize = jobConfigFromContext(ctx).padSize
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 1
+	}
+
+	rc := &RecursiveConcat{
+		Client:      options.Client,
+		Region:      options.Region,
+		EndpointUrl: options.EndpointUrl,
+		Bucket:      options.Bucket,
+		DstPrefix:   options.DstPrefix,
+		DstKey:      options.DstKey,
+		PartsKey:    options.PartsKey,
+
+		MinPartSize:         options.MinPartSize,
+		MaxAttempts:         options.MaxAttempts,
+		DeleteIntermediates: options.DeleteIntermediates,
+	}
+	if err := rc.CreateFirstBlock(ctx); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+func (r *RecursiveConcat) uploadPart(ctx context.Context, object *S3Obj, uploadId string, bucket, key string, partNum int32) (types.CompletedPart, error) {
+
+	input := &s3.UploadPartInput{
+		Bucket:     &bucket,
+		Key:        &key,
+		PartNumber: aws.Int32(partNum),
+		UploadId:   &uploadId,
+		Body:       io.ReadSeeker(bytes.NewReader(object.Data)),
+	}
+
+	res, err := r.Client.UploadPart(ctx, input)
+	if err != nil {
+		return types.CompletedPart{}, err
+	}
+	return types.CompletedPart{
+		ETag:       res.ETag,
+		PartNumber: input.PartNumber}, nil
+}
+
+func (r *RecursiveConcat) uploadPartCopy(ctx context.Context, object *S3Obj, uploadId string, bucket, key string, partNum int32, start, end int64) (types.CompletedPart, error) {
+
+	input := s3.UploadPartCopyInput{
+		Bucket:          &bucket,
+		Key:             &key,
+		PartNumber:      aws.Int32(partNum),
+		UploadId:        &uploadId,
+		CopySource:      aws.String(copySource(object)),
+		CopySourceRange: aws.String(copySourceRange(object, start, end)),
+	}
+
+	res, err := r.Client.UploadPartCopy(ctx, &input)
+	if err != nil {
+		return types.CompletedPart{}, err
+	}
+
+	return types.CompletedPart{
+		ETag:       res.CopyPartResult.ETag,
+		PartNumber: input.PartNumber}, nil
+
+}
+
+// mergePair merges objectList into s3://bucket/key, up to MaxAttempts
+// times, waiting a little longer after every failure.
+func (r *RecursiveConcat) mergePair(ctx context.Context, objectList []*S3Obj, trim int64, bucket, key string) (*S3Obj, error) {
+	var complete *S3Obj
+	var err error
+	for attempt := 1; ; attempt++ {
+		complete, err = r.mergePairOnce(ctx, objectList, trim, bucket, key)
+		if err == nil || attempt >= r.MaxAttempts || ctx.Err() != nil {
+			return complete, err
+		}
+		Warnf(ctx, "merge into s3://%s/%s failed, attempt %d of %d: %s", bucket, key, attempt, r.MaxAttempts, err.Error())
+		audit(ctx, AuditRecord{Event: AuditRetry, Bucket: bucket, Key: key, Attempt: attempt, Error: err.Error()})
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+func (r *RecursiveConcat) mergePairOnce(ctx context.Context, objectList []*S3Obj, trim int64, bucket, key string) (*S3Obj, error) {
+	complete := NewS3Obj()
+
+	if len(objectList) > 2 {
+		return nil, fmt.Errorf("mergePair needs two or less *S3Obj")
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		ACL:    types.ObjectCannedACLBucketOwnerFullControl,
+	}
+	jobConfigFromContext(ctx).encryptUpload(input)
+	output, err := r.Client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return complete, err
+	}
+
+	uploadId := *output.UploadId
+	trackUpload(ctx, bucket, key, uploadId)
+	parts := []types.CompletedPart{}
+	var accumSize int64 = 0
+	var partNum int32 = 0
+	for _, o := range objectList {
+		if len(o.Data) > 0 {
+			// Debugf(ctx,"uploadPart key:%d", len(o.Data))
+			partNum += 1
+			part, err := r.uploadPart(ctx, o, uploadId, bucket, key, partNum)
+			if err != nil {
+				Debugf(ctx, "UploadPart of %d bytes to s3://%s/%s failed", len(o.Data), bucket, key)
+				return complete, err
+			}
+			parts = append(parts, part)
+			accumSize += int64(len(o.Data))
+		} else if *o.Size > 0 {
+			Debugf(ctx, "uploadPartCopy bucket:%s key:%s %d", o.Bucket, *o.Key, len(o.Data))
+			// objects over 5GiB are copied in several parts
+			for _, rng := range splitCopyRange(trim, *o.Size) {
+				partNum += 1
+				part, err := r.uploadPartCopy(ctx, o, uploadId, bucket, key, partNum, rng[0], rng[1])
+				if err != nil {
+					Debugf(ctx, "UploadPartCopy failed, uploadId: %s, bucket: %s, key: %s, start: %d, end: %d", uploadId, bucket, key, rng[0], rng[1])
+					return complete, err
+				}
+				traceCall(ctx, o, TraceCall{Op: "UploadPartCopy", Bucket: bucket, Key: key, PartNumber: partNum, Range: copySourceRange(o, rng[0], rng[1])})
+				parts = append(parts, part)
+			}
+			accumSize += int64(*o.Size) - trim
+		}
+	}
+
+	completeOutput, err := r.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		return complete, err
+	}
+	untrackUpload(ctx, uploadId)
+
+	now := time.Now()
+	complete = &S3Obj{
+		Bucket: *completeOutput.Bucket,
+		Object: types.Object{
+			Key:          completeOutput.Key,
+			ETag:         completeOutput.ETag,
+			Size:         &accumSize,
+			LastModified: &now,
+		},
+	}
+
+	return complete, nil
+}
+
+func calculateFinalSize(objectList []*S3Obj) int64 {
+	var accum int64 = 0
+	for _, v := range objectList {
+		accum += *v.Size
+	}
+	return accum
+}
+
+func (r *RecursiveConcat) ConcatObjects(ctx context.Context, objectList []*S3Obj, bucket, key string) (*S3Obj, error) {
+
+	// if calculateFinalSize(objectList) < fileSizeMin+1 {
+	// 	return &S3Obj{}, fmt.Errorf("Unable to concatenate these files, too small")
+	// }
+
+	if len(objectList) == 0 {
+		return NewS3Obj(), fmt.Errorf("no elements passed to concat")
+	}
+
+	merged := objectList
+	trimStart := false
+	if *objectList[0].Size < r.MinPartSize {
+		objectList = append([]*S3Obj{&r.block}, objectList...)
+		trimStart = true
+	}
+
+	accum := objectList[0]
+	for _, object := range objectList[1:] {
+		if object.Bucket == "" {
+			object.Bucket = bucket
+		}
+		var err error
+		Debugf(ctx, "accum: s3://%s/%s <- s3://%s/%s data %d", accum.Bucket, *accum.Key, object.Bucket, *object.Key, len(object.Data))
+		accum, err = r.mergePair(ctx, []*S3Obj{accum, object}, 0, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// sort.Slice(parts, func(i, j int) bool {
+	// 	return parts[i].PartNumber < parts[j].PartNumber
+	// })
+	// fmt.Printf("%+v", completeOutput)
+
+	if trimStart {
+		var err error
+		accum, err = r.mergePair(ctx, []*S3Obj{accum}, *r.block.Size, bucket, key)
+		if err != nil {
+			Debugf(ctx, "error 2\n%s %s", bucket, key)
+			return nil, err
+		}
+	}
+	r.deleteMerged(ctx, merged, accum)
+
+	return accum, nil
+}
+
+func checkRequiredArgs(o *RecursiveConcatOptions) error {
+	if o.Client == nil {
+		return fmt.Errorf("s3 client is required")
+	}
+	if o.Bucket == "" {
+		return fmt.Errorf("Bucket is required")
+	}
+	if o.DstPrefix == "" {
+		return fmt.Errorf("DstPrefix is required")
+	}
+	if o.Region == "" {
+		return fmt.Errorf("Region is required")
+	}
+	return nil
+}
+
+// Copy creates a clone where the APIOptions list is deep copied.
+func (o RecursiveConcatOptions) Copy() RecursiveConcatOptions {
+	to := o
+	return to
+}
@@ -0,0 +1,152 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestRecursiveConcatOptions(t *testing.T) {
+	ctx := context.Background()
+	newConcat := func(fail map[string]preflightFailure, ops *[]string, optFns ...func(*RecursiveConcatOptions)) *RecursiveConcat {
+		client := s3.New(s3.Options{
+			Region:       "us-east-1",
+			Credentials:  aws.AnonymousCredentials{},
+			HTTPClient:   preflightHTTPClient{fail: fail, ops: ops},
+			Retryer:      aws.NopRetryer{},
+			UsePathStyle: true,
+		})
+		rc, err := NewRecursiveConcat(ctx, RecursiveConcatOptions{
+			Client:    client,
+			Bucket:    "dst-bucket",
+			DstPrefix: "prefix",
+			DstKey:    "archive.tar",
+			PartsKey:  "prefix/archive.tar.parts",
+			Region:    "us-east-1",
+		}, optFns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rc
+	}
+	count := func(ops []string, op string) int {
+		n := 0
+		for _, o := range ops {
+			if o == op {
+				n++
+			}
+		}
+		return n
+	}
+	objects := func() []*S3Obj {
+		return []*S3Obj{
+			NewS3ObjOptions(WithBucketAndKey("dst-bucket", "prefix/archive.tar.parts/pair.1"), WithSize(2048)),
+			NewS3ObjOptions(WithBucketAndKey("src-bucket", "prefix/file.txt"), WithSize(2048)),
+		}
+	}
+
+	var ops []string
+	rc := newConcat(nil, &ops)
+	if rc.MinPartSize != defaultPadSize || rc.MaxAttempts != 1 || rc.DeleteIntermediates {
+		t.Errorf("unexpected defaults %d %d %t", rc.MinPartSize, rc.MaxAttempts, rc.DeleteIntermediates)
+	}
+
+	ops = nil
+	rc = newConcat(map[string]preflightFailure{"CompleteMultipartUpload": {http.StatusInternalServerError, "InternalError"}}, &ops, func(o *RecursiveConcatOptions) {
+		o.MinPartSize = 1024
+		o.MaxAttempts = 2
+	})
+	if *rc.block.Size != 1024 {
+		t.Errorf("block size = %d, want 1024", *rc.block.Size)
+	}
+	if _, err := rc.ConcatObjects(ctx, objects(), "dst-bucket", "prefix/archive.tar"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := count(ops, "CreateMultipartUpload"); n != 2 {
+		t.Errorf("the merge was tried %d times, want 2", n)
+	}
+
+	for _, deleteIntermediates := range []bool{false, true} {
+		ops = nil
+		rc = newConcat(nil, &ops, func(o *RecursiveConcatOptions) {
+			o.MinPartSize = 1024
+			o.DeleteIntermediates = deleteIntermediates
+		})
+		if _, err := rc.ConcatObjects(ctx, objects(), "dst-bucket", "prefix/archive.tar"); err != nil {
+			t.Fatal(err)
+		}
+		if err := rc.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// the intermediate pair and the block are deleted, not the source
+		want := 0
+		if deleteIntermediates {
+			want = 2
+		}
+		if n := count(ops, "DELETE"); n != want {
+			t.Errorf("DeleteIntermediates %t: %d deletes, want %d: %s", deleteIntermediates, n, want, strings.Join(ops, ","))
+		}
+	}
+}
+
+func TestRecursiveConcatErrors(t *testing.T) {
+	ctx := context.Background()
+	newClient := func(fail map[string]preflightFailure, ops *[]string) *s3.Client {
+		return s3.New(s3.Options{
+			Region:       "us-east-1",
+			Credentials:  aws.AnonymousCredentials{},
+			HTTPClient:   preflightHTTPClient{fail: fail, ops: ops},
+			Retryer:      aws.NopRetryer{},
+			UsePathStyle: true,
+		})
+	}
+	options := RecursiveConcatOptions{
+		Client:    newClient(map[string]preflightFailure{"PUT": {http.StatusForbidden, "AccessDenied"}}, nil),
+		Bucket:    "dst-bucket",
+		DstPrefix: "prefix",
+		DstKey:    "archive.tar",
+		Region:    "us-east-1",
+	}
+	// the block of zeros can't be uploaded
+	if _, err := NewRecursiveConcat(ctx, options); apiErrorCode(err) != "AccessDenied" {
+		t.Errorf("NewRecursiveConcat() = %v, want AccessDenied", err)
+	}
+	options.Bucket = ""
+	if _, err := NewRecursiveConcat(ctx, options); err == nil {
+		t.Error("NewRecursiveConcat() without a bucket succeeded")
+	}
+
+	// the wait before the next attempt stops with the context
+	var ops []string
+	options.Bucket = "dst-bucket"
+	options.Client = newClient(map[string]preflightFailure{"CompleteMultipartUpload": {http.StatusInternalServerError, "InternalError"}}, &ops)
+	rc, err := NewRecursiveConcat(ctx, options, func(o *RecursiveConcatOptions) {
+		o.MinPartSize = 1024
+		o.MaxAttempts = 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	objects := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("dst-bucket", "prefix/archive.tar.parts/pair.1"), WithSize(2048)),
+		NewS3ObjOptions(WithBucketAndKey("src-bucket", "prefix/file.txt"), WithSize(2048)),
+	}
+	if _, err := rc.ConcatObjects(cctx, objects, "dst-bucket", "prefix/archive.tar"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ConcatObjects() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 900*time.Millisecond {
+		t.Errorf("ConcatObjects() returned after %s", d)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Policies for the objects archived under the name of an earlier entry, see
+// S3TarS3Options.OnConflict.
+const (
+	// ConflictSuffix renames the later entries, b.txt becomes b.1.txt.
+	ConflictSuffix = "suffix"
+	// ConflictFail fails the archive before anything is uploaded.
+	ConflictFail = "fail"
+	// ConflictKeepFirst leaves the later objects out of the archive.
+	ConflictKeepFirst = "keep-first"
+)
+
+// conflictName returns name with the suffix .n before its extension.
+func conflictName(name string, n int) string {
+	ext := path.Ext(name)
+	if ext == path.Base(name) {
+		// a dotfile, e.g. .bashrc, has no extension
+		ext = ""
+	}
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// resolveConflicts finds the objects of objectList archived under the name
+// of an earlier entry, e.g. the same key listed from two buckets, and applies
+// policy. Without a policy the conflicts are only logged, tar extracts the
+// last entry of a name over the previous ones.
+func resolveConflicts(ctx context.Context, objectList []*S3Obj, policy string) ([]*S3Obj, error) {
+	names := make(map[string]bool, len(objectList))
+	var conflicts []int
+	for i, o := range objectList {
+		name := o.entryName()
+		if names[name] {
+			conflicts = append(conflicts, i)
+			continue
+		}
+		names[name] = true
+	}
+	if len(conflicts) == 0 {
+		return objectList, nil
+	}
+	first := objectList[conflicts[0]]
+	switch policy {
+	case ConflictFail:
+		return nil, fmt.Errorf("%d objects are archived under the name of another entry, the first one is %q from %s", len(conflicts), first.entryName(), first.Bucket)
+	case ConflictKeepFirst:
+		Warnf(ctx, "skipping %d objects archived under the name of another entry", len(conflicts))
+		kept := make([]*S3Obj, 0, len(objectList)-len(conflicts))
+		next := 0
+		for i, o := range objectList {
+			if next < len(conflicts) && conflicts[next] == i {
+				next++
+				Warnf(ctx, "duplicate entry %q from %s, keeping the first one", o.entryName(), o.Bucket)
+				r := auditObject(AuditSkipped, "", o)
+				r.Reason = "duplicate entry name"
+				audit(ctx, r)
+				continue
+			}
+			kept = append(kept, o)
+		}
+		return kept, nil
+	case ConflictSuffix:
+		// the objects are copied, objectList may be archived again
+		renamed := make([]*S3Obj, len(objectList))
+		copy(renamed, objectList)
+		for _, i := range conflicts {
+			o := objectList[i]
+			name := o.entryName()
+			n := 1
+			for names[conflictName(name, n)] {
+				n++
+			}
+			c := *o
+			c.Name = conflictName(name, n)
+			names[c.Name] = true
+			renamed[i] = &c
+			Infof(ctx, "duplicate entry %q from %s archived as %q", name, o.Bucket, c.Name)
+		}
+		return renamed, nil
+	}
+	Warnf(ctx, "%d objects are archived under the name of another entry, the first one is %q from %s, see --on-conflict", len(conflicts), first.entryName(), first.Bucket)
+	return objectList, nil
+}
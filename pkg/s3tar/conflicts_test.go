@@ -0,0 +1,51 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	ctx := context.Background()
+	objectList := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("bucket-a", "data/b.txt")),
+		NewS3ObjOptions(WithBucketAndKey("bucket-b", "data/b.txt")),
+		NewS3ObjOptions(WithBucketAndKey("bucket-a", "data/b.1.txt")),
+		NewS3ObjOptions(WithBucketAndKey("bucket-c", "data/b.txt")),
+		NewS3ObjOptions(WithBucketAndKey("bucket-a", ".bashrc")),
+		NewS3ObjOptions(WithBucketAndKey("bucket-b", ".bashrc")),
+	}
+	if got, err := resolveConflicts(ctx, objectList, ""); err != nil || len(got) != 6 {
+		t.Errorf("no policy: %d objects, err %v", len(got), err)
+	}
+	if _, err := resolveConflicts(ctx, objectList, ConflictFail); err == nil || !strings.Contains(err.Error(), "bucket-b") {
+		t.Errorf("expected an error naming the first duplicate, got %v", err)
+	}
+
+	got, err := resolveConflicts(ctx, objectList, ConflictKeepFirst)
+	if err != nil || len(got) != 3 {
+		t.Fatalf("keep-first: %d objects, err %v", len(got), err)
+	}
+	for i, want := range []string{"bucket-a", "bucket-a", "bucket-a"} {
+		if got[i].Bucket != want {
+			t.Errorf("keep-first %d: got %s", i, got[i].Bucket)
+		}
+	}
+
+	got, err = resolveConflicts(ctx, objectList, ConflictSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"data/b.txt", "data/b.2.txt", "data/b.1.txt", "data/b.3.txt", ".bashrc", ".bashrc.1"} {
+		if name := got[i].entryName(); name != want {
+			t.Errorf("suffix %d: got %q, want %q", i, name, want)
+		}
+	}
+	if objectList[1].Name != "" {
+		t.Errorf("the objects of the list should be copied")
+	}
+}
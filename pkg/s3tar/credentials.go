@@ -0,0 +1,187 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrCredentialsExpired is the cause of the cancellation of a run whose
+// credentials expired and couldn't be refreshed, see WithCredentialsRefresh.
+// Like on SIGTERM, the run writes its checkpoint and returns ErrInterrupted.
+var ErrCredentialsExpired = errors.New("the credentials expired and can't be refreshed")
+
+const (
+	contextKeySourceRead         = contextKey("sourceRead")
+	contextKeyCredentialsExpired = contextKey("credentialsExpired")
+)
+
+// expiredTokenCodes are the errors of the requests signed with expired
+// credentials.
+var expiredTokenCodes = []string{"ExpiredToken", "ExpiredTokenException", "TokenRefreshRequired"}
+
+// routedCredentials retrieves the source credentials for the requests marked
+// by WithSourceCredentials, the destination credentials otherwise.
+type routedCredentials struct {
+	src aws.CredentialsProvider
+	dst aws.CredentialsProvider
+}
+
+func (c *routedCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if read, _ := ctx.Value(contextKeySourceRead).(bool); read {
+		return c.src.Retrieve(ctx)
+	}
+	return c.dst.Retrieve(ctx)
+}
+
+func (c *routedCredentials) Invalidate() {
+	invalidate(c.src)
+	invalidate(c.dst)
+}
+
+// invalidate drops the credentials cached by p, if any.
+func invalidate(p aws.CredentialsProvider) {
+	if c, ok := p.(interface{ Invalidate() }); ok {
+		c.Invalidate()
+	}
+}
+
+// WithSourceCredentials signs the reads of the source with src instead of
+// the credentials of the client, for the security models where no principal
+// can both read the source and write the destination. The List, Head and Get
+// requests to any bucket but dstBucket use src; the other requests and every
+// request to dstBucket use the credentials of the client. Use it when
+// creating the client:
+//
+//	svc := s3.NewFromConfig(dstCfg, s3tar.WithSourceCredentials(srcCfg.Credentials, "dst-bucket"))
+//
+// UploadPartCopy is a write of the destination: the destination principal
+// still needs s3:GetObject on the source of the server-side copies. Only the
+// archives built in memory, with ConcatInMemory, download the source with src
+// and upload it with the client credentials.
+func WithSourceCredentials(src aws.CredentialsProvider, dstBucket string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.Credentials = &routedCredentials{src: src, dst: o.Credentials}
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3TarSourceCredentials",
+				func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					if bucket, ok := readBucket(in.Parameters); ok && bucket != dstBucket {
+						ctx = context.WithValue(ctx, contextKeySourceRead, true)
+					}
+					return next.HandleInitialize(ctx, in)
+				}), middleware.Before)
+		})
+	}
+}
+
+// readBucket returns the bucket of the requests that only read.
+func readBucket(params interface{}) (string, bool) {
+	switch input := params.(type) {
+	case *s3.ListObjectsV2Input:
+		return aws.ToString(input.Bucket), true
+	case *s3.ListObjectVersionsInput:
+		return aws.ToString(input.Bucket), true
+	case *s3.HeadObjectInput:
+		return aws.ToString(input.Bucket), true
+	case *s3.GetObjectInput:
+		return aws.ToString(input.Bucket), true
+	case *s3.GetObjectTaggingInput:
+		return aws.ToString(input.Bucket), true
+	case *s3.GetObjectAclInput:
+		return aws.ToString(input.Bucket), true
+	case *s3.GetObjectAttributesInput:
+		return aws.ToString(input.Bucket), true
+	case *s3.HeadBucketInput:
+		return aws.ToString(input.Bucket), true
+	}
+	return "", false
+}
+
+// WithCredentialsRefresh makes the multi-hour runs survive the expiry of
+// their credentials. The providers of the AWS config, e.g. a role, SSO, a
+// credential_process or the instance profile, refresh the credentials
+// before they expire. A request rejected with an expired token drops the
+// cached credentials and is sent once more with new ones, for the providers
+// that don't tell when their credentials expire. When the credentials can't be
+// refreshed, e.g. a session token in the environment, the request fails with
+// ErrCredentialsExpired and the run stops with a checkpoint. Use it when
+// creating the client, after the options that set the credentials:
+//
+//	svc := s3.NewFromConfig(cfg, s3tar.WithCredentialsRefresh)
+func WithCredentialsRefresh(o *s3.Options) {
+	creds := o.Credentials
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		refresh := middleware.FinalizeMiddlewareFunc("S3TarCredentialsRefresh",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				out, metadata, err := next.HandleFinalize(ctx, in)
+				if _, ok := creds.(interface{ Invalidate() }); !ok || !isExpiredToken(err) {
+					return out, metadata, err
+				}
+				// the credentials are retrieved once per request, before
+				// the retries
+				invalidate(creds)
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					if err := req.RewindStream(); err != nil {
+						return out, metadata, err
+					}
+				}
+				return next.HandleFinalize(ctx, in)
+			})
+		// the last client option sets the credentials to refresh
+		if _, ok := stack.Finalize.Get(refresh.ID()); ok {
+			if _, err := stack.Finalize.Swap(refresh.ID(), refresh); err != nil {
+				return err
+			}
+		} else if err := stack.Finalize.Add(refresh, middleware.Before); err != nil {
+			return err
+		}
+		if _, ok := stack.Initialize.Get("S3TarCredentialsExpired"); ok {
+			return nil
+		}
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3TarCredentialsExpired",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				var signErr *v4.SigningError
+				if (isExpiredToken(err) || errors.As(err, &signErr)) && ctx.Err() == nil {
+					if stop, ok := ctx.Value(contextKeyCredentialsExpired).(context.CancelCauseFunc); ok {
+						stop(ErrCredentialsExpired)
+					}
+					err = fmt.Errorf("%w: %w", ErrCredentialsExpired, err)
+				}
+				return out, metadata, err
+			}), middleware.Before)
+	})
+}
+
+// withCredentialsExpiry returns a copy of ctx canceled with
+// ErrCredentialsExpired by the clients created with WithCredentialsRefresh
+// when the credentials expired.
+func withCredentialsExpiry(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return context.WithValue(ctx, contextKeyCredentialsExpired, cancel), func() { cancel(nil) }
+}
+
+// isExpiredToken reports whether err is a request rejected for an expired
+// token.
+func isExpiredToken(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range expiredTokenCodes {
+		if apiErr.ErrorCode() == code {
+			return true
+		}
+	}
+	return false
+}
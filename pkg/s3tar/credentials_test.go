@@ -0,0 +1,176 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// signerHTTPClient answers every request with 200 and records the access
+// key that signed it.
+type signerHTTPClient struct {
+	keys *[]string
+}
+
+func (c signerHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	auth := r.Header.Get("Authorization")
+	key := ""
+	if i := strings.Index(auth, "Credential="); i >= 0 {
+		key, _, _ = strings.Cut(auth[i+len("Credential="):], "/")
+	}
+	*c.keys = append(*c.keys, key)
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+func TestWithSourceCredentials(t *testing.T) {
+	ctx := context.Background()
+	var keys []string
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("DST", "secret", ""),
+		HTTPClient:   signerHTTPClient{keys: &keys},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	}, WithSourceCredentials(credentials.NewStaticCredentialsProvider("SRC", "secret", ""), "dst-bucket"))
+
+	requests := []struct {
+		name string
+		send func() error
+		key  string
+	}{
+		{"list the source", func() error {
+			_, err := svc.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("src-bucket")})
+			return err
+		}, "SRC"},
+		{"head a source object", func() error {
+			_, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("src-bucket"), Key: aws.String("a")})
+			return err
+		}, "SRC"},
+		{"get a source object", func() error {
+			out, err := svc.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("src-bucket"), Key: aws.String("a")})
+			if err == nil {
+				out.Body.Close()
+			}
+			return err
+		}, "SRC"},
+		{"head the archive", func() error {
+			_, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("dst-bucket"), Key: aws.String("archive.tar")})
+			return err
+		}, "DST"},
+		{"write the destination", func() error {
+			_, err := svc.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("dst-bucket"), Key: aws.String("archive.tar"), Body: strings.NewReader("")})
+			return err
+		}, "DST"},
+		{"write another bucket", func() error {
+			_, err := svc.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("log-bucket"), Key: aws.String("audit.jsonl"), Body: strings.NewReader("")})
+			return err
+		}, "DST"},
+		{"copy from the source", func() error {
+			_, err := svc.UploadPartCopy(ctx, &s3.UploadPartCopyInput{Bucket: aws.String("dst-bucket"), Key: aws.String("archive.tar"), UploadId: aws.String("1"), PartNumber: aws.Int32(1), CopySource: aws.String("src-bucket/a")})
+			return err
+		}, "DST"},
+	}
+	for _, r := range requests {
+		keys = nil
+		// the empty answers can't always be parsed, only the signature matters
+		r.send()
+		if len(keys) != 1 || keys[0] != r.key {
+			t.Errorf("%s: signed with %v, want %s", r.name, keys, r.key)
+		}
+	}
+}
+
+// expiringHTTPClient rejects the requests signed with the access keys in
+// expired, and records the body of the last request accepted.
+type expiringHTTPClient struct {
+	expired map[string]bool
+	body    *string
+}
+
+func (c expiringHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	auth := r.Header.Get("Authorization")
+	for key := range c.expired {
+		if strings.Contains(auth, "Credential="+key+"/") {
+			body := "<Error><Code>ExpiredToken</Code><Message>The provided token has expired.</Message></Error>"
+			return &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+		}
+	}
+	if r.Body != nil {
+		data, _ := io.ReadAll(r.Body)
+		*c.body = string(data)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+func TestWithCredentialsRefresh(t *testing.T) {
+	// a credential_process without an expiration, cached until invalidated
+	var retrieved atomic.Int32
+	creds := aws.NewCredentialsCache(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		n := retrieved.Add(1)
+		return aws.Credentials{AccessKeyID: fmt.Sprintf("KEY%d", n), SecretAccessKey: "secret", SessionToken: "token"}, nil
+	}))
+	var body string
+	client := expiringHTTPClient{expired: map[string]bool{"KEY1": true}, body: &body}
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  creds,
+		HTTPClient:   client,
+		Retryer:      NewRetryer(3, 0),
+		UsePathStyle: true,
+	}, WithCredentialsRefresh)
+
+	ctx, stop := withCredentialsExpiry(context.Background())
+	defer stop()
+	input := &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("archive.tar"), Body: strings.NewReader("data")}
+	if _, err := svc.PutObject(ctx, input); err != nil {
+		t.Fatalf("PutObject() with refreshed credentials = %v", err)
+	}
+	if n := retrieved.Load(); n != 2 {
+		t.Errorf("the credentials were retrieved %d times, want 2", n)
+	}
+	if body != "data" {
+		t.Errorf("the body sent again = %q", body)
+	}
+
+	// static session credentials can't be refreshed
+	svc = s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("KEY1", "secret", "token"),
+		HTTPClient:   client,
+		Retryer:      NewRetryer(3, 0),
+		UsePathStyle: true,
+	}, WithCredentialsRefresh)
+	_, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a")})
+	if !errors.Is(err, ErrCredentialsExpired) {
+		t.Errorf("HeadObject() with expired credentials = %v", err)
+	}
+	if !errors.Is(context.Cause(ctx), ErrCredentialsExpired) {
+		t.Errorf("the run wasn't stopped, cause %v", context.Cause(ctx))
+	}
+
+	// the routed credentials are refreshed too
+	retrieved.Store(0)
+	creds.Invalidate()
+	svc = s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  creds,
+		HTTPClient:   client,
+		Retryer:      NewRetryer(3, 0),
+		UsePathStyle: true,
+	}, WithSourceCredentials(credentials.NewStaticCredentialsProvider("SRC", "secret", ""), "bucket"), WithCredentialsRefresh)
+	if _, err := svc.PutObject(context.Background(), input); err != nil || retrieved.Load() != 2 {
+		t.Errorf("PutObject() with routed credentials = %v, retrieved %d times", err, retrieved.Load())
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// dedupObjectList replaces every object that has the same ETag and size as an
+// earlier object with a zero-length hardlink entry pointing at the first copy.
+// Objects without an ETag are never considered duplicates.
+func dedupObjectList(ctx context.Context, objectList []*S3Obj) []*S3Obj {
+	seen := map[string]*S3Obj{}
+	deduped := make([]*S3Obj, len(objectList))
+	var saved int64
+	var links int
+	for i, o := range objectList {
+		deduped[i] = o
+		if o.ETag == nil || *o.ETag == "" || o.Size == nil || *o.Size == 0 {
+			continue
+		}
+		id := fmt.Sprintf("%s-%d", *o.ETag, *o.Size)
+		if o.Slice {
+			// slices of an object share its ETag
+			id = fmt.Sprintf("%s-%d-%d", *o.ETag, o.Offset, *o.Size)
+		}
+		first, ok := seen[id]
+		if !ok {
+			seen[id] = o
+			continue
+		}
+		link := &S3Obj{
+			Object:     o.Object,
+			Bucket:     o.Bucket,
+			PartNum:    o.PartNum,
+			Name:       o.Name,
+			LinkTarget: first.entryName(),
+		}
+		link.Size = aws.Int64(0)
+		deduped[i] = link
+		saved += *o.Size
+		links += 1
+		Debugf(ctx, "dedup: %s -> %s", o.entryName(), first.entryName())
+	}
+	if links > 0 {
+		Infof(ctx, "dedup: replaced %d duplicate objects with hardlinks, saving %s", links, formatBytes(saved))
+	}
+	return deduped
+}
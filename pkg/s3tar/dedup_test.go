@@ -0,0 +1,52 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/csv"
+	"testing"
+)
+
+func TestDedupObjectList(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	objectList := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("bucket", "a.txt"), WithSize(100), WithETag("etag-1")),
+		NewS3ObjOptions(WithBucketAndKey("bucket", "b.txt"), WithSize(100), WithETag("etag-2")),
+		NewS3ObjOptions(WithBucketAndKey("bucket", "c.txt"), WithSize(100), WithETag("etag-1")),
+		NewS3ObjOptions(WithBucketAndKey("bucket", "d.txt"), WithSize(200), WithETag("etag-1")),
+		NewS3ObjOptions(WithBucketAndKey("bucket", "e.txt"), WithSize(100)),
+		NewS3ObjOptions(WithBucketAndKey("bucket", "f.txt"), WithSize(100)),
+	}
+
+	got := dedupObjectList(ctx, objectList)
+	wantLinks := []string{"", "", "a.txt", "", "", ""}
+	for i, o := range got {
+		if o.LinkTarget != wantLinks[i] {
+			t.Errorf("%s: LinkTarget = %q, want %q", *o.Key, o.LinkTarget, wantLinks[i])
+		}
+	}
+	if *got[2].Size != 0 {
+		t.Errorf("hardlink size = %d, want 0", *got[2].Size)
+	}
+	if *objectList[2].Size != 100 {
+		t.Errorf("dedup modified the source object")
+	}
+
+	headers, err := defaultJobConfig.buildHeaders(got, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := defaultJobConfig.createCSVTOC(0, headers, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[2][1] != records[0][1] || records[2][2] != records[0][2] {
+		t.Errorf("hardlink TOC entry %v does not point at %v", records[2], records[0])
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// addSHA256Digests sets the SHA256 of every object so the digest is recorded
+// in the TOC.
+func addSHA256Digests(ctx context.Context, svc Backend, objectList []*S3Obj, threads int) error {
+	Infof(ctx, "computing sha256 digests")
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for _, o := range objectList {
+		o := o
+		g.Go(func() error {
+			digest, err := objectSHA256(ctx, svc, o)
+			if err != nil {
+				return fmt.Errorf("unable to compute sha256 of s3://%s/%s: %w", o.Bucket, *o.Key, err)
+			}
+			o.SHA256 = digest
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// objectSHA256 returns the hex encoded SHA-256 of an object. The checksum
+// stored by S3 is used when the object was uploaded in a single part with a
+// SHA256 checksum, otherwise the object is streamed and hashed.
+func objectSHA256(ctx context.Context, svc Backend, obj *S3Obj) (string, error) {
+	if len(obj.Data) > 0 {
+		sum := sha256.Sum256(obj.Data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	// the checksum of the object isn't the one of a slice
+	if !obj.Slice {
+		attrs, err := getObjectAttributes(ctx, svc, obj)
+		if err == nil && attrs.ChecksumSHA256 != "" && attrs.FullObjectChecksum() {
+			if digest, err := base64ToHex(attrs.ChecksumSHA256); err == nil {
+				return digest, nil
+			}
+		}
+	}
+
+	Debugf(ctx, "streaming s3://%s/%s to compute the sha256", obj.Bucket, *obj.Key)
+	r, err := getObjectVersion(ctx, svc, obj)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// base64ToHex converts the base64 checksums returned by S3 to hex.
+func base64ToHex(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,87 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestTOCSHA256Column(t *testing.T) {
+	data := []byte("hello world")
+	o := NewS3ObjOptions(WithBucketAndKey("bucket", "hello.txt"), WithSize(int64(len(data))), WithETag("etag"))
+	o.Data = data
+	digest, err := objectSHA256(context.TODO(), nil, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	if digest != want {
+		t.Fatalf("digest = %s, want %s", digest, want)
+	}
+	o.SHA256 = digest
+
+	objectList := []*S3Obj{o}
+	headers, err := defaultJobConfig.buildHeaders(objectList, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := defaultJobConfig.createCSVTOC(0, headers, objectList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records[0]) != 5 || records[0][4] != want {
+		t.Errorf("toc record = %v", records[0])
+	}
+
+	// S3 returns base64 checksums
+	part := types.CompletedPart{ChecksumSHA256: aws.String("uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=")}
+	if err := verifyPartSHA256(part, want); err != nil {
+		t.Error(err)
+	}
+	if err := verifyPartSHA256(part, "00"+want[2:]); err == nil {
+		t.Error("expected a mismatch")
+	}
+}
+
+func TestVerifyRangeSHA256(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBackend()
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	m.Put("bucket", "archive.tar", data)
+	// an entry of 600 bytes at 100 copied in three parts
+	ranges := [][2]int64{{100, 350}, {350, 600}, {600, 700}}
+	parts := make([]types.CompletedPart, len(ranges))
+	for i, rng := range ranges {
+		sum := sha256.Sum256(data[rng[0]:rng[1]])
+		parts[i].ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	sum := sha256.Sum256(data[100:700])
+	digest := hex.EncodeToString(sum[:])
+	if err := verifyRangeSHA256(ctx, m, "bucket", "archive.tar", ranges, parts, digest); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRangeSHA256(ctx, m, "bucket", "archive.tar", ranges, parts, "00"+digest[2:]); err == nil {
+		t.Error("expected a mismatch of the entry")
+	}
+	// a part S3 copied differently
+	parts[1].ChecksumSHA256 = parts[0].ChecksumSHA256
+	if err := verifyRangeSHA256(ctx, m, "bucket", "archive.tar", ranges, parts, digest); err == nil {
+		t.Error("expected a mismatch of the second part")
+	}
+}
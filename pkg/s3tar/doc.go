@@ -0,0 +1,24 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package s3tar creates, lists and extracts tar archives of objects in
+// Amazon S3 without downloading them, it's the library behind the s3tar
+// command.
+//
+// Archiver, created with NewArchiveClient, is the entry point:
+//
+//	client := s3tar.NewArchiveClient(s3.NewFromConfig(cfg))
+//	err := client.Create(ctx, &s3tar.S3TarS3Options{
+//		SrcBucket: "bucket",
+//		SrcPrefix: "files/",
+//		DstBucket: "bucket",
+//		DstKey:    "archives/files.tar",
+//		DstPrefix: "archives",
+//		Region:    "us-east-1",
+//	})
+//
+// The exported identifiers follow semantic versioning with the module
+// tags: they don't change in a backward incompatible way within a major
+// version. The s3tar command is built on the same API and is the reference
+// for how the options are combined.
+package s3tar
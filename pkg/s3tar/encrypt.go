@@ -0,0 +1,270 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Object metadata of client-side encrypted archives.
+const (
+	metaEncryption = "s3tar-encryption"
+	metaNonce      = "s3tar-nonce"
+	metaKeyWrap    = "s3tar-key-wrap"
+	metaWrappedKey = "s3tar-wrapped-key"
+	metaParts      = "s3tar-parts"
+
+	encryptionPartsGCM = "aes-256-gcm-parts"
+	keyWrapKMS         = "kms"
+	keyWrapAge         = "age"
+)
+
+// KMSAPI is the part of the KMS client used for client-side encryption.
+type KMSAPI interface {
+	GenerateDataKey(context.Context, *kms.GenerateDataKeyInput, ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(context.Context, *kms.DecryptInput, ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// ClientEncryption encrypts the archive before it is uploaded. Every part of
+// the multipart upload is sealed with AES-256-GCM under a random data key,
+// with the number of parts and whether it is the last one as additional
+// data, so an archive missing its last parts doesn't decrypt. The data key
+// is wrapped with a KMS key or age recipients and stored in the metadata of
+// the archive. Decrypt it with DecryptArchive.
+type ClientEncryption struct {
+	// KMS and KMSKeyID wrap the data key with kms:GenerateDataKey. KMS is
+	// also used to unwrap it.
+	KMS      KMSAPI
+	KMSKeyID string
+	// AgeRecipients wrap the data key, e.g. age1... public keys.
+	AgeRecipients []age.Recipient
+	// AgeIdentities unwrap the data key when decrypting.
+	AgeIdentities []age.Identity
+}
+
+type archiveCipher struct {
+	aead     cipher.AEAD
+	prefix   []byte
+	parts    int32
+	metadata map[string]string
+}
+
+// newArchiveCipher generates and wraps the data key of an archive of parts
+// parts.
+func newArchiveCipher(ctx context.Context, enc *ClientEncryption, parts int32) (*archiveCipher, error) {
+	var key, wrapped []byte
+	var wrap string
+	switch {
+	case enc.KMSKeyID != "" && len(enc.AgeRecipients) > 0:
+		return nil, fmt.Errorf("client-side encryption takes a KMS key or age recipients, not both")
+	case enc.KMSKeyID != "":
+		if enc.KMS == nil {
+			return nil, fmt.Errorf("a KMS client is required to encrypt with %s", enc.KMSKeyID)
+		}
+		output, err := enc.KMS.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+			KeyId:   aws.String(enc.KMSKeyID),
+			KeySpec: kmstypes.DataKeySpecAes256,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate a data key with %s: %w", enc.KMSKeyID, err)
+		}
+		key, wrapped, wrap = output.Plaintext, output.CiphertextBlob, keyWrapKMS
+	case len(enc.AgeRecipients) > 0:
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		w, err := age.Encrypt(&buf, enc.AgeRecipients...)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(key); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		wrapped, wrap = buf.Bytes(), keyWrapAge
+	default:
+		return nil, fmt.Errorf("client-side encryption needs a KMS key or age recipients")
+	}
+
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	c, err := newPartsCipher(key, prefix, parts)
+	if err != nil {
+		return nil, err
+	}
+	c.metadata = map[string]string{
+		metaEncryption: encryptionPartsGCM,
+		metaNonce:      base64.StdEncoding.EncodeToString(prefix),
+		metaKeyWrap:    wrap,
+		metaWrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		metaParts:      strconv.Itoa(int(parts)),
+	}
+	// S3 limits the user metadata to 2KB
+	if len(c.metadata[metaWrappedKey]) > 1800 {
+		return nil, fmt.Errorf("the wrapped data key is too large for the object metadata, use fewer age recipients")
+	}
+	return c, nil
+}
+
+func newPartsCipher(key, prefix []byte, parts int32) (*archiveCipher, error) {
+	if parts < 1 {
+		return nil, fmt.Errorf("an archive has at least one part, got %d", parts)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveCipher{aead: aead, prefix: prefix, parts: parts}, nil
+}
+
+// nonce is the random prefix of the archive followed by the part number, so
+// the parts can be sealed in parallel without sharing a counter.
+func (c *archiveCipher) nonce(partNum int32) []byte {
+	nonce := make([]byte, 0, c.aead.NonceSize())
+	nonce = append(nonce, c.prefix...)
+	return binary.BigEndian.AppendUint32(nonce, uint32(partNum))
+}
+
+// additionalData is the number of parts of the archive and a last part
+// flag. A part opens only with the part count it was sealed with, and only
+// the last part opens as the last one: dropping parts fails.
+func (c *archiveCipher) additionalData(partNum int32) []byte {
+	ad := binary.BigEndian.AppendUint32(nil, uint32(c.parts))
+	if partNum == c.parts {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+func (c *archiveCipher) seal(partNum int32, data []byte) []byte {
+	return c.aead.Seal(nil, c.nonce(partNum), data, c.additionalData(partNum))
+}
+
+func (c *archiveCipher) open(partNum int32, data []byte) ([]byte, error) {
+	plain, err := c.aead.Open(nil, c.nonce(partNum), data, c.additionalData(partNum))
+	if err != nil {
+		return nil, fmt.Errorf("part %d: %w", partNum, err)
+	}
+	return plain, nil
+}
+
+// openArchiveCipher unwraps the data key stored in the metadata of an archive.
+func openArchiveCipher(ctx context.Context, enc *ClientEncryption, metadata map[string]string) (*archiveCipher, error) {
+	if metadata[metaEncryption] != encryptionPartsGCM {
+		return nil, fmt.Errorf("the archive isn't client-side encrypted")
+	}
+	prefix, err := base64.StdEncoding.DecodeString(metadata[metaNonce])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", metaNonce, err)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(metadata[metaWrappedKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", metaWrappedKey, err)
+	}
+	parts, err := strconv.ParseInt(metadata[metaParts], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", metaParts, err)
+	}
+	var key []byte
+	switch metadata[metaKeyWrap] {
+	case keyWrapKMS:
+		if enc.KMS == nil {
+			return nil, fmt.Errorf("the data key is wrapped with KMS, a KMS client is required")
+		}
+		output, err := enc.KMS.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: wrapped})
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt the data key: %w", err)
+		}
+		key = output.Plaintext
+	case keyWrapAge:
+		if len(enc.AgeIdentities) == 0 {
+			return nil, fmt.Errorf("the data key is wrapped with age, an age identity is required")
+		}
+		r, err := age.Decrypt(bytes.NewReader(wrapped), enc.AgeIdentities...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt the data key: %w", err)
+		}
+		if key, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown key wrap %q", metadata[metaKeyWrap])
+	}
+	return newPartsCipher(key, prefix, int32(parts))
+}
+
+// DecryptArchive writes the plaintext tar of a client-side encrypted archive
+// to w. The parts are read with GetObject and their part numbers, so the
+// archive must keep the part layout it was uploaded with. The number of
+// parts is the one the archive was sealed with, an archive with fewer or
+// more parts fails.
+func DecryptArchive(ctx context.Context, svc *s3.Client, bucket, key string, enc *ClientEncryption, w io.Writer) error {
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key),
+		PartNumber: aws.Int32(1),
+	})
+	if err != nil {
+		return err
+	}
+	c, err := openArchiveCipher(ctx, enc, head.Metadata)
+	if err != nil {
+		return fmt.Errorf("s3://%s/%s: %w", bucket, key, err)
+	}
+	parts := aws.ToInt32(head.PartsCount)
+	if parts == 0 {
+		parts = 1
+	}
+	if parts != c.parts {
+		return fmt.Errorf("s3://%s/%s has %d parts, it was encrypted with %d", bucket, key, parts, c.parts)
+	}
+	for partNum := int32(1); partNum <= c.parts; partNum++ {
+		input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+		if head.PartsCount != nil {
+			input.PartNumber = aws.Int32(partNum)
+		}
+		output, err := svc.GetObject(ctx, input)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(limitReader(ctx, output.Body))
+		output.Body.Close()
+		if err != nil {
+			return err
+		}
+		plain, err := c.open(partNum, data)
+		if err != nil {
+			return fmt.Errorf("s3://%s/%s: %w", bucket, key, err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// fakeKMS wraps the data keys by reversing them.
+type fakeKMS struct{}
+
+func (fakeKMS) GenerateDataKey(_ context.Context, input *kms.GenerateDataKeyInput, _ ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &kms.GenerateDataKeyOutput{KeyId: input.KeyId, Plaintext: key, CiphertextBlob: reversed(key)}, nil
+}
+
+func (fakeKMS) Decrypt(_ context.Context, input *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{KeyId: aws.String("key"), Plaintext: reversed(input.CiphertextBlob)}, nil
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func TestArchiveCipher(t *testing.T) {
+	ctx := context.Background()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := age.GenerateX25519Identity()
+
+	tests := []struct {
+		name string
+		enc  *ClientEncryption
+		dec  *ClientEncryption
+		fail bool
+	}{
+		{"kms", &ClientEncryption{KMS: fakeKMS{}, KMSKeyID: "key"}, &ClientEncryption{KMS: fakeKMS{}}, false},
+		{"age", &ClientEncryption{AgeRecipients: []age.Recipient{identity.Recipient()}}, &ClientEncryption{AgeIdentities: []age.Identity{identity}}, false},
+		{"wrong identity", &ClientEncryption{AgeRecipients: []age.Recipient{identity.Recipient()}}, &ClientEncryption{AgeIdentities: []age.Identity{other}}, true},
+		{"no kms client", &ClientEncryption{KMS: fakeKMS{}, KMSKeyID: "key"}, &ClientEncryption{AgeIdentities: []age.Identity{identity}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newArchiveCipher(ctx, tt.enc, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			part1, part2 := []byte("first part"), []byte("second part")
+			sealed1, sealed2 := c.seal(1, part1), c.seal(2, part2)
+			if bytes.Contains(sealed1, part1) {
+				t.Fatal("part 1 is not encrypted")
+			}
+
+			d, err := openArchiveCipher(ctx, tt.dec, c.metadata)
+			if tt.fail {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := d.open(2, sealed2); err != nil || !bytes.Equal(got, part2) {
+				t.Fatalf("open(2) = %q, %v", got, err)
+			}
+			if got, err := d.open(1, sealed1); err != nil || !bytes.Equal(got, part1) {
+				t.Fatalf("open(1) = %q, %v", got, err)
+			}
+			// parts can't be reordered or modified
+			if _, err := d.open(1, sealed2); err == nil {
+				t.Fatal("expected an error opening part 2 as part 1")
+			}
+
+			// an archive truncated to its first part doesn't open, with the
+			// part count of the metadata changed to match it or not
+			truncated := map[string]string{}
+			for k, v := range c.metadata {
+				truncated[k] = v
+			}
+			truncated[metaParts] = "1"
+			if d, err := openArchiveCipher(ctx, tt.dec, truncated); err != nil {
+				t.Fatal(err)
+			} else if _, err := d.open(1, sealed1); err == nil {
+				t.Fatal("expected an error opening the first part as the last one")
+			}
+
+			sealed1[0] ^= 1
+			if _, err := d.open(1, sealed1); err == nil {
+				t.Fatal("expected an error opening a modified part")
+			}
+		})
+	}
+}
+
+func TestNewArchiveCipherArgs(t *testing.T) {
+	identity, _ := age.GenerateX25519Identity()
+	for _, enc := range []*ClientEncryption{
+		{},
+		{KMSKeyID: "key"},
+		{KMS: fakeKMS{}, KMSKeyID: "key", AgeRecipients: []age.Recipient{identity.Recipient()}},
+	} {
+		if _, err := newArchiveCipher(context.Background(), enc, 1); err == nil {
+			t.Errorf("newArchiveCipher(%+v) expected an error", enc)
+		}
+	}
+	if _, err := openArchiveCipher(context.Background(), &ClientEncryption{}, map[string]string{}); err == nil {
+		t.Error("expected an error for an archive without encryption metadata")
+	}
+}
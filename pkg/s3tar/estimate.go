@@ -0,0 +1,212 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Prices are the S3 Standard list prices in USD used by EstimateCost.
+type Prices struct {
+	// Tier1 is the price of 1,000 PUT, COPY, POST or LIST requests.
+	Tier1 float64
+	// Tier2 is the price of 1,000 GET or HEAD requests.
+	Tier2 float64
+	// StorageGBMonth is the price of storing 1GB for a month.
+	StorageGBMonth float64
+	// TransferOutGB is the price of 1GB downloaded to the internet. Data
+	// downloaded to EC2 in the same region is free.
+	TransferOutGB float64
+}
+
+var defaultPrices = Prices{Tier1: 0.005, Tier2: 0.0004, StorageGBMonth: 0.023, TransferOutGB: 0.09}
+
+// regionPrices are the regions priced differently than us-east-1. They are
+// approximate and change over time, see https://aws.amazon.com/s3/pricing/
+var regionPrices = map[string]Prices{
+	"us-west-1":      {Tier1: 0.0055, Tier2: 0.00044, StorageGBMonth: 0.026, TransferOutGB: 0.09},
+	"eu-central-1":   {Tier1: 0.0054, Tier2: 0.00043, StorageGBMonth: 0.0245, TransferOutGB: 0.09},
+	"eu-west-2":      {Tier1: 0.0053, Tier2: 0.00042, StorageGBMonth: 0.024, TransferOutGB: 0.09},
+	"ap-northeast-1": {Tier1: 0.0047, Tier2: 0.00037, StorageGBMonth: 0.025, TransferOutGB: 0.114},
+	"ap-southeast-1": {Tier1: 0.005, Tier2: 0.0004, StorageGBMonth: 0.025, TransferOutGB: 0.12},
+	"ap-southeast-2": {Tier1: 0.0055, Tier2: 0.00044, StorageGBMonth: 0.025, TransferOutGB: 0.114},
+	"sa-east-1":      {Tier1: 0.007, Tier2: 0.00056, StorageGBMonth: 0.0405, TransferOutGB: 0.15},
+	"us-gov-west-1":  {Tier1: 0.0055, Tier2: 0.00044, StorageGBMonth: 0.039, TransferOutGB: 0.155},
+	"us-gov-east-1":  {Tier1: 0.0055, Tier2: 0.00044, StorageGBMonth: 0.039, TransferOutGB: 0.155},
+}
+
+// PricesForRegion returns the approximate S3 Standard prices of a region.
+// Regions that aren't known use the us-east-1 prices.
+func PricesForRegion(region string) Prices {
+	if p, ok := regionPrices[region]; ok {
+		return p
+	}
+	return defaultPrices
+}
+
+// Estimate is the projected cost of creating an archive.
+type Estimate struct {
+	Region string
+	// Mode is in-memory, small-files or large-files, see createFromList.
+	Mode         string
+	Objects      int64
+	TotalBytes   int64
+	ArchiveBytes int64
+
+	List int64
+	Head int64
+	Get  int64
+	Put  int64
+	Copy int64
+	// Post counts CreateMultipartUpload and CompleteMultipartUpload.
+	Post int64
+
+	// TransferBytes are downloaded by s3tar, e.g. in-memory mode.
+	TransferBytes int64
+	// IntermediateBytes is the peak size of the .parts objects. They are
+	// deleted once the archive is complete.
+	IntermediateBytes int64
+
+	Prices Prices
+}
+
+// EstimateCost projects the requests sent and the bytes moved to create an
+// archive of objectList with opts. listed is true when objectList comes from
+// listing a prefix rather than a manifest.
+func EstimateCost(objectList []*S3Obj, opts *S3TarS3Options, listed bool, prices Prices) *Estimate {
+	cfg := newJobConfig(opts)
+	e := &Estimate{Region: opts.Region, Objects: int64(len(objectList)), Prices: prices}
+	smallFiles := false
+	var large int64
+	for _, o := range objectList {
+		e.TotalBytes += *o.Size
+		if *o.Size < cfg.padSize {
+			smallFiles = true
+		} else {
+			large++
+		}
+	}
+	e.ArchiveBytes = cfg.estimateFinalSize(objectList) + 2*blockSize
+	n := e.Objects
+
+	if listed {
+		e.List = ceilDiv(n, 1000)
+	} else {
+		e.Get++ // the manifest
+	}
+	if opts.PreservePOSIXMetadata {
+		e.Head = n
+	}
+	if opts.VerifySizes && !opts.TrustListing {
+		e.Head += n
+	}
+	if opts.MetadataSidecars {
+		// HeadObject, GetObjectTagging and GetObjectAcl
+		e.Head += n
+		e.Get += 2 * n
+	}
+	if opts.SHA256Digests {
+		e.Get += n
+		e.TransferBytes += e.TotalBytes
+	}
+
+	partSize := cfg.findMinimumPartSize(e.ArchiveBytes, opts.UserMaxPartSize)
+	parts := ceilDiv(e.ArchiveBytes, partSize)
+	switch {
+	case opts.ConcatInMemory || e.TotalBytes < cfg.padSize:
+		e.Mode = "in-memory"
+		e.Get += n
+		e.TransferBytes += e.TotalBytes
+		e.Put += parts
+		e.Post += 2
+	case smallFiles:
+		// every object is merged with its header, then with the object: two
+		// multipart uploads that copy the accumulated group each time
+		e.Mode = "small-files"
+		groups := ceilDiv(e.ArchiveBytes, cfg.findMinimumPartSize(e.ArchiveBytes, 0))
+		e.Post += 4*(n+1) + 2*groups
+		e.Put += n + 1
+		e.Copy += 3*(n+1) + groups
+		// final concat of the groups, then redistribute
+		e.Post += 4
+		e.Copy += groups + parts
+		e.IntermediateBytes = 2 * e.ArchiveBytes
+		if large > 0 {
+			// the large objects start their group, they aren't merged with
+			// the objects before them
+			e.Mode = "mixed"
+			e.Post -= 2 * large
+			e.Copy -= 2 * large
+		}
+	default:
+		// every object is copied with the header of the next one
+		e.Mode = "large-files"
+		for _, o := range objectList {
+			e.Copy += ceilDiv(*o.Size, partSizeMax)
+		}
+		e.Post += 2 * (n + 1)
+		e.Put += n + 1
+		if n+1 > maxPartNumLimit {
+			batches := ceilDiv(n+1, maxPartNumLimit)
+			e.Post += 2 * batches
+			e.Copy += n + 1
+		}
+		e.Post += 4
+		e.Copy += n + 1 + parts
+		e.IntermediateBytes = 2 * e.ArchiveBytes
+	}
+	return e
+}
+
+func ceilDiv(a, b int64) int64 {
+	if b <= 0 {
+		return 0
+	}
+	return (a + b - 1) / b
+}
+
+// RequestCost is the cost of the requests in USD.
+func (e *Estimate) RequestCost() float64 {
+	tier1 := float64(e.List+e.Put+e.Copy+e.Post) / 1000 * e.Prices.Tier1
+	tier2 := float64(e.Head+e.Get) / 1000 * e.Prices.Tier2
+	return tier1 + tier2
+}
+
+// IntermediateCost is the cost in USD of keeping the .parts objects for a day.
+func (e *Estimate) IntermediateCost() float64 {
+	return gigabytes(e.IntermediateBytes) * e.Prices.StorageGBMonth / 30
+}
+
+// StorageCost is the monthly cost in USD of storing the archive in S3 Standard.
+func (e *Estimate) StorageCost() float64 {
+	return gigabytes(e.ArchiveBytes) * e.Prices.StorageGBMonth
+}
+
+func gigabytes(b int64) float64 {
+	return float64(b) / (1 << 30)
+}
+
+// WriteReport writes a human readable estimate to w.
+func (e *Estimate) WriteReport(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "estimate (%s, %s mode):\n", e.Region, e.Mode)
+	fmt.Fprintf(&buf, "  objects:        %d\n", e.Objects)
+	fmt.Fprintf(&buf, "  total size:     %s\n", formatBytes(e.TotalBytes))
+	fmt.Fprintf(&buf, "  archive size:   %s\n", formatBytes(e.ArchiveBytes))
+	fmt.Fprintf(&buf, "  requests:\n")
+	fmt.Fprintf(&buf, "    %-6s %12d\n", "LIST", e.List)
+	fmt.Fprintf(&buf, "    %-6s %12d\n", "HEAD", e.Head)
+	fmt.Fprintf(&buf, "    %-6s %12d\n", "GET", e.Get)
+	fmt.Fprintf(&buf, "    %-6s %12d\n", "PUT", e.Put)
+	fmt.Fprintf(&buf, "    %-6s %12d\n", "COPY", e.Copy)
+	fmt.Fprintf(&buf, "    %-6s %12d\n", "POST", e.Post)
+	fmt.Fprintf(&buf, "  downloaded:     %s (free to EC2 in %s, $%.2f to the internet)\n", formatBytes(e.TransferBytes), e.Region, gigabytes(e.TransferBytes)*e.Prices.TransferOutGB)
+	fmt.Fprintf(&buf, "  intermediates:  %s ($%.4f per day until deleted)\n", formatBytes(e.IntermediateBytes), e.IntermediateCost())
+	fmt.Fprintf(&buf, "  request cost:   $%.4f\n", e.RequestCost())
+	fmt.Fprintf(&buf, "  storage:        $%.4f per month for the archive in S3 Standard\n", e.StorageCost())
+	_, err := w.Write(buf.Bytes())
+	return err
+}
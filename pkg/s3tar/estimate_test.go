@@ -0,0 +1,73 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func estimateObjects(n int, size int64) []*S3Obj {
+	objectList := make([]*S3Obj, n)
+	now := time.Now()
+	for i := range objectList {
+		objectList[i] = NewS3ObjOptions(WithBucketAndKey("my-bucket", "file"), WithSize(size))
+		objectList[i].LastModified = &now
+	}
+	return objectList
+}
+
+func TestEstimateCost(t *testing.T) {
+	prices := PricesForRegion("us-west-2")
+	opts := &S3TarS3Options{Region: "us-west-2"}
+
+	e := EstimateCost(estimateObjects(10000, 100<<10), opts, true, prices)
+	if e.Mode != "small-files" || e.List != 10 || e.Get != 0 || e.Head != 0 {
+		t.Errorf("unexpected estimate %+v", e)
+	}
+	// a header PUT and three copies per object, as in the README example
+	if e.Put != 10001 || e.Copy < 30003 || e.IntermediateBytes != 2*e.ArchiveBytes {
+		t.Errorf("unexpected estimate %+v", e)
+	}
+	want := float64(e.List+e.Put+e.Copy+e.Post) / 1000 * 0.005
+	if math.Abs(e.RequestCost()-want) > 1e-9 {
+		t.Errorf("RequestCost = %f, want %f", e.RequestCost(), want)
+	}
+
+	opts.ConcatInMemory = true
+	e = EstimateCost(estimateObjects(10000, 100<<10), opts, false, prices)
+	if e.Mode != "in-memory" || e.Get != 10001 || e.Copy != 0 || e.TransferBytes != 10000*100<<10 {
+		t.Errorf("unexpected estimate %+v", e)
+	}
+
+	opts.ConcatInMemory = false
+	// two objects one byte under the minimum part size and two at it
+	small := EstimateCost(append(estimateObjects(10, 100<<10), estimateObjects(2, defaultPadSize-1)...), opts, true, prices)
+	e = EstimateCost(append(estimateObjects(10, 100<<10), estimateObjects(2, defaultPadSize)...), opts, true, prices)
+	if small.Mode != "small-files" || e.Mode != "mixed" || e.Copy != small.Copy-4 || e.Post != small.Post-4 {
+		t.Errorf("unexpected estimates %+v and %+v", small, e)
+	}
+
+	e = EstimateCost(estimateObjects(3, 6<<30), opts, true, prices)
+	if e.Mode != "large-files" || e.Put != 4 {
+		t.Errorf("unexpected estimate %+v", e)
+	}
+
+	var buf bytes.Buffer
+	if err := e.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"large-files mode", "COPY", "request cost:"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("%q is missing from the report:\n%s", want, buf.String())
+		}
+	}
+
+	if PricesForRegion("sa-east-1").Tier1 <= PricesForRegion("us-east-1").Tier1 {
+		t.Errorf("sa-east-1 should be priced higher")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// directoryBucketSuffix ends the names of the S3 Express One Zone directory
+// buckets, e.g. bucket--usw2-az1--x-s3.
+const directoryBucketSuffix = "--x-s3"
+
+// isDirectoryBucket reports whether bucket is an S3 Express One Zone
+// directory bucket. The SDK resolves their zonal endpoint and creates the
+// sessions, s3tar only has to leave out what they don't support.
+func isDirectoryBucket(bucket string) bool {
+	return strings.HasSuffix(bucket, directoryBucketSuffix)
+}
+
+// WithDirectoryBuckets removes the ACL and tagging of the uploads and copies
+// to directory buckets, they don't support them. Use it when creating the
+// client:
+//
+//	svc := s3.NewFromConfig(cfg, s3tar.WithDirectoryBuckets)
+func WithDirectoryBuckets(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3TarDirectoryBuckets",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				switch input := in.Parameters.(type) {
+				case *s3.CreateMultipartUploadInput:
+					if isDirectoryBucket(*input.Bucket) {
+						input.ACL, input.Tagging = "", nil
+					}
+				case *s3.PutObjectInput:
+					if isDirectoryBucket(*input.Bucket) {
+						input.ACL, input.Tagging = "", nil
+					}
+				case *s3.CopyObjectInput:
+					if isDirectoryBucket(*input.Bucket) {
+						input.ACL, input.Tagging = "", nil
+					}
+				}
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+	})
+}
+
+// checkDirectoryBuckets checks that an archive from or to a directory bucket
+// doesn't use what they don't support. The default storage class becomes
+// EXPRESS_ONEZONE.
+func checkDirectoryBuckets(opts *S3TarS3Options) error {
+	if isDirectoryBucket(opts.SrcBucket) {
+		if opts.SrcPrefix != "" && !strings.HasSuffix(opts.SrcPrefix, "/") {
+			return fmt.Errorf("the prefix of the directory bucket %s must end with /", opts.SrcBucket)
+		}
+		if opts.MetadataSidecars {
+			return fmt.Errorf("metadata sidecars can't be created from the directory bucket %s, it has no ACL or tags", opts.SrcBucket)
+		}
+	}
+	if !isDirectoryBucket(opts.DstBucket) {
+		return nil
+	}
+	switch opts.storageClass {
+	case "", types.StorageClassStandard:
+		opts.storageClass = types.StorageClassExpressOnezone
+	case types.StorageClassExpressOnezone:
+	default:
+		return fmt.Errorf("the directory bucket %s only supports the %s storage class", opts.DstBucket, types.StorageClassExpressOnezone)
+	}
+	if len(opts.ObjectTags.TagSet) > 0 {
+		return fmt.Errorf("the directory bucket %s doesn't support tags", opts.DstBucket)
+	}
+	return nil
+}
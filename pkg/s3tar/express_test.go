@@ -0,0 +1,40 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestCheckDirectoryBuckets(t *testing.T) {
+	if !isDirectoryBucket("archives--usw2-az1--x-s3") || isDirectoryBucket("archives") {
+		t.Errorf("isDirectoryBucket")
+	}
+
+	opts := &S3TarS3Options{SrcBucket: "src", DstBucket: "archives--usw2-az1--x-s3", storageClass: types.StorageClassStandard}
+	if err := checkDirectoryBuckets(opts); err != nil || opts.storageClass != types.StorageClassExpressOnezone {
+		t.Errorf("storage class = %s, %v", opts.storageClass, err)
+	}
+	opts.storageClass = types.StorageClassGlacier
+	if err := checkDirectoryBuckets(opts); err == nil {
+		t.Errorf("expected an error for GLACIER")
+	}
+	opts.storageClass = ""
+	opts.ObjectTags = types.Tagging{TagSet: []types.Tag{{Key: aws.String("k"), Value: aws.String("v")}}}
+	if err := checkDirectoryBuckets(opts); err == nil {
+		t.Errorf("expected an error for the tags")
+	}
+
+	opts = &S3TarS3Options{SrcBucket: "files--usw2-az1--x-s3", SrcPrefix: "logs", DstBucket: "dst"}
+	if err := checkDirectoryBuckets(opts); err == nil {
+		t.Errorf("expected an error for a prefix without /")
+	}
+	opts.SrcPrefix = "logs/"
+	if err := checkDirectoryBuckets(opts); err != nil {
+		t.Error(err)
+	}
+}
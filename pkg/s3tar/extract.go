@@ -0,0 +1,600 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	gnuTarHeaderSize = blockSize * 1
+	paxTarHeaderSize = blockSize * 3
+)
+
+// Extract will unpack the tar file from source to target without downloading the archive locally.
+// The archive has to be created with the manifest option. The entries are
+// copied opts.Threads at a time. When the extraction fails or is interrupted,
+// the entries extracted so far are written to a progress record and skipped
+// by the next run with opts.Resume.
+func Extract(ctx context.Context, svc *s3.Client, prefix string, opts *S3TarS3Options) error {
+
+	head, err := headArchive(ctx, svc, opts.SrcBucket, opts.SrcKey)
+	if err != nil {
+		return err
+	}
+	etag := aws.ToString(head.ETag)
+
+	if isGzipArchive(opts.SrcKey, head) {
+		return extractStream(ctx, svc, prefix, opts)
+	}
+
+	toc, err := extractCSVToc(ctx, svc, opts.SrcBucket, opts.SrcKey, opts.ExternalToc)
+	if err != nil {
+		return err
+	}
+
+	var sidecars map[string]*FileMetadata
+	if opts.RestoreMetadata {
+		sidecars = map[string]*FileMetadata{}
+		for _, f := range toc {
+			if strings.HasSuffix(f.Filename, metadataSidecarSuffix) {
+				sidecars[f.Filename] = f
+			}
+		}
+	}
+
+	var resume *ExtractProgress
+	if opts.Resume {
+		resume, err = loadExtractProgress(ctx, svc, etag, opts)
+		if err != nil {
+			return err
+		}
+		if resume != nil {
+			Infof(ctx, "resuming from s3://%s/%s with %d entries extracted", opts.DstBucket, extractProgressKey(opts), len(resume.Entries))
+		}
+	}
+	progress := newExtractProgressTracker(etag, resume)
+
+	// every name is checked before the first entry is copied
+	dstKeys := make(map[*FileMetadata]string, len(toc))
+	for _, f := range toc {
+		if !strings.HasPrefix(f.Filename, prefix) {
+			continue
+		}
+		if dstKeys[f], err = extractKey(opts.DstPrefix, f.Filename); err != nil {
+			return err
+		}
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for _, f := range toc {
+		f := f
+		if !strings.HasPrefix(f.Filename, prefix) || progress.extracted(f) {
+			continue
+		}
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			dstKey := dstKeys[f]
+			sidecar := sidecars[f.Filename+metadataSidecarSuffix]
+			if err := extractRange(gctx, svc, opts.SrcBucket, opts.SrcKey, opts.DstBucket, dstKey, f.Start, f.Size, f.SHA256, sidecar, opts); err != nil {
+				return fmt.Errorf("unable to extract %s: %w", f.Filename, err)
+			}
+			progress.add(f)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		if pErr := progress.writeProgress(context.WithoutCancel(ctx), svc, opts); pErr != nil {
+			Errorf(ctx, "unable to write the progress: %s", pErr.Error())
+		} else {
+			err = fmt.Errorf("%w, the extraction can be resumed with --resume", err)
+		}
+		return err
+	}
+	if resume != nil {
+		deleteExtractProgress(ctx, svc, opts)
+	}
+	return nil
+}
+
+var ErrUnableToAccess = errors.New("unable to access")
+
+func checkIfObjectExists(ctx context.Context, svc *s3.Client, bucket, key string) error {
+	_, err := headArchive(ctx, svc, bucket, key)
+	return err
+}
+
+func headArchive(ctx context.Context, svc *s3.Client, bucket, key string) (*s3.HeadObjectOutput, error) {
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		Errorf(ctx, "%s", err.Error())
+		Errorf(ctx, "does s3://%s/%s exist?", bucket, key)
+		return nil, ErrUnableToAccess
+	}
+	return head, nil
+}
+
+// List will print out the contents in a tar, we do this by just printing from the TOC.
+func List(ctx context.Context, svc *s3.Client, bucket, key string, opts *S3TarS3Options) (TOC, error) {
+	if err := checkIfObjectExists(ctx, svc, bucket, key); err != nil {
+		return nil, err
+	}
+	toc, err := extractCSVToc(ctx, svc, bucket, key, opts.ExternalToc)
+	if err != nil {
+		return TOC{}, err
+	}
+	return toc, nil
+}
+
+// extractRange copies a single entry out of the archive. When the TOC has a
+// sha256 for the entry, S3 computes the checksum of the copied part and the
+// copy is verified against it. With RestoreMetadata, the Content-Type,
+// Cache-Control and user metadata are set from the sidecar of the entry, if
+// any, and its PAX records.
+func extractRange(ctx context.Context, svc *s3.Client, bucket, key, dstBucket, dstKey string, start, size int64, digest string, sidecar *FileMetadata, opts *S3TarS3Options) error {
+	var Metadata map[string]string
+	var objectMetadata *ObjectMetadata
+	if opts.RestoreMetadata && sidecar != nil {
+		var err error
+		objectMetadata, err = readMetadataSidecar(ctx, svc, bucket, key, sidecar)
+		if err != nil {
+			Warnf(ctx, "unable to read the metadata sidecar of %s: %s", dstKey, err.Error())
+		}
+	}
+	if opts.PreservePOSIXMetadata || opts.RestoreMetadata {
+		hdr, headerSize, err := extractTarHeaderEnding(ctx, svc, bucket, key, start)
+		if err != nil {
+			Warnf(ctx, "unable to extract tar header for %s, cannot set permissions", dstKey)
+			hdr = nil
+		}
+		if hdr != nil && opts.RestoreMetadata {
+			objectMetadata = mergeObjectMetadata(objectMetadata, paxObjectMetadata(hdr.PAXRecords))
+		}
+		if hdr != nil && opts.PreservePOSIXMetadata {
+			Metadata = posixMetadata(hdr)
+			Debugf(ctx, "got posix metadata permissions: %s uid: %s gid: %s name: %s from header size %d, ending %d, format %s",
+				Metadata["file-permissions"], Metadata["file-owner"], Metadata["file-group"], hdr.Name,
+				headerSize, start, hdr.Format,
+			)
+		}
+
+	}
+
+	var checksumAlgorithm types.ChecksumAlgorithm
+	if digest != "" {
+		checksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(dstBucket),
+		Key:               aws.String(dstKey),
+		ACL:               types.ObjectCannedACLBucketOwnerFullControl,
+		Metadata:          Metadata,
+		ChecksumAlgorithm: checksumAlgorithm,
+	}
+	if objectMetadata != nil {
+		applyObjectMetadata(input, objectMetadata)
+	}
+	output, err := svc.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return err
+	}
+	uploadId := *output.UploadId
+	//Infof(ctx, "s3://%s/%s", bucket, dstKey)
+
+	abort := func() {
+		svc.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   &dstBucket,
+			Key:      &dstKey,
+			UploadId: &uploadId,
+		})
+	}
+
+	var parts []types.CompletedPart
+	if size > 0 {
+		parts, err = extractCopyRange(ctx, svc, bucket, key, dstBucket, dstKey, uploadId, start, size, opts)
+		if err != nil {
+			abort()
+			return err
+		}
+	} else {
+		parts, err = extractEmptyRange(ctx, svc, dstBucket, dstKey, uploadId, checksumAlgorithm)
+		if err != nil {
+			abort()
+			return err
+		}
+	}
+
+	if digest != "" && len(parts) > 1 {
+		// the checksum of a multipart object is a checksum of the part
+		// checksums, the entry is read to hash it
+		Debugf(ctx, "s3://%s/%s was copied in %d parts, reading it to verify its sha256", dstBucket, dstKey, len(parts))
+		if err := verifyRangeSHA256(ctx, svc, bucket, key, splitRange(start, start+size, extractPartSize(opts)), parts, digest); err != nil {
+			abort()
+			return fmt.Errorf("s3://%s/%s: %w", dstBucket, dstKey, err)
+		}
+	} else if digest != "" {
+		if err := verifyPartSHA256(parts[0], digest); err != nil {
+			abort()
+			return fmt.Errorf("s3://%s/%s: %w", dstBucket, dstKey, err)
+		}
+	}
+
+	completeOutput, err := svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   &dstBucket,
+		Key:      &dstKey,
+		UploadId: &uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	Infof(ctx, "x s3://%s/%s", *completeOutput.Bucket, *completeOutput.Key)
+	return nil
+}
+
+// posixMetadata returns the S3 metadata keeping the permissions, owner and
+// times of a tar entry, see parseHeaderPermissions.
+func posixMetadata(hdr *tar.Header) map[string]string {
+	var mtime string = strconv.FormatInt(hdr.ModTime.UnixMilli(), 10)
+	var hasATime = hdr.Format == tar.FormatGNU || hdr.Format == tar.FormatPAX
+	var atime string
+	var ctime string
+	if hasATime {
+		atime = strconv.FormatInt(hdr.AccessTime.UnixMilli(), 10)
+		ctime = strconv.FormatInt(hdr.ChangeTime.UnixMilli(), 10)
+	} else {
+		atime = mtime
+		ctime = mtime
+	}
+	return map[string]string{
+		"file-permissions": fmt.Sprintf("%#o", hdr.Mode),
+		"file-owner":       strconv.Itoa(hdr.Uid),
+		"file-group":       strconv.Itoa(hdr.Gid),
+		"file-atime":       atime,
+		"file-mtime":       mtime,
+		"file-ctime":       ctime,
+	}
+}
+
+// applyObjectMetadata sets the headers and user metadata of m on the
+// extracted object. The POSIX metadata already in input takes precedence.
+func applyObjectMetadata(input *s3.CreateMultipartUploadInput, m *ObjectMetadata) {
+	if m.ContentType != "" {
+		input.ContentType = aws.String(m.ContentType)
+	}
+	if m.CacheControl != "" {
+		input.CacheControl = aws.String(m.CacheControl)
+	}
+	if m.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(m.ContentEncoding)
+	}
+	if m.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(m.ContentDisposition)
+	}
+	if m.ContentLanguage != "" {
+		input.ContentLanguage = aws.String(m.ContentLanguage)
+	}
+	if len(m.Metadata) > 0 {
+		metadata := make(map[string]string, len(m.Metadata)+len(input.Metadata))
+		for k, v := range m.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range input.Metadata {
+			metadata[k] = v
+		}
+		input.Metadata = metadata
+	}
+}
+
+func extractEmptyRange(ctx context.Context, svc *s3.Client, dstBucket string, dstKey string, uploadId string, checksumAlgorithm types.ChecksumAlgorithm) ([]types.CompletedPart, error) {
+	input := s3.UploadPartInput{
+		Bucket:            &dstBucket,
+		Key:               &dstKey,
+		PartNumber:        aws.Int32(1),
+		UploadId:          &uploadId,
+		Body:              new(bytes.Buffer),
+		ChecksumAlgorithm: checksumAlgorithm,
+	}
+
+	res, err := svc.UploadPart(ctx, &input)
+	if err != nil {
+		return nil, err
+	}
+	parts := []types.CompletedPart{
+		types.CompletedPart{
+			ETag:           res.ETag,
+			PartNumber:     aws.Int32(1),
+			ChecksumSHA256: res.ChecksumSHA256,
+		},
+	}
+	return parts, nil
+}
+
+// extractCopyRange copies size bytes at start of the archive into the upload.
+// Entries larger than the part size, 5GiB unless ExtractPartSize is set, are
+// copied in several parts, opts.Threads at a time.
+func extractCopyRange(ctx context.Context, svc *s3.Client, bucket string, key string, dstBucket string, dstKey string, uploadId string, start, size int64, opts *S3TarS3Options) ([]types.CompletedPart, error) {
+	ranges := splitRange(start, start+size, extractPartSize(opts))
+	parts := make([]types.CompletedPart, len(ranges))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for i, rng := range ranges {
+		i, rng := i, rng
+		g.Go(func() error {
+			input := s3.UploadPartCopyInput{
+				Bucket:          &dstBucket,
+				Key:             &dstKey,
+				PartNumber:      aws.Int32(int32(i + 1)),
+				UploadId:        &uploadId,
+				CopySource:      aws.String(formatCopySource(bucket, key, "")),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", rng[0], rng[1]-1)),
+			}
+			res, err := svc.UploadPartCopy(gctx, &input)
+			if err != nil {
+				return err
+			}
+			parts[i] = types.CompletedPart{
+				ETag:           res.CopyPartResult.ETag,
+				PartNumber:     input.PartNumber,
+				ChecksumSHA256: res.CopyPartResult.ChecksumSHA256,
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// extractPartSize is the largest part an entry is copied in.
+func extractPartSize(opts *S3TarS3Options) int64 {
+	if opts.ExtractPartSize > 0 {
+		return opts.ExtractPartSize
+	}
+	return partSizeMax
+}
+
+// verifyPartSHA256 compares the checksum S3 computed for the extracted part
+// with the digest recorded in the TOC.
+func verifyPartSHA256(part types.CompletedPart, digest string) error {
+	if part.ChecksumSHA256 == nil {
+		return fmt.Errorf("no sha256 returned for the extracted part")
+	}
+	got, err := base64ToHex(*part.ChecksumSHA256)
+	if err != nil {
+		return err
+	}
+	if got != digest {
+		return fmt.Errorf("sha256 mismatch, expected %s got %s", digest, got)
+	}
+	return nil
+}
+
+// verifyRangeSHA256 reads the ranges of the archive an entry was copied
+// from in parts. The sha256 of every range must be the checksum S3 computed
+// for its part, and the sha256 of them all the digest recorded in the TOC.
+func verifyRangeSHA256(ctx context.Context, svc Backend, bucket, key string, ranges [][2]int64, parts []types.CompletedPart, digest string) error {
+	if len(ranges) != len(parts) {
+		return fmt.Errorf("%d parts copied for %d ranges", len(parts), len(ranges))
+	}
+	r, err := getObjectRange(ctx, svc, bucket, key, ranges[0][0], ranges[len(ranges)-1][1]-1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	whole := sha256.New()
+	for i, rng := range ranges {
+		h := sha256.New()
+		if _, err := io.CopyN(io.MultiWriter(whole, h), r, rng[1]-rng[0]); err != nil {
+			return fmt.Errorf("unable to read the entry: %w", err)
+		}
+		if err := verifyPartSHA256(parts[i], hex.EncodeToString(h.Sum(nil))); err != nil {
+			return fmt.Errorf("part %d: %w", i+1, err)
+		}
+	}
+	if got := hex.EncodeToString(whole.Sum(nil)); got != digest {
+		return fmt.Errorf("sha256 mismatch, expected %s got %s", digest, got)
+	}
+	return nil
+}
+
+type TOC []*FileMetadata
+type FileMetadata struct {
+	Filename string
+	Start    int64
+	Size     int64
+	Etag     string
+	SHA256   string
+}
+
+// errNoTarHeader is returned by extractTarHeader when the archive doesn't
+// begin with a tar header.
+var errNoTarHeader = errors.New("unable to parse the first tar header")
+
+func extractTarHeader(ctx context.Context, svc Backend, bucket, key string) (*tar.Header, int64, error) {
+
+	headerSize := gnuTarHeaderSize
+	ctr := 0
+
+retry:
+
+	if ctr >= 2 {
+		return nil, 0, errNoTarHeader
+	}
+	ctr += 1
+
+	output, err := getObjectRange(ctx, svc, bucket, key, 0, headerSize-1)
+	if apiErrorCode(err) == "InvalidRange" {
+		// smaller than a header
+		return nil, 0, errNoTarHeader
+	} else if err != nil {
+		return nil, 0, err
+	}
+	tr := tar.NewReader(output)
+	hdr, err := tr.Next()
+	if err != nil {
+		headerSize = paxTarHeaderSize
+		goto retry
+	}
+	return hdr, headerSize, err
+}
+
+func extractTarHeaderEnding(ctx context.Context, svc *s3.Client, bucket, key string, end int64) (*tar.Header, int64, error) {
+
+	headerSize := paxTarHeaderSize
+	ctr := 0
+
+	// the last 512 bytes of a PAX (POSIX.1-2001) header resemble a USTAR (POSIX.1-1988) header
+	// so we should check for the longer header first.
+	if end < paxTarHeaderSize {
+		// however, if there is not enough room for a PAX header, only look for GNU header
+		headerSize = gnuTarHeaderSize
+		ctr = 1
+	}
+
+retry:
+	if ctr >= 2 {
+		return nil, 0, fmt.Errorf("unable to parse header ending %d from TAR", end)
+	}
+	ctr += 1
+
+	output, err := getObjectRange(ctx, svc, bucket, key, end-headerSize, end-1)
+	if err != nil {
+		return nil, 0, err
+	}
+	tr := tar.NewReader(output)
+	hdr, err := tr.Next()
+	if err != nil {
+		headerSize = gnuTarHeaderSize
+		goto retry
+	}
+	return hdr, headerSize, err
+}
+
+// ErrNoTOC is returned by ReadTOC for an archive with neither an embedded
+// toc.csv nor an external TOC.
+var ErrNoTOC = errors.New("archive has no TOC")
+
+// openTOC returns the toc.csv of the archive, or its external TOC when it
+// was created with NoEmbeddedTOC. Only an archive whose first entry isn't a
+// toc.csv and without an external TOC has no TOC, the errors reading them,
+// e.g. AccessDenied, are returned.
+func openTOC(ctx context.Context, svc Backend, bucket, key string) (io.ReadCloser, error) {
+	hdr, offset, err := extractTarHeader(ctx, svc, bucket, key)
+	if err != nil && !errors.Is(err, errNoTarHeader) {
+		return nil, fmt.Errorf("unable to read s3://%s/%s: %w", bucket, key, err)
+	}
+	if err == nil && hdr.Name == "toc.csv" {
+		// extract the csv now that we know the length of the CSV
+		return getObjectRange(ctx, svc, bucket, key, offset, offset+hdr.Size-1)
+	}
+	// created with NoEmbeddedTOC, or not created by s3tar
+	output, err := getObject(ctx, svc, bucket, ExternalTOCKey(key))
+	var notFound *types.NoSuchKey
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrNoTOC)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read s3://%s/%s: %w", bucket, ExternalTOCKey(key), err)
+	}
+	Infof(ctx, "using the TOC s3://%s/%s", bucket, ExternalTOCKey(key))
+	return output, nil
+}
+
+// ReadTOC returns the entries of an archive created by s3tar from its
+// embedded or external TOC. Unlike List, it doesn't scan the tar headers of
+// an archive without a TOC, it returns ErrNoTOC.
+func ReadTOC(ctx context.Context, svc Backend, bucket, key string) (TOC, error) {
+	output, err := openTOC(ctx, svc, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+	return ParseTOC(output)
+}
+
+func extractCSVToc(ctx context.Context, svc *s3.Client, bucket, key, externalToc string) (TOC, error) {
+	var output io.ReadCloser
+	// for regular s3tar files that have a toc in them, else files with external TOCs
+	if externalToc == "" {
+		var err error
+		output, err = openTOC(ctx, svc, bucket, key)
+		if errors.Is(err, ErrNoTOC) {
+			Infof(ctx, "s3://%s/%s has no TOC, scanning the tar headers", bucket, key)
+			return ScanTar(ctx, svc, bucket, key)
+		} else if err != nil {
+			return nil, err
+		}
+	} else {
+		Infof(ctx, "using external-toc: %s", externalToc)
+		var err error
+		output, err = loadFile(ctx, svc, externalToc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer output.Close()
+	return ParseTOC(output)
+}
+
+// ParseTOC parses the CSV lines of a TOC: name, offset, size, etag and the
+// optional sha256 of every entry.
+func ParseTOC(r io.Reader) (TOC, error) {
+	var m TOC
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse csv TOC: %w", err)
+		}
+		if len(record) != 4 && len(record) != 5 {
+			return nil, fmt.Errorf("unable to parse csv TOC line %d. Was this archive created with s3tar?", len(m)+1)
+		}
+		start, err := StringToInt64(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the offset of %s: %w", record[0], err)
+		}
+		size, err := StringToInt64(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the size of %s: %w", record[0], err)
+		}
+		fm := &FileMetadata{
+			Filename: record[0],
+			Start:    start,
+			Size:     size,
+			Etag:     record[3],
+		}
+		if len(record) == 5 {
+			fm.SHA256 = record[4]
+		}
+		m = append(m, fm)
+	}
+	return m, nil
+}
@@ -0,0 +1,134 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ExtractProgress is the state of an extraction that failed or was
+// interrupted. It is written next to the extracted objects, see
+// extractProgressKey.
+type ExtractProgress struct {
+	Archive string `json:"archive"`
+	// ETag of the archive, the progress of another archive is not reused.
+	ETag      string    `json:"etag"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Entries are the files extracted, by name and offset in the archive.
+	Entries []ExtractedEntry `json:"entries"`
+}
+
+// ExtractedEntry is an entry that was extracted.
+type ExtractedEntry struct {
+	Filename string `json:"filename"`
+	Start    int64  `json:"start"`
+}
+
+type extractProgressTracker struct {
+	mu      sync.Mutex
+	etag    string
+	done    map[ExtractedEntry]bool
+	resumed map[ExtractedEntry]bool
+}
+
+func newExtractProgressTracker(etag string, resume *ExtractProgress) *extractProgressTracker {
+	t := &extractProgressTracker{etag: etag, done: map[ExtractedEntry]bool{}, resumed: map[ExtractedEntry]bool{}}
+	if resume != nil {
+		for _, e := range resume.Entries {
+			t.resumed[e] = true
+			t.done[e] = true
+		}
+	}
+	return t
+}
+
+// extracted reports whether the run being resumed already extracted f.
+func (t *extractProgressTracker) extracted(f *FileMetadata) bool {
+	return t.resumed[ExtractedEntry{Filename: f.Filename, Start: f.Start}]
+}
+
+func (t *extractProgressTracker) add(f *FileMetadata) {
+	t.mu.Lock()
+	t.done[ExtractedEntry{Filename: f.Filename, Start: f.Start}] = true
+	t.mu.Unlock()
+}
+
+func extractProgressKey(opts *S3TarS3Options) string {
+	return JoinKey(opts.DstPrefix, path.Base(opts.SrcKey)+".extract-progress.json")
+}
+
+// loadExtractProgress reads the progress of a previous extraction of the
+// same archive. It returns nil when there is none or the archive changed.
+func loadExtractProgress(ctx context.Context, svc *s3.Client, etag string, opts *S3TarS3Options) (*ExtractProgress, error) {
+	key := extractProgressKey(opts)
+	r, err := getObject(ctx, svc, opts.DstBucket, key)
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &ExtractProgress{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("invalid progress s3://%s/%s: %w", opts.DstBucket, key, err)
+	}
+	if p.ETag != etag {
+		Warnf(ctx, "s3://%s/%s changed since s3://%s/%s was written, extracting everything", opts.SrcBucket, opts.SrcKey, opts.DstBucket, key)
+		return nil, nil
+	}
+	return p, nil
+}
+
+// writeProgress writes the entries extracted so far. ctx must not be the
+// canceled context of the run.
+func (t *extractProgressTracker) writeProgress(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	t.mu.Lock()
+	p := &ExtractProgress{
+		Archive:   fmt.Sprintf("s3://%s/%s", opts.SrcBucket, opts.SrcKey),
+		ETag:      t.etag,
+		CreatedAt: time.Now(),
+		Entries:   make([]ExtractedEntry, 0, len(t.done)),
+	}
+	for e := range t.done {
+		p.Entries = append(p.Entries, e)
+	}
+	t.mu.Unlock()
+	sort.Slice(p.Entries, func(i, j int) bool { return p.Entries[i].Start < p.Entries[j].Start })
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := putObject(ctx, svc, opts.DstBucket, extractProgressKey(opts), data); err != nil {
+		return err
+	}
+	Warnf(ctx, "%d entries extracted. progress: s3://%s/%s", len(p.Entries), opts.DstBucket, extractProgressKey(opts))
+	return nil
+}
+
+// deleteExtractProgress removes the progress once the extraction is complete.
+func deleteExtractProgress(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) {
+	_, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &opts.DstBucket, Key: aws.String(extractProgressKey(opts))})
+	if err != nil {
+		Warnf(ctx, "unable to delete the progress s3://%s/%s: %s", opts.DstBucket, extractProgressKey(opts), err.Error())
+	}
+}
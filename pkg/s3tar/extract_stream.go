@@ -0,0 +1,281 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// streamPartSize is the default part size of the entries uploaded while a
+// compressed archive is decompressed.
+const streamPartSize = 16 * 1024 * 1024
+
+// isGzipArchive reports whether the archive is gzip compressed, by its
+// extension or the Content-Type and Content-Encoding it was uploaded with.
+func isGzipArchive(key string, head *s3.HeadObjectOutput) bool {
+	if strings.HasSuffix(key, ".tar.gz") || strings.HasSuffix(key, ".tgz") {
+		return true
+	}
+	if head == nil {
+		return false
+	}
+	switch aws.ToString(head.ContentType) {
+	case "application/gzip", "application/x-gzip", "application/x-compressed-tar":
+		return true
+	}
+	return aws.ToString(head.ContentEncoding) == "gzip"
+}
+
+// extractStream unpacks a gzip compressed archive. A compressed archive has
+// no offsets to copy from, it is downloaded once and decompressed on the fly.
+// Every entry is uploaded as it is decoded, in parts of ExtractPartSize
+// (16MB by default) for the larger ones. At most opts.Threads parts or small
+// entries are buffered and uploaded at a time, so the memory used is bounded
+// by Threads * part size. --resume isn't supported, the whole archive is
+// extracted again.
+func extractStream(ctx context.Context, svc *s3.Client, prefix string, opts *S3TarS3Options) error {
+	Infof(ctx, "s3://%s/%s is compressed, streaming it", opts.SrcBucket, opts.SrcKey)
+	body, err := getObject(ctx, svc, opts.SrcBucket, opts.SrcKey)
+	if err != nil {
+		return err
+	}
+	r, err := maybeGunzip(body)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	s := &streamExtractor{svc: svc, opts: opts, partSize: streamPartSize}
+	if opts.ExtractPartSize > 0 {
+		s.partSize = opts.ExtractPartSize
+	}
+	return s.extract(ctx, r, prefix)
+}
+
+type streamExtractor struct {
+	svc      *s3.Client
+	opts     *S3TarS3Options
+	partSize int64
+
+	// completions are the multipart uploads waiting for their parts
+	completions sync.WaitGroup
+	mu          sync.Mutex
+	err         error
+}
+
+func (s *streamExtractor) setErr(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+}
+
+func (s *streamExtractor) extract(ctx context.Context, r io.Reader, prefix string) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.opts.Threads)
+	// hardlinks are copied from their target once it is uploaded
+	links := map[string]string{}
+	extracted := map[string]bool{}
+
+	tr := tar.NewReader(r)
+	var readErr error
+	for gctx.Err() == nil {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("unable to read s3://%s/%s: %w", s.opts.SrcBucket, s.opts.SrcKey, err)
+			break
+		}
+		if !strings.HasPrefix(hdr.Name, prefix) {
+			continue
+		}
+		dstKey, keyErr := extractKey(s.opts.DstPrefix, hdr.Name)
+		if keyErr != nil && (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeCont || hdr.Typeflag == tar.TypeLink) {
+			readErr = keyErr
+			break
+		}
+		switch {
+		case isSparse(hdr):
+			Warnf(ctx, "skipping sparse file %s", hdr.Name)
+		case hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeCont:
+			input := s.uploadInput(hdr, dstKey)
+			if hdr.Size <= s.partSize {
+				err = s.putEntry(gctx, g, tr, hdr.Size, input)
+			} else {
+				err = s.uploadEntry(gctx, g, tr, hdr.Size, input)
+			}
+			if err != nil {
+				readErr = fmt.Errorf("unable to extract %s: %w", hdr.Name, err)
+			}
+			extracted[hdr.Name] = true
+		case hdr.Typeflag == tar.TypeLink:
+			links[dstKey] = hdr.Linkname
+		case hdr.Typeflag == tar.TypeDir:
+			// directories are implied by the keys
+		default:
+			Warnf(ctx, "skipping %s, entries of type %q can't be extracted to Amazon S3", hdr.Name, hdr.Typeflag)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	err := g.Wait()
+	s.completions.Wait()
+	for _, e := range []error{readErr, err, s.err} {
+		if e != nil {
+			return e
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for dstKey, target := range links {
+		if !extracted[target] {
+			Warnf(ctx, "skipping hardlink %s, %s was not extracted", dstKey, target)
+			continue
+		}
+		_, err := s.svc.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.opts.DstBucket),
+			Key:        aws.String(dstKey),
+			CopySource: aws.String(formatCopySource(s.opts.DstBucket, JoinKey(s.opts.DstPrefix, target), "")),
+			ACL:        types.ObjectCannedACLBucketOwnerFullControl,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to copy hardlink %s: %w", dstKey, err)
+		}
+		Infof(ctx, "x s3://%s/%s", s.opts.DstBucket, dstKey)
+	}
+	return nil
+}
+
+// uploadInput returns the upload of an entry with its POSIX metadata and the
+// S3 metadata of its PAX records, per the options.
+func (s *streamExtractor) uploadInput(hdr *tar.Header, dstKey string) *s3.CreateMultipartUploadInput {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(s.opts.DstBucket),
+		Key:               aws.String(dstKey),
+		ACL:               types.ObjectCannedACLBucketOwnerFullControl,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+	if s.opts.PreservePOSIXMetadata {
+		input.Metadata = posixMetadata(hdr)
+	}
+	if s.opts.RestoreMetadata {
+		if m := paxObjectMetadata(hdr.PAXRecords); m != nil {
+			applyObjectMetadata(input, m)
+		}
+	}
+	return input
+}
+
+// putEntry reads a small entry and uploads it with a single PutObject.
+func (s *streamExtractor) putEntry(ctx context.Context, g *errgroup.Group, r io.Reader, size int64, input *s3.CreateMultipartUploadInput) error {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	g.Go(func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := s.svc.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:            input.Bucket,
+			Key:               input.Key,
+			ACL:               input.ACL,
+			ChecksumAlgorithm: input.ChecksumAlgorithm,
+			Metadata:          input.Metadata,
+			ContentType:       input.ContentType,
+			CacheControl:      input.CacheControl,
+			ContentEncoding:   input.ContentEncoding,
+			Body:              limitBody(ctx, bytes.NewReader(data)),
+			ContentLength:     aws.Int64(size),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to upload s3://%s/%s: %w", *input.Bucket, *input.Key, err)
+		}
+		Infof(ctx, "x s3://%s/%s", *input.Bucket, *input.Key)
+		return nil
+	})
+	return nil
+}
+
+// uploadEntry reads a large entry part by part. The parts are uploaded by g
+// while the next ones are read, the upload is completed once they are all
+// uploaded.
+func (s *streamExtractor) uploadEntry(ctx context.Context, g *errgroup.Group, r io.Reader, size int64, input *s3.CreateMultipartUploadInput) error {
+	output, err := s.svc.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return err
+	}
+	uploadId := *output.UploadId
+	parts := make([]types.CompletedPart, (size+s.partSize-1)/s.partSize)
+	var uploads sync.WaitGroup
+	for i := range parts {
+		n := s.partSize
+		if remaining := size - int64(i)*s.partSize; remaining < n {
+			n = remaining
+		}
+		data := make([]byte, n)
+		if _, err = io.ReadFull(r, data); err != nil {
+			break
+		}
+		i := i
+		uploads.Add(1)
+		g.Go(func() error {
+			defer uploads.Done()
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			partNum := int32(i + 1)
+			res, err := uploadPart(ctx, s.svc, uploadId, *input.Bucket, *input.Key, data, &partNum)
+			if err != nil {
+				return err
+			}
+			parts[i] = types.CompletedPart{ETag: res.ETag, PartNumber: &partNum, ChecksumSHA256: res.ChecksumSHA256}
+			return nil
+		})
+	}
+
+	s.completions.Add(1)
+	go func() {
+		defer s.completions.Done()
+		uploads.Wait()
+		if err != nil || ctx.Err() != nil {
+			s.svc.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+				Bucket:   input.Bucket,
+				Key:      input.Key,
+				UploadId: &uploadId,
+			})
+			return
+		}
+		_, cErr := s.svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          input.Bucket,
+			Key:             input.Key,
+			UploadId:        &uploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+		if cErr != nil {
+			s.setErr(fmt.Errorf("unable to complete s3://%s/%s: %w", *input.Bucket, *input.Key, cErr))
+			return
+		}
+		Infof(ctx, "x s3://%s/%s", *input.Bucket, *input.Key)
+	}()
+	return err
+}
@@ -0,0 +1,31 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestIsGzipArchive(t *testing.T) {
+	tests := []struct {
+		key  string
+		head *s3.HeadObjectOutput
+		want bool
+	}{
+		{"archive.tar.gz", nil, true},
+		{"archive.tgz", nil, true},
+		{"archive.tar", nil, false},
+		{"archive.tar", &s3.HeadObjectOutput{ContentType: aws.String("application/x-tar")}, false},
+		{"archive", &s3.HeadObjectOutput{ContentType: aws.String("application/gzip")}, true},
+		{"archive", &s3.HeadObjectOutput{ContentEncoding: aws.String("gzip")}, true},
+	}
+	for _, tt := range tests {
+		if got := isGzipArchive(tt.key, tt.head); got != tt.want {
+			t.Errorf("isGzipArchive(%s) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// groupPlaceholder is replaced with the name of the group in the archive
+// key of a grouped run.
+const groupPlaceholder = "{group}"
+
+// ObjectGroup is the objects of one archive of a grouped run.
+type ObjectGroup struct {
+	Name    string
+	Objects []*S3Obj
+}
+
+// GroupByDelimiter groups the keys by their first segment after prefix, e.g.
+// customer_id for prefix/customer_id/file.txt with the / delimiter. Keys
+// directly under prefix have no group.
+func GroupByDelimiter(prefix, delimiter string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		rest := strings.TrimPrefix(key, prefix)
+		i := strings.Index(rest, delimiter)
+		if i <= 0 {
+			return "", false
+		}
+		return rest[:i], true
+	}
+}
+
+// GroupByRegex groups the keys by the first capture group of re, or by the
+// whole match when re has no group. Keys re doesn't match have no group.
+func GroupByRegex(re *regexp.Regexp) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		m := re.FindStringSubmatch(key)
+		if m == nil {
+			return "", false
+		}
+		if len(m) > 1 {
+			return m[1], m[1] != ""
+		}
+		return m[0], m[0] != ""
+	}
+}
+
+// GroupObjects splits objectList with groupFn. The groups are sorted by name
+// and keep the order of their objects. The objects without a group are
+// returned apart.
+func GroupObjects(objectList []*S3Obj, groupFn func(key string) (string, bool)) ([]ObjectGroup, []*S3Obj) {
+	index := map[string]int{}
+	var groups []ObjectGroup
+	var ungrouped []*S3Obj
+	for _, o := range objectList {
+		name, ok := groupFn(*o.Key)
+		if !ok {
+			ungrouped = append(ungrouped, o)
+			continue
+		}
+		i, found := index[name]
+		if !found {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, ObjectGroup{Name: name})
+		}
+		groups[i].Objects = append(groups[i].Objects, o)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, ungrouped
+}
+
+// GroupedArchiveName returns the destination key of the archive of a group,
+// key with {group} replaced by its name or the name inserted before the
+// extension, e.g. prefix/archive.customer_id.tar.
+func GroupedArchiveName(key, group string) string {
+	if strings.Contains(key, groupPlaceholder) {
+		return strings.ReplaceAll(key, groupPlaceholder, group)
+	}
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(key, ext), group, ext)
+}
@@ -0,0 +1,55 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGroupObjects(t *testing.T) {
+	var objectList []*S3Obj
+	for _, key := range []string{"data/c2/a.txt", "data/c1/a.txt", "data/readme.txt", "data/c2/dir/b.txt", "data/year=2023/x.txt"} {
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(1)))
+	}
+	summary := func(groups []ObjectGroup) string {
+		var s []string
+		for _, g := range groups {
+			var keys []string
+			for _, o := range g.Objects {
+				keys = append(keys, *o.Key)
+			}
+			s = append(s, g.Name+":"+strings.Join(keys, "+"))
+		}
+		return strings.Join(s, ",")
+	}
+
+	groups, ungrouped := GroupObjects(objectList, GroupByDelimiter("data/", "/"))
+	want := "c1:data/c1/a.txt,c2:data/c2/a.txt+data/c2/dir/b.txt,year=2023:data/year=2023/x.txt"
+	if got := summary(groups); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if len(ungrouped) != 1 || *ungrouped[0].Key != "data/readme.txt" {
+		t.Errorf("unexpected ungrouped objects %v", ungrouped)
+	}
+
+	groups, ungrouped = GroupObjects(objectList, GroupByRegex(regexp.MustCompile(`year=(\d{4})/`)))
+	if got := summary(groups); got != "2023:data/year=2023/x.txt" || len(ungrouped) != 4 {
+		t.Errorf("got %s and %d ungrouped objects", got, len(ungrouped))
+	}
+	groups, _ = GroupObjects(objectList, GroupByRegex(regexp.MustCompile(`c\d`)))
+	if got := summary(groups); got != "c1:data/c1/a.txt,c2:data/c2/a.txt+data/c2/dir/b.txt" {
+		t.Errorf("got %s", got)
+	}
+
+	for _, tt := range []struct{ key, want string }{
+		{"out/archive.tar", "out/archive.c1.tar"},
+		{"out/{group}/archive.tar", "out/c1/archive.tar"},
+	} {
+		if got := GroupedArchiveName(tt.key, "c1"); got != tt.want {
+			t.Errorf("GroupedArchiveName(%s) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"time"
+
+	"github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar/s3tarpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// watchInterval is how often WatchJob checks the job for changes.
+var watchInterval = time.Second
+
+// grpcJobService is the s3tarpb.JobServiceServer of a JobServer.
+type grpcJobService struct {
+	s3tarpb.UnimplementedJobServiceServer
+	server *JobServer
+}
+
+// GRPCService returns the gRPC API of the server, the same jobs as the HTTP
+// API with WatchJob to stream their progress. Register it with:
+//
+//	gs := grpc.NewServer()
+//	s3tarpb.RegisterJobServiceServer(gs, jobServer.GRPCService())
+func (s *JobServer) GRPCService() s3tarpb.JobServiceServer {
+	return &grpcJobService{server: s}
+}
+
+func (g *grpcJobService) SubmitJob(_ context.Context, req *s3tarpb.JobRequest) (*s3tarpb.Job, error) {
+	job, err := g.server.Submit(jobRequestFromProto(req))
+	if err == errQueueFull {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return jobToProto(g.server.Job(job.ID)), nil
+}
+
+func (g *grpcJobService) GetJob(_ context.Context, req *s3tarpb.GetJobRequest) (*s3tarpb.Job, error) {
+	job := g.server.Job(req.GetId())
+	if job == nil {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", req.GetId())
+	}
+	return jobToProto(job), nil
+}
+
+func (g *grpcJobService) ListJobs(context.Context, *s3tarpb.ListJobsRequest) (*s3tarpb.ListJobsResponse, error) {
+	jobs := g.server.Jobs()
+	res := &s3tarpb.ListJobsResponse{Jobs: make([]*s3tarpb.Job, len(jobs))}
+	for i := range jobs {
+		res.Jobs[i] = jobToProto(&jobs[i])
+	}
+	return res, nil
+}
+
+func (g *grpcJobService) CancelJob(_ context.Context, req *s3tarpb.CancelJobRequest) (*s3tarpb.Job, error) {
+	job, err := g.server.Cancel(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if job == nil {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", req.GetId())
+	}
+	return jobToProto(g.server.Job(req.GetId())), nil
+}
+
+// WatchJob sends the job when its status or progress changed since the last
+// check, every watchInterval, until it is finished.
+func (g *grpcJobService) WatchJob(req *s3tarpb.WatchJobRequest, stream s3tarpb.JobService_WatchJobServer) error {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	var last *s3tarpb.Job
+	for {
+		job := g.server.Job(req.GetId())
+		if job == nil {
+			return status.Errorf(codes.NotFound, "job %s not found", req.GetId())
+		}
+		if msg := jobToProto(job); !proto.Equal(msg, last) {
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+			last = msg
+		}
+		switch job.Status {
+		case JobSucceeded, JobFailed, JobCanceled:
+			if job.FinishedAt != nil {
+				return nil
+			}
+			// a running job that was canceled isn't finished until its
+			// worker returns
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func jobRequestFromProto(req *s3tarpb.JobRequest) JobRequest {
+	return JobRequest{
+		Type:           req.GetType(),
+		Archive:        req.GetArchive(),
+		Source:         req.GetSource(),
+		Manifest:       req.GetManifest(),
+		Destination:    req.GetDestination(),
+		Prefix:         req.GetPrefix(),
+		Format:         req.GetFormat(),
+		StorageClass:   req.GetStorageClass(),
+		ConcatInMemory: req.GetConcatInMemory(),
+		Overwrite:      req.GetOverwrite(),
+	}
+}
+
+func jobToProto(job *Job) *s3tarpb.Job {
+	req := job.Request
+	msg := &s3tarpb.Job{
+		Id: job.ID,
+		Request: &s3tarpb.JobRequest{
+			Type:           req.Type,
+			Archive:        req.Archive,
+			Source:         req.Source,
+			Manifest:       req.Manifest,
+			Destination:    req.Destination,
+			Prefix:         req.Prefix,
+			Format:         req.Format,
+			StorageClass:   req.StorageClass,
+			ConcatInMemory: req.ConcatInMemory,
+			Overwrite:      req.Overwrite,
+		},
+		Status:    job.Status,
+		Tenant:    job.Tenant,
+		Error:     job.Error,
+		CreatedAt: timestamppb.New(job.CreatedAt),
+	}
+	if job.StartedAt != nil {
+		msg.StartedAt = timestamppb.New(*job.StartedAt)
+	}
+	if job.FinishedAt != nil {
+		msg.FinishedAt = timestamppb.New(*job.FinishedAt)
+	}
+	if p := job.Progress; p != nil {
+		msg.Progress = &s3tarpb.JobProgress{
+			Stage:       p.Stage,
+			Percent:     p.Percent,
+			Entries:     int64(p.Entries),
+			EntriesDone: int64(p.EntriesDone),
+		}
+	}
+	return msg
+}
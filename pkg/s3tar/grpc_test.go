@@ -0,0 +1,135 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar/s3tarpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// progressArchiver reports half of the entries of every create job copied,
+// then blocks like blockingArchiver.
+type progressArchiver struct {
+	blockingArchiver
+}
+
+func (p *progressArchiver) Create(ctx context.Context, opts *S3TarS3Options, _ ...func(*S3TarS3Options)) error {
+	heartbeatStage(ctx, HeartbeatBuilding, 4)
+	heartbeatProgress(ctx, 2)
+	return p.wait(ctx, opts)
+}
+
+func TestGRPCService(t *testing.T) {
+	defer func(d time.Duration) { watchInterval = d }(watchInterval)
+	watchInterval = 10 * time.Millisecond
+
+	archiver := &progressArchiver{blockingArchiver{release: make(chan struct{}), started: make(chan *S3TarS3Options, 10)}}
+	s := NewJobServer(archiver, ServerOptions{Workers: 1, QueueSize: 1, Options: S3TarS3Options{Region: "us-west-2"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	s3tarpb.RegisterJobServiceServer(gs, s.GRPCService())
+	go gs.Serve(lis)
+	defer gs.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := s3tarpb.NewJobServiceClient(conn)
+
+	if _, err := client.SubmitJob(ctx, &s3tarpb.JobRequest{Type: JobTypeCreate, Archive: "bucket/archive.tar"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SubmitJob() without s3:// error = %v", err)
+	}
+	job, err := client.SubmitJob(ctx, &s3tarpb.JobRequest{Type: JobTypeCreate, Archive: "s3://bucket/archive.tar", Source: "s3://bucket/files/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.GetId() == "" || job.GetRequest().GetStorageClass() != "STANDARD" || job.GetCreatedAt() == nil {
+		t.Errorf("SubmitJob() = %v", job)
+	}
+
+	stream, err := client.WatchJob(ctx, &s3tarpb.WatchJobRequest{Id: job.GetId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-archiver.started
+	var updates []*s3tarpb.Job
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		updates = append(updates, update)
+		if update.GetProgress().GetEntriesDone() == 2 {
+			break
+		}
+	}
+	if p := updates[len(updates)-1].GetProgress(); p.GetStage() != HeartbeatBuilding || p.GetPercent() != 50 || p.GetEntries() != 4 {
+		t.Errorf("progress = %v", p)
+	}
+
+	// the queue holds a single job
+	if _, err := client.SubmitJob(ctx, &s3tarpb.JobRequest{Type: JobTypeExtract, Archive: "s3://bucket/archive.tar", Destination: "s3://bucket/out"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.SubmitJob(ctx, &s3tarpb.JobRequest{Type: JobTypeExtract, Archive: "s3://bucket/archive.tar", Destination: "s3://bucket/out"}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("SubmitJob() with a full queue error = %v", err)
+	}
+	list, err := client.ListJobs(ctx, &s3tarpb.ListJobsRequest{})
+	if err != nil || len(list.GetJobs()) != 2 || list.GetJobs()[1].GetRequest().GetDestination() != "s3://bucket/out/" {
+		t.Fatalf("ListJobs() = %v, %v", list, err)
+	}
+	queued := list.GetJobs()[1].GetId()
+	if _, err := client.CancelJob(ctx, &s3tarpb.CancelJobRequest{Id: queued}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CancelJob(ctx, &s3tarpb.CancelJobRequest{Id: queued}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CancelJob() of a canceled job error = %v", err)
+	}
+
+	close(archiver.release)
+	var last *s3tarpb.Job
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		last = update
+	}
+	if last.GetStatus() != JobSucceeded || last.GetFinishedAt() == nil || last.GetProgress() != nil {
+		t.Errorf("last update = %v", last)
+	}
+
+	for _, err := range []error{
+		func() error { _, err := client.GetJob(ctx, &s3tarpb.GetJobRequest{Id: "missing"}); return err }(),
+		func() error { _, err := client.CancelJob(ctx, &s3tarpb.CancelJobRequest{Id: "missing"}); return err }(),
+		func() error {
+			stream, err := client.WatchJob(ctx, &s3tarpb.WatchJobRequest{Id: "missing"})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}(),
+	} {
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("error = %v, want NotFound", err)
+		}
+	}
+}
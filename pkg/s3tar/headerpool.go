@@ -0,0 +1,97 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// headerPool is the tar headers of the objects of a run staged once in a
+// single object under the parts prefix, see StageHeaders. The merges copy a
+// header with a range of the pool instead of uploading its bytes.
+type headerPool struct {
+	bucket string
+	key    string
+	// the header of the object i is the bytes from offsets[i] to
+	// offsets[i+1], the objects without a header have none
+	offsets []int64
+}
+
+// header returns the header of the object i as a range of the pool.
+func (p *headerPool) header(i int) *S3Obj {
+	size := p.offsets[i+1] - p.offsets[i]
+	return &S3Obj{
+		Bucket: p.bucket,
+		Object: types.Object{
+			Key:  aws.String(p.key),
+			Size: &size,
+		},
+		Slice:  true,
+		Offset: p.offsets[i],
+	}
+}
+
+// stageHeaders writes the headers of objectList, with the POSIX metadata of
+// headList, to a single object under the parts prefix of the run. They are
+// built in parallel a window at a time and uploaded in large parts, the
+// headers of tens of millions of entries are never all in memory.
+func stageHeaders(ctx context.Context, svc Backend, objectList []*S3Obj, headList []*s3.HeadObjectOutput, opts *S3TarS3Options) (*headerPool, error) {
+	cfg := jobConfigFromContext(ctx)
+	key := JoinKey(partsKey(opts), "headers", "headers.tar")
+	// the size isn't known before the headers are built, the parts allow
+	// 4KB per header, long names and PAX records included
+	w, err := newPartWriter(ctx, svc, opts.DstBucket, key, cfg.tocPartSize(int64(len(objectList))*8*blockSize))
+	if err != nil {
+		return nil, err
+	}
+	offsets := make([]int64, len(objectList)+1)
+	window := runtime.GOMAXPROCS(0) * entryChunk
+	data := make([][]byte, window)
+	for start := 0; start < len(objectList); start += window {
+		end := min(start+window, len(objectList))
+		err := forEachChunk(end-start, func(_, s, e int) error {
+			for i := start + s; i < start+e; i++ {
+				data[i-start] = nil
+				if objectList[i].NoHeaderRequired {
+					continue
+				}
+				var prev *S3Obj
+				if i > 0 {
+					prev = objectList[i-1]
+				}
+				h, err := cfg.headerData(objectList[i], prev, false, headList[i])
+				if err != nil {
+					return err
+				}
+				data[i-start] = h
+			}
+			return nil
+		})
+		if err != nil {
+			w.abort()
+			return nil, err
+		}
+		for i := start; i < end; i++ {
+			if _, err := w.Write(data[i-start]); err != nil {
+				w.abort()
+				return nil, err
+			}
+			offsets[i+1] = offsets[i] + int64(len(data[i-start]))
+		}
+	}
+	if _, err := w.close(); err != nil {
+		return nil, err
+	}
+	if w.size != offsets[len(objectList)] {
+		return nil, fmt.Errorf("the headers are %d bytes, expected %d", w.size, offsets[len(objectList)])
+	}
+	Debugf(ctx, "staged %d bytes of headers at s3://%s/%s", w.size, opts.DstBucket, key)
+	return &headerPool{bucket: opts.DstBucket, key: key, offsets: offsets}, nil
+}
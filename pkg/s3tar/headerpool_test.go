@@ -0,0 +1,99 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// partCounter counts the UploadPart calls, the bytes sent by the client.
+type partCounter struct {
+	*MemoryBackend
+	parts atomic.Int32
+}
+
+func (c *partCounter) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	c.parts.Add(1)
+	return c.MemoryBackend.UploadPart(ctx, params, optFns...)
+}
+
+func TestStageHeaders(t *testing.T) {
+	ctx := context.Background()
+	opts := &S3TarS3Options{DstBucket: "bucket", DstKey: "archive.tar"}
+	var objectList []*S3Obj
+	for i := 0; i < entryChunk+100; i++ {
+		o := NewS3ObjOptions(WithBucketAndKey("bucket", fmt.Sprintf("dir/%d.txt", i)), WithSize(int64(i%1500)), WithETag("etag"))
+		objectList = append(objectList, o)
+	}
+	objectList = append(objectList, defaultJobConfig.generateLastBlock(0))
+	m := NewMemoryBackend()
+	pool, err := stageHeaders(ctx, m, objectList, make([]*s3.HeadObjectOutput, len(objectList)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged := m.Get("bucket", pool.key)
+	for i, o := range objectList[:len(objectList)-1] {
+		h := pool.header(i)
+		data := staged[h.Offset : h.Offset+*h.Size]
+		if i > 0 {
+			// the padding of the previous entry
+			data = data[findPadding(*objectList[i-1].Size):]
+		}
+		hdr, err := tar.NewReader(bytes.NewReader(data)).Next()
+		if err != nil || hdr.Name != *o.Key || hdr.Size != *o.Size {
+			t.Fatalf("header %d: %+v, %v", i, hdr, err)
+		}
+	}
+	if h := pool.header(len(objectList) - 1); *h.Size != 0 {
+		t.Errorf("the EOF block has a %d byte header", *h.Size)
+	}
+
+	// the archive only uploads the staged headers, the TOC and the EOF block
+	c := &partCounter{MemoryBackend: NewMemoryBackend()}
+	c.MinPartSize = 64 * 1024
+	var list []*S3Obj
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("src/%d.txt", i)
+		c.Put("bucket", key, bytes.Repeat([]byte("x"), 1000*i))
+		list = append(list, NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(int64(1000*i)), WithETag("etag")))
+	}
+	opts = &S3TarS3Options{
+		SrcBucket:    "bucket",
+		DstBucket:    "bucket",
+		DstKey:       "archives/archive.tar",
+		DstPrefix:    "archives/",
+		Region:       "us-east-1",
+		Threads:      4,
+		PadSize:      64 * 1024,
+		StageHeaders: true,
+	}
+	if err := CreateFromListWithBackend(ctx, c, list, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.parts.Load(); n > 4 {
+		t.Errorf("%d parts uploaded for 20 entries", n)
+	}
+	if keys := c.Keys("bucket", "archives/"); len(keys) != 1 || keys[0] != opts.DstKey {
+		t.Errorf("the staged headers are left: %v", keys)
+	}
+	tr := tar.NewReader(bytes.NewReader(c.Get("bucket", opts.DstKey)))
+	n := 0
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		if want := "toc.csv"; n > 0 && hdr.Name != aws.ToString(list[n-1].Key) || n == 0 && hdr.Name != want {
+			t.Errorf("entry %d is %s", n, hdr.Name)
+		}
+		n++
+	}
+	if n != len(list)+1 {
+		t.Errorf("read %d entries, want %d", n, len(list)+1)
+	}
+}
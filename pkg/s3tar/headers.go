@@ -0,0 +1,388 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// buildHeader builds a tar header for the given S3 object.
+// Objects over 8GiB don't fit the octal size field, the tar writer stores
+// their size in a PAX size record (PAX) or with base-256 encoding (GNU).
+//
+// Parameters:
+//   - o: The S3 object for which the tar header needs to be built.
+//   - prev: The previous S3 object.
+//   - addZeros: A flag indicating whether to add zeros.
+//   - head: The head object containing S3 metadata, used to set file permissions, owner, and group.
+//
+// Returns:
+//   - S3Obj: The S3 object with the built tar header.
+//   - error: The header can't be written in the format of the job.
+//
+// Example:
+//
+//	o := &S3Obj{
+//	  Key:           aws.String("example.txt"),
+//	  Size:          aws.Int64(1024),
+//	  LastModified:  aws.Time(time.Now()),
+//	}
+//	prev := &S3Obj{
+//	  Size: aws.Int64(512),
+//	}
+//	addZeros := true
+//	head := &s3.HeadObjectOutput{
+//	  Metadata: map[string]*string{
+//	    "file-permissions": aws.String("0644"),
+//	    "file-owner":       aws.String("1000"),
+//	    "file-group":       aws.String("1000"),
+//	  },
+//	}
+//	result, err := defaultJobConfig.buildHeader(o, prev, addZeros, head)
+//	fmt.Println(result, err)
+func (c jobConfig) buildHeader(o, prev *S3Obj, addZeros bool, head *s3.HeadObjectOutput) (S3Obj, error) {
+	data, err := c.headerData(o, prev, addZeros, head)
+	if err != nil {
+		return S3Obj{}, err
+	}
+	ETag := fmt.Sprintf("%x", md5.Sum(data))
+	return S3Obj{
+		Object: types.Object{
+			Key:  aws.String("header"),
+			ETag: &ETag,
+			Size: aws.Int64(int64(len(data))),
+		},
+		Data: data,
+	}, nil
+}
+
+// headerData returns the padding of prev followed by the tar header of o.
+func (c jobConfig) headerData(o, prev *S3Obj, addZeros bool, head *s3.HeadObjectOutput) ([]byte, error) {
+	name := o.entryName()
+	var buff bytes.Buffer
+	tw := tar.NewWriter(&buff)
+	hdr := &tar.Header{
+		Name:       name,
+		Mode:       0600,
+		Size:       *o.Size,
+		ModTime:    *o.LastModified,
+		ChangeTime: *o.LastModified,
+		AccessTime: time.Now(),
+		Format:     c.format,
+	}
+	if head != nil {
+		if err := parseHeaderPermissions(hdr, head.Metadata); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	applyTarFormat(hdr)
+	hdr.PAXRecords = xattrRecords(o.Xattrs)
+	if o.LinkTarget != "" {
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = o.LinkTarget
+		hdr.Size = 0
+	}
+
+	if addZeros {
+		buff.Write(c.pad())
+	}
+
+	if prev != nil && prev.Size != nil && *prev.Size > 0 {
+		padSize := findPadding(*prev.Size)
+		buff.Write(make([]byte, padSize))
+	}
+	headerStart := buff.Len()
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := tw.Flush(); err != nil {
+		// we ignore this error, the tar library will complain that we
+		// didn't write the whole file. This part is already on Amazon S3
+	}
+	if o.alignPad > 0 {
+		if err := alignHeader(&buff, headerStart, hdr, o.alignPad); err != nil {
+			return nil, err
+		}
+	}
+	data := buff.Bytes()
+	if c.strictChecksum {
+		if err := strictHeaderChecksum(data[headerStart:]); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return data, nil
+}
+
+// EntryInfo describes an archive entry for BuildTarHeader.
+type EntryInfo struct {
+	// Name is the path of the entry in the archive.
+	Name string
+	// Size is the size of the data following the header, 0 for a link.
+	Size    int64
+	ModTime time.Time
+	// AccessTime and ChangeTime default to ModTime.
+	AccessTime time.Time
+	ChangeTime time.Time
+	// Mode defaults to 0600, like the entries s3tar writes.
+	Mode  int64
+	Uid   int
+	Gid   int
+	Uname string
+	Gname string
+	// Linkname makes the entry a hardlink to an earlier entry of the
+	// archive, as s3tar writes the duplicates with --dedup.
+	Linkname string
+	// Metadata is the user metadata of the source object. The
+	// file-permissions, file-owner, file-group and file-*time keys
+	// override the fields above, as with --preserve-posix-metadata.
+	Metadata map[string]string
+	// Xattrs are written as SCHILY.xattr.<name> PAX records.
+	Xattrs map[string]string
+	// PAXRecords are extra PAX records of the entry.
+	PAXRecords map[string]string
+	// Format is the tar format, PAX when left unset.
+	Format tar.Format
+}
+
+// BuildTarHeader returns the tar header of entry the way s3tar writes it in
+// an archive: the header blocks, with any PAX or GNU extended header before
+// them, and no data. Its length is a multiple of 512 bytes. The data of the
+// entry has to be padded to a multiple of 512 bytes before the next header.
+//
+// It lets other tools compute the headers of an archive ahead of time, e.g.
+// to stage them next to the objects. USTAR headers drop the access and
+// change times and truncate the modification time to the second, like the
+// archives do; the names and PAX records they can't hold return an error.
+func BuildTarHeader(entry EntryInfo) ([]byte, error) {
+	if entry.Name == "" {
+		return nil, fmt.Errorf("entry name required")
+	}
+	if entry.Size < 0 {
+		return nil, fmt.Errorf("%s: negative size %d", entry.Name, entry.Size)
+	}
+	hdr := &tar.Header{
+		Name:       entry.Name,
+		Mode:       entry.Mode,
+		Uid:        entry.Uid,
+		Gid:        entry.Gid,
+		Uname:      entry.Uname,
+		Gname:      entry.Gname,
+		Size:       entry.Size,
+		ModTime:    entry.ModTime,
+		AccessTime: entry.AccessTime,
+		ChangeTime: entry.ChangeTime,
+		Format:     entry.Format,
+	}
+	if hdr.Mode == 0 {
+		hdr.Mode = 0600
+	}
+	if hdr.Format == tar.FormatUnknown {
+		hdr.Format = tar.FormatPAX
+	}
+	if hdr.AccessTime.IsZero() {
+		hdr.AccessTime = hdr.ModTime
+	}
+	if hdr.ChangeTime.IsZero() {
+		hdr.ChangeTime = hdr.ModTime
+	}
+	if err := parseHeaderPermissions(hdr, entry.Metadata); err != nil {
+		return nil, fmt.Errorf("%s: %w", entry.Name, err)
+	}
+	if entry.Linkname != "" {
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = entry.Linkname
+		hdr.Size = 0
+	}
+	if len(entry.Xattrs) > 0 || len(entry.PAXRecords) > 0 {
+		hdr.PAXRecords = xattrRecords(entry.Xattrs)
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string, len(entry.PAXRecords))
+		}
+		for k, v := range entry.PAXRecords {
+			hdr.PAXRecords[k] = v
+		}
+	}
+	applyTarFormat(hdr)
+
+	var buff bytes.Buffer
+	tw := tar.NewWriter(&buff)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, fmt.Errorf("%s: %w", entry.Name, err)
+	}
+	// the writer flushes the header on WriteHeader, there is no data to
+	// write and closing it would complain about the missing bytes
+	return buff.Bytes(), nil
+}
+
+// tarHeaderSize returns the size of the header the tar writer emits for a
+// regular entry in the given format. PAX entries carry an extra extended
+// header for the sub-second timestamps.
+func tarHeaderSize(format tar.Format) int64 {
+	switch format {
+	case tar.FormatGNU, tar.FormatUSTAR:
+		return gnuTarHeaderSize
+	default:
+		return paxTarHeaderSize
+	}
+}
+
+// applyTarFormat drops the fields a strict USTAR header is unable to encode,
+// the tar writer refuses the header otherwise.
+func applyTarFormat(hdr *tar.Header) {
+	if hdr.Format == tar.FormatUSTAR {
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+		hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+	}
+}
+
+// parseHeaderPermissions sets the permissions, owner, and group of a tar.Header based on the metadata from s3.HeadObjectOutput.
+// If the "file-permissions" metadata is present, it is parsed as an octal string and set as the Mode of the tar.Header.
+// If the "file-owner" metadata is present, it is parsed as an integer and set as the Uid of the tar.Header.
+// If the "file-group" metadata is present, it is parsed as an integer and set as the Gid of the tar.Header.
+// The hdr parameter is a pointer to the tar.Header that will be modified.
+// If the metadata is empty, no modifications will be made to the tar.Header.
+// It returns the metadata it is unable to parse.
+func parseHeaderPermissions(hdr *tar.Header, s3metadata map[string]string) error {
+	if modeStr, ok := s3metadata["file-permissions"]; ok {
+		modeInt, err := strconv.ParseInt(modeStr, 8, 64)
+		if err != nil {
+			return fmt.Errorf("file-permissions: %w", err)
+		}
+		hdr.Mode = modeInt
+	}
+	if ownerStr, ok := s3metadata["file-owner"]; ok {
+		ownerInt, err := strconv.ParseInt(ownerStr, 10, 32)
+		if err != nil {
+			return fmt.Errorf("file-owner: %w", err)
+		}
+		hdr.Uid = int(ownerInt)
+	}
+	if groupStr, ok := s3metadata["file-group"]; ok {
+		groupInt, err := strconv.ParseInt(groupStr, 10, 32)
+		if err != nil {
+			return fmt.Errorf("file-group: %w", err)
+		}
+		hdr.Gid = int(groupInt)
+	}
+	times := []struct {
+		key string
+		dst *time.Time
+	}{
+		{"file-atime", &hdr.AccessTime},
+		{"file-mtime", &hdr.ModTime},
+		{"file-ctime", &hdr.ChangeTime},
+	}
+	for _, t := range times {
+		if timeStr, ok := s3metadata[t.key]; ok {
+			timeVal, err := parseMetadataTime(timeStr)
+			if err != nil {
+				return fmt.Errorf("%s: %w", t.key, err)
+			}
+			*t.dst = timeVal
+		}
+	}
+	return nil
+}
+
+// parseMetadataTime parses a file-*time metadata value, in milliseconds or
+// in nanoseconds with an "ns" suffix.
+func parseMetadataTime(timeStr string) (time.Time, error) {
+	if strings.HasSuffix(timeStr, "ns") {
+		timeInt, err := strconv.ParseInt(strings.TrimSuffix(timeStr, "ns"), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, timeInt), nil
+	}
+	timeInt, err := strconv.ParseInt(timeStr, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, timeInt*int64(time.Millisecond)), nil
+}
+
+func (c jobConfig) buildHeaders(objectList []*S3Obj, frontPad bool) ([]*S3Obj, error) {
+	headers := make([]*S3Obj, len(objectList))
+	err := forEachChunk(len(objectList), func(_, start, end int) error {
+		for i := start; i < end; i++ {
+			o := objectList[i]
+			name := *o.Key
+			filename := path.Base(name)
+			prev := &S3Obj{Object: types.Object{}}
+			addZero := true
+			if i > 0 {
+				prev = objectList[i-1]
+				addZero = false
+			}
+			if !frontPad {
+				addZero = false
+			}
+			/* buildHeaders is only used to locate the entries with createCSVTOC.
+			 * inspection of createCSVTOC shows that file permissions, uid and gid are not used in the manifest
+			 * therefore we do not need to pass in the head object output
+			 */
+			newObject, err := c.buildHeader(o, prev, addZero, nil)
+			if err != nil {
+				return err
+			}
+			newObject.PartNum = i
+			newObject.Key = aws.String(filename + ".hdr")
+			headers[i] = &newObject
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// strictHeaderChecksum walks the header blocks of a single entry (including
+// PAX and GNU long name headers), rewrites every checksum in the canonical
+// "%06o\x00 " form and makes sure the header would validate with both the
+// unsigned sum from POSIX and the signed sum some extractors (7-Zip, older
+// Windows tar) compute. The two only disagree when a header block contains
+// non-ASCII bytes.
+func strictHeaderChecksum(data []byte) error {
+	for off := 0; off+int(blockSize) <= len(data); {
+		blk := data[off : off+int(blockSize)]
+		var unsigned, signed int64
+		for i, c := range blk {
+			if i >= 148 && i < 156 {
+				c = ' ' // the checksum field counts as spaces
+			}
+			unsigned += int64(c)
+			signed += int64(int8(c))
+		}
+		if unsigned != signed {
+			return fmt.Errorf("tar header has non-ASCII bytes, signed and unsigned checksums differ. Use the pax format")
+		}
+		copy(blk[148:156], fmt.Sprintf("%06o\x00 ", unsigned))
+
+		// extended headers are followed by their records, then the next header
+		switch blk[156] {
+		case tar.TypeXHeader, tar.TypeXGlobalHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+			size, err := strconv.ParseInt(strings.Trim(string(blk[124:136]), " \x00"), 8, 64)
+			if err != nil {
+				return fmt.Errorf("unable to parse extended header size: %w", err)
+			}
+			off += int(blockSize + size + findPadding(size))
+		default:
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestBuildHeaderFormats(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		format tar.Format
+	}{
+		{name: "ustar", format: tar.FormatUSTAR},
+		{name: "pax", format: tar.FormatPAX},
+		{name: "gnu", format: tar.FormatGNU},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newJobConfig(&S3TarS3Options{tarFormat: tt.format})
+			o := NewS3ObjOptions(WithBucketAndKey("bucket", "dir/file.txt"), WithSize(1024))
+			o.LastModified = aws.Time(time.Unix(1700000000, 123456789))
+			h, err := cfg.buildHeader(o, nil, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *h.Size != tarHeaderSize(tt.format) {
+				t.Errorf("header size = %d, want %d", *h.Size, tarHeaderSize(tt.format))
+			}
+			data := append(h.Data, make([]byte, 1024)...)
+			hdr, err := tar.NewReader(bytes.NewReader(data)).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name != "dir/file.txt" || hdr.Size != 1024 {
+				t.Errorf("got %s %d", hdr.Name, hdr.Size)
+			}
+		})
+	}
+}
+
+func TestBuildHeaderLargeEntry(t *testing.T) {
+
+	var size int64 = 300 * 1024 * 1024 * 1024
+	for _, format := range []tar.Format{tar.FormatPAX, tar.FormatGNU} {
+		cfg := newJobConfig(&S3TarS3Options{tarFormat: format})
+		o := NewS3ObjOptions(WithBucketAndKey("bucket", "large.bin"), WithSize(size))
+		h, err := cfg.buildHeader(o, nil, false, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		hdr, err := tar.NewReader(bytes.NewReader(h.Data)).Next()
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if hdr.Size != size {
+			t.Errorf("%s: size = %d, want %d", format, hdr.Size, size)
+		}
+	}
+}
+
+// TestStrictHeaderChecksumMatrix builds headers for the name shapes that tend
+// to trip up extractors and checks archive/tar still reads them after the
+// checksums are rewritten, and that non-ASCII GNU headers are rejected.
+func TestHeaderDataErrors(t *testing.T) {
+	o := NewS3ObjOptions(WithBucketAndKey("bucket", strings.Repeat("long/", 60)+"file.txt"), WithSize(10))
+	// a USTAR header can't hold a name over 256 bytes
+	ustar := newJobConfig(&S3TarS3Options{tarFormat: tar.FormatUSTAR})
+	if _, err := ustar.headerData(o, nil, false, nil); err == nil {
+		t.Errorf("expected the long name to be refused in the ustar format")
+	}
+	if _, err := ustar.entryHeaders([]*S3Obj{o}); err == nil {
+		t.Errorf("expected entryHeaders to fail")
+	}
+	o = NewS3ObjOptions(WithBucketAndKey("bucket", "file.txt"), WithSize(10))
+	head := &s3.HeadObjectOutput{Metadata: map[string]string{"file-permissions": "rw-r--r--"}}
+	if _, err := defaultJobConfig.headerData(o, nil, false, head); err == nil || !strings.Contains(err.Error(), "file.txt") {
+		t.Errorf("expected the permissions to be refused, got %v", err)
+	}
+}
+
+func TestStrictHeaderChecksumMatrix(t *testing.T) {
+
+	names := map[string]string{
+		"short":   "file.txt",
+		"long":    strings.Repeat("directory/", 20) + "file.txt",
+		"unicode": "données/ファイル.txt",
+	}
+	formats := []tar.Format{tar.FormatUSTAR, tar.FormatPAX, tar.FormatGNU}
+	for _, format := range formats {
+		for kind, name := range names {
+			t.Run(format.String()+"/"+kind, func(t *testing.T) {
+				hdr := &tar.Header{
+					Name:    name,
+					Mode:    0600,
+					Size:    10,
+					ModTime: time.Unix(1700000000, 0),
+					Format:  format,
+				}
+				var buf bytes.Buffer
+				tw := tar.NewWriter(&buf)
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Skipf("%s can't encode this name: %s", format, err)
+				}
+				tw.Flush()
+				data := buf.Bytes()
+				err := strictHeaderChecksum(data)
+				wantErr := format == tar.FormatGNU && kind == "unicode"
+				if (err != nil) != wantErr {
+					t.Fatalf("strictHeaderChecksum() error = %v, wantErr %v", err, wantErr)
+				}
+				if wantErr {
+					return
+				}
+				data = append(data, make([]byte, 512)...)
+				got, err := tar.NewReader(bytes.NewReader(data)).Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Name != name {
+					t.Errorf("name = %q, want %q", got.Name, name)
+				}
+			})
+		}
+	}
+}
+
+func TestBuildTarHeader(t *testing.T) {
+
+	mtime := time.Unix(1700000000, 123456789)
+	tests := []struct {
+		name    string
+		entry   EntryInfo
+		want    func(t *testing.T, hdr *tar.Header)
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			entry: EntryInfo{Name: "dir/file.txt", Size: 1000, ModTime: mtime},
+			want: func(t *testing.T, hdr *tar.Header) {
+				if hdr.Mode != 0600 || !hdr.ModTime.Equal(mtime) || !hdr.AccessTime.Equal(mtime) {
+					t.Errorf("mode = %o, mtime = %s, atime = %s", hdr.Mode, hdr.ModTime, hdr.AccessTime)
+				}
+			},
+		},
+		{
+			name: "posix metadata",
+			entry: EntryInfo{Name: "file.txt", Size: 10, ModTime: mtime, Metadata: map[string]string{
+				"file-permissions": "0755",
+				"file-owner":       "1000",
+				"file-group":       "2000",
+				"file-mtime":       "1600000000000000000ns",
+			}},
+			want: func(t *testing.T, hdr *tar.Header) {
+				if hdr.Mode != 0755 || hdr.Uid != 1000 || hdr.Gid != 2000 {
+					t.Errorf("mode = %o, uid = %d, gid = %d", hdr.Mode, hdr.Uid, hdr.Gid)
+				}
+				if !hdr.ModTime.Equal(time.Unix(1600000000, 0)) {
+					t.Errorf("mtime = %s", hdr.ModTime)
+				}
+			},
+		},
+		{
+			name: "pax records",
+			entry: EntryInfo{Name: strings.Repeat("directory/", 20) + "données.txt", Size: 10, ModTime: mtime,
+				Uname: "user", Gname: "group",
+				Xattrs:     map[string]string{"security.selinux": "system_u:object_r:etc_t:s0"},
+				PAXRecords: map[string]string{"comment": "staged"}},
+			want: func(t *testing.T, hdr *tar.Header) {
+				if hdr.Name != strings.Repeat("directory/", 20)+"données.txt" || hdr.Uname != "user" || hdr.Gname != "group" {
+					t.Errorf("name = %q, uname = %q, gname = %q", hdr.Name, hdr.Uname, hdr.Gname)
+				}
+				if hdr.PAXRecords["SCHILY.xattr.security.selinux"] != "system_u:object_r:etc_t:s0" || hdr.PAXRecords["comment"] != "staged" {
+					t.Errorf("records = %v", hdr.PAXRecords)
+				}
+			},
+		},
+		{
+			name:  "large gnu",
+			entry: EntryInfo{Name: "large.bin", Size: 300 << 30, ModTime: mtime, Format: tar.FormatGNU},
+			want: func(t *testing.T, hdr *tar.Header) {
+				if hdr.Size != 300<<30 {
+					t.Errorf("size = %d", hdr.Size)
+				}
+			},
+		},
+		{
+			name:  "ustar truncates times",
+			entry: EntryInfo{Name: "file.txt", Size: 10, ModTime: mtime, Format: tar.FormatUSTAR},
+			want: func(t *testing.T, hdr *tar.Header) {
+				if !hdr.ModTime.Equal(time.Unix(1700000000, 0)) {
+					t.Errorf("mtime = %s", hdr.ModTime)
+				}
+			},
+		},
+		{
+			name:  "link",
+			entry: EntryInfo{Name: "copy.txt", Size: 10, ModTime: mtime, Linkname: "file.txt"},
+			want: func(t *testing.T, hdr *tar.Header) {
+				if hdr.Typeflag != tar.TypeLink || hdr.Linkname != "file.txt" || hdr.Size != 0 {
+					t.Errorf("type = %c, link = %q, size = %d", hdr.Typeflag, hdr.Linkname, hdr.Size)
+				}
+			},
+		},
+		{name: "no name", entry: EntryInfo{Size: 10}, wantErr: true},
+		{name: "negative size", entry: EntryInfo{Name: "file.txt", Size: -1}, wantErr: true},
+		{name: "bad metadata", entry: EntryInfo{Name: "file.txt", Metadata: map[string]string{"file-owner": "root"}}, wantErr: true},
+		{name: "ustar xattrs", entry: EntryInfo{Name: "file.txt", Format: tar.FormatUSTAR, Xattrs: map[string]string{"user.a": "b"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := BuildTarHeader(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildTarHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(data)%512 != 0 {
+				t.Fatalf("header length %d isn't a multiple of 512", len(data))
+			}
+			tr := tar.NewReader(bytes.NewReader(data))
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want(t, hdr)
+		})
+	}
+}
+
+// TestBuildTarHeaderArchive checks the headers line up with the ones s3tar
+// writes, so an archive made of them and padded data reads back.
+func TestBuildTarHeaderArchive(t *testing.T) {
+
+	for _, format := range []tar.Format{tar.FormatPAX, tar.FormatGNU} {
+		data, err := BuildTarHeader(EntryInfo{Name: "file.txt", Size: 1024, ModTime: time.Now(), Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != tarHeaderSize(format) {
+			t.Errorf("%s: header size = %d, want %d", format, len(data), tarHeaderSize(format))
+		}
+	}
+
+	var archive bytes.Buffer
+	contents := []string{"first", "second entry", strings.Repeat("x", 600)}
+	for i, c := range contents {
+		data, err := BuildTarHeader(EntryInfo{Name: fmt.Sprintf("file%d.txt", i), Size: int64(len(c)), ModTime: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive.Write(data)
+		archive.WriteString(c)
+		archive.Write(make([]byte, findPadding(int64(len(c)))))
+	}
+	archive.Write(make([]byte, 1024))
+	tr := tar.NewReader(&archive)
+	for i, c := range contents {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != fmt.Sprintf("file%d.txt", i) || string(got) != c {
+			t.Errorf("entry %d = %s %q", i, hdr.Name, got)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Next() = %v, want EOF", err)
+	}
+}
+
+// testHeaderData is headerData failing the test on an error.
+func testHeaderData(t testing.TB, c jobConfig, o, prev *S3Obj) []byte {
+	t.Helper()
+	data, err := c.headerData(o, prev, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testEntryHeaders is entryHeaders failing the test on an error.
+func testEntryHeaders(t testing.TB, c jobConfig, entries []*S3Obj) []*S3Obj {
+	t.Helper()
+	headers, err := c.entryHeaders(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return headers
+}
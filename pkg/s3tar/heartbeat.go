@@ -0,0 +1,163 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const contextKeyHeartbeat = contextKey("heartbeat")
+
+// Stages of HeartbeatStatus.
+const (
+	HeartbeatPreparing   = "preparing"
+	HeartbeatBuilding    = "building"
+	HeartbeatVerifying   = "verifying"
+	HeartbeatCleaningUp  = "cleaning up"
+	HeartbeatComplete    = "complete"
+	HeartbeatFailed      = "failed"
+	HeartbeatInterrupted = "interrupted"
+)
+
+// HeartbeatStatus is the content of the heartbeat object of an archive,
+// rewritten every HeartbeatInterval while the archive is created. A
+// monitor reads UpdatedAt to detect a stuck job.
+type HeartbeatStatus struct {
+	Archive string `json:"archive"`
+	JobID   string `json:"jobId"`
+	Stage   string `json:"stage"`
+	// Percent is the share of the entries copied into the archive, 100
+	// once it is complete.
+	Percent     float64   `json:"percent"`
+	Entries     int       `json:"entries"`
+	EntriesDone int       `json:"entriesDone"`
+	StartedAt   time.Time `json:"startedAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type heartbeat struct {
+	mu     sync.Mutex
+	status HeartbeatStatus
+}
+
+// heartbeatKey is the key of the heartbeat object, next to the archive.
+func heartbeatKey(opts *S3TarS3Options) string {
+	return opts.DstKey + ".heartbeat.json"
+}
+
+// startHeartbeat writes the heartbeat object of the archive every
+// opts.HeartbeatInterval until the returned function is called with the
+// result of the job, which writes it a last time. It reuses the heartbeat
+// the JobServer put in ctx to follow the progress of its jobs.
+func startHeartbeat(ctx context.Context, svc Backend, opts *S3TarS3Options) (context.Context, func(error)) {
+	if opts.HeartbeatInterval <= 0 {
+		return ctx, func(error) {}
+	}
+	now := time.Now().UTC()
+	h := heartbeatFromContext(ctx)
+	if h == nil {
+		h = &heartbeat{}
+		ctx = context.WithValue(ctx, contextKeyHeartbeat, h)
+	}
+	h.mu.Lock()
+	h.status = HeartbeatStatus{
+		Archive:   "s3://" + opts.DstBucket + "/" + opts.DstKey,
+		JobID:     opts.jobID,
+		Stage:     HeartbeatPreparing,
+		StartedAt: now,
+	}
+	h.mu.Unlock()
+	// the last write must happen after the job context is canceled
+	writeCtx := context.WithoutCancel(ctx)
+	h.write(writeCtx, svc, opts)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(opts.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				h.write(writeCtx, svc, opts)
+			}
+		}
+	}()
+	return ctx, func(err error) {
+		close(stop)
+		wg.Wait()
+		h.mu.Lock()
+		switch {
+		case err == nil:
+			h.status.Stage, h.status.Percent = HeartbeatComplete, 100
+		case errors.Is(err, ErrInterrupted):
+			h.status.Stage, h.status.Error = HeartbeatInterrupted, err.Error()
+		default:
+			h.status.Stage, h.status.Error = HeartbeatFailed, err.Error()
+		}
+		h.mu.Unlock()
+		h.write(writeCtx, svc, opts)
+	}
+}
+
+// write puts the status to the heartbeat object. A failed write is only
+// logged, the heartbeat doesn't fail the job.
+func (h *heartbeat) write(ctx context.Context, svc Backend, opts *S3TarS3Options) {
+	h.mu.Lock()
+	h.status.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(h.status, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		Warnf(ctx, "unable to encode the heartbeat: %s", err.Error())
+		return
+	}
+	if _, err := putObject(ctx, svc, opts.DstBucket, heartbeatKey(opts), data); err != nil {
+		Warnf(ctx, "unable to write the heartbeat s3://%s/%s: %s", opts.DstBucket, heartbeatKey(opts), err.Error())
+	}
+}
+
+func heartbeatFromContext(ctx context.Context) *heartbeat {
+	if h, ok := ctx.Value(contextKeyHeartbeat).(*heartbeat); ok {
+		return h
+	}
+	return nil
+}
+
+// heartbeatStage sets the stage of the job, and the number of entries when
+// entries isn't 0.
+func heartbeatStage(ctx context.Context, stage string, entries int) {
+	if h := heartbeatFromContext(ctx); h != nil {
+		h.mu.Lock()
+		h.status.Stage = stage
+		if entries > 0 {
+			h.status.Entries = entries
+		}
+		h.mu.Unlock()
+	}
+}
+
+// heartbeatProgress records n more entries copied into the archive.
+func heartbeatProgress(ctx context.Context, n int) {
+	h := heartbeatFromContext(ctx)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.EntriesDone += n
+	if h.status.Entries > 0 {
+		// 100 is left for the archive once complete
+		h.status.Percent = min(99.9, math.Round(float64(h.status.EntriesDone)*1000/float64(h.status.Entries))/10)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestHeartbeat(t *testing.T) {
+	var body bytes.Buffer
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   auditHTTPClient{body: &body},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	opts := &S3TarS3Options{DstBucket: "dst-bucket", DstKey: "archive.tar", jobID: "abcd"}
+	if ctx, stop := startHeartbeat(context.Background(), svc, opts); heartbeatFromContext(ctx) != nil {
+		t.Errorf("no heartbeat is written without an interval")
+	} else {
+		stop(nil)
+	}
+
+	opts.HeartbeatInterval = time.Millisecond
+	ctx, stop := startHeartbeat(context.Background(), svc, opts)
+	h := heartbeatFromContext(ctx)
+	status := func() HeartbeatStatus {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.status
+	}
+	heartbeatStage(ctx, HeartbeatBuilding, 3)
+	heartbeatProgress(ctx, 1)
+	if s := status(); s.Stage != HeartbeatBuilding || s.Percent != 33.3 {
+		t.Errorf("unexpected status %+v", s)
+	}
+	heartbeatProgress(ctx, 3)
+	if s := status(); s.Percent != 99.9 {
+		t.Errorf("expected 99.9%% until complete, got %v", s.Percent)
+	}
+	stop(fmt.Errorf("%w: s3://dst-bucket/archive.tar can be resumed with --resume", ErrInterrupted))
+	if status().Stage != HeartbeatInterrupted || !bytes.Contains(body.Bytes(), []byte(`"stage": "interrupted"`)) {
+		t.Errorf("unexpected heartbeat %s", body.String())
+	}
+
+	_, stop = startHeartbeat(context.Background(), svc, opts)
+	stop(nil)
+	if !bytes.Contains(body.Bytes(), []byte(`"stage": "complete"`)) || !bytes.Contains(body.Bytes(), []byte(`"percent": 100`)) {
+		t.Errorf("unexpected heartbeat %s", body.String())
+	}
+}
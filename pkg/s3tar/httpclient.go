@@ -0,0 +1,90 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// HTTPClientOptions configures the HTTP transport of the S3 client. Zero
+// values keep the SDK defaults.
+type HTTPClientOptions struct {
+	// Timeout is the time limit of a whole request, including reading the
+	// response body.
+	Timeout time.Duration
+	// ResponseHeaderTimeout is the time to wait for the response headers
+	// after the request is sent. UploadPartCopy of a 5GB part can take
+	// minutes before S3 responds.
+	ResponseHeaderTimeout time.Duration
+	// MaxIdleConnsPerHost is the number of connections kept open to S3. The
+	// SDK default of 10 is too low when running hundreds of goroutines.
+	MaxIdleConnsPerHost int
+	// ProxyURL overrides the HTTP_PROXY and HTTPS_PROXY variables.
+	ProxyURL string
+	// CABundle is a PEM file with additional certificate authorities, e.g.
+	// of a TLS inspecting proxy.
+	CABundle string
+}
+
+// NewHTTPClient returns an HTTP client for the S3 client, to use with
+// config.WithHTTPClient.
+func NewHTTPClient(o HTTPClientOptions) (aws.HTTPClient, error) {
+	var proxy *url.URL
+	if o.ProxyURL != "" {
+		var err error
+		proxy, err = url.Parse(o.ProxyURL)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url %q", o.ProxyURL)
+		}
+	}
+
+	var rootCAs *x509.CertPool
+	if o.CABundle != "" {
+		pem, err := os.ReadFile(o.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs, err = x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CABundle)
+		}
+	}
+
+	client := awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+		if o.ResponseHeaderTimeout > 0 {
+			t.ResponseHeaderTimeout = o.ResponseHeaderTimeout
+		}
+		if o.MaxIdleConnsPerHost > 0 {
+			t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+			if t.MaxIdleConns < o.MaxIdleConnsPerHost {
+				t.MaxIdleConns = o.MaxIdleConnsPerHost
+			}
+		}
+		if proxy != nil {
+			t.Proxy = http.ProxyURL(proxy)
+		}
+		if rootCAs != nil {
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
+			}
+			t.TLSClientConfig.RootCAs = rootCAs
+		}
+	})
+	if o.Timeout > 0 {
+		client = client.WithTimeout(o.Timeout)
+	}
+	return client, nil
+}
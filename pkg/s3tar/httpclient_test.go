@@ -0,0 +1,48 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	client, err := NewHTTPClient(HTTPClientOptions{
+		Timeout:               10 * time.Minute,
+		ResponseHeaderTimeout: 5 * time.Minute,
+		MaxIdleConnsPerHost:   500,
+		ProxyURL:              "http://proxy.example.com:3128",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.(*awshttp.BuildableClient)
+	tr := c.GetTransport()
+	if c.GetTimeout() != 10*time.Minute || tr.ResponseHeaderTimeout != 5*time.Minute {
+		t.Errorf("timeout = %s response header timeout = %s", c.GetTimeout(), tr.ResponseHeaderTimeout)
+	}
+	if tr.MaxIdleConnsPerHost != 500 || tr.MaxIdleConns < 500 {
+		t.Errorf("MaxIdleConnsPerHost = %d MaxIdleConns = %d", tr.MaxIdleConnsPerHost, tr.MaxIdleConns)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://s3.us-west-2.amazonaws.com/", nil)
+	proxy, err := tr.Proxy(req)
+	if err != nil || proxy.Host != "proxy.example.com:3128" {
+		t.Errorf("proxy = %v err = %v", proxy, err)
+	}
+
+	if _, err := NewHTTPClient(HTTPClientOptions{ProxyURL: "proxy.example.com"}); err == nil {
+		t.Errorf("expected an error for a proxy url without a scheme")
+	}
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(bundle, []byte("not a certificate"), 0o600)
+	if _, err := NewHTTPClient(HTTPClientOptions{CABundle: bundle}); err == nil {
+		t.Errorf("expected an error for a CA bundle without certificates")
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Manifest column names accepted in ManifestOptions.Columns. Any other name
+// (or an empty one) skips the column.
+const (
+	ManifestColumnBucket       = "bucket"
+	ManifestColumnKey          = "key"
+	ManifestColumnSize         = "size"
+	ManifestColumnETag         = "etag"
+	ManifestColumnVersionId    = "versionid"
+	ManifestColumnLastModified = "lastmodified"
+	// ManifestColumnOffset and ManifestColumnLength archive a slice of the
+	// object, length bytes from offset. Size is still the size of the
+	// object.
+	ManifestColumnOffset = "offset"
+	ManifestColumnLength = "length"
+	// ManifestColumnXattrs are the extended attributes of the entry, see
+	// ParseXattrs.
+	ManifestColumnXattrs = "xattrs"
+)
+
+// DefaultManifestColumns is the column order of a manifest without a mapping.
+var DefaultManifestColumns = []string{ManifestColumnBucket, ManifestColumnKey, ManifestColumnSize, ManifestColumnETag}
+
+// ManifestOptions describe the layout of a manifest CSV.
+type ManifestOptions struct {
+	SkipHeader bool
+	UrlDecode  bool
+	// Columns maps each column of the manifest to a field, e.g.
+	// bucket,key,versionId,-,-,size. When empty and SkipHeader is set, the
+	// header line is used if it names the bucket and key columns, otherwise
+	// DefaultManifestColumns.
+	Columns []string
+	// Delimiter is the field separator, defaults to ','.
+	Delimiter rune
+	// LazyQuotes allows quotes inside unquoted fields and unescaped quotes
+	// inside quoted fields.
+	LazyQuotes bool
+}
+
+// ParseManifestColumns parses a comma separated column mapping such as
+// "bucket,key,versionId,-,-,size".
+func ParseManifestColumns(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	columns := strings.Split(s, ",")
+	for i, c := range columns {
+		columns[i] = normalizeColumnName(c)
+	}
+	if err := checkManifestColumns(columns); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+func normalizeColumnName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.NewReplacer("_", "", "-", "", " ", "").Replace(name)
+}
+
+func checkManifestColumns(columns []string) error {
+	known := map[string]bool{}
+	for _, c := range DefaultManifestColumns {
+		known[c] = true
+	}
+	known[ManifestColumnVersionId] = true
+	known[ManifestColumnLastModified] = true
+	known[ManifestColumnOffset] = true
+	known[ManifestColumnLength] = true
+	known[ManifestColumnXattrs] = true
+	found := map[string]bool{}
+	for _, c := range columns {
+		if known[c] && found[c] {
+			return fmt.Errorf("manifest column %s is mapped twice", c)
+		}
+		found[c] = true
+	}
+	for _, required := range []string{ManifestColumnBucket, ManifestColumnKey, ManifestColumnSize} {
+		if !found[required] {
+			return fmt.Errorf("manifest columns must include %s", required)
+		}
+	}
+	return nil
+}
+
+func LoadCSV(ctx context.Context, svc *s3.Client, fpath string, skipHeader, urlDecode bool) ([]*S3Obj, int64, error) {
+	return LoadManifest(ctx, svc, fpath, ManifestOptions{SkipHeader: skipHeader, UrlDecode: urlDecode})
+}
+
+// LoadManifest loads a manifest CSV from a local path or s3://bucket/key.
+func LoadManifest(ctx context.Context, svc *s3.Client, fpath string, opts ManifestOptions) ([]*S3Obj, int64, error) {
+	r, err := loadFile(ctx, svc, fpath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	start := time.Now()
+	list, accum, err := parseManifest(ctx, r, opts)
+	recordStage(ctx, "manifest", start)
+	return list, accum, err
+}
+
+func parseCSV(f io.Reader, skipHeader bool, urlDecode bool) ([]*S3Obj, int64, error) {
+	return parseManifest(context.Background(), f, ManifestOptions{SkipHeader: skipHeader, UrlDecode: urlDecode})
+}
+
+func parseManifest(ctx context.Context, f io.Reader, mo ManifestOptions) ([]*S3Obj, int64, error) {
+	var data []*S3Obj
+	var accum int64
+	mr := NewManifestReader(f, mo)
+	mr.ctx = ctx
+	for {
+		obj, err := mr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		data = append(data, obj)
+		accum += estimateObjectSize(*obj.Size)
+	}
+	return data, accum, nil
+}
+
+// ManifestReader reads a manifest one line at a time so manifests with
+// hundreds of millions of rows can be processed in bounded memory.
+type ManifestReader struct {
+	r          *csv.Reader
+	body       io.Closer
+	opts       ManifestOptions
+	columns    []string
+	lineNumber int
+	// ctx is the context the skipped lines are logged with
+	ctx context.Context
+}
+
+// NewManifestReader returns a ManifestReader that parses f.
+func NewManifestReader(f io.Reader, opts ManifestOptions) *ManifestReader {
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = opts.LazyQuotes
+	r.ReuseRecord = true
+	if opts.Delimiter != 0 {
+		r.Comma = opts.Delimiter
+	}
+	return &ManifestReader{r: r, opts: opts, columns: opts.Columns, ctx: context.Background()}
+}
+
+// OpenManifest opens a manifest from a local path or s3://bucket/key. The
+// caller must Close the reader.
+func OpenManifest(ctx context.Context, svc *s3.Client, fpath string, opts ManifestOptions) (*ManifestReader, error) {
+	f, err := loadFile(ctx, svc, fpath)
+	if err != nil {
+		return nil, err
+	}
+	mr := NewManifestReader(f, opts)
+	mr.body = f
+	mr.ctx = ctx
+	return mr, nil
+}
+
+// Close closes the underlying file or S3 object.
+func (m *ManifestReader) Close() error {
+	if m.body == nil {
+		return nil
+	}
+	return m.body.Close()
+}
+
+// Next returns the next object of the manifest or io.EOF at the end.
+func (m *ManifestReader) Next() (*S3Obj, error) {
+	for {
+		record, err := m.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		lineNumber := m.lineNumber
+		m.lineNumber++
+		if lineNumber == 0 && m.opts.SkipHeader {
+			if len(m.columns) == 0 {
+				m.columns = headerColumns(record)
+			}
+			continue
+		}
+		if len(m.columns) == 0 {
+			m.columns = DefaultManifestColumns
+		}
+		if obj := m.parseRecord(record, lineNumber); obj != nil {
+			return obj, nil
+		}
+	}
+}
+
+// ReadChunk returns up to n objects and their estimated size in the archive.
+// It returns io.EOF once the manifest has no more objects.
+func (m *ManifestReader) ReadChunk(n int) ([]*S3Obj, int64, error) {
+	var data []*S3Obj
+	var accum int64
+	for len(data) < n {
+		obj, err := m.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		data = append(data, obj)
+		accum += estimateObjectSize(*obj.Size)
+	}
+	if len(data) == 0 {
+		return nil, 0, io.EOF
+	}
+	return data, accum, nil
+}
+
+func (m *ManifestReader) parseRecord(record []string, lineNumber int) *S3Obj {
+	fields := map[string]string{}
+	for i, c := range m.columns {
+		if i < len(record) && c != "" {
+			fields[c] = record[i]
+		}
+	}
+	if _, ok := fields[ManifestColumnSize]; !ok {
+		Warnf(m.ctx, "not enough values in csv line. skipping line %d", lineNumber+1)
+		return nil
+	}
+
+	size, err := strconv.ParseInt(fields[ManifestColumnSize], 10, 64)
+	if err != nil {
+		Warnf(m.ctx, "unable to parse size on line %d. setting to zero", lineNumber+1)
+		size = 0
+	}
+
+	key := fields[ManifestColumnKey]
+	if m.opts.UrlDecode {
+		key, err = url.QueryUnescape(key)
+		if err != nil {
+			key = fields[ManifestColumnKey]
+		}
+	}
+
+	opts := []func(*S3Obj){
+		WithBucketAndKey(fields[ManifestColumnBucket], key),
+		WithSize(size),
+	}
+
+	if etag, ok := fields[ManifestColumnETag]; ok {
+		opts = append(opts, WithETag(etag))
+	}
+
+	obj := NewS3ObjOptions(opts...)
+	obj.VersionId = fields[ManifestColumnVersionId]
+	if lm := fields[ManifestColumnLastModified]; lm != "" {
+		if t, err := time.Parse(time.RFC3339, lm); err == nil {
+			obj.LastModified = &t
+		}
+	}
+	if fields[ManifestColumnOffset] != "" || fields[ManifestColumnLength] != "" {
+		if err := sliceObject(obj, fields[ManifestColumnOffset], fields[ManifestColumnLength]); err != nil {
+			Warnf(m.ctx, "%s. skipping line %d", err.Error(), lineNumber+1)
+			return nil
+		}
+	}
+	if x := fields[ManifestColumnXattrs]; x != "" {
+		xattrs, err := ParseXattrs(x)
+		if err != nil {
+			Warnf(m.ctx, "%s. skipping line %d", err.Error(), lineNumber+1)
+			return nil
+		}
+		obj.Xattrs = xattrs
+	}
+	return obj
+}
+
+// sliceObject makes obj the slice of length bytes from offset of the
+// object, the rest of the object when length is empty. The entry is named
+// key.<first byte>-<last byte> so the slices of an object don't overwrite
+// each other.
+func sliceObject(obj *S3Obj, offset, length string) error {
+	var start int64
+	var err error
+	if offset != "" {
+		if start, err = strconv.ParseInt(offset, 10, 64); err != nil || start < 0 {
+			return fmt.Errorf("invalid offset %q", offset)
+		}
+	}
+	size := *obj.Size - start
+	if length != "" {
+		if size, err = strconv.ParseInt(length, 10, 64); err != nil || size <= 0 {
+			return fmt.Errorf("invalid length %q", length)
+		}
+	}
+	if size <= 0 || start+size > *obj.Size {
+		return fmt.Errorf("the slice %d-%d is outside of s3://%s/%s (%d bytes)", start, start+size-1, obj.Bucket, *obj.Key, *obj.Size)
+	}
+	if start == 0 && size == *obj.Size {
+		return nil
+	}
+	obj.Slice, obj.Offset = true, start
+	obj.Size = aws.Int64(size)
+	obj.Name = fmt.Sprintf("%s.%d-%d", *obj.Key, start, start+size-1)
+	return nil
+}
+
+// headerColumns maps a header line to column names. It returns nil when the
+// header doesn't name the bucket and key columns so the default order is used.
+func headerColumns(header []string) []string {
+	columns := make([]string, len(header))
+	for i, h := range header {
+		columns[i] = normalizeColumnName(h)
+	}
+	if checkManifestColumns(columns) != nil {
+		return nil
+	}
+	return columns
+}
@@ -0,0 +1,195 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseManifestColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"default", "bucket,key,size,etag", []string{"bucket", "key", "size", "etag"}, false},
+		{"inventory", "Bucket,Key,VersionId,-,-,Size", []string{"bucket", "key", "versionid", "", "", "size"}, false},
+		{"missing size", "bucket,key", nil, true},
+		{"twice", "bucket,key,size,key", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseManifestColumns(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseManifestColumns() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ParseManifestColumns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		opts        ManifestOptions
+		wantKey     string
+		wantSize    int64
+		wantVersion string
+	}{
+		{
+			name:     "default order",
+			input:    "my-bucket,dir/a.txt,100,abc\n",
+			wantKey:  "dir/a.txt",
+			wantSize: 100,
+		},
+		{
+			name:        "column mapping",
+			input:       "my-bucket,dir/a.txt,v1,true,false,100\n",
+			opts:        ManifestOptions{Columns: []string{"bucket", "key", "versionid", "", "", "size"}},
+			wantKey:     "dir/a.txt",
+			wantSize:    100,
+			wantVersion: "v1",
+		},
+		{
+			name:     "tab delimiter",
+			input:    "my-bucket\tdir/a,b.txt\t100\n",
+			opts:     ManifestOptions{Delimiter: '\t'},
+			wantKey:  "dir/a,b.txt",
+			wantSize: 100,
+		},
+		{
+			name:     "header mapping",
+			input:    "Size,Key,Bucket\n100,dir/a.txt,my-bucket\n",
+			opts:     ManifestOptions{SkipHeader: true},
+			wantKey:  "dir/a.txt",
+			wantSize: 100,
+		},
+		{
+			name:     "lazy quotes",
+			input:    "my-bucket,dir/a\"b.txt,100\n",
+			opts:     ManifestOptions{LazyQuotes: true},
+			wantKey:  "dir/a\"b.txt",
+			wantSize: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, _, err := parseManifest(context.Background(), strings.NewReader(tt.input), tt.opts)
+			if err != nil {
+				t.Fatalf("parseManifest() error = %v", err)
+			}
+			if len(list) != 1 {
+				t.Fatalf("parseManifest() returned %d objects, want 1", len(list))
+			}
+			o := list[0]
+			if o.Bucket != "my-bucket" || *o.Key != tt.wantKey || *o.Size != tt.wantSize || o.VersionId != tt.wantVersion {
+				t.Errorf("parseManifest() = %s %s %d %s", o.Bucket, *o.Key, *o.Size, o.VersionId)
+			}
+		})
+	}
+}
+
+func TestMaybeGunzip(t *testing.T) {
+	const manifest = "my-bucket,dir/a.txt,100\n"
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(manifest))
+	zw.Close()
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"plain", []byte(manifest)},
+		{"gzip", compressed.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := maybeGunzip(io.NopCloser(bytes.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("maybeGunzip() error = %v", err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != manifest {
+				t.Errorf("maybeGunzip() = %q, want %q", got, manifest)
+			}
+		})
+	}
+}
+
+func TestManifestReaderReadChunk(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 7; i++ {
+		sb.WriteString("my-bucket,file.txt,10\n")
+	}
+	mr := NewManifestReader(strings.NewReader(sb.String()), ManifestOptions{})
+	var sizes []int
+	for {
+		chunk, _, err := mr.ReadChunk(3)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("ReadChunk() error = %v", err)
+		}
+		sizes = append(sizes, len(chunk))
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf("ReadChunk() chunk sizes = %v, want [3 3 1]", sizes)
+	}
+}
+
+func TestManifestSlices(t *testing.T) {
+	input := "bucket,key,size,etag,offset,length\n" +
+		"my-bucket,logs/app.log,1000,abc,100,50\n" +
+		"my-bucket,logs/app.log,1000,abc,900,\n" +
+		"my-bucket,logs/app.log,1000,abc,0,1000\n" +
+		"my-bucket,logs/app.log,1000,abc,990,50\n" +
+		"my-bucket,logs/app.log,1000,abc,-1,10\n"
+	list, _, err := parseManifest(context.Background(), strings.NewReader(input), ManifestOptions{SkipHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("got %d objects, want 3, the slices outside of the object are skipped", len(list))
+	}
+	want := []struct {
+		name   string
+		slice  bool
+		offset int64
+		size   int64
+	}{
+		{"logs/app.log.100-149", true, 100, 50},
+		{"logs/app.log.900-999", true, 900, 100},
+		{"logs/app.log", false, 0, 1000},
+	}
+	for i, w := range want {
+		o := list[i]
+		if o.entryName() != w.name || o.Slice != w.slice || o.Offset != w.offset || *o.Size != w.size {
+			t.Errorf("%d: got %s %t %d %d, want %+v", i, o.entryName(), o.Slice, o.Offset, *o.Size, w)
+		}
+	}
+	if got := copySourceRange(list[0], 10, 50); got != "bytes=110-149" {
+		t.Errorf("copySourceRange() = %s", got)
+	}
+}
+
+func TestChunkedArchiveName(t *testing.T) {
+	if got := ChunkedArchiveName("prefix/archive.tar", 3); got != "prefix/archive.00003.tar" {
+		t.Errorf("ChunkedArchiveName() = %s", got)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policies for the keys that can't be archived as is, see
+// S3TarS3Options.CheckKeys.
+const (
+	// CheckKeysError fails the archive before anything is uploaded.
+	CheckKeysError = "error"
+	// CheckKeysSkip leaves the objects out of the archive.
+	CheckKeysSkip = "skip"
+)
+
+const maxKeyLength = 1024
+
+// invalidKeyReason returns why a key can't be archived, or "" when it can.
+// Spaces, +, % and other UTF-8 characters are valid, they are URL-encoded in
+// the CopySource.
+func invalidKeyReason(key string) string {
+	switch {
+	case key == "":
+		return "empty key"
+	case len(key) > maxKeyLength:
+		return fmt.Sprintf("longer than %d bytes", maxKeyLength)
+	case !utf8.ValidString(key):
+		return "not valid UTF-8, check --urldecode"
+	case strings.HasPrefix(key, "/"):
+		return "absolute path"
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return fmt.Sprintf("control character %U", r)
+		}
+	}
+	for _, s := range strings.Split(key, "/") {
+		if s == "." || s == ".." {
+			return "contains a . or .. path segment"
+		}
+	}
+	return ""
+}
+
+// checkKeys validates the keys of objectList per policy. It returns the
+// objects to archive.
+func checkKeys(ctx context.Context, objectList []*S3Obj, policy string) ([]*S3Obj, error) {
+	if policy == "" {
+		return objectList, nil
+	}
+	valid := make([]*S3Obj, 0, len(objectList))
+	var invalid []string
+	var skipped []AuditRecord
+	for _, o := range objectList {
+		reason := invalidKeyReason(*o.Key)
+		if reason == "" {
+			valid = append(valid, o)
+			continue
+		}
+		Warnf(ctx, "invalid key %q in %s: %s", *o.Key, o.Bucket, reason)
+		invalid = append(invalid, *o.Key)
+		r := auditObject(AuditSkipped, "", o)
+		r.Reason = "invalid key: " + reason
+		skipped = append(skipped, r)
+	}
+	if len(invalid) == 0 {
+		return objectList, nil
+	}
+	if policy == CheckKeysSkip {
+		Warnf(ctx, "skipping %d objects with invalid keys", len(invalid))
+		for _, r := range skipped {
+			audit(ctx, r)
+		}
+		return valid, nil
+	}
+	return nil, fmt.Errorf("%d objects have keys that can't be archived, the first one is %q", len(invalid), invalid[0])
+}
+
+// CheckArchiveRoot returns an error when root can't be the top-level
+// directory of the entries of an archive. The slashes around it are
+// optional.
+func CheckArchiveRoot(root string) error {
+	trimmed := strings.TrimSuffix(root, "/")
+	if reason := invalidKeyReason(trimmed); reason != "" {
+		return fmt.Errorf("archive root %q: %s", root, reason)
+	}
+	for _, s := range strings.Split(trimmed, "/") {
+		if s == "" {
+			return fmt.Errorf("archive root %q: empty path segment", root)
+		}
+	}
+	return nil
+}
+
+// withArchiveRoot returns copies of the objects of objectList named under
+// root. The objects are copied, objectList may be archived again.
+func withArchiveRoot(objectList []*S3Obj, root string) []*S3Obj {
+	root = strings.TrimSuffix(root, "/")
+	if root == "" {
+		return objectList
+	}
+	rooted := make([]*S3Obj, len(objectList))
+	for i, o := range objectList {
+		c := *o
+		c.Name = root + "/" + o.entryName()
+		rooted[i] = &c
+	}
+	return rooted
+}
+
+// clusterByPrefix returns objectList ordered by the directory of the entry
+// names, the order of the list is kept within a directory. The entries under
+// any prefix ending with a slash are then adjacent, the ones directly in it
+// first: "a/" sorts after "a-b/" and before "a/b/". The objects at the root
+// come first.
+func clusterByPrefix(objectList []*S3Obj) []*S3Obj {
+	clustered := make([]*S3Obj, len(objectList))
+	copy(clustered, objectList)
+	dir := func(o *S3Obj) string {
+		name := o.entryName()
+		return name[:strings.LastIndex(name, "/")+1]
+	}
+	sort.SliceStable(clustered, func(i, j int) bool {
+		return dir(clustered[i]) < dir(clustered[j])
+	})
+	return clustered
+}
+
+// JoinKey joins the elements of an S3 key with slashes, whatever the OS
+// s3tar runs on. filepath.Join uses backslashes on Windows.
+func JoinKey(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// extractKey returns the key the entry name is extracted to under prefix.
+// Names that are absolute, have . or .. elements or can't be S3 keys are
+// refused, a crafted TOC or tar header would otherwise write outside of
+// prefix. The ./ of the names of tar -C dir . is dropped.
+func extractKey(prefix, name string) (string, error) {
+	for strings.HasPrefix(name, "./") {
+		name = name[2:]
+	}
+	if reason := invalidKeyReason(name); reason != "" {
+		return "", fmt.Errorf("entry %q can't be extracted: %s", name, reason)
+	}
+	key := JoinKey(prefix, name)
+	if base := JoinKey(prefix); base != "." && !strings.HasPrefix(key, base+"/") {
+		return "", fmt.Errorf("entry %q is extracted outside of %s", name, prefix)
+	}
+	return key, nil
+}
+
+// KeyDir returns the prefix of a key without its last element, "." when it
+// has none like filepath.Dir.
+func KeyDir(key string) string {
+	return path.Dir(key)
+}
+
+// NormalizePrefix turns the backslashes of an S3 URL or prefix typed on
+// Windows into slashes and drops the empty elements, keeping the trailing
+// slash of a prefix and the s3:// scheme.
+func NormalizePrefix(prefix string) string {
+	scheme := ""
+	if strings.HasPrefix(prefix, "s3://") {
+		scheme, prefix = "s3://", strings.TrimPrefix(prefix, "s3://")
+	}
+	prefix = strings.ReplaceAll(prefix, "\\", "/")
+	elems := strings.Split(prefix, "/")
+	kept := elems[:0]
+	for i, e := range elems {
+		if e != "" || i == len(elems)-1 {
+			kept = append(kept, e)
+		}
+	}
+	return scheme + strings.Join(kept, "/")
+}
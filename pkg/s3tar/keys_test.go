@@ -0,0 +1,137 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestInvalidKeyReason(t *testing.T) {
+	valid := []string{"file.txt", "dir/my file.txt", "a+b.txt", "100%.txt", "naïve/日本.txt", "dir/"}
+	for _, key := range valid {
+		if reason := invalidKeyReason(key); reason != "" {
+			t.Errorf("%q should be valid: %s", key, reason)
+		}
+	}
+	invalid := []string{"", "/etc/passwd", "dir/../../etc/passwd", "./file.txt", "new\nline.txt", "tab\t.txt", "bad\xff.txt", strings.Repeat("a", 1025)}
+	for _, key := range invalid {
+		if invalidKeyReason(key) == "" {
+			t.Errorf("%q should be invalid", key)
+		}
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	ctx := context.Background()
+	objectList := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("my-bucket", "good.txt")),
+		NewS3ObjOptions(WithBucketAndKey("my-bucket", "../bad.txt")),
+		NewS3ObjOptions(WithBucketAndKey("my-bucket", "also good.txt")),
+	}
+	if got, err := checkKeys(ctx, objectList, ""); err != nil || len(got) != 3 {
+		t.Errorf("no policy: %d objects, err %v", len(got), err)
+	}
+	if _, err := checkKeys(ctx, objectList, CheckKeysError); err == nil || !strings.Contains(err.Error(), "../bad.txt") {
+		t.Errorf("expected an error naming the key, got %v", err)
+	}
+	got, err := checkKeys(ctx, objectList, CheckKeysSkip)
+	if err != nil || len(got) != 2 || *got[1].Key != "also good.txt" {
+		t.Errorf("skip: %d objects, err %v", len(got), err)
+	}
+}
+
+func TestJoinKey(t *testing.T) {
+	if got := JoinKey(KeyDir("backups/archive.tar"), "archive.tar.parts", "abcd", "toc.csv"); got != "backups/archive.tar.parts/abcd/toc.csv" {
+		t.Errorf("got %q", got)
+	}
+	if got := JoinKey(KeyDir("archive.tar"), "archive.tar.parts"); got != "archive.tar.parts" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExtractKey(t *testing.T) {
+	for _, tt := range []struct{ prefix, name, want string }{
+		{"restored", "dir/a.txt", "restored/dir/a.txt"},
+		{"restored/", "./dir/a.txt", "restored/dir/a.txt"},
+		{".", "a.txt", "a.txt"},
+		{"restored", "../a.txt", ""},
+		{"restored", "dir/../../a.txt", ""},
+		{"restored", "/etc/passwd", ""},
+		{"restored", "a\x00b", ""},
+		{"restored", "", ""},
+	} {
+		got, err := extractKey(tt.prefix, tt.name)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("extractKey(%q, %q) = %q, %v, want %q", tt.prefix, tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizePrefix(t *testing.T) {
+	tests := map[string]string{
+		"s3://bucket/dir/archive.tar":    "s3://bucket/dir/archive.tar",
+		`s3://bucket\dir\archive.tar`:    "s3://bucket/dir/archive.tar",
+		`s3://bucket/dir\\sub\`:          "s3://bucket/dir/sub/",
+		"s3://bucket//dir///":            "s3://bucket/dir/",
+		`dir\sub`:                        "dir/sub",
+		"s3://bucket":                    "s3://bucket",
+		"s3://bucket/name with spaces/x": "s3://bucket/name with spaces/x",
+	}
+	for in, want := range tests {
+		if got := NormalizePrefix(in); got != want {
+			t.Errorf("NormalizePrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestArchiveRoot(t *testing.T) {
+	for _, root := range []string{"backup-2024-01/", "backup-2024-01", "backups/2024/01/"} {
+		if err := CheckArchiveRoot(root); err != nil {
+			t.Errorf("%q should be valid: %s", root, err)
+		}
+	}
+	for _, root := range []string{"/", "/backup/", "../backup/", "backup//01/", "back\nup/"} {
+		if CheckArchiveRoot(root) == nil {
+			t.Errorf("%q should be invalid", root)
+		}
+	}
+
+	named := NewS3ObjOptions(WithBucketAndKey("my-bucket", "logs/a.log"))
+	named.Name = "logs/a.log.0-99"
+	objectList := []*S3Obj{NewS3ObjOptions(WithBucketAndKey("my-bucket", "dir/file.txt")), named}
+	rooted := withArchiveRoot(objectList, "backup-2024-01/")
+	for i, want := range []string{"backup-2024-01/dir/file.txt", "backup-2024-01/logs/a.log.0-99"} {
+		if got := rooted[i].entryName(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if objectList[0].Name != "" || *rooted[0].Key != "dir/file.txt" {
+		t.Errorf("the objects should be copied, the key kept")
+	}
+}
+
+func TestClusterByPrefix(t *testing.T) {
+	var objectList []*S3Obj
+	for _, key := range []string{"a/x.txt", "b/1.txt", "a/b/y.txt", "root.txt", "a-b/z.txt", "a/0.txt", "b/0.txt", "a/b/c/w.txt"} {
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("bucket", key)))
+	}
+	named := NewS3ObjOptions(WithBucketAndKey("bucket", "b/2.txt"))
+	named.Name = "a/b/renamed.txt"
+	objectList = append(objectList, named)
+
+	clustered := clusterByPrefix(objectList)
+	var got []string
+	for _, o := range clustered {
+		got = append(got, o.entryName())
+	}
+	want := []string{"root.txt", "a-b/z.txt", "a/x.txt", "a/0.txt", "a/b/y.txt", "a/b/renamed.txt", "a/b/c/w.txt", "b/1.txt", "b/0.txt"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("clusterByPrefix() = %v, want %v", got, want)
+	}
+	if *objectList[0].Key != "a/x.txt" || *objectList[1].Key != "b/1.txt" {
+		t.Errorf("the list was reordered in place")
+	}
+}
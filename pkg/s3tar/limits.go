@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+)
+
+const contextKeySelectionLimits = contextKey("selectionLimits")
+
+// SelectionLimits are the most objects and bytes a job archives, e.g. to stop
+// a typo in a prefix from archiving a whole bucket. Zero is no limit.
+type SelectionLimits struct {
+	MaxObjects int
+	MaxBytes   int64
+}
+
+// SelectionLimitError is the error of a selection over its limits. Objects
+// and Bytes are what was selected when the limit was hit, a listing stops
+// there.
+type SelectionLimitError struct {
+	Limits  SelectionLimits
+	Objects int
+	Bytes   int64
+}
+
+func (e *SelectionLimitError) Error() string {
+	if e.Limits.MaxObjects > 0 && e.Objects > e.Limits.MaxObjects {
+		return fmt.Sprintf("the selection has over %d objects, the limit of the job, check the source or raise the limit", e.Limits.MaxObjects)
+	}
+	return fmt.Sprintf("the selection has over %d bytes, the limit of the job, check the source or raise the limit", e.Limits.MaxBytes)
+}
+
+// exceeded returns whether objects and bytes are over the limits.
+func (l SelectionLimits) exceeded(objects int, bytes int64) bool {
+	return (l.MaxObjects > 0 && objects > l.MaxObjects) || (l.MaxBytes > 0 && bytes > l.MaxBytes)
+}
+
+// Check returns a *SelectionLimitError when objectList is over the limits.
+func (l SelectionLimits) Check(objectList []*S3Obj) error {
+	var bytes int64
+	for _, o := range objectList {
+		bytes += *o.Size
+	}
+	if l.exceeded(len(objectList), bytes) {
+		return &SelectionLimitError{Limits: l, Objects: len(objectList), Bytes: bytes}
+	}
+	return nil
+}
+
+// WithSelectionLimits stops ListAllObjects with a *SelectionLimitError as
+// soon as what it listed is over limits, before the whole prefix is listed.
+func WithSelectionLimits(ctx context.Context, limits SelectionLimits) context.Context {
+	return context.WithValue(ctx, contextKeySelectionLimits, limits)
+}
+
+func selectionLimitsFromContext(ctx context.Context) SelectionLimits {
+	if l, ok := ctx.Value(contextKeySelectionLimits).(SelectionLimits); ok {
+		return l
+	}
+	return SelectionLimits{}
+}
+
+// SplitByLimits splits objectList in lists within limits, keeping its order
+// like BreakUpList. An object over MaxBytes is in a list of its own.
+func SplitByLimits(objectList []*S3Obj, limits SelectionLimits) [][]*S3Obj {
+	var lists [][]*S3Obj
+	var current []*S3Obj
+	var bytes int64
+	for _, o := range objectList {
+		if len(current) > 0 && limits.exceeded(len(current)+1, bytes+*o.Size) {
+			lists = append(lists, current)
+			current, bytes = nil, 0
+		}
+		current = append(current, o)
+		bytes += *o.Size
+	}
+	if len(current) > 0 {
+		lists = append(lists, current)
+	}
+	return lists
+}
@@ -0,0 +1,53 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSelectionLimits(t *testing.T) {
+	var objectList []*S3Obj
+	for i := 0; i < 10; i++ {
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("bucket", fmt.Sprintf("%d", i)), WithSize(100)))
+	}
+	if err := (SelectionLimits{}).Check(objectList); err != nil {
+		t.Errorf("no limits: %v", err)
+	}
+	if err := (SelectionLimits{MaxObjects: 10, MaxBytes: 1000}).Check(objectList); err != nil {
+		t.Errorf("at the limits: %v", err)
+	}
+	var limitErr *SelectionLimitError
+	if err := (SelectionLimits{MaxBytes: 999}).Check(objectList); !errors.As(err, &limitErr) || limitErr.Bytes != 1000 {
+		t.Errorf("over MaxBytes: %v", err)
+	}
+
+	lists := SplitByLimits(objectList, SelectionLimits{MaxObjects: 4, MaxBytes: 350})
+	if len(lists) != 4 || len(lists[0]) != 3 || len(lists[3]) != 1 {
+		t.Errorf("got %d lists", len(lists))
+	}
+}
+
+func TestListAllObjectsSelectionLimits(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	for i := 0; i < 2500; i++ {
+		backend.Put("bucket", fmt.Sprintf("files/%05d", i), []byte("x"))
+	}
+	var limitErr *SelectionLimitError
+	_, _, err := listAllObjects(WithSelectionLimits(ctx, SelectionLimits{MaxObjects: 1500}), backend, "bucket", "files/")
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("expected a SelectionLimitError, got %v", err)
+	}
+	// the listing stops at the page over the limit
+	if limitErr.Objects != 2000 {
+		t.Errorf("listed %d objects", limitErr.Objects)
+	}
+	if list, _, err := listAllObjects(WithSelectionLimits(ctx, SelectionLimits{MaxObjects: 2500}), backend, "bucket", "files/"); err != nil || len(list) != 2500 {
+		t.Errorf("at the limit: %d objects, %v", len(list), err)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const contextKeyListCheckpoint = contextKey("listCheckpoint")
+
+// listProgressInterval is how often the progress of a listing is logged.
+const listProgressInterval = 30 * time.Second
+
+// listThrottleAttempts is how many times a throttled page is listed again,
+// on top of the retries of the SDK, before the listing fails.
+const listThrottleAttempts = 5
+
+// listThrottleBackoff is the first wait before listing a throttled page
+// again, it doubles on every attempt.
+var listThrottleBackoff = time.Second
+
+// ListCheckpoint is the progress of a listing, written to
+// <archive>.listing/listing.json. The objects listed so far are in the
+// manifests of Parts, the listing goes on from ContinuationToken.
+type ListCheckpoint struct {
+	Bucket            string    `json:"bucket"`
+	Prefix            string    `json:"prefix"`
+	ContinuationToken string    `json:"continuationToken,omitempty"`
+	Complete          bool      `json:"complete"`
+	Objects           int       `json:"objects"`
+	Parts             []string  `json:"parts"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// listCheckpointer writes the checkpoint of a listing every interval.
+type listCheckpointer struct {
+	bucket   string
+	prefix   string
+	interval time.Duration
+
+	state   ListCheckpoint
+	pending []*S3Obj
+	written time.Time
+}
+
+// WithListCheckpoint checkpoints the listing of the source of an archive to
+// s3://bucket/<key>.listing/ every interval, key being the archive. A
+// listing that restarts with a checkpoint of the same source goes on from
+// it. The checkpoint is kept once the listing is complete, so the objects
+// aren't listed again when the archive is resumed, see DeleteListCheckpoint.
+func WithListCheckpoint(ctx context.Context, bucket, key string, interval time.Duration) context.Context {
+	return context.WithValue(ctx, contextKeyListCheckpoint, &listCheckpointer{
+		bucket:   bucket,
+		prefix:   key + ".listing/",
+		interval: interval,
+	})
+}
+
+func listCheckpointFromContext(ctx context.Context) *listCheckpointer {
+	if c, ok := ctx.Value(contextKeyListCheckpoint).(*listCheckpointer); ok {
+		return c
+	}
+	return nil
+}
+
+// DeleteListCheckpoint deletes the listing checkpoint of the archive
+// s3://bucket/key once the archive is complete.
+func DeleteListCheckpoint(ctx context.Context, svc *s3.Client, bucket, key string) error {
+	prefix := key + ".listing/"
+	p := s3.NewListObjectsV2Paginator(svc, &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix})
+	for p.HasMorePages() {
+		output, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, o := range output.Contents {
+			if _, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: o.Key}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resume returns the objects of the checkpoint of the listing of
+// s3://bucket/prefix and keeps the token to go on from in c.state. It
+// returns no objects when there is no checkpoint of this listing.
+func (c *listCheckpointer) resume(ctx context.Context, client Backend, bucket, prefix string) ([]*S3Obj, error) {
+	c.state = ListCheckpoint{Bucket: bucket, Prefix: prefix}
+	c.written = time.Now()
+	r, err := getObject(ctx, client, c.bucket, c.prefix+"listing.json")
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var state ListCheckpoint
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid listing checkpoint s3://%s/%slisting.json: %w", c.bucket, c.prefix, err)
+	}
+	if state.Bucket != bucket || state.Prefix != prefix {
+		Warnf(ctx, "the listing checkpoint s3://%s/%slisting.json is of s3://%s/%s, listing s3://%s/%s from the start", c.bucket, c.prefix, state.Bucket, state.Prefix, bucket, prefix)
+		return nil, nil
+	}
+	var list []*S3Obj
+	for _, key := range state.Parts {
+		r, err := getObject(ctx, client, c.bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		objects, _, err := parseManifest(ctx, r, ManifestOptions{SkipHeader: true})
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, objects...)
+	}
+	if len(list) != state.Objects {
+		return nil, fmt.Errorf("the listing checkpoint s3://%s/%slisting.json has %d objects, its parts %d", c.bucket, c.prefix, state.Objects, len(list))
+	}
+	c.state = state
+	return list, nil
+}
+
+// add records the objects of a page and the token of the next one, and
+// writes the checkpoint when it is due.
+func (c *listCheckpointer) add(ctx context.Context, client Backend, objects []*S3Obj, token *string) error {
+	c.pending = append(c.pending, objects...)
+	c.state.ContinuationToken = aws.ToString(token)
+	if time.Since(c.written) < c.interval {
+		return nil
+	}
+	return c.write(ctx, client)
+}
+
+// write uploads the objects listed since the last checkpoint and the
+// checkpoint.
+func (c *listCheckpointer) write(ctx context.Context, client Backend) error {
+	if len(c.pending) > 0 {
+		data, err := planManifest(c.pending)
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s%05d.csv", c.prefix, len(c.state.Parts))
+		if _, err := putObject(ctx, client, c.bucket, key, data); err != nil {
+			return err
+		}
+		c.state.Parts = append(c.state.Parts, key)
+		c.state.Objects += len(c.pending)
+		c.pending = nil
+	}
+	c.state.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := putObject(ctx, client, c.bucket, c.prefix+"listing.json", data); err != nil {
+		return err
+	}
+	c.written = time.Now()
+	Debugf(ctx, "listing checkpoint: %d objects", c.state.Objects)
+	return nil
+}
+
+// listPage lists a page, listing it again when it is throttled.
+func listPage(ctx context.Context, client Backend, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	backoff := listThrottleBackoff
+	for attempt := 1; ; attempt++ {
+		output, err := client.ListObjectsV2(ctx, input)
+		if err == nil || !isSlowDown(err) || attempt == listThrottleAttempts {
+			return output, err
+		}
+		Warnf(ctx, "listing s3://%s/%s is throttled, trying again in %s", aws.ToString(input.Bucket), aws.ToString(input.Prefix), backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// failingListBackend fails the ListObjectsV2 calls in fail, counted from 1.
+type failingListBackend struct {
+	*MemoryBackend
+	calls int
+	fail  map[int]error
+}
+
+func (b *failingListBackend) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	b.calls++
+	if err := b.fail[b.calls]; err != nil {
+		return nil, err
+	}
+	return b.MemoryBackend.ListObjectsV2(ctx, params, optFns...)
+}
+
+func TestListCheckpoint(t *testing.T) {
+	listThrottleBackoff = time.Millisecond
+	defer func() { listThrottleBackoff = time.Second }()
+
+	m := NewMemoryBackend()
+	for i := 0; i < 2500; i++ {
+		m.Put("src", fmt.Sprintf("files/%05d.txt", i), []byte("data"))
+	}
+	m.Put("src", "files/dir/", nil)
+	listing := func(b *failingListBackend) ([]*S3Obj, error) {
+		// a checkpoint on every page
+		ctx := WithListCheckpoint(context.Background(), "dst", "archive.tar", 0)
+		list, _, err := listAllObjects(ctx, b, "src", "files/")
+		return list, err
+	}
+
+	// interrupted on the third page, the second is throttled once
+	b := &failingListBackend{MemoryBackend: m, fail: map[int]error{
+		2: &smithy.GenericAPIError{Code: "SlowDown"},
+		4: errors.New("connection reset"),
+	}}
+	if _, err := listing(b); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(m.Keys("dst", "archive.tar.listing/")) != 3 {
+		t.Fatalf("got %v", m.Keys("dst", "archive.tar.listing/"))
+	}
+
+	// the restarted listing goes on from the third page
+	b = &failingListBackend{MemoryBackend: m}
+	list, err := listing(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.calls != 1 || len(list) != 2500 {
+		t.Fatalf("got %d objects in %d calls", len(list), b.calls)
+	}
+	for i, o := range list {
+		if want := fmt.Sprintf("files/%05d.txt", i); *o.Key != want || o.PartNum != i+1 || o.Bucket != "src" {
+			t.Fatalf("%d: got %s %d", i, *o.Key, o.PartNum)
+		}
+	}
+
+	// once complete, the objects aren't listed again
+	b = &failingListBackend{MemoryBackend: m}
+	if list, err = listing(b); err != nil || len(list) != 2500 || b.calls != 0 {
+		t.Fatalf("got %d objects in %d calls, %v", len(list), b.calls, err)
+	}
+
+	// another source is listed from the start
+	ctx := WithListCheckpoint(context.Background(), "dst", "archive.tar", time.Hour)
+	if list, _, err = listAllObjects(ctx, m, "src", "files/01"); err != nil || len(list) != 1000 {
+		t.Fatalf("got %d objects, %v", len(list), err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 )
 
 const (
@@ -15,6 +16,34 @@ const (
 	contextKeyLoggerLevel = contextKey("logger-level")
 )
 
+// Log levels of SetLogLevel, every level logs the messages of the levels
+// before it. The -v, -vv and -vvv flags of the CLI map to LogLevelInfo,
+// LogLevelInfo and LogLevelDebug.
+const (
+	// LogLevelQuiet logs nothing, not even the errors that don't stop
+	// the run
+	LogLevelQuiet = -1
+	LogLevelError = 0
+	LogLevelWarn  = 1
+	LogLevelInfo  = 2
+	LogLevelDebug = 3
+)
+
+// ParseLogLevel returns the level named debug, info, warn or error.
+func ParseLogLevel(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
 type logWriter struct {
 }
 
@@ -34,22 +63,25 @@ func SetupLogger(incoming context.Context) context.Context {
 
 func Debugf(ctx context.Context, format string, v ...interface{}) {
 	logger, level := getValues(ctx)
-	if level > 2 && level <= 3 {
+	if level >= LogLevelDebug {
 		logger.Printf(format, v...)
 	}
 }
 
 func Warnf(ctx context.Context, format string, v ...interface{}) {
 	logger, level := getValues(ctx)
-	if level > 1 && level <= 3 {
+	if level >= LogLevelWarn {
 		logger.Printf(format, v...)
 	}
 }
 
-// Errorf, always log regardless of log level, but don't stop the application
+// Errorf logs at every level but LogLevelQuiet, and doesn't stop the
+// application
 func Errorf(ctx context.Context, format string, v ...interface{}) {
-	logger, _ := getValues(ctx)
-	logger.Printf(format, v...)
+	logger, level := getValues(ctx)
+	if level >= LogLevelError {
+		logger.Printf(format, v...)
+	}
 }
 func Fatalf(ctx context.Context, format string, v ...interface{}) {
 	log.Fatalf(format, v...)
@@ -57,7 +89,7 @@ func Fatalf(ctx context.Context, format string, v ...interface{}) {
 
 func Infof(ctx context.Context, format string, v ...interface{}) {
 	logger, level := getValues(ctx)
-	if level >= 1 {
+	if level >= LogLevelInfo {
 		logger.Printf(format, v...)
 	}
 }
@@ -68,7 +100,6 @@ func getValues(ctx context.Context) (*log.Logger, int) {
 	if _logger, ok := ctx.Value(contextKeyLogger).(*log.Logger); ok {
 		logger = _logger
 	} else {
-		log.Printf("default logger")
 		logger = log.Default()
 	}
 	if _level, ok := ctx.Value(contextKeyLoggerLevel).(int); ok {
@@ -0,0 +1,50 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogLevels(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		level int
+		want  string
+	}{
+		{"debug", LogLevelDebug, "error,warn,info,debug,"},
+		{"info", LogLevelInfo, "error,warn,info,"},
+		{"WARN", LogLevelWarn, "error,warn,"},
+		{"error", LogLevelError, "error,"},
+		{"", LogLevelQuiet, ""},
+	} {
+		level := LogLevelQuiet
+		if c.name != "" {
+			var err error
+			if level, err = ParseLogLevel(c.name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if level != c.level {
+			t.Errorf("%s: level = %d, want %d", c.name, level, c.level)
+		}
+		buf := &bytes.Buffer{}
+		ctx := context.WithValue(context.Background(), contextKeyLogger, log.New(buf, "", 0))
+		ctx = SetLogLevel(ctx, level)
+		Errorf(ctx, "error,")
+		Warnf(ctx, "warn,")
+		Infof(ctx, "info,")
+		Debugf(ctx, "debug,")
+		if got := strings.ReplaceAll(buf.String(), "\n", ""); got != c.want {
+			t.Errorf("%s: logged %q, want %q", c.name, got, c.want)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
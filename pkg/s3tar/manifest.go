@@ -0,0 +1,729 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultTOCMemoryLimit is the largest TOC kept in memory when
+// S3TarS3Options.TOCMemoryLimit isn't set.
+const defaultTOCMemoryLimit = 64 * 1024 * 1024
+
+// entryChunk is the number of entries a worker builds the headers or the
+// TOC lines of at a time.
+const entryChunk = 4096
+
+// forEachChunk calls fn with the index, start and end of the consecutive
+// chunks of n entries, on up to GOMAXPROCS goroutines. The headers and the
+// TOC lines of tens of millions of entries take minutes to build on a single
+// core, while the other cores wait.
+func forEachChunk(n int, fn func(i, start, end int) error) error {
+	if n <= entryChunk {
+		return fn(0, 0, n)
+	}
+	var g errgroup.Group
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for i := 0; i*entryChunk < n; i++ {
+		i, start, end := i, i*entryChunk, min((i+1)*entryChunk, n)
+		g.Go(func() error {
+			return fn(i, start, end)
+		})
+	}
+	return g.Wait()
+}
+
+func buildToc(ctx context.Context, objectList []*S3Obj) (*S3Obj, *S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+
+	headers, err := cfg.entryHeaders(objectList)
+	if err != nil {
+		return nil, nil, err
+	}
+	toc, err := cfg._buildToc(ctx, headers, objectList)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordTOC(ctx, toc.Bytes())
+
+	// Build a header with the original data
+	tocObj := NewS3Obj()
+	tocObj.Key = aws.String("toc.csv")
+	tocObj.AddData(toc.Bytes())
+	// passing nil as we don't need to set permissions/owner/group for toc.csv
+	tocHeader, err := cfg.buildHeader(tocObj, nil, false, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	tocHeader.Bucket = objectList[0].Bucket
+	tocObj.Bucket = objectList[0].Bucket
+
+	return tocObj, &tocHeader, nil
+}
+
+// tocObject returns the TOC of objectList. A TOC up to opts.TOCMemoryLimit
+// is returned with its Data like buildToc. A larger one is written to a
+// temporary object under the parts prefix as it is generated and is copied
+// into the archive like the other entries, so tens of millions of entries
+// don't need gigabytes of memory.
+func tocObject(ctx context.Context, svc Backend, objectList []*S3Obj, opts *S3TarS3Options) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+	limit := opts.TOCMemoryLimit
+	if limit == 0 {
+		limit = defaultTOCMemoryLimit
+	}
+	headers, err := cfg.entryHeaders(objectList)
+	if err != nil {
+		return nil, err
+	}
+	length, offset, err := cfg.tocLength(headers, objectList)
+	if err != nil {
+		return nil, err
+	}
+	if length <= limit {
+		tocObj, _, err := buildToc(ctx, objectList)
+		return tocObj, err
+	}
+
+	key := JoinKey(partsKey(opts), "toc.csv")
+	Infof(ctx, "the TOC is %d bytes, writing it to s3://%s/%s", length, opts.DstBucket, key)
+	w, err := newPartWriter(ctx, svc, opts.DstBucket, key, cfg.tocPartSize(length))
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.writeCSVTOC(w, offset, headers, objectList); err != nil {
+		w.abort()
+		return nil, err
+	}
+	etag, err := w.close()
+	if err != nil {
+		return nil, err
+	}
+	if w.size != length {
+		return nil, fmt.Errorf("the TOC is %d bytes, expected %d", w.size, length)
+	}
+
+	tocObj := NewS3ObjOptions(WithBucketAndKey(opts.DstBucket, key), WithSize(length), WithETag(etag))
+	tocObj.Name = "toc.csv"
+	recordTOCObject(ctx, tocObj)
+	return tocObj, nil
+}
+
+// ExternalTOCKey is the key of the TOC of the archive key created with
+// NoEmbeddedTOC.
+func ExternalTOCKey(key string) string {
+	return key + ".toc.csv"
+}
+
+// tocSpan is the size of the TOC entry at the front of the archive created
+// from entries, its header and padding included, see NoEmbeddedTOC.
+func (c jobConfig) tocSpan(entries []*S3Obj) (int64, error) {
+	headers, err := c.entryHeaders(entries)
+	if err != nil {
+		return 0, err
+	}
+	size, err := c.tocSize(headers, entries)
+	if err != nil {
+		return 0, err
+	}
+	offsets := c.entryOffsets(size, headers, entries[:1])
+	return offsets[0].HeaderStart, nil
+}
+
+// writeExternalTOC writes the TOC of the archive created from objectList
+// without its TOC entry to ExternalTOCKey, the offsets moved back by
+// opts.tocTrim. It replaces the TOC recorded for the master index.
+func writeExternalTOC(ctx context.Context, svc Backend, objectList []*S3Obj, opts *S3TarS3Options) error {
+	cfg := jobConfigFromContext(ctx)
+	offsets, err := cfg.trimmedOffsets(objectList, opts.tocTrim)
+	if err != nil {
+		return err
+	}
+	withDigest := hasDigests(objectList)
+	limit := opts.TOCMemoryLimit
+	if limit == 0 {
+		limit = defaultTOCMemoryLimit
+	}
+	key := ExternalTOCKey(opts.DstKey)
+	length := &countingWriter{}
+	if err := writeTOCLines(length, offsets, objectList, withDigest); err != nil {
+		return err
+	}
+	if length.n <= limit {
+		var buf bytes.Buffer
+		if err := writeTOCLines(&buf, offsets, objectList, withDigest); err != nil {
+			return err
+		}
+		if _, err := putObject(ctx, svc, opts.DstBucket, key, buf.Bytes()); err != nil {
+			return fmt.Errorf("unable to write the TOC s3://%s/%s: %w", opts.DstBucket, key, err)
+		}
+		recordTOC(ctx, buf.Bytes())
+	} else {
+		w, err := newPartWriter(ctx, svc, opts.DstBucket, key, cfg.tocPartSize(length.n))
+		if err != nil {
+			return err
+		}
+		if err := writeTOCLines(w, offsets, objectList, withDigest); err != nil {
+			w.abort()
+			return err
+		}
+		if _, err := w.close(); err != nil {
+			return err
+		}
+		recordTOC(ctx, nil)
+		recordTOCObject(ctx, NewS3ObjOptions(WithBucketAndKey(opts.DstBucket, key), WithSize(length.n)))
+	}
+	Infof(ctx, "TOC: s3://%s/%s", opts.DstBucket, key)
+	return nil
+}
+
+// tocPartSize is the part size a TOC of length bytes is uploaded with, the
+// parts are kept in memory one at a time.
+func (c jobConfig) tocPartSize(length int64) int {
+	partSize := c.findMinimumPartSize(length, 0)
+	if partSize < c.padSize {
+		partSize = c.padSize
+	}
+	return int(partSize)
+}
+
+func (c jobConfig) _buildToc(ctx context.Context, headers []*S3Obj, objectList []*S3Obj) (*bytes.Buffer, error) {
+	_, offset, err := c.tocLength(headers, objectList)
+	if err != nil {
+		return nil, err
+	}
+	return c.createCSVTOC(offset, headers, objectList)
+}
+
+// tocLength returns the length of the CSV TOC and the offset its entries are
+// located from, its length padded to a block. The offsets are part of the
+// TOC, the offset grows until it fits the TOC written with it.
+func (c jobConfig) tocLength(headers []*S3Obj, objectList []*S3Obj) (int64, int64, error) {
+	var offset int64
+	for {
+		w := &countingWriter{}
+		if err := c.writeCSVTOC(w, offset, headers, objectList); err != nil {
+			return 0, 0, err
+		}
+		padded := w.n + findPadding(w.n)
+		if padded <= offset {
+			return w.n, offset, nil
+		}
+		offset = padded
+	}
+}
+
+func (c jobConfig) createCSVTOC(offset int64, headers []*S3Obj, objectList []*S3Obj) (*bytes.Buffer, error) {
+	buf := bytes.Buffer{}
+	if err := c.writeCSVTOC(&buf, offset, headers, objectList); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// writeCSVTOC writes the TOC of objectList located after a TOC of offset
+// bytes, one line at a time.
+func (c jobConfig) writeCSVTOC(w io.Writer, offset int64, headers []*S3Obj, objectList []*S3Obj) error {
+	return writeTOCLines(w, c.entryOffsets(offset, headers, objectList), objectList, hasDigests(objectList))
+}
+
+// hasDigests reports whether the TOC of objectList has a sha256 column, it
+// is only written when digests were computed.
+func hasDigests(objectList []*S3Obj) bool {
+	for _, o := range objectList {
+		if o.SHA256 != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTOCLines writes a line per entry of objectList located at offsets.
+// The lines are formatted in parallel, a chunk per worker, and written in
+// order: a window of GOMAXPROCS chunks is held in memory at a time.
+func writeTOCLines(w io.Writer, offsets []EntryOffset, objectList []*S3Obj, withDigest bool) error {
+	window := runtime.GOMAXPROCS(0) * entryChunk
+	bufs := make([]bytes.Buffer, runtime.GOMAXPROCS(0))
+	for start := 0; start < len(offsets); start += window {
+		end := min(start+window, len(offsets))
+		chunks := 0
+		err := forEachChunk(end-start, func(i, s, e int) error {
+			bufs[i].Reset()
+			return formatTOCLines(&bufs[i], offsets[start+s:start+e], objectList[start+s:start+e], withDigest)
+		})
+		if err != nil {
+			return err
+		}
+		for chunks*entryChunk < end-start {
+			if _, err := bufs[chunks].WriteTo(w); err != nil {
+				return err
+			}
+			chunks++
+		}
+	}
+	return nil
+}
+
+// formatTOCLines writes the CSV lines of the entries of objectList located
+// at offsets.
+func formatTOCLines(w io.Writer, offsets []EntryOffset, objectList []*S3Obj, withDigest bool) error {
+	cw := csv.NewWriter(w)
+	for i, e := range offsets {
+		line := []string{}
+		line = append(line,
+			e.Key,
+			fmt.Sprintf("%d", e.Start),
+			fmt.Sprintf("%d", e.Size),
+			*objectList[i].ETag)
+		if withDigest {
+			line = append(line, objectList[i].SHA256)
+		}
+		if err := cw.Write(line); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// EntryOffset is the location of an entry in the archive.
+type EntryOffset struct {
+	Key string
+	// HeaderStart is the offset of the tar header of the entry, including
+	// the PAX or GNU long name headers.
+	HeaderStart int64
+	// Start and Size locate the data of the entry. Hardlinks point at the
+	// data of the object they link to, like the TOC.
+	Start int64
+	Size  int64
+}
+
+// ComputeOffsets returns where every entry will be in the archive s3tar
+// creates from entries with options, the same offsets as the TOC. The format,
+// ArchiveRoot, Align and NoEmbeddedTOC options change the offsets. entries
+// must be the list the archive is created from, after --dedup or
+// --metadata-sidecars added or removed objects, with their size and ETag.
+// The archives created in memory have no TOC and don't match these offsets.
+func ComputeOffsets(entries []*S3Obj, options *S3TarS3Options, optFns ...func(*S3TarS3Options)) ([]EntryOffset, error) {
+	var opts S3TarS3Options
+	if options != nil {
+		opts = options.Copy()
+	}
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if opts.formatErr != nil {
+		return nil, opts.formatErr
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	cfg := newJobConfig(&opts)
+	entries = withArchiveRoot(entries, opts.ArchiveRoot)
+	if opts.Align > 0 {
+		if cfg.format != tar.FormatPAX {
+			return nil, fmt.Errorf("alignment pads the PAX headers, it requires the pax format")
+		}
+		// the padding is set on copies, entries may be archived after
+		aligned := make([]*S3Obj, len(entries))
+		for i, o := range entries {
+			c := *o
+			aligned[i] = &c
+		}
+		entries = aligned
+		if err := cfg.alignEntries(entries, opts.Align); err != nil {
+			return nil, err
+		}
+	}
+	if opts.NoEmbeddedTOC {
+		trim, err := cfg.tocSpan(entries)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.trimmedOffsets(entries, trim)
+	}
+	return cfg.computeOffsets(entries)
+}
+
+func (c jobConfig) computeOffsets(entries []*S3Obj) ([]EntryOffset, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	headers, err := c.entryHeaders(entries)
+	if err != nil {
+		return nil, err
+	}
+	size, err := c.tocSize(headers, entries)
+	if err != nil {
+		return nil, err
+	}
+	return c.entryOffsets(size, headers, entries), nil
+}
+
+// trimmedOffsets is computeOffsets in an archive with its first trim bytes
+// cut, the TOC entry of NoEmbeddedTOC.
+func (c jobConfig) trimmedOffsets(entries []*S3Obj, trim int64) ([]EntryOffset, error) {
+	offsets, err := c.computeOffsets(entries)
+	if err != nil {
+		return nil, err
+	}
+	for i := range offsets {
+		offsets[i].HeaderStart -= trim
+		offsets[i].Start -= trim
+	}
+	return offsets, nil
+}
+
+// archiveSize returns the size of the archive s3tar creates from entries,
+// from the TOC to the end of archive blocks.
+func (c jobConfig) archiveSize(entries []*S3Obj) (int64, error) {
+	headers, err := c.entryHeaders(entries)
+	if err != nil {
+		return 0, err
+	}
+	size, err := c.tocSize(headers, entries)
+	if err != nil {
+		return 0, err
+	}
+	size += tarHeaderSize(c.format)
+	size += findPadding(size)
+	for i, o := range entries {
+		size += *headers[i].Size + *o.Size
+	}
+	return size + c.lastBlockSize(size), nil
+}
+
+// entryHeaders returns the headers of entries with their size only. They are
+// built in parallel, the header of an entry only depends on the entry and the
+// size of the one before it.
+func (c jobConfig) entryHeaders(entries []*S3Obj) ([]*S3Obj, error) {
+	headers := make([]*S3Obj, len(entries))
+	err := forEachChunk(len(entries), func(_, start, end int) error {
+		for i := start; i < end; i++ {
+			var prev *S3Obj
+			if i > 0 {
+				prev = entries[i-1]
+			}
+			data, err := c.headerData(entries[i], prev, false, nil)
+			if err != nil {
+				return err
+			}
+			size := int64(len(data))
+			headers[i] = &S3Obj{Object: types.Object{Size: &size}}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// partWriter uploads what is written to it as a multipart upload, holding a
+// single part in memory.
+type partWriter struct {
+	ctx      context.Context
+	svc      Backend
+	bucket   string
+	key      string
+	uploadId string
+	partSize int
+	buf      []byte
+	parts    []types.CompletedPart
+	size     int64
+}
+
+func newPartWriter(ctx context.Context, svc Backend, bucket, key string, partSize int) (*partWriter, error) {
+	output, err := svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(key),
+		ACL:               types.ObjectCannedACLBucketOwnerFullControl,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create s3://%s/%s: %w", bucket, key, err)
+	}
+	trackUpload(ctx, bucket, key, *output.UploadId)
+	return &partWriter{
+		ctx:      ctx,
+		svc:      svc,
+		bucket:   bucket,
+		key:      key,
+		uploadId: *output.UploadId,
+		partSize: partSize,
+		buf:      make([]byte, 0, partSize),
+	}, nil
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		l := w.partSize - len(w.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+		w.buf = append(w.buf, p[:l]...)
+		p = p[l:]
+		if len(w.buf) == w.partSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush uploads the buffered bytes as the next part.
+func (w *partWriter) flush() error {
+	partNum := int32(len(w.parts) + 1)
+	output, err := uploadPart(w.ctx, w.svc, w.uploadId, w.bucket, w.key, w.buf, &partNum)
+	if err != nil {
+		return fmt.Errorf("unable to upload part %d of s3://%s/%s: %w", partNum, w.bucket, w.key, err)
+	}
+	w.parts = append(w.parts, types.CompletedPart{
+		ETag:           output.ETag,
+		PartNumber:     aws.Int32(partNum),
+		ChecksumSHA256: output.ChecksumSHA256,
+	})
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// close uploads the last part and completes the upload, it returns the ETag
+// of the object.
+func (w *partWriter) close() (string, error) {
+	if len(w.buf) > 0 || len(w.parts) == 0 {
+		if err := w.flush(); err != nil {
+			w.abort()
+			return "", err
+		}
+	}
+	output, err := w.svc.CompleteMultipartUpload(w.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.bucket),
+		Key:             aws.String(w.key),
+		UploadId:        aws.String(w.uploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	})
+	if err != nil {
+		w.abort()
+		return "", fmt.Errorf("unable to complete s3://%s/%s: %w", w.bucket, w.key, err)
+	}
+	untrackUpload(w.ctx, w.uploadId)
+	return aws.ToString(output.ETag), nil
+}
+
+func (w *partWriter) abort() {
+	w.svc.AbortMultipartUpload(context.WithoutCancel(w.ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.bucket),
+		Key:      aws.String(w.key),
+		UploadId: aws.String(w.uploadId),
+	})
+	untrackUpload(w.ctx, w.uploadId)
+}
+
+// tocSize is the size of the TOC of entries, padding included.
+func (c jobConfig) tocSize(headers []*S3Obj, entries []*S3Obj) (int64, error) {
+	_, offset, err := c.tocLength(headers, entries)
+	return offset, err
+}
+
+// entryOffsets locates the entries after a TOC of tocSize bytes, padding
+// included.
+func (c jobConfig) entryOffsets(tocSize int64, headers []*S3Obj, objectList []*S3Obj) []EntryOffset {
+	var currLocation int64 = tocSize + tarHeaderSize(c.format)
+	currLocation = currLocation + findPadding(currLocation)
+	offsets := make([]EntryOffset, 0, len(objectList))
+	// hardlink entries point at the location of the object they link to
+	locations := map[string][2]int64{}
+
+	for i := 0; i < len(objectList); i++ {
+		headerStart := currLocation
+		if i > 0 {
+			// the header starts with the padding of the previous entry
+			headerStart += findPadding(*objectList[i-1].Size)
+		}
+		currLocation += *headers[i].Size
+		start, size := currLocation, *objectList[i].Size
+		if target, ok := locations[objectList[i].LinkTarget]; ok && objectList[i].LinkTarget != "" {
+			start, size = target[0], target[1]
+		} else {
+			locations[objectList[i].entryName()] = [2]int64{start, size}
+		}
+		offsets = append(offsets, EntryOffset{Key: objectList[i].entryName(), HeaderStart: headerStart, Start: start, Size: size})
+		currLocation += *objectList[i].Size
+	}
+	return offsets
+}
+
+// buildFirstPart returns the TOC with its header, after the pad when
+// frontPad is set so the part reaches the minimum part size.
+func (c jobConfig) buildFirstPart(csvData []byte, frontPad bool) (*S3Obj, error) {
+	header, err := c.tocHeader(int64(len(csvData)), frontPad)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(header)
+	buf.Write(csvData)
+
+	// the TOC offsets expect the entries right after the padding
+	lastBytes := make([]byte, findPadding(int64(len(csvData))))
+	buf.Write(lastBytes)
+
+	endPadding := NewS3Obj()
+	endPadding.AddData(buf.Bytes())
+	return endPadding, nil
+}
+
+// concatFirstPart is buildFirstPart for a TOC written to a temporary object
+// by tocObject, the header and the padding are concatenated around a copy
+// of it.
+func concatFirstPart(ctx context.Context, rc *RecursiveConcat, tocObj *S3Obj, frontPad bool, opts *S3TarS3Options) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+	data, err := cfg.tocHeader(*tocObj.Size, frontPad)
+	if err != nil {
+		return nil, err
+	}
+	header := NewS3Obj()
+	header.AddData(data)
+	parts := []*S3Obj{header, tocObj}
+	if n := findPadding(*tocObj.Size); n > 0 {
+		padding := NewS3Obj()
+		padding.AddData(make([]byte, n))
+		parts = append(parts, padding)
+	}
+	key := JoinKey(partsKey(opts), "toc.first")
+	return rc.ConcatObjects(ctx, parts, opts.DstBucket, key)
+}
+
+// tocHeader returns the tar header of a TOC of size bytes, after the pad
+// when frontPad is set.
+func (c jobConfig) tocHeader(size int64, frontPad bool) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	hdr := &tar.Header{
+		Name:       "toc.csv",
+		Mode:       0600,
+		Size:       size,
+		ModTime:    time.Now(),
+		ChangeTime: time.Now(),
+		AccessTime: time.Now(),
+		Format:     c.format,
+	}
+	applyTarFormat(hdr)
+	if frontPad {
+		buf.Write(c.pad())
+	}
+	headerStart := buf.Len()
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, fmt.Errorf("%s: %w", hdr.Name, err)
+	}
+	if err := tw.Flush(); err != nil {
+		// we ignore this error, the tar library will complain that we
+		// didn't write the whole file. This part is already on Amazon S3
+	}
+	if c.strictChecksum {
+		if err := strictHeaderChecksum(buf.Bytes()[headerStart:]); err != nil {
+			return nil, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// GenerateToc creates a TOC csv of an existing TAR file (not created by s3tar)
+// tar file MUST NOT have compression.
+// tar file must be on the local file system to.
+func GenerateToc(ctx context.Context, svc *s3.Client, tarFile, outputToc string, opts *S3TarS3Options) error {
+
+	if strings.Contains(tarFile, "s3://") {
+		// remote file on s3, only the headers are downloaded
+		toc, err := ScanTar(ctx, svc, opts.SrcBucket, opts.SrcKey)
+		if err != nil {
+			return err
+		}
+
+		w, err := os.Create(outputToc)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer w.Close()
+		cw := csv.NewWriter(w)
+		for _, f := range toc {
+			record := []string{f.Filename, fmt.Sprintf("%d", f.Start), fmt.Sprintf("%d", f.Size), ""}
+			if err = cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	} else {
+		// local file
+		Debugf(ctx, "%s is a local file", tarFile)
+
+		r, err := os.Open(tarFile)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer r.Close()
+
+		w, err := os.Create(outputToc)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer w.Close()
+
+		cw := csv.NewWriter(w)
+		tr := tar.NewReader(r)
+		for {
+			h, err := tr.Next()
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if err == io.EOF {
+				break
+			}
+
+			offset, err := r.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+
+			offsetStr := fmt.Sprintf("%d", offset)
+			size := fmt.Sprintf("%d", h.Size)
+			record := []string{h.Name, offsetStr, size, ""}
+			if err = cw.Write(record); err != nil {
+				return err
+			}
+
+		}
+		cw.Flush()
+		return nil
+	}
+}
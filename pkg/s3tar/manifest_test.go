@@ -0,0 +1,200 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestComputeOffsets(t *testing.T) {
+	contents := map[string][]byte{
+		"a.txt":                               []byte("hello"),
+		"dir/b.bin":                           bytes.Repeat([]byte("b"), 1300),
+		strings.Repeat("long/", 40) + "c.txt": []byte("long name"),
+		"empty.txt":                           {},
+	}
+	var entries []*S3Obj
+	for _, key := range []string{"a.txt", "dir/b.bin", strings.Repeat("long/", 40) + "c.txt", "empty.txt"} {
+		o := NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(int64(len(contents[key]))), WithETag("etag"))
+		entries = append(entries, o)
+	}
+	link := NewS3ObjOptions(WithBucketAndKey("bucket", "link.txt"), WithSize(0), WithETag("etag"))
+	link.LinkTarget = "dir/b.bin"
+	entries = append(entries, link)
+
+	// lay out the archive like the large files path, without the 5MB pad
+	tocObj, _, err := buildToc(context.TODO(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := testFirstPart(t, defaultJobConfig, tocObj.Data)
+	for i, o := range entries {
+		var prev *S3Obj
+		if i > 0 {
+			prev = entries[i-1]
+		}
+		archive = append(archive, testHeaderData(t, defaultJobConfig, o, prev)...)
+		archive = append(archive, contents[*o.Key]...)
+	}
+
+	offsets, err := ComputeOffsets(entries, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != len(entries) {
+		t.Fatalf("got %d offsets, want %d", len(offsets), len(entries))
+	}
+	for i, e := range offsets {
+		hdr, err := tar.NewReader(bytes.NewReader(archive[e.HeaderStart:])).Next()
+		if err != nil {
+			t.Fatalf("%s: %s", e.Key, err)
+		}
+		if hdr.Name != *entries[i].Key || e.Key != hdr.Name {
+			t.Errorf("header at %d is %s, want %s", e.HeaderStart, hdr.Name, *entries[i].Key)
+		}
+		want := contents[e.Key]
+		if e.Key == "link.txt" {
+			want = contents["dir/b.bin"]
+		}
+		if got := archive[e.Start : e.Start+e.Size]; !bytes.Equal(got, want) {
+			t.Errorf("%s data = %q, want %q", e.Key, got, want)
+		}
+	}
+	end := int64(len(archive))
+	if size, err := defaultJobConfig.archiveSize(entries); err != nil || size != end+defaultJobConfig.lastBlockSize(end) {
+		t.Errorf("archiveSize = %d, want %d", size, end+defaultJobConfig.lastBlockSize(end))
+	}
+	if offsets, err := ComputeOffsets(nil, nil); offsets != nil || err != nil {
+		t.Errorf("expected no offsets, got %v %v", offsets, err)
+	}
+	if _, err := ComputeOffsets(entries, nil, WithTarFormat("cpio")); err == nil {
+		t.Errorf("expected an unsupported format")
+	}
+	if _, err := ComputeOffsets(entries, &S3TarS3Options{Align: 4096}, WithTarFormat("gnu")); err == nil {
+		t.Errorf("expected the alignment to require the pax format")
+	}
+}
+
+func TestLastBlockSize(t *testing.T) {
+	posix := newJobConfig(&S3TarS3Options{EOFPadding: EOFPaddingPOSIX})
+	tests := []struct {
+		size   int64
+		compat int64
+		posix  int64
+	}{
+		{0, 3 * blockSize, 2 * blockSize},
+		{blockSize * 7, 3 * blockSize, 2 * blockSize},
+		{blockSize*7 + 12, 3*blockSize - 12, 3*blockSize - 12},
+		{1, 3*blockSize - 1, 3*blockSize - 1},
+	}
+	for _, tt := range tests {
+		if got := defaultJobConfig.lastBlockSize(tt.size); got != tt.compat {
+			t.Errorf("lastBlockSize(%d) = %d, want %d", tt.size, got, tt.compat)
+		}
+		if got := posix.lastBlockSize(tt.size); got != tt.posix {
+			t.Errorf("posix lastBlockSize(%d) = %d, want %d", tt.size, got, tt.posix)
+		}
+	}
+}
+
+func TestTocLength(t *testing.T) {
+	var entries []*S3Obj
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("dir/%s%d.txt", strings.Repeat("x", i%17), i)
+		entries = append(entries, NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(int64(i*1000)), WithETag("etag")))
+		headers := testEntryHeaders(t, defaultJobConfig, entries)
+		length, offset, err := defaultJobConfig.tocLength(headers, entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		toc, err := defaultJobConfig._buildToc(context.TODO(), headers, entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(toc.Len()) != length || offset != length+findPadding(length) {
+			t.Fatalf("%d entries: tocLength = %d, %d, the TOC is %d bytes", len(entries), length, offset, toc.Len())
+		}
+		// the TOC locates the entries after itself
+		want := fmt.Sprintf("%d", defaultJobConfig.entryOffsets(offset, headers, entries)[0].Start)
+		if start := strings.Split(toc.String(), ",")[1]; start != want {
+			t.Fatalf("%d entries: first entry at %s, want %s", len(entries), start, want)
+		}
+	}
+
+	tocObj := NewS3ObjOptions(WithBucketAndKey("bucket", "archive.tar.parts/toc.csv"), WithSize(10), WithETag("etag"))
+	tocObj.Name = "toc.csv"
+	hdr, err := tar.NewReader(bytes.NewReader(testHeaderData(t, defaultJobConfig, tocObj, nil))).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "toc.csv" {
+		t.Errorf("the entry is named %s, want toc.csv", hdr.Name)
+	}
+}
+
+func TestParallelTOC(t *testing.T) {
+	// enough entries for several windows of chunks, with PAX names and
+	// fields the CSV quotes
+	var entries []*S3Obj
+	for i := 0; i < 3*runtime.GOMAXPROCS(0)*entryChunk+17; i++ {
+		key := fmt.Sprintf("dir%d/%d.txt", i%7, i)
+		switch i % 5 {
+		case 1:
+			key = strings.Repeat("long/", 30) + key
+		case 2:
+			key = fmt.Sprintf("quoted \"%d\", with a comma.txt", i)
+		}
+		entries = append(entries, NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(int64(i%3000)), WithETag("etag")))
+	}
+
+	headers := testEntryHeaders(t, defaultJobConfig, entries)
+	for i, o := range entries {
+		var prev *S3Obj
+		if i > 0 {
+			prev = entries[i-1]
+		}
+		if want := int64(len(testHeaderData(t, defaultJobConfig, o, prev))); *headers[i].Size != want {
+			t.Fatalf("header %d is %d bytes, want %d", i, *headers[i].Size, want)
+		}
+	}
+
+	offsets := defaultJobConfig.entryOffsets(0, headers, entries)
+	var got, want bytes.Buffer
+	if err := writeTOCLines(&got, offsets, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	cw := csv.NewWriter(&want)
+	for i, e := range offsets {
+		cw.Write([]string{e.Key, fmt.Sprintf("%d", e.Start), fmt.Sprintf("%d", e.Size), *entries[i].ETag})
+	}
+	cw.Flush()
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("the TOC is %d bytes, want the %d bytes written line by line", got.Len(), want.Len())
+	}
+}
+
+func TestTocHeaderErrors(t *testing.T) {
+	// a ustar header can't hold a TOC of 8GiB
+	ustar := newJobConfig(&S3TarS3Options{tarFormat: tar.FormatUSTAR})
+	if _, err := ustar.tocHeader(8<<30, false); err == nil || !strings.Contains(err.Error(), "toc.csv") {
+		t.Errorf("expected an error about toc.csv, got %v", err)
+	}
+}
+
+// testFirstPart is buildFirstPart failing the test on an error.
+func testFirstPart(t testing.TB, c jobConfig, csvData []byte) []byte {
+	t.Helper()
+	part, err := c.buildFirstPart(csvData, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return part.Data
+}
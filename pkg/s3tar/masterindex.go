@@ -0,0 +1,226 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	contextKeyMasterIndex  = contextKey("master-index")
+	contextKeyArchiveIndex = contextKey("archive-index")
+
+	// masterIndexPartSize is the size of the parts the index is uploaded
+	// with, so a run with millions of objects doesn't hold it in memory.
+	masterIndexPartSize = 8 * 1024 * 1024
+)
+
+// MasterIndex aggregates the TOCs of the archives of a split run into a
+// single CSV object, so a key can be found without opening every archive.
+// Every row is archive,key,start,size,etag,sha256 where archive is the
+// s3:// url of the archive and start is the offset of the data in it. The
+// archives are written in the order they complete.
+//
+// Archives built in memory or as zip have no TOC and are left out.
+type MasterIndex struct {
+	svc    *s3.Client
+	bucket string
+	key    string
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	uploadId *string
+	parts    []types.CompletedPart
+	archives int
+	entries  int64
+}
+
+// NewMasterIndex returns an index written to s3://bucket/key by Close.
+func NewMasterIndex(svc *s3.Client, bucket, key string) *MasterIndex {
+	return &MasterIndex{svc: svc, bucket: bucket, key: key}
+}
+
+// WithMasterIndex returns a context that records the TOC of every archive
+// created with it in index.
+func WithMasterIndex(ctx context.Context, index *MasterIndex) context.Context {
+	return context.WithValue(ctx, contextKeyMasterIndex, index)
+}
+
+func masterIndexFromContext(ctx context.Context) *MasterIndex {
+	if m, ok := ctx.Value(contextKeyMasterIndex).(*MasterIndex); ok {
+		return m
+	}
+	return nil
+}
+
+// archiveIndex holds the TOC of an archive until it is complete.
+type archiveIndex struct {
+	archive string
+	toc     []byte
+	// tocObj is the TOC written to a temporary object, see tocObject.
+	tocObj *S3Obj
+}
+
+// recordTOC keeps the CSV TOC of the archive being created, if the run has a
+// master index.
+func recordTOC(ctx context.Context, toc []byte) {
+	if a, ok := ctx.Value(contextKeyArchiveIndex).(*archiveIndex); ok {
+		a.toc = toc
+	}
+}
+
+// recordTOCObject keeps the location of a TOC too large to be kept in
+// memory, it is read back once the archive is complete.
+func recordTOCObject(ctx context.Context, tocObj *S3Obj) {
+	if a, ok := ctx.Value(contextKeyArchiveIndex).(*archiveIndex); ok {
+		a.tocObj = tocObj
+	}
+}
+
+// add appends the TOC of a complete archive to the index.
+func (m *MasterIndex) add(ctx context.Context, a *archiveIndex) error {
+	var toc io.Reader
+	switch {
+	case a.toc != nil:
+		toc = bytes.NewReader(a.toc)
+	case a.tocObj != nil:
+		body, err := getObject(ctx, m.svc, a.tocObj.Bucket, *a.tocObj.Key)
+		if err != nil {
+			return fmt.Errorf("unable to read the TOC of %s: %w", a.archive, err)
+		}
+		defer body.Close()
+		toc = body
+	default:
+		Warnf(ctx, "%s has no TOC, it is not in the master index", a.archive)
+		return nil
+	}
+	r := csv.NewReader(toc)
+	r.FieldsPerRecord = -1
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := csv.NewWriter(&m.buf)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("unable to read the TOC of %s: %w", a.archive, err)
+		}
+		sha256 := ""
+		if len(record) == 5 {
+			sha256 = record[4]
+		}
+		if err := w.Write([]string{a.archive, record[0], record[1], record[2], record[3], sha256}); err != nil {
+			return err
+		}
+		m.entries++
+		// a TOC read back from Amazon S3 can be larger than a part
+		if m.buf.Len() >= masterIndexPartSize {
+			w.Flush()
+			if err := m.flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	m.archives++
+	if m.buf.Len() >= masterIndexPartSize {
+		return m.flush(ctx)
+	}
+	return nil
+}
+
+// flush uploads the buffered rows as the next part of the index.
+func (m *MasterIndex) flush(ctx context.Context) error {
+	if m.uploadId == nil {
+		output, err := m.svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:            aws.String(m.bucket),
+			Key:               aws.String(m.key),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			ContentType:       aws.String("text/csv"),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create the master index: %w", err)
+		}
+		m.uploadId = output.UploadId
+	}
+	partNum := int32(len(m.parts) + 1)
+	output, err := uploadPart(ctx, m.svc, *m.uploadId, m.bucket, m.key, m.buf.Bytes(), &partNum)
+	if err != nil {
+		return fmt.Errorf("unable to upload part %d of the master index: %w", partNum, err)
+	}
+	m.parts = append(m.parts, types.CompletedPart{
+		ETag:           output.ETag,
+		PartNumber:     aws.Int32(partNum),
+		ChecksumSHA256: output.ChecksumSHA256,
+	})
+	m.buf.Reset()
+	return nil
+}
+
+// Close writes the rows left and completes the index.
+func (m *MasterIndex) Close(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.uploadId == nil {
+		_, err := m.svc.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:            aws.String(m.bucket),
+			Key:               aws.String(m.key),
+			Body:              bytes.NewReader(m.buf.Bytes()),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			ContentType:       aws.String("text/csv"),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write the master index: %w", err)
+		}
+	} else {
+		if m.buf.Len() > 0 {
+			if err := m.flush(ctx); err != nil {
+				return err
+			}
+		}
+		_, err := m.svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(m.bucket),
+			Key:             aws.String(m.key),
+			UploadId:        m.uploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: m.parts},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to complete the master index: %w", err)
+		}
+	}
+	Infof(ctx, "master index s3://%s/%s: %d objects in %d archives", m.bucket, m.key, m.entries, m.archives)
+	return nil
+}
+
+// Abort discards the parts of the index uploaded so far.
+func (m *MasterIndex) Abort(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.uploadId == nil {
+		return
+	}
+	_, err := m.svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(m.bucket),
+		Key:      aws.String(m.key),
+		UploadId: m.uploadId,
+	})
+	if err != nil {
+		Warnf(ctx, "unable to abort the master index upload %s: %s", *m.uploadId, err.Error())
+	}
+	m.uploadId = nil
+}
@@ -0,0 +1,38 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMasterIndexAdd(t *testing.T) {
+	ctx := context.Background()
+	m := NewMasterIndex(nil, "bucket", "archive.index.csv")
+
+	a := &archiveIndex{archive: "s3://bucket/archive.0.tar"}
+	recordTOC(context.WithValue(ctx, contextKeyArchiveIndex, a), []byte("a.txt,1536,10,etag-a\n\"b,c.txt\",2560,20,etag-b\n"))
+	if err := m.add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	b := &archiveIndex{archive: "s3://bucket/archive.1.tar", toc: []byte("d.txt,1536,5,etag-d,abc123\n")}
+	if err := m.add(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	// in-memory archives have no TOC
+	if err := m.add(ctx, &archiveIndex{archive: "s3://bucket/archive.2.tar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "s3://bucket/archive.0.tar,a.txt,1536,10,etag-a,\n" +
+		"s3://bucket/archive.0.tar,\"b,c.txt\",2560,20,etag-b,\n" +
+		"s3://bucket/archive.1.tar,d.txt,1536,5,etag-d,abc123\n"
+	if got := m.buf.String(); got != want {
+		t.Errorf("index = %q, want %q", got, want)
+	}
+	if m.entries != 3 || m.archives != 2 {
+		t.Errorf("entries = %d, archives = %d, want 3 and 2", m.entries, m.archives)
+	}
+}
@@ -17,7 +17,8 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S3Obj, estimatedSize int64, opts *S3TarS3Options) (*S3Obj, error) {
+func buildInMemoryConcat(ctx context.Context, client Backend, objectList []*S3Obj, estimatedSize int64, opts *S3TarS3Options) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
 
 	largestObjectSize := findLargestObject(objectList)
 
@@ -25,15 +26,31 @@ func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S
 		return nil, fmt.Errorf("largest object is over the 5GiB limit\n")
 	}
 
-	if estimatedSize < fileSizeMin {
+	var enc *archiveCipher
+	var metadata map[string]string
+
+	if estimatedSize < cfg.padSize {
+		if opts.ClientEncryption != nil {
+			var err error
+			if enc, err = newArchiveCipher(ctx, opts.ClientEncryption, 1); err != nil {
+				return nil, err
+			}
+			metadata = enc.metadata
+		}
 		data, err := tarGroup(ctx, client, objectList, opts)
 		if err != nil {
 			return nil, err
 		}
-		return uploadObject(ctx, client, opts.DstBucket, opts.DstKey, data, opts)
+		if enc != nil {
+			data = enc.seal(1, data)
+		}
+		if err := checkOverwrite(ctx, client, opts); err != nil {
+			return nil, err
+		}
+		return uploadObject(ctx, client, opts.DstBucket, opts.DstKey, data, metadata, opts)
 	} else {
 
-		sizeLimit := findMinimumPartSize(estimatedSize, opts.UserMaxPartSize)
+		sizeLimit := cfg.findMinimumPartSize(estimatedSize, opts.UserMaxPartSize)
 
 		Infof(ctx, "mpu partsize: %s, largestObject: %d\n", formatBytes(sizeLimit), largestObjectSize)
 
@@ -44,37 +61,50 @@ func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S
 		// }
 		// objectList = append([]*S3Obj{tocObj}, objectList...)
 
-		groups := splitSliceBySizeLimit(sizeLimit, objectList)
+		groups := cfg.splitSliceBySizeLimit(sizeLimit, objectList)
 		if len(groups) > maxPartNumLimit {
 			return nil, fmt.Errorf("number of parts (%d) exceeded the number of mpu parts allowed (10k)\n", len(groups))
 		}
 
 		Infof(ctx, "number of parts: %d\n", len(groups))
 
+		if opts.ClientEncryption != nil {
+			var err error
+			if enc, err = newArchiveCipher(ctx, opts.ClientEncryption, int32(len(groups))); err != nil {
+				return nil, err
+			}
+			metadata = enc.metadata
+		}
+
 		tags := TagsToUrlEncodedString(opts.ObjectTags)
 
+		if err := checkOverwrite(ctx, client, opts); err != nil {
+			return nil, err
+		}
 		// create MPU
-		mpu, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
-			Bucket:               &opts.DstBucket,
-			Key:                  &opts.DstKey,
-			StorageClass:         opts.storageClass,
-			ChecksumAlgorithm:    types.ChecksumAlgorithmSha256,
-			Tagging:              &tags,
-			ACL:                  types.ObjectCannedACLBucketOwnerFullControl,
-			SSEKMSKeyId:          &opts.KMSKeyID,
-			ServerSideEncryption: opts.SSEAlgo,
-		})
+		input := &s3.CreateMultipartUploadInput{
+			Bucket:            &opts.DstBucket,
+			Key:               &opts.DstKey,
+			StorageClass:      opts.storageClass,
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			Tagging:           &tags,
+			ACL:               types.ObjectCannedACLBucketOwnerFullControl,
+			Metadata:          metadata,
+		}
+		newJobConfig(opts).encryptUpload(input)
+		mpu, err := client.CreateMultipartUpload(ctx, input)
 		if err != nil {
 			Errorf(ctx, "unable to create multipart")
 			return nil, err
 		}
+		trackUpload(ctx, opts.DstBucket, opts.DstKey, *mpu.UploadId)
 
 		parts := make([]types.CompletedPart, len(groups))
 		partsSizeList := make([]int64, len(groups))
 
 		processGroups := func() error {
 			g, _ := errgroup.WithContext(context.Background())
-			g.SetLimit(threads)
+			g.SetLimit(cfg.threads)
 
 			for i, group := range groups {
 				i, group := i, group
@@ -91,6 +121,9 @@ func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S
 					if i != len(groups)-1 { // only on the last iteration we leave the 2 block padding tar EOF.
 						data = data[0 : len(data)-1024]
 					}
+					if enc != nil {
+						data = enc.seal(partNum, data)
+					}
 
 					rc, err := uploadPart(ctx, client, *mpu.UploadId, opts.DstBucket, opts.DstKey, data, &partNum)
 					if err != nil {
@@ -102,6 +135,7 @@ func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S
 						ChecksumSHA256: rc.ChecksumSHA256,
 					}
 					partsSizeList[i] = int64(len(data))
+					heartbeatProgress(ctx, len(group))
 					return nil
 				})
 
@@ -128,6 +162,7 @@ func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S
 			Errorf(ctx, "unable to complete mpu")
 			return nil, err
 		}
+		untrackUpload(ctx, *mpu.UploadId)
 
 		totalSize := sumSlice[int64](partsSizeList)
 
@@ -143,7 +178,7 @@ func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S
 		}
 
 		// once the TOC is working we need to subtract 1 to the number of files we report
-		fmt.Printf("total files: %d\n", len(objectList))
+		Infof(ctx, "total files: %d", len(objectList))
 		return complete, nil
 	}
 
@@ -158,28 +193,25 @@ func sumSlice[T int | int32 | int64 | float64](i []T) (o T) {
 
 func findLargestObject(objectList []*S3Obj) int64 {
 	var largestObject int64 = 0
-	var largestObjectKey string
 	for _, o := range objectList {
 		if *o.Size > largestObject {
 			largestObject = *o.Size
-			largestObjectKey = *o.Key
 		}
 	}
-	fmt.Printf("largestObjectKey: %s\n", largestObjectKey)
 	return largestObject
 }
 
-func uploadObject(ctx context.Context, client *s3.Client, bucket, key string, data []byte, opts *S3TarS3Options) (*S3Obj, error) {
-
-	rc, err := client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:               &bucket,
-		Key:                  &key,
-		ChecksumAlgorithm:    types.ChecksumAlgorithmSha256,
-		StorageClass:         opts.storageClass,
-		Body:                 bytes.NewReader(data),
-		SSEKMSKeyId:          &opts.KMSKeyID,
-		ServerSideEncryption: opts.SSEAlgo,
-	})
+func uploadObject(ctx context.Context, client Backend, bucket, key string, data []byte, metadata map[string]string, opts *S3TarS3Options) (*S3Obj, error) {
+	input := &s3.PutObjectInput{
+		Bucket:            &bucket,
+		Key:               &key,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		StorageClass:      opts.storageClass,
+		Body:              limitBody(ctx, bytes.NewReader(data)),
+		Metadata:          metadata,
+	}
+	newJobConfig(opts).encryptPut(input)
+	rc, err := client.PutObject(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -198,9 +230,8 @@ func uploadObject(ctx context.Context, client *s3.Client, bucket, key string, da
 
 	return complete, nil
 }
-func uploadPart(ctx context.Context, client *s3.Client, uploadId, bucket, key string, data []byte, partNum *int32) (*s3.UploadPartOutput, error) {
-
-	body := io.ReadSeeker(bytes.NewReader(data))
+func uploadPart(ctx context.Context, client Backend, uploadId, bucket, key string, data []byte, partNum *int32) (*s3.UploadPartOutput, error) {
+	body := limitBody(ctx, bytes.NewReader(data))
 
 	rc, err := client.UploadPart(ctx, &s3.UploadPartInput{
 		UploadId:          &uploadId,
@@ -215,7 +246,8 @@ func uploadPart(ctx context.Context, client *s3.Client, uploadId, bucket, key st
 
 }
 
-func tarGroup(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) ([]byte, error) {
+func tarGroup(ctx context.Context, client Backend, objectList []*S3Obj, opts *S3TarS3Options) ([]byte, error) {
+	cfg := jobConfigFromContext(ctx)
 	buf := bytes.Buffer{}
 	tw := tar.NewWriter(&buf)
 
@@ -226,6 +258,8 @@ func tarGroup(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts
 		if len(o.Data) > 0 {
 			s3metadata = nil
 			r = io.NopCloser(bytes.NewReader(o.Data))
+		} else if o.LinkTarget != "" {
+			r = io.NopCloser(bytes.NewReader(nil))
 		} else {
 			r, s3metadata, err = downloadS3Data(ctx, client, o)
 			if err != nil {
@@ -234,21 +268,35 @@ func tarGroup(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts
 		}
 		defer r.Close()
 		h := tar.Header{
-			Name:       *o.Key,
+			Name:       o.entryName(),
 			Size:       *o.Size,
 			Mode:       0600,
 			ModTime:    *o.LastModified,
 			ChangeTime: *o.LastModified,
 			AccessTime: *o.LastModified,
-			Format:     tarFormat,
+			Format:     cfg.format,
 		}
 		if opts.PreservePOSIXMetadata {
-			setHeaderPermissions(&h, s3metadata)
+			if err := parseHeaderPermissions(&h, s3metadata); err != nil {
+				return nil, fmt.Errorf("%s: %w", h.Name, err)
+			}
+		}
+		applyTarFormat(&h)
+		h.PAXRecords = xattrRecords(o.Xattrs)
+		if o.LinkTarget != "" {
+			h.Typeflag = tar.TypeLink
+			h.Linkname = o.LinkTarget
 		}
 
+		headerStart := buf.Len()
 		if err := tw.WriteHeader(&h); err != nil {
 			return nil, err
 		}
+		if cfg.strictChecksum {
+			if err := strictHeaderChecksum(buf.Bytes()[headerStart:]); err != nil {
+				return nil, fmt.Errorf("%s: %w", *o.Key, err)
+			}
+		}
 		if _, err := io.Copy(tw, r); err != nil {
 			return nil, err
 		}
@@ -266,7 +314,7 @@ func tarGroup(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts
 
 }
 
-func splitSliceBySizeLimit(groupSizeLimit int64, objectList []*S3Obj) [][]*S3Obj {
+func (c jobConfig) splitSliceBySizeLimit(groupSizeLimit int64, objectList []*S3Obj) [][]*S3Obj {
 	var groups [][]*S3Obj
 	var currentGroup []*S3Obj
 	var currentSize int64 = 0
@@ -282,7 +330,7 @@ func splitSliceBySizeLimit(groupSizeLimit int64, objectList []*S3Obj) [][]*S3Obj
 		currentGroup = append(currentGroup, objectList[i])
 		currentSize += *objectList[i].Size
 
-		if currentSize > groupSizeLimit && currentSize > fileSizeMin {
+		if currentSize > groupSizeLimit && currentSize > c.padSize {
 			groups = append(groups, currentGroup)
 			currentGroup = nil
 			currentSize = 0
@@ -296,11 +344,25 @@ func splitSliceBySizeLimit(groupSizeLimit int64, objectList []*S3Obj) [][]*S3Obj
 	return groups
 }
 
-func downloadS3Data(ctx context.Context, client *s3.Client, object *S3Obj) (io.ReadCloser, map[string]string, error) {
-	resp, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &object.Bucket, Key: object.Key})
+func downloadS3Data(ctx context.Context, client Backend, object *S3Obj) (io.ReadCloser, map[string]string, error) {
+	input := &s3.GetObjectInput{Bucket: &object.Bucket, Key: object.Key}
+	if object.VersionId != "" {
+		input.VersionId = &object.VersionId
+	}
+	if object.Slice {
+		input.Range = aws.String(copySourceRange(object, 0, *object.Size))
+	}
+	resp, err := client.GetObject(ctx, input)
 	if err != nil {
-		fmt.Printf("error downloading: s3://%s/%s\n", object.Bucket, *object.Key)
+		Debugf(ctx, "error downloading: s3://%s/%s", object.Bucket, *object.Key)
 		return nil, nil, err
 	}
-	return resp.Body, resp.Metadata, nil
+	traceCall(ctx, object, TraceCall{Op: "GetObject", Range: aws.ToString(input.Range)})
+	encoding := aws.ToString(resp.ContentEncoding)
+	if resp.ContentLength != nil && *resp.ContentLength != *object.Size {
+		resp.Body.Close()
+		return nil, nil, sizeMismatch(object, *resp.ContentLength, encoding)
+	}
+	body := &sizeCheckReader{ReadCloser: resp.Body, obj: object, encoding: encoding}
+	return limitReader(ctx, body), resp.Metadata, nil
 }
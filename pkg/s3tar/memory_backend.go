@@ -0,0 +1,364 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// MemoryBackend is a Backend keeping the objects in memory, to test the
+// layout of the archives without Amazon S3. It enforces the rules of S3
+// the layout depends on: the parts of a multipart upload but the last are
+// at least MinPartSize and the ranges copied are in their source object.
+// Versions, tags and ACLs aren't supported.
+type MemoryBackend struct {
+	// MinPartSize is the minimum size of the parts but the last, 5MB by
+	// default like Amazon S3.
+	MinPartSize int64
+
+	mu      sync.Mutex
+	objects map[string]*memoryObject
+	uploads map[string]*memoryUpload
+	next    int
+}
+
+type memoryObject struct {
+	data         []byte
+	etag         string
+	metadata     map[string]string
+	lastModified time.Time
+	// parts are the sizes of the parts of a multipart object
+	parts []int64
+}
+
+type memoryUpload struct {
+	bucket   string
+	key      string
+	metadata map[string]string
+	parts    map[int32]*memoryObject
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		MinPartSize: 5 * 1024 * 1024,
+		objects:     map[string]*memoryObject{},
+		uploads:     map[string]*memoryUpload{},
+	}
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+// Put stores data at s3://bucket/key.
+func (m *MemoryBackend) Put(bucket, key string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[bucket+"/"+key] = newMemoryObject(data, nil)
+}
+
+// Get returns the data of s3://bucket/key, or nil when it doesn't exist.
+func (m *MemoryBackend) Get(bucket, key string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok := m.objects[bucket+"/"+key]; ok {
+		return o.data
+	}
+	return nil
+}
+
+// Keys returns the keys of the objects of bucket under prefix, sorted.
+func (m *MemoryBackend) Keys(bucket, prefix string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for name := range m.objects {
+		if key, ok := strings.CutPrefix(name, bucket+"/"); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newMemoryObject(data []byte, metadata map[string]string) *memoryObject {
+	return &memoryObject{
+		data:         data,
+		etag:         fmt.Sprintf("\"%x\"", md5.Sum(data)),
+		metadata:     metadata,
+		lastModified: time.Now().UTC(),
+	}
+}
+
+func memoryError(code, format string, a ...any) error {
+	return &smithy.GenericAPIError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// memoryRange returns the bytes of data in a bytes=start-end range.
+func memoryRange(data []byte, r string) ([]byte, error) {
+	if r == "" {
+		return data, nil
+	}
+	var start, end int64
+	if _, err := fmt.Sscanf(r, "bytes=%d-%d", &start, &end); err != nil {
+		if _, err := fmt.Sscanf(r, "bytes=%d-", &start); err != nil {
+			return nil, memoryError("InvalidArgument", "invalid range %s", r)
+		}
+		end = int64(len(data)) - 1
+	}
+	if start > end || end >= int64(len(data)) {
+		return nil, memoryError("InvalidRange", "range %s of a %d bytes object", r, len(data))
+	}
+	return data[start : end+1], nil
+}
+
+func (m *MemoryBackend) object(bucket, key string) (*memoryObject, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objects[bucket+"/"+key]
+	return o, ok
+}
+
+func (m *MemoryBackend) upload(uploadId *string) (*memoryUpload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uploads[aws.ToString(uploadId)]
+	if !ok {
+		return nil, &types.NoSuchUpload{Message: uploadId}
+	}
+	return u, nil
+}
+
+func (m *MemoryBackend) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	uploadId := fmt.Sprintf("upload-%d", m.next)
+	m.uploads[uploadId] = &memoryUpload{
+		bucket:   aws.ToString(params.Bucket),
+		key:      aws.ToString(params.Key),
+		metadata: params.Metadata,
+		parts:    map[int32]*memoryObject{},
+	}
+	return &s3.CreateMultipartUploadOutput{Bucket: params.Bucket, Key: params.Key, UploadId: aws.String(uploadId)}, nil
+}
+
+func (m *MemoryBackend) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	u, err := m.upload(params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	part := newMemoryObject(data, nil)
+	m.mu.Lock()
+	u.parts[aws.ToInt32(params.PartNumber)] = part
+	m.mu.Unlock()
+	return &s3.UploadPartOutput{ETag: aws.String(part.etag)}, nil
+}
+
+func (m *MemoryBackend) UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	u, err := m.upload(params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	source, _, _ := strings.Cut(aws.ToString(params.CopySource), "?")
+	source, err = url.PathUnescape(source)
+	if err != nil {
+		return nil, memoryError("InvalidArgument", "invalid copy source %s", aws.ToString(params.CopySource))
+	}
+	bucket, key, _ := strings.Cut(source, "/")
+	src, ok := m.object(bucket, key)
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String(source)}
+	}
+	data, err := memoryRange(src.data, aws.ToString(params.CopySourceRange))
+	if err != nil {
+		return nil, err
+	}
+	part := newMemoryObject(data, nil)
+	m.mu.Lock()
+	u.parts[aws.ToInt32(params.PartNumber)] = part
+	m.mu.Unlock()
+	return &s3.UploadPartCopyOutput{CopyPartResult: &types.CopyPartResult{ETag: aws.String(part.etag)}}, nil
+}
+
+func (m *MemoryBackend) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	u, err := m.upload(params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var completed []types.CompletedPart
+	if params.MultipartUpload != nil {
+		completed = params.MultipartUpload.Parts
+	}
+	if len(completed) == 0 {
+		return nil, memoryError("MalformedXML", "no parts")
+	}
+	var buf bytes.Buffer
+	var sizes []int64
+	last := int32(0)
+	for i, p := range completed {
+		num := aws.ToInt32(p.PartNumber)
+		part, ok := u.parts[num]
+		if !ok || part.etag != aws.ToString(p.ETag) {
+			return nil, memoryError("InvalidPart", "part %d of %s", num, u.key)
+		}
+		if num <= last {
+			return nil, memoryError("InvalidPartOrder", "part %d after part %d", num, last)
+		}
+		if i < len(completed)-1 && int64(len(part.data)) < m.MinPartSize {
+			return nil, memoryError("EntityTooSmall", "part %d of %s is %d bytes", num, u.key, len(part.data))
+		}
+		last = num
+		buf.Write(part.data)
+		sizes = append(sizes, int64(len(part.data)))
+	}
+	o := newMemoryObject(buf.Bytes(), u.metadata)
+	o.etag = fmt.Sprintf("\"%x-%d\"", md5.Sum(o.data), len(sizes))
+	o.parts = sizes
+	m.objects[u.bucket+"/"+u.key] = o
+	delete(m.uploads, aws.ToString(params.UploadId))
+	return &s3.CompleteMultipartUploadOutput{Bucket: params.Bucket, Key: params.Key, ETag: aws.String(o.etag)}, nil
+}
+
+func (m *MemoryBackend) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	if _, err := m.upload(params.UploadId); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	delete(m.uploads, aws.ToString(params.UploadId))
+	m.mu.Unlock()
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (m *MemoryBackend) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var data []byte
+	if params.Body != nil {
+		var err error
+		if data, err = io.ReadAll(params.Body); err != nil {
+			return nil, err
+		}
+	}
+	o := newMemoryObject(data, params.Metadata)
+	m.mu.Lock()
+	m.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = o
+	m.mu.Unlock()
+	return &s3.PutObjectOutput{ETag: aws.String(o.etag)}, nil
+}
+
+func (m *MemoryBackend) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	o, ok := m.object(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	if !ok {
+		return nil, &types.NoSuchKey{Message: params.Key}
+	}
+	data, err := memoryRange(o.data, aws.ToString(params.Range))
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: aws.Int64(int64(len(data))),
+		ETag:          aws.String(o.etag),
+		LastModified:  aws.Time(o.lastModified),
+		Metadata:      o.metadata,
+	}, nil
+}
+
+func (m *MemoryBackend) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	o, ok := m.object(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	if !ok {
+		return nil, &types.NotFound{Message: params.Key}
+	}
+	if params.IfMatch != nil && *params.IfMatch != o.etag {
+		return nil, memoryError("PreconditionFailed", "%s has changed", aws.ToString(params.Key))
+	}
+	head := &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(o.data))),
+		ETag:          aws.String(o.etag),
+		LastModified:  aws.Time(o.lastModified),
+		Metadata:      o.metadata,
+	}
+	if n := aws.ToInt32(params.PartNumber); n > 0 && len(o.parts) > 0 {
+		if int(n) > len(o.parts) {
+			return nil, memoryError("InvalidPartNumber", "%s has %d parts", aws.ToString(params.Key), len(o.parts))
+		}
+		head.ContentLength = aws.Int64(o.parts[n-1])
+		head.PartsCount = aws.Int32(int32(len(o.parts)))
+	}
+	return head, nil
+}
+
+func (m *MemoryBackend) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.mu.Lock()
+	delete(m.objects, aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key))
+	m.mu.Unlock()
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *MemoryBackend) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	out := &s3.DeleteObjectsOutput{}
+	if params.Delete == nil {
+		return out, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range params.Delete.Objects {
+		delete(m.objects, aws.ToString(params.Bucket)+"/"+aws.ToString(o.Key))
+		out.Deleted = append(out.Deleted, types.DeletedObject{Key: o.Key})
+	}
+	return out, nil
+}
+
+func (m *MemoryBackend) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	bucket := aws.ToString(params.Bucket)
+	after := aws.ToString(params.StartAfter)
+	if params.ContinuationToken != nil {
+		after = *params.ContinuationToken
+	}
+	maxKeys := int(aws.ToInt32(params.MaxKeys))
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	out := &s3.ListObjectsV2Output{Name: params.Bucket, Prefix: params.Prefix, IsTruncated: aws.Bool(false)}
+	for _, key := range m.Keys(bucket, aws.ToString(params.Prefix)) {
+		if key <= after {
+			continue
+		}
+		if len(out.Contents) == maxKeys {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = out.Contents[len(out.Contents)-1].Key
+			break
+		}
+		o, ok := m.object(bucket, key)
+		if !ok {
+			continue
+		}
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(o.data))),
+			ETag:         aws.String(o.etag),
+			LastModified: aws.Time(o.lastModified),
+		})
+	}
+	out.KeyCount = aws.Int32(int32(len(out.Contents)))
+	return out, nil
+}
@@ -0,0 +1,212 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// mergeSource is an archive being merged and its entries, the bytes
+// between the end of its TOC and its end of archive blocks.
+type mergeSource struct {
+	bucket string
+	key    string
+	toc    TOC
+	// start and size locate the entries in the archive
+	start int64
+	size  int64
+}
+
+// readMergeSource reads the TOC of an archive created by s3tar.
+func readMergeSource(ctx context.Context, svc *s3.Client, archive string) (*mergeSource, error) {
+	bucket, key := ExtractBucketAndPath(archive)
+	hdr, offset, err := extractTarHeader(ctx, svc, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read s3://%s/%s: %w", bucket, key, err)
+	}
+	if hdr.Name != "toc.csv" {
+		return nil, fmt.Errorf("s3://%s/%s has no TOC, only archives created by s3tar can be merged", bucket, key)
+	}
+	toc, err := extractCSVToc(ctx, svc, bucket, key, "")
+	if err != nil {
+		return nil, err
+	}
+	src := &mergeSource{bucket: bucket, key: key, toc: toc}
+	src.start = offset + hdr.Size + findPadding(hdr.Size)
+	// the duplicates of --dedup point back at the first copy, the last
+	// entry isn't always the one ending last
+	var end int64
+	for _, fm := range toc {
+		if e := fm.Start + fm.Size + findPadding(fm.Size); e > end {
+			end = e
+		}
+	}
+	if end > src.start {
+		src.size = end - src.start
+	}
+	return src, nil
+}
+
+// entries is the slice of the archive holding its entries.
+func (m *mergeSource) entries() *S3Obj {
+	o := NewS3ObjOptions(WithBucketAndKey(m.bucket, m.key), WithSize(m.size))
+	o.Slice, o.Offset = true, m.start
+	return o
+}
+
+// mergedTOC returns the TOC of the archive merging sources, the entries of
+// every source follow the ones of the previous source. Like tocLength, the
+// offset of the entries grows until the TOC written with it fits.
+func (c jobConfig) mergedTOC(sources []*mergeSource) []byte {
+	withDigest := false
+	for _, src := range sources {
+		for _, fm := range src.toc {
+			withDigest = withDigest || fm.SHA256 != ""
+		}
+	}
+	var offset int64
+	for {
+		buf := &bytes.Buffer{}
+		cw := csv.NewWriter(buf)
+		base := tarHeaderSize(c.format) + offset
+		for _, src := range sources {
+			for _, fm := range src.toc {
+				line := []string{fm.Filename, fmt.Sprintf("%d", fm.Start-src.start+base), fmt.Sprintf("%d", fm.Size), fm.Etag}
+				if withDigest {
+					line = append(line, fm.SHA256)
+				}
+				// a bytes.Buffer doesn't fail
+				cw.Write(line)
+			}
+			base += src.size
+		}
+		cw.Flush()
+		n := int64(buf.Len())
+		if padded := n + findPadding(n); padded > offset {
+			offset = padded
+			continue
+		}
+		return buf.Bytes()
+	}
+}
+
+// MergeArchives concatenates archives created by s3tar into a single
+// archive at s3://DstBucket/DstKey, e.g. to roll daily archives into a
+// monthly one. The entries of every archive are copied server-side with a
+// ranged copy, without their TOC and end of archive blocks, after a TOC
+// listing the entries of all of them. Nothing is downloaded but the TOCs.
+func MergeArchives(ctx context.Context, svc *s3.Client, archives []string, options *S3TarS3Options, optFns ...func(*S3TarS3Options)) error {
+	if len(archives) < 2 {
+		return fmt.Errorf("at least two archives are required to merge")
+	}
+	ctx, opts, err := rewriteOptions(ctx, options, optFns)
+	if err != nil {
+		return err
+	}
+	var sources []*mergeSource
+	entries := 0
+	for _, archive := range archives {
+		src, err := readMergeSource(ctx, svc, archive)
+		if err != nil {
+			return err
+		}
+		Infof(ctx, "s3://%s/%s: %d entries, %d bytes", src.bucket, src.key, len(src.toc), src.size)
+		entries += len(src.toc)
+		sources = append(sources, src)
+	}
+	if err := writeEntries(ctx, svc, sources, &opts); err != nil {
+		return err
+	}
+	Infof(ctx, "merged %d archives, %d entries into s3://%s/%s", len(sources), entries, opts.DstBucket, opts.DstKey)
+	return nil
+}
+
+// rewriteOptions applies optFns to a copy of options and returns ctx with
+// its job configuration, like createFromList, for the commands writing an
+// archive out of the entries of other archives.
+func rewriteOptions(ctx context.Context, options *S3TarS3Options, optFns []func(*S3TarS3Options)) (context.Context, S3TarS3Options, error) {
+	opts := options.Copy()
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if opts.formatErr != nil {
+		return ctx, opts, opts.formatErr
+	}
+	return withJobConfig(ctx, newJobConfig(&opts)), opts, nil
+}
+
+// writeEntries writes the entries of sources to s3://DstBucket/DstKey after
+// their merged TOC.
+func writeEntries(ctx context.Context, svc *s3.Client, sources []*mergeSource, opts *S3TarS3Options) (err error) {
+	cfg := jobConfigFromContext(ctx)
+	if opts.jobID, err = randomHex(4); err != nil {
+		return err
+	}
+	ctx = withJobID(ctx, opts.jobID)
+	if err := checkOverwrite(ctx, svc, opts); err != nil {
+		return err
+	}
+	start := time.Now()
+	toc := cfg.mergedTOC(sources)
+	start = recordStage(ctx, "toc", start)
+
+	defer func() {
+		cleanUp(ctx, svc, opts)
+		recordStage(ctx, "cleanup", start)
+	}()
+
+	parts := []*S3Obj{nil}
+	allLarge := true
+	var size int64
+	for _, src := range sources {
+		if src.size == 0 {
+			continue
+		}
+		parts = append(parts, src.entries())
+		allLarge = allLarge && src.size >= cfg.padSize
+		size += src.size
+	}
+	tempKey := JoinKey(partsKey(opts), "output.temp")
+	var concatObj *S3Obj
+	var trim int64
+	if allLarge {
+		// a single multipart upload, the TOC is padded to the minimum part
+		// size when smaller and the pad is trimmed by redistribute
+		frontPad := tarHeaderSize(cfg.format)+int64(len(toc)) < cfg.padSize
+		if frontPad {
+			trim = cfg.padSize
+		}
+		if parts[0], err = cfg.buildFirstPart(toc, frontPad); err != nil {
+			return err
+		}
+		size += *parts[0].Size - trim
+		parts = append(parts, cfg.generateLastBlock(size))
+		concatObj, err = concatObjects(ctx, svc, 0, parts, opts.DstBucket, tempKey)
+	} else {
+		if parts[0], err = cfg.buildFirstPart(toc, false); err != nil {
+			return err
+		}
+		size += *parts[0].Size
+		parts = append(parts, cfg.generateLastBlock(size))
+		tempOpts := opts.Copy()
+		tempOpts.DstKey = tempKey
+		concatObj, err = mergeTree(ctx, svc, parts, &tempOpts)
+	}
+	if err != nil {
+		return err
+	}
+	start = recordStage(ctx, "concat", start)
+
+	if _, err := redistribute(ctx, svc, concatObj, trim, opts.DstBucket, opts.DstKey, opts.storageClass, opts.ObjectTags); err != nil {
+		return err
+	}
+	recordStage(ctx, "redistribute", start)
+	return verifyArchiveSize(ctx, svc, opts, size+*parts[len(parts)-1].Size)
+}
@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"testing"
+)
+
+func TestMergedTOC(t *testing.T) {
+	sources := []*mergeSource{
+		{
+			toc: TOC{
+				{Filename: "a.txt", Start: 2048, Size: 100, Etag: "etag-a"},
+				{Filename: "b.txt", Start: 3072, Size: 600, Etag: "etag-b"},
+			},
+			start: 1536,
+			size:  2560,
+		},
+		{
+			toc: TOC{
+				{Filename: "c.txt", Start: 5632, Size: 10, Etag: "etag-c", SHA256: "digest-c"},
+			},
+			start: 5120,
+			size:  1024,
+		},
+	}
+	toc := defaultJobConfig.mergedTOC(sources)
+	records, err := csv.NewReader(bytes.NewReader(toc)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d entries, want 3", len(records))
+	}
+	for _, r := range records {
+		if len(r) != 5 {
+			t.Errorf("%s: got %d columns, want 5", r[0], len(r))
+		}
+	}
+
+	// the entries start right after the first part
+	base := int64(len(testFirstPart(t, defaultJobConfig, toc)))
+	want := []int64{base + 512, base + 1536, base + 2560 + 512}
+	for i, r := range records {
+		start, err := strconv.ParseInt(r[1], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start != want[i] {
+			t.Errorf("%s: start = %d, want %d", r[0], start, want[i])
+		}
+	}
+}
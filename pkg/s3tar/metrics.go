@@ -0,0 +1,258 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	contextKeyStats    = contextKey("stats")
+	contextKeyCounters = contextKey("counters")
+)
+
+var (
+	// requestCounts is the number of S3 requests sent by clients configured
+	// with WithRequestMetrics, by operation.
+	requestCounts = expvar.NewMap("s3tar_requests")
+	// requestErrors is the number of failed S3 requests by operation.
+	requestErrors = expvar.NewMap("s3tar_request_errors")
+	// requestRetries is the number of attempts the SDK retried by operation,
+	// requestThrottles the part of them S3 answered SlowDown or 503 to.
+	requestRetries   = expvar.NewMap("s3tar_request_retries")
+	requestThrottles = expvar.NewMap("s3tar_request_throttles")
+	// retryBudgetExhausted is the number of requests that failed because the
+	// retry budget of the client was spent, see NewRetryer.
+	retryBudgetExhausted = expvar.NewMap("s3tar_retry_budget_exhausted")
+	// bytesCopied is the number of bytes copied server-side with UploadPartCopy.
+	bytesCopied = expvar.NewInt("s3tar_bytes_copied")
+	// stageSeconds is the time spent in each stage of the archive creation.
+	stageSeconds = expvar.NewMap("s3tar_stage_seconds")
+
+	jobsQueued    = expvar.NewInt("s3tar_jobs_queued")
+	jobsInFlight  = expvar.NewInt("s3tar_jobs_in_flight")
+	jobsCompleted = expvar.NewInt("s3tar_jobs_completed")
+	jobsFailed    = expvar.NewInt("s3tar_jobs_failed")
+)
+
+// WithRequestMetrics counts every request sent by the S3 client and the
+// attempts the SDK retried. The counts of the process are published with
+// expvar as s3tar_requests and s3tar_request_retries, the ones of each job
+// show up in its --stats report. Use it when creating the client:
+//
+//	svc := s3.NewFromConfig(cfg, s3tar.WithRequestMetrics)
+func WithRequestMetrics(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3TarRequestMetrics",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				operation := awsmiddleware.GetOperationName(ctx)
+				counters := requestCountersFromContext(ctx)
+				counters.add(requestCounts, operation)
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				countRetries(counters, operation, metadata)
+				if err != nil {
+					counters.add(requestErrors, operation)
+					if errors.As(err, &ratelimit.QuotaExceededError{}) {
+						counters.add(retryBudgetExhausted, operation)
+					}
+				} else if input, ok := in.Parameters.(*s3.UploadPartCopyInput); ok {
+					bytesCopied.Add(copyRangeSize(aws.ToString(input.CopySourceRange)))
+				}
+				return out, metadata, err
+			}), middleware.After)
+	})
+}
+
+// countRetries counts the attempts of a request the SDK retried, every
+// attempt but the last.
+func countRetries(counters *requestCounters, operation string, metadata middleware.Metadata) {
+	results, ok := retry.GetAttemptResults(metadata)
+	if !ok || len(results.Results) < 2 {
+		return
+	}
+	for _, r := range results.Results[:len(results.Results)-1] {
+		counters.add(requestRetries, operation)
+		if isSlowDown(r.Err) {
+			counters.add(requestThrottles, operation)
+		}
+	}
+}
+
+// requestCounters are the requests of one job by operation, for each of the
+// expvar maps they are added to. The expvar maps are shared by the jobs of
+// the process, the stats of a job are taken from its own counters so the
+// archives built at the same time don't count each other's requests.
+type requestCounters struct {
+	mu     sync.Mutex
+	counts map[*expvar.Map]map[string]int64
+}
+
+// withRequestCounters returns a context whose requests are counted apart
+// from the ones of the other jobs.
+func withRequestCounters(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyCounters, &requestCounters{counts: map[*expvar.Map]map[string]int64{}})
+}
+
+func requestCountersFromContext(ctx context.Context) *requestCounters {
+	if c, ok := ctx.Value(contextKeyCounters).(*requestCounters); ok {
+		return c
+	}
+	return nil
+}
+
+// add counts a request of operation in m and in the counters of the job,
+// if any.
+func (c *requestCounters) add(m *expvar.Map, operation string) {
+	m.Add(operation, 1)
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[m] == nil {
+		c.counts[m] = map[string]int64{}
+	}
+	c.counts[m][operation]++
+}
+
+// snapshot returns the counts of the job added to m by operation.
+func (c *requestCounters) snapshot(m *expvar.Map) map[string]int64 {
+	counts := map[string]int64{}
+	if c == nil {
+		return counts
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.counts[m] {
+		counts[k] = v
+	}
+	return counts
+}
+
+// total returns the counts of the job added to m.
+func (c *requestCounters) total(m *expvar.Map) int64 {
+	var total int64
+	for _, v := range c.snapshot(m) {
+		total += v
+	}
+	return total
+}
+
+// NewRetryer returns the standard SDK retryer with up to maxAttempts attempts
+// per request, 0 for no limit, and a retry budget shared by the requests of
+// the client: budget retries, a timeout counts twice, and every request that
+// succeeds at the first attempt gives a fifth of a retry back. Once it is
+// spent requests fail at their first error instead of retrying, so a
+// throttled or unreachable endpoint doesn't stall the job. 0 disables the
+// budget. Use it with config.WithRetryer.
+func NewRetryer(maxAttempts, budget int) aws.Retryer {
+	return retry.AddWithMaxAttempts(retry.NewStandard(func(o *retry.StandardOptions) {
+		if budget > 0 {
+			o.RateLimiter = ratelimit.NewTokenRateLimit(uint(budget) * retry.DefaultRetryCost)
+		} else {
+			o.RateLimiter = ratelimit.None
+		}
+	}), maxAttempts)
+}
+
+// copyRangeSize returns the size of a bytes=start-end copy range.
+func copyRangeSize(r string) int64 {
+	var start, end int64
+	if _, err := fmt.Sscanf(r, "bytes=%d-%d", &start, &end); err != nil {
+		return 0
+	}
+	return end - start + 1
+}
+
+// startJob tracks an archive or extract job in the job metrics. The returned
+// function must be called with the result of the job.
+func startJob() func(error) {
+	jobsInFlight.Add(1)
+	return func(err error) {
+		jobsInFlight.Add(-1)
+		if err != nil {
+			jobsFailed.Add(1)
+		} else {
+			jobsCompleted.Add(1)
+		}
+	}
+}
+
+// MetricsHandler serves the request, job and stage metrics in the Prometheus
+// text format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetric(w, "s3tar_jobs_queued", "gauge", "Archives waiting for a free slot.", jobsQueued.Value())
+		writeMetric(w, "s3tar_jobs_in_flight", "gauge", "Archive and extract jobs running.", jobsInFlight.Value())
+		writeMetric(w, "s3tar_jobs_completed_total", "counter", "Jobs that finished without error.", jobsCompleted.Value())
+		writeMetric(w, "s3tar_jobs_failed_total", "counter", "Jobs that returned an error.", jobsFailed.Value())
+		writeMetric(w, "s3tar_bytes_copied_total", "counter", "Bytes copied server-side with UploadPartCopy.", bytesCopied.Value())
+		writeMetricMap(w, "s3tar_requests_total", "counter", "S3 requests sent.", "operation", requestCounts)
+		writeMetricMap(w, "s3tar_request_errors_total", "counter", "S3 requests that failed.", "operation", requestErrors)
+		writeMetricMap(w, "s3tar_request_retries_total", "counter", "S3 request attempts retried.", "operation", requestRetries)
+		writeMetricMap(w, "s3tar_request_throttles_total", "counter", "S3 request attempts retried after a SlowDown.", "operation", requestThrottles)
+		writeMetricMap(w, "s3tar_retry_budget_exhausted_total", "counter", "S3 requests that failed because the retry budget was spent.", "operation", retryBudgetExhausted)
+		writeMetricMap(w, "s3tar_stage_seconds_total", "counter", "Time spent in each stage.", "stage", stageSeconds)
+	})
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+func writeMetricMap(w io.Writer, name, kind, help, label string, m *expvar.Map) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	m.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", name, label, kv.Key, kv.Value.String())
+	})
+}
+
+// WithStats returns a context that collects the stages run before an archive
+// is created, e.g. the listing, so they are included in the --stats report.
+func WithStats(ctx context.Context) context.Context {
+	ctx = withRequestCounters(ctx)
+	return context.WithValue(ctx, contextKeyStats, &ArchiveStats{counters: requestCountersFromContext(ctx)})
+}
+
+func statsFromContext(ctx context.Context) *ArchiveStats {
+	if s, ok := ctx.Value(contextKeyStats).(*ArchiveStats); ok {
+		return s
+	}
+	return nil
+}
+
+// recordStage records the time since start under name in the expvar stage
+// timings and in the stats of the archive being built, if any. It returns
+// the current time so consecutive stages can be chained.
+func recordStage(ctx context.Context, name string, start time.Time) time.Time {
+	now := time.Now()
+	stageSeconds.AddFloat(name, now.Sub(start).Seconds())
+	statsFromContext(ctx).addStage(name, now.Sub(start))
+	return now
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,164 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type failingHTTPClient struct{}
+
+func (failingHTTPClient) Do(*http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("no network in tests")
+}
+
+func TestWithRequestMetrics(t *testing.T) {
+	svc := s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient:  failingHTTPClient{},
+		Retryer:     aws.NopRetryer{},
+	}, WithRequestMetrics)
+
+	// two jobs running at the same time
+	job, other := withRequestCounters(context.TODO()), withRequestCounters(context.TODO())
+	processCount := func() int64 {
+		if v, ok := requestCounts.Get("HeadObject").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := processCount()
+	for i := 0; i < 2; i++ {
+		svc.HeadObject(job, &s3.HeadObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")})
+	}
+	svc.HeadObject(other, &s3.HeadObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")})
+	if got := requestCountersFromContext(job).snapshot(requestCounts)["HeadObject"]; got != 2 {
+		t.Errorf("HeadObject requests = %d, want 2", got)
+	}
+	if got := requestCountersFromContext(other).snapshot(requestErrors)["HeadObject"]; got != 1 {
+		t.Errorf("HeadObject errors = %d, want 1", got)
+	}
+	// the expvar counters are the ones of the process
+	if got := processCount() - before; got != 3 {
+		t.Errorf("process HeadObject requests = %d, want 3", got)
+	}
+}
+
+// scriptedHTTPClient answers the requests with the status codes of script in
+// turn, 0 for a network error, and with network errors once it's done.
+type scriptedHTTPClient struct {
+	mu     sync.Mutex
+	script []int
+}
+
+func (c *scriptedHTTPClient) Do(*http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.script) == 0 || c.script[0] == 0 {
+		if len(c.script) > 0 {
+			c.script = c.script[1:]
+		}
+		return nil, fmt.Errorf("no network in tests")
+	}
+	status := c.script[0]
+	c.script = c.script[1:]
+	return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+// noDelayRetryer retries without waiting.
+type noDelayRetryer struct {
+	aws.Retryer
+}
+
+func (noDelayRetryer) RetryDelay(int, error) (time.Duration, error) {
+	return 0, nil
+}
+
+func TestRequestRetries(t *testing.T) {
+	newClient := func(httpClient aws.HTTPClient, budget int) *s3.Client {
+		return s3.New(s3.Options{
+			Region:      "us-east-1",
+			Credentials: aws.AnonymousCredentials{},
+			HTTPClient:  httpClient,
+			Retryer:     noDelayRetryer{NewRetryer(10, budget)},
+		}, WithRequestMetrics)
+	}
+	input := &s3.HeadObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")}
+
+	ctx := withRequestCounters(context.TODO())
+	counters := requestCountersFromContext(ctx)
+	svc := newClient(&scriptedHTTPClient{script: []int{http.StatusServiceUnavailable, 0, http.StatusOK}}, 0)
+	if _, err := svc.HeadObject(ctx, input); err != nil {
+		t.Fatal(err)
+	}
+	if got := counters.snapshot(requestRetries)["HeadObject"]; got != 2 {
+		t.Errorf("HeadObject retries = %d, want 2", got)
+	}
+	if got := counters.snapshot(requestThrottles)["HeadObject"]; got != 1 {
+		t.Errorf("HeadObject throttles = %d, want 1", got)
+	}
+
+	// a budget of one retry
+	ctx = withRequestCounters(context.TODO())
+	counters = requestCountersFromContext(ctx)
+	svc = newClient(&scriptedHTTPClient{}, 1)
+	_, err := svc.HeadObject(ctx, input)
+	if !errors.As(err, &ratelimit.QuotaExceededError{}) {
+		t.Fatalf("got %v, want a QuotaExceededError", err)
+	}
+	if got := counters.snapshot(requestRetries)["HeadObject"]; got != 1 {
+		t.Errorf("HeadObject retries = %d, want 1", got)
+	}
+	if got := counters.snapshot(retryBudgetExhausted)["HeadObject"]; got != 1 {
+		t.Errorf("HeadObject exhausted budgets = %d, want 1", got)
+	}
+}
+
+func TestRecordStage(t *testing.T) {
+	ctx := WithStats(context.TODO())
+	recordStage(ctx, "list", time.Now().Add(-time.Second))
+	s := statsFromContext(ctx)
+	if len(s.Stages) != 1 || s.Stages[0].Name != "list" || s.Stages[0].Duration < time.Second {
+		t.Errorf("unexpected stages %+v", s.Stages)
+	}
+	// without stats in the context only the expvar timings are updated
+	recordStage(context.TODO(), "list", time.Now())
+}
+
+func TestMetricsHandler(t *testing.T) {
+	done := startJob()
+	done(nil)
+	bytesCopied.Add(copyRangeSize("bytes=0-1023"))
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE s3tar_jobs_in_flight gauge\ns3tar_jobs_in_flight 0\n",
+		"# TYPE s3tar_jobs_completed_total counter\n",
+		"# TYPE s3tar_bytes_copied_total counter\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if copyRangeSize("bytes=0-1023") != 1024 {
+		t.Errorf("copyRangeSize() = %d, want 1024", copyRangeSize("bytes=0-1023"))
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Routes of NetworkReport.
+const (
+	RouteGatewayEndpoint   = "gateway endpoint"
+	RouteInterfaceEndpoint = "interface endpoint"
+	RouteNAT               = "NAT"
+	RouteInternetGateway   = "internet gateway"
+	RouteProxy             = "proxy"
+	RouteUnknown           = "unknown"
+)
+
+// networkTimeout bounds every step of InspectNetwork, the instance metadata
+// service doesn't answer off EC2.
+const networkTimeout = 3 * time.Second
+
+// NetworkReport is how the requests reach the regional Amazon S3 endpoint,
+// see InspectNetwork.
+type NetworkReport struct {
+	// Endpoint is the host of the endpoint.
+	Endpoint  string
+	Addresses []string
+	// Route is one of the Route constants. It is RouteUnknown off EC2, with
+	// a custom endpoint or when the route table can't be read.
+	Route string
+	// Via is the target of the route, e.g. vpce-0123 or nat-0123.
+	Via string
+}
+
+// InspectNetwork resolves the regional S3 endpoint of svc and opens a TCP
+// connection to it, the requests s3tar sends millions of. On EC2 it then
+// reads the route table of the subnet of the instance to tell whether the
+// requests go through an S3 gateway endpoint or a NAT, which bills every GB
+// processed. That needs ec2:DescribeRouteTables and ec2:DescribePrefixLists,
+// the route is RouteUnknown without them. The error is only about the
+// resolution and the connection.
+func InspectNetwork(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) (*NetworkReport, error) {
+	o := svc.Options()
+	endpoint, err := s3EndpointURL(o, opts)
+	if err != nil {
+		return nil, err
+	}
+	r := &NetworkReport{Endpoint: endpoint.Hostname(), Route: RouteUnknown}
+	if proxy, err := http.ProxyFromEnvironment(&http.Request{URL: endpoint}); err == nil && proxy != nil {
+		// the proxy resolves and connects
+		r.Route, r.Via = RouteProxy, proxy.Host
+		return r, nil
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, networkTimeout)
+	defer cancel()
+	r.Addresses, err = net.DefaultResolver.LookupHost(lookupCtx, r.Endpoint)
+	if err != nil {
+		return r, fmt.Errorf("unable to resolve %s: %w", r.Endpoint, err)
+	}
+	port := endpoint.Port()
+	if port == "" {
+		port = "443"
+		if endpoint.Scheme == "http" {
+			port = "80"
+		}
+	}
+	conn, err := (&net.Dialer{Timeout: networkTimeout}).DialContext(ctx, "tcp", net.JoinHostPort(r.Endpoint, port))
+	if err != nil {
+		return r, fmt.Errorf("unable to connect to %s: %w", r.Endpoint, err)
+	}
+	conn.Close()
+
+	if privateAddresses(r.Addresses) {
+		// the private DNS of an interface endpoint
+		r.Route = RouteInterfaceEndpoint
+		return r, nil
+	}
+	if opts.EndpointUrl != "" {
+		return r, nil
+	}
+	subnet, vpc, err := instanceSubnet(ctx)
+	if err != nil {
+		Debugf(ctx, "not checking the route to %s, the instance metadata is unavailable: %s", r.Endpoint, err.Error())
+		return r, nil
+	}
+	client := ec2.New(ec2.Options{Region: o.Region, Credentials: o.Credentials, HTTPClient: o.HTTPClient})
+	table, prefixList, err := s3RouteTable(ctx, client, o.Region, subnet, vpc)
+	if err != nil {
+		Warnf(ctx, "unable to read the route table of %s, check ec2:DescribeRouteTables and ec2:DescribePrefixLists: %s", subnet, err.Error())
+		return r, nil
+	}
+	r.Route, r.Via = s3Route(table, prefixList)
+	return r, nil
+}
+
+// s3EndpointURL returns opts.EndpointUrl, or the regional S3 endpoint.
+func s3EndpointURL(o s3.Options, opts *S3TarS3Options) (*url.URL, error) {
+	if opts.EndpointUrl != "" {
+		u, err := url.Parse(opts.EndpointUrl)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint url %q", opts.EndpointUrl)
+		}
+		return u, nil
+	}
+	name, suffix := "s3", "amazonaws.com"
+	if o.EndpointOptions.UseFIPSEndpoint == aws.FIPSEndpointStateEnabled {
+		name = "s3-fips"
+	}
+	if strings.HasPrefix(o.Region, "cn-") {
+		suffix = "amazonaws.com.cn"
+	}
+	return &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.%s.%s", name, o.Region, suffix)}, nil
+}
+
+// privateAddresses reports whether all the addresses are private.
+func privateAddresses(addresses []string) bool {
+	for _, a := range addresses {
+		ip, err := netip.ParseAddr(a)
+		if err != nil || !ip.IsPrivate() {
+			return false
+		}
+	}
+	return len(addresses) > 0
+}
+
+// instanceSubnet returns the subnet and the VPC of the EC2 instance s3tar
+// runs on.
+func instanceSubnet(ctx context.Context) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, networkTimeout)
+	defer cancel()
+	client := imds.New(imds.Options{Retryer: aws.NopRetryer{}})
+	get := func(path string) (string, error) {
+		output, err := client.GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+		if err != nil {
+			return "", err
+		}
+		defer output.Content.Close()
+		data, err := io.ReadAll(output.Content)
+		return strings.TrimSpace(string(data)), err
+	}
+	mac, err := get("mac")
+	if err != nil {
+		return "", "", err
+	}
+	subnet, err := get("network/interfaces/macs/" + mac + "/subnet-id")
+	if err != nil {
+		return "", "", err
+	}
+	vpc, err := get("network/interfaces/macs/" + mac + "/vpc-id")
+	return subnet, vpc, err
+}
+
+// s3RouteTable returns the route table of subnet, the main route table of
+// vpc when the subnet has none, and the id of the prefix list of S3 in the
+// region.
+func s3RouteTable(ctx context.Context, client *ec2.Client, region, subnet, vpc string) (ec2types.RouteTable, string, error) {
+	filters := [][]ec2types.Filter{
+		{{Name: aws.String("association.subnet-id"), Values: []string{subnet}}},
+		{{Name: aws.String("vpc-id"), Values: []string{vpc}}, {Name: aws.String("association.main"), Values: []string{"true"}}},
+	}
+	var table *ec2types.RouteTable
+	for _, f := range filters {
+		output, err := client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{Filters: f})
+		if err != nil {
+			return ec2types.RouteTable{}, "", err
+		}
+		if len(output.RouteTables) > 0 {
+			table = &output.RouteTables[0]
+			break
+		}
+	}
+	if table == nil {
+		return ec2types.RouteTable{}, "", fmt.Errorf("no route table found")
+	}
+	name := "com.amazonaws." + region + ".s3"
+	output, err := client.DescribePrefixLists(ctx, &ec2.DescribePrefixListsInput{
+		Filters: []ec2types.Filter{{Name: aws.String("prefix-list-name"), Values: []string{name}}},
+	})
+	if err != nil {
+		return ec2types.RouteTable{}, "", err
+	}
+	if len(output.PrefixLists) == 0 {
+		return ec2types.RouteTable{}, "", fmt.Errorf("no prefix list %s", name)
+	}
+	return *table, aws.ToString(output.PrefixLists[0].PrefixListId), nil
+}
+
+// s3Route returns the route of the S3 traffic in table: the gateway endpoint
+// of the S3 prefix list when there is one, otherwise the default route.
+func s3Route(table ec2types.RouteTable, prefixList string) (string, string) {
+	var def *ec2types.Route
+	for i, r := range table.Routes {
+		if r.State == ec2types.RouteStateBlackhole {
+			continue
+		}
+		if aws.ToString(r.DestinationPrefixListId) == prefixList && strings.HasPrefix(aws.ToString(r.GatewayId), "vpce-") {
+			return RouteGatewayEndpoint, aws.ToString(r.GatewayId)
+		}
+		if aws.ToString(r.DestinationCidrBlock) == "0.0.0.0/0" {
+			def = &table.Routes[i]
+		}
+	}
+	switch {
+	case def == nil:
+		return RouteUnknown, ""
+	case def.NatGatewayId != nil:
+		return RouteNAT, aws.ToString(def.NatGatewayId)
+	case def.InstanceId != nil:
+		// a NAT instance
+		return RouteNAT, aws.ToString(def.InstanceId)
+	case strings.HasPrefix(aws.ToString(def.GatewayId), "igw-"):
+		return RouteInternetGateway, aws.ToString(def.GatewayId)
+	case def.TransitGatewayId != nil:
+		return RouteUnknown, aws.ToString(def.TransitGatewayId)
+	}
+	return RouteUnknown, aws.ToString(def.GatewayId)
+}
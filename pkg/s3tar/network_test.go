@@ -0,0 +1,132 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestS3Route(t *testing.T) {
+	const s3List = "pl-63a5400a"
+	igw := ec2types.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-1")}
+	nat := ec2types.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1")}
+	local := ec2types.Route{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local")}
+	endpoint := ec2types.Route{DestinationPrefixListId: aws.String(s3List), GatewayId: aws.String("vpce-1")}
+	dynamodb := ec2types.Route{DestinationPrefixListId: aws.String("pl-02cd2c6b"), GatewayId: aws.String("vpce-2")}
+	blackhole := endpoint
+	blackhole.State = ec2types.RouteStateBlackhole
+
+	tests := []struct {
+		name   string
+		routes []ec2types.Route
+		route  string
+		via    string
+	}{
+		{"gateway endpoint", []ec2types.Route{local, nat, endpoint}, RouteGatewayEndpoint, "vpce-1"},
+		{"nat", []ec2types.Route{local, nat}, RouteNAT, "nat-1"},
+		{"dynamodb endpoint", []ec2types.Route{local, dynamodb, nat}, RouteNAT, "nat-1"},
+		{"blackhole endpoint", []ec2types.Route{blackhole, nat}, RouteNAT, "nat-1"},
+		{"nat instance", []ec2types.Route{{DestinationCidrBlock: aws.String("0.0.0.0/0"), InstanceId: aws.String("i-1")}}, RouteNAT, "i-1"},
+		{"internet gateway", []ec2types.Route{local, igw}, RouteInternetGateway, "igw-1"},
+		{"transit gateway", []ec2types.Route{{DestinationCidrBlock: aws.String("0.0.0.0/0"), TransitGatewayId: aws.String("tgw-1")}}, RouteUnknown, "tgw-1"},
+		{"no default route", []ec2types.Route{local}, RouteUnknown, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, via := s3Route(ec2types.RouteTable{Routes: tt.routes}, s3List)
+			if route != tt.route || via != tt.via {
+				t.Errorf("s3Route() = %s %s, want %s %s", route, via, tt.route, tt.via)
+			}
+		})
+	}
+}
+
+func TestPrivateAddresses(t *testing.T) {
+	tests := map[string]bool{
+		"10.0.1.5,172.16.0.1": true,
+		"10.0.1.5,52.92.1.1":  false,
+		"52.218.1.1":          false,
+		"fd00::1":             true,
+		"":                    false,
+	}
+	for addresses, want := range tests {
+		var list []string
+		if addresses != "" {
+			list = strings.Split(addresses, ",")
+		}
+		if got := privateAddresses(list); got != want {
+			t.Errorf("privateAddresses(%s) = %v", addresses, got)
+		}
+	}
+}
+
+func TestS3EndpointURL(t *testing.T) {
+	tests := []struct {
+		region string
+		fips   bool
+		want   string
+	}{
+		{"us-west-2", false, "s3.us-west-2.amazonaws.com"},
+		{"us-gov-west-1", true, "s3-fips.us-gov-west-1.amazonaws.com"},
+		{"cn-north-1", false, "s3.cn-north-1.amazonaws.com.cn"},
+	}
+	for _, tt := range tests {
+		o := s3.Options{Region: tt.region}
+		if tt.fips {
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+		}
+		u, err := s3EndpointURL(o, &S3TarS3Options{})
+		if err != nil || u.Host != tt.want {
+			t.Errorf("s3EndpointURL(%s) = %v, %v", tt.region, u, err)
+		}
+	}
+	if u, err := s3EndpointURL(s3.Options{}, &S3TarS3Options{EndpointUrl: "http://minio:9000"}); err != nil || u.Host != "minio:9000" {
+		t.Errorf("s3EndpointURL() = %v, %v", u, err)
+	}
+}
+
+func TestInspectNetwork(t *testing.T) {
+	ctx := context.Background()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	svc := s3.New(s3.Options{Region: "us-east-1", Credentials: aws.AnonymousCredentials{}})
+
+	opts := &S3TarS3Options{EndpointUrl: "http://" + lis.Addr().String()}
+	report, err := InspectNetwork(ctx, svc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Endpoint != "127.0.0.1" || len(report.Addresses) != 1 || report.Route != RouteUnknown {
+		t.Errorf("InspectNetwork() = %+v", report)
+	}
+	if err := checkNetwork(ctx, svc, opts); err != nil {
+		t.Errorf("checkNetwork() = %v", err)
+	}
+	opts.RequireGatewayEndpoint = true
+	if err := checkNetwork(ctx, svc, opts); err == nil || !strings.Contains(err.Error(), "not an S3 gateway endpoint") {
+		t.Errorf("checkNetwork() with RequireGatewayEndpoint = %v", err)
+	}
+
+	addr := lis.Addr().String()
+	lis.Close()
+	if _, err := InspectNetwork(ctx, svc, &S3TarS3Options{EndpointUrl: "http://" + addr}); err == nil || !strings.Contains(err.Error(), "unable to connect") {
+		t.Errorf("InspectNetwork() of a closed port = %v", err)
+	}
+	_, err = InspectNetwork(ctx, svc, &S3TarS3Options{EndpointUrl: "http://s3tar-test.invalid"})
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Errorf("InspectNetwork() of an unknown host = %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ObjectLambdaEvent is the event S3 Object Lambda invokes a function with.
+// Only the fields used to answer GetObject are kept.
+type ObjectLambdaEvent struct {
+	GetObjectContext *ObjectLambdaGetObjectContext `json:"getObjectContext"`
+	Configuration    ObjectLambdaConfiguration     `json:"configuration"`
+	UserRequest      ObjectLambdaUserRequest       `json:"userRequest"`
+}
+
+// ObjectLambdaGetObjectContext routes the response of a GetObject.
+type ObjectLambdaGetObjectContext struct {
+	InputS3URL  string `json:"inputS3Url"`
+	OutputRoute string `json:"outputRoute"`
+	OutputToken string `json:"outputToken"`
+}
+
+// ObjectLambdaConfiguration is the configuration of the Object Lambda access
+// point. Payload is ObjectLambdaPayload as JSON.
+type ObjectLambdaConfiguration struct {
+	AccessPointArn           string `json:"accessPointArn"`
+	SupportingAccessPointArn string `json:"supportingAccessPointArn"`
+	Payload                  string `json:"payload"`
+}
+
+// ObjectLambdaUserRequest is the request sent by the application.
+type ObjectLambdaUserRequest struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// ObjectLambdaPayload is the payload of an Object Lambda access point serving
+// the entries of an archive.
+type ObjectLambdaPayload struct {
+	// Archive is the s3:// url of the archive.
+	Archive string `json:"archive"`
+}
+
+// ObjectLambdaHandler answers the GetObject requests of an S3 Object Lambda
+// access point with the entries of an archive, read by range through its TOC,
+// so applications read the original keys without extracting the archive.
+type ObjectLambdaHandler struct {
+	svc *s3.Client
+	// Archive is used when the access point has no payload.
+	Archive string
+
+	mu   sync.Mutex
+	tocs map[string]map[string]*FileMetadata
+}
+
+// NewObjectLambdaHandler returns an ObjectLambdaHandler. The TOC of every
+// archive is read once and kept for the life of the function.
+func NewObjectLambdaHandler(svc *s3.Client) *ObjectLambdaHandler {
+	return &ObjectLambdaHandler{svc: svc, tocs: map[string]map[string]*FileMetadata{}}
+}
+
+// Handle answers event with WriteGetObjectResponse. The error is the one
+// returned to Lambda, the application gets an S3 error response either way.
+func (h *ObjectLambdaHandler) Handle(ctx context.Context, event *ObjectLambdaEvent) error {
+	if event.GetObjectContext == nil {
+		return fmt.Errorf("only GetObject is supported")
+	}
+	archive := h.Archive
+	if event.Configuration.Payload != "" {
+		var payload ObjectLambdaPayload
+		if err := json.Unmarshal([]byte(event.Configuration.Payload), &payload); err != nil {
+			return h.fail(ctx, event, http.StatusInternalServerError, "InternalError", fmt.Errorf("invalid payload: %w", err))
+		}
+		archive = payload.Archive
+	}
+	if !strings.HasPrefix(archive, "s3://") {
+		return h.fail(ctx, event, http.StatusInternalServerError, "InternalError", fmt.Errorf("the payload has no s3:// archive"))
+	}
+	u, err := url.Parse(event.UserRequest.URL)
+	if err != nil {
+		return h.fail(ctx, event, http.StatusBadRequest, "InvalidRequest", err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+
+	toc, err := h.toc(ctx, archive)
+	if err != nil {
+		return h.fail(ctx, event, http.StatusInternalServerError, "InternalError", err)
+	}
+	entry, ok := toc[key]
+	if !ok {
+		_, err := h.svc.WriteGetObjectResponse(ctx, &s3.WriteGetObjectResponseInput{
+			RequestRoute: &event.GetObjectContext.OutputRoute,
+			RequestToken: &event.GetObjectContext.OutputToken,
+			StatusCode:   aws.Int32(http.StatusNotFound),
+			ErrorCode:    aws.String("NoSuchKey"),
+			ErrorMessage: aws.String("The specified key does not exist."),
+		})
+		return err
+	}
+
+	start, end, partial, err := entryRange(header(event.UserRequest.Headers, "Range"), entry.Size)
+	if err != nil {
+		return h.fail(ctx, event, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", err)
+	}
+	input := &s3.WriteGetObjectResponseInput{
+		RequestRoute:  &event.GetObjectContext.OutputRoute,
+		RequestToken:  &event.GetObjectContext.OutputToken,
+		StatusCode:    aws.Int32(http.StatusOK),
+		AcceptRanges:  aws.String("bytes"),
+		ContentLength: aws.Int64(end - start),
+		Body:          bytes.NewReader(nil),
+	}
+	if entry.Etag != "" {
+		input.ETag = &entry.Etag
+	}
+	if partial {
+		input.StatusCode = aws.Int32(http.StatusPartialContent)
+		input.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end-1, entry.Size))
+	}
+	if end > start {
+		bucket, archiveKey := ExtractBucketAndPath(archive)
+		body, err := getObjectRange(ctx, h.svc, bucket, archiveKey, entry.Start+start, entry.Start+end-1)
+		if err != nil {
+			return h.fail(ctx, event, http.StatusInternalServerError, "InternalError", err)
+		}
+		defer body.Close()
+		input.Body = body
+	}
+	_, err = h.svc.WriteGetObjectResponse(ctx, input)
+	return err
+}
+
+// fail sends an error response to the application and returns err.
+func (h *ObjectLambdaHandler) fail(ctx context.Context, event *ObjectLambdaEvent, status int32, code string, err error) error {
+	Warnf(ctx, "%s: %s", event.UserRequest.URL, err.Error())
+	if _, werr := h.svc.WriteGetObjectResponse(ctx, &s3.WriteGetObjectResponseInput{
+		RequestRoute: &event.GetObjectContext.OutputRoute,
+		RequestToken: &event.GetObjectContext.OutputToken,
+		StatusCode:   &status,
+		ErrorCode:    &code,
+		ErrorMessage: aws.String(err.Error()),
+	}); werr != nil {
+		Warnf(ctx, "unable to write the error response: %s", werr.Error())
+	}
+	return err
+}
+
+// toc returns the entries of archive by name.
+func (h *ObjectLambdaHandler) toc(ctx context.Context, archive string) (map[string]*FileMetadata, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if toc, ok := h.tocs[archive]; ok {
+		return toc, nil
+	}
+	bucket, key := ExtractBucketAndPath(archive)
+	entries, err := extractCSVToc(ctx, h.svc, bucket, key, "")
+	if err != nil {
+		return nil, err
+	}
+	toc := make(map[string]*FileMetadata, len(entries))
+	for _, e := range entries {
+		toc[e.Filename] = e
+	}
+	h.tocs[archive] = toc
+	return toc, nil
+}
+
+// header returns the value of the header name, whatever its case.
+func header(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// entryRange returns the bytes [start, end) of an entry of size bytes asked
+// for by the Range header r, and whether it is a partial read.
+func entryRange(r string, size int64) (start, end int64, partial bool, err error) {
+	if r == "" {
+		return 0, size, false, nil
+	}
+	spec, ok := strings.CutPrefix(r, "bytes=")
+	first, last, found := strings.Cut(spec, "-")
+	if !ok || !found || strings.Contains(spec, ",") {
+		return 0, 0, false, fmt.Errorf("unsupported range %q", r)
+	}
+	if first == "" {
+		// the last bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, fmt.Errorf("invalid range %q", r)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false, fmt.Errorf("invalid range %q for %d bytes", r, size)
+	}
+	end = size
+	if last != "" {
+		l, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || l < start {
+			return 0, 0, false, fmt.Errorf("invalid range %q", r)
+		}
+		if l+1 < size {
+			end = l + 1
+		}
+	}
+	return start, end, true, nil
+}
+
+// ServeLambdaRuntime runs h as a Lambda custom runtime, answering the
+// invocations of the runtime API at runtimeAPI, the value of
+// AWS_LAMBDA_RUNTIME_API, until ctx is done.
+func (h *ObjectLambdaHandler) ServeLambdaRuntime(ctx context.Context, runtimeAPI string) error {
+	base := "http://" + runtimeAPI + "/2018-06-01/runtime/invocation/"
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"next", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		invocationCtx, cancel := ctx, context.CancelFunc(func() {})
+		if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+			invocationCtx, cancel = context.WithDeadline(ctx, time.UnixMilli(ms))
+		}
+		var event ObjectLambdaEvent
+		err = json.NewDecoder(resp.Body).Decode(&event)
+		resp.Body.Close()
+		if err == nil {
+			err = h.Handle(invocationCtx, &event)
+		}
+		cancel()
+
+		result, body := base+id+"/response", []byte(`{"statusCode":200}`)
+		if err != nil {
+			result = base + id + "/error"
+			body, _ = json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": "S3TarError"})
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, result, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// objectLambdaHTTPClient serves the ranges of archive and keeps the
+// WriteGetObjectResponse requests.
+type objectLambdaHTTPClient struct {
+	archive   []byte
+	responses *[]*http.Request
+	bodies    *[]string
+}
+
+func (c objectLambdaHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(r.URL.Path, "/WriteGetObjectResponse") {
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+		}
+		*c.responses = append(*c.responses, r)
+		*c.bodies = append(*c.bodies, string(body))
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+	}
+	return rangeHTTPClient{archive: c.archive}.Do(r)
+}
+
+func TestObjectLambdaHandler(t *testing.T) {
+	// a GNU archive: the TOC at 512, a.txt at 1536 and the empty b.txt at 2560
+	toc := []byte("a.txt,1536,11,\"etag-a\"\nb.txt,2560,0,\n")
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct{ name, data string }{{"toc.csv", string(toc)}, {"a.txt", "hello world"}, {"b.txt", ""}} {
+		tw.WriteHeader(&tar.Header{Name: e.name, Size: int64(len(e.data)), Mode: 0600, Format: tar.FormatGNU})
+		tw.Write([]byte(e.data))
+	}
+	tw.Close()
+
+	var responses []*http.Request
+	var bodies []string
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   objectLambdaHTTPClient{archive: buf.Bytes(), responses: &responses, bodies: &bodies},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	h := NewObjectLambdaHandler(svc)
+
+	tests := []struct {
+		key, rng     string
+		status, body string
+		contentRange string
+		err          bool
+	}{
+		{key: "a.txt", status: "200", body: "hello world"},
+		{key: "a.txt", rng: "bytes=6-", status: "206", body: "world", contentRange: "bytes 6-10/11"},
+		{key: "a.txt", rng: "bytes=0-4", status: "206", body: "hello", contentRange: "bytes 0-4/11"},
+		{key: "a.txt", rng: "bytes=-3", status: "206", body: "rld", contentRange: "bytes 8-10/11"},
+		{key: "a.txt", rng: "bytes=11-", status: "416", err: true},
+		{key: "b.txt", status: "200", body: ""},
+		{key: "c.txt", status: "404"},
+	}
+	for _, test := range tests {
+		t.Run(test.key+test.rng, func(t *testing.T) {
+			responses, bodies = nil, nil
+			event := &ObjectLambdaEvent{
+				GetObjectContext: &ObjectLambdaGetObjectContext{OutputRoute: "route", OutputToken: "token"},
+				Configuration:    ObjectLambdaConfiguration{Payload: `{"archive":"s3://bucket/archive.tar"}`},
+				UserRequest: ObjectLambdaUserRequest{
+					URL:     fmt.Sprintf("https://ap-123456789012.s3-object-lambda.us-east-1.amazonaws.com/%s", test.key),
+					Headers: map[string]string{"range": test.rng},
+				},
+			}
+			err := h.Handle(context.Background(), event)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if len(responses) != 1 {
+				t.Fatalf("got %d responses", len(responses))
+			}
+			r := responses[0]
+			if got := r.Header.Get("x-amz-fwd-status"); got != test.status {
+				t.Errorf("status: got %s, want %s", got, test.status)
+			}
+			if r.Header.Get("x-amz-request-route") != "route" || r.Header.Get("x-amz-request-token") != "token" {
+				t.Errorf("not routed: %v", r.Header)
+			}
+			if test.status[0] == '2' && bodies[0] != test.body {
+				t.Errorf("body: got %q, want %q", bodies[0], test.body)
+			}
+			if got := r.Header.Get("x-amz-fwd-header-Content-Range"); got != test.contentRange {
+				t.Errorf("Content-Range: got %q, want %q", got, test.contentRange)
+			}
+		})
+	}
+}
+
+func TestServeLambdaRuntime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan string, 1)
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2018-06-01/runtime/invocation/next":
+			w.Header().Set("Lambda-Runtime-Aws-Request-Id", "req-1")
+			// a HeadObject event, which isn't supported
+			w.Write([]byte(`{"headObjectContext":{},"userRequest":{"url":"https://ap/a.txt"}}`))
+		default:
+			body, _ := io.ReadAll(r.Body)
+			results <- r.URL.Path + " " + string(body)
+			cancel()
+		}
+	}))
+	defer runtime.Close()
+
+	h := NewObjectLambdaHandler(s3.New(s3.Options{Region: "us-east-1"}))
+	err := h.ServeLambdaRuntime(ctx, strings.TrimPrefix(runtime.URL, "http://"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v", err)
+	}
+	if got := <-results; !strings.HasPrefix(got, "/2018-06-01/runtime/invocation/req-1/error ") || !strings.Contains(got, "only GetObject is supported") {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"sync"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const contextKeyPause = contextKey("pause")
+
+// PauseControl pauses the jobs of a context without canceling them, to
+// yield the S3 throughput to other workloads, e.g. during business hours.
+// The requests already sent finish, the next ones that move data wait for
+// Resume.
+type PauseControl struct {
+	mu     sync.Mutex
+	resume chan struct{}
+}
+
+// NewPauseControl returns a PauseControl that isn't paused.
+func NewPauseControl() *PauseControl {
+	return &PauseControl{}
+}
+
+// Pause holds the requests that move data until Resume.
+func (p *PauseControl) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume == nil {
+		p.resume = make(chan struct{})
+	}
+}
+
+// Resume releases the requests waiting since Pause.
+func (p *PauseControl) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume != nil {
+		close(p.resume)
+		p.resume = nil
+	}
+}
+
+// Paused reports whether the jobs are paused.
+func (p *PauseControl) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resume != nil
+}
+
+// wait blocks while the jobs are paused.
+func (p *PauseControl) wait(ctx context.Context) error {
+	p.mu.Lock()
+	resume := p.resume
+	p.mu.Unlock()
+	if resume == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resume:
+		return nil
+	}
+}
+
+// WithPauseControl returns a context whose S3 requests wait while p is
+// paused. The client must be created with WithPause.
+func WithPauseControl(ctx context.Context, p *PauseControl) context.Context {
+	return context.WithValue(ctx, contextKeyPause, p)
+}
+
+// pausedOperations are the requests that move data, the ones held during a
+// pause. Completing or aborting an upload isn't held.
+var pausedOperations = map[string]bool{
+	"GetObject":      true,
+	"PutObject":      true,
+	"CopyObject":     true,
+	"UploadPart":     true,
+	"UploadPartCopy": true,
+}
+
+// WithPause holds the requests that move data while the PauseControl of
+// their context is paused. Use it when creating the client:
+//
+//	svc := s3.NewFromConfig(cfg, s3tar.WithPause)
+func WithPause(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3TarPause",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				p, ok := ctx.Value(contextKeyPause).(*PauseControl)
+				if ok && pausedOperations[awsmiddleware.GetOperationName(ctx)] {
+					if err := p.wait(ctx); err != nil {
+						return middleware.InitializeOutput{}, middleware.Metadata{}, err
+					}
+				}
+				return next.HandleInitialize(ctx, in)
+			}), middleware.After)
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestPause(t *testing.T) {
+	var ops []string
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   preflightHTTPClient{ops: &ops},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	}, WithPause)
+	p := NewPauseControl()
+	ctx := WithPauseControl(context.Background(), p)
+
+	p.Pause()
+	if !p.Paused() {
+		t.Fatal("not paused")
+	}
+	// completing an upload isn't held
+	svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String("bucket"), Key: aws.String("key"), UploadId: aws.String("upload-1")})
+	done := make(chan error)
+	go func() {
+		_, err := svc.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key"), Body: strings.NewReader("data")})
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("PutObject was sent while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.Resume()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ops, ","); got != "AbortMultipartUpload,PUT" {
+		t.Errorf("got %s", got)
+	}
+
+	// a paused request gives up with its context
+	p.Pause()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := svc.PutObject(canceled, &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key"), Body: strings.NewReader("data")}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestJobServerPause(t *testing.T) {
+	s := NewJobServer(&blockingArchiver{}, ServerOptions{})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	for _, c := range []struct {
+		path   string
+		paused bool
+	}{{"/pause", true}, {"/resume", false}} {
+		res, err := http.Post(srv.URL+c.path, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]bool
+		json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || body["paused"] != c.paused || s.PauseControl().Paused() != c.paused {
+			t.Errorf("%s: status %d, %v", c.path, res.StatusCode, body)
+		}
+	}
+}
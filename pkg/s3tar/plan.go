@@ -0,0 +1,256 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Formats of ArchivePlan.Export.
+const (
+	PlanExportStepFunctions = "stepfunctions"
+	PlanExportBatch         = "batch"
+)
+
+// ArchivePlan is a run split in archives of up to a size limit, each built by
+// its own job from its own manifest, e.g. the jobs of an AWS Batch array job.
+type ArchivePlan struct {
+	Region string      `json:"region"`
+	Chunks []PlanChunk `json:"chunks"`
+}
+
+// PlanChunk is an archive of an ArchivePlan.
+type PlanChunk struct {
+	Archive  string `json:"archive"`
+	Manifest string `json:"manifest"`
+	Objects  int    `json:"objects"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// planManifestColumns are the columns of the manifests of a plan, named in
+// their header line.
+var planManifestColumns = []string{ManifestColumnBucket, ManifestColumnKey, ManifestColumnSize, ManifestColumnETag, ManifestColumnVersionId, ManifestColumnLastModified, ManifestColumnOffset, ManifestColumnLength, ManifestColumnXattrs}
+
+// planPrefix is where the plan of the archive key and its manifests are kept.
+func planPrefix(key string) string {
+	return key + ".plan/"
+}
+
+// CreatePlan splits objectList in archives of up to sizeLimit bytes named like
+// the archives of a --size-limit run, and writes the manifest of every
+// archive and the plan under <archive>.plan/ next to s3://bucket/key. It
+// returns the plan and the s3:// url of plan.json.
+func CreatePlan(ctx context.Context, svc *s3.Client, objectList []*S3Obj, bucket, key string, sizeLimit int64, region string) (*ArchivePlan, string, error) {
+	var lists [][]*S3Obj
+	for _, list := range BreakUpList(objectList, sizeLimit) {
+		// the first list is empty when the first object is over the limit
+		if len(list) > 0 {
+			lists = append(lists, list)
+		}
+	}
+	if len(lists) == 0 {
+		return nil, "", fmt.Errorf("no objects to archive")
+	}
+	plan := &ArchivePlan{Region: region}
+	prefix := planPrefix(key)
+	for i, list := range lists {
+		archiveKey := key
+		if len(lists) > 1 {
+			archiveKey = ChunkedArchiveName(key, i)
+		}
+		manifestKey := fmt.Sprintf("%s%05d.csv", prefix, i)
+		data, err := planManifest(list)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := putObject(ctx, svc, bucket, manifestKey, data); err != nil {
+			return nil, "", err
+		}
+		var size int64
+		for _, o := range list {
+			size += *o.Size
+		}
+		plan.Chunks = append(plan.Chunks, PlanChunk{
+			Archive:  fmt.Sprintf("s3://%s/%s", bucket, archiveKey),
+			Manifest: fmt.Sprintf("s3://%s/%s", bucket, manifestKey),
+			Objects:  len(list),
+			Bytes:    size,
+		})
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := putObject(ctx, svc, bucket, prefix+"plan.json", data); err != nil {
+		return nil, "", err
+	}
+	return plan, fmt.Sprintf("s3://%s/%splan.json", bucket, prefix), nil
+}
+
+// planManifest returns the manifest of objectList with a header line.
+func planManifest(objectList []*S3Obj) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(planManifestColumns)
+	for _, o := range objectList {
+		var etag, lastModified, offset, length string
+		if o.ETag != nil {
+			etag = *o.ETag
+		}
+		if o.LastModified != nil {
+			lastModified = o.LastModified.Format(time.RFC3339)
+		}
+		size := *o.Size
+		if o.Slice {
+			// the manifest has the size of the object, it is at least
+			// the end of the slice
+			offset, length = strconv.FormatInt(o.Offset, 10), strconv.FormatInt(size, 10)
+			size += o.Offset
+		}
+		w.Write([]string{o.Bucket, *o.Key, strconv.FormatInt(size, 10), etag, o.VersionId, lastModified, offset, length, encodeXattrs(o.Xattrs)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// LoadPlan reads the plan at the s3:// url planURL.
+func LoadPlan(ctx context.Context, svc *s3.Client, planURL string) (*ArchivePlan, error) {
+	bucket, key := ExtractBucketAndPath(planURL)
+	r, err := getObject(ctx, svc, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	plan := &ArchivePlan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %w", planURL, err)
+	}
+	if len(plan.Chunks) == 0 {
+		return nil, fmt.Errorf("the plan %s has no archives", planURL)
+	}
+	return plan, nil
+}
+
+// Export returns a CloudFormation template that runs the plan at planURL. An
+// AWS Batch job definition builds a chunk per job, picked by the index of the
+// job in an array job. PlanExportStepFunctions adds a state machine that
+// submits a job per chunk, at most maxConcurrency at a time.
+func (p *ArchivePlan) Export(format, planURL string, maxConcurrency int) ([]byte, error) {
+	parameters := map[string]any{
+		"Image":      map[string]any{"Type": "String", "Description": "container image with s3tar in its PATH"},
+		"JobRoleArn": map[string]any{"Type": "String", "Description": "role of the jobs, with access to the source, the archives and the plan"},
+		"Vcpus":      map[string]any{"Type": "String", "Default": "4"},
+		"Memory":     map[string]any{"Type": "String", "Default": "8192", "Description": "memory of a job in MiB"},
+	}
+	resources := map[string]any{
+		"JobDefinition": map[string]any{
+			"Type": "AWS::Batch::JobDefinition",
+			"Properties": map[string]any{
+				"Type": "container",
+				// an attempt that was interrupted resumes from its checkpoint
+				"RetryStrategy": map[string]any{"Attempts": 3},
+				"ContainerProperties": map[string]any{
+					"Image":      map[string]any{"Ref": "Image"},
+					"Command":    []string{"s3tar", "--region", p.Region, "--plan", planURL, "--resume"},
+					"JobRoleArn": map[string]any{"Ref": "JobRoleArn"},
+					"ResourceRequirements": []any{
+						map[string]any{"Type": "VCPU", "Value": map[string]any{"Ref": "Vcpus"}},
+						map[string]any{"Type": "MEMORY", "Value": map[string]any{"Ref": "Memory"}},
+					},
+				},
+			},
+		},
+	}
+	outputs := map[string]any{}
+	description := fmt.Sprintf("s3tar plan of %d archives, %s", len(p.Chunks), planURL)
+
+	switch format {
+	case PlanExportBatch:
+		submit := "aws batch submit-job --job-name s3tar --job-queue <job queue> --job-definition ${JobDefinition}"
+		if len(p.Chunks) > 1 {
+			submit += fmt.Sprintf(" --array-properties size=%d", len(p.Chunks))
+		}
+		outputs["SubmitJob"] = map[string]any{"Value": map[string]any{"Fn::Sub": submit}}
+	case PlanExportStepFunctions:
+		if maxConcurrency < 0 {
+			return nil, fmt.Errorf("the max concurrency should be >= 0")
+		}
+		chunks := make([]string, len(p.Chunks))
+		for i := range chunks {
+			chunks[i] = strconv.Itoa(i)
+		}
+		parameters["JobQueue"] = map[string]any{"Type": "String", "Description": "AWS Batch job queue of the jobs"}
+		parameters["StateMachineRoleArn"] = map[string]any{"Type": "String", "Description": "role of the state machine, allowed to submit and describe the jobs"}
+		resources["StateMachine"] = map[string]any{
+			"Type": "AWS::StepFunctions::StateMachine",
+			"Properties": map[string]any{
+				"RoleArn": map[string]any{"Ref": "StateMachineRoleArn"},
+				"DefinitionSubstitutions": map[string]any{
+					"JobQueue":      map[string]any{"Ref": "JobQueue"},
+					"JobDefinition": map[string]any{"Ref": "JobDefinition"},
+				},
+				"Definition": map[string]any{
+					"Comment": description,
+					"StartAt": "Chunks",
+					"States": map[string]any{
+						"Chunks": map[string]any{
+							"Type":   "Pass",
+							"Result": map[string]any{"chunks": chunks},
+							"Next":   "Archives",
+						},
+						"Archives": map[string]any{
+							"Type":           "Map",
+							"ItemsPath":      "$.chunks",
+							"MaxConcurrency": maxConcurrency,
+							"ItemProcessor": map[string]any{
+								"ProcessorConfig": map[string]any{"Mode": "INLINE"},
+								"StartAt":         "Archive",
+								"States": map[string]any{
+									"Archive": map[string]any{
+										"Type":     "Task",
+										"Resource": "arn:aws:states:::batch:submitJob.sync",
+										"Parameters": map[string]any{
+											"JobName":       "s3tar",
+											"JobQueue":      "${JobQueue}",
+											"JobDefinition": "${JobDefinition}",
+											"ContainerOverrides": map[string]any{
+												"Environment": []any{map[string]any{"Name": "S3TAR_PLAN_CHUNK", "Value.$": "$"}},
+											},
+										},
+										"End": true,
+									},
+								},
+							},
+							"End": true,
+						},
+					},
+				},
+			},
+		}
+		outputs["StartExecution"] = map[string]any{"Value": map[string]any{"Fn::Sub": "aws stepfunctions start-execution --state-machine-arn ${StateMachine}"}}
+	default:
+		return nil, fmt.Errorf("unknown export format %q, use %s or %s", format, PlanExportStepFunctions, PlanExportBatch)
+	}
+
+	return json.MarshalIndent(map[string]any{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Description":              description,
+		"Parameters":               parameters,
+		"Resources":                resources,
+		"Outputs":                  outputs,
+	}, "", "  ")
+}
@@ -0,0 +1,156 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// putHTTPClient keeps the body of every PutObject by path.
+type putHTTPClient struct {
+	objects map[string][]byte
+}
+
+func (c putHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	if r.Method == http.MethodPut {
+		c.objects[r.URL.Path], _ = io.ReadAll(r.Body)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+func TestCreatePlan(t *testing.T) {
+	modified := time.Date(2024, 1, 31, 2, 0, 0, 0, time.UTC)
+	a := NewS3ObjOptions(WithBucketAndKey("src", "logs/a.log"), WithSize(3*1024*1024), WithETag(`"etag-a"`))
+	a.LastModified = &modified
+	b := NewS3ObjOptions(WithBucketAndKey("src", "logs/b,1.log"), WithSize(100), WithETag(`"etag-b"`))
+	b.VersionId = "v1"
+	c := NewS3ObjOptions(WithBucketAndKey("src", "logs/c.log"), WithSize(1024))
+	c.Slice, c.Offset, c.Name = true, 512, "logs/c.log.512-1535"
+
+	objects := map[string][]byte{}
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   putHTTPClient{objects: objects},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	// a.log doesn't fit with the others
+	plan, planURL, err := CreatePlan(context.Background(), svc, []*S3Obj{a, b, c}, "dst", "archives/logs.tar", 3*1024*1024, "us-west-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planURL != "s3://dst/archives/logs.tar.plan/plan.json" || len(plan.Chunks) != 2 {
+		t.Fatalf("got %s %+v", planURL, plan)
+	}
+	want := PlanChunk{Archive: "s3://dst/archives/logs.00001.tar", Manifest: "s3://dst/archives/logs.tar.plan/00001.csv", Objects: 2, Bytes: 1124}
+	if plan.Chunks[1] != want {
+		t.Errorf("got %+v, want %+v", plan.Chunks[1], want)
+	}
+	var written ArchivePlan
+	if err := json.Unmarshal(objects["/dst/archives/logs.tar.plan/plan.json"], &written); err != nil || len(written.Chunks) != 2 || written.Region != "us-west-2" {
+		t.Errorf("got %+v, %v", written, err)
+	}
+
+	// the manifest of a chunk reads back the objects of the chunk
+	manifest, ok := objects["/dst/archives/logs.tar.plan/00001.csv"]
+	if !ok {
+		t.Fatalf("no manifest in %v", objects)
+	}
+	list, _, err := parseManifest(context.Background(), bytes.NewReader(manifest), ManifestOptions{SkipHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("got %d objects", len(list))
+	}
+	if *list[0].Key != "logs/b,1.log" || list[0].VersionId != "v1" || *list[0].ETag != `"etag-b"` || *list[0].Size != 100 {
+		t.Errorf("got %+v", list[0])
+	}
+	if !list[1].Slice || list[1].Offset != 512 || *list[1].Size != 1024 || list[1].Name != c.Name {
+		t.Errorf("got %+v", list[1])
+	}
+	first, _, err := parseManifest(context.Background(), bytes.NewReader(objects["/dst/archives/logs.tar.plan/00000.csv"]), ManifestOptions{SkipHeader: true})
+	if err != nil || len(first) != 1 || !first[0].LastModified.Equal(modified) {
+		t.Errorf("got %+v, %v", first, err)
+	}
+}
+
+func TestExportPlan(t *testing.T) {
+	plan := &ArchivePlan{Region: "us-west-2", Chunks: make([]PlanChunk, 3)}
+	planURL := "s3://dst/logs.tar.plan/plan.json"
+
+	var batch struct {
+		Resources map[string]struct {
+			Type       string
+			Properties struct {
+				ContainerProperties struct{ Command []string }
+			}
+		}
+		Outputs map[string]struct{ Value map[string]string }
+	}
+	data, err := plan.Export(PlanExportBatch, planURL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		t.Fatal(err)
+	}
+	command := strings.Join(batch.Resources["JobDefinition"].Properties.ContainerProperties.Command, " ")
+	if command != "s3tar --region us-west-2 --plan "+planURL+" --resume" {
+		t.Errorf("got %s", command)
+	}
+	if submit := batch.Outputs["SubmitJob"].Value["Fn::Sub"]; !strings.HasSuffix(submit, "--array-properties size=3") {
+		t.Errorf("got %s", submit)
+	}
+	if _, ok := batch.Resources["StateMachine"]; ok {
+		t.Error("got a state machine")
+	}
+
+	var sfn struct {
+		Resources map[string]struct {
+			Type       string
+			Properties struct {
+				Definition struct {
+					States struct {
+						Chunks struct {
+							Result struct{ Chunks []string }
+						}
+						Archives struct {
+							MaxConcurrency int
+						}
+					}
+				}
+			}
+		}
+	}
+	data, err = plan.Export(PlanExportStepFunctions, planURL, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &sfn); err != nil {
+		t.Fatal(err)
+	}
+	states := sfn.Resources["StateMachine"].Properties.Definition.States
+	if strings.Join(states.Chunks.Result.Chunks, ",") != "0,1,2" || states.Archives.MaxConcurrency != 2 {
+		t.Errorf("got %+v", states)
+	}
+	if sfn.Resources["JobDefinition"].Type != "AWS::Batch::JobDefinition" {
+		t.Errorf("got %+v", sfn.Resources)
+	}
+
+	if _, err := plan.Export("cloudformation", planURL, 0); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrPreflight is wrapped by the error returned when the pre-flight checks
+// fail. The error lists every problem found.
+var ErrPreflight = errors.New("pre-flight checks failed")
+
+// Preflight checks that the archive described by opts can be created before
+// anything is copied: the destination bucket exists and accepts multipart
+// uploads, tags and the KMS key, and the source can be read. It sends a few
+// requests and writes no objects, the multipart upload it starts is
+// aborted. With CheckPermissions it also writes and deletes an empty object
+// and copies a byte of the source to a multipart upload, see dryWrite. In a
+// versioned bucket these leave versions and delete markers behind.
+// With CheckNetwork or RequireGatewayEndpoint it checks the way to S3 first,
+// see InspectNetwork.
+func Preflight(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	var problems []error
+	if opts.CheckNetwork || opts.RequireGatewayEndpoint {
+		if err := checkNetwork(ctx, svc, opts); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	var sample *S3Obj
+	switch {
+	case opts.SrcManifest != "":
+		if strings.HasPrefix(opts.SrcManifest, "s3://") {
+			bucket, key := ExtractBucketAndPath(opts.SrcManifest)
+			sample = NewS3ObjOptions(WithBucketAndKey(bucket, key))
+		}
+	case opts.SrcBucket != "":
+		output, err := svc.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(opts.SrcBucket),
+			Prefix:  aws.String(opts.SrcPrefix),
+			MaxKeys: aws.Int32(1),
+		})
+		if err != nil {
+			problems = append(problems, preflightError("list", "s3://"+opts.SrcBucket+"/"+opts.SrcPrefix, "s3:ListBucket", err))
+		} else if len(output.Contents) > 0 {
+			sample = &S3Obj{Object: output.Contents[0], Bucket: opts.SrcBucket}
+		}
+	}
+	return preflight(ctx, svc, opts, sample, problems)
+}
+
+// preflight runs the destination checks and reads sample from the source.
+func preflight(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, sample *S3Obj, problems []error) error {
+	var sampleSize int64 = -1
+	if sample != nil {
+		input := &s3.HeadObjectInput{Bucket: aws.String(sample.Bucket), Key: sample.Key}
+		if sample.VersionId != "" {
+			input.VersionId = aws.String(sample.VersionId)
+		}
+		if output, err := svc.HeadObject(ctx, input); err != nil {
+			problems = append(problems, preflightError("read", "s3://"+sample.Bucket+"/"+*sample.Key, "s3:GetObject", err))
+		} else {
+			sampleSize = aws.ToInt64(output.ContentLength)
+		}
+	}
+
+	dst := "s3://" + opts.DstBucket
+	if _, err := svc.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(opts.DstBucket)}); err != nil {
+		if apiErrorCode(err) == "NotFound" || apiErrorCode(err) == "NoSuchBucket" {
+			problems = append(problems, fmt.Errorf("destination bucket %s does not exist", dst))
+			return joinPreflight(problems)
+		}
+		problems = append(problems, preflightError("access", dst, "s3:ListBucket", err))
+	}
+
+	key := opts.DstKey + ".s3tar-preflight"
+	put := &s3.PutObjectInput{
+		Bucket:        aws.String(opts.DstBucket),
+		Key:           aws.String(key),
+		Body:          strings.NewReader(""),
+		ContentLength: aws.Int64(0),
+	}
+	mpu := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(opts.DstBucket),
+		Key:    aws.String(key),
+	}
+	permission := "s3:PutObject"
+	if len(opts.ObjectTags.TagSet) > 0 {
+		put.Tagging = aws.String(TagsToUrlEncodedString(opts.ObjectTags))
+		mpu.Tagging = put.Tagging
+		permission += " and s3:PutObjectTagging"
+	}
+	if opts.KMSKeyID != "" {
+		cfg := newJobConfig(opts)
+		cfg.encryptPut(put)
+		cfg.encryptUpload(mpu)
+		permission += fmt.Sprintf(", and kms:GenerateDataKey and kms:Decrypt on %s", opts.KMSKeyID)
+	}
+
+	if output, err := svc.CreateMultipartUpload(ctx, mpu); err != nil {
+		problems = append(problems, preflightError("start a multipart upload in", dst, permission, err))
+	} else {
+		_, err := svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(opts.DstBucket),
+			Key:      aws.String(key),
+			UploadId: output.UploadId,
+		})
+		if err != nil {
+			Warnf(ctx, "unable to abort the pre-flight upload %s: %s", *output.UploadId, err.Error())
+		}
+	}
+
+	if opts.CheckPermissions {
+		if _, err := svc.PutObject(ctx, put); err != nil {
+			problems = append(problems, preflightError("write to", dst, permission, err))
+		} else if _, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(opts.DstBucket), Key: aws.String(key)}); err != nil {
+			if opts.ConcatInMemory {
+				Warnf(ctx, "unable to delete s3://%s/%s: %s", opts.DstBucket, key, err.Error())
+			} else {
+				// the intermediate objects can't be cleaned up
+				problems = append(problems, preflightError("delete from", dst, "s3:DeleteObject", err))
+			}
+		}
+		if sampleSize < 0 {
+			problems = append(problems, fmt.Errorf("unable to check the permissions to copy: no source object could be read"))
+		} else if err := dryWrite(ctx, svc, opts, sample, sampleSize, mpu, permission); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return joinPreflight(problems)
+}
+
+// dryWrite copies the first byte of sample into a multipart upload under the
+// parts prefix, completes it and deletes it: the requests the archive is
+// built with, so a policy that only denies UploadPartCopy or
+// CompleteMultipartUpload fails now and not hours into the job.
+func dryWrite(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, sample *S3Obj, size int64, mpu *s3.CreateMultipartUploadInput, permission string) error {
+	key := JoinKey(partsKey(opts), "s3tar-permissions-check")
+	dst := "s3://" + opts.DstBucket + "/" + key
+	input := *mpu
+	input.Key = aws.String(key)
+	output, err := svc.CreateMultipartUpload(ctx, &input)
+	if err != nil {
+		return preflightError("start a multipart upload in", dst, permission, err)
+	}
+	abort := func() {
+		_, err := svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(opts.DstBucket),
+			Key:      aws.String(key),
+			UploadId: output.UploadId,
+		})
+		if err != nil {
+			Warnf(ctx, "unable to abort the pre-flight upload %s: %s", *output.UploadId, err.Error())
+		}
+	}
+
+	copyInput := &s3.UploadPartCopyInput{
+		Bucket:     aws.String(opts.DstBucket),
+		Key:        aws.String(key),
+		UploadId:   output.UploadId,
+		PartNumber: aws.Int32(1),
+		CopySource: aws.String(copySource(sample)),
+	}
+	if size > 0 {
+		// an empty object has no range to copy
+		copyInput.CopySourceRange = aws.String("bytes=0-0")
+	}
+	part, err := svc.UploadPartCopy(ctx, copyInput)
+	if err != nil {
+		abort()
+		src := "s3://" + sample.Bucket + "/" + *sample.Key
+		return preflightError("copy "+src+" to", dst, "s3:GetObject on the source and "+permission, err)
+	}
+	var etag *string
+	if part.CopyPartResult != nil {
+		etag = part.CopyPartResult.ETag
+	}
+	_, err = svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(opts.DstBucket),
+		Key:      aws.String(key),
+		UploadId: output.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: []types.CompletedPart{{ETag: etag, PartNumber: aws.Int32(1)}},
+		},
+	})
+	if err != nil {
+		abort()
+		return preflightError("complete a multipart upload in", dst, permission, err)
+	}
+	if _, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(opts.DstBucket), Key: aws.String(key)}); err != nil {
+		return preflightError("delete", dst, "s3:DeleteObject", err)
+	}
+	return nil
+}
+
+// checkNetwork fails when the S3 endpoint can't be reached, and warns when
+// the requests go through a NAT. With opts.RequireGatewayEndpoint it fails
+// unless they go through an S3 gateway endpoint.
+func checkNetwork(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	report, err := InspectNetwork(ctx, svc, opts)
+	if err != nil {
+		return fmt.Errorf("%w, check the DNS, the route tables and the security groups", err)
+	}
+	route := report.Route
+	if report.Via != "" {
+		route += " " + report.Via
+	}
+	Infof(ctx, "%s resolves to %s, route: %s", report.Endpoint, strings.Join(report.Addresses, ", "), route)
+	switch {
+	case report.Route == RouteGatewayEndpoint:
+	case opts.RequireGatewayEndpoint:
+		return fmt.Errorf("the requests to %s go through %s, not an S3 gateway endpoint", report.Endpoint, route)
+	case report.Route == RouteNAT:
+		Warnf(ctx, "the requests to %s go through %s, which bills every GB processed: add an S3 gateway endpoint to the route table", report.Endpoint, route)
+	}
+	return nil
+}
+
+func joinPreflight(problems []error) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w:\n%w", ErrPreflight, errors.Join(problems...))
+}
+
+// preflightError explains a failed request with the permission it needs.
+func preflightError(action, resource, permission string, err error) error {
+	code := apiErrorCode(err)
+	switch {
+	case code == "AccessDenied" || code == "Forbidden":
+		return fmt.Errorf("unable to %s %s: access denied, check %s", action, resource, permission)
+	case strings.HasPrefix(code, "KMS."):
+		return fmt.Errorf("unable to %s %s: the KMS key can't be used (%s), check %s", action, resource, code, permission)
+	case code == "NoSuchKey" || code == "NotFound":
+		return fmt.Errorf("unable to %s %s: not found", action, resource)
+	case code == "NoSuchBucket":
+		return fmt.Errorf("unable to %s %s: the bucket does not exist", action, resource)
+	}
+	return fmt.Errorf("unable to %s %s: %w", action, resource, err)
+}
+
+func apiErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type preflightFailure struct {
+	status int
+	code   string
+}
+
+// preflightHTTPClient answers every request with 200, except the operations
+// in fail.
+type preflightHTTPClient struct {
+	fail map[string]preflightFailure
+	// ops records the operations sent, when set
+	ops *[]string
+	// bucketKeys records the operations sent with an S3 Bucket Key, when set
+	bucketKeys *[]string
+}
+
+func (c preflightHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	op := r.Method
+	if r.URL.Query().Has("uploads") {
+		op = "CreateMultipartUpload"
+	} else if r.URL.Query().Has("uploadId") {
+		switch r.Method {
+		case http.MethodPut:
+			op = "UploadPartCopy"
+		case http.MethodPost:
+			op = "CompleteMultipartUpload"
+		default:
+			op = "AbortMultipartUpload"
+		}
+	} else if r.Method == http.MethodHead && strings.Count(strings.Trim(r.URL.Path, "/"), "/") == 0 {
+		op = "HeadBucket"
+	}
+	if c.ops != nil {
+		*c.ops = append(*c.ops, op)
+	}
+	if c.bucketKeys != nil && r.Header.Get("X-Amz-Server-Side-Encryption-Bucket-Key-Enabled") == "true" {
+		*c.bucketKeys = append(*c.bucketKeys, op)
+	}
+	status, body := http.StatusOK, ""
+	if f, ok := c.fail[op]; ok {
+		status = f.status
+		if r.Method != http.MethodHead {
+			body = "<Error><Code>" + f.code + "</Code><Message>failed</Message></Error>"
+		}
+	} else if op == "CreateMultipartUpload" {
+		body = "<InitiateMultipartUploadResult><Bucket>dst-bucket</Bucket><Key>archive.tar.s3tar-preflight</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>"
+	} else if op == "UploadPartCopy" {
+		body = "<CopyPartResult><ETag>\"etag\"</ETag></CopyPartResult>"
+	} else if op == "CompleteMultipartUpload" {
+		body = "<CompleteMultipartUploadResult><Bucket>dst-bucket</Bucket><Key>archive.tar.parts/s3tar-permissions-check</Key><ETag>\"etag-1\"</ETag></CompleteMultipartUploadResult>"
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+func TestPreflight(t *testing.T) {
+	ctx := context.Background()
+	opts := &S3TarS3Options{DstBucket: "dst-bucket", DstKey: "archive.tar"}
+	sample := NewS3ObjOptions(WithBucketAndKey("src-bucket", "prefix/file.txt"))
+	newClient := func(fail map[string]preflightFailure) *s3.Client {
+		return s3.New(s3.Options{
+			Region:       "us-east-1",
+			Credentials:  aws.AnonymousCredentials{},
+			HTTPClient:   preflightHTTPClient{fail: fail},
+			Retryer:      aws.NopRetryer{},
+			UsePathStyle: true,
+		})
+	}
+
+	var ops []string
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   preflightHTTPClient{ops: &ops},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	if err := preflight(ctx, svc, opts, sample, nil); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	// nothing is written, a versioned bucket keeps no version of the checks
+	if got := strings.Join(ops, ","); got != "HEAD,HeadBucket,CreateMultipartUpload,AbortMultipartUpload" {
+		t.Errorf("unexpected requests %s", got)
+	}
+
+	err := preflight(ctx, newClient(map[string]preflightFailure{
+		"HEAD":                  {http.StatusForbidden, "Forbidden"},
+		"CreateMultipartUpload": {http.StatusForbidden, "AccessDenied"},
+	}), opts, sample, nil)
+	if !errors.Is(err, ErrPreflight) {
+		t.Fatalf("expected ErrPreflight, got %v", err)
+	}
+	for _, want := range []string{"read s3://src-bucket/prefix/file.txt", "s3:GetObject", "start a multipart upload in s3://dst-bucket", "s3:PutObject"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q is missing from %s", want, err)
+		}
+	}
+
+	err = preflight(ctx, newClient(map[string]preflightFailure{"HeadBucket": {http.StatusNotFound, "NotFound"}}), opts, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "destination bucket s3://dst-bucket does not exist") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestPreflightCheckPermissions(t *testing.T) {
+	ctx := context.Background()
+	opts := &S3TarS3Options{DstBucket: "dst-bucket", DstKey: "archive.tar", CheckPermissions: true}
+	sample := NewS3ObjOptions(WithBucketAndKey("src-bucket", "prefix/file.txt"))
+	newClient := func(fail map[string]preflightFailure, ops *[]string) *s3.Client {
+		return s3.New(s3.Options{
+			Region:       "us-east-1",
+			Credentials:  aws.AnonymousCredentials{},
+			HTTPClient:   preflightHTTPClient{fail: fail, ops: ops},
+			Retryer:      aws.NopRetryer{},
+			UsePathStyle: true,
+		})
+	}
+
+	var ops []string
+	if err := preflight(ctx, newClient(nil, &ops), opts, sample, nil); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	want := "PUT,DELETE,CreateMultipartUpload,UploadPartCopy,CompleteMultipartUpload,DELETE"
+	if got := strings.Join(ops, ","); !strings.HasSuffix(got, want) {
+		t.Errorf("got %s, want the dry write to end with %s", got, want)
+	}
+
+	ops = nil
+	err := preflight(ctx, newClient(map[string]preflightFailure{"UploadPartCopy": {http.StatusForbidden, "AccessDenied"}}, &ops), opts, sample, nil)
+	if !errors.Is(err, ErrPreflight) || !strings.Contains(err.Error(), "copy s3://src-bucket/prefix/file.txt to s3://dst-bucket/archive.tar.parts/s3tar-permissions-check") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if ops[len(ops)-1] != "AbortMultipartUpload" {
+		t.Errorf("the upload wasn't aborted: %v", ops)
+	}
+
+	err = preflight(ctx, newClient(nil, nil), opts, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "no source object") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestPreflightBucketKey(t *testing.T) {
+	ctx := context.Background()
+	opts := &S3TarS3Options{DstBucket: "dst-bucket", DstKey: "archive.tar", KMSKeyID: "alias/archives", SSEAlgo: "aws:kms", BucketKeyEnabled: true}
+	var bucketKeys []string
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   preflightHTTPClient{bucketKeys: &bucketKeys},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	if err := preflight(ctx, svc, opts, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(bucketKeys, ","); got != "CreateMultipartUpload" {
+		t.Errorf("got the bucket key on %s", got)
+	}
+
+	a := &ArchiveClient{}
+	if _, err := a.checkArgs(&S3TarS3Options{SrcBucket: "src-bucket", DstBucket: "dst-bucket", DstKey: "archive.tar", BucketKeyEnabled: true}, nil); err == nil {
+		t.Errorf("a bucket key without a KMS key should be refused")
+	}
+	if _, err := a.checkArgs(&S3TarS3Options{SrcBucket: "src-bucket", DstBucket: "dst-bucket", DstKey: "archive.tar", BucketKeyEnabled: true}, []func(*S3TarS3Options){WithKMS("alias/archives", "aws:kms")}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// MaxPresignExpiry is the longest a pre-signed URL can be valid with SigV4.
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// PresignedArchive is a pre-signed GET URL of an archive, for consumers
+// without AWS credentials.
+type PresignedArchive struct {
+	Archive string    `json:"archive"`
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+	// TOCRange is the Range header that reads the TOC of the archive with
+	// URL, e.g. bytes=1536-2047. It is empty when the archive has no TOC.
+	TOCRange string `json:"toc_range,omitempty"`
+}
+
+// presignMu keeps the lines of archives built concurrently from interleaving.
+var presignMu sync.Mutex
+
+// PresignArchive returns a pre-signed GET URL of s3://bucket/key valid for
+// expires. The URL stops working when the credentials that signed it expire,
+// e.g. the session of an assumed role, even if expires is longer.
+func PresignArchive(ctx context.Context, svc *s3.Client, bucket, key string, expires time.Duration) (*PresignedArchive, error) {
+	if expires <= 0 || expires > MaxPresignExpiry {
+		return nil, fmt.Errorf("the expiry of a pre-signed URL must be between 1s and %s", MaxPresignExpiry)
+	}
+	req, err := s3.NewPresignClient(svc).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return nil, err
+	}
+	p := &PresignedArchive{
+		Archive: fmt.Sprintf("s3://%s/%s", bucket, key),
+		URL:     req.URL,
+		Expires: time.Now().Add(expires).UTC().Truncate(time.Second),
+	}
+	// archives built with a TOC start with it
+	if hdr, offset, err := extractTarHeader(ctx, svc, bucket, key); err == nil && hdr.Name == "toc.csv" && hdr.Size > 0 {
+		p.TOCRange = fmt.Sprintf("bytes=%d-%d", offset, offset+hdr.Size-1)
+	}
+	return p, nil
+}
+
+// presignCreated writes the pre-signed URL of the archive to opts.Presigned.
+func presignCreated(ctx context.Context, svc Backend, opts *S3TarS3Options) {
+	if opts.Presigned == nil {
+		return
+	}
+	client, ok := svc.(*s3.Client)
+	if !ok {
+		Warnf(ctx, "pre-signed URLs are only supported with Amazon S3")
+		return
+	}
+	p, err := PresignArchive(ctx, client, opts.DstBucket, opts.DstKey, opts.PresignExpiry)
+	if err != nil {
+		Warnf(ctx, "unable to pre-sign s3://%s/%s: %s", opts.DstBucket, opts.DstKey, err.Error())
+		return
+	}
+	line, err := json.Marshal(p)
+	if err != nil {
+		Warnf(ctx, "unable to pre-sign s3://%s/%s: %s", opts.DstBucket, opts.DstKey, err.Error())
+		return
+	}
+	presignMu.Lock()
+	defer presignMu.Unlock()
+	if _, err := opts.Presigned.Write(append(line, '\n')); err != nil {
+		Warnf(ctx, "unable to write the pre-signed URL: %s", err.Error())
+	}
+}
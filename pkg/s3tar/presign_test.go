@@ -0,0 +1,93 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// rangeHTTPClient serves the ranges of archive to GetObject.
+type rangeHTTPClient struct {
+	archive []byte
+}
+
+func (c rangeHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+	if end >= len(c.archive) {
+		end = len(c.archive) - 1
+	}
+	return &http.Response{
+		StatusCode: http.StatusPartialContent,
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(c.archive[start : end+1])),
+		Request:    r,
+	}, nil
+}
+
+func TestPresignArchive(t *testing.T) {
+	toc := []byte("a.txt,3072,5,\n")
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "toc.csv", Size: int64(len(toc)), Mode: 0600, Format: tar.FormatPAX, PAXRecords: map[string]string{"comment": "s3tar"}})
+	tw.Write(toc)
+	tw.Close()
+
+	svc := s3.New(s3.Options{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+		}),
+		HTTPClient:   rangeHTTPClient{archive: buf.Bytes()},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	}, WithJobUserAgent, WithRequestMetrics, WithPause, WithTuning)
+
+	var out bytes.Buffer
+	opts := &S3TarS3Options{DstBucket: "bucket", DstKey: "archive.tar", Presigned: &out, PresignExpiry: 2 * time.Hour}
+	presignCreated(context.Background(), svc, opts)
+
+	var p PresignedArchive
+	if err := json.Unmarshal(out.Bytes(), &p); err != nil {
+		t.Fatalf("%s: %v", out.String(), err)
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Archive != "s3://bucket/archive.tar" || !strings.HasSuffix(u.Path, "/bucket/archive.tar") {
+		t.Errorf("got %+v", p)
+	}
+	if got := u.Query().Get("X-Amz-Expires"); got != "7200" {
+		t.Errorf("X-Amz-Expires: got %s", got)
+	}
+	if u.Query().Get("X-Amz-Signature") == "" {
+		t.Error("the URL is not signed")
+	}
+	// the PAX header of the TOC is 1536 bytes
+	if want := fmt.Sprintf("bytes=1536-%d", 1536+len(toc)-1); p.TOCRange != want {
+		t.Errorf("TOCRange: got %s, want %s", p.TOCRange, want)
+	}
+
+	for _, d := range []time.Duration{0, MaxPresignExpiry + time.Second} {
+		if _, err := PresignArchive(context.Background(), svc, "bucket", "key", d); err == nil {
+			t.Errorf("%s: expected an error", d)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// reportMaxEntries is the number of entries listed in an HTML report, a
+// page of millions of rows doesn't open in a browser.
+const reportMaxEntries = 100000
+
+// ReportKey is the key of the HTML report of the archive key, see
+// WriteHTMLReport.
+func ReportKey(key string) string {
+	return key + ".html"
+}
+
+type reportData struct {
+	Archive   string
+	Created   string
+	Entries   TOC
+	Total     int
+	TotalSize int64
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Archive}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; }
+td.n { text-align: right; font-family: monospace; }
+input { width: 30em; padding: 0.4em; margin-bottom: 1em; }
+</style>
+</head>
+<body>
+<h1>{{.Archive}}</h1>
+<p>{{.Total}} entries, {{bytes .TotalSize}}. Report created {{.Created}}.{{if lt (len .Entries) .Total}} The first {{len .Entries}} entries are listed, the TOC of the archive lists them all.{{end}}</p>
+<input id="search" type="search" placeholder="Search the entries" autofocus>
+<table>
+<thead><tr><th>Name</th><th>Size</th><th>Offset</th></tr></thead>
+<tbody id="entries">
+{{range .Entries}}<tr><td>{{.Filename}}</td><td class="n" title="{{.Size}} bytes">{{bytes .Size}}</td><td class="n">{{.Start}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+document.getElementById("search").addEventListener("input", function (e) {
+  var q = e.target.value.toLowerCase();
+  var rows = document.getElementById("entries").rows;
+  for (var i = 0; i < rows.length; i++) {
+    rows[i].style.display = rows[i].cells[0].textContent.toLowerCase().indexOf(q) < 0 ? "none" : "";
+  }
+});
+</script>
+</body>
+</html>
+`))
+
+// renderHTMLReport returns the HTML report of the entries of archive.
+func renderHTMLReport(archive string, toc TOC) ([]byte, error) {
+	data := reportData{
+		Archive: archive,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Entries: toc,
+		Total:   len(toc),
+	}
+	for _, e := range toc {
+		data.TotalSize += e.Size
+	}
+	if len(toc) > reportMaxEntries {
+		data.Entries = toc[:reportMaxEntries]
+	}
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteHTMLReport writes a static HTML page listing the entries of the
+// archive s3://bucket/key, their size and offset, with a search box, to
+// ReportKey(key). It lets people without the CLI browse an archive from the
+// console or a website. The entries are read from the TOC, or from the tar
+// headers of an archive without one.
+func WriteHTMLReport(ctx context.Context, svc Backend, bucket, key string) error {
+	toc, err := ReadTOC(ctx, svc, bucket, key)
+	if client, ok := svc.(*s3.Client); ok && errors.Is(err, ErrNoTOC) {
+		toc, err = ScanTar(ctx, client, bucket, key)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the entries of s3://%s/%s: %w", bucket, key, err)
+	}
+	report, err := renderHTMLReport(fmt.Sprintf("s3://%s/%s", bucket, key), toc)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(ReportKey(key)),
+		Body:          bytes.NewReader(report),
+		ContentLength: aws.Int64(int64(len(report))),
+		ContentType:   aws.String("text/html; charset=utf-8"),
+	}
+	jobConfigFromContext(ctx).encryptPut(input)
+	if _, err := svc.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("unable to write s3://%s/%s: %w", bucket, ReportKey(key), err)
+	}
+	Infof(ctx, "report: s3://%s/%s", bucket, ReportKey(key))
+	return nil
+}
+
+// reportCreated writes the HTML report of the archive when opts.HTMLReport
+// is set. The archive is complete, a missing report doesn't fail it.
+func reportCreated(ctx context.Context, svc Backend, opts *S3TarS3Options) {
+	if !opts.HTMLReport {
+		return
+	}
+	if err := WriteHTMLReport(ctx, svc, opts.DstBucket, opts.DstKey); err != nil {
+		Warnf(ctx, "%s", err.Error())
+	}
+}
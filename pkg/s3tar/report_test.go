@@ -0,0 +1,87 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestRenderHTMLReport(t *testing.T) {
+	toc := TOC{
+		{Filename: "src/a.txt", Start: 1536, Size: 10},
+		{Filename: "src/b.bin", Start: 2560, Size: 70000},
+		// the names are escaped, the TOC of another tool may have any
+		{Filename: "<script>alert(1)</script>.txt", Start: 73216, Size: 0},
+	}
+	data, err := renderHTMLReport("s3://bucket/archive.tar", toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+	for _, want := range []string{"s3://bucket/archive.tar", "<td>src/a.txt</td>", "68.3594 KiB", `id="search"`, "&lt;script&gt;alert(1)&lt;/script&gt;.txt"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("the report has no %q", want)
+		}
+	}
+	if strings.Contains(report, "<script>alert") {
+		t.Errorf("the entry name isn't escaped")
+	}
+
+	// a page of millions of rows doesn't open
+	toc = nil
+	for i := 0; i < reportMaxEntries+10; i++ {
+		toc = append(toc, &FileMetadata{Filename: fmt.Sprintf("%d.txt", i)})
+	}
+	data, err = renderHTMLReport("s3://bucket/archive.tar", toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Count(string(data), "<tr><td>"); rows != reportMaxEntries {
+		t.Errorf("the report lists %d entries, want %d", rows, reportMaxEntries)
+	}
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBackend()
+	m.MinPartSize = 64 * 1024
+	var list []*S3Obj
+	for key, data := range map[string]string{"src/a.txt": "first file", "src/b.bin": strings.Repeat("b", 70000)} {
+		m.Put("bucket", key, []byte(data))
+		o := NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(int64(len(data))), WithETag("etag"))
+		o.LastModified = aws.Time(time.Now())
+		list = append(list, o)
+	}
+	opts := &S3TarS3Options{
+		SrcBucket:  "bucket",
+		DstBucket:  "bucket",
+		DstKey:     "archives/archive.tar",
+		DstPrefix:  "archives/",
+		Region:     "us-east-1",
+		Threads:    2,
+		PadSize:    64 * 1024,
+		HTMLReport: true,
+	}
+	if err := CreateFromListWithBackend(ctx, m, list, opts); err != nil {
+		t.Fatal(err)
+	}
+	report := string(m.Get("bucket", ReportKey(opts.DstKey)))
+	for _, want := range []string{"s3://bucket/archives/archive.tar", "<td>src/a.txt</td>", "<td>src/b.bin</td>", `id="search"`} {
+		if !strings.Contains(report, want) {
+			t.Errorf("the report has no %q", want)
+		}
+	}
+
+	m.Put("bucket", "plain.bin", []byte("not a tar"))
+	if err := WriteHTMLReport(ctx, m, "bucket", "plain.bin"); !errors.Is(err, ErrNoTOC) {
+		t.Errorf("WriteHTMLReport() of an archive without a TOC = %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrNotInIndex is the error of the keys missing from the master index.
+var ErrNotInIndex = errors.New("not in the master index")
+
+// RestoreResult is the outcome of restoring a single key.
+type RestoreResult struct {
+	Key string
+	// Archive is the s3:// url of the archive the key was copied from.
+	Archive string
+	// Destination is the s3:// url the key was copied to.
+	Destination string
+	Err         error
+}
+
+// indexEntry is a row of the master index.
+type indexEntry struct {
+	archive     string
+	start, size int64
+	sha256      string
+}
+
+// LoadKeys reads the keys to restore, one per line, from a local file or an
+// object in Amazon S3.
+func LoadKeys(ctx context.Context, svc *s3.Client, path string) ([]string, error) {
+	r, err := loadFile(ctx, svc, path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		key := strings.TrimSuffix(scanner.Text(), "\r")
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// Restore copies keys out of the archives listed in the master index at
+// indexPath to opts.DstBucket under opts.DstPrefix. Every key is copied with
+// ranged server-side copies of its archive, see MasterIndex. The index is read
+// once and only the rows of the requested keys are kept. The error is only
+// set when the index can't be read, the outcome of every key is in the
+// results.
+func Restore(ctx context.Context, svc *s3.Client, indexPath string, keys []string, opts *S3TarS3Options) ([]RestoreResult, error) {
+	wanted := make(map[string]*indexEntry, len(keys))
+	for _, key := range keys {
+		wanted[key] = nil
+	}
+	r, err := loadFile(ctx, svc, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if err := readIndex(r, wanted); err != nil {
+		return nil, fmt.Errorf("unable to read the master index %s: %w", indexPath, err)
+	}
+
+	threads := opts.Threads
+	if threads < 1 {
+		threads = 100
+	}
+	results := make([]RestoreResult, len(keys))
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for i, key := range keys {
+		i, key := i, key
+		results[i].Key = key
+		entry := wanted[key]
+		if entry == nil {
+			results[i].Err = ErrNotInIndex
+			continue
+		}
+		dstKey, err := extractKey(opts.DstPrefix, key)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Archive = entry.archive
+		results[i].Destination = fmt.Sprintf("s3://%s/%s", opts.DstBucket, dstKey)
+		g.Go(func() error {
+			bucket, archiveKey := ExtractBucketAndPath(entry.archive)
+			results[i].Err = extractRange(ctx, svc, bucket, archiveKey, opts.DstBucket, dstKey, entry.start, entry.size, entry.sha256, nil, opts)
+			return nil
+		})
+	}
+	g.Wait()
+	return results, nil
+}
+
+// readIndex keeps the location of the keys in wanted. A key archived more
+// than once is restored from the first archive it appears in.
+func readIndex(r io.Reader, wanted map[string]*indexEntry) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 6
+	cr.ReuseRecord = true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		entry, ok := wanted[record[1]]
+		if !ok || entry != nil {
+			continue
+		}
+		start, err := StringToInt64(record[2])
+		if err != nil {
+			return fmt.Errorf("invalid start of %s: %w", record[1], err)
+		}
+		size, err := StringToInt64(record[3])
+		if err != nil {
+			return fmt.Errorf("invalid size of %s: %w", record[1], err)
+		}
+		wanted[record[1]] = &indexEntry{archive: record[0], start: start, size: size, sha256: record[5]}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadIndex(t *testing.T) {
+	index := "s3://bucket/archive.0.tar,a.txt,1536,10,etag-a,\n" +
+		"s3://bucket/archive.0.tar,\"b,c.txt\",2560,20,etag-b,\n" +
+		"s3://bucket/archive.1.tar,d.txt,1536,5,etag-d,abc123\n" +
+		"s3://bucket/archive.2.tar,a.txt,3584,10,etag-a,\n"
+	wanted := map[string]*indexEntry{"a.txt": nil, "b,c.txt": nil, "d.txt": nil, "missing.txt": nil}
+	if err := readIndex(strings.NewReader(index), wanted); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*indexEntry{
+		"a.txt":       {archive: "s3://bucket/archive.0.tar", start: 1536, size: 10},
+		"b,c.txt":     {archive: "s3://bucket/archive.0.tar", start: 2560, size: 20},
+		"d.txt":       {archive: "s3://bucket/archive.1.tar", start: 1536, size: 5, sha256: "abc123"},
+		"missing.txt": nil,
+	}
+	if !reflect.DeepEqual(wanted, want) {
+		t.Errorf("readIndex() = %v, want %v", wanted, want)
+	}
+
+	for _, bad := range []string{
+		"s3://bucket/archive.0.tar,a.txt,1536,10\n",
+		"s3://bucket/archive.0.tar,a.txt,x,10,etag-a,\n",
+	} {
+		if err := readIndex(strings.NewReader(bad), map[string]*indexEntry{"a.txt": nil}); err == nil {
+			t.Errorf("readIndex(%q) expected an error", bad)
+		}
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(path, []byte("a.txt\r\n\nb c.txt\ndir/d.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeys(context.Background(), nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.txt", "b c.txt", "dir/d.txt"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("LoadKeys() = %q, want %q", keys, want)
+	}
+}
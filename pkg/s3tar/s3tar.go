@@ -0,0 +1,1564 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/remeh/sizedwaitgroup"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	blockSize       = int64(512)
+	partSizeMin     = 5 * 1024 * 1024 // 5MB
+	defaultPadSize  = partSizeMin
+	fileSizeMax     = 1024 * 1024 * 1024 * 1024 * 5 // 5TB
+	partSizeMax     = 1024 * 1024 * 1024 * 5        // 5GB
+	maxPartNumLimit = 10000
+	ustarSizeMax    = 1<<33 - 1 // largest size the octal size field holds
+)
+
+// ErrArchiveExists is returned when the archive already exists and
+// S3TarS3Options.Overwrite isn't set.
+var ErrArchiveExists = errors.New("archive already exists")
+
+// jobConfig is the part of the options the archive layout depends on: the
+// tar format, the pad prepended to the parts smaller than the minimum part
+// size and how many requests run at the same time. Every job has its own in
+// its context, the archives built at the same time don't share any state.
+type jobConfig struct {
+	format         tar.Format
+	padSize        int64
+	threads        int
+	strictChecksum bool
+	// kmsKeyID, sseAlgo and bucketKey encrypt the archive and the objects
+	// it is built from, see encryptUpload.
+	kmsKeyID  string
+	sseAlgo   types.ServerSideEncryption
+	bucketKey bool
+	// posixEOF ends the archive with exactly two zero blocks, see
+	// EOFPaddingPOSIX.
+	posixEOF bool
+	// attributes are the object attributes fetched by the job, see
+	// getObjectAttributes.
+	attributes *attributesCache
+}
+
+// defaultJobConfig is the configuration of the options left unset. It
+// caches no attributes, the requests outside a job don't share them.
+var defaultJobConfig = func() jobConfig {
+	cfg := newJobConfig(&S3TarS3Options{})
+	cfg.attributes = nil
+	return cfg
+}()
+
+func newJobConfig(opts *S3TarS3Options) jobConfig {
+	cfg := jobConfig{
+		format:         opts.tarFormat,
+		padSize:        opts.PadSize,
+		threads:        opts.Threads,
+		strictChecksum: opts.StrictUSTARChecksum,
+		kmsKeyID:       opts.KMSKeyID,
+		sseAlgo:        opts.SSEAlgo,
+		bucketKey:      opts.BucketKeyEnabled,
+		posixEOF:       opts.EOFPadding == EOFPaddingPOSIX,
+		attributes:     newAttributesCache(),
+	}
+	if cfg.format == tar.FormatUnknown {
+		cfg.format = tar.FormatPAX
+	}
+	if cfg.padSize == 0 {
+		cfg.padSize = defaultPadSize
+	}
+	if cfg.threads < 1 {
+		cfg.threads = 100
+	}
+	return cfg
+}
+
+// pad returns the zeros prepended to a part smaller than the minimum part
+// size, removed from the archive once it is complete.
+func (c jobConfig) pad() []byte {
+	return make([]byte, c.padSize)
+}
+
+// encryptUpload sets the SSE-KMS key of the job on input. With a bucket key
+// S3 asks KMS for a key of the bucket once instead of a data key per object
+// and part copied.
+func (c jobConfig) encryptUpload(input *s3.CreateMultipartUploadInput) {
+	if c.kmsKeyID == "" {
+		return
+	}
+	input.SSEKMSKeyId, input.ServerSideEncryption = aws.String(c.kmsKeyID), c.sseAlgo
+	if c.bucketKey {
+		input.BucketKeyEnabled = aws.Bool(true)
+	}
+}
+
+// encryptPut is encryptUpload for a PutObject.
+func (c jobConfig) encryptPut(input *s3.PutObjectInput) {
+	if c.kmsKeyID == "" {
+		return
+	}
+	input.SSEKMSKeyId, input.ServerSideEncryption = aws.String(c.kmsKeyID), c.sseAlgo
+	if c.bucketKey {
+		input.BucketKeyEnabled = aws.Bool(true)
+	}
+}
+
+func withJobConfig(ctx context.Context, cfg jobConfig) context.Context {
+	return context.WithValue(ctx, contextKeyJob, cfg)
+}
+
+// jobConfigFromContext returns the configuration of the job ctx belongs
+// to, the default one outside a job.
+func jobConfigFromContext(ctx context.Context) jobConfig {
+	if cfg, ok := ctx.Value(contextKeyJob).(jobConfig); ok {
+		return cfg
+	}
+	return defaultJobConfig
+}
+
+func ServerSideTar(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+
+	var objectList []*S3Obj
+	var err error
+	if opts.SrcManifest != "" {
+		Infof(ctx, "using manifest file %s", opts.SrcManifest)
+		objectList, _, err = LoadManifest(ctx, svc, opts.SrcManifest, opts.manifestOptions())
+	} else if opts.SrcBucket != "" && opts.NoncurrentVersions {
+		Infof(ctx, "using the noncurrent versions in source bucket '%s' and prefix '%s'", opts.SrcBucket, opts.SrcPrefix)
+		objectList, _, _, err = ListNoncurrentVersions(ctx, svc, opts.SrcBucket, opts.SrcPrefix)
+	} else if opts.SrcBucket != "" && opts.AllVersions {
+		Infof(ctx, "using every version in source bucket '%s' and prefix '%s'", opts.SrcBucket, opts.SrcPrefix)
+		objectList, _, err = ListAllVersions(ctx, svc, opts.SrcBucket, opts.SrcPrefix)
+	} else if opts.SrcBucket != "" {
+		Infof(ctx, "using source bucket '%s' and prefix '%s'", opts.SrcBucket, opts.SrcPrefix)
+		objectList, _, err = ListAllObjects(ctx, svc, opts.SrcBucket, opts.SrcPrefix)
+	} else {
+		return fmt.Errorf("manifest file or source bucket required")
+	}
+	if err != nil {
+		return err
+	}
+
+	return createFromList(ctx, svc, objectList, opts)
+}
+
+func createFromList(ctx context.Context, svc Backend, objectList []*S3Obj, opts *S3TarS3Options) (err error) {
+
+	cfg := newJobConfig(opts)
+	ctx = withJobConfig(ctx, cfg)
+	ctx = context.WithValue(ctx, contextKeyS3Client, svc)
+	start := time.Now()
+
+	// the archives built at the same time count their requests apart
+	ctx = withRequestCounters(ctx)
+	var stats *ArchiveStats
+	if opts.Stats != nil {
+		stats = newArchiveStats(objectList, requestCountersFromContext(ctx))
+		stats.Archive = fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey)
+		if parent := statsFromContext(ctx); parent != nil {
+			// stages that ran before the archive, e.g. listing
+			stats.Stages = append(stats.Stages, parent.Stages...)
+		}
+		ctx = context.WithValue(ctx, contextKeyStats, stats)
+	}
+	tracker := newCheckpointTracker()
+	tracker.listHash = objectListHash(objectList)
+	if opts.Resume {
+		tracker.resume, err = loadCheckpoint(ctx, svc, opts)
+		if err != nil {
+			return err
+		}
+		if tracker.resume != nil && tracker.resume.ListHash != tracker.listHash {
+			return fmt.Errorf("the objects to archive changed since the checkpoint s3://%s/%s was written, run without --resume to start again", opts.DstBucket, checkpointKey(opts))
+		}
+		if tracker.resume != nil {
+			Infof(ctx, "resuming from s3://%s/%s with %d completed groups", opts.DstBucket, checkpointKey(opts), len(tracker.resume.Groups))
+			if n := len(tracker.resume.FailedGroups); n > 0 {
+				Infof(ctx, "retrying %d failed groups", n)
+			}
+		}
+	}
+	ctx = context.WithValue(ctx, contextKeyCheckpoint, tracker)
+	ctx, stopExpired := withCredentialsExpiry(ctx)
+	defer stopExpired()
+	ctx, objTrace := withObjectTrace(ctx)
+	// the entries of the archive, and whether their offsets are the ones of
+	// the TOC
+	var traced []*S3Obj
+	var tracedOffsets bool
+	if tracker.resume != nil && tracker.resume.JobID != "" {
+		opts.jobID = tracker.resume.JobID
+	} else if opts.jobID, err = randomHex(4); err != nil {
+		return err
+	}
+	ctx = withJobID(ctx, opts.jobID)
+	if err := checkOverwrite(ctx, svc, opts); err != nil {
+		return err
+	}
+	// registered first, the last heartbeat is written once cleaned up
+	ctx, stopHeartbeat := startHeartbeat(ctx, svc, opts)
+	defer func() { stopHeartbeat(err) }()
+	index := masterIndexFromContext(ctx)
+	var archiveIdx *archiveIndex
+	if index != nil {
+		archiveIdx = &archiveIndex{archive: fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey)}
+		ctx = context.WithValue(ctx, contextKeyArchiveIndex, archiveIdx)
+	}
+	stageStart := start
+	concatObj := NewS3Obj()
+
+	defer func() {
+		if r := recover(); r != nil {
+			Errorf(ctx, "%v", r)
+			Errorf(ctx, "recovered from a panic. Trying to clean up.")
+			// the archive isn't complete, nothing runs as if it were
+			err = fmt.Errorf("panic: %v", r)
+		}
+		var groupsErr *GroupsFailedError
+		if ctx.Err() != nil || errors.As(err, &groupsErr) {
+			// keep the .parts so the run can be resumed
+			if cpErr := tracker.writeCheckpoint(context.WithoutCancel(ctx), svc, opts); cpErr != nil {
+				Errorf(ctx, "unable to write the checkpoint: %s", cpErr.Error())
+			}
+			if groupsErr == nil && errors.Is(context.Cause(ctx), ErrCredentialsExpired) {
+				err = fmt.Errorf("%w (%w): s3://%s/%s can be resumed with --resume once the credentials are renewed", ErrInterrupted, ErrCredentialsExpired, opts.DstBucket, opts.DstKey)
+			} else if groupsErr == nil && errors.Is(context.Cause(ctx), ErrMaxRuntime) {
+				err = fmt.Errorf("%w (%w): s3://%s/%s can be resumed with --resume", ErrInterrupted, ErrMaxRuntime, opts.DstBucket, opts.DstKey)
+			} else if groupsErr == nil {
+				err = fmt.Errorf("%w: s3://%s/%s can be resumed with --resume", ErrInterrupted, opts.DstBucket, opts.DstKey)
+			}
+			auditArchive(ctx, fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey), objectList, 0, err)
+			return
+		}
+		if err == nil && tracker.resume != nil {
+			deleteCheckpoint(ctx, svc, opts)
+		}
+		if err == nil && index != nil {
+			err = index.add(ctx, archiveIdx)
+		}
+		if err == nil && opts.Signer != nil {
+			err = SignArchive(ctx, svc, opts.DstBucket, opts.DstKey, opts.Signer)
+		}
+		if err == nil {
+			traceArchive(ctx, svc, objTrace, opts, traced, tracedOffsets)
+			presignCreated(ctx, svc, opts)
+			reportCreated(ctx, svc, opts)
+		}
+		if !opts.ConcatInMemory {
+			heartbeatStage(ctx, HeartbeatCleaningUp, 0)
+			cleanUp(ctx, svc, opts)
+			recordStage(ctx, "cleanup", stageStart)
+		}
+		var archiveSize int64
+		if concatObj != nil && concatObj.Size != nil {
+			archiveSize = *concatObj.Size
+		}
+		auditArchive(ctx, fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey), objectList, archiveSize, err)
+		elapsed := time.Since(start)
+		Infof(ctx, "Time elapsed: %s", elapsed)
+		if stats != nil {
+			stats.ArchiveBytes = archiveSize
+			stats.addStage("total", time.Since(start))
+			stats.finish()
+			writeReport := stats.WriteReport
+			if opts.StatsJSON {
+				writeReport = stats.WriteJSON
+			}
+			if err := writeReport(opts.Stats); err != nil {
+				Warnf(ctx, "unable to write the stats report: %s", err.Error())
+			}
+		}
+	}()
+
+	Infof(ctx, "processing %d Amazon S3 Objects", len(objectList))
+
+	objectList, err = checkKeys(ctx, objectList, opts.CheckKeys)
+	if err != nil {
+		return err
+	}
+	if opts.TrustListing {
+		sourceList := objectList
+		defer func() {
+			if err != nil {
+				err = checkTrustedSizes(ctx, svc, sourceList, opts.Threads, err)
+			}
+		}()
+	}
+	if opts.VerifySizes && opts.TrustListing {
+		Infof(ctx, "trusting the sizes of the listing, the size of the archive is checked once it is built")
+	} else if opts.VerifySizes {
+		if err := verifySourceSizes(ctx, svc, objectList, opts.Threads); err != nil {
+			return err
+		}
+		stageStart = recordStage(ctx, "verify-sizes", stageStart)
+	}
+	objectList = withArchiveRoot(objectList, opts.ArchiveRoot)
+	// before the conflicts, an object named toc.meta.json is renamed or left out
+	objectList, err = withArchiveMeta(objectList, opts.ArchiveMeta)
+	if err != nil {
+		return err
+	}
+	objectList, err = resolveConflicts(ctx, objectList, opts.OnConflict)
+	if err != nil {
+		return err
+	}
+	if opts.ClusterByPrefix {
+		// before the sidecars and the hardlinks, which follow their object
+		objectList = clusterByPrefix(objectList)
+	}
+
+	if opts.MetadataSidecars {
+		objectList, err = addMetadataSidecars(ctx, svc, objectList, opts.Threads)
+		if err != nil {
+			return err
+		}
+		stageStart = recordStage(ctx, "sidecars", stageStart)
+	}
+
+	if opts.zipFormat {
+		if opts.ClientEncryption != nil {
+			return fmt.Errorf("client-side encryption is not supported with the zip format")
+		}
+		if opts.Align > 0 {
+			return fmt.Errorf("alignment is not supported with the zip format")
+		}
+		if opts.NoEmbeddedTOC {
+			return fmt.Errorf("the zip format has no TOC entry, NoEmbeddedTOC is not supported with it")
+		}
+		if hasXattrs(objectList) {
+			Warnf(ctx, "the zip format has no PAX records, the xattrs are left out")
+		}
+		var err error
+		traced = objectList
+		heartbeatStage(ctx, HeartbeatBuilding, len(objectList))
+		concatObj, err = createZipFromList(ctx, svc, objectList, opts)
+		if err != nil {
+			return err
+		}
+		stageStart = recordStage(ctx, "build", stageStart)
+		Infof(ctx, "Final Object: s3://%s/%s", concatObj.Bucket, *concatObj.Key)
+		return nil
+	}
+
+	if opts.Dedup {
+		objectList = dedupObjectList(ctx, objectList)
+		stageStart = recordStage(ctx, "dedup", stageStart)
+	}
+
+	if opts.SHA256Digests {
+		if opts.ConcatInMemory {
+			Warnf(ctx, "the in-memory archive has no TOC, sha256 digests are not recorded")
+		} else if err := addSHA256Digests(ctx, svc, objectList, opts.Threads); err != nil {
+			return err
+		}
+		stageStart = recordStage(ctx, "digests", stageStart)
+	}
+
+	smallFiles := false
+
+	totalSize := int64(0)
+	for _, o := range objectList {
+		totalSize += *o.Size
+		if *o.Size < cfg.padSize {
+			smallFiles = true
+		}
+	}
+	Infof(ctx, "final size %s (without tar headers + padding)", formatBytes(totalSize))
+
+	if totalSize > fileSizeMax {
+		return fmt.Errorf("total size (%d) of all objects is more than 5TB. Reduce the number of objects", totalSize)
+	}
+
+	if cfg.format != tar.FormatPAX && hasXattrs(objectList) {
+		return fmt.Errorf("the xattrs are PAX records, they require the pax format")
+	}
+
+	if cfg.format == tar.FormatUSTAR {
+		for _, o := range objectList {
+			if *o.Size > ustarSizeMax {
+				return fmt.Errorf("%s is %d bytes, USTAR entries are limited to 8GiB. Use the pax or gnu format", *o.Key, *o.Size)
+			}
+		}
+	}
+
+	if opts.ClientEncryption != nil && !opts.ConcatInMemory && totalSize >= cfg.padSize {
+		return fmt.Errorf("client-side encryption requires the in-memory mode, the server-side copies can't encrypt the data")
+	}
+
+	if opts.Align > 0 {
+		if cfg.format != tar.FormatPAX {
+			return fmt.Errorf("alignment pads the PAX headers, it requires the pax format")
+		}
+		if opts.ConcatInMemory || totalSize < cfg.padSize {
+			Warnf(ctx, "the archives built in memory have no TOC, the entries aren't aligned")
+		} else {
+			if err := cfg.alignEntries(objectList, opts.Align); err != nil {
+				return err
+			}
+			stageStart = recordStage(ctx, "align", stageStart)
+		}
+	}
+
+	// the options of a chunk may come from an archive already created
+	opts.tocTrim = 0
+	if opts.NoEmbeddedTOC {
+		if opts.ConcatInMemory || totalSize < cfg.padSize {
+			Warnf(ctx, "the archives built in memory have no TOC, no external TOC is written")
+		} else {
+			// the TOC is built with the archive and cut from its front
+			if opts.tocTrim, err = cfg.tocSpan(objectList); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the in-memory archives are written by the tar writer, the others are
+	// checked against the size the headers add up to once they are complete
+	var expectedSize int64
+	traced = objectList
+	heartbeatStage(ctx, HeartbeatBuilding, len(objectList))
+	if opts.ConcatInMemory || totalSize < cfg.padSize {
+		Debugf(ctx, "Processing small files in-memory")
+		var err error
+		concatObj, err = buildInMemoryConcat(ctx, svc, objectList, totalSize, opts)
+		if err != nil {
+			return err
+		}
+		recordStage(ctx, "build", stageStart)
+	} else if smallFiles {
+		// the large objects of a mixed list start groups of their own, see
+		// planGroups
+		Debugf(ctx, "Processing small files")
+		size, err := cfg.archiveSize(objectList)
+		if err != nil {
+			return err
+		}
+		expectedSize = size - opts.tocTrim
+		rc, err := NewRecursiveConcat(ctx, RecursiveConcatOptions{
+			Client:      svc,
+			Bucket:      opts.DstBucket,
+			DstPrefix:   opts.DstPrefix,
+			DstKey:      opts.DstKey,
+			PartsKey:    partsKey(opts),
+			Region:      opts.Region,
+			EndpointUrl: opts.EndpointUrl,
+		})
+		if err != nil {
+			return err
+		}
+		headList := make([]*s3.HeadObjectOutput, len(objectList))
+		if opts.PreservePOSIXMetadata {
+			var wg sync.WaitGroup
+			for i, obj := range objectList {
+				wg.Add(1)
+				go func(i int, obj *S3Obj) {
+					defer wg.Done()
+					if obj.NoHeaderRequired {
+						headList[i] = nil
+					} else {
+						head := fetchS3ObjectHead(ctx, svc, obj)
+						headList[i] = head
+					}
+				}(i, obj)
+			}
+			wg.Wait()
+		}
+
+		Debugf(ctx, "building toc")
+		manifestObj, err := tocObject(ctx, svc, objectList, opts)
+		if err != nil {
+			Debugf(ctx, "buildToc: %s", err.Error())
+			return err
+		}
+		objectList = append([]*S3Obj{manifestObj}, objectList...)
+		headList = append([]*s3.HeadObjectOutput{nil}, headList...)
+		Debugf(ctx, "prepended toc: %s Size: %d len.Data: %d", *manifestObj.Key, *manifestObj.Size, len(manifestObj.Data))
+		concatObj, err = processSmallFiles(ctx, svc, rc, objectList, headList, opts.DstKey, opts)
+		if err != nil {
+			return err
+		}
+	} else {
+		Debugf(ctx, "Processing large files")
+		size, err := cfg.archiveSize(objectList)
+		if err != nil {
+			return err
+		}
+		expectedSize = size - opts.tocTrim
+		concatObj, err = processLargeFiles(ctx, svc, objectList, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the build stages are recorded as they run
+	stageStart = time.Now()
+	tracedOffsets = expectedSize > 0
+	if expectedSize > 0 {
+		heartbeatStage(ctx, HeartbeatVerifying, 0)
+		if err := verifyArchiveSize(ctx, svc, opts, expectedSize); err != nil {
+			return err
+		}
+		stageStart = recordStage(ctx, "verify", stageStart)
+	}
+	if opts.tocTrim > 0 {
+		if err := writeExternalTOC(ctx, svc, traced, opts); err != nil {
+			return err
+		}
+	}
+	Infof(ctx, "Final Object: s3://%s/%s", concatObj.Bucket, *concatObj.Key)
+	return nil
+}
+
+// cleanUp deletes the intermediate objects of the run, unless
+// opts.KeepIntermediates is set. A failure is only logged, the archive is
+// complete.
+func cleanUp(ctx context.Context, svc Backend, opts *S3TarS3Options) {
+	scratchDirs := []string{
+		partsKey(opts),
+		JoinKey(opts.DstPrefix, opts.DstKey, "headers"),
+	}
+	if opts.KeepIntermediates {
+		Infof(ctx, "keeping the intermediate objects at s3://%s/%s", opts.DstBucket, scratchDirs[0])
+		return
+	}
+	Infof(ctx, "deleting all intermediate objects")
+	for _, path := range scratchDirs {
+		if path == "" || path == "/" {
+			continue
+		}
+		deleteList, _, err := listAllObjects(ctx, svc, opts.DstBucket, path)
+		if err == nil {
+			err = deleteObjectList(ctx, svc, deleteList)
+		}
+		if err != nil {
+			Warnf(ctx, "Unable to delete intermediate objects at: %s %s: %s", opts.DstBucket, path, err.Error())
+		}
+	}
+}
+
+// partsKey is the prefix of the intermediate objects of the run. The job ID
+// keeps two runs writing to the same destination apart.
+func partsKey(opts *S3TarS3Options) string {
+	return JoinKey(opts.DstPrefix, opts.DstKey+".parts", opts.jobID)
+}
+
+// checkOverwrite fails with ErrArchiveExists when the archive exists and
+// opts.Overwrite isn't set. It runs when the run starts and again before the
+// archive is written, another job may have created it in the meantime.
+func checkOverwrite(ctx context.Context, svc Backend, opts *S3TarS3Options) error {
+	if opts.Overwrite {
+		return nil
+	}
+	_, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &opts.DstBucket, Key: &opts.DstKey})
+	if err == nil {
+		return fmt.Errorf("s3://%s/%s: %w, use --overwrite to replace it", opts.DstBucket, opts.DstKey, ErrArchiveExists)
+	}
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+// ErrSizeMismatch is returned when the archive created isn't the size its
+// headers and objects add up to, e.g. a part was truncated.
+var ErrSizeMismatch = errors.New("archive size mismatch")
+
+// verifyArchiveSize compares the size of the archive in Amazon S3 with the
+// size computed from the headers, see archiveSize.
+func verifyArchiveSize(ctx context.Context, svc Backend, opts *S3TarS3Options, expected int64) error {
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &opts.DstBucket, Key: &opts.DstKey})
+	if err != nil {
+		return fmt.Errorf("unable to verify s3://%s/%s: %w", opts.DstBucket, opts.DstKey, err)
+	}
+	if size := aws.ToInt64(head.ContentLength); size != expected {
+		return fmt.Errorf("s3://%s/%s is %d bytes, expected %d: %w", opts.DstBucket, opts.DstKey, size, expected, ErrSizeMismatch)
+	}
+	Debugf(ctx, "s3://%s/%s is %d bytes as expected", opts.DstBucket, opts.DstKey, expected)
+	return nil
+}
+
+// Policies of the end of archive blocks, see S3TarS3Options.EOFPadding.
+const (
+	// EOFPaddingCompat adds a zero block to an archive already ending on a
+	// block, three blocks in all, the trailer of the archives created by
+	// the earlier versions.
+	EOFPaddingCompat = "compat"
+	// EOFPaddingPOSIX pads the last entry to a block and adds exactly the
+	// two zero blocks of POSIX, like tar and the in-memory archives.
+	EOFPaddingPOSIX = "posix"
+)
+
+// lastBlockSize is the size of the padding of the last entry and the end of
+// archive blocks, for an archive of s bytes so far.
+func (c jobConfig) lastBlockSize(s int64) int64 {
+	lastBlockSize := findPadding(s)
+	if lastBlockSize == 0 && !c.posixEOF {
+		lastBlockSize = blockSize
+	}
+	return lastBlockSize + blockSize*2
+}
+
+func (c jobConfig) generateLastBlock(s int64) *S3Obj {
+	lastBytes := make([]byte, c.lastBlockSize(s))
+	eofPadding := NewS3Obj()
+	eofPadding.AddData(lastBytes)
+	eofPadding.NoHeaderRequired = true
+	return eofPadding
+}
+
+type concatresult struct {
+	result *S3Obj
+	err    error
+}
+
+// concatObjAndHeader will only perform pair (obj1 + hdr2) concatenation
+// concatObjAndHeader returns the pairs and the size of the pad in front of
+// the first one, 0 when the TOC is larger than the minimum part size.
+func concatObjAndHeader(ctx context.Context, svc Backend, objectList []*S3Obj, opts *S3TarS3Options) ([]*S3Obj, int64, error) {
+	cfg := jobConfigFromContext(ctx)
+
+	ctx = context.WithValue(ctx, contextKeyS3Client, svc)
+	concater, err := NewRecursiveConcat(ctx, RecursiveConcatOptions{
+		Client:      svc,
+		Bucket:      opts.DstBucket,
+		DstPrefix:   opts.DstPrefix,
+		DstKey:      opts.DstKey,
+		PartsKey:    partsKey(opts),
+		Region:      opts.Region,
+		EndpointUrl: opts.EndpointUrl,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	manifestObj, err := tocObject(ctx, svc, objectList, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	var trim int64
+	frontPad := tarHeaderSize(cfg.format)+*manifestObj.Size < cfg.padSize
+	if frontPad {
+		trim = cfg.padSize
+	}
+	var firstPart *S3Obj
+	if len(manifestObj.Data) > 0 {
+		firstPart, err = cfg.buildFirstPart(manifestObj.Data, frontPad)
+	} else {
+		firstPart, err = concatFirstPart(ctx, concater, manifestObj, frontPad, opts)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	firstPart.Bucket = opts.DstBucket
+	objectList = append([]*S3Obj{firstPart}, objectList...)
+
+	wg := sizedwaitgroup.New(opts.Threads)
+	resultsChan := make(chan concatresult)
+	for i, obj := range objectList {
+		nextIndex := i + 1
+		var notLastBlock = nextIndex < len(objectList)
+		var nextObject *S3Obj
+		if notLastBlock {
+			nextObject = objectList[nextIndex]
+		} else {
+			nextObject = nil
+		}
+
+		name := fmt.Sprintf("%d.part-%d.hdr", i, nextIndex)
+		key := JoinKey(partsKey(opts), name)
+		wg.Add()
+		go func(nextObject *S3Obj, obj *S3Obj, key string, partNum int) {
+			var p1 = obj
+			var p2 *S3Obj = nil
+			if notLastBlock {
+				var head *s3.HeadObjectOutput
+				if opts.PreservePOSIXMetadata {
+					head = fetchS3ObjectHead(ctx, svc, nextObject)
+				} else {
+					head = nil
+				}
+
+				h, err := cfg.buildHeader(nextObject, p1, false, head)
+				if err != nil {
+					resultsChan <- concatresult{nil, err}
+					wg.Done()
+					return
+				}
+				p2 = &h
+			} else {
+				// everything before the last object ends on a block
+				eofPadding := cfg.generateLastBlock(*obj.Size)
+				p2 = eofPadding
+			}
+			var pairs = []*S3Obj{p1, p2}
+
+			res, err := concater.ConcatObjects(ctx, pairs, opts.DstBucket, key)
+			if err != nil {
+				Infof(ctx, err.Error())
+			} else {
+				heartbeatProgress(ctx, 1)
+				res.PartNum = partNum
+			}
+			resultsChan <- concatresult{res, err}
+			wg.Done()
+		}(nextObject, obj, key, i+1)
+
+	}
+	go func() {
+		wg.Wait()
+		close(resultsChan)
+	}()
+
+	var results []*S3Obj
+	var resultErr error
+	// every goroutine sends its result, the channel is drained after an error
+	for r := range resultsChan {
+		if r.err != nil {
+			if resultErr == nil {
+				resultErr = r.err
+			}
+			continue
+		}
+		results = append(results, r.result)
+	}
+	if resultErr != nil {
+		return nil, 0, resultErr
+	}
+	sort.Sort(byPartNum(results))
+	return results, trim, nil
+}
+
+func fetchS3ObjectHead(ctx context.Context, svc Backend, nextObject *S3Obj) *s3.HeadObjectOutput {
+	Debugf(ctx, "fetching head for %s/%s", *&nextObject.Bucket, *nextObject.Key)
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(nextObject.Bucket),
+		Key:    nextObject.Key,
+	}
+	if nextObject.VersionId != "" {
+		input.VersionId = aws.String(nextObject.VersionId)
+	}
+	head, err := svc.HeadObject(ctx, input)
+	if err != nil {
+		Fatalf(ctx, err.Error())
+	}
+	return head
+}
+
+type batchGroup struct {
+	Obj *S3Obj
+	Err error
+}
+
+func breakUpList(ctx context.Context, svc Backend, objectList []*S3Obj, opts *S3TarS3Options) ([]*S3Obj, error) {
+
+	l := list.New()
+	for i := 0; i < len(objectList); i++ {
+		l.PushBack(objectList[i])
+	}
+
+	batchGoupList := [][]*S3Obj{}
+	partCounter := 1
+	var accum int64 = 0
+	var accumList []*S3Obj
+	for e := l.Front(); e != nil; {
+		o := e.Value.(*S3Obj)
+		temp := accum + *o.Size
+		// an object over the part limit gets a batch of its own
+		if temp < partSizeMax || len(accumList) == 0 {
+			accum = temp
+			o.PartNum = partCounter
+			partCounter += 1
+			accumList = append(accumList, o)
+			e = e.Next()
+		} else {
+			batchGoupList = append(batchGoupList, accumList)
+			accum = 0
+			partCounter = 1
+			accumList = []*S3Obj{}
+		}
+	}
+
+	Debugf(ctx, "finished sending batches...\npushing last batch\n")
+	if len(accumList) > 0 {
+		batchGoupList = append(batchGoupList, accumList)
+	}
+
+	ConcatBatch := func(batchList [][]*S3Obj) ([]*S3Obj, error) {
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(opts.Threads)
+		results := make([]*S3Obj, len(batchGoupList))
+		for i, batch := range batchList {
+			i, batch := i, batch
+			g.Go(func() error {
+				Debugf(ctx, "processing batch: %d\n", i)
+				fn, err := randomHex(12)
+				if err != nil {
+					return err
+				}
+				tempKey := JoinKey(partsKey(opts), fn)
+				obj, err := concatObjects(ctx, svc, 0, batch, opts.DstBucket, tempKey)
+				if err == nil {
+					obj.PartNum = i + 1
+					results[i] = obj
+				}
+				return err
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+
+	return ConcatBatch(batchGoupList)
+}
+
+func processLargeFiles(ctx context.Context, svc Backend, objectList []*S3Obj, opts *S3TarS3Options) (*S3Obj, error) {
+
+	start := time.Now()
+	results, trim, err := concatObjAndHeader(ctx, svc, objectList, opts)
+	if err != nil {
+		return nil, err
+	}
+	start = recordStage(ctx, "headers", start)
+
+	if len(results) > 10000 {
+		Infof(ctx, "objectList is larger than 10,000 files. processing in batches\n")
+		var err error
+		results, err = breakUpList(ctx, svc, results, opts)
+		if err != nil {
+			return nil, err
+		}
+		start = recordStage(ctx, "grouping", start)
+	}
+	Debugf(ctx, "list reduced\n")
+
+	tempKey := JoinKey(partsKey(opts), "output.temp")
+	concatObj, err := concatObjects(ctx, svc, 0, results, opts.DstBucket, tempKey)
+	if err != nil {
+		return nil, err
+	}
+	start = recordStage(ctx, "concat", start)
+
+	if err := checkOverwrite(ctx, svc, opts); err != nil {
+		return nil, err
+	}
+	finalObject, err := redistribute(ctx, svc, concatObj, trim+opts.tocTrim, opts.DstBucket, opts.DstKey, opts.storageClass, opts.ObjectTags)
+	if err != nil {
+		return nil, err
+	}
+	recordStage(ctx, "redistribute", start)
+
+	Infof(ctx, "Finished: s3://%s/%s", finalObject.Bucket, *finalObject.Key)
+	return finalObject, nil
+
+}
+
+// redistribute will try to evenly distribute the object into equal size parts.
+// it will also trim whatever offset passed, helpful to remove the front padding
+func redistribute(ctx context.Context, client Backend, obj *S3Obj, trimoffset int64, bucket, key string, storageClass types.StorageClass, tagSet types.Tagging) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+	finalSize := *obj.Size - trimoffset
+	min, max, mid := findMinMaxPartRange(finalSize)
+	var r int64 = 0
+	for i := max; i >= min; i-- {
+		r = finalSize % i
+		if r == 0 {
+			mid = i
+			break
+		}
+	}
+
+	partSize := finalSize / mid
+	Debugf(ctx, "redistribute calculations")
+	Debugf(ctx, "parts: %d", mid)
+	Debugf(ctx, "FinalSize:\t%d", finalSize)
+	Debugf(ctx, "total:\t%d", partSize*mid)
+	Debugf(ctx, "PartSize:\t%d", partSize)
+	var start int64 = 0
+	type IndexLoc struct {
+		Start int64
+		End   int64
+		Size  int64
+	}
+	indexList := []IndexLoc{}
+	for start = 0; start < finalSize; start = start + partSize {
+		i := IndexLoc{
+			Start: trimoffset + start,
+			End:   trimoffset + start + partSize,
+			Size:  partSize,
+		}
+		indexList = append(indexList, i)
+		Debugf(ctx, "%v-%v", i.Start, i.End)
+	}
+	if indexList[len(indexList)-1].End != *obj.Size {
+		indexList[len(indexList)-1].End = *obj.Size
+	}
+
+	complete := NewS3Obj()
+	tags := TagsToUrlEncodedString(tagSet)
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		StorageClass: storageClass,
+		Tagging:      &tags,
+		ACL:          types.ObjectCannedACLBucketOwnerFullControl,
+	}
+	jobConfigFromContext(ctx).encryptUpload(input)
+	output, err := client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		Infof(ctx, err.Error())
+		return nil, err
+	}
+	uploadId := *output.UploadId
+	trackUpload(ctx, bucket, key, uploadId)
+
+	Redistribute := func(ctx context.Context, indexList []IndexLoc) ([]types.CompletedPart, error) {
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(cfg.threads)
+		parts := make([]types.CompletedPart, len(indexList))
+		for i, r := range indexList {
+			i, r := i, r
+			g.Go(func() error {
+				partNum := int32(i + 1)
+				copySourceRange := fmt.Sprintf("bytes=%d-%d", r.Start, r.End-1)
+				input := s3.UploadPartCopyInput{
+					Bucket:          &bucket,
+					Key:             &key,
+					PartNumber:      &partNum,
+					UploadId:        &uploadId,
+					CopySource:      aws.String(copySource(obj)),
+					CopySourceRange: aws.String(copySourceRange),
+				}
+				Debugf(ctx, "UploadPartCopy (s3://%s/%s) into:\n\ts3://%s/%s", *input.Bucket, *input.Key, bucket, key)
+				rc, err := client.UploadPartCopy(ctx, &input)
+				if err != nil {
+					Debugf(ctx, "error for s3://%s/%s", *input.Bucket, *input.Key)
+					Debugf(ctx, "CopySourceRange %s", *input.CopySourceRange)
+					return err
+				}
+				parts[i] = types.CompletedPart{
+					ETag:       rc.CopyPartResult.ETag,
+					PartNumber: input.PartNumber}
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+		return parts, nil
+	}
+
+	parts, err := Redistribute(ctx, indexList)
+	if err != nil {
+		return nil, err
+	}
+	Debugf(ctx, "len parts: %d\n", len(parts))
+
+	completeOutput, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		Infof(ctx, err.Error())
+		return nil, err
+	}
+	untrackUpload(ctx, uploadId)
+	now := time.Now()
+	complete = &S3Obj{
+		Bucket: *completeOutput.Bucket,
+		Object: types.Object{
+			Key:          completeOutput.Key,
+			ETag:         completeOutput.ETag,
+			Size:         &finalSize,
+			LastModified: &now,
+		},
+	}
+	return complete, nil
+
+}
+
+func processSmallFiles(ctx context.Context, client Backend, rc *RecursiveConcat, objectList []*S3Obj, headList []*s3.HeadObjectOutput, dstKey string, opts *S3TarS3Options) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+
+	Debugf(ctx, "processSmallFiles path")
+
+	start := time.Now()
+	indexList, totalSize, err := planGroups(ctx, objectList)
+	if err != nil {
+		return nil, err
+	}
+	indexList = cfg.mergeSmallGroups(indexList)
+	eofPadding := cfg.generateLastBlock(totalSize)
+	objectList = append(objectList, eofPadding)
+	headList = append(headList, nil)
+	indexList[len(indexList)-1].End = len(objectList) - 1
+
+	g := new(errgroup.Group)
+	g.SetLimit(opts.Threads)
+	groups := make([]*S3Obj, len(indexList))
+	// a failed group doesn't stop the others, the completed ones are reused
+	// when the run is resumed
+	var mu sync.Mutex
+	var failed []FailedGroup
+
+	start = recordStage(ctx, "grouping", start)
+	var pool *headerPool
+	if opts.StageHeaders {
+		var err error
+		if pool, err = stageHeaders(ctx, client, objectList, headList, opts); err != nil {
+			return nil, err
+		}
+	}
+	Debugf(ctx, "Created %d parts", len(indexList))
+	for i, p := range indexList {
+		i, p := i, p
+		start := p.Start
+		end := p.End
+		// the header of a group starting with its data ends this one
+		nextDataFirst := i+1 < len(indexList) && indexList[i+1].DataFirst
+		Debugf(ctx, "Part %06d range: %d - %d", i+1, p.Start, p.End)
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			newPart, err := _processSmallFiles(ctx, rc, pool, objectList, headList, start, end, p.DataFirst, nextDataFirst, opts)
+			if err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				Errorf(ctx, "group %d-%d failed: %s", start, end, err.Error())
+				g := FailedGroup{
+					Start: start,
+					End:   end,
+					First: aws.ToString(objectList[start].Key),
+					Last:  aws.ToString(objectList[end].Key),
+					Error: err.Error(),
+				}
+				trackFailedGroup(ctx, g)
+				mu.Lock()
+				failed = append(failed, g)
+				mu.Unlock()
+				return nil
+			}
+			newPart.PartNum = start
+			groups[i] = newPart
+			return nil
+		})
+	}
+
+	Debugf(ctx, "Waiting for threads")
+	//swg.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if len(failed) > 0 {
+		sort.Slice(failed, func(i, j int) bool { return failed[i].Start < failed[j].Start })
+		return nil, &GroupsFailedError{
+			Archive: fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey),
+			Groups:  failed,
+			Total:   len(indexList),
+		}
+	}
+	sort.Sort(byPartNum(groups))
+	start = recordStage(ctx, "headers", start)
+
+	// reset partNum counts.
+	// Figure out if the final concat needs to be recursive
+	recursiveConcat := false
+	for x := 0; x < len(groups)-1; x++ { //ignore last piece
+		groups[x].PartNum = x + 1
+		// Debugf(ctx,"Group %05d - Size: %d", x, groups[x].Size/1024/1024)
+		if *groups[x].Size < cfg.padSize {
+			recursiveConcat = true
+		}
+	}
+	if recursiveConcat {
+		// mergeSmallGroups plans every group over the minimum part size
+		Warnf(ctx, "a group is smaller than the minimum part size, concatenating the groups recursively")
+	}
+	groups[len(groups)-1].PartNum = len(groups) // setup the last PartNum since we skipped it
+
+	if err := checkOverwrite(ctx, client, opts); err != nil {
+		return NewS3Obj(), err
+	}
+	finalObject := NewS3Obj()
+	if recursiveConcat {
+		var err error
+		finalObject, err = mergeTree(ctx, client, groups, opts)
+		if err != nil {
+			return NewS3Obj(), err
+		}
+	} else {
+		var err error
+		finalObject, err = concatObjects(ctx, client, 0, groups, opts.DstBucket, opts.DstKey)
+		if err != nil {
+			Debugf(ctx, "error recursion on final\n%s", err.Error())
+			return NewS3Obj(), err
+		}
+	}
+	start = recordStage(ctx, "concat", start)
+
+	finalObject, err = redistribute(ctx, client, finalObject, opts.tocTrim, opts.DstBucket, opts.DstKey, opts.storageClass, opts.ObjectTags)
+	recordStage(ctx, "redistribute", start)
+	return finalObject, err
+
+}
+
+// _processSmallFiles processes a range of small files from the given objectList and headList.
+// It generates tar headers for each file and concatenates them into a finalPart.
+// If a file does not require a tar header, it is appended directly to the parts list.
+// The headList is either the results of S3 HEAD requests or nil.
+//
+//	if present, the head is used to set POSIX file permissions, owner and group.
+//
+// When dataFirst is set the header of the first object was added to the group
+// before and the group starts with its data. When nextDataFirst is set the
+// header of the object after end is added at the end of the group.
+//
+// The generated parts are then concatenated using the rc.ConcatObjects function.
+// The resulting finalPart is returned along with any error encountered during the process.
+//
+// Parameters:
+//   - ctx: The context.Context for the operation.
+//   - rc: The RecursiveConcat used to concatenate the parts of this job.
+//   - pool: The headers staged with StageHeaders, or nil to upload them.
+//   - objectList: A slice of S3Obj representing the list of objects to process.
+//   - headList: A slice of s3.HeadObjectOutput or nil, used to set permissions, uid and gid
+//   - start: The starting index of the range of files to process.
+//   - end: The ending index of the range of files to process.
+//   - dataFirst: The group starts with the data of the object at start.
+//   - nextDataFirst: The group ends with the header of the object after end.
+//   - opts: A pointer to S3TarS3Options containing the options for S3 operations.
+//
+// Returns:
+//   - *S3Obj: The final concatenated part.
+//   - error: Any error encountered during the process.
+func _processSmallFiles(ctx context.Context, rc *RecursiveConcat, pool *headerPool, objectList []*S3Obj, headList []*s3.HeadObjectOutput, start, end int, dataFirst, nextDataFirst bool, opts *S3TarS3Options) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+	parentPartsKey := partsKey(opts)
+	header := func(i int) (*S3Obj, error) {
+		if pool != nil {
+			return pool.header(i), nil
+		}
+		prev := NewS3Obj()
+		if (i - 1) >= 0 {
+			prev = objectList[i-1]
+		}
+		header, err := cfg.buildHeader(objectList[i], prev, false, headList[i])
+		if err != nil {
+			return nil, err
+		}
+		header.Bucket = opts.DstBucket
+		return &header, nil
+	}
+	parts := []*S3Obj{}
+	for i, partNum := start, 0; i <= end; i, partNum = i+1, partNum+1 {
+		Debugf(ctx, "Processing: %s", *objectList[i].Key)
+		// a copy keeps the version and the range of the source
+		obj := *objectList[i]
+		obj.PartNum = partNum
+		// some objects my not need a tar header generated (like the last piece)
+		if objectList[i].NoHeaderRequired {
+			parts = append(parts, objectList[i])
+		} else if i == start && dataFirst {
+			parts = append(parts, &obj)
+		} else {
+			h, err := header(i)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, h, &obj)
+		}
+	}
+	if nextDataFirst && end+1 < len(objectList) {
+		h, err := header(end + 1)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, h)
+	}
+
+	batchName := fmt.Sprintf("%d-%d", start, end)
+	if dataFirst || nextDataFirst {
+		// not the layout of the groups of the same range in a list of small
+		// objects, a checkpoint of one can't be resumed with the other
+		batchName += ".mixed"
+	}
+	dstKey := JoinKey(parentPartsKey, strings.Join([]string{"iteration", "batch", batchName}, "."))
+	if resumed := resumedGroup(ctx, rc.Client, opts.DstBucket, dstKey, start); resumed != nil {
+		Debugf(ctx, "reusing %s from the checkpoint", dstKey)
+		heartbeatProgress(ctx, end-start+1)
+		return resumed, nil
+	}
+	finalPart, err := rc.ConcatObjects(ctx, parts, opts.DstBucket, dstKey)
+	if err != nil {
+		Debugf(ctx, "%s", dstKey)
+		Debugf(ctx, "error recursion on final\n%s", err.Error())
+		return NewS3Obj(), err
+	}
+	trackGroup(ctx, start, finalPart)
+	heartbeatProgress(ctx, end-start+1)
+
+	return finalPart, nil
+}
+
+// findMinimumPartSize is for the case when we want to optimize as many parts
+// as possible. This is helpful to parallelize the workload even more.
+// findMinimumPartSize will start at 5MB and increment by 5MB until we're
+// within the 10,000 MPU part limit
+func (c jobConfig) findMinimumPartSize(finalSizeBytes, userMaxSize int64) int64 {
+
+	partSize := c.padSize
+
+	if userMaxSize > 0 {
+		partSize = userMaxSize * 1024 * 1024
+	}
+
+	for ; partSize <= partSizeMax; partSize = partSize + c.padSize {
+		if finalSizeBytes/int64(partSize) < maxPartNumLimit {
+			break
+		}
+	}
+
+	if partSize > partSizeMax {
+		log.Fatal("part size maximum cannot exceed 5GiB")
+	}
+
+	return partSize
+}
+
+// estimateFinalSize takes the total of all object
+// then multiplies the number of objects by the header size
+// then multiplies 512 by every object (the padding -- worst case scenario)
+func (c jobConfig) estimateFinalSize(objectList []*S3Obj) int64 {
+	headerSize := tarHeaderSize(c.format)
+	estimatedSize := int64(0)
+	for _, o := range objectList {
+		estimatedSize += *o.Size + int64(headerSize+blockSize)
+	}
+	return estimatedSize
+}
+
+// planGroups walks through objectList and builds groups of about the part
+// size so they can be concatenated in parallel. Every object over the
+// minimum part size but the first one starts a group with its data, its
+// header ends the group before. The large objects of a mixed list are
+// copied once into their group instead of being merged with the small
+// objects before them, and their group is large enough to be a part without
+// the block of zeros in front.
+func planGroups(ctx context.Context, objectList []*S3Obj) ([]Index, int64, error) {
+	cfg := jobConfigFromContext(ctx)
+
+	estimatedSize := cfg.estimateFinalSize(objectList)
+	partSize := cfg.findMinimumPartSize(estimatedSize, 0)
+	Infof(ctx, "estimated final size: %d bytes (with headers + padding)\nmultipart part-size: %d bytes\n", estimatedSize, partSize)
+
+	var indexList []Index
+	// largeSize is the size of the large object starting the group, the
+	// objects merged with it make up a part of their own
+	var totalSize, currSize, largeSize int64
+	group := Index{}
+	closeGroup := func(end int) {
+		group.End, group.Size = end, int(currSize)
+		indexList = append(indexList, group)
+		group, currSize, largeSize = Index{Start: end + 1}, 0, 0
+	}
+	for i, o := range objectList {
+		var prev *S3Obj
+		if i > 0 {
+			prev = objectList[i-1]
+		}
+		// passing nil for head, header is only used to estimate size, so permissions are not needed
+		header, err := cfg.headerData(o, prev, false, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		hdrSize := int64(len(header))
+		totalSize += hdrSize + *o.Size
+		if i > 0 && *o.Size >= cfg.padSize {
+			if group.Start < i {
+				currSize += hdrSize
+				closeGroup(i - 1)
+			} else {
+				// the group before ended with the previous object
+				indexList[len(indexList)-1].Size += int(hdrSize)
+			}
+			group.DataFirst = true
+			currSize, largeSize = *o.Size, *o.Size
+		} else {
+			currSize += hdrSize + *o.Size
+		}
+		if currSize-largeSize > partSize && i < len(objectList)-1 {
+			closeGroup(i)
+		}
+	}
+	switch {
+	case len(indexList) == 0 || group.DataFirst:
+		closeGroup(len(objectList) - 1)
+	default:
+		// the last group includes everything till the end, we don't want
+		// something that is less than 5MB
+		indexList[len(indexList)-1].End = len(objectList) - 1
+		indexList[len(indexList)-1].Size += int(currSize)
+	}
+	return indexList, totalSize, nil
+}
+
+// mergeTree concatenates groups smaller than the minimum part size into
+// s3://DstBucket/DstKey. Appending every group to the archive so far would
+// copy it again each time, the copied bytes would grow with the square of
+// the number of groups. The groups are merged in pairs instead, level by
+// level, so every byte is copied once per level, log2 of the number of
+// groups. The first part of a pair must be over the minimum part size: the
+// small groups are first appended to the group before them, after the pad
+// for the first one, and the pairs of larger parts stay larger.
+func mergeTree(ctx context.Context, client Backend, groups []*S3Obj, opts *S3TarS3Options) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+	treeKey := func(level, i int) string {
+		return JoinKey(partsKey(opts), fmt.Sprintf("tree.%d.%d", level, i))
+	}
+	var trim int64
+	var nodes []*S3Obj
+	for i, group := range groups {
+		switch {
+		case i == 0 && *group.Size < cfg.padSize:
+			padObject := NewS3Obj()
+			padObject.AddData(cfg.pad())
+			merged, err := concatObjects(ctx, client, 0, []*S3Obj{padObject, group}, opts.DstBucket, treeKey(0, i))
+			if err != nil {
+				return nil, err
+			}
+			trim = cfg.padSize
+			nodes = append(nodes, merged)
+		case i > 0 && i < len(groups)-1 && *group.Size < cfg.padSize:
+			merged, err := concatObjects(ctx, client, 0, []*S3Obj{nodes[len(nodes)-1], group}, opts.DstBucket, treeKey(0, i))
+			if err != nil {
+				return nil, err
+			}
+			nodes[len(nodes)-1] = merged
+		default:
+			nodes = append(nodes, group)
+		}
+	}
+
+	for level := 1; len(nodes) > 1; level++ {
+		Debugf(ctx, "merging %d parts, level %d", len(nodes), level)
+		next := make([]*S3Obj, (len(nodes)+1)/2)
+		last := len(nodes) <= 2
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(opts.Threads)
+		for i := 0; i < len(nodes); i += 2 {
+			i := i
+			if i+1 == len(nodes) {
+				next[i/2] = nodes[i]
+				continue
+			}
+			g.Go(func() error {
+				key, pairTrim := treeKey(level, i/2), 0
+				if last {
+					key = opts.DstKey
+					// the pad is removed with the last merge when what is
+					// left of the first part is still large enough
+					if trim > 0 && *nodes[i].Size-trim >= cfg.padSize {
+						pairTrim, trim = int(trim), 0
+					}
+				}
+				merged, err := concatObjects(gctx, client, pairTrim, nodes[i:i+2], opts.DstBucket, key)
+				next[i/2] = merged
+				return err
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+		nodes = next
+	}
+	if trim > 0 || *nodes[0].Key != opts.DstKey {
+		return concatObjects(ctx, client, int(trim), nodes[:1], opts.DstBucket, opts.DstKey)
+	}
+	return nodes[0], nil
+}
+
+// mergeSmallGroups merges every group planned under the minimum part size,
+// but the last one, with the group that follows it. Every group is then a
+// valid part and the final concat is a single multipart upload instead of
+// the recursive fallback. A group followed by one starting with the data of
+// a large object is merged with the group before it instead, when there is
+// one, so the large object keeps its group. The groups are planned without
+// the POSIX owner and group names, the headers can only grow, so the planned
+// size is a lower bound.
+func (c jobConfig) mergeSmallGroups(indexList []Index) []Index {
+	merged := make([]Index, 0, len(indexList))
+	for _, idx := range indexList {
+		if n := len(merged); n > 0 && int64(merged[n-1].Size) < c.padSize {
+			if !idx.DataFirst || n == 1 {
+				merged[n-1].End = idx.End
+				merged[n-1].Size += idx.Size
+				continue
+			}
+			merged[n-2].End = merged[n-1].End
+			merged[n-2].Size += merged[n-1].Size
+			merged = merged[:n-1]
+		}
+		merged = append(merged, idx)
+	}
+	return merged
+}
+
+func concatObjects(ctx context.Context, client Backend, trimFirstBytes int, objectList []*S3Obj, bucket, key string) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+	complete := NewS3Obj()
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: &bucket,
+		Key:    &key,
+		ACL:    types.ObjectCannedACLBucketOwnerFullControl,
+	}
+	cfg.encryptUpload(input)
+	output, err := client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return complete, err
+	}
+	var accumSize int64 = 0
+	uploadId := *output.UploadId
+	trackUpload(ctx, bucket, key, uploadId)
+	var parts []types.CompletedPart
+	var partErr error
+	m := sync.RWMutex{}
+	swg := sizedwaitgroup.New(cfg.threads)
+	var partCounter int32 = 0
+	for i, object := range objectList {
+		if ctx.Err() != nil {
+			// stop scheduling new parts
+			break
+		}
+		if len(object.Data) > 0 {
+			partCounter += 1
+			partNum := partCounter
+			accumSize += int64(len(object.Data))
+			input := &s3.UploadPartInput{
+				Bucket:     &bucket,
+				Key:        &key,
+				PartNumber: &partNum,
+				UploadId:   &uploadId,
+				Body:       io.ReadSeeker(bytes.NewReader(object.Data)),
+			}
+			swg.Add()
+			go func(input *s3.UploadPartInput) {
+				defer swg.Done()
+				Debugf(ctx, "UploadPart (bytes) into: %s/%s", *input.Bucket, *input.Key)
+				r, err := client.UploadPart(ctx, input)
+				if err != nil {
+					Debugf(ctx, "error for s3://%s/%s", *input.Bucket, *input.Key)
+					m.Lock()
+					partErr = err
+					m.Unlock()
+					return
+				}
+				m.Lock()
+				parts = append(parts, types.CompletedPart{
+					ETag:       r.ETag,
+					PartNumber: input.PartNumber})
+				m.Unlock()
+			}(input)
+		} else {
+			var start int64 = 0
+			if i == 0 && trimFirstBytes > 0 {
+				start = int64(trimFirstBytes)
+			}
+			accumSize += *object.Size - start
+			sourceKey := copySource(object)
+			// objects over 5GiB are copied in several parts
+			for _, rng := range splitCopyRange(start, *object.Size) {
+				partCounter += 1
+				partNum := partCounter
+				input := s3.UploadPartCopyInput{
+					Bucket:          &bucket,
+					Key:             &key,
+					PartNumber:      &partNum,
+					UploadId:        &uploadId,
+					CopySource:      aws.String(sourceKey),
+					CopySourceRange: aws.String(copySourceRange(object, rng[0], rng[1])),
+				}
+				swg.Add()
+				go func(object *S3Obj, input s3.UploadPartCopyInput) {
+					defer swg.Done()
+					Debugf(ctx, "UploadPartCopy (s3://%s/%s) into:\n\ts3://%s/%s", *input.Bucket, *input.Key, bucket, key)
+					r, err := client.UploadPartCopy(ctx, &input)
+					if err != nil {
+						Debugf(ctx, "error for s3://%s/%s", *input.Bucket, *input.Key)
+						m.Lock()
+						partErr = err
+						m.Unlock()
+						return
+					}
+					traceCall(ctx, object, TraceCall{Op: "UploadPartCopy", Bucket: bucket, Key: key, PartNumber: *input.PartNumber, Range: *input.CopySourceRange})
+					m.Lock()
+					parts = append(parts, types.CompletedPart{
+						ETag:       r.CopyPartResult.ETag,
+						PartNumber: input.PartNumber})
+					m.Unlock()
+				}(object, input)
+			}
+		}
+	}
+
+	swg.Wait()
+	if partErr == nil {
+		partErr = ctx.Err()
+	}
+	if partErr != nil {
+		return complete, partErr
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return *parts[i].PartNumber < *parts[j].PartNumber
+	})
+
+	completeOutput, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		return complete, err
+	}
+	untrackUpload(ctx, uploadId)
+	now := time.Now()
+	complete = &S3Obj{
+		Bucket: *completeOutput.Bucket,
+		Object: types.Object{
+			Key:          completeOutput.Key,
+			ETag:         completeOutput.ETag,
+			Size:         &accumSize,
+			LastModified: &now,
+		},
+	}
+	return complete, nil
+}
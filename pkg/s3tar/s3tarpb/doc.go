@@ -0,0 +1,8 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package s3tarpb is the gRPC API of s3tar serve, generated from s3tar.proto.
+// Clients in other languages generate their stubs from the same file.
+package s3tarpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative s3tar.proto
@@ -0,0 +1,119 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ArchiveJob is a single archive to be built as part of a multi-archive run.
+type ArchiveJob struct {
+	ObjectList []*S3Obj
+	Options    *S3TarS3Options
+}
+
+// SchedulerOptions controls how many archives are built at the same time and
+// how many goroutines all of them are allowed to use together.
+type SchedulerOptions struct {
+	// MaxConcurrentArchives is the number of archives being built at once.
+	MaxConcurrentArchives int
+	// TotalThreads is the global goroutine budget shared across all archives.
+	TotalThreads int
+}
+
+// RunArchiveJobs builds several archives concurrently. The global goroutine
+// budget is split evenly across the archives that are in flight, so the total
+// number of S3 requests in flight stays close to TotalThreads.
+func RunArchiveJobs(ctx context.Context, archiver Archiver, jobs []ArchiveJob, options SchedulerOptions, optFns ...func(*S3TarS3Options)) error {
+
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	concurrent := options.MaxConcurrentArchives
+	if concurrent < 1 {
+		concurrent = 1
+	}
+	if concurrent > len(jobs) {
+		concurrent = len(jobs)
+	}
+	totalThreads := options.TotalThreads
+	if totalThreads < 1 {
+		totalThreads = 100
+	}
+	threadsPerArchive := totalThreads / concurrent
+	if threadsPerArchive < 1 {
+		threadsPerArchive = 1
+	}
+
+	Infof(ctx, "building %d archives, %d at a time with %d goroutines each", len(jobs), concurrent, threadsPerArchive)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrent)
+	jobsQueued.Add(int64(len(jobs)))
+	for _, job := range jobs {
+		job := job
+		g.Go(func() error {
+			jobsQueued.Add(-1)
+			opts := job.Options.Copy()
+			opts.Threads = threadsPerArchive
+			Infof(gctx, "creating s3://%s/%s", opts.DstBucket, opts.DstKey)
+			if err := archiver.CreateFromList(gctx, job.ObjectList, &opts, optFns...); err != nil {
+				return fmt.Errorf("s3://%s/%s: %w", opts.DstBucket, opts.DstKey, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// ChunkedArchiveName returns the destination key of the n-th archive of a
+// chunked run, e.g. prefix/archive.00003.tar.
+func ChunkedArchiveName(key string, n int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s.%05d%s", strings.TrimSuffix(key, ext), n, ext)
+}
+
+// RunManifestChunks reads the manifest chunkSize objects at a time and builds
+// one archive per chunk, named with ChunkedArchiveName. At most
+// MaxConcurrentArchives chunks are held in memory at once.
+func RunManifestChunks(ctx context.Context, archiver Archiver, manifest *ManifestReader, chunkSize int, opts *S3TarS3Options, options SchedulerOptions, optFns ...func(*S3TarS3Options)) error {
+	if chunkSize < 1 {
+		return fmt.Errorf("chunk size must be greater than zero")
+	}
+	concurrent := options.MaxConcurrentArchives
+	if concurrent < 1 {
+		concurrent = 1
+	}
+
+	n := 0
+	for {
+		var jobs []ArchiveJob
+		for len(jobs) < concurrent {
+			chunk, estimatedSize, err := manifest.ReadChunk(chunkSize)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			jobOpts := opts.Copy()
+			jobOpts.DstKey = ChunkedArchiveName(opts.DstKey, n)
+			Infof(ctx, "chunk %d: %d objects, estimated tar size: %d", n, len(chunk), estimatedSize)
+			jobs = append(jobs, ArchiveJob{ObjectList: chunk, Options: &jobOpts})
+			n++
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+		if err := RunArchiveJobs(ctx, archiver, jobs, options, optFns...); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,469 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ArchiveEntry is an entry of an archive read by VerifyArchive.
+type ArchiveEntry struct {
+	Name string
+	// Offset is where the data of the entry starts in the archive.
+	Offset int64
+	Size   int64
+	// SHA256 is the hex encoded digest of the data of the entry.
+	SHA256   string
+	Linkname string
+}
+
+// VerifyArchive reads a whole tar archive from r with archive/tar, entry by
+// entry, and returns its entries. Every header has to be valid, the data of
+// every entry complete and the archive has to end with zero blocks. The TOC
+// at the front of an s3tar archive is checked against the entries: every
+// line has to locate the data of an entry of the same name and size.
+func VerifyArchive(r io.Reader) ([]ArchiveEntry, error) {
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	var entries []ArchiveEntry
+	var toc []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, fmt.Errorf("entry %d: %w", len(entries)+1, err)
+		}
+		e := ArchiveEntry{Name: hdr.Name, Offset: cr.n, Size: hdr.Size, Linkname: hdr.Linkname}
+		h := sha256.New()
+		w := io.Writer(h)
+		var buf bytes.Buffer
+		if len(entries) == 0 && hdr.Name == "toc.csv" {
+			w = io.MultiWriter(h, &buf)
+		}
+		n, err := io.Copy(w, tr)
+		if err != nil {
+			return entries, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		if n != hdr.Size {
+			return entries, fmt.Errorf("%s: read %d bytes, the header has %d", hdr.Name, n, hdr.Size)
+		}
+		if buf.Len() > 0 {
+			toc = buf.Bytes()
+		}
+		e.SHA256 = hex.EncodeToString(h.Sum(nil))
+		entries = append(entries, e)
+	}
+	// anything after the end of archive blocks has to be zeros too
+	if _, err := io.Copy(io.Discard, &zeroCheckReader{r: cr}); err != nil {
+		return entries, fmt.Errorf("after the end of archive: %w", err)
+	}
+	if toc != nil {
+		if err := verifyTOC(toc, entries); err != nil {
+			return entries, fmt.Errorf("toc.csv: %w", err)
+		}
+	}
+	return entries, nil
+}
+
+// verifyTOC checks every line of the TOC locates the data of an entry.
+func verifyTOC(toc []byte, entries []ArchiveEntry) error {
+	byName := make(map[string]ArchiveEntry, len(entries))
+	for _, e := range entries {
+		byName[e.Name] = e
+	}
+	r := csv.NewReader(bytes.NewReader(toc))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if len(record) < 3 {
+			return fmt.Errorf("invalid line %q", strings.Join(record, ","))
+		}
+		start, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid offset: %w", record[0], err)
+		}
+		size, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid size: %w", record[0], err)
+		}
+		e, ok := byName[record[0]]
+		if !ok {
+			return fmt.Errorf("%s isn't in the archive", record[0])
+		}
+		// hardlinks point at the data of their target
+		if e.Linkname != "" {
+			e = byName[e.Linkname]
+		}
+		if e.Offset != start || e.Size != size {
+			return fmt.Errorf("%s is at %d with %d bytes, the TOC has %d with %d bytes", record[0], e.Offset, e.Size, start, size)
+		}
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// zeroCheckReader fails on the first byte of r that isn't a zero.
+type zeroCheckReader struct {
+	r io.Reader
+}
+
+func (z *zeroCheckReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] != 0 {
+			return i, fmt.Errorf("unexpected data")
+		}
+	}
+	return n, err
+}
+
+// SelfTestOptions configures SelfTest.
+type SelfTestOptions struct {
+	// Bucket and Prefix are where the test objects and the archives are
+	// written, they are deleted once the test is done unless Keep is set.
+	Bucket string
+	Prefix string
+	Region string
+	// Formats are the tar formats tested, pax and gnu by default. USTAR
+	// headers can't hold the names of the test objects.
+	Formats []string
+	// PadSize is the minimum part size of the backend, 5MB on Amazon S3.
+	// The large objects are over it.
+	PadSize int64
+	// Bsdtar is the bsdtar command the archives are extracted with as well,
+	// looked up in PATH when empty. The check is skipped when it isn't found.
+	Bsdtar  string
+	Threads int
+	Keep    bool
+}
+
+// SelfTestResult is the result of a case of SelfTest in a format.
+type SelfTestResult struct {
+	Case    string `json:"case"`
+	Format  string `json:"format"`
+	Entries int    `json:"entries"`
+	Size    int64  `json:"size"`
+	// Bsdtar is set when the archive was extracted with bsdtar as well.
+	Bsdtar   bool          `json:"bsdtar"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// selfTestCase is a list of objects archived by SelfTest.
+type selfTestCase struct {
+	name     string
+	sizes    []int64
+	inMemory bool
+}
+
+// selfTestCases are the layouts of the archives SelfTest creates: only
+// small objects, only large ones, a mix of both and an archive built in
+// memory, with names that need PAX or GNU extended headers.
+func selfTestCases(padSize int64) []selfTestCase {
+	return []selfTestCase{
+		{name: "small", sizes: []int64{0, 1, 511, 512, 513, 1000, 4096, 70000}},
+		{name: "large", sizes: []int64{padSize, 2*padSize + 7}},
+		{name: "mixed", sizes: []int64{1000, padSize + 3, 10, 2 * padSize, 512}},
+		{name: "in-memory", sizes: []int64{100, 2000, 0, 30000}, inMemory: true},
+	}
+}
+
+// selfTestName is the name of object i of a case, some of them too long or
+// not ASCII for a plain USTAR header.
+func selfTestName(i int) string {
+	switch i % 4 {
+	case 1:
+		return fmt.Sprintf("%s%d.txt", strings.Repeat("directory/", 12), i)
+	case 2:
+		return fmt.Sprintf("données/ファイル-%d.txt", i)
+	default:
+		return fmt.Sprintf("files/%d.bin", i)
+	}
+}
+
+// SelfTest creates archives of generated objects in the bucket, reads every
+// archive back with archive/tar, see VerifyArchive, and with bsdtar when it
+// is installed, and compares every entry with the object it was created
+// from. It is meant to be run in an account before archiving at scale: the
+// archives are created by the same code, with the same backend and
+// credentials. A result is returned for every case and format, the error
+// is set when one of them failed.
+func SelfTest(ctx context.Context, backend Backend, opts SelfTestOptions) ([]SelfTestResult, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("bucket required")
+	}
+	if len(opts.Formats) == 0 {
+		opts.Formats = []string{"pax", "gnu"}
+	}
+	for _, format := range opts.Formats {
+		// the names of the test objects need PAX or GNU headers
+		if format != "pax" && format != "gnu" {
+			return nil, fmt.Errorf("the self-test supports the pax and gnu formats, not %s", format)
+		}
+	}
+	if opts.PadSize == 0 {
+		opts.PadSize = defaultPadSize
+	}
+	bsdtar := opts.Bsdtar
+	if bsdtar == "" {
+		bsdtar = "bsdtar"
+	}
+	bsdtar, err := exec.LookPath(bsdtar)
+	if err != nil {
+		Warnf(ctx, "bsdtar not found, the archives are only read with archive/tar: %s", err)
+		bsdtar = ""
+	}
+	prefix := strings.TrimSuffix(opts.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	prefix += fmt.Sprintf("s3tar-self-test-%d/", time.Now().UnixNano())
+	if !opts.Keep {
+		defer func() {
+			if err := deletePrefix(context.WithoutCancel(ctx), backend, opts.Bucket, prefix); err != nil {
+				Warnf(ctx, "unable to delete s3://%s/%s: %s", opts.Bucket, prefix, err)
+			}
+		}()
+	}
+
+	var results []SelfTestResult
+	failed := 0
+	rnd := rand.New(rand.NewSource(1))
+	for _, tc := range selfTestCases(opts.PadSize) {
+		objectList, digests, err := putSelfTestObjects(ctx, backend, opts.Bucket, prefix+"src/"+tc.name+"/", tc.sizes, rnd)
+		if err != nil {
+			return results, err
+		}
+		for _, format := range opts.Formats {
+			start := time.Now()
+			res := SelfTestResult{Case: tc.name, Format: format}
+			key := fmt.Sprintf("%sarchives/%s.%s.tar", prefix, tc.name, format)
+			err := selfTestArchive(ctx, backend, objectList, digests, key, bsdtar, tc, format, opts, &res)
+			if err != nil {
+				res.Error = err.Error()
+				failed++
+			}
+			res.Duration = time.Since(start)
+			results = append(results, res)
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d self-test archives failed", failed, len(results))
+	}
+	return results, nil
+}
+
+// putSelfTestObjects writes objects of the given sizes under prefix and
+// returns them with the digest of their data by entry name.
+func putSelfTestObjects(ctx context.Context, backend Backend, bucket, prefix string, sizes []int64, rnd *rand.Rand) ([]*S3Obj, map[string]string, error) {
+	var objectList []*S3Obj
+	digests := map[string]string{}
+	for i, size := range sizes {
+		data := make([]byte, size)
+		rnd.Read(data)
+		key := prefix + selfTestName(i)
+		output, err := backend.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to write s3://%s/%s: %w", bucket, key, err)
+		}
+		o := NewS3ObjOptions(WithBucketAndKey(bucket, key), WithSize(size), WithETag(aws.ToString(output.ETag)))
+		o.LastModified = aws.Time(time.Now())
+		objectList = append(objectList, o)
+		sum := sha256.Sum256(data)
+		digests[key] = hex.EncodeToString(sum[:])
+	}
+	return objectList, digests, nil
+}
+
+// selfTestArchive creates the archive key of objectList and checks it.
+func selfTestArchive(ctx context.Context, backend Backend, objectList []*S3Obj, digests map[string]string, key, bsdtar string, tc selfTestCase, format string, opts SelfTestOptions, res *SelfTestResult) error {
+	archiveOpts := &S3TarS3Options{
+		SrcBucket:      opts.Bucket,
+		DstBucket:      opts.Bucket,
+		DstKey:         key,
+		DstPrefix:      KeyDir(key),
+		Region:         opts.Region,
+		Threads:        opts.Threads,
+		PadSize:        opts.PadSize,
+		ConcatInMemory: tc.inMemory,
+	}
+	// every case gets its own copy, the archive options change the list
+	list := make([]*S3Obj, len(objectList))
+	for i, o := range objectList {
+		c := *o
+		list[i] = &c
+	}
+	if err := CreateFromListWithBackend(ctx, backend, list, archiveOpts, WithTarFormat(format)); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	output, err := backend.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(opts.Bucket), Key: aws.String(key)})
+	if err != nil {
+		return err
+	}
+	res.Size = aws.ToInt64(output.ContentLength)
+	entries, err := VerifyArchive(output.Body)
+	output.Body.Close()
+	if err != nil {
+		return fmt.Errorf("archive/tar: %w", err)
+	}
+	res.Entries = len(entries)
+	if err := compareSelfTestEntries(entries, digests); err != nil {
+		return fmt.Errorf("archive/tar: %w", err)
+	}
+
+	if bsdtar == "" {
+		return nil
+	}
+	output, err = backend.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(opts.Bucket), Key: aws.String(key)})
+	if err != nil {
+		return err
+	}
+	defer output.Body.Close()
+	if err := bsdtarExtract(ctx, bsdtar, output.Body, digests); err != nil {
+		return fmt.Errorf("bsdtar: %w", err)
+	}
+	res.Bsdtar = true
+	return nil
+}
+
+// compareSelfTestEntries checks the archive has an entry with the data of
+// every object, the TOC aside.
+func compareSelfTestEntries(entries []ArchiveEntry, digests map[string]string) error {
+	seen := 0
+	for _, e := range entries {
+		if e.Name == "toc.csv" {
+			continue
+		}
+		want, ok := digests[e.Name]
+		if !ok {
+			return fmt.Errorf("unexpected entry %s", e.Name)
+		}
+		if e.SHA256 != want {
+			return fmt.Errorf("%s: sha256 %s, want %s", e.Name, e.SHA256, want)
+		}
+		seen++
+	}
+	if seen != len(digests) {
+		return fmt.Errorf("%d entries, want %d", seen, len(digests))
+	}
+	return nil
+}
+
+// bsdtarExtract extracts the archive read from r with bsdtar in a temporary
+// directory and compares the extracted files with digests.
+func bsdtarExtract(ctx context.Context, bsdtar string, r io.Reader, digests map[string]string) error {
+	dir, err := os.MkdirTemp("", "s3tar-self-test")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bsdtar, "-xf", "-", "-C", dir)
+	cmd.Stdin = r
+	cmd.Stderr = &stderr
+	// bsdtar refuses the UTF-8 names of PAX headers in the C locale
+	cmd.Env = os.Environ()
+	if !strings.Contains(strings.ToUpper(os.Getenv("LC_ALL")+os.Getenv("LC_CTYPE")+os.Getenv("LANG")), "UTF-8") {
+		cmd.Env = append(cmd.Env, "LC_ALL=C.UTF-8")
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	for name, want := range digests {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path.Clean(name))))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("%s wasn't extracted", name)
+			}
+			return err
+		}
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			return fmt.Errorf("%s: sha256 %s, want %s", name, got, want)
+		}
+	}
+	return nil
+}
+
+// deletePrefix deletes the objects under prefix.
+func deletePrefix(ctx context.Context, backend Backend, bucket, prefix string) error {
+	objectList, _, err := listAllObjects(ctx, backend, bucket, prefix)
+	if err != nil || len(objectList) == 0 {
+		return err
+	}
+	return deleteObjectList(ctx, backend, objectList)
+}
+
+// WriteSelfTestReport writes a line per result of SelfTest to w.
+func WriteSelfTestReport(w io.Writer, results []SelfTestResult) error {
+	var buf bytes.Buffer
+	for _, r := range results {
+		status := "ok"
+		if r.Error != "" {
+			status = "FAIL"
+		}
+		checks := "archive/tar"
+		if r.Bsdtar {
+			checks += ", bsdtar"
+		}
+		fmt.Fprintf(&buf, "%-4s %-10s %-6s %3d entries %10s  %s  (%s)\n", status, r.Case, r.Format, r.Entries, formatBytes(r.Size), r.Duration.Round(time.Millisecond), checks)
+		if r.Error != "" {
+			fmt.Fprintf(&buf, "     %s\n", r.Error)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteSelfTestJSON writes the results of SelfTest as JSON to w.
+func WriteSelfTestJSON(w io.Writer, results []SelfTestResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
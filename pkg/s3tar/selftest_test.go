@@ -0,0 +1,143 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTar returns a tar archive of files, a toc.csv entry first when toc is
+// set, the way s3tar lays it out.
+func testTar(t *testing.T, files []string, toc func(offsets []int64) string) []byte {
+	t.Helper()
+	build := func(tocData string) ([]byte, []int64) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		var offsets []int64
+		write := func(name, data string) {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Flush()
+			offsets = append(offsets, int64(buf.Len()))
+			tw.Write([]byte(data))
+		}
+		if toc != nil {
+			write("toc.csv", tocData)
+		}
+		for i, f := range files {
+			write(fmt.Sprintf("file%d", i), f)
+		}
+		tw.Close()
+		return buf.Bytes(), offsets
+	}
+	if toc == nil {
+		data, _ := build("")
+		return data
+	}
+	// the offsets depend on the size of the TOC, which only depends on the
+	// length of the lines
+	_, offsets := build(toc(make([]int64, len(files)+1)))
+	data, _ := build(toc(offsets))
+	return data
+}
+
+func TestVerifyArchive(t *testing.T) {
+
+	files := []string{"first", strings.Repeat("x", 600), ""}
+	tocLines := func(offsets []int64) string {
+		var lines strings.Builder
+		for i, f := range files {
+			fmt.Fprintf(&lines, "file%d,%08d,%d,etag\n", i, offsets[i+1], len(f))
+		}
+		return lines.String()
+	}
+	valid := testTar(t, files, tocLines)
+	entries, err := VerifyArchive(bytes.NewReader(valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[1].Name != "file0" || entries[2].Size != 600 {
+		t.Fatalf("entries = %+v", entries)
+	}
+
+	wrongTOC := testTar(t, files, func(offsets []int64) string {
+		offsets[2] += 512
+		return tocLines(offsets)
+	})
+	trailing := append(append([]byte{}, valid...), 'x')
+	corrupt := append([]byte{}, valid...)
+	corrupt[0] ^= 0xff
+	tests := map[string][]byte{
+		"truncated":  valid[:len(valid)-1536],
+		"trailing":   trailing,
+		"checksum":   corrupt,
+		"wrong toc":  wrongTOC,
+		"no entries": nil,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			entries, err := VerifyArchive(bytes.NewReader(data))
+			if name == "no entries" {
+				if err != nil || len(entries) != 0 {
+					t.Errorf("VerifyArchive() = %v, %v", entries, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Errorf("VerifyArchive() error = nil")
+			}
+		})
+	}
+
+	plain := testTar(t, files, nil)
+	if entries, err := VerifyArchive(bytes.NewReader(plain)); err != nil || len(entries) != 3 {
+		t.Errorf("VerifyArchive() = %d entries, %v", len(entries), err)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+
+	ctx := context.Background()
+	m := NewMemoryBackend()
+	var pad int64 = 64 * 1024
+	m.MinPartSize = pad
+	results, err := SelfTest(ctx, m, SelfTestOptions{
+		Bucket:  "test-bucket",
+		Prefix:  "self-test/",
+		Region:  "us-east-1",
+		PadSize: pad,
+		Threads: 4,
+	})
+	if err != nil {
+		var buf bytes.Buffer
+		WriteSelfTestReport(&buf, results)
+		t.Fatalf("%s\n%s", err, buf.String())
+	}
+	if len(results) != 2*len(selfTestCases(pad)) {
+		t.Fatalf("%d results", len(results))
+	}
+	_, lookErr := exec.LookPath("bsdtar")
+	for _, r := range results {
+		if r.Entries == 0 || r.Size == 0 || r.Error != "" {
+			t.Errorf("%+v", r)
+		}
+		if r.Bsdtar != (lookErr == nil) {
+			t.Errorf("%s %s: bsdtar = %v, installed %v", r.Case, r.Format, r.Bsdtar, lookErr == nil)
+		}
+	}
+	if keys := m.Keys("test-bucket", ""); len(keys) > 0 {
+		t.Errorf("the test objects weren't deleted: %v", keys)
+	}
+	if _, err := SelfTest(ctx, m, SelfTestOptions{Bucket: "test-bucket", Formats: []string{"ustar"}}); err == nil {
+		t.Errorf("SelfTest() with ustar error = nil")
+	}
+}
@@ -0,0 +1,430 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job types accepted by the job server.
+const (
+	JobTypeCreate  = "create"
+	JobTypeExtract = "extract"
+)
+
+// Job states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// JobRequest describes an archive or extract job.
+type JobRequest struct {
+	Type string `json:"type"`
+	// Archive is the s3:// url of the archive to create or extract.
+	Archive string `json:"archive"`
+	// Source is the s3://bucket/prefix to archive. Either Source or Manifest
+	// is required to create an archive.
+	Source   string `json:"source,omitempty"`
+	Manifest string `json:"manifest,omitempty"`
+	// Destination is the s3://bucket/prefix/ to extract to.
+	Destination string `json:"destination,omitempty"`
+	// Prefix only extracts the entries that start with it.
+	Prefix         string `json:"prefix,omitempty"`
+	Format         string `json:"format,omitempty"`
+	StorageClass   string `json:"storageClass,omitempty"`
+	ConcatInMemory bool   `json:"concatInMemory,omitempty"`
+	// Overwrite replaces the archive if it already exists.
+	Overwrite bool `json:"overwrite,omitempty"`
+}
+
+// Job is the status of a submitted JobRequest.
+type Job struct {
+	ID      string     `json:"id"`
+	Request JobRequest `json:"request"`
+	Status  string     `json:"status"`
+	// Tenant is the tenant the job counts against, see TenantLimits.
+	Tenant     string     `json:"tenant,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Progress is the progress of a running create job.
+	Progress *JobProgress `json:"progress,omitempty"`
+
+	cancel    context.CancelFunc
+	heartbeat *heartbeat
+}
+
+// JobProgress is the progress of a running create job, the stage and the
+// entries of its HeartbeatStatus.
+type JobProgress struct {
+	Stage       string  `json:"stage"`
+	Percent     float64 `json:"percent"`
+	Entries     int     `json:"entries"`
+	EntriesDone int     `json:"entriesDone"`
+}
+
+// snapshot returns a copy of the job with its progress. Call with s.mu held.
+func (job *Job) snapshot() Job {
+	j := *job
+	if job.Status == JobRunning && job.heartbeat != nil {
+		job.heartbeat.mu.Lock()
+		st := job.heartbeat.status
+		job.heartbeat.mu.Unlock()
+		j.Progress = &JobProgress{Stage: st.Stage, Percent: st.Percent, Entries: st.Entries, EntriesDone: st.EntriesDone}
+	}
+	return j
+}
+
+// ServerOptions configures a JobServer.
+type ServerOptions struct {
+	// Workers is the number of jobs running at the same time.
+	Workers int
+	// QueueSize is the number of jobs waiting for a worker. Submitting a job
+	// when the queue is full fails.
+	QueueSize int
+	// Tenants limits the jobs and the requests of every tenant, the
+	// tenants take turns in the queue.
+	Tenants TenantLimits
+	// JobRetention is how long a finished job is kept, for its status to be
+	// read, before it is removed. Defaults to an hour.
+	JobRetention time.Duration
+	// Options are the defaults of every job, e.g. Region and Threads.
+	Options S3TarS3Options
+}
+
+// JobServer runs archive and extract jobs submitted over HTTP with a bounded
+// worker pool.
+type JobServer struct {
+	archiver Archiver
+	options  ServerOptions
+	tenants  *tenants
+	queue    *jobQueue
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	pause *PauseControl
+}
+
+// NewJobServer returns a JobServer. Call Start to run the workers.
+func NewJobServer(archiver Archiver, options ServerOptions) *JobServer {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.QueueSize < 1 {
+		options.QueueSize = 100
+	}
+	if options.JobRetention <= 0 {
+		options.JobRetention = time.Hour
+	}
+	t := newTenants(options.Tenants)
+	return &JobServer{
+		archiver: archiver,
+		options:  options,
+		tenants:  t,
+		queue:    newJobQueue(t, options.QueueSize),
+		jobs:     map[string]*Job{},
+		pause:    NewPauseControl(),
+	}
+}
+
+// PauseControl pauses and resumes the running jobs of the server.
+func (s *JobServer) PauseControl() *PauseControl {
+	return s.pause
+}
+
+// Start runs the workers until ctx is done.
+func (s *JobServer) Start(ctx context.Context) {
+	for i := 0; i < s.options.Workers; i++ {
+		go s.worker(ctx)
+	}
+}
+
+func (s *JobServer) worker(ctx context.Context) {
+	for {
+		job := s.queue.next(ctx)
+		if job == nil {
+			return
+		}
+		jobsQueued.Add(-1)
+		s.run(ctx, job)
+		s.queue.done(job)
+	}
+}
+
+func (s *JobServer) run(ctx context.Context, job *Job) {
+	s.mu.Lock()
+	if job.Status == JobCanceled {
+		s.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(WithPauseControl(s.tenants.withRequestRate(ctx, job.Tenant), s.pause))
+	defer cancel()
+	if job.Request.Type == JobTypeCreate {
+		// the archive reports its stage and entries to it
+		job.heartbeat = &heartbeat{status: HeartbeatStatus{Stage: HeartbeatPreparing}}
+		ctx = context.WithValue(ctx, contextKeyHeartbeat, job.heartbeat)
+	}
+	now := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	job.cancel = cancel
+	s.mu.Unlock()
+
+	Infof(ctx, "job %s: %s %s (tenant %s)", job.ID, job.Request.Type, job.Request.Archive, job.Tenant)
+	err := runJobRequest(ctx, s.archiver, s.options.Options, job.Request)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.cancel = nil
+	switch {
+	case job.Status == JobCanceled:
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+		Warnf(ctx, "job %s failed: %s", job.ID, err.Error())
+	default:
+		job.Status = JobSucceeded
+	}
+}
+
+// runJobRequest runs a job with defaults as the base options.
+func runJobRequest(ctx context.Context, archiver Archiver, defaults S3TarS3Options, req JobRequest) error {
+	opts := defaults.Copy()
+	switch req.Type {
+	case JobTypeCreate:
+		opts.DstBucket, opts.DstKey = ExtractBucketAndPath(req.Archive)
+		opts.DstPrefix = KeyDir(opts.DstKey)
+		opts.SrcManifest = req.Manifest
+		if req.Source != "" {
+			opts.SrcBucket, opts.SrcPrefix = ExtractBucketAndPath(req.Source)
+		}
+		opts.ConcatInMemory = req.ConcatInMemory
+		opts.Overwrite = opts.Overwrite || req.Overwrite
+		return archiver.Create(ctx, &opts, WithTarFormat(req.Format), WithStorageClass(req.StorageClass))
+	case JobTypeExtract:
+		opts.SrcBucket, opts.SrcKey = ExtractBucketAndPath(req.Archive)
+		opts.SrcPrefix = KeyDir(opts.SrcKey)
+		opts.DstBucket, opts.DstKey = ExtractBucketAndPath(req.Destination)
+		opts.DstPrefix = KeyDir(opts.DstKey)
+		return archiver.Extract(ctx, &opts, WithExtractPrefix(req.Prefix))
+	}
+	return fmt.Errorf("unknown job type %q", req.Type)
+}
+
+func checkJobRequest(req *JobRequest) error {
+	if !strings.HasPrefix(req.Archive, "s3://") {
+		return fmt.Errorf("archive must be an s3:// url")
+	}
+	switch req.Type {
+	case JobTypeCreate:
+		if req.Source == "" && req.Manifest == "" {
+			return fmt.Errorf("source or manifest is required")
+		}
+		if req.Source != "" && !strings.HasPrefix(req.Source, "s3://") {
+			return fmt.Errorf("source must be an s3:// url")
+		}
+		// a local path would be read from the disk of the server
+		if req.Manifest != "" && !strings.HasPrefix(req.Manifest, "s3://") {
+			return fmt.Errorf("manifest must be an s3:// url")
+		}
+		if err := checkTarFormat(req.Format); err != nil {
+			return err
+		}
+		if req.StorageClass == "" {
+			req.StorageClass = "STANDARD"
+		}
+	case JobTypeExtract:
+		if !strings.HasPrefix(req.Destination, "s3://") {
+			return fmt.Errorf("destination must be an s3:// url")
+		}
+		if !strings.HasSuffix(req.Destination, "/") {
+			req.Destination += "/"
+		}
+	default:
+		return fmt.Errorf("type must be %s or %s", JobTypeCreate, JobTypeExtract)
+	}
+	return nil
+}
+
+// Submit queues a job and returns it.
+func (s *JobServer) Submit(req JobRequest) (*Job, error) {
+	if err := checkJobRequest(&req); err != nil {
+		return nil, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{ID: id, Request: req, Status: JobQueued, Tenant: s.options.Tenants.TenantOf(req), CreatedAt: time.Now()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictFinished(job.CreatedAt)
+	if !s.queue.push(job) {
+		return nil, errQueueFull
+	}
+	jobsQueued.Add(1)
+	s.jobs[job.ID] = job
+	return job, nil
+}
+
+var errQueueFull = fmt.Errorf("job queue is full")
+
+// evictFinished removes the jobs finished more than JobRetention before now.
+// Call with s.mu held.
+func (s *JobServer) evictFinished(now time.Time) {
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > s.options.JobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// Cancel cancels a queued or running job.
+func (s *JobServer) Cancel(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	switch job.Status {
+	case JobQueued:
+		// the worker skips it when it comes out of the queue
+		job.Status = JobCanceled
+		now := time.Now()
+		job.FinishedAt = &now
+	case JobRunning:
+		job.Status = JobCanceled
+		job.cancel()
+	default:
+		return nil, fmt.Errorf("job %s already %s", id, job.Status)
+	}
+	return job, nil
+}
+
+// Job returns a copy of a job or nil if it doesn't exist.
+func (s *JobServer) Job(id string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		j := job.snapshot()
+		return &j
+	}
+	return nil
+}
+
+// Jobs returns a copy of all the jobs, oldest first.
+func (s *JobServer) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Handler returns the HTTP API of the server:
+//
+//	POST   /jobs       submit a JobRequest
+//	GET    /jobs       list the jobs
+//	GET    /jobs/{id}  get the status of a job
+//	DELETE /jobs/{id}  cancel a job
+//	POST   /pause      hold the S3 requests of the running jobs
+//	POST   /resume     release them
+//	GET    /metrics    Prometheus metrics
+func (s *JobServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+	pause := func(paused bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if paused {
+				s.pause.Pause()
+			} else {
+				s.pause.Resume()
+			}
+			writeJSON(w, http.StatusOK, map[string]bool{"paused": s.pause.Paused()})
+		}
+	}
+	mux.HandleFunc("/pause", pause(true))
+	mux.HandleFunc("/resume", pause(false))
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.Jobs())
+		case http.MethodPost:
+			var req JobRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			job, err := s.Submit(req)
+			if err == errQueueFull {
+				writeError(w, http.StatusServiceUnavailable, err)
+				return
+			} else if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, s.Job(job.ID))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		switch r.Method {
+		case http.MethodGet:
+			job := s.Job(id)
+			if job == nil {
+				writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+		case http.MethodDelete:
+			job, err := s.Cancel(id)
+			if err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			if job == nil {
+				writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+				return
+			}
+			writeJSON(w, http.StatusOK, s.Job(id))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
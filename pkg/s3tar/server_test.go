@@ -0,0 +1,157 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingArchiver blocks every job until its context is canceled or release
+// is closed.
+type blockingArchiver struct {
+	release chan struct{}
+	started chan *S3TarS3Options
+}
+
+func (b *blockingArchiver) wait(ctx context.Context, opts *S3TarS3Options) error {
+	b.started <- opts
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.release:
+		return nil
+	}
+}
+
+func (b *blockingArchiver) Create(ctx context.Context, opts *S3TarS3Options, _ ...func(*S3TarS3Options)) error {
+	return b.wait(ctx, opts)
+}
+
+func (b *blockingArchiver) CreateFromList(ctx context.Context, _ []*S3Obj, opts *S3TarS3Options, _ ...func(*S3TarS3Options)) error {
+	return b.wait(ctx, opts)
+}
+
+func (b *blockingArchiver) Extract(ctx context.Context, opts *S3TarS3Options, _ ...func(*S3TarS3Options)) error {
+	return b.wait(ctx, opts)
+}
+
+func (b *blockingArchiver) List(context.Context, string, *S3TarS3Options, ...func(*S3TarS3Options)) (TOC, error) {
+	return TOC{}, nil
+}
+
+func waitForStatus(t *testing.T, s *JobServer, id, status string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if s.Job(id).Status == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s is %s, want %s", id, s.Job(id).Status, status)
+}
+
+func TestJobServer(t *testing.T) {
+	archiver := &blockingArchiver{release: make(chan struct{}), started: make(chan *S3TarS3Options, 10)}
+	s := NewJobServer(archiver, ServerOptions{Workers: 1, QueueSize: 2, Options: S3TarS3Options{Region: "us-west-2"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	submit := func(req JobRequest) (*http.Response, Job) {
+		body, _ := json.Marshal(req)
+		res, err := http.Post(srv.URL+"/jobs", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var job Job
+		json.NewDecoder(res.Body).Decode(&job)
+		return res, job
+	}
+
+	for name, req := range map[string]JobRequest{
+		"missing source":   {Type: JobTypeCreate, Archive: "s3://bucket/a.tar"},
+		"local manifest":   {Type: JobTypeCreate, Archive: "s3://bucket/a.tar", Manifest: "/etc/passwd"},
+		"unknown format":   {Type: JobTypeCreate, Archive: "s3://bucket/a.tar", Source: "s3://bucket/src/", Format: "cpio"},
+		"local source":     {Type: JobTypeCreate, Archive: "s3://bucket/a.tar", Source: "bucket/src/"},
+		"local extract to": {Type: JobTypeExtract, Archive: "s3://bucket/a.tar", Destination: "/tmp/"},
+	} {
+		if res, _ := submit(req); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, res.StatusCode)
+		}
+	}
+
+	res, first := submit(JobRequest{Type: JobTypeCreate, Archive: "s3://bucket/a.tar", Source: "s3://bucket/src/"})
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("submit: status %d, want 202", res.StatusCode)
+	}
+	opts := <-archiver.started
+	if opts.DstBucket != "bucket" || opts.DstKey != "a.tar" || opts.SrcPrefix != "src/" || opts.Region != "us-west-2" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	waitForStatus(t, s, first.ID, JobRunning)
+
+	// the worker is busy, the next job waits in the queue and is canceled there
+	_, second := submit(JobRequest{Type: JobTypeExtract, Archive: "s3://bucket/a.tar", Destination: "s3://bucket/out"})
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/jobs/"+second.ID, nil)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("cancel queued job: %v %v", err, res)
+	}
+
+	// cancel the running job
+	req, _ = http.NewRequest(http.MethodDelete, srv.URL+"/jobs/"+first.ID, nil)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("cancel running job: %v %v", err, res)
+	}
+	waitForStatus(t, s, first.ID, JobCanceled)
+	if s.Job(first.ID).FinishedAt == nil {
+		t.Errorf("canceled job has no finish time")
+	}
+
+	_, third := submit(JobRequest{Type: JobTypeExtract, Archive: "s3://bucket/a.tar", Destination: "s3://bucket/out"})
+	opts = <-archiver.started
+	if opts.DstKey != "out/" {
+		t.Errorf("extract destination %s, want out/", opts.DstKey)
+	}
+	close(archiver.release)
+	waitForStatus(t, s, third.ID, JobSucceeded)
+	if s.Job(second.ID).Status != JobCanceled {
+		t.Errorf("queued job ran after being canceled")
+	}
+
+	res, err := http.Get(srv.URL + "/jobs/unknown")
+	if err != nil || res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job: %v %v", err, res)
+	}
+}
+
+func TestJobServerEvictsFinishedJobs(t *testing.T) {
+	s := NewJobServer(&blockingArchiver{}, ServerOptions{JobRetention: time.Minute})
+	finished := time.Now().Add(-2 * time.Minute)
+	s.jobs["old"] = &Job{ID: "old", Status: JobSucceeded, FinishedAt: &finished}
+	recent := time.Now()
+	s.jobs["recent"] = &Job{ID: "recent", Status: JobFailed, FinishedAt: &recent}
+	s.jobs["running"] = &Job{ID: "running", Status: JobRunning}
+
+	job, err := s.Submit(JobRequest{Type: JobTypeCreate, Archive: "s3://bucket/a.tar", Source: "s3://bucket/src/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Job("old") != nil {
+		t.Errorf("the job finished before the retention wasn't removed")
+	}
+	for _, id := range []string{"recent", "running", job.ID} {
+		if s.Job(id) == nil {
+			t.Errorf("job %s was removed", id)
+		}
+	}
+}
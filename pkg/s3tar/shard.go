@@ -0,0 +1,26 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"hash/fnv"
+)
+
+// ShardByHash splits objectList into n lists by the FNV-1a hash of the entry
+// names. BreakUpList keeps the order of the list, so the objects of a prefix
+// with large files end up in the same archive. Hashing gives every archive a
+// mix of small and large objects from across the list, the archives are
+// close in size and take as long to restore in parallel. An object always
+// lands in the same shard, whatever the other objects are. Some lists may be
+// empty when there are few objects.
+func ShardByHash(objectList []*S3Obj, n int) [][]*S3Obj {
+	shards := make([][]*S3Obj, n)
+	for _, o := range objectList {
+		h := fnv.New32a()
+		h.Write([]byte(o.entryName()))
+		i := h.Sum32() % uint32(n)
+		shards[i] = append(shards[i], o)
+	}
+	return shards
+}
@@ -0,0 +1,56 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardByHash(t *testing.T) {
+	var objectList []*S3Obj
+	var total int64
+	// the large objects are all under the same prefix
+	for i := 0; i < 4000; i++ {
+		size := int64(1000)
+		if i < 1000 {
+			size = 100000
+		}
+		total += size
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("bucket", fmt.Sprintf("dir/%05d.bin", i)), WithSize(size)))
+	}
+
+	shards := ShardByHash(objectList, 4)
+	if len(shards) != 4 {
+		t.Fatalf("got %d shards, want 4", len(shards))
+	}
+	count := 0
+	for i, shard := range shards {
+		var size int64
+		for _, o := range shard {
+			size += *o.Size
+		}
+		count += len(shard)
+		// within 10% of an even split
+		if even := total / 4; size < even*9/10 || size > even*11/10 {
+			t.Errorf("shard %d is %d bytes, an even split is %d", i, size, even)
+		}
+	}
+	if count != len(objectList) {
+		t.Errorf("got %d objects in the shards, want %d", count, len(objectList))
+	}
+
+	again := ShardByHash(objectList[:10], 4)
+	for i, shard := range again {
+		for _, o := range shard {
+			found := false
+			for _, p := range shards[i] {
+				found = found || p == o
+			}
+			if !found {
+				t.Errorf("%s moved to another shard", *o.Key)
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// metadataSidecarSuffix is appended to the key of an object to name its
+// sidecar entry.
+const metadataSidecarSuffix = ".metadata.json"
+
+// ObjectMetadata is the content of a .metadata.json sidecar. It keeps the S3
+// metadata of an object for extractors that can't read PAX records.
+type ObjectMetadata struct {
+	Key                string            `json:"key"`
+	VersionId          string            `json:"versionId,omitempty"`
+	ContentType        string            `json:"contentType,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+	ACL                *ACLSummary       `json:"acl,omitempty"`
+}
+
+// ACLSummary is the owner and the grants of an object ACL. Grants are
+// "grantee:permission", the grantee is a canonical id, an email or a group URI.
+type ACLSummary struct {
+	Owner  string   `json:"owner,omitempty"`
+	Grants []string `json:"grants,omitempty"`
+}
+
+// addMetadataSidecars returns objectList with a .metadata.json entry after
+// every object. Hardlinks and generated entries don't get a sidecar.
+func addMetadataSidecars(ctx context.Context, backend Backend, objectList []*S3Obj, threads int) ([]*S3Obj, error) {
+	svc, ok := backend.(metadataBackend)
+	if !ok {
+		return nil, fmt.Errorf("the backend can't read the tags and ACL of the objects for the metadata sidecars")
+	}
+	Infof(ctx, "fetching metadata for the sidecars")
+	sidecars := make([]*S3Obj, len(objectList))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for i, o := range objectList {
+		i, o := i, o
+		if o.LinkTarget != "" || len(o.Data) > 0 {
+			continue
+		}
+		g.Go(func() error {
+			m, err := fetchObjectMetadata(gctx, svc, o)
+			if err != nil {
+				return fmt.Errorf("unable to get the metadata of s3://%s/%s: %w", o.Bucket, *o.Key, err)
+			}
+			sidecars[i], err = metadataSidecar(o, m)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	withSidecars := make([]*S3Obj, 0, 2*len(objectList))
+	for i, o := range objectList {
+		withSidecars = append(withSidecars, o)
+		if sidecars[i] != nil {
+			withSidecars = append(withSidecars, sidecars[i])
+		}
+	}
+	return withSidecars, nil
+}
+
+// fetchObjectMetadata gets the headers, tags and ACL of an object.
+func fetchObjectMetadata(ctx context.Context, svc metadataBackend, obj *S3Obj) (*ObjectMetadata, error) {
+	var versionId *string
+	if obj.VersionId != "" {
+		versionId = aws.String(obj.VersionId)
+	}
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(obj.Bucket),
+		Key:       obj.Key,
+		VersionId: versionId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tagging, err := svc.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket:    aws.String(obj.Bucket),
+		Key:       obj.Key,
+		VersionId: versionId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	acl, err := svc.GetObjectAcl(ctx, &s3.GetObjectAclInput{
+		Bucket:    aws.String(obj.Bucket),
+		Key:       obj.Key,
+		VersionId: versionId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := &ObjectMetadata{
+		Key:                *obj.Key,
+		VersionId:          obj.VersionId,
+		ContentType:        aws.ToString(head.ContentType),
+		ContentEncoding:    aws.ToString(head.ContentEncoding),
+		ContentDisposition: aws.ToString(head.ContentDisposition),
+		ContentLanguage:    aws.ToString(head.ContentLanguage),
+		CacheControl:       aws.ToString(head.CacheControl),
+		Metadata:           head.Metadata,
+		ACL:                summarizeACL(acl.Owner, acl.Grants),
+	}
+	if len(tagging.TagSet) > 0 {
+		m.Tags = make(map[string]string, len(tagging.TagSet))
+		for _, t := range tagging.TagSet {
+			m.Tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		}
+	}
+	return m, nil
+}
+
+func summarizeACL(owner *types.Owner, grants []types.Grant) *ACLSummary {
+	summary := &ACLSummary{}
+	if owner != nil {
+		summary.Owner = aws.ToString(owner.ID)
+	}
+	for _, g := range grants {
+		if g.Grantee == nil {
+			continue
+		}
+		grantee := aws.ToString(g.Grantee.ID)
+		if g.Grantee.URI != nil {
+			grantee = *g.Grantee.URI
+		} else if g.Grantee.EmailAddress != nil {
+			grantee = *g.Grantee.EmailAddress
+		}
+		summary.Grants = append(summary.Grants, fmt.Sprintf("%s:%s", grantee, g.Permission))
+	}
+	sort.Strings(summary.Grants)
+	return summary
+}
+
+// metadataSidecar returns the .metadata.json entry of obj.
+func metadataSidecar(obj *S3Obj, m *ObjectMetadata) (*S3Obj, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	sidecar := NewS3ObjOptions(WithBucketAndKey(obj.Bucket, obj.entryName()+metadataSidecarSuffix))
+	sidecar.LastModified = obj.LastModified
+	sidecar.AddData(append(data, '\n'))
+	return sidecar, nil
+}
+
+// paxXattrMimeType is the PAX record of the xattr GNU tar --xattrs stores the
+// freedesktop mime type in, also written by s3tar for a user.mime_type
+// xattr of the manifest.
+const paxXattrMimeType = "SCHILY.xattr.user.mime_type"
+
+// paxObjectMetadata returns the S3 metadata found in the PAX records of an
+// entry, or nil if there is none.
+func paxObjectMetadata(records map[string]string) *ObjectMetadata {
+	if records[paxXattrMimeType] == "" {
+		return nil
+	}
+	return &ObjectMetadata{ContentType: records[paxXattrMimeType]}
+}
+
+// readMetadataSidecar downloads the .metadata.json entry of an archive.
+func readMetadataSidecar(ctx context.Context, svc *s3.Client, bucket, key string, sidecar *FileMetadata) (*ObjectMetadata, error) {
+	if sidecar.Size == 0 {
+		return nil, fmt.Errorf("%s is empty", sidecar.Filename)
+	}
+	r, err := getObjectRange(ctx, svc, bucket, key, sidecar.Start, sidecar.Start+sidecar.Size-1)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	m := &ObjectMetadata{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", sidecar.Filename, err)
+	}
+	return m, nil
+}
+
+// mergeObjectMetadata fills the fields missing from m with the ones of
+// fallback. Either can be nil.
+func mergeObjectMetadata(m, fallback *ObjectMetadata) *ObjectMetadata {
+	if m == nil {
+		return fallback
+	}
+	if fallback == nil {
+		return m
+	}
+	merged := *m
+	if merged.ContentType == "" {
+		merged.ContentType = fallback.ContentType
+	}
+	if merged.CacheControl == "" {
+		merged.CacheControl = fallback.CacheControl
+	}
+	if merged.ContentEncoding == "" {
+		merged.ContentEncoding = fallback.ContentEncoding
+	}
+	if len(fallback.Metadata) > 0 {
+		merged.Metadata = make(map[string]string, len(m.Metadata)+len(fallback.Metadata))
+		for k, v := range fallback.Metadata {
+			merged.Metadata[k] = v
+		}
+		for k, v := range m.Metadata {
+			merged.Metadata[k] = v
+		}
+	}
+	return &merged
+}
@@ -0,0 +1,85 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestMetadataSidecar(t *testing.T) {
+	o := NewS3ObjOptions(WithBucketAndKey("bucket", "dir/a.txt"), WithSize(10))
+	acl := summarizeACL(&types.Owner{ID: aws.String("owner-id")}, []types.Grant{
+		{Grantee: &types.Grantee{ID: aws.String("owner-id")}, Permission: types.PermissionFullControl},
+		{Grantee: &types.Grantee{URI: aws.String("http://acs.amazonaws.com/groups/global/AllUsers")}, Permission: types.PermissionRead},
+	})
+	m := &ObjectMetadata{
+		Key:         *o.Key,
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"file-owner": "1000"},
+		Tags:        map[string]string{"project": "s3tar"},
+		ACL:         acl,
+	}
+	sidecar, err := metadataSidecar(o, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *sidecar.Key != "dir/a.txt.metadata.json" {
+		t.Errorf("key = %s", *sidecar.Key)
+	}
+	if *sidecar.Size != int64(len(sidecar.Data)) || !sidecar.LastModified.Equal(*o.LastModified) {
+		t.Errorf("size %d, data %d, modified %v", *sidecar.Size, len(sidecar.Data), sidecar.LastModified)
+	}
+
+	var got ObjectMetadata
+	if err := json.Unmarshal(sidecar.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, m) {
+		t.Errorf("sidecar = %+v, want %+v", got, m)
+	}
+	wantGrants := []string{"http://acs.amazonaws.com/groups/global/AllUsers:READ", "owner-id:FULL_CONTROL"}
+	if got.ACL.Owner != "owner-id" || !reflect.DeepEqual(got.ACL.Grants, wantGrants) {
+		t.Errorf("acl = %+v", got.ACL)
+	}
+}
+
+func TestRestoreObjectMetadata(t *testing.T) {
+	pax := paxObjectMetadata(map[string]string{
+		paxXattrMimeType: "image/png",
+		"mtime":          "1700000000.1",
+	})
+	if pax == nil || pax.ContentType != "image/png" {
+		t.Fatalf("pax metadata = %+v", pax)
+	}
+	pax.CacheControl = "max-age=60"
+	pax.Metadata = map[string]string{"project": "pax"}
+	if m := paxObjectMetadata(map[string]string{"mtime": "1700000000.1"}); m != nil {
+		t.Errorf("expected no metadata, got %+v", m)
+	}
+
+	sidecar := &ObjectMetadata{ContentType: "text/plain", Metadata: map[string]string{"project": "sidecar", "owner": "me"}}
+	merged := mergeObjectMetadata(sidecar, pax)
+	if merged.ContentType != "text/plain" || merged.CacheControl != "max-age=60" {
+		t.Errorf("merged = %+v", merged)
+	}
+	if !reflect.DeepEqual(merged.Metadata, map[string]string{"project": "sidecar", "owner": "me"}) {
+		t.Errorf("merged metadata = %v", merged.Metadata)
+	}
+
+	input := &s3.CreateMultipartUploadInput{Metadata: map[string]string{"file-owner": "1000", "owner": "posix"}}
+	applyObjectMetadata(input, merged)
+	if aws.ToString(input.ContentType) != "text/plain" || aws.ToString(input.CacheControl) != "max-age=60" || input.ContentEncoding != nil {
+		t.Errorf("headers = %v %v %v", input.ContentType, input.CacheControl, input.ContentEncoding)
+	}
+	want := map[string]string{"project": "sidecar", "owner": "posix", "file-owner": "1000"}
+	if !reflect.DeepEqual(input.Metadata, want) {
+		t.Errorf("metadata = %v, want %v", input.Metadata, want)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Signing algorithms of ArchiveSignature, named like the KMS signing
+// algorithms so the signatures of KMS keys can be verified without KMS.
+const (
+	SigningEd25519        = "ED25519"
+	SigningECDSASHA256    = string(kmstypes.SigningAlgorithmSpecEcdsaSha256)
+	SigningECDSASHA384    = string(kmstypes.SigningAlgorithmSpecEcdsaSha384)
+	SigningECDSASHA512    = string(kmstypes.SigningAlgorithmSpecEcdsaSha512)
+	SigningRSAPSSSHA256   = string(kmstypes.SigningAlgorithmSpecRsassaPssSha256)
+	SigningRSAPKCS1SHA256 = string(kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha256)
+)
+
+// ErrTampered is returned by VerifySignature when the archive doesn't match
+// its signature.
+var ErrTampered = errors.New("the archive doesn't match its signature")
+
+// KMSSignAPI is the part of the KMS client used to sign and verify archives.
+type KMSSignAPI interface {
+	GetPublicKey(context.Context, *kms.GetPublicKeyInput, ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	Sign(context.Context, *kms.SignInput, ...func(*kms.Options)) (*kms.SignOutput, error)
+	Verify(context.Context, *kms.VerifyInput, ...func(*kms.Options)) (*kms.VerifyOutput, error)
+}
+
+// ArchiveSigner signs the archives once they are created, see SignArchive.
+// Set either KMS and KMSKeyID or Key.
+type ArchiveSigner struct {
+	// KMS and KMSKeyID sign with an asymmetric KMS key of the SIGN_VERIFY
+	// usage, kms:Sign and kms:GetPublicKey.
+	KMS      KMSSignAPI
+	KMSKeyID string
+	// Key signs locally with an Ed25519, ECDSA or RSA private key, see
+	// ParsePrivateKey.
+	Key crypto.Signer
+}
+
+// ArchiveVerifier checks the signature of an archive, see VerifySignature. The
+// signature is checked with PublicKey when it is set, otherwise with
+// kms:Verify and the KMS key recorded in the signature.
+type ArchiveVerifier struct {
+	KMS       KMSSignAPI
+	PublicKey crypto.PublicKey
+}
+
+// SignedStatement is what is signed: the size of the archive and the SHA-256
+// of its TOC. The TOC has the offset and size of every entry, and their
+// SHA-256 with S3TarS3Options.SHA256, which extraction verifies. An archive
+// without a TOC, built in memory or zip, is hashed whole instead.
+type SignedStatement struct {
+	Size      int64  `json:"size"`
+	Entries   int    `json:"entries,omitempty"`
+	TOCSHA256 string `json:"tocSha256,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+}
+
+// ArchiveSignature is the content of the SignatureKey of an archive.
+type ArchiveSignature struct {
+	// Archive is where the archive was signed, for information only: a copy
+	// of the archive and its signature elsewhere verifies.
+	Archive   string          `json:"archive"`
+	Statement SignedStatement `json:"statement"`
+	Algorithm string          `json:"algorithm"`
+	// KeyID is the ARN of the KMS key, or sha256: and the SHA-256 of the
+	// PKIX public key of a local key.
+	KeyID     string    `json:"keyId"`
+	Signature []byte    `json:"signature"`
+	SignedAt  time.Time `json:"signedAt"`
+}
+
+// SignatureKey returns the key of the signature of an archive.
+func SignatureKey(key string) string {
+	return key + ".signature.json"
+}
+
+// SignArchive signs the TOC of the archive s3://bucket/key, read back from
+// S3, and writes the signature to SignatureKey(key).
+func SignArchive(ctx context.Context, svc Backend, bucket, key string, signer *ArchiveSigner) error {
+	statement, err := archiveStatement(ctx, svc, bucket, key)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+	sig := &ArchiveSignature{
+		Archive:   fmt.Sprintf("s3://%s/%s", bucket, key),
+		Statement: statement,
+		SignedAt:  time.Now().UTC(),
+	}
+	if signer.Key != nil {
+		sig.Algorithm, sig.Signature, err = signLocal(signer.Key, payload)
+		if err == nil {
+			sig.KeyID, err = keyFingerprint(signer.Key.Public())
+		}
+	} else {
+		sig.Algorithm, sig.KeyID, sig.Signature, err = signKMS(ctx, signer, payload)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to sign s3://%s/%s: %w", bucket, key, err)
+	}
+	data, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := putObject(ctx, svc, bucket, SignatureKey(key), data); err != nil {
+		return err
+	}
+	Infof(ctx, "signed s3://%s/%s with %s", bucket, key, sig.KeyID)
+	return nil
+}
+
+// VerifySignature checks s3://bucket/key against its signature. It returns
+// the signature, and an error wrapping ErrTampered when the TOC, the size or
+// the signature don't match.
+func VerifySignature(ctx context.Context, svc Backend, bucket, key string, v *ArchiveVerifier) (*ArchiveSignature, error) {
+	r, err := getObject(ctx, svc, bucket, SignatureKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the signature s3://%s/%s: %w", bucket, SignatureKey(key), err)
+	}
+	defer r.Close()
+	sig := &ArchiveSignature{}
+	if err := json.NewDecoder(r).Decode(sig); err != nil {
+		return nil, fmt.Errorf("invalid signature s3://%s/%s: %w", bucket, SignatureKey(key), err)
+	}
+	statement, err := archiveStatement(ctx, svc, bucket, key)
+	if err != nil {
+		return sig, err
+	}
+	switch {
+	case statement.Size != sig.Statement.Size:
+		return sig, fmt.Errorf("%w: the size is %d, %d was signed", ErrTampered, statement.Size, sig.Statement.Size)
+	case statement.TOCSHA256 != sig.Statement.TOCSHA256:
+		return sig, fmt.Errorf("%w: the TOC was modified", ErrTampered)
+	case statement.SHA256 != sig.Statement.SHA256:
+		return sig, fmt.Errorf("%w: the content was modified", ErrTampered)
+	}
+	// the statement signed, as it was written
+	payload, err := json.Marshal(sig.Statement)
+	if err != nil {
+		return sig, err
+	}
+	if v.PublicKey != nil {
+		err = verifyLocal(v.PublicKey, sig.Algorithm, payload, sig.Signature)
+	} else if v.KMS != nil {
+		err = verifyKMS(ctx, v.KMS, sig, payload)
+	} else {
+		return sig, fmt.Errorf("a public key or a KMS client is required to verify the signature")
+	}
+	return sig, err
+}
+
+// archiveStatement returns the statement of the archive as it is in S3.
+func archiveStatement(ctx context.Context, svc Backend, bucket, key string) (SignedStatement, error) {
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return SignedStatement{}, err
+	}
+	statement := SignedStatement{Size: aws.ToInt64(head.ContentLength)}
+	h := sha256.New()
+	r, err := openTOC(ctx, svc, bucket, key)
+	if errors.Is(err, ErrNoTOC) {
+		Infof(ctx, "s3://%s/%s has no TOC, hashing the whole archive", bucket, key)
+		r, err = getObject(ctx, svc, bucket, key)
+		if err != nil {
+			return statement, err
+		}
+		defer r.Close()
+		if _, err := io.Copy(h, r); err != nil {
+			return statement, err
+		}
+		statement.SHA256 = hex.EncodeToString(h.Sum(nil))
+		return statement, nil
+	} else if err != nil {
+		return statement, err
+	}
+	defer r.Close()
+	toc, err := ParseTOC(io.TeeReader(r, h))
+	if err != nil {
+		return statement, err
+	}
+	statement.Entries = len(toc)
+	statement.TOCSHA256 = hex.EncodeToString(h.Sum(nil))
+	return statement, nil
+}
+
+// signKMS signs payload with the KMS key of signer, with the first SHA-256
+// algorithm the key supports, or its first algorithm.
+func signKMS(ctx context.Context, signer *ArchiveSigner, payload []byte) (string, string, []byte, error) {
+	if signer.KMS == nil || signer.KMSKeyID == "" {
+		return "", "", nil, fmt.Errorf("a KMS client and key, or a local key, are required")
+	}
+	key, err := signer.KMS.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(signer.KMSKeyID)})
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(key.SigningAlgorithms) == 0 {
+		return "", "", nil, fmt.Errorf("%s is not a signing key", signer.KMSKeyID)
+	}
+	algorithm := key.SigningAlgorithms[0]
+	for _, a := range key.SigningAlgorithms {
+		if a == kmstypes.SigningAlgorithmSpecEcdsaSha256 || a == kmstypes.SigningAlgorithmSpecRsassaPssSha256 {
+			algorithm = a
+			break
+		}
+	}
+	output, err := signer.KMS.Sign(ctx, &kms.SignInput{
+		KeyId:            key.KeyId,
+		Message:          payload,
+		MessageType:      kmstypes.MessageTypeRaw,
+		SigningAlgorithm: algorithm,
+	})
+	if err != nil {
+		return "", "", nil, err
+	}
+	return string(algorithm), aws.ToString(output.KeyId), output.Signature, nil
+}
+
+func verifyKMS(ctx context.Context, client KMSSignAPI, sig *ArchiveSignature, payload []byte) error {
+	output, err := client.Verify(ctx, &kms.VerifyInput{
+		KeyId:            aws.String(sig.KeyID),
+		Message:          payload,
+		MessageType:      kmstypes.MessageTypeRaw,
+		Signature:        sig.Signature,
+		SigningAlgorithm: kmstypes.SigningAlgorithmSpec(sig.Algorithm),
+	})
+	var invalid *kmstypes.KMSInvalidSignatureException
+	if errors.As(err, &invalid) || (err == nil && !output.SignatureValid) {
+		return fmt.Errorf("%w: invalid signature", ErrTampered)
+	}
+	return err
+}
+
+// signLocal signs payload with key: Ed25519, ECDSA with the hash of the size
+// of its curve, or RSA-PSS with SHA-256.
+func signLocal(key crypto.Signer, payload []byte) (string, []byte, error) {
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err := key.Sign(rand.Reader, payload, crypto.Hash(0))
+		return SigningEd25519, sig, err
+	case *ecdsa.PublicKey:
+		algorithm, hash := ecdsaHash(pub.Curve)
+		sig, err := key.Sign(rand.Reader, digest(hash, payload), hash)
+		return algorithm, sig, err
+	case *rsa.PublicKey:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		sig, err := key.Sign(rand.Reader, digest(crypto.SHA256, payload), opts)
+		return SigningRSAPSSSHA256, sig, err
+	}
+	return "", nil, fmt.Errorf("unsupported key type %T", key.Public())
+}
+
+// verifyLocal checks the signature of payload with the public key, for the
+// signatures of local keys and of KMS keys. A signature of an algorithm of
+// another type of key doesn't match.
+func verifyLocal(key crypto.PublicKey, algorithm string, payload, sig []byte) error {
+	ok := false
+	switch pub := key.(type) {
+	case ed25519.PublicKey:
+		ok = algorithm == SigningEd25519 && ed25519.Verify(pub, payload, sig)
+	case *ecdsa.PublicKey:
+		hash := map[string]crypto.Hash{SigningECDSASHA256: crypto.SHA256, SigningECDSASHA384: crypto.SHA384, SigningECDSASHA512: crypto.SHA512}[algorithm]
+		ok = hash != 0 && ecdsa.VerifyASN1(pub, digest(hash, payload), sig)
+	case *rsa.PublicKey:
+		switch algorithm {
+		case SigningRSAPSSSHA256:
+			ok = rsa.VerifyPSS(pub, crypto.SHA256, digest(crypto.SHA256, payload), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		case SigningRSAPKCS1SHA256:
+			ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest(crypto.SHA256, payload), sig) == nil
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	if !ok {
+		return fmt.Errorf("%w: invalid signature", ErrTampered)
+	}
+	return nil
+}
+
+func ecdsaHash(curve elliptic.Curve) (string, crypto.Hash) {
+	switch curve {
+	case elliptic.P384():
+		return SigningECDSASHA384, crypto.SHA384
+	case elliptic.P521():
+		return SigningECDSASHA512, crypto.SHA512
+	}
+	return SigningECDSASHA256, crypto.SHA256
+}
+
+func digest(hash crypto.Hash, payload []byte) []byte {
+	h := hash.New()
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// keyFingerprint returns sha256: and the SHA-256 of the PKIX encoding of key.
+func keyFingerprint(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// ParsePrivateKey parses a PEM private key to sign archives with: PKCS #8,
+// e.g. from openssl genpkey, or an EC or PKCS #1 RSA key.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKey parses a PEM public key to verify archives with, e.g. from
+// openssl pkey -pubout or the public key of a KMS key.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		// aws kms get-public-key --output text --query PublicKey | base64 -d
+		return x509.ParsePKIXPublicKey(data)
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
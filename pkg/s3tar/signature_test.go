@@ -0,0 +1,172 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeSignKMS is an ECC_NIST_P256 KMS key.
+type fakeSignKMS struct {
+	key *ecdsa.PrivateKey
+}
+
+const fakeSignKeyARN = "arn:aws:kms:us-east-1:111122223333:key/1234abcd"
+
+func (f *fakeSignKMS) GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+	der, err := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+	return &kms.GetPublicKeyOutput{
+		KeyId:             aws.String(fakeSignKeyARN),
+		PublicKey:         der,
+		SigningAlgorithms: []kmstypes.SigningAlgorithmSpec{kmstypes.SigningAlgorithmSpecEcdsaSha256},
+	}, err
+}
+
+func (f *fakeSignKMS) Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error) {
+	sum := sha256.Sum256(params.Message)
+	sig, err := ecdsa.SignASN1(rand.Reader, f.key, sum[:])
+	return &kms.SignOutput{KeyId: aws.String(fakeSignKeyARN), Signature: sig, SigningAlgorithm: params.SigningAlgorithm}, err
+}
+
+func (f *fakeSignKMS) Verify(ctx context.Context, params *kms.VerifyInput, optFns ...func(*kms.Options)) (*kms.VerifyOutput, error) {
+	sum := sha256.Sum256(params.Message)
+	if !ecdsa.VerifyASN1(&f.key.PublicKey, sum[:], params.Signature) {
+		return nil, &kmstypes.KMSInvalidSignatureException{Message: aws.String("invalid")}
+	}
+	return &kms.VerifyOutput{KeyId: params.KeyId, SignatureValid: true}, nil
+}
+
+// signedArchive creates s3://bucket/archive.tar with a TOC, or built in
+// memory without one.
+func signedArchive(t *testing.T, m *MemoryBackend, inMemory bool, signer *ArchiveSigner) {
+	t.Helper()
+	ctx := context.Background()
+	var pad int64 = 64 * 1024
+	m.MinPartSize = pad
+	var list []*S3Obj
+	for key, data := range map[string]string{"src/a.txt": "first file", "src/b.bin": strings.Repeat("b", 70000)} {
+		m.Put("bucket", key, []byte(data))
+		head, _ := m.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key)})
+		o := NewS3ObjOptions(WithBucketAndKey("bucket", key), WithSize(int64(len(data))), WithETag(aws.ToString(head.ETag)))
+		o.LastModified = aws.Time(time.Now())
+		list = append(list, o)
+	}
+	opts := &S3TarS3Options{
+		SrcBucket:      "bucket",
+		DstBucket:      "bucket",
+		DstKey:         "archives/archive.tar",
+		DstPrefix:      "archives/",
+		Region:         "us-east-1",
+		Threads:        2,
+		PadSize:        pad,
+		ConcatInMemory: inMemory,
+		Signer:         signer,
+	}
+	if err := CreateFromListWithBackend(ctx, m, list, opts, WithTarFormat("pax")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignArchive(t *testing.T) {
+	ctx := context.Background()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	kmsKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	kmsClient := &fakeSignKMS{key: kmsKey}
+
+	tests := []struct {
+		name     string
+		signer   *ArchiveSigner
+		verifier *ArchiveVerifier
+		inMemory bool
+	}{
+		{"ed25519", &ArchiveSigner{Key: edKey}, &ArchiveVerifier{PublicKey: edKey.Public()}, false},
+		{"ecdsa", &ArchiveSigner{Key: ecKey}, &ArchiveVerifier{PublicKey: ecKey.Public()}, false},
+		{"rsa", &ArchiveSigner{Key: rsaKey}, &ArchiveVerifier{PublicKey: rsaKey.Public()}, false},
+		{"kms", &ArchiveSigner{KMS: kmsClient, KMSKeyID: "alias/archives"}, &ArchiveVerifier{KMS: kmsClient}, false},
+		{"kms offline", &ArchiveSigner{KMS: kmsClient, KMSKeyID: "alias/archives"}, &ArchiveVerifier{PublicKey: kmsKey.Public()}, false},
+		{"in memory", &ArchiveSigner{Key: edKey}, &ArchiveVerifier{PublicKey: edKey.Public()}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryBackend()
+			signedArchive(t, m, tt.inMemory, tt.signer)
+			sig, err := VerifySignature(ctx, m, "bucket", "archives/archive.tar", tt.verifier)
+			if err != nil {
+				t.Fatalf("VerifySignature() = %v", err)
+			}
+			if tt.inMemory != (sig.Statement.SHA256 != "") || tt.inMemory == (sig.Statement.TOCSHA256 != "") {
+				t.Errorf("statement %+v", sig.Statement)
+			}
+			if tt.signer.KMS != nil && (sig.KeyID != fakeSignKeyARN || sig.Algorithm != SigningECDSASHA256) {
+				t.Errorf("signed with %s %s", sig.Algorithm, sig.KeyID)
+			}
+
+			// the name of an entry changed in the TOC, or in the archive
+			// without a TOC
+			data := m.Get("bucket", "archives/archive.tar")
+			m.Put("bucket", "archives/archive.tar", bytes.Replace(data, []byte("src/a.txt"), []byte("src/x.txt"), -1))
+			if _, err := VerifySignature(ctx, m, "bucket", "archives/archive.tar", tt.verifier); !errors.Is(err, ErrTampered) {
+				t.Errorf("VerifySignature() of a modified archive = %v", err)
+			}
+			m.Put("bucket", "archives/archive.tar", data)
+
+			// a statement signed by someone else
+			sigData := m.Get("bucket", SignatureKey("archives/archive.tar"))
+			other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err := SignArchive(ctx, m, "bucket", "archives/archive.tar", &ArchiveSigner{Key: other}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := VerifySignature(ctx, m, "bucket", "archives/archive.tar", tt.verifier); !errors.Is(err, ErrTampered) {
+				t.Errorf("VerifySignature() of another signature = %v", err)
+			}
+			m.Put("bucket", SignatureKey("archives/archive.tar"), sigData)
+			if _, err := VerifySignature(ctx, m, "bucket", "archives/archive.tar", tt.verifier); err != nil {
+				t.Errorf("VerifySignature() of the restored archive = %v", err)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+	ec, _ := x509.MarshalECPrivateKey(key)
+	pkix, _ := x509.MarshalPKIXPublicKey(key.Public())
+	for _, block := range []*pem.Block{{Type: "PRIVATE KEY", Bytes: pkcs8}, {Type: "EC PRIVATE KEY", Bytes: ec}} {
+		signer, err := ParsePrivateKey(pem.EncodeToMemory(block))
+		if err != nil || !key.Equal(signer) {
+			t.Errorf("ParsePrivateKey(%s) = %v", block.Type, err)
+		}
+	}
+	// a PEM public key, or the DER of kms get-public-key
+	for _, data := range [][]byte{pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}), pkix} {
+		pub, err := ParsePublicKey(data)
+		if err != nil || !key.PublicKey.Equal(pub) {
+			t.Errorf("ParsePublicKey() = %v", err)
+		}
+	}
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("ParsePrivateKey() error = nil")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrSourceSizeMismatch is returned when a source object doesn't have the
+// size written in its tar header, e.g. an object stored with
+// Content-Encoding gzip served decompressed by a gateway, or an object
+// overwritten since it was listed. The archive would be corrupt, it isn't
+// created.
+var ErrSourceSizeMismatch = errors.New("source size mismatch")
+
+func sizeMismatch(o *S3Obj, size int64, encoding string) error {
+	err := fmt.Errorf("%w: s3://%s/%s has %d bytes in its tar header but %d bytes in Amazon S3", ErrSourceSizeMismatch, o.Bucket, aws.ToString(o.Key), aws.ToInt64(o.Size), size)
+	if encoding != "" {
+		err = fmt.Errorf("%w, Content-Encoding %s", err, encoding)
+	}
+	return err
+}
+
+// sizeCheckReader fails the download of an object when it doesn't read the
+// bytes of its tar header, before a short or long entry is written.
+type sizeCheckReader struct {
+	io.ReadCloser
+	obj      *S3Obj
+	encoding string
+	read     int64
+}
+
+func (r *sizeCheckReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	size := aws.ToInt64(r.obj.Size)
+	if r.read > size || (err == io.EOF && r.read != size) {
+		return n, sizeMismatch(r.obj, r.read, r.encoding)
+	}
+	return n, err
+}
+
+// verifySourceSizes checks the size of every source object with a
+// HeadObject before the archive is built. The sizes of a listing or a
+// manifest are written in the tar headers, a mismatch would corrupt the
+// archive.
+func verifySourceSizes(ctx context.Context, svc Backend, objectList []*S3Obj, threads int) error {
+	Infof(ctx, "verifying the size of %d objects", len(objectList))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for _, o := range objectList {
+		o := o
+		if o.LinkTarget != "" || len(o.Data) > 0 {
+			continue
+		}
+		g.Go(func() error {
+			input := &s3.HeadObjectInput{Bucket: aws.String(o.Bucket), Key: o.Key}
+			if o.VersionId != "" {
+				input.VersionId = aws.String(o.VersionId)
+			}
+			head, err := svc.HeadObject(gctx, input)
+			if err != nil {
+				return fmt.Errorf("unable to verify the size of s3://%s/%s: %w", o.Bucket, aws.ToString(o.Key), err)
+			}
+			size := aws.ToInt64(head.ContentLength)
+			// a slice only needs its range to be in the object
+			if (o.Slice && o.Offset+aws.ToInt64(o.Size) > size) || (!o.Slice && size != aws.ToInt64(o.Size)) {
+				return sizeMismatch(o, size, aws.ToString(head.ContentEncoding))
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// checkTrustedSizes checks the size of the source objects of a
+// TrustListing job that failed with err because a listed size was wrong:
+// the archive isn't the size its headers add up to, or a copy range is past
+// the end of its object. The objects that don't match are added to err.
+func checkTrustedSizes(ctx context.Context, svc Backend, objectList []*S3Obj, threads int, err error) error {
+	// the groups of small files keep the message of their error only
+	if !errors.Is(err, ErrSizeMismatch) && apiErrorCode(err) != "InvalidRange" && !strings.Contains(err.Error(), "InvalidRange") {
+		return err
+	}
+	Warnf(ctx, "%s, checking the size of the %d source objects", err.Error(), len(objectList))
+	if serr := verifySourceSizes(ctx, svc, objectList, threads); serr != nil {
+		return fmt.Errorf("%w: %w", err, serr)
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// sizesHTTPClient answers a HeadObject with the size of the key in sizes,
+// stored with Content-Encoding gzip.
+type sizesHTTPClient struct {
+	sizes map[string]string
+}
+
+func (c sizesHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Content-Length", c.sizes[path.Base(r.URL.Path)])
+	header.Set("Content-Encoding", "gzip")
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    r,
+	}, nil
+}
+
+func TestSizeCheckReader(t *testing.T) {
+	for _, tt := range []struct {
+		data  string
+		valid bool
+	}{{"hello", true}, {"hell", false}, {"hello world", false}} {
+		o := NewS3ObjOptions(WithBucketAndKey("my-bucket", "a.txt.gz"), WithSize(5))
+		r := &sizeCheckReader{ReadCloser: io.NopCloser(strings.NewReader(tt.data)), obj: o, encoding: "gzip"}
+		_, err := io.ReadAll(r)
+		if tt.valid && err != nil {
+			t.Errorf("%q: unexpected error %s", tt.data, err)
+		}
+		if !tt.valid && (!errors.Is(err, ErrSourceSizeMismatch) || !strings.Contains(err.Error(), "gzip")) {
+			t.Errorf("%q: expected a size mismatch, got %v", tt.data, err)
+		}
+	}
+}
+
+func TestVerifySourceSizes(t *testing.T) {
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   sizesHTTPClient{sizes: map[string]string{"a.gz": "100", "b.gz": "100"}},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	ctx := context.Background()
+	slice := NewS3ObjOptions(WithBucketAndKey("my-bucket", "b.gz"), WithSize(50))
+	slice.Slice, slice.Offset = true, 50
+	objectList := []*S3Obj{NewS3ObjOptions(WithBucketAndKey("my-bucket", "a.gz"), WithSize(100)), slice}
+	if err := verifySourceSizes(ctx, svc, objectList, 2); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	slice.Offset = 60
+	err := verifySourceSizes(ctx, svc, objectList, 2)
+	if !errors.Is(err, ErrSourceSizeMismatch) || !strings.Contains(err.Error(), "b.gz") {
+		t.Errorf("expected a size mismatch for the slice, got %v", err)
+	}
+	err = verifySourceSizes(ctx, svc, []*S3Obj{NewS3ObjOptions(WithBucketAndKey("my-bucket", "a.gz"), WithSize(300))}, 2)
+	if !errors.Is(err, ErrSourceSizeMismatch) || !strings.Contains(err.Error(), "Content-Encoding gzip") {
+		t.Errorf("expected a size mismatch, got %v", err)
+	}
+}
+
+// headCounter counts the HeadObject requests sent to a bucket.
+type headCounter struct {
+	*MemoryBackend
+	bucket string
+	mu     sync.Mutex
+	heads  int
+}
+
+func (h *headCounter) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if aws.ToString(params.Bucket) == h.bucket {
+		h.mu.Lock()
+		h.heads++
+		h.mu.Unlock()
+	}
+	return h.MemoryBackend.HeadObject(ctx, params, optFns...)
+}
+
+func TestTrustListing(t *testing.T) {
+	const mb = 1024 * 1024
+	sizes := []int{1000, 3 * mb, 4 * mb}
+	build := func(trust bool, shrink int) (int, error) {
+		svc := &headCounter{MemoryBackend: NewMemoryBackend(), bucket: "src-bucket"}
+		var objectList []*S3Obj
+		for i, size := range sizes {
+			key := fmt.Sprintf("files/%d.bin", i)
+			data := bytes.Repeat([]byte{'a'}, size)
+			if i == 1 {
+				data = data[:size-shrink]
+			}
+			svc.Put("src-bucket", key, data)
+			objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src-bucket", key), WithSize(int64(size))))
+		}
+		opts := &S3TarS3Options{SrcBucket: "src-bucket", DstBucket: "dst-bucket", DstKey: "archives/archive.tar", Region: "us-east-1", Threads: 4, VerifySizes: true, TrustListing: trust}
+		opts.DstPrefix = KeyDir(opts.DstKey)
+		err := CreateFromListWithBackend(context.Background(), svc, objectList, opts)
+		return svc.heads, err
+	}
+
+	if heads, err := build(false, 0); err != nil || heads != len(sizes) {
+		t.Errorf("VerifySizes: %d HeadObject, %v", heads, err)
+	}
+	if heads, err := build(true, 0); err != nil || heads != 0 {
+		t.Errorf("TrustListing: %d HeadObject, %v", heads, err)
+	}
+	// the copy of files/1.bin is out of range, the sources are checked
+	heads, err := build(true, 10)
+	if !errors.Is(err, ErrSourceSizeMismatch) || !strings.Contains(err.Error(), "files/1.bin") || heads != len(sizes) {
+		t.Errorf("TrustListing with a wrong size: %d HeadObject, %v", heads, err)
+	}
+
+	opts := &S3TarS3Options{SrcBucket: "src-bucket", DstBucket: "dst-bucket", DstKey: "archive.tar", TrustListing: true, PreservePOSIXMetadata: true}
+	if err := checkCreateArgs(opts); err == nil {
+		t.Errorf("TrustListing with PreservePOSIXMetadata should be refused")
+	}
+}
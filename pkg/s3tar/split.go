@@ -0,0 +1,94 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// SplitArchive splits an archive created by s3tar into archives of up to
+// sizeLimit bytes, e.g. when it is over the size limit of a downstream
+// system. The archives are named like the ones of --size-limit,
+// DstKey.00.tar, DstKey.01.tar... Every archive is a range of the entries
+// of the original copied server-side after its own TOC, an entry larger
+// than sizeLimit is written alone. SplitArchive returns the archives
+// written.
+func SplitArchive(ctx context.Context, svc *s3.Client, archive string, sizeLimit int64, options *S3TarS3Options, optFns ...func(*S3TarS3Options)) ([]string, error) {
+	ctx, opts, err := rewriteOptions(ctx, options, optFns)
+	if err != nil {
+		return nil, err
+	}
+	cfg := jobConfigFromContext(ctx)
+	src, err := readMergeSource(ctx, svc, archive)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := cfg.splitEntries(src, sizeLimit)
+	if err != nil {
+		return nil, err
+	}
+	Infof(ctx, "splitting s3://%s/%s into %d archives", src.bucket, src.key, len(outputs))
+	var written []string
+	for i, output := range outputs {
+		outOpts := opts.Copy()
+		outOpts.DstKey = splitKey(opts.DstKey, i, len(outputs))
+		outOpts.DstPrefix = KeyDir(outOpts.DstKey)
+		if err := writeEntries(ctx, svc, []*mergeSource{output}, &outOpts); err != nil {
+			return written, err
+		}
+		path := fmt.Sprintf("s3://%s/%s", outOpts.DstBucket, outOpts.DstKey)
+		Infof(ctx, "%s: %d entries", path, len(output.toc))
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// splitEntries breaks the entries of src up in ranges that fit in archives
+// of sizeLimit bytes. The range of an entry starts where the previous one
+// ends so its tar headers come with it.
+func (c jobConfig) splitEntries(src *mergeSource, sizeLimit int64) ([]*mergeSource, error) {
+	// TOC header and padding, end of archive blocks
+	overhead := tarHeaderSize(c.format) + blockSize*4
+	var outputs []*mergeSource
+	current := &mergeSource{bucket: src.bucket, key: src.key, start: src.start}
+	var tocSize int64
+	for _, fm := range src.toc {
+		end := fm.Start + fm.Size + findPadding(fm.Size)
+		cursor := current.start + current.size
+		if fm.Start < cursor {
+			return nil, fmt.Errorf("%s is a duplicate, archives created with --dedup can't be split", fm.Filename)
+		}
+		// a TOC line with the largest offset
+		line := int64(len(fm.Filename)+len(fm.Etag)+len(fm.SHA256)) + 48
+		if len(current.toc) > 0 && overhead+tocSize+line+current.size+end-cursor > sizeLimit {
+			outputs = append(outputs, current)
+			current = &mergeSource{bucket: src.bucket, key: src.key, start: cursor}
+			tocSize = 0
+		}
+		current.toc = append(current.toc, fm)
+		current.size = end - current.start
+		tocSize += line
+	}
+	if len(current.toc) > 0 {
+		outputs = append(outputs, current)
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("s3://%s/%s has no entries", src.bucket, src.key)
+	}
+	return outputs, nil
+}
+
+// splitKey returns the key of the i-th of n archives split out of key.
+func splitKey(key string, i, n int) string {
+	width := len(strconv.Itoa(n))
+	if width == 1 {
+		width = 2
+	}
+	return fmt.Sprintf("%s.%0*d.tar", strings.TrimSuffix(key, ".tar"), width, i)
+}
@@ -0,0 +1,77 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"testing"
+)
+
+func TestSplitEntries(t *testing.T) {
+	// every entry is a 1024 bytes header and 4000 bytes of data
+	src := &mergeSource{bucket: "bucket", key: "archive.tar", start: 2048}
+	offset := src.start
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		src.toc = append(src.toc, &FileMetadata{Filename: name, Start: offset + 1024, Size: 4000, Etag: "etag"})
+		offset += 1024 + 4096
+	}
+	src.size = offset - src.start
+
+	outputs, err := defaultJobConfig.splitEntries(src, 14000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"a.txt", "b.txt"}, {"c.txt", "d.txt"}, {"e.txt"}}
+	if len(outputs) != len(want) {
+		t.Fatalf("got %d archives, want %d", len(outputs), len(want))
+	}
+	next := src.start
+	for i, output := range outputs {
+		if output.start != next {
+			t.Errorf("archive %d starts at %d, want %d", i, output.start, next)
+		}
+		next = output.start + output.size
+		if len(output.toc) != len(want[i]) {
+			t.Fatalf("archive %d: got %d entries, want %d", i, len(output.toc), len(want[i]))
+		}
+		for j, fm := range output.toc {
+			if fm.Filename != want[i][j] {
+				t.Errorf("archive %d entry %d = %s, want %s", i, j, fm.Filename, want[i][j])
+			}
+		}
+	}
+	if next != src.start+src.size {
+		t.Errorf("archives end at %d, want %d", next, src.start+src.size)
+	}
+
+	// an entry over the limit is written alone
+	outputs, err = defaultJobConfig.splitEntries(src, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 5 {
+		t.Errorf("got %d archives, want 5", len(outputs))
+	}
+
+	src.toc = append(src.toc, &FileMetadata{Filename: "dup.txt", Start: src.toc[0].Start, Size: 4000, Etag: "etag"})
+	if _, err := defaultJobConfig.splitEntries(src, 14000); err == nil {
+		t.Errorf("expected an error for a --dedup duplicate")
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		i, n int
+		want string
+	}{
+		{"archive.tar", 0, 3, "archive.00.tar"},
+		{"dir/archive.tar", 11, 120, "dir/archive.011.tar"},
+		{"archive", 1, 2, "archive.01.tar"},
+	}
+	for _, tt := range tests {
+		if got := splitKey(tt.key, tt.i, tt.n); got != tt.want {
+			t.Errorf("splitKey(%q, %d, %d) = %q, want %q", tt.key, tt.i, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// SQSAPI is the part of the SQS client used by SQSWorker.
+type SQSAPI interface {
+	ReceiveMessage(context.Context, *sqs.ReceiveMessageInput, ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(context.Context, *sqs.DeleteMessageInput, ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(context.Context, *sqs.ChangeMessageVisibilityInput, ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// JobStatusWriter stores the status of the jobs processed by SQSWorker.
+type JobStatusWriter interface {
+	WriteStatus(ctx context.Context, job *Job) error
+}
+
+// SQSWorkerOptions configures an SQSWorker.
+type SQSWorkerOptions struct {
+	QueueURL string
+	// Workers is the number of messages processed at the same time.
+	Workers int
+	// VisibilityTimeout in seconds. Messages being processed are extended by
+	// this much every VisibilityTimeout/2 so long jobs aren't delivered twice.
+	VisibilityTimeout int32
+	// Status stores the job status when the job starts and finishes.
+	Status JobStatusWriter
+	// Tenants limits the jobs and the requests of every tenant. The message
+	// of a job over MaxJobs is made visible again after TenantRetryDelay
+	// seconds, 30 by default, for this or another worker. Every receive
+	// counts against the maxReceiveCount of the redrive policy of the queue.
+	Tenants          TenantLimits
+	TenantRetryDelay int32
+	// Options are the defaults of every job, e.g. Region and Threads.
+	Options S3TarS3Options
+}
+
+// receiveBackoff is the first wait before receiving messages again after
+// ReceiveMessage failed, it doubles up to receiveMaxBackoff.
+var receiveBackoff = time.Second
+
+const receiveMaxBackoff = time.Minute
+
+// SQSWorker polls an SQS queue for JobRequest messages and runs them. A
+// message is deleted once its job succeeds. Failed jobs are left in the
+// queue so they are retried, or moved to a dead-letter queue by the redrive
+// policy of the queue. Messages that aren't a valid JobRequest would fail
+// on every receive, they are deleted with a failed status.
+type SQSWorker struct {
+	archiver Archiver
+	sqs      SQSAPI
+	options  SQSWorkerOptions
+	tenants  *tenants
+}
+
+// NewSQSWorker returns an SQSWorker. Call Run to start polling.
+func NewSQSWorker(archiver Archiver, sqsClient SQSAPI, options SQSWorkerOptions) *SQSWorker {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.VisibilityTimeout < 2 {
+		options.VisibilityTimeout = 300
+	}
+	if options.TenantRetryDelay < 1 {
+		options.TenantRetryDelay = 30
+	}
+	return &SQSWorker{archiver: archiver, sqs: sqsClient, options: options, tenants: newTenants(options.Tenants)}
+}
+
+// Run polls the queue until ctx is done. Jobs that are running when ctx is
+// done are canceled and their messages become visible again. Errors
+// receiving messages are logged and the workers try again, a throttled or
+// unreachable queue doesn't stop them.
+func (w *SQSWorker) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < w.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.poll(ctx)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (w *SQSWorker) poll(ctx context.Context) {
+	backoff := receiveBackoff
+	for ctx.Err() == nil {
+		output, err := w.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(w.options.QueueURL),
+			MaxNumberOfMessages: 1,
+			WaitTimeSeconds:     20,
+			VisibilityTimeout:   w.options.VisibilityTimeout,
+		})
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			Warnf(ctx, "unable to receive messages from %s, trying again in %s: %s", w.options.QueueURL, backoff, err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, receiveMaxBackoff)
+			continue
+		}
+		backoff = receiveBackoff
+		for _, msg := range output.Messages {
+			w.handle(ctx, msg)
+		}
+	}
+}
+
+// parseJobMessage returns the JobRequest of the body of a message. Unknown
+// fields are refused, a misspelled option would otherwise be ignored.
+func parseJobMessage(body string) (JobRequest, error) {
+	var req JobRequest
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return req, err
+	}
+	if dec.More() {
+		return req, fmt.Errorf("the message has data after the job request")
+	}
+	return req, checkJobRequest(&req)
+}
+
+func (w *SQSWorker) handle(ctx context.Context, msg sqstypes.Message) {
+	job := &Job{ID: aws.ToString(msg.MessageId), Status: JobRunning, CreatedAt: time.Now()}
+	job.StartedAt = &job.CreatedAt
+
+	var err error
+	job.Request, err = parseJobMessage(aws.ToString(msg.Body))
+	if err != nil {
+		Warnf(ctx, "message %s: invalid job: %s", job.ID, err.Error())
+		w.finish(ctx, job, err)
+		w.delete(ctx, msg)
+		return
+	}
+	job.Tenant = w.options.Tenants.TenantOf(job.Request)
+	if !w.tenants.acquire(job.Tenant) {
+		Infof(ctx, "message %s: tenant %s is running its maximum jobs, retrying in %ds", job.ID, job.Tenant, w.options.TenantRetryDelay)
+		w.retryLater(ctx, msg)
+		return
+	}
+	defer w.tenants.release(job.Tenant)
+	w.writeStatus(ctx, job)
+
+	jobCtx, cancel := context.WithCancel(w.tenants.withRequestRate(ctx, job.Tenant))
+	heartbeat := make(chan struct{})
+	go func() {
+		defer close(heartbeat)
+		w.heartbeat(jobCtx, msg)
+	}()
+
+	Infof(ctx, "message %s: %s %s", job.ID, job.Request.Type, job.Request.Archive)
+	err = runJobRequest(jobCtx, w.archiver, w.options.Options, job.Request)
+	cancel()
+	<-heartbeat
+
+	if ctx.Err() != nil {
+		// shutting down, the message becomes visible again
+		return
+	}
+	w.finish(ctx, job, err)
+	if err != nil {
+		Warnf(ctx, "message %s failed: %s", job.ID, err.Error())
+		return
+	}
+	w.delete(ctx, msg)
+}
+
+// heartbeat extends the visibility timeout of msg until ctx is done.
+func (w *SQSWorker) heartbeat(ctx context.Context, msg sqstypes.Message) {
+	ticker := time.NewTicker(time.Duration(w.options.VisibilityTimeout) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := w.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(w.options.QueueURL),
+				ReceiptHandle:     msg.ReceiptHandle,
+				VisibilityTimeout: w.options.VisibilityTimeout,
+			})
+			if err != nil && ctx.Err() == nil {
+				Warnf(ctx, "message %s: unable to extend the visibility timeout: %s", aws.ToString(msg.MessageId), err.Error())
+			}
+		}
+	}
+}
+
+// retryLater makes msg visible again after TenantRetryDelay.
+func (w *SQSWorker) retryLater(ctx context.Context, msg sqstypes.Message) {
+	_, err := w.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(w.options.QueueURL),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: w.options.TenantRetryDelay,
+	})
+	if err != nil {
+		// it is visible again after the visibility timeout
+		Warnf(ctx, "message %s: unable to change the visibility timeout: %s", aws.ToString(msg.MessageId), err.Error())
+	}
+}
+
+func (w *SQSWorker) finish(ctx context.Context, job *Job, err error) {
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = JobSucceeded
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+	}
+	w.writeStatus(ctx, job)
+}
+
+func (w *SQSWorker) writeStatus(ctx context.Context, job *Job) {
+	if w.options.Status == nil {
+		return
+	}
+	if err := w.options.Status.WriteStatus(ctx, job); err != nil {
+		Warnf(ctx, "message %s: unable to write the job status: %s", job.ID, err.Error())
+	}
+}
+
+func (w *SQSWorker) delete(ctx context.Context, msg sqstypes.Message) {
+	_, err := w.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(w.options.QueueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		Warnf(ctx, "message %s: unable to delete: %s", aws.ToString(msg.MessageId), err.Error())
+	}
+}
+
+// S3StatusWriter writes the status of every job as JSON to
+// s3://bucket/prefix/<job id>.json.
+type S3StatusWriter struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+func (s *S3StatusWriter) WriteStatus(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = putObject(ctx, s.Client, s.Bucket, path.Join(s.Prefix, job.ID+".json"), data)
+	return err
+}
+
+// DynamoDBStatusWriter writes the status of every job to a DynamoDB table
+// with a string partition key named id.
+type DynamoDBStatusWriter struct {
+	Client *dynamodb.Client
+	Table  string
+}
+
+func (d *DynamoDBStatusWriter) WriteStatus(ctx context.Context, job *Job) error {
+	request, err := json.Marshal(job.Request)
+	if err != nil {
+		return err
+	}
+	item := map[string]ddbtypes.AttributeValue{
+		"id":        &ddbtypes.AttributeValueMemberS{Value: job.ID},
+		"status":    &ddbtypes.AttributeValueMemberS{Value: job.Status},
+		"request":   &ddbtypes.AttributeValueMemberS{Value: string(request)},
+		"updatedAt": &ddbtypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+	}
+	if job.Error != "" {
+		item["error"] = &ddbtypes.AttributeValueMemberS{Value: job.Error}
+	}
+	_, err = d.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.Table),
+		Item:      item,
+	})
+	return err
+}
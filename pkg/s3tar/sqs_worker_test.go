@@ -0,0 +1,170 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeSQS delivers its messages once and then waits for the context.
+type fakeSQS struct {
+	mu       sync.Mutex
+	messages []sqstypes.Message
+	deleted  []string
+	// delayed are the messages made visible again before the end of
+	// their visibility timeout
+	delayed []string
+	// failures is the number of receives failing before the messages are
+	// delivered
+	failures int
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, _ *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	if f.failures > 0 {
+		f.failures--
+		f.mu.Unlock()
+		return nil, fmt.Errorf("connection reset by peer")
+	}
+	if len(f.messages) > 0 {
+		msg := f.messages[0]
+		f.messages = f.messages[1:]
+		f.mu.Unlock()
+		return &sqs.ReceiveMessageOutput{Messages: []sqstypes.Message{msg}}, nil
+	}
+	f.mu.Unlock()
+	f.once.Do(func() { close(f.done) })
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (f *fakeSQS) DeleteMessage(_ context.Context, in *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, aws.ToString(in.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(_ context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delayed = append(f.delayed, aws.ToString(in.ReceiptHandle))
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+type recordingStatus struct {
+	mu       sync.Mutex
+	statuses map[string][]string
+}
+
+func (r *recordingStatus) WriteStatus(_ context.Context, job *Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[job.ID] = append(r.statuses[job.ID], job.Status)
+	return nil
+}
+
+// failingArchiver fails the jobs of s3://bucket/fail.tar.
+type failingArchiver struct{ blockingArchiver }
+
+func (f *failingArchiver) Create(_ context.Context, opts *S3TarS3Options, _ ...func(*S3TarS3Options)) error {
+	if opts.DstKey == "fail.tar" {
+		return fmt.Errorf("failed")
+	}
+	return nil
+}
+
+func TestSQSWorker(t *testing.T) {
+	message := func(id, body string) sqstypes.Message {
+		return sqstypes.Message{MessageId: aws.String(id), ReceiptHandle: aws.String(id), Body: aws.String(body)}
+	}
+	queue := &fakeSQS{
+		done: make(chan struct{}),
+		messages: []sqstypes.Message{
+			message("ok", `{"type":"create","archive":"s3://bucket/a.tar","source":"s3://bucket/src/"}`),
+			message("fail", `{"type":"create","archive":"s3://bucket/fail.tar","source":"s3://bucket/src/"}`),
+			message("invalid", `{"type":"copy"}`),
+			message("misspelled", `{"type":"create","archive":"s3://bucket/b.tar","sorce":"s3://bucket/src/"}`),
+			message("local", `{"type":"create","archive":"s3://bucket/b.tar","manifest":"/etc/passwd"}`),
+		},
+		// the worker keeps polling after the receives failing
+		failures: 2,
+	}
+	defer func(backoff time.Duration) { receiveBackoff = backoff }(receiveBackoff)
+	receiveBackoff = time.Millisecond
+	status := &recordingStatus{statuses: map[string][]string{}}
+	w := NewSQSWorker(&failingArchiver{}, queue, SQSWorkerOptions{QueueURL: "queue", Status: status})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- w.Run(ctx) }()
+	<-queue.done
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if fmt.Sprint(queue.deleted) != "[ok invalid misspelled local]" {
+		t.Errorf("deleted messages %v, want [ok invalid misspelled local]", queue.deleted)
+	}
+	want := map[string]string{
+		"ok":         "[running succeeded]",
+		"fail":       "[running failed]",
+		"invalid":    "[failed]",
+		"misspelled": "[failed]",
+		"local":      "[failed]",
+	}
+	for id, statuses := range want {
+		if got := fmt.Sprint(status.statuses[id]); got != statuses {
+			t.Errorf("%s statuses %s, want %s", id, got, statuses)
+		}
+	}
+}
+
+func TestSQSWorkerTenants(t *testing.T) {
+	message := func(id, archive string) sqstypes.Message {
+		body := `{"type":"create","archive":"` + archive + `","source":"s3://src/"}`
+		return sqstypes.Message{MessageId: aws.String(id), ReceiptHandle: aws.String(id), Body: aws.String(body)}
+	}
+	queue := &fakeSQS{
+		done:     make(chan struct{}),
+		messages: []sqstypes.Message{message("big-1", "s3://big/1.tar"), message("big-2", "s3://big/2.tar")},
+	}
+	archiver := &blockingArchiver{release: make(chan struct{}), started: make(chan *S3TarS3Options, 10)}
+	w := NewSQSWorker(archiver, queue, SQSWorkerOptions{QueueURL: "queue", Workers: 2, Tenants: TenantLimits{MaxJobs: 1}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- w.Run(ctx) }()
+	<-archiver.started
+	<-queue.done
+	close(archiver.release)
+	// the first job is deleted once it succeeds
+	for i := 0; i < 100; i++ {
+		queue.mu.Lock()
+		n := len(queue.deleted)
+		queue.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if fmt.Sprint(queue.deleted) != "[big-1]" || fmt.Sprint(queue.delayed) != "[big-2]" {
+		t.Errorf("deleted %v and delayed %v, want [big-1] and [big-2]", queue.deleted, queue.delayed)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// HistogramBucket counts the objects with Min <= size < Max.
+type HistogramBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// StageTiming is the time spent in one stage of the archive creation, the
+// number of S3 requests sent during it and how many of their attempts were
+// retried. A slow stage with few retries was slowed down by the planning or
+// the size of the copies, one with many throttled retries by S3.
+type StageTiming struct {
+	Name      string        `json:"name"`
+	Duration  time.Duration `json:"duration"`
+	Requests  int64         `json:"requests"`
+	Retries   int64         `json:"retries"`
+	Throttled int64         `json:"throttled"`
+}
+
+// ArchiveStats describes the objects that went into an archive and how long
+// each stage took. It is written at the end of a run when
+// S3TarS3Options.Stats is set.
+type ArchiveStats struct {
+	Archive      string            `json:"archive"`
+	Objects      int               `json:"objects"`
+	TotalBytes   int64             `json:"totalBytes"`
+	ArchiveBytes int64             `json:"archiveBytes"`
+	SmallestKey  string            `json:"smallestKey"`
+	SmallestSize int64             `json:"smallestSize"`
+	LargestKey   string            `json:"largestKey"`
+	LargestSize  int64             `json:"largestSize"`
+	Histogram    []HistogramBucket `json:"histogram"`
+	Stages       []StageTiming     `json:"stages"`
+	// Requests is the number of S3 requests by operation. Requests are only
+	// counted for clients created with WithRequestMetrics.
+	Requests map[string]int64 `json:"requests"`
+	// Retries and Throttled are the attempts retried by operation, Throttled
+	// the ones S3 answered SlowDown or 503 to. The others failed on the
+	// network or with another retryable error.
+	Retries   map[string]int64 `json:"retries"`
+	Throttled map[string]int64 `json:"throttled"`
+	// RetryBudgetExhausted is the number of requests by operation that
+	// failed because the retry budget of the client was spent.
+	RetryBudgetExhausted map[string]int64 `json:"retryBudgetExhausted,omitempty"`
+
+	// counters are the requests of the job, counted from zero when the
+	// stats are created.
+	counters    *requestCounters
+	requestsAt  int64
+	retriesAt   int64
+	throttlesAt int64
+}
+
+// histogramLimits are the upper bounds of the size histogram. The 5MiB bucket
+// matches the smallest multipart part.
+var histogramLimits = []int64{1, 1 << 10, 64 << 10, 1 << 20, 5 << 20, 100 << 20, 1 << 30, 5 << 30, 1 << 62}
+
+// newArchiveStats returns the stats of the objects in objectList, the
+// requests are the ones counted by counters.
+func newArchiveStats(objectList []*S3Obj, counters *requestCounters) *ArchiveStats {
+	s := &ArchiveStats{
+		Objects:  len(objectList),
+		counters: counters,
+	}
+	var min int64
+	for _, limit := range histogramLimits {
+		s.Histogram = append(s.Histogram, HistogramBucket{Min: min, Max: limit})
+		min = limit
+	}
+	for i, o := range objectList {
+		size := *o.Size
+		s.TotalBytes += size
+		if i == 0 || size < s.SmallestSize {
+			s.SmallestKey, s.SmallestSize = *o.Key, size
+		}
+		if i == 0 || size > s.LargestSize {
+			s.LargestKey, s.LargestSize = *o.Key, size
+		}
+		for j := range s.Histogram {
+			if size < s.Histogram[j].Max {
+				s.Histogram[j].Count++
+				s.Histogram[j].Bytes += size
+				break
+			}
+		}
+	}
+	return s
+}
+
+// statsMu protects the stages recorded from goroutines and keeps the reports
+// of archives built concurrently from interleaving.
+var statsMu sync.Mutex
+
+func (s *ArchiveStats) addStage(name string, d time.Duration) {
+	if s == nil {
+		return
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	total, retries, throttles := s.counters.total(requestCounts), s.counters.total(requestRetries), s.counters.total(requestThrottles)
+	s.Stages = append(s.Stages, StageTiming{
+		Name:      name,
+		Duration:  d,
+		Requests:  total - s.requestsAt,
+		Retries:   retries - s.retriesAt,
+		Throttled: throttles - s.throttlesAt,
+	})
+	s.requestsAt, s.retriesAt, s.throttlesAt = total, retries, throttles
+}
+
+// finish records the requests sent and retried while building the archive.
+func (s *ArchiveStats) finish() {
+	s.Requests = s.counters.snapshot(requestCounts)
+	s.Retries = s.counters.snapshot(requestRetries)
+	s.Throttled = s.counters.snapshot(requestThrottles)
+	s.RetryBudgetExhausted = s.counters.snapshot(retryBudgetExhausted)
+}
+
+// WriteReport writes a human readable report of the stats to w.
+func (s *ArchiveStats) WriteReport(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "archive stats: %s\n", s.Archive)
+	fmt.Fprintf(&buf, "  objects:        %d\n", s.Objects)
+	fmt.Fprintf(&buf, "  total size:     %s\n", formatBytes(s.TotalBytes))
+	if s.ArchiveBytes > 0 {
+		fmt.Fprintf(&buf, "  archive size:   %s\n", formatBytes(s.ArchiveBytes))
+	}
+	if s.Objects > 0 {
+		fmt.Fprintf(&buf, "  average size:   %s\n", formatBytes(s.TotalBytes/int64(s.Objects)))
+		fmt.Fprintf(&buf, "  smallest:       %s (%s)\n", s.SmallestKey, formatBytes(s.SmallestSize))
+		fmt.Fprintf(&buf, "  largest:        %s (%s)\n", s.LargestKey, formatBytes(s.LargestSize))
+		// one GET, restore or lifecycle transition on the archive replaces one
+		// per object
+		fmt.Fprintf(&buf, "  requests:       %d per-object requests become 1 (%dx fewer GET, restore and transition requests)\n", s.Objects, s.Objects)
+	}
+	fmt.Fprintf(&buf, "  size histogram:\n")
+	for _, b := range s.Histogram {
+		if b.Count == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "    %6s - %-6s %10d objects %s\n", histogramLabel(b.Min), histogramLabel(b.Max), b.Count, formatBytes(b.Bytes))
+	}
+	if len(s.Stages) > 0 {
+		fmt.Fprintf(&buf, "  timings:\n")
+		for _, st := range s.Stages {
+			fmt.Fprintf(&buf, "    %-12s %12s %8d requests %6d retries (%d throttled)\n", st.Name, st.Duration.Round(time.Millisecond), st.Requests, st.Retries, st.Throttled)
+		}
+	}
+	if len(s.Requests) > 0 {
+		fmt.Fprintf(&buf, "  s3 requests:\n")
+		for _, op := range sortedKeys(s.Requests) {
+			fmt.Fprintf(&buf, "    %-24s %d", op, s.Requests[op])
+			if s.Retries[op] > 0 {
+				fmt.Fprintf(&buf, " (%d retries, %d throttled)", s.Retries[op], s.Throttled[op])
+			}
+			fmt.Fprintf(&buf, "\n")
+		}
+	}
+	for _, op := range sortedKeys(s.RetryBudgetExhausted) {
+		fmt.Fprintf(&buf, "  retry budget exhausted: %d %s requests failed\n", s.RetryBudgetExhausted[op], op)
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteJSON writes the stats as JSON to w, the durations in nanoseconds.
+func (s *ArchiveStats) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func histogramLabel(n int64) string {
+	switch {
+	case n == histogramLimits[len(histogramLimits)-1]:
+		return "max"
+	case n >= 1<<30:
+		return fmt.Sprintf("%dGiB", n>>30)
+	case n >= 1<<20:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
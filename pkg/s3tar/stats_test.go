@@ -0,0 +1,77 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveStats(t *testing.T) {
+	objectList := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("my-bucket", "empty.txt"), WithSize(0)),
+		NewS3ObjOptions(WithBucketAndKey("my-bucket", "small.txt"), WithSize(100)),
+		NewS3ObjOptions(WithBucketAndKey("my-bucket", "medium.bin"), WithSize(2<<20)),
+		NewS3ObjOptions(WithBucketAndKey("my-bucket", "large.bin"), WithSize(6<<30)),
+	}
+	counters := requestCountersFromContext(withRequestCounters(context.Background()))
+	s := newArchiveStats(objectList, counters)
+	if s.Objects != 4 || s.TotalBytes != 100+2<<20+6<<30 {
+		t.Errorf("Objects = %d TotalBytes = %d", s.Objects, s.TotalBytes)
+	}
+	if s.SmallestKey != "empty.txt" || s.LargestKey != "large.bin" {
+		t.Errorf("smallest = %s largest = %s", s.SmallestKey, s.LargestKey)
+	}
+	counts := map[int64]int{}
+	total := 0
+	for _, b := range s.Histogram {
+		counts[b.Max] = b.Count
+		total += b.Count
+	}
+	if total != 4 || counts[1] != 1 || counts[1<<10] != 1 || counts[5<<20] != 1 || counts[1<<62] != 1 {
+		t.Errorf("unexpected histogram %+v", s.Histogram)
+	}
+
+	count := func(c *requestCounters, m *expvar.Map, n int) {
+		for i := 0; i < n; i++ {
+			c.add(m, "UploadPartCopy")
+		}
+	}
+	count(counters, requestCounts, 3)
+	count(counters, requestRetries, 2)
+	count(counters, requestThrottles, 1)
+	// the requests of another job aren't in the report
+	count(requestCountersFromContext(withRequestCounters(context.Background())), requestCounts, 5)
+	s.addStage("build", time.Second)
+	s.finish()
+	if st := s.Stages[0]; st.Requests != 3 || st.Retries != 2 || st.Throttled != 1 {
+		t.Errorf("unexpected stage %+v", st)
+	}
+	var buf bytes.Buffer
+	if err := s.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"objects:        4", "large.bin", "5GiB - max", "build", "1s", "2 retries (1 throttled)", "UploadPartCopy           3 (2 retries, 1 throttled)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report is missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := s.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got ArchiveStats
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Objects != 4 || len(got.Stages) != 1 || got.Stages[0].Retries != 2 || got.Retries["UploadPartCopy"] != 2 || got.Throttled["UploadPartCopy"] != 1 {
+		t.Errorf("unexpected JSON report %s", buf.String())
+	}
+}
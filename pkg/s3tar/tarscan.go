@@ -0,0 +1,159 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// scanWindowSize is the size of the ranged reads used to scan the headers.
+// Headers of small entries close to each other are read at once.
+const scanWindowSize = 64 * 1024
+
+// rangeReader reads an object with ranged GETs. Seek only moves the offset,
+// so the tar reader skips the data of the entries without downloading it.
+type rangeReader struct {
+	ctx         context.Context
+	svc         *s3.Client
+	bucket, key string
+	size        int64
+	offset      int64
+	// window holds the bytes at windowStart
+	window      []byte
+	windowStart int64
+	requests    int
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.offset < r.windowStart || r.offset >= r.windowStart+int64(len(r.window)) {
+		end := r.offset + scanWindowSize
+		if end > r.size {
+			end = r.size
+		}
+		body, err := getObjectRange(r.ctx, r.svc, r.bucket, r.key, r.offset, end-1)
+		if err != nil {
+			return 0, err
+		}
+		r.window, err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return 0, err
+		}
+		r.windowStart = r.offset
+		r.requests++
+		if len(r.window) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	n := copy(p, r.window[r.offset-r.windowStart:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// ScanTar builds the TOC of a tar in Amazon S3 that wasn't created by s3tar
+// by reading its headers with ranged GETs, the data is never downloaded.
+// GNU, PAX and USTAR headers, including long names, are supported.
+// Directories, symlinks, devices and sparse files are skipped with a warning.
+// Hardlinks point at the data of their target. The tar can't be compressed.
+func ScanTar(ctx context.Context, svc *s3.Client, bucket, key string) (TOC, error) {
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, err
+	}
+	r := &rangeReader{ctx: ctx, svc: svc, bucket: bucket, key: key, size: aws.ToInt64(head.ContentLength)}
+	toc, err := scanTar(r, func(format string, v ...any) {
+		Warnf(ctx, format, v...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to scan s3://%s/%s: %w", bucket, key, err)
+	}
+	Debugf(ctx, "scanned %d entries of s3://%s/%s with %d requests", len(toc), bucket, key, r.requests)
+	return toc, nil
+}
+
+// scanTar reads the headers of r. r is an io.Seeker so the tar reader skips
+// the data of the entries.
+func scanTar(r io.ReadSeeker, warnf func(string, ...any)) (TOC, error) {
+	var toc TOC
+	entries := map[string]*FileMetadata{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return toc, nil
+		}
+		if err != nil {
+			if errors.Is(err, tar.ErrHeader) && len(toc) == 0 {
+				return nil, fmt.Errorf("not a tar or a compressed tar: %w", err)
+			}
+			return nil, err
+		}
+		start, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case isSparse(hdr):
+			warnf("skipping sparse file %s", hdr.Name)
+		case hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeCont:
+			fm := &FileMetadata{Filename: hdr.Name, Start: start, Size: hdr.Size}
+			entries[hdr.Name] = fm
+			toc = append(toc, fm)
+		case hdr.Typeflag == tar.TypeLink:
+			target, ok := entries[hdr.Linkname]
+			if !ok {
+				warnf("skipping hardlink %s, %s is not in the tar", hdr.Name, hdr.Linkname)
+				continue
+			}
+			toc = append(toc, &FileMetadata{Filename: hdr.Name, Start: target.Start, Size: target.Size})
+		case hdr.Typeflag == tar.TypeDir:
+			// directories are implied by the keys
+		default:
+			warnf("skipping %s, entries of type %q can't be extracted to Amazon S3", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// isSparse reports whether hdr is a GNU sparse file, in the old GNU format or
+// with GNU.sparse PAX records.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
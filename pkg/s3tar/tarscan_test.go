@@ -0,0 +1,104 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestScanTar(t *testing.T) {
+	longName := strings.Repeat("d/", 80) + "long.txt"
+	files := []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "dir/a.txt", Typeflag: tar.TypeReg, Mode: 0644, Format: tar.FormatUSTAR}, "hello"},
+		{tar.Header{Name: longName, Typeflag: tar.TypeReg, Mode: 0644, Format: tar.FormatGNU}, "gnu long name"},
+		{tar.Header{Name: "pax/" + longName, Typeflag: tar.TypeReg, Mode: 0644, Format: tar.FormatPAX}, strings.Repeat("x", 1000)},
+		{tar.Header{Name: "link.txt", Typeflag: tar.TypeLink, Linkname: "dir/a.txt"}, ""},
+		{tar.Header{Name: "symlink.txt", Typeflag: tar.TypeSymlink, Linkname: "dir/a.txt"}, ""},
+		{tar.Header{Name: "empty.txt", Typeflag: tar.TypeReg}, ""},
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := f.hdr
+		hdr.Size = int64(len(f.data))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	var warnings []string
+	toc, err := scanTar(bytes.NewReader(data), func(format string, v ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, v...))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"dir/a.txt":       "hello",
+		longName:          "gnu long name",
+		"pax/" + longName: strings.Repeat("x", 1000),
+		"link.txt":        "hello",
+		"empty.txt":       "",
+	}
+	if len(toc) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(toc), len(want))
+	}
+	for _, f := range toc {
+		got := string(data[f.Start : f.Start+f.Size])
+		if got != want[f.Filename] {
+			t.Errorf("%s = %q, want %q", f.Filename, got, want[f.Filename])
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "symlink.txt") {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	if _, err := scanTar(bytes.NewReader(bytes.Repeat([]byte{0x1f, 0x8b}, 512)), t.Logf); err == nil {
+		t.Errorf("expected an error for a file that isn't a tar")
+	}
+	if !isSparse(&tar.Header{PAXRecords: map[string]string{"GNU.sparse.major": "1"}}) || isSparse(&tar.Header{Typeflag: tar.TypeReg}) {
+		t.Errorf("isSparse")
+	}
+}
+
+// deniedBackend refuses every GetObject.
+type deniedBackend struct {
+	*MemoryBackend
+}
+
+func (deniedBackend) GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return nil, memoryError("AccessDenied", "Access Denied")
+}
+
+func TestReadTOCErrors(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBackend()
+	m.Put("bucket", "archive.tar", make([]byte, 2048))
+	// an archive that can't be read isn't an archive without a TOC
+	if _, err := ReadTOC(ctx, deniedBackend{m}, "bucket", "archive.tar"); err == nil || errors.Is(err, ErrNoTOC) || apiErrorCode(err) != "AccessDenied" {
+		t.Errorf("ReadTOC() of an archive that can't be read = %v", err)
+	}
+	if _, err := ReadTOC(ctx, m, "bucket", "archive.tar"); !errors.Is(err, ErrNoTOC) {
+		t.Errorf("ReadTOC() of an archive without a TOC = %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const contextKeyRequestRate = contextKey("requestRate")
+
+// TenantLimits are the budgets of the tenants sharing a JobServer or an
+// SQSWorker, so the large jobs of one tenant don't starve the others. The
+// tenant of a job is the bucket it writes to, see TenantOf. The limits are
+// kept by every server or worker process, a fleet of N workers runs up to N
+// times MaxJobs jobs of a tenant.
+type TenantLimits struct {
+	// MaxJobs is the number of jobs of a tenant running at the same time, 0
+	// for no limit.
+	MaxJobs int
+	// RequestsPerSecond limits the S3 requests of the jobs of a tenant
+	// together, retries included, 0 for no limit. The client must be
+	// created with WithRequestRate.
+	RequestsPerSecond float64
+	// PrefixDepth adds the first PrefixDepth directories of the destination
+	// key to the tenant, e.g. 1 to tell s3://bucket/customer-a/ from
+	// s3://bucket/customer-b/.
+	PrefixDepth int
+	// Overrides replace the MaxJobs and RequestsPerSecond of the tenants by
+	// name, e.g. "bucket" or "bucket/customer-a".
+	Overrides map[string]TenantLimits
+}
+
+// TenantOf returns the tenant of a job: the bucket of the archive created or
+// of the destination of an extraction, followed by the first PrefixDepth
+// directories of the key.
+func (l TenantLimits) TenantOf(req JobRequest) string {
+	dst := req.Archive
+	if req.Type == JobTypeExtract {
+		dst = req.Destination
+	}
+	bucket, key := ExtractBucketAndPath(dst)
+	if l.PrefixDepth <= 0 {
+		return bucket
+	}
+	// the last element is the archive, or empty after the trailing slash of
+	// the destination
+	dirs := strings.Split(key, "/")
+	dirs = dirs[:len(dirs)-1]
+	if len(dirs) > l.PrefixDepth {
+		dirs = dirs[:l.PrefixDepth]
+	}
+	return strings.Join(append([]string{bucket}, dirs...), "/")
+}
+
+// limits returns the limits of tenant.
+func (l TenantLimits) limits(tenant string) TenantLimits {
+	if o, ok := l.Overrides[tenant]; ok {
+		return o
+	}
+	return l
+}
+
+// tenants keeps the jobs running and the request rate of every tenant.
+type tenants struct {
+	limits TenantLimits
+
+	mu      sync.Mutex
+	running map[string]int
+	rates   map[string]*rateLimiter
+}
+
+func newTenants(limits TenantLimits) *tenants {
+	return &tenants{limits: limits, running: map[string]int{}, rates: map[string]*rateLimiter{}}
+}
+
+// acquire starts a job of tenant, it returns false when the tenant already
+// runs MaxJobs jobs.
+func (t *tenants) acquire(tenant string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if max := t.limits.limits(tenant).MaxJobs; max > 0 && t.running[tenant] >= max {
+		return false
+	}
+	t.running[tenant]++
+	return true
+}
+
+// release ends a job started with acquire.
+func (t *tenants) release(tenant string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running[tenant]--
+	if t.running[tenant] <= 0 {
+		delete(t.running, tenant)
+	}
+}
+
+// withRequestRate returns a context whose S3 requests share the request
+// rate of tenant with the other jobs of the tenant.
+func (t *tenants) withRequestRate(ctx context.Context, tenant string) context.Context {
+	rps := t.limits.limits(tenant).RequestsPerSecond
+	if rps <= 0 {
+		return ctx
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.rates[tenant]
+	if !ok {
+		l = newRateLimiter(rps)
+		t.rates[tenant] = l
+	}
+	return context.WithValue(ctx, contextKeyRequestRate, l)
+}
+
+// WithRequestRate holds the requests of a context over the request rate of
+// its tenant, see TenantLimits. Every attempt counts. Use it when creating
+// the client:
+//
+//	svc := s3.NewFromConfig(cfg, s3tar.WithRequestRate)
+func WithRequestRate(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		if _, ok := stack.Finalize.Get("Retry"); !ok {
+			// pre-signed requests are not sent
+			return nil
+		}
+		return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("S3TarRequestRate",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				if l, ok := ctx.Value(contextKeyRequestRate).(*rateLimiter); ok {
+					if err := l.wait(ctx, 1); err != nil {
+						return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+					}
+				}
+				return next.HandleFinalize(ctx, in)
+			}), "Retry", middleware.After)
+	})
+}
+
+// jobQueue is the queue of a JobServer. The tenants take turns: the next
+// job is the oldest of the tenant after the one that started a job last,
+// skipping the tenants running MaxJobs jobs.
+type jobQueue struct {
+	tenants  *tenants
+	capacity int
+
+	mu     sync.Mutex
+	queued map[string][]*Job
+	order  []string // the tenants with queued jobs, the next one first
+	size   int
+	wake   chan struct{}
+}
+
+func newJobQueue(t *tenants, capacity int) *jobQueue {
+	return &jobQueue{tenants: t, capacity: capacity, queued: map[string][]*Job{}, wake: make(chan struct{})}
+}
+
+// broadcast wakes up the workers waiting in next. Call with q.mu held.
+func (q *jobQueue) broadcast() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// push queues job, it returns false when the queue is full.
+func (q *jobQueue) push(job *Job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.size >= q.capacity {
+		return false
+	}
+	if len(q.queued[job.Tenant]) == 0 {
+		q.order = append(q.order, job.Tenant)
+	}
+	q.queued[job.Tenant] = append(q.queued[job.Tenant], job)
+	q.size++
+	q.broadcast()
+	return true
+}
+
+// next waits for a job a tenant can start and acquires it, call done once
+// it is finished. It returns nil once ctx is done.
+func (q *jobQueue) next(ctx context.Context) *Job {
+	for {
+		q.mu.Lock()
+		job := q.pop()
+		wake := q.wake
+		q.mu.Unlock()
+		if job != nil {
+			return job
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		}
+	}
+}
+
+// pop returns the next job of a tenant under its limits. Call with q.mu
+// held.
+func (q *jobQueue) pop() *Job {
+	for i, tenant := range q.order {
+		if !q.tenants.acquire(tenant) {
+			continue
+		}
+		jobs := q.queued[tenant]
+		job := jobs[0]
+		q.order = append(q.order[:i:i], q.order[i+1:]...)
+		if len(jobs) == 1 {
+			delete(q.queued, tenant)
+		} else {
+			q.queued[tenant] = jobs[1:]
+			// back of the line
+			q.order = append(q.order, tenant)
+		}
+		q.size--
+		return job
+	}
+	return nil
+}
+
+// done releases the tenant of a job returned by next.
+func (q *jobQueue) done(job *Job) {
+	q.tenants.release(job.Tenant)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.broadcast()
+}
@@ -0,0 +1,153 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestTenantOf(t *testing.T) {
+	tests := []struct {
+		depth int
+		req   JobRequest
+		want  string
+	}{
+		{0, JobRequest{Type: JobTypeCreate, Archive: "s3://bucket/customer-a/logs/a.tar"}, "bucket"},
+		{1, JobRequest{Type: JobTypeCreate, Archive: "s3://bucket/customer-a/logs/a.tar"}, "bucket/customer-a"},
+		{2, JobRequest{Type: JobTypeCreate, Archive: "s3://bucket/customer-a/logs/a.tar"}, "bucket/customer-a/logs"},
+		{3, JobRequest{Type: JobTypeCreate, Archive: "s3://bucket/customer-a/logs/a.tar"}, "bucket/customer-a/logs"},
+		{1, JobRequest{Type: JobTypeCreate, Archive: "s3://bucket/a.tar"}, "bucket"},
+		{1, JobRequest{Type: JobTypeExtract, Archive: "s3://archives/a.tar", Destination: "s3://bucket/customer-b/"}, "bucket/customer-b"},
+	}
+	for _, tt := range tests {
+		if got := (TenantLimits{PrefixDepth: tt.depth}).TenantOf(tt.req); got != tt.want {
+			t.Errorf("TenantOf(%d, %+v) = %q, want %q", tt.depth, tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	jobs := func(tenants ...string) []*Job {
+		var list []*Job
+		for i, tenant := range tenants {
+			list = append(list, &Job{ID: tenant + string(rune('0'+i)), Tenant: tenant})
+		}
+		return list
+	}
+	next := func(q *jobQueue) string {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if job := q.pop(); job != nil {
+			return job.ID
+		}
+		return ""
+	}
+
+	// the tenants take turns
+	q := newJobQueue(newTenants(TenantLimits{}), 10)
+	for _, job := range jobs("a", "a", "a", "b", "c") {
+		q.push(job)
+	}
+	var order []string
+	for id := next(q); id != ""; id = next(q) {
+		order = append(order, id)
+	}
+	if got := len(order); got != 5 || order[0] != "a0" || order[1] != "b3" || order[2] != "c4" || order[3] != "a1" || order[4] != "a2" {
+		t.Errorf("order = %v, want [a0 b3 c4 a1 a2]", order)
+	}
+
+	// a tenant at MaxJobs waits for its jobs to finish, the others go on
+	q = newJobQueue(newTenants(TenantLimits{MaxJobs: 1, Overrides: map[string]TenantLimits{"c": {MaxJobs: 2}}}), 4)
+	list := jobs("a", "a", "c", "c")
+	for _, job := range list {
+		q.push(job)
+	}
+	if q.push(&Job{Tenant: "b"}) {
+		t.Errorf("push() on a full queue = true")
+	}
+	if a, c, c2, none := next(q), next(q), next(q), next(q); a != "a0" || c != "c2" || c2 != "c3" || none != "" {
+		t.Errorf("next = %s %s %s %q, want a0 c2 c3 and none", a, c, c2, none)
+	}
+	q.done(list[0])
+	if got := next(q); got != "a1" {
+		t.Errorf("next after done = %q, want a1", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if job := q.next(ctx); job != nil {
+		t.Errorf("next() on an empty queue = %v", job)
+	}
+}
+
+func TestJobServerTenants(t *testing.T) {
+	archiver := &blockingArchiver{release: make(chan struct{}), started: make(chan *S3TarS3Options, 10)}
+	s := NewJobServer(archiver, ServerOptions{Workers: 3, QueueSize: 10, Tenants: TenantLimits{MaxJobs: 1}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	var ids []string
+	for _, archive := range []string{"s3://big/1.tar", "s3://big/2.tar", "s3://big/3.tar", "s3://small/1.tar"} {
+		job, err := s.Submit(JobRequest{Type: JobTypeCreate, Archive: archive, Source: "s3://src/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	// a job of each tenant runs, the third worker stays idle
+	started := map[string]int{}
+	for i := 0; i < 2; i++ {
+		started[(<-archiver.started).DstBucket]++
+	}
+	if started["big"] != 1 || started["small"] != 1 {
+		t.Errorf("started %v, want a job of big and small", started)
+	}
+	select {
+	case opts := <-archiver.started:
+		t.Errorf("s3://%s/%s started over the tenant limit", opts.DstBucket, opts.DstKey)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if job := s.Job(ids[3]); job.Tenant != "small" || job.Status != JobRunning {
+		t.Errorf("small job = %s %s", job.Tenant, job.Status)
+	}
+	close(archiver.release)
+	for _, id := range ids {
+		waitForStatus(t, s, id, JobSucceeded)
+	}
+}
+
+func TestWithRequestRate(t *testing.T) {
+	svc := s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient:  &scriptedHTTPClient{script: []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+	}, WithRequestRate)
+	tn := newTenants(TenantLimits{RequestsPerSecond: 2, Overrides: map[string]TenantLimits{"free": {}}})
+	ctx := tn.withRequestRate(context.Background(), "bucket")
+	if tn.withRequestRate(context.Background(), "free") != context.Background() {
+		t.Errorf("a tenant without a request rate got a limiter")
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a second of burst, the third request waits for half a second
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("3 requests at 2 per second took %s", d)
+	}
+	// the jobs of a tenant share its rate
+	if l := tn.withRequestRate(context.Background(), "bucket").Value(contextKeyRequestRate); l != ctx.Value(contextKeyRequestRate) {
+		t.Errorf("the jobs of a tenant have different limiters")
+	}
+}
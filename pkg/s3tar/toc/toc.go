@@ -0,0 +1,179 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package toc reads the TOC of the archives created by s3tar, so a service
+// finds where an entry is in an archive and reads it with a ranged
+// GetObject, without re-implementing the TOC format:
+//
+//	t, err := toc.OpenTOC(ctx, svc, "bucket", "archive.tar")
+//	if err != nil {
+//		return err
+//	}
+//	entry, ok := t.Lookup("dir/file.txt")
+//	if !ok {
+//		return fmt.Errorf("not in the archive")
+//	}
+//	out, err := svc.GetObject(ctx, &s3.GetObjectInput{
+//		Bucket: aws.String("bucket"),
+//		Key:    aws.String("archive.tar"),
+//		Range:  aws.String(entry.Range()),
+//	})
+package toc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar"
+)
+
+// Entry is an entry of the TOC.
+type Entry struct {
+	Name string
+	// Offset is the offset of the data of the entry in the archive, after
+	// its tar header.
+	Offset int64
+	Size   int64
+	// ETag is the ETag of the source object.
+	ETag string
+	// SHA256 is the hex sha256 of the data, when the archive was created
+	// with digests.
+	SHA256 string
+}
+
+// Range returns the HTTP Range of the data of the entry, for GetObject. It
+// returns an empty string for an empty entry, which has nothing to read.
+func (e Entry) Range() string {
+	if e.Size == 0 {
+		return ""
+	}
+	return fmt.Sprintf("bytes=%d-%d", e.Offset, e.Offset+e.Size-1)
+}
+
+// TOC is the table of contents of an archive, in the order of the entries.
+type TOC struct {
+	entries []Entry
+	byName  map[string]int
+}
+
+// OpenTOC reads the TOC of the archive s3://bucket/key, embedded as its first
+// entry or next to it when the archive was created without one. It returns
+// s3tar.ErrNoTOC for an archive with neither, e.g. one that wasn't created
+// by s3tar. svc is usually an *s3.Client.
+func OpenTOC(ctx context.Context, svc s3tar.Backend, bucket, key string) (*TOC, error) {
+	entries, err := s3tar.ReadTOC(ctx, svc, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return newTOC(entries), nil
+}
+
+// Parse reads a TOC from r, e.g. a toc.csv extracted from an archive or
+// written by --external-toc.
+func Parse(r io.Reader) (*TOC, error) {
+	entries, err := s3tar.ParseTOC(r)
+	if err != nil {
+		return nil, err
+	}
+	return newTOC(entries), nil
+}
+
+func newTOC(entries s3tar.TOC) *TOC {
+	t := &TOC{entries: make([]Entry, len(entries)), byName: make(map[string]int, len(entries))}
+	for i, e := range entries {
+		t.entries[i] = Entry{Name: e.Filename, Offset: e.Start, Size: e.Size, ETag: e.Etag, SHA256: e.SHA256}
+		// like tar, the last entry of a name wins
+		t.byName[e.Filename] = i
+	}
+	return t
+}
+
+// Len returns the number of entries.
+func (t *TOC) Len() int {
+	return len(t.entries)
+}
+
+// Lookup returns the entry with name, the last one if the archive has
+// several.
+func (t *TOC) Lookup(name string) (Entry, bool) {
+	i, ok := t.byName[name]
+	if !ok {
+		return Entry{}, false
+	}
+	return t.entries[i], true
+}
+
+// Iter returns an iterator over the entries, in the order of the archive:
+//
+//	it := t.Iter()
+//	for it.Next() {
+//		fmt.Println(it.Entry().Name)
+//	}
+func (t *TOC) Iter() *Iterator {
+	return &Iterator{toc: t, i: -1}
+}
+
+// Iterator iterates over the entries of a TOC.
+type Iterator struct {
+	toc *TOC
+	i   int
+}
+
+// Next advances to the next entry, it returns false after the last one.
+func (it *Iterator) Next() bool {
+	if it.i < len(it.toc.entries) {
+		it.i++
+	}
+	return it.i < len(it.toc.entries)
+}
+
+// Entry returns the current entry. Call it after Next returned true.
+func (it *Iterator) Entry() Entry {
+	return it.toc.entries[it.i]
+}
+
+// Span is the range of an archive holding the data of several entries.
+type Span struct {
+	Start, End int64
+	// Entries is the number of entries in the span.
+	Entries int
+	// Contiguous is false when the data of other entries is in the span
+	// too.
+	Contiguous bool
+}
+
+// Range returns the HTTP Range of the span, for GetObject or a restore.
+func (s Span) Range() string {
+	return fmt.Sprintf("bytes=%d-%d", s.Start, s.End)
+}
+
+// Span returns the range holding the data of the entries under prefix, from
+// the first one to the end of the last one. It returns false when no entry
+// with data is under prefix. The entries under a prefix ending with a slash
+// are contiguous in the archives created with ClusterByPrefix, they are read
+// with a single request and cut from the span with their offsets.
+func (t *TOC) Span(prefix string) (Span, bool) {
+	s := Span{Start: -1, Contiguous: true}
+	for _, e := range t.entries {
+		if e.Size == 0 || !strings.HasPrefix(e.Name, prefix) {
+			continue
+		}
+		s.Entries++
+		if s.Start < 0 || e.Offset < s.Start {
+			s.Start = e.Offset
+		}
+		s.End = max(s.End, e.Offset+e.Size-1)
+	}
+	if s.Entries == 0 {
+		return Span{}, false
+	}
+	for _, e := range t.entries {
+		if e.Size > 0 && !strings.HasPrefix(e.Name, prefix) && e.Offset <= s.End && e.Offset+e.Size-1 >= s.Start {
+			s.Contiguous = false
+			break
+		}
+	}
+	return s, true
+}
@@ -0,0 +1,136 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package toc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/awslabs/amazon-s3-tar-tool/pkg/s3tar"
+)
+
+func TestOpenTOC(t *testing.T) {
+	ctx := context.Background()
+	m := s3tar.NewMemoryBackend()
+	var pad int64 = 64 * 1024
+	m.MinPartSize = pad
+	files := map[string]string{
+		"src/a.txt":   "first file",
+		"src/b.txt":   strings.Repeat("b", 70000),
+		"src/empty":   "",
+		"src/d/c.bin": "nested",
+	}
+	var list []*s3tar.S3Obj
+	for key, data := range files {
+		m.Put("bucket", key, []byte(data))
+		head, _ := m.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key)})
+		o := s3tar.NewS3ObjOptions(s3tar.WithBucketAndKey("bucket", key), s3tar.WithSize(int64(len(data))), s3tar.WithETag(aws.ToString(head.ETag)))
+		o.LastModified = aws.Time(time.Now())
+		list = append(list, o)
+	}
+	for _, external := range []bool{false, true} {
+		t.Run(fmt.Sprintf("external %v", external), func(t *testing.T) {
+			key := fmt.Sprintf("archives/archive-%v.tar", external)
+			opts := &s3tar.S3TarS3Options{
+				SrcBucket:     "bucket",
+				DstBucket:     "bucket",
+				DstKey:        key,
+				DstPrefix:     s3tar.KeyDir(key),
+				Region:        "us-east-1",
+				Threads:       2,
+				PadSize:       pad,
+				NoEmbeddedTOC: external,
+			}
+			if err := s3tar.CreateFromListWithBackend(ctx, m, list, opts, s3tar.WithTarFormat("pax")); err != nil {
+				t.Fatal(err)
+			}
+			toc, err := OpenTOC(ctx, m, "bucket", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if toc.Len() != len(files) {
+				t.Fatalf("Len() = %d", toc.Len())
+			}
+			n := 0
+			for it := toc.Iter(); it.Next(); n++ {
+				e := it.Entry()
+				want, ok := files[e.Name]
+				if !ok || e.Size != int64(len(want)) {
+					t.Errorf("entry %+v", e)
+					continue
+				}
+				if e.Size == 0 {
+					if e.Range() != "" {
+						t.Errorf("Range() of an empty entry = %q", e.Range())
+					}
+					continue
+				}
+				out, err := m.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key), Range: aws.String(e.Range())})
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := io.ReadAll(out.Body)
+				if string(data) != want {
+					t.Errorf("%s = %q, want %q", e.Name, data, want)
+				}
+			}
+			if n != len(files) {
+				t.Errorf("the iterator returned %d entries", n)
+			}
+			if e, ok := toc.Lookup("src/d/c.bin"); !ok || e.Size != 6 {
+				t.Errorf("Lookup() = %+v, %v", e, ok)
+			}
+			if _, ok := toc.Lookup("missing"); ok {
+				t.Errorf("Lookup(missing) = true")
+			}
+		})
+	}
+
+	m.Put("bucket", "plain.tar", make([]byte, 1024))
+	if _, err := OpenTOC(ctx, m, "bucket", "plain.tar"); !errors.Is(err, s3tar.ErrNoTOC) {
+		t.Errorf("OpenTOC() without a TOC error = %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	toc, err := Parse(strings.NewReader("a,1024,3,etag\nb,2048,0,etag,abcd\na,3072,5,etag\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := toc.Lookup("a"); e.Offset != 3072 || e.Range() != "bytes=3072-3076" {
+		t.Errorf("Lookup(a) = %+v, the last entry of a name wins", e)
+	}
+	if e, _ := toc.Lookup("b"); e.SHA256 != "abcd" {
+		t.Errorf("Lookup(b) = %+v", e)
+	}
+	for _, bad := range []string{"a,1024\n", "a,x,3,etag\n", "a,1024,y,etag\n", "\"a,1024,3,etag\n"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("Parse(%q) error = nil", bad)
+		}
+	}
+}
+
+func TestSpan(t *testing.T) {
+	// a/ clustered, b/ scattered around c.txt
+	toc, err := Parse(strings.NewReader("a/1,1024,10,etag\na/2,2048,10,etag\na/empty,2560,0,etag\nb/1,3072,10,etag\nc.txt,4096,10,etag\nb/2,5120,10,etag\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := toc.Span("a/"); !ok || !s.Contiguous || s.Entries != 2 || s.Range() != "bytes=1024-2057" {
+		t.Errorf("Span(a/) = %+v, %v", s, ok)
+	}
+	if s, ok := toc.Span("b/"); !ok || s.Contiguous || s.Range() != "bytes=3072-5129" {
+		t.Errorf("Span(b/) = %+v, %v", s, ok)
+	}
+	if _, ok := toc.Span("d/"); ok {
+		t.Errorf("Span(d/) = true")
+	}
+}
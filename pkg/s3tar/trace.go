@@ -0,0 +1,193 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	contextKeyTraceLog    = contextKey("trace-log")
+	contextKeyObjectTrace = contextKey("object-trace")
+)
+
+// TraceCall is a request that read or copied a source object.
+type TraceCall struct {
+	Op string `json:"op"`
+	// Bucket, Key and PartNumber are the part the object was copied to
+	Bucket     string `json:"bucket,omitempty"`
+	Key        string `json:"key,omitempty"`
+	PartNumber int32  `json:"partNumber,omitempty"`
+	// Range is the range of the source object read or copied
+	Range string `json:"range,omitempty"`
+}
+
+// TraceRecord is a line of the trace log, an entry of an archive.
+type TraceRecord struct {
+	Time      time.Time `json:"time"`
+	Archive   string    `json:"archive"`
+	Name      string    `json:"name"`
+	Bucket    string    `json:"bucket,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	VersionId string    `json:"versionId,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
+	Size      int64     `json:"size"`
+	// HeaderOffset and DataOffset locate the tar header and the data of
+	// the entry, like the TOC. They aren't known for the archives built in
+	// memory or zip archives.
+	HeaderOffset int64 `json:"headerOffset,omitempty"`
+	DataOffset   int64 `json:"dataOffset,omitempty"`
+	// Part is the part of the archive the data starts in.
+	Part  int32       `json:"part,omitempty"`
+	Calls []TraceCall `json:"calls,omitempty"`
+}
+
+// TraceLog writes a JSON Lines record of every entry of the archives
+// created with its context to s3://bucket/key: where its header and data
+// are in the archive and the requests that read or copied it, to debug an
+// archive reported as corrupted.
+type TraceLog struct {
+	mu      sync.Mutex
+	w       jsonLines
+	entries int
+}
+
+// NewTraceLog returns a trace log written to s3://bucket/key by Close.
+func NewTraceLog(svc *s3.Client, bucket, key string) *TraceLog {
+	return &TraceLog{w: jsonLines{name: "trace log", svc: svc, bucket: bucket, key: key}}
+}
+
+// WithTraceLog returns a context that records the entries of the archives
+// created with it in log.
+func WithTraceLog(ctx context.Context, log *TraceLog) context.Context {
+	return context.WithValue(ctx, contextKeyTraceLog, log)
+}
+
+func traceLogFromContext(ctx context.Context) *TraceLog {
+	if l, ok := ctx.Value(contextKeyTraceLog).(*TraceLog); ok {
+		return l
+	}
+	return nil
+}
+
+// Record appends r to the log, with the current time when r has none.
+func (l *TraceLog) Record(ctx context.Context, r TraceRecord) {
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries++
+	l.w.write(ctx, r)
+}
+
+// Close writes the log.
+func (l *TraceLog) Close(ctx context.Context) error {
+	ctx = context.WithoutCancel(ctx)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.close(ctx); err != nil {
+		return err
+	}
+	Infof(ctx, "trace log s3://%s/%s: %d entries", l.w.bucket, l.w.key, l.entries)
+	return nil
+}
+
+// objectTrace collects the requests made for the source objects of an
+// archive while it is built.
+type objectTrace struct {
+	mu    sync.Mutex
+	calls map[string][]TraceCall
+}
+
+// withObjectTrace returns a context collecting the requests made for the
+// source objects when it has a trace log, and the collected requests.
+func withObjectTrace(ctx context.Context) (context.Context, *objectTrace) {
+	if traceLogFromContext(ctx) == nil {
+		return ctx, nil
+	}
+	t := &objectTrace{calls: map[string][]TraceCall{}}
+	return context.WithValue(ctx, contextKeyObjectTrace, t), t
+}
+
+// traceID identifies a source object, the copies of an S3Obj made while
+// grouping the objects have the same id.
+func traceID(o *S3Obj) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%d", o.Bucket, aws.ToString(o.Key), o.VersionId, o.Offset)
+}
+
+// traceCall records a request made for o, when ctx collects them.
+func traceCall(ctx context.Context, o *S3Obj, c TraceCall) {
+	t, ok := ctx.Value(contextKeyObjectTrace).(*objectTrace)
+	if !ok {
+		return
+	}
+	id := traceID(o)
+	t.mu.Lock()
+	t.calls[id] = append(t.calls[id], c)
+	t.mu.Unlock()
+}
+
+// traceArchive records the entries of a complete archive. The offsets are
+// computed like the TOC when withOffsets is set.
+func traceArchive(ctx context.Context, svc Backend, t *objectTrace, opts *S3TarS3Options, entries []*S3Obj, withOffsets bool) {
+	l := traceLogFromContext(ctx)
+	if l == nil || t == nil {
+		return
+	}
+	archive := fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey)
+	var offsets []EntryOffset
+	var partSize int64
+	var parts int32
+	if withOffsets {
+		var err error
+		offsets, err = jobConfigFromContext(ctx).trimmedOffsets(entries, opts.tocTrim)
+		if err != nil {
+			Warnf(ctx, "unable to locate the entries of %s: %s", archive, err.Error())
+			return
+		}
+		// the parts of an archive are the same size but the last one
+		head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &opts.DstBucket, Key: &opts.DstKey, PartNumber: aws.Int32(1)})
+		if err != nil {
+			Warnf(ctx, "unable to read the parts of %s: %s", archive, err.Error())
+		} else if head.PartsCount != nil {
+			partSize, parts = aws.ToInt64(head.ContentLength), *head.PartsCount
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, o := range entries {
+		r := TraceRecord{
+			Archive:   archive,
+			Name:      o.entryName(),
+			Bucket:    o.Bucket,
+			Key:       aws.ToString(o.Key),
+			VersionId: o.VersionId,
+			ETag:      aws.ToString(o.ETag),
+			Size:      aws.ToInt64(o.Size),
+			Calls:     t.calls[traceID(o)],
+		}
+		if len(o.Data) > 0 {
+			// generated entries, e.g. metadata sidecars, have no source
+			r.Bucket, r.Key, r.ETag = "", "", ""
+			r.Calls = nil
+		}
+		if offsets != nil {
+			r.HeaderOffset, r.DataOffset = offsets[i].HeaderStart, offsets[i].Start
+			if partSize > 0 {
+				r.Part = int32(r.DataOffset/partSize) + 1
+				if r.Part > parts {
+					r.Part = parts
+				}
+			}
+		}
+		l.Record(ctx, r)
+	}
+}
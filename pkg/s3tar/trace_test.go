@@ -0,0 +1,108 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// traceHTTPClient answers the HeadObject of the first part of an archive
+// of parts parts of partSize bytes, and keeps the last PutObject.
+type traceHTTPClient struct {
+	auditHTTPClient
+	partSize string
+	parts    string
+}
+
+func (c traceHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	resp, err := c.auditHTTPClient.Do(r)
+	if r.Method == http.MethodHead {
+		resp.Header.Set("Content-Length", c.partSize)
+		resp.ContentLength = 0
+		resp.Header.Set("x-amz-mp-parts-count", c.parts)
+	}
+	return resp, err
+}
+
+func TestTraceLog(t *testing.T) {
+	var body bytes.Buffer
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   traceHTTPClient{auditHTTPClient{body: &body}, "8192", "2"},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	log := NewTraceLog(svc, "trace-bucket", "trace.jsonl")
+	ctx, objTrace := withObjectTrace(WithTraceLog(context.Background(), log))
+	if objTrace == nil {
+		t.Fatal("expected the requests to be collected")
+	}
+
+	a := NewS3ObjOptions(WithBucketAndKey("src-bucket", "prefix/a.txt"), WithSize(10000), WithETag("etag-a"))
+	a.VersionId = "v1"
+	b := NewS3ObjOptions(WithBucketAndKey("src-bucket", "prefix/b.txt"), WithSize(20))
+	// the copies made while grouping the objects are the same object
+	copyA := *a
+	copyA.PartNum = 3
+	traceCall(ctx, &copyA, TraceCall{Op: "UploadPartCopy", Bucket: "dst-bucket", Key: "archive.tar", PartNumber: 1, Range: "bytes=0-9999"})
+	traceCall(ctx, b, TraceCall{Op: "GetObject"})
+
+	opts := &S3TarS3Options{DstBucket: "dst-bucket", DstKey: "archive.tar"}
+	traceArchive(ctx, svc, objTrace, opts, []*S3Obj{a, b}, true)
+	if err := log.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []TraceRecord
+	s := bufio.NewScanner(&body)
+	for s.Scan() {
+		// the body is sent aws-chunked with its checksum
+		if !bytes.HasPrefix(s.Bytes(), []byte("{")) {
+			continue
+		}
+		var r TraceRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("%s: %s", s.Text(), err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	offsets, err := ComputeOffsets([]*S3Obj{a, b}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		name  string
+		part  int32
+		calls int
+	}{{"prefix/a.txt", 1, 1}, {"prefix/b.txt", 2, 1}} {
+		r := records[i]
+		if r.Archive != "s3://dst-bucket/archive.tar" || r.Name != want.name {
+			t.Errorf("record %d: unexpected %s %s", i, r.Archive, r.Name)
+		}
+		if r.HeaderOffset != offsets[i].HeaderStart || r.DataOffset != offsets[i].Start {
+			t.Errorf("%s: expected offsets %d %d, got %d %d", r.Name, offsets[i].HeaderStart, offsets[i].Start, r.HeaderOffset, r.DataOffset)
+		}
+		if r.Part != want.part {
+			t.Errorf("%s: expected part %d, got %d", r.Name, want.part, r.Part)
+		}
+		if len(r.Calls) != want.calls {
+			t.Errorf("%s: expected %d calls, got %v", r.Name, want.calls, r.Calls)
+		}
+	}
+	if records[0].VersionId != "v1" || records[0].Calls[0].Range != "bytes=0-9999" {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+}
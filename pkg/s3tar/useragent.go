@@ -0,0 +1,60 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const contextKeyJobID = contextKey("job-id")
+
+// withJobID returns ctx with the id of the job, the requests sent with it
+// carry it in their user agent, see WithJobUserAgent.
+func withJobID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKeyJobID, id)
+}
+
+func jobIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyJobID).(string)
+	return id
+}
+
+// WithJobUserAgent appends s3tar-job/<id> to the user agent of the requests
+// sent for an archive, the id is the one of its .parts prefix. The user
+// agent is recorded by the server access logs and CloudTrail, the requests
+// of a job can be told apart from the other jobs of the same role. Use it
+// when creating the client:
+//
+//	svc := s3.NewFromConfig(cfg, s3tar.WithJobUserAgent)
+func WithJobUserAgent(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("S3TarJobUserAgent",
+			func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					if id := jobIDFromContext(ctx); id != "" {
+						ua := req.Header.Get("User-Agent")
+						req.Header.Set("User-Agent", strings.TrimSpace(ua+" s3tar-job/"+id))
+					}
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+	})
+}
+
+// ParseUserAgentTag parses a key=value tag of the user agent, e.g.
+// team=backup. The tag is sent as key/value, the characters the user agent
+// doesn't allow are replaced with - by the SDK.
+func ParseUserAgentTag(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" || value == "" {
+		return "", "", fmt.Errorf("invalid user agent tag %q, use key=value", s)
+	}
+	return key, value, nil
+}
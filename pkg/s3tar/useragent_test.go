@@ -0,0 +1,65 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type userAgentHTTPClient struct {
+	agents *[]string
+}
+
+func (c userAgentHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	*c.agents = append(*c.agents, r.Header.Get("User-Agent"))
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    r,
+	}, nil
+}
+
+func TestJobUserAgent(t *testing.T) {
+	var agents []string
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   userAgentHTTPClient{agents: &agents},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+		AppID:        "nightly-backup",
+	}, WithJobUserAgent)
+
+	ctx := context.Background()
+	input := &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key")}
+	if _, err := svc.HeadObject(withJobID(ctx, "0a1b2c3d"), input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.HeadObject(ctx, input); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(agents[0], " s3tar-job/0a1b2c3d") || !strings.Contains(agents[0], "app/nightly-backup") {
+		t.Errorf("got %q", agents[0])
+	}
+	if strings.Contains(agents[1], "s3tar-job/") {
+		t.Errorf("got %q outside a job", agents[1])
+	}
+
+	if _, _, err := ParseUserAgentTag("team=backup"); err != nil {
+		t.Error(err)
+	}
+	for _, tag := range []string{"team", "=backup", "team="} {
+		if _, _, err := ParseUserAgentTag(tag); err == nil {
+			t.Errorf("%q: expected an error", tag)
+		}
+	}
+}
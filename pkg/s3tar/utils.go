@@ -0,0 +1,861 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type contextKey string
+
+const (
+	contextKeyS3Client = contextKey("s3-client")
+	contextKeyJob      = contextKey("job")
+)
+
+var (
+	extractS3 = regexp.MustCompile(`s3://(.[^/]*)/?(.*)`)
+)
+
+// S3TarS3Options options to create an archive
+type S3TarS3Options struct {
+	SrcManifest           string
+	SkipManifestHeader    bool
+	SrcBucket             string
+	SrcPrefix             string
+	SrcKey                string
+	DstBucket             string
+	DstPrefix             string
+	DstKey                string
+	Threads               int
+	DeleteSource          bool
+	Region                string
+	EndpointUrl           string
+	ExternalToc           string
+	tarFormat             tar.Format
+	zipFormat             bool
+	formatErr             error
+	storageClass          types.StorageClass
+	extractPrefix         string
+	ConcatInMemory        bool
+	UrlDecode             bool
+	UserMaxPartSize       int64
+	ObjectTags            types.Tagging
+	KMSKeyID              string
+	SSEAlgo               types.ServerSideEncryption
+	PreservePOSIXMetadata bool
+	Dedup                 bool
+	StrictUSTARChecksum   bool
+	SHA256Digests         bool
+	ManifestColumns       []string
+	ManifestDelimiter     rune
+	ManifestLazyQuotes    bool
+	// Stats receives a report of the archive when it has been created.
+	Stats io.Writer
+	// StatsJSON writes the Stats report as JSON, see ArchiveStats.
+	StatsJSON bool
+	// Presigned receives a JSON line with a pre-signed GET URL of the archive,
+	// valid for PresignExpiry, when it has been created.
+	Presigned     io.Writer
+	PresignExpiry time.Duration
+	// HTMLReport writes a static HTML page listing the entries of the archive
+	// to ReportKey(DstKey) once it is complete. See WriteHTMLReport.
+	HTMLReport bool
+	// InterruptPolicy is InterruptAbort or InterruptKeep. It decides what
+	// happens to the multipart uploads in flight when the run is interrupted.
+	InterruptPolicy string
+	// Resume reuses the groups completed by an interrupted run. Extractions
+	// skip the entries extracted by the previous run.
+	Resume bool
+	// ExtractPartSize is the largest part, in bytes, an entry is copied in
+	// when extracting. Defaults to 5GiB, larger entries are copied in parts.
+	ExtractPartSize int64
+	// CheckKeys is CheckKeysError or CheckKeysSkip to check the keys before
+	// the archive is created, e.g. for control characters or .. segments.
+	CheckKeys string
+	// OnConflict is ConflictSuffix, ConflictFail or ConflictKeepFirst for
+	// the objects archived under the name of an earlier entry. Without it
+	// the duplicates are archived and logged.
+	OnConflict string
+	// BucketKeyEnabled encrypts the archive and the objects it is built from
+	// with an S3 Bucket Key of the KMS key, S3 then calls KMS once per bucket
+	// key instead of once per object and part copied. It requires aws:kms.
+	BucketKeyEnabled bool
+	// ArchiveMeta are key/values describing the archive, e.g. its owner or
+	// a ticket, written to a toc.meta.json entry after the TOC.
+	ArchiveMeta map[string]string
+	// NoEmbeddedTOC leaves the toc.csv entry out of the archive, which only
+	// has the objects. The TOC is written next to it instead, see
+	// ExternalTOCKey. The archives built in memory have no TOC either way.
+	NoEmbeddedTOC bool
+	// EOFPadding is EOFPaddingCompat, the default, or EOFPaddingPOSIX for
+	// the readers rejecting a trailer larger than two zero blocks.
+	EOFPadding string
+	// SkipPreflight skips the checks of the buckets and the KMS key before
+	// the archive is created, see Preflight.
+	SkipPreflight bool
+	// CheckPermissions adds a dry write to the pre-flight checks: a byte of
+	// the first source object is copied to a multipart upload under the
+	// parts prefix, which is completed and deleted.
+	CheckPermissions bool
+	// ClientEncryption encrypts the archive before it is uploaded. Only the
+	// in-memory mode downloads the data, so it requires ConcatInMemory or an
+	// archive smaller than 5MB.
+	ClientEncryption *ClientEncryption
+	// Signer signs the archive once it is complete, the signature is written
+	// to SignatureKey(DstKey). See SignArchive.
+	Signer *ArchiveSigner
+	// MetadataSidecars adds a <key>.metadata.json entry after every object
+	// with its Content-Type, tags, ACL and user metadata.
+	MetadataSidecars bool
+	// RestoreMetadata sets the Content-Type, Cache-Control and user metadata
+	// of the extracted objects from the metadata sidecars and PAX records.
+	RestoreMetadata bool
+	// Overwrite replaces the archive when it already exists, otherwise the
+	// run fails with ErrArchiveExists.
+	Overwrite bool
+	// CheckNetwork resolves and connects to the S3 endpoint in the
+	// pre-flight, and warns when the requests go through a NAT, see
+	// InspectNetwork.
+	CheckNetwork bool
+	// RequireGatewayEndpoint fails the pre-flight unless the requests go
+	// through an S3 gateway endpoint.
+	RequireGatewayEndpoint bool
+	// KeepIntermediates leaves the intermediate objects under the .parts
+	// prefix of the run in place, to debug a failed run.
+	KeepIntermediates bool
+	// PadSize is the minimum part size of the destination, 5MB by default.
+	// Parts smaller than it are concatenated after a pad of PadSize bytes
+	// that is removed at the end. Stores with a different minimum part size
+	// set it to theirs. The pad is skipped when the first part is already
+	// large enough.
+	PadSize int64
+	// NoncurrentVersions archives the noncurrent versions under SrcPrefix
+	// instead of the current objects, see ListNoncurrentVersions.
+	NoncurrentVersions bool
+	// AllVersions archives every version under SrcPrefix, the current ones
+	// too, see ListAllVersions.
+	AllVersions bool
+	// TOCMemoryLimit is the largest TOC kept in memory, 64MB by default.
+	// Larger TOCs are written to a temporary object under the parts prefix
+	// and copied into the archive.
+	TOCMemoryLimit int64
+	// ArchiveRoot is a top-level directory every entry is placed under,
+	// e.g. backup-2024-01/, like the tarballs extracted to a directory of
+	// their own. See CheckArchiveRoot.
+	ArchiveRoot string
+	// ClusterByPrefix orders the entries by their directory so the entries
+	// under a prefix are adjacent in the archive and can be restored with a
+	// single range, see Span in the toc package.
+	ClusterByPrefix bool
+	// StageHeaders uploads the tar headers of the small objects once, in a
+	// single object under the parts prefix, and the merges copy them from
+	// it instead of uploading every header as a part of its own.
+	StageHeaders bool
+	// VerifySizes checks the size of every source object with a HeadObject
+	// before the archive is built, see ErrSourceSizeMismatch. The downloads
+	// of the in-memory mode are always checked.
+	VerifySizes bool
+	// TrustListing never sends a HeadObject per source object, the sizes of
+	// the listing or the manifest are written in the headers as is. It turns
+	// VerifySizes off. The size of the archive is still checked once it is
+	// built, and only when it is wrong are the sources checked with a
+	// HeadObject each to name the objects that don't match.
+	TrustListing bool
+	// Align pads the tar headers so the data of every entry starts at a
+	// multiple of Align bytes in the archive, for readers reading it by
+	// aligned blocks. It must be a multiple of 512, see ParseAlign. Only PAX
+	// archives with a TOC are aligned.
+	Align int64
+	// HeartbeatInterval writes the status of the job to
+	// <DstKey>.heartbeat.json at that interval, for monitors without access
+	// to the logs, see HeartbeatStatus.
+	HeartbeatInterval time.Duration
+	// jobID makes the intermediate keys of the run unique, see partsKey.
+	jobID string
+	// tocTrim is the size of the TOC entry cut from the front of the
+	// archive with NoEmbeddedTOC.
+	tocTrim int64
+}
+
+func (o *S3TarS3Options) manifestOptions() ManifestOptions {
+	return ManifestOptions{
+		SkipHeader: o.SkipManifestHeader,
+		UrlDecode:  o.UrlDecode,
+		Columns:    o.ManifestColumns,
+		Delimiter:  o.ManifestDelimiter,
+		LazyQuotes: o.ManifestLazyQuotes,
+	}
+}
+
+func TagsToUrlEncodedString(tagging types.Tagging) string {
+
+	vals := url.Values{}
+	for _, x := range tagging.TagSet {
+		vals.Add(*x.Key, *x.Value)
+	}
+	return vals.Encode()
+
+}
+
+func (o *S3TarS3Options) Copy() S3TarS3Options {
+	to := *o
+	return to
+}
+
+func findMinMaxPartRange(objectSize int64) (int64, int64, int64) {
+	const (
+		KB          int64 = 1024
+		partsLimit  int64 = 10000
+		partSizeMin int64 = KB * KB * 5
+		partSizeMax int64 = KB * KB * KB * 5
+		// optimalSize = 1024 * 1024 * 16
+	)
+
+	// partSizeMin = 1000 * 1000 * 5
+	// partSizeMax = 5e+9
+	// curSize = 5e+12 #5TB
+
+	curSize := objectSize
+	nPartsMax := partsLimit
+	var nPartsMaxSize int64 = 0
+	for {
+		nPartsMaxSize = curSize / nPartsMax
+		// an object under the minimum part size, e.g. with a smaller
+		// PadSize, is a single part
+		if nPartsMaxSize < partSizeMin && nPartsMax > 1 {
+			nPartsMax = nPartsMax - 1
+			continue
+		}
+		break
+	}
+
+	var nPartsMin int64 = 1
+	var nPartsMinSize int64 = 0
+	for {
+		nPartsMinSize = curSize / nPartsMin
+		if nPartsMinSize > partSizeMax {
+			nPartsMin += 1
+			continue
+		}
+		break
+	}
+	mid := nPartsMax / 2
+	return nPartsMin, nPartsMax, mid
+}
+
+type PartsMessage struct {
+	Parts   []*S3Obj
+	PartNum int
+}
+
+func NewS3Obj() *S3Obj {
+	now := time.Now()
+	return &S3Obj{
+		Object: types.Object{
+			Key:          aws.String(""),
+			ETag:         aws.String(""),
+			LastModified: &now,
+		},
+	}
+}
+
+func NewS3ObjOptions(options ...func(*S3Obj)) *S3Obj {
+	now := time.Now()
+	obj := &S3Obj{
+		Object: types.Object{
+			Key:          aws.String(""),
+			ETag:         aws.String(""),
+			LastModified: &now,
+		},
+	}
+	for _, o := range options {
+		o(obj)
+	}
+	return obj
+}
+
+func WithBucketAndKey(bucket, key string) func(*S3Obj) {
+	return func(o *S3Obj) {
+		o.Bucket = bucket
+		o.Key = &key
+	}
+}
+func WithSize(size int64) func(*S3Obj) {
+	return func(o *S3Obj) {
+		o.Size = &size
+	}
+}
+func WithETag(etag string) func(*S3Obj) {
+	return func(o *S3Obj) {
+		o.ETag = &etag
+	}
+}
+
+func NewS3ObjFromObject(o types.Object) *S3Obj {
+	return &S3Obj{Object: o}
+}
+
+func StringToInt64(s string) (int64, error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return i, nil
+}
+
+type S3Obj struct {
+	types.Object
+	Bucket           string
+	PartNum          int
+	Data             []byte
+	NoHeaderRequired bool
+	// LinkTarget is set when this object is stored as a hardlink to an
+	// identical object earlier in the archive.
+	LinkTarget string
+	// SHA256 is the hex encoded digest recorded in the TOC, if computed.
+	SHA256 string
+	// Attributes are set once fetched with FetchObjectAttributes.
+	Attributes *ObjectAttributes
+	// VersionId selects a specific version of the source object.
+	VersionId string
+	// Name is the name of the entry when it isn't Key, like a TOC written
+	// under the parts prefix or a noncurrent version.
+	Name string
+	// Slice is set when the entry is only the Size bytes of the source
+	// object from Offset, e.g. a day of a rolling log object.
+	Slice  bool
+	Offset int64
+	// Xattrs are the extended attributes of the entry, e.g. an SELinux
+	// label, written as SCHILY.xattr.<name> PAX records. See ParseXattrs.
+	Xattrs map[string]string
+	// alignPad is the padding added to the tar header so the data starts
+	// aligned, see alignEntries.
+	alignPad int64
+}
+
+// entryName is the name of the object in the archive.
+func (s *S3Obj) entryName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return *s.Key
+}
+
+func (s *S3Obj) AddData(data []byte) {
+	etag := fmt.Sprintf("%x", md5.Sum(data))
+	s.Data = data
+	s.Size = aws.Int64(int64(len(data)))
+	s.ETag = &etag
+}
+
+type VirtualArchive []*S3Obj
+
+type byPartNum []*S3Obj
+
+func (a byPartNum) Len() int           { return len(a) }
+func (a byPartNum) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPartNum) Less(i, j int) bool { return a[i].PartNum < a[j].PartNum }
+
+type Index struct {
+	Start int
+	End   int
+	Size  int
+	// DataFirst is set when the group starts with the data of its first
+	// object, a large one, and its header ends the group before.
+	DataFirst bool
+}
+
+func findPadding(offset int64) (n int64) {
+	return -offset & (blockSize - 1)
+}
+
+type Logger struct {
+	Level int
+}
+
+// ExtractBucketAndPath helper function to extract bucket and key from s3://bucket/prefix/key URLs
+func ExtractBucketAndPath(s3url string) (bucket string, path string) {
+	if strings.HasPrefix(s3url, "s3://arn:") {
+		if bucket, path, ok := splitBucketARN(strings.TrimPrefix(s3url, "s3://")); ok {
+			return bucket, path
+		}
+	}
+	parts := extractS3.FindAllStringSubmatch(s3url, -1)
+	if len(parts) > 0 && len(parts[0]) > 2 {
+		bucket = parts[0][1]
+		path = parts[0][2]
+	}
+	return
+}
+
+func filter[T any](ss []T, test func(T) bool) (ret []T) {
+	for _, s := range ss {
+		if test(s) {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+func removeDirs(object types.Object) bool {
+	name := *object.Key
+	if string(name[len(name)-1]) == "/" {
+		return false
+	}
+	return true
+}
+
+func ListAllObjects(ctx context.Context, client *s3.Client, Bucket, Prefix string, filterFns ...func(types.Object) bool) ([]*S3Obj, int64, error) {
+	return listAllObjects(ctx, client, Bucket, Prefix, filterFns...)
+}
+
+// listAllObjects is ListAllObjects for any backend.
+func listAllObjects(ctx context.Context, client Backend, Bucket, Prefix string, filterFns ...func(types.Object) bool) ([]*S3Obj, int64, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: &Bucket,
+		Prefix: &Prefix,
+	}
+	var accum int64
+	start := time.Now()
+
+	ctr := 1
+	var list []*S3Obj
+	var defaultFilter []func(types.Object) bool
+	defaultFilter = append(defaultFilter, removeDirs)
+	allFilters := append(defaultFilter, filterFns...)
+
+	checkpoint := listCheckpointFromContext(ctx)
+	if checkpoint != nil {
+		resumed, err := checkpoint.resume(ctx, client, Bucket, Prefix)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, o := range resumed {
+			o.PartNum = ctr
+			ctr += 1
+			accum += estimateObjectSize(*o.Size)
+		}
+		list = resumed
+		if len(resumed) > 0 {
+			Infof(ctx, "resuming the listing of s3://%s/%s from %d objects", Bucket, Prefix, len(resumed))
+		}
+		if checkpoint.state.Complete {
+			recordStage(ctx, "list", start)
+			return list, accum, nil
+		}
+		if checkpoint.state.ContinuationToken != "" {
+			input.ContinuationToken = aws.String(checkpoint.state.ContinuationToken)
+		}
+	}
+
+	limits := selectionLimitsFromContext(ctx)
+	var listedBytes int64
+	for _, o := range list {
+		listedBytes += *o.Size
+	}
+	resumedCount := len(list)
+	logged := start
+	for {
+		output, err := listPage(ctx, client, input)
+		if err != nil {
+			Debugf(ctx, "unable to list s3://%s/%s: %s", Bucket, Prefix, err.Error())
+			if checkpoint != nil {
+				// keep what was listed for the next run
+				if cpErr := checkpoint.write(context.WithoutCancel(ctx), client); cpErr != nil {
+					Warnf(ctx, "unable to write the listing checkpoint: %s", cpErr.Error())
+				}
+			}
+			return list, accum, err
+		}
+		contents := output.Contents
+		if len(allFilters) > 0 {
+			for _, tf := range allFilters {
+				contents = filter(contents, tf)
+			}
+		}
+		page := make([]*S3Obj, 0, len(contents))
+		for _, o := range contents {
+			page = append(page, &S3Obj{
+				Object:  o,
+				Bucket:  Bucket,
+				PartNum: ctr,
+			})
+			ctr += 1
+			accum += estimateObjectSize(*o.Size)
+			listedBytes += *o.Size
+		}
+		list = append(list, page...)
+		if limits.exceeded(len(list), listedBytes) {
+			return list, accum, &SelectionLimitError{Limits: limits, Objects: len(list), Bytes: listedBytes}
+		}
+		if checkpoint != nil {
+			if err := checkpoint.add(ctx, client, page, output.NextContinuationToken); err != nil {
+				return list, accum, err
+			}
+		}
+		if time.Since(logged) >= listProgressInterval {
+			logged = time.Now()
+			Infof(ctx, "listed %d objects, %.0f keys/s", len(list), float64(len(list)-resumedCount)/time.Since(start).Seconds())
+		}
+		if !aws.ToBool(output.IsTruncated) || output.NextContinuationToken == nil {
+			break
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+	if checkpoint != nil {
+		checkpoint.state.Complete = true
+		if err := checkpoint.write(ctx, client); err != nil {
+			return list, accum, err
+		}
+	}
+
+	recordStage(ctx, "list", start)
+	return list, accum, nil
+}
+
+// estimate the object size including header and padding
+func estimateObjectSize(size int64) int64 {
+	pad := findPadding(size)
+	return int64(paxTarHeaderSize) + size + pad
+}
+
+func BreakUpList(objectList []*S3Obj, limitSize int64) [][]*S3Obj {
+
+	var list [][]*S3Obj
+	var currentList []*S3Obj
+	var accum int64 = 0
+	for i := 0; i < len(objectList); i++ {
+		currentObjectSize := estimateObjectSize(*objectList[i].Size)
+		if accum+currentObjectSize < limitSize {
+			currentList = append(currentList, objectList[i])
+			accum += currentObjectSize
+		} else {
+			list = append(list, currentList)
+			currentList = append([]*S3Obj{}, objectList[i])
+			accum = currentObjectSize
+		}
+	}
+	if len(currentList) > 0 {
+		list = append(list, currentList)
+	}
+	return list
+}
+
+func putObject(ctx context.Context, svc Backend, bucket, key string, data []byte) (*s3.PutObjectOutput, error) {
+	input := &s3.PutObjectInput{
+		Bucket:        &bucket,
+		Key:           &key,
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	}
+	return svc.PutObject(ctx, input)
+}
+
+func getObject(ctx context.Context, svc Backend, bucket, key string) (io.ReadCloser, error) {
+	return getObjectRange(ctx, svc, bucket, key, 0, 0)
+}
+
+// getObjectVersion streams obj, the version it selects if any.
+func getObjectVersion(ctx context.Context, svc Backend, obj *S3Obj) (io.ReadCloser, error) {
+	params := &s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    obj.Key,
+	}
+	if obj.VersionId != "" {
+		params.VersionId = aws.String(obj.VersionId)
+	}
+	if obj.Slice {
+		params.Range = aws.String(copySourceRange(obj, 0, *obj.Size))
+	}
+	output, err := svc.GetObject(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	traceCall(ctx, obj, TraceCall{Op: "GetObject", Range: aws.ToString(params.Range)})
+	return limitReader(ctx, output.Body), nil
+}
+func getObjectRange(ctx context.Context, svc Backend, bucket, key string, start, end int64) (io.ReadCloser, error) {
+	params := &s3.GetObjectInput{
+		Key:    &key,
+		Bucket: &bucket,
+	}
+	if end != 0 {
+		byteRange := fmt.Sprintf("bytes=%d-%d", start, end)
+		params.Range = &byteRange
+	}
+	output, err := svc.GetObject(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return limitReader(ctx, output.Body), nil
+}
+
+// loadFile opens a local file or streams an object from s3://bucket/key.
+// Gzip compressed files are decompressed on the fly.
+func loadFile(ctx context.Context, svc *s3.Client, path string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	var err error
+	if strings.Contains(path, "s3://") {
+		bucket, key := ExtractBucketAndPath(path)
+		r, err = getObject(ctx, svc, bucket, key)
+	} else {
+		r, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return maybeGunzip(r)
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// maybeGunzip wraps r with a gzip reader when the stream starts with the gzip
+// magic bytes, otherwise the data is returned as is.
+func maybeGunzip(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		r.Close()
+		return nil, err
+	}
+	body := struct {
+		io.Reader
+		io.Closer
+	}{br, r}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: zr, body: r}, nil
+}
+
+// DeleteAllMultiparts helper function to clear ALL MultipartUploads in a bucket. This will delete all incomplete (or in progress) MPUs for a bucket.
+func DeleteAllMultiparts(client *s3.Client, bucket string) error {
+	output, err := client.ListMultipartUploads(context.TODO(), &s3.ListMultipartUploadsInput{Bucket: &bucket})
+	if err != nil {
+		return err
+	}
+	for _, upload := range output.Uploads {
+		Infof(context.Background(), "Aborting %s", *upload.UploadId)
+		_, err := client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		if err != nil {
+			Infof(context.Background(), err.Error())
+			return err
+		}
+		// log.Printf("AbortedMultiUpload ok %s", r)
+	}
+	return nil
+}
+
+// deleteConcurrency is the number of DeleteObjects requests in flight when
+// a list of objects is deleted, every one of up to deleteObjectsMax keys.
+const deleteConcurrency = 4
+
+// deleteAttempts is how many times a key a DeleteObjects request reported
+// an error for, e.g. SlowDown, is tried.
+const deleteAttempts = 3
+
+// deleteRetryDelay is the wait before the keys that couldn't be deleted are
+// tried again, doubled every attempt.
+var deleteRetryDelay = time.Second
+
+// deleteObjectList deletes the objects of a bucket in batches of
+// deleteObjectsMax keys, deleteConcurrency batches at a time. The failed
+// requests are retried by the client, the keys a batch reports errors for
+// are retried here.
+func deleteObjectList(ctx context.Context, svc Backend, objectList []*S3Obj) error {
+	var g errgroup.Group
+	g.SetLimit(deleteConcurrency)
+	for i := 0; i < len(objectList); i += deleteObjectsMax {
+		batch := objectList[i:min(i+deleteObjectsMax, len(objectList))]
+		keys := make([]types.ObjectIdentifier, len(batch))
+		for j, o := range batch {
+			keys[j] = types.ObjectIdentifier{Key: o.Key}
+		}
+		g.Go(func() error {
+			return deleteKeys(ctx, svc, batch[0].Bucket, keys)
+		})
+	}
+	return g.Wait()
+}
+
+// deleteKeys deletes keys with a single DeleteObjects request, up to
+// deleteAttempts times for the keys it reports errors for.
+func deleteKeys(ctx context.Context, svc Backend, bucket string, keys []types.ObjectIdentifier) error {
+	delay := deleteRetryDelay
+	for attempt := 1; ; attempt++ {
+		output, err := svc.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Quiet: aws.Bool(true), Objects: keys},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete %d objects from s3://%s: %w", len(keys), bucket, err)
+		}
+		if len(output.Errors) == 0 {
+			return nil
+		}
+		if attempt == deleteAttempts {
+			e := output.Errors[0]
+			return fmt.Errorf("unable to delete %d objects from s3://%s, e.g. %s: %s", len(output.Errors), bucket, aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+		keys = make([]types.ObjectIdentifier, len(output.Errors))
+		for i, e := range output.Errors {
+			keys[i] = types.ObjectIdentifier{Key: e.Key, VersionId: e.VersionId}
+		}
+		Debugf(ctx, "retrying the deletion of %d objects from s3://%s", len(keys), bucket)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// splitCopyRange breaks the byte range [start, end) into ranges that fit into a
+// single UploadPartCopy (5GiB max). The ranges are evenly sized so none of
+// them ends up under the 5MiB part minimum.
+func splitCopyRange(start, end int64) [][2]int64 {
+	return splitRange(start, end, partSizeMax)
+}
+
+// splitRange breaks the byte range [start, end) into evenly sized ranges of
+// at most partSize bytes. Only the last range can be under 5MiB.
+func splitRange(start, end, partSize int64) [][2]int64 {
+	length := end - start
+	n := (length + partSize - 1) / partSize
+	if n <= 1 {
+		return [][2]int64{{start, end}}
+	}
+	chunk := (length + n - 1) / n
+	if chunk < partSizeMin {
+		chunk = partSizeMin
+	}
+	ranges := make([][2]int64, 0, n)
+	for s := start; s < end; s += chunk {
+		e := s + chunk
+		if e > end {
+			e = end
+		}
+		ranges = append(ranges, [2]int64{s, e})
+	}
+	return ranges
+}
+
+// copySourceRange returns the CopySourceRange of the bytes [start, end) of
+// the entry, in the source object.
+func copySourceRange(obj *S3Obj, start, end int64) string {
+	return fmt.Sprintf("bytes=%d-%d", obj.Offset+start, obj.Offset+end-1)
+}
+
+// copySource returns the CopySource of an UploadPartCopy for the object.
+func copySource(obj *S3Obj) string {
+	return formatCopySource(obj.Bucket, *obj.Key, obj.VersionId)
+}
+
+// formatCopySource returns bucket/key[?versionId=] with the key URL-encoded,
+// as S3 decodes CopySource. Without it, keys with %, + or spaces point to
+// another object or fail. The format is the same in every partition, the
+// endpoint the request is sent to is resolved by the client from its region.
+// Outposts access points are copied from with the ARN of the object.
+func formatCopySource(bucket, key, versionId string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		// PathEscape leaves + as is, S3 would decode it as a space
+		segments[i] = strings.ReplaceAll(url.PathEscape(s), "+", "%2B")
+	}
+	source := copySourceBucket(bucket) + "/" + strings.Join(segments, "/")
+	if versionId != "" {
+		source += "?versionId=" + url.QueryEscape(versionId)
+	}
+	return source
+}
+
+// Partition returns the AWS partition of a region: aws, aws-cn, aws-us-gov,
+// aws-iso or aws-iso-b.
+func Partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "aws-iso-b"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "aws-iso"
+	}
+	return "aws"
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func formatBytes(contentLength int64) string {
+	if contentLength < 0 {
+		return "Invalid size"
+	}
+
+	// Define SI unit symbols
+	units := []string{"Bytes", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+	// Convert to float64 for calculation
+	size := float64(contentLength)
+
+	// Determine the appropriate unit
+	unitIndex := 0
+	for size >= 1024 && unitIndex < len(units)-1 {
+		size /= 1024
+		unitIndex++
+	}
+
+	// Format the result with two decimal places
+	msg := fmt.Sprintf("%.4f %s", size, units[unitIndex])
+	if units[unitIndex] == "Bytes" {
+		msg = fmt.Sprintf("%.0f %s", size, units[unitIndex])
+	}
+	return msg
+}
@@ -0,0 +1,388 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestExtractBucketAndPath(t *testing.T) {
+	type args struct {
+		s3url string
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wantBucket string
+		wantPath   string
+	}{
+		{
+			name:       "valid path",
+			args:       args{s3url: "s3://bucket/prefix"},
+			wantBucket: "bucket",
+			wantPath:   "prefix",
+		},
+		{
+			name:       "valid path - end in slash",
+			args:       args{s3url: "s3://bucket/prefix/"},
+			wantBucket: "bucket",
+			wantPath:   "prefix/",
+		},
+		{
+			name:       "valid path, no prefix",
+			args:       args{s3url: "s3://bucket"},
+			wantBucket: "bucket",
+			wantPath:   "",
+		},
+		{
+			name:       "invalid path",
+			args:       args{s3url: "/home/yanko"},
+			wantBucket: "",
+			wantPath:   "",
+		},
+		{
+			name:       "no path",
+			args:       args{s3url: ""},
+			wantBucket: "",
+			wantPath:   "",
+		},
+		{
+			name:       "access point",
+			args:       args{s3url: "s3://arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap/prefix/archive.tar"},
+			wantBucket: "arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap",
+			wantPath:   "prefix/archive.tar",
+		},
+		{
+			name:       "multi-region access point",
+			args:       args{s3url: "s3://arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap/archive.tar"},
+			wantBucket: "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap",
+			wantPath:   "archive.tar",
+		},
+		{
+			name:       "outposts access point",
+			args:       args{s3url: "s3://arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/accesspoint/my-ap/prefix/archive.tar"},
+			wantBucket: "arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/accesspoint/my-ap",
+			wantPath:   "prefix/archive.tar",
+		},
+		{
+			name:       "outposts access point, no prefix",
+			args:       args{s3url: "s3://arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/accesspoint/my-ap"},
+			wantBucket: "arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/accesspoint/my-ap",
+			wantPath:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotBucket, gotPath := ExtractBucketAndPath(tt.args.s3url)
+			if gotBucket != tt.wantBucket {
+				t.Errorf("ExtractBucketAndPath() gotBucket = %v, want %v", gotBucket, tt.wantBucket)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("ExtractBucketAndPath() gotPath = %v, want %v", gotPath, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestSplitCopyRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end int64
+		wantParts  int
+	}{
+		{name: "small", start: 0, end: 1024, wantParts: 1},
+		{name: "exactly 5GiB", start: 0, end: partSizeMax, wantParts: 1},
+		{name: "just over 5GiB", start: 0, end: partSizeMax + 1, wantParts: 2},
+		{name: "300GiB with trim", start: defaultPadSize, end: 300 * 1024 * 1024 * 1024, wantParts: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitCopyRange(tt.start, tt.end)
+			if len(got) != tt.wantParts {
+				t.Fatalf("got %d ranges, want %d", len(got), tt.wantParts)
+			}
+			next := tt.start
+			for i, r := range got {
+				if r[0] != next {
+					t.Errorf("range %d starts at %d, want %d", i, r[0], next)
+				}
+				if r[1]-r[0] > partSizeMax {
+					t.Errorf("range %d is larger than 5GiB", i)
+				}
+				if i < len(got)-1 && r[1]-r[0] < partSizeMin {
+					t.Errorf("range %d is smaller than 5MiB", i)
+				}
+				next = r[1]
+			}
+			if next != tt.end {
+				t.Errorf("ranges end at %d, want %d", next, tt.end)
+			}
+		})
+	}
+}
+
+func TestPartition(t *testing.T) {
+	tests := map[string]string{
+		"us-west-2":      "aws",
+		"eu-central-1":   "aws",
+		"us-gov-west-1":  "aws-us-gov",
+		"cn-northwest-1": "aws-cn",
+		"us-iso-east-1":  "aws-iso",
+		"us-isob-east-1": "aws-iso-b",
+	}
+	for region, want := range tests {
+		if got := Partition(region); got != want {
+			t.Errorf("Partition(%s) = %s, want %s", region, got, want)
+		}
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	obj := NewS3ObjOptions(WithBucketAndKey("my-bucket", "prefix/file.txt"))
+	if got := copySource(obj); got != "my-bucket/prefix/file.txt" {
+		t.Errorf("copySource = %s", got)
+	}
+	obj.VersionId = "abc"
+	if got := copySource(obj); got != "my-bucket/prefix/file.txt?versionId=abc" {
+		t.Errorf("copySource = %s", got)
+	}
+
+	tests := map[string]string{
+		"my file.txt":         "my-bucket/my%20file.txt",
+		"a+b=c.txt":           "my-bucket/a%2Bb=c.txt",
+		"100%.txt":            "my-bucket/100%25.txt",
+		"dir/naïve/日本.txt":    "my-bucket/dir/na%C3%AFve/%E6%97%A5%E6%9C%AC.txt",
+		"what?#.txt":          "my-bucket/what%3F%23.txt",
+		"trailing/slash/":     "my-bucket/trailing/slash/",
+		"double//slash/a.txt": "my-bucket/double//slash/a.txt",
+	}
+	for key, want := range tests {
+		if got := formatCopySource("my-bucket", key, ""); got != want {
+			t.Errorf("formatCopySource(%q) = %s, want %s", key, got, want)
+		}
+	}
+	if got := formatCopySource("my-bucket", "a.txt", "3/L4k+qJ"); got != "my-bucket/a.txt?versionId=3%2FL4k%2BqJ" {
+		t.Errorf("formatCopySource with version = %s", got)
+	}
+	ap := "arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/accesspoint/my-ap"
+	if got := formatCopySource(ap, "dir/a b.txt", ""); got != "arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/object/dir/a%20b.txt" {
+		t.Errorf("formatCopySource on outposts = %s", got)
+	}
+	ap = "arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap"
+	if got := formatCopySource(ap, "a.txt", "v1"); got != ap+"/object/a.txt?versionId=v1" {
+		t.Errorf("formatCopySource on an access point = %s", got)
+	}
+}
+
+func TestSplitRange(t *testing.T) {
+	const mb = 1024 * 1024
+	got := splitRange(0, 12*mb, 5*mb)
+	want := [][2]int64{{0, 5 * mb}, {5 * mb, 10 * mb}, {10 * mb, 12 * mb}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitRange = %v, want %v", got, want)
+	}
+	got = splitRange(100, 100+20*mb, 8*mb)
+	if len(got) != 3 || got[0][0] != 100 || got[2][1] != 100+20*mb {
+		t.Errorf("splitRange = %v", got)
+	}
+}
+
+func TestJobConfig(t *testing.T) {
+	cfg := newJobConfig(&S3TarS3Options{PadSize: 1024 * 1024, Threads: 8})
+	if cfg.padSize != 1024*1024 || int64(len(cfg.pad())) != cfg.padSize || cfg.threads != 8 {
+		t.Errorf("pad = %d, len(pad) = %d, threads = %d", cfg.padSize, len(cfg.pad()), cfg.threads)
+	}
+	if size := cfg.findMinimumPartSize(1024*1024*100, 0); size != 1024*1024 {
+		t.Errorf("findMinimumPartSize = %d", size)
+	}
+	if defaultJobConfig.padSize != defaultPadSize || defaultJobConfig.format != tar.FormatPAX || defaultJobConfig.threads != 100 {
+		t.Errorf("unexpected defaults %+v", defaultJobConfig)
+	}
+
+	// the jobs running at the same time keep their own configuration
+	ctx := withJobConfig(context.Background(), cfg)
+	if got := jobConfigFromContext(ctx); got != cfg {
+		t.Errorf("got %+v, want %+v", got, cfg)
+	}
+	if got := jobConfigFromContext(context.Background()); got != defaultJobConfig {
+		t.Errorf("got %+v outside a job", got)
+	}
+}
+
+func TestMergeSmallGroups(t *testing.T) {
+	mb := int(defaultPadSize)
+	indexList := []Index{
+		{Start: 0, End: 9, Size: 1},
+		{Start: 10, End: 19, Size: mb},
+		{Start: 20, End: 29, Size: mb / 2},
+		{Start: 30, End: 39, Size: mb / 2},
+		{Start: 40, End: 49, Size: 2 * mb},
+		{Start: 50, End: 59, Size: 1},
+	}
+	want := []Index{
+		{Start: 0, End: 19, Size: mb + 1},
+		{Start: 20, End: 39, Size: mb},
+		{Start: 40, End: 49, Size: 2 * mb},
+		{Start: 50, End: 59, Size: 1},
+	}
+	got := defaultJobConfig.mergeSmallGroups(indexList)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// a small group before a large object goes to the group before it
+	indexList = []Index{
+		{Start: 0, End: 0, Size: 1},
+		{Start: 1, End: 1, Size: 2 * mb, DataFirst: true},
+		{Start: 2, End: 4, Size: 1},
+		{Start: 5, End: 6, Size: mb, DataFirst: true},
+	}
+	want = []Index{
+		{Start: 0, End: 4, Size: 2*mb + 2},
+		{Start: 5, End: 6, Size: mb, DataFirst: true},
+	}
+	if got := defaultJobConfig.mergeSmallGroups(indexList); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPlanGroups(t *testing.T) {
+	const mb = 1024 * 1024
+	ctx := withJobConfig(context.Background(), defaultJobConfig)
+	var objectList []*S3Obj
+	for i, size := range []int64{1000, 6 * mb, 100, 200, 7 * mb, 8 * mb, 10} {
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("my-bucket", fmt.Sprintf("%d.bin", i)), WithSize(size)))
+	}
+	indexList, totalSize, err := planGroups(ctx, objectList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups [][3]int
+	var plannedSize int
+	for _, idx := range indexList {
+		dataFirst := 0
+		if idx.DataFirst {
+			dataFirst = 1
+		}
+		groups = append(groups, [3]int{idx.Start, idx.End, dataFirst})
+		plannedSize += idx.Size
+	}
+	// the large objects start their groups but the first one, with the small
+	// objects before it
+	want := [][3]int{{0, 0, 0}, {1, 3, 1}, {4, 4, 1}, {5, 6, 1}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("got groups %v, want %v", groups, want)
+	}
+	if int64(plannedSize) != totalSize {
+		t.Errorf("planned %d bytes, total %d", plannedSize, totalSize)
+	}
+
+	// a list of small objects has no group starting with its data
+	indexList, _, err = planGroups(ctx, objectList[2:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexList) != 1 || indexList[0].DataFirst || indexList[0].End != 1 {
+		t.Errorf("got %v", indexList)
+	}
+}
+
+// flakyDeleteBackend reports an error for every key ending in 7 the first
+// failures times DeleteObjects is asked to delete it.
+type flakyDeleteBackend struct {
+	*MemoryBackend
+	failures int
+
+	mu       sync.Mutex
+	attempts map[string]int
+	inFlight int
+	maxBatch int
+	maxConc  int
+}
+
+func (f *flakyDeleteBackend) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	f.inFlight++
+	f.maxConc = max(f.maxConc, f.inFlight)
+	f.maxBatch = max(f.maxBatch, len(params.Delete.Objects))
+	var keep, failed []types.ObjectIdentifier
+	for _, o := range params.Delete.Objects {
+		key := aws.ToString(o.Key)
+		f.attempts[key]++
+		if strings.HasSuffix(key, "7") && f.attempts[key] <= f.failures {
+			failed = append(failed, o)
+		} else {
+			keep = append(keep, o)
+		}
+	}
+	f.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	out, err := f.MemoryBackend.DeleteObjects(ctx, &s3.DeleteObjectsInput{Bucket: params.Bucket, Delete: &types.Delete{Objects: keep}})
+	for _, o := range failed {
+		out.Errors = append(out.Errors, types.Error{Key: o.Key, Code: aws.String("SlowDown"), Message: aws.String("Please reduce your request rate.")})
+	}
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+	return out, err
+}
+
+func TestDeleteObjectList(t *testing.T) {
+	defer func(d time.Duration) { deleteRetryDelay = d }(deleteRetryDelay)
+	deleteRetryDelay = time.Millisecond
+	ctx := context.Background()
+
+	for _, failures := range []int{deleteAttempts - 1, deleteAttempts} {
+		m := NewMemoryBackend()
+		var objectList []*S3Obj
+		for i := 0; i < 4500; i++ {
+			key := fmt.Sprintf("dst/archive.tar.parts/%05d", i)
+			m.Put("bucket", key, []byte("x"))
+			objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("bucket", key)))
+		}
+		f := &flakyDeleteBackend{MemoryBackend: m, failures: failures, attempts: map[string]int{}}
+		err := deleteObjectList(ctx, f, objectList)
+		if f.maxBatch > deleteObjectsMax || f.maxConc > deleteConcurrency {
+			t.Errorf("batches of up to %d keys, %d at a time", f.maxBatch, f.maxConc)
+		}
+		left := m.Keys("bucket", "")
+		if failures < deleteAttempts {
+			if err != nil || len(left) != 0 {
+				t.Errorf("deleteObjectList() = %v, %d objects left", err, len(left))
+			}
+			continue
+		}
+		if err == nil || len(left) != 450 {
+			t.Errorf("deleteObjectList() = %v, %d objects left", err, len(left))
+		}
+	}
+}
+
+func TestCleanUpKeepIntermediates(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBackend()
+	opts := &S3TarS3Options{DstBucket: "bucket", DstPrefix: "dst/", DstKey: "dst/archive.tar", jobID: "0a1b2c3d"}
+	m.Put("bucket", partsKey(opts)+"/part-1", []byte("x"))
+	opts.KeepIntermediates = true
+	cleanUp(ctx, m, opts)
+	if len(m.Keys("bucket", "")) != 1 {
+		t.Errorf("the intermediate objects were deleted with KeepIntermediates")
+	}
+	opts.KeepIntermediates = false
+	cleanUp(ctx, m, opts)
+	if keys := m.Keys("bucket", ""); len(keys) != 0 {
+		t.Errorf("objects left: %v", keys)
+	}
+}
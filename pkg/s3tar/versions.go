@@ -0,0 +1,141 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// deleteObjectsMax is the number of keys a DeleteObjects request accepts.
+const deleteObjectsMax = 1000
+
+// ListNoncurrentVersions lists the noncurrent versions of the objects under
+// prefix in a versioned bucket, the history --noncurrent-versions archives.
+// Every version is named key.versions/<last modified>-<versionId> in the
+// archive so the versions of a key don't overwrite each other. The delete
+// markers that aren't the latest version of their key are returned as well,
+// they have no data to archive but are part of the history
+// DeleteVersions removes.
+func ListNoncurrentVersions(ctx context.Context, client *s3.Client, bucket, prefix string) ([]*S3Obj, []types.ObjectIdentifier, int64, error) {
+	return listVersions(ctx, client, bucket, prefix, false)
+}
+
+// ListAllVersions lists every version of the objects under prefix, the
+// current ones too, the capture of a versioned bucket --all-versions
+// archives. The versions are named like ListNoncurrentVersions names them.
+// Delete markers have no data and aren't listed.
+func ListAllVersions(ctx context.Context, client *s3.Client, bucket, prefix string) ([]*S3Obj, int64, error) {
+	list, _, accum, err := listVersions(ctx, client, bucket, prefix, true)
+	return list, accum, err
+}
+
+// listVersions lists the noncurrent versions under prefix, or every version
+// with all.
+func listVersions(ctx context.Context, client *s3.Client, bucket, prefix string, all bool) ([]*S3Obj, []types.ObjectIdentifier, int64, error) {
+	start := time.Now()
+	var list []*S3Obj
+	var markers []types.ObjectIdentifier
+	var accum int64
+	ctr := 1
+	p := s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for p.HasMorePages() {
+		output, err := p.NextPage(ctx)
+		if err != nil {
+			return list, markers, accum, err
+		}
+		for _, v := range output.Versions {
+			if (aws.ToBool(v.IsLatest) && !all) || !removeDirs(types.Object{Key: v.Key}) {
+				continue
+			}
+			o := NewS3ObjOptions(WithBucketAndKey(bucket, *v.Key), WithSize(aws.ToInt64(v.Size)), WithETag(aws.ToString(v.ETag)))
+			o.LastModified = v.LastModified
+			o.StorageClass = types.ObjectStorageClass(v.StorageClass)
+			o.VersionId = aws.ToString(v.VersionId)
+			o.Name = versionEntryName(*v.Key, o.VersionId, aws.ToTime(v.LastModified))
+			o.PartNum = ctr
+			ctr += 1
+			list = append(list, o)
+			accum += estimateObjectSize(*o.Size)
+		}
+		for _, m := range output.DeleteMarkers {
+			if aws.ToBool(m.IsLatest) {
+				continue
+			}
+			markers = append(markers, types.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+		}
+	}
+	if all {
+		Infof(ctx, "found %d versions in s3://%s/%s", len(list), bucket, prefix)
+	} else {
+		Infof(ctx, "found %d noncurrent versions and %d noncurrent delete markers in s3://%s/%s", len(list), len(markers), bucket, prefix)
+	}
+	recordStage(ctx, "list", start)
+	return list, markers, accum, nil
+}
+
+// versionEntryName is the name of a version in the archive, the versions of
+// a key sort by date.
+func versionEntryName(key, versionId string, lastModified time.Time) string {
+	return fmt.Sprintf("%s.versions/%s-%s", key, lastModified.UTC().Format("20060102T150405Z"), versionId)
+}
+
+// DeleteVersions permanently deletes the archived versions and the delete
+// markers from bucket, once the archive holding them is complete. Objects
+// without a VersionId are left alone, so the current versions are never
+// deleted. The versions the job of ctx skipped, e.g. with CheckKeysSkip or
+// ConflictKeepFirst, aren't in the archive: they and the delete markers of
+// their keys are left alone too, see WithSkipCounter. Every version that
+// couldn't be deleted is reported.
+func DeleteVersions(ctx context.Context, client *s3.Client, bucket string, versions []*S3Obj, markers []types.ObjectIdentifier) error {
+	skipped, _ := ctx.Value(contextKeySkipCounter).(*SkipCounter)
+	ids := make([]types.ObjectIdentifier, 0, len(versions)+len(markers))
+	kept := 0
+	for _, o := range versions {
+		if o.VersionId == "" || len(o.Data) > 0 {
+			continue
+		}
+		if skipped != nil && skipped.skippedVersion(*o.Key, o.VersionId) {
+			kept++
+			continue
+		}
+		ids = append(ids, types.ObjectIdentifier{Key: o.Key, VersionId: aws.String(o.VersionId)})
+	}
+	for _, m := range markers {
+		if skipped != nil && skipped.skippedKey(aws.ToString(m.Key)) {
+			kept++
+			continue
+		}
+		ids = append(ids, m)
+	}
+	if kept > 0 {
+		Warnf(ctx, "keeping %d versions and delete markers of objects that weren't archived", kept)
+	}
+
+	var errs []error
+	for i := 0; i < len(ids); i += deleteObjectsMax {
+		batch := ids[i:min(i+deleteObjectsMax, len(ids))]
+		output, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: batch, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete the versions from s3://%s: %w", bucket, err)
+		}
+		for _, e := range output.Errors {
+			errs = append(errs, fmt.Errorf("unable to delete s3://%s/%s version %s: %s", bucket, aws.ToString(e.Key), aws.ToString(e.VersionId), aws.ToString(e.Message)))
+		}
+	}
+	Infof(ctx, "deleted %d versions from s3://%s", len(ids)-len(errs), bucket)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,151 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// versionsHTTPClient answers ListObjectVersions with a fixed history and
+// records the bodies of the DeleteObjects requests.
+type versionsHTTPClient struct {
+	deletes *[]string
+}
+
+func (c versionsHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	body := `<ListVersionsResult>
+<IsTruncated>false</IsTruncated>
+<Version><Key>a.txt</Key><VersionId>v3</VersionId><IsLatest>true</IsLatest><LastModified>2024-03-01T00:00:00.000Z</LastModified><ETag>"e3"</ETag><Size>3</Size></Version>
+<Version><Key>a.txt</Key><VersionId>v2</VersionId><IsLatest>false</IsLatest><LastModified>2024-02-01T00:00:00.000Z</LastModified><ETag>"e2"</ETag><Size>2</Size></Version>
+<Version><Key>a.txt</Key><VersionId>v1</VersionId><IsLatest>false</IsLatest><LastModified>2024-01-01T10:20:30.000Z</LastModified><ETag>"e1"</ETag><Size>1</Size></Version>
+<Version><Key>dir/</Key><VersionId>d1</VersionId><IsLatest>false</IsLatest><LastModified>2024-01-01T00:00:00.000Z</LastModified><ETag>"d"</ETag><Size>0</Size></Version>
+<DeleteMarker><Key>b.txt</Key><VersionId>m2</VersionId><IsLatest>true</IsLatest><LastModified>2024-02-01T00:00:00.000Z</LastModified></DeleteMarker>
+<DeleteMarker><Key>c.txt</Key><VersionId>m1</VersionId><IsLatest>false</IsLatest><LastModified>2024-01-01T00:00:00.000Z</LastModified></DeleteMarker>
+</ListVersionsResult>`
+	if r.Method == http.MethodPost {
+		b, _ := io.ReadAll(r.Body)
+		*c.deletes = append(*c.deletes, string(b))
+		body = "<DeleteResult></DeleteResult>"
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+func TestNoncurrentVersions(t *testing.T) {
+	ctx := context.Background()
+	var deletes []string
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   versionsHTTPClient{deletes: &deletes},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+
+	versions, markers, _, err := ListNoncurrentVersions(ctx, svc, "bucket", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, o := range versions {
+		names = append(names, o.entryName()+"@"+o.VersionId)
+	}
+	want := "a.txt.versions/20240201T000000Z-v2@v2,a.txt.versions/20240101T102030Z-v1@v1"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if len(markers) != 1 || *markers[0].Key != "c.txt" {
+		t.Errorf("got markers %v, want c.txt", markers)
+	}
+
+	if err := DeleteVersions(ctx, svc, "bucket", versions, markers); err != nil {
+		t.Fatal(err)
+	}
+	if len(deletes) != 1 {
+		t.Fatalf("got %d DeleteObjects requests, want 1", len(deletes))
+	}
+	for _, id := range []string{"<VersionId>v1</VersionId>", "<VersionId>v2</VersionId>", "<VersionId>m1</VersionId>"} {
+		if !strings.Contains(deletes[0], id) {
+			t.Errorf("%s is missing from %s", id, deletes[0])
+		}
+	}
+	if strings.Contains(deletes[0], "<VersionId>v3</VersionId>") {
+		t.Errorf("the current version was deleted")
+	}
+
+	versions, _, err = ListAllVersions(ctx, svc, "bucket", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = nil
+	for _, o := range versions {
+		names = append(names, o.entryName()+"@"+o.VersionId)
+	}
+	want = "a.txt.versions/20240301T000000Z-v3@v3," + want
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDeleteVersionsSkipped(t *testing.T) {
+	var deletes []string
+	svc := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   versionsHTTPClient{deletes: &deletes},
+		Retryer:      aws.NopRetryer{},
+		UsePathStyle: true,
+	})
+	skipped := &SkipCounter{}
+	ctx := WithSkipCounter(context.Background(), skipped)
+
+	m := NewMemoryBackend()
+	var versions []*S3Obj
+	for _, v := range []struct{ key, versionId string }{{"a.txt", "v1"}, {"../b.txt", "v2"}} {
+		m.Put("bucket", v.key, []byte("data"))
+		o := NewS3ObjOptions(WithBucketAndKey("bucket", v.key), WithSize(4), WithETag("etag"))
+		o.VersionId = v.versionId
+		o.LastModified = aws.Time(time.Now())
+		versions = append(versions, o)
+	}
+	opts := &S3TarS3Options{SrcBucket: "bucket", DstBucket: "bucket", DstKey: "archive.tar", Region: "us-east-1", Threads: 2, CheckKeys: CheckKeysSkip}
+	if err := CreateFromListWithBackend(ctx, m, versions, opts); err != nil {
+		t.Fatal(err)
+	}
+	if skipped.Skipped() != 1 {
+		t.Fatalf("skipped %d objects, want 1", skipped.Skipped())
+	}
+
+	markers := []types.ObjectIdentifier{{Key: aws.String("c.txt"), VersionId: aws.String("m1")}, {Key: aws.String("../b.txt"), VersionId: aws.String("m2")}}
+	if err := DeleteVersions(ctx, svc, "bucket", versions, markers); err != nil {
+		t.Fatal(err)
+	}
+	if len(deletes) != 1 {
+		t.Fatalf("got %d DeleteObjects requests, want 1", len(deletes))
+	}
+	for _, id := range []string{"<VersionId>v1</VersionId>", "<VersionId>m1</VersionId>"} {
+		if !strings.Contains(deletes[0], id) {
+			t.Errorf("%s is missing from %s", id, deletes[0])
+		}
+	}
+	// the skipped key isn't in the archive, its history is kept
+	for _, id := range []string{"<VersionId>v2</VersionId>", "<VersionId>m2</VersionId>"} {
+		if strings.Contains(deletes[0], id) {
+			t.Errorf("%s of the skipped key was deleted", id)
+		}
+	}
+}
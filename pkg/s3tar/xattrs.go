@@ -0,0 +1,68 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// paxXattrPrefix is the prefix of the PAX records of the extended attributes
+// of an entry, the records GNU tar --xattrs and bsdtar restore.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// ParseXattrs parses the xattrs column of a manifest, URL query encoded
+// name=value pairs such as
+// security.selinux=system_u:object_r:default_t:s0&user.app=reports. The
+// names can't be empty, have a = or be set twice.
+func ParseXattrs(s string) (map[string]string, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xattrs %q: %w", s, err)
+	}
+	xattrs := make(map[string]string, len(values))
+	for name, v := range values {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return nil, fmt.Errorf("invalid xattr name %q", name)
+		}
+		if len(v) > 1 {
+			return nil, fmt.Errorf("xattr %s is set %d times", name, len(v))
+		}
+		xattrs[name] = v[0]
+	}
+	return xattrs, nil
+}
+
+// encodeXattrs returns the xattrs column of xattrs, see ParseXattrs.
+func encodeXattrs(xattrs map[string]string) string {
+	values := url.Values{}
+	for name, v := range xattrs {
+		values.Set(name, v)
+	}
+	return values.Encode()
+}
+
+// xattrRecords returns the PAX records of the extended attributes of an
+// entry, nil when it has none.
+func xattrRecords(xattrs map[string]string) map[string]string {
+	if len(xattrs) == 0 {
+		return nil
+	}
+	records := make(map[string]string, len(xattrs))
+	for name, v := range xattrs {
+		records[paxXattrPrefix+name] = v
+	}
+	return records
+}
+
+// hasXattrs reports whether an entry of objectList has extended attributes.
+func hasXattrs(objectList []*S3Obj) bool {
+	for _, o := range objectList {
+		if len(o.Xattrs) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseXattrs(t *testing.T) {
+	xattrs, err := ParseXattrs("security.selinux=system_u:object_r:default_t:s0&user.app=a%26b%3Dc")
+	if err != nil || len(xattrs) != 2 || xattrs["security.selinux"] != "system_u:object_r:default_t:s0" || xattrs["user.app"] != "a&b=c" {
+		t.Errorf("got %v %v", xattrs, err)
+	}
+	if got, err := ParseXattrs(encodeXattrs(xattrs)); err != nil || len(got) != 2 || got["user.app"] != "a&b=c" {
+		t.Errorf("round trip: got %v %v", got, err)
+	}
+	for _, s := range []string{"=value", "user.a=1&user.a=2", "user.a%3Db=1", "user.a=%zz"} {
+		if _, err := ParseXattrs(s); err == nil {
+			t.Errorf("%q should be refused", s)
+		}
+	}
+
+	manifest := "bucket,key,size,xattrs\nsrc-bucket,a.txt,10,user.app=reports\nsrc-bucket,b.txt,10,\n"
+	list, _, err := parseManifest(context.Background(), strings.NewReader(manifest), ManifestOptions{SkipHeader: true})
+	if err != nil || len(list) != 2 {
+		t.Fatalf("got %d objects: %v", len(list), err)
+	}
+	if list[0].Xattrs["user.app"] != "reports" || list[1].Xattrs != nil {
+		t.Errorf("xattrs = %v, %v", list[0].Xattrs, list[1].Xattrs)
+	}
+}
+
+func TestXattrRecords(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name  string
+		sizes []int
+		opts  S3TarS3Options
+	}{
+		{"in memory", []int{1000, 10}, S3TarS3Options{}},
+		{"small files", []int{1000, 3 * mb, 4 * mb}, S3TarS3Options{}},
+		{"aligned", []int{6 * mb, 100, 5 * mb}, S3TarS3Options{Align: 4096}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := NewMemoryBackend()
+			contents := map[string][]byte{}
+			var objectList []*S3Obj
+			for i, size := range tt.sizes {
+				key := fmt.Sprintf("files/%d.bin", i)
+				data := bytes.Repeat([]byte{byte('a' + i)}, size)
+				contents[key] = data
+				m.Put("src-bucket", key, data)
+				o := NewS3ObjOptions(WithBucketAndKey("src-bucket", key), WithSize(int64(size)))
+				if i%2 == 0 {
+					o.Xattrs = map[string]string{"security.selinux": "system_u:object_r:default_t:s0", "user.index": strconv.Itoa(i)}
+				}
+				objectList = append(objectList, o)
+			}
+			opts := tt.opts
+			opts.SrcBucket, opts.DstBucket, opts.DstKey, opts.Threads = "src-bucket", "dst-bucket", "archives/archive.tar", 4
+			opts.DstPrefix, opts.Region = KeyDir(opts.DstKey), "us-east-1"
+			if err := CreateFromListWithBackend(ctx, m, objectList, &opts); err != nil {
+				t.Fatal(err)
+			}
+			archive := m.Get("dst-bucket", opts.DstKey)
+			tr := tar.NewReader(bytes.NewReader(archive))
+			var toc []byte
+			for i := 0; ; {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := io.ReadAll(tr)
+				if hdr.Name == "toc.csv" {
+					toc = data
+					continue
+				}
+				want := ""
+				if i%2 == 0 {
+					want = strconv.Itoa(i)
+				}
+				if got := hdr.PAXRecords[paxXattrPrefix+"user.index"]; got != want {
+					t.Errorf("%s: user.index = %q, want %q", hdr.Name, got, want)
+				}
+				if !bytes.Equal(data, contents[hdr.Name]) {
+					t.Errorf("%s: wrong data", hdr.Name)
+				}
+				i++
+			}
+			if tt.name == "in memory" {
+				return
+			}
+			lines, err := csv.NewReader(bytes.NewReader(toc)).ReadAll()
+			if err != nil || len(lines) != len(contents) {
+				t.Fatalf("invalid TOC %q: %v", toc, err)
+			}
+			for _, line := range lines {
+				start, _ := strconv.ParseInt(line[1], 10, 64)
+				size, _ := strconv.ParseInt(line[2], 10, 64)
+				if !bytes.Equal(archive[start:start+size], contents[line[0]]) {
+					t.Errorf("%s: the TOC offset %d is wrong", line[0], start)
+				}
+				if opts.Align > 0 && start%opts.Align != 0 {
+					t.Errorf("%s starts at %d", line[0], start)
+				}
+			}
+		})
+	}
+
+	objectList := []*S3Obj{NewS3ObjOptions(WithBucketAndKey("src-bucket", "a.txt"), WithSize(10))}
+	objectList[0].Xattrs = map[string]string{"user.app": "reports"}
+	m := NewMemoryBackend()
+	m.Put("src-bucket", "a.txt", make([]byte, 10))
+	opts := &S3TarS3Options{SrcBucket: "src-bucket", DstBucket: "dst-bucket", DstKey: "archive.tar", Region: "us-east-1", Threads: 4}
+	if err := CreateFromListWithBackend(context.Background(), m, objectList, opts, WithTarFormat("gnu")); err == nil {
+		t.Errorf("the gnu format has no PAX records, the xattrs should be refused")
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"golang.org/x/sync/errgroup"
+)
+
+// ZIP (store only) archives are assembled the same way as the tar archives:
+// the local file headers and the central directory are generated in memory
+// and uploaded as small parts, the file contents are copied server-side.
+// The only data that has to be read is the CRC-32 of each object, which comes
+// from the object checksum when S3 has a full-object CRC32 and is streamed
+// otherwise.
+
+const (
+	zipLocalHeaderSig   = 0x04034b50
+	zipCentralHeaderSig = 0x02014b50
+	zipEndSig           = 0x06054b50
+	zip64EndSig         = 0x06064b50
+	zip64LocatorSig     = 0x07064b50
+	zip64ExtraID        = 0x0001
+	zipVersion20        = 20
+	zipVersion45        = 45
+	zipCreatorUnix      = 3
+	zipFlagUTF8         = 0x800
+	zipUint32Max        = 0xffffffff
+	zipUint16Max        = 0xffff
+)
+
+type zipEntry struct {
+	Name    string
+	Size    int64
+	CRC32   uint32
+	ModTime time.Time
+	Offset  int64
+}
+
+func (e *zipEntry) isZip64() bool {
+	return e.Size >= zipUint32Max || e.Offset >= zipUint32Max
+}
+
+// zipLocalHeader returns the local file header for the entry, the file data
+// follows it directly.
+func zipLocalHeader(e *zipEntry) []byte {
+	var extra []byte
+	size32 := uint32(e.Size)
+	version := uint16(zipVersion20)
+	if e.Size >= zipUint32Max {
+		extra = make([]byte, 20)
+		binary.LittleEndian.PutUint16(extra[0:], zip64ExtraID)
+		binary.LittleEndian.PutUint16(extra[2:], 16)
+		binary.LittleEndian.PutUint64(extra[4:], uint64(e.Size))
+		binary.LittleEndian.PutUint64(extra[12:], uint64(e.Size))
+		size32 = zipUint32Max
+		version = zipVersion45
+	}
+	modTime, modDate := msDosTime(e.ModTime)
+	buf := &bytes.Buffer{}
+	writeLE(buf,
+		uint32(zipLocalHeaderSig),
+		version,
+		uint16(zipFlagUTF8),
+		uint16(0), // store
+		modTime,
+		modDate,
+		e.CRC32,
+		size32, // compressed size
+		size32,
+		uint16(len(e.Name)),
+		uint16(len(extra)),
+	)
+	buf.WriteString(e.Name)
+	buf.Write(extra)
+	return buf.Bytes()
+}
+
+// zipCentralDirectory returns the central directory and end of central
+// directory records, offset is where the central directory starts.
+func zipCentralDirectory(entries []*zipEntry, offset int64) []byte {
+	buf := &bytes.Buffer{}
+	for _, e := range entries {
+		var extra []byte
+		size32, offset32 := uint32(e.Size), uint32(e.Offset)
+		if e.Size >= zipUint32Max {
+			extra = binary.LittleEndian.AppendUint64(extra, uint64(e.Size))
+			extra = binary.LittleEndian.AppendUint64(extra, uint64(e.Size))
+			size32 = zipUint32Max
+		}
+		if e.Offset >= zipUint32Max {
+			extra = binary.LittleEndian.AppendUint64(extra, uint64(e.Offset))
+			offset32 = zipUint32Max
+		}
+		if len(extra) > 0 {
+			hdr := binary.LittleEndian.AppendUint16(nil, zip64ExtraID)
+			hdr = binary.LittleEndian.AppendUint16(hdr, uint16(len(extra)))
+			extra = append(hdr, extra...)
+		}
+		version := uint16(zipVersion20)
+		if e.isZip64() {
+			version = zipVersion45
+		}
+		modTime, modDate := msDosTime(e.ModTime)
+		writeLE(buf,
+			uint32(zipCentralHeaderSig),
+			uint16(zipCreatorUnix<<8|zipVersion45),
+			version,
+			uint16(zipFlagUTF8),
+			uint16(0), // store
+			modTime,
+			modDate,
+			e.CRC32,
+			size32,
+			size32,
+			uint16(len(e.Name)),
+			uint16(len(extra)),
+			uint16(0),        // comment length
+			uint16(0),        // disk number
+			uint16(0),        // internal attributes
+			uint32(0600<<16), // external attributes, unix mode
+			offset32,
+		)
+		buf.WriteString(e.Name)
+		buf.Write(extra)
+	}
+
+	cdSize := int64(buf.Len())
+	count := len(entries)
+	if count >= zipUint16Max || cdSize >= zipUint32Max || offset >= zipUint32Max {
+		zip64EndOffset := offset + cdSize
+		writeLE(buf,
+			uint32(zip64EndSig),
+			uint64(44), // size of the rest of the record
+			uint16(zipCreatorUnix<<8|zipVersion45),
+			uint16(zipVersion45),
+			uint32(0), // disk number
+			uint32(0), // disk with the central directory
+			uint64(count),
+			uint64(count),
+			uint64(cdSize),
+			uint64(offset),
+			uint32(zip64LocatorSig),
+			uint32(0),
+			uint64(zip64EndOffset),
+			uint32(1), // total number of disks
+		)
+		count = zipUint16Max
+		cdSize = zipUint32Max
+		offset = zipUint32Max
+	}
+	writeLE(buf,
+		uint32(zipEndSig),
+		uint16(0),
+		uint16(0),
+		uint16(count),
+		uint16(count),
+		uint32(cdSize),
+		uint32(offset),
+		uint16(0), // comment length
+	)
+	return buf.Bytes()
+}
+
+// writeLE writes the fixed size values in little endian order.
+func writeLE(buf *bytes.Buffer, values ...any) {
+	for _, v := range values {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+}
+
+// msDosTime converts a time to the MS-DOS date and time format ZIP uses.
+func msDosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	fDate := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	fTime := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return fTime, fDate
+}
+
+// objectCRC32 returns the CRC-32 of an object. The checksum stored by S3 is
+// used when the object was uploaded with a full-object CRC32, otherwise the
+// object is streamed and hashed.
+func objectCRC32(ctx context.Context, svc Backend, obj *S3Obj) (uint32, error) {
+	if len(obj.Data) > 0 {
+		return crc32.ChecksumIEEE(obj.Data), nil
+	}
+	// the checksum of the object isn't the one of a slice
+	if !obj.Slice {
+		attrs, err := getObjectAttributes(ctx, svc, obj)
+		if err == nil && attrs.ChecksumCRC32 != "" && attrs.FullObjectChecksum() {
+			b, err := base64.StdEncoding.DecodeString(attrs.ChecksumCRC32)
+			if err == nil && len(b) == 4 {
+				return binary.BigEndian.Uint32(b), nil
+			}
+		}
+	}
+	Debugf(ctx, "streaming s3://%s/%s to compute the crc32", obj.Bucket, *obj.Key)
+	r, err := getObjectVersion(ctx, svc, obj)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, r); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+func createZipFromList(ctx context.Context, svc Backend, objectList []*S3Obj, opts *S3TarS3Options) (*S3Obj, error) {
+	cfg := jobConfigFromContext(ctx)
+
+	Infof(ctx, "building zip (store only) archive")
+	if calculateFinalSize(objectList) < cfg.padSize {
+		return buildInMemoryZip(ctx, svc, objectList, opts)
+	}
+
+	entries := make([]*zipEntry, len(objectList))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for i, o := range objectList {
+		i, o := i, o
+		g.Go(func() error {
+			crc, err := objectCRC32(gctx, svc, o)
+			if err != nil {
+				return fmt.Errorf("unable to get crc32 of s3://%s/%s: %w", o.Bucket, *o.Key, err)
+			}
+			entries[i] = &zipEntry{
+				Name:    o.entryName(),
+				Size:    *o.Size,
+				CRC32:   crc,
+				ModTime: *o.LastModified,
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var offset int64 = 0
+	parts := []*S3Obj{}
+	for i, e := range entries {
+		e.Offset = offset
+		header := NewS3Obj()
+		header.AddData(zipLocalHeader(e))
+		header.Key = aws.String("zip-header")
+		header.Bucket = opts.DstBucket
+		parts = append(parts, header, objectList[i])
+		offset += *header.Size + e.Size
+	}
+	centralDirectory := NewS3Obj()
+	centralDirectory.AddData(zipCentralDirectory(entries, offset))
+	centralDirectory.Key = aws.String("zip-central-directory")
+	centralDirectory.Bucket = opts.DstBucket
+	parts = append(parts, centralDirectory)
+
+	rc, err := NewRecursiveConcat(ctx, RecursiveConcatOptions{
+		Client:      svc,
+		Bucket:      opts.DstBucket,
+		DstPrefix:   opts.DstPrefix,
+		DstKey:      opts.DstKey,
+		PartsKey:    partsKey(opts),
+		Region:      opts.Region,
+		EndpointUrl: opts.EndpointUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// group the parts so every group but the last is over the 5MB part minimum
+	groups := [][]*S3Obj{}
+	var current []*S3Obj
+	var currentSize int64
+	for _, p := range parts {
+		current = append(current, p)
+		currentSize += *p.Size
+		if currentSize >= cfg.padSize {
+			groups = append(groups, current)
+			current, currentSize = nil, 0
+		}
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+
+	parentPartsKey := partsKey(opts)
+	results := make([]*S3Obj, len(groups))
+	g, gctx = errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for i, group := range groups {
+		i, group := i, group
+		g.Go(func() error {
+			if len(group) == 1 && len(group[0].Data) == 0 {
+				results[i] = group[0]
+				return nil
+			}
+			dstKey := JoinKey(parentPartsKey, fmt.Sprintf("zip.group.%d", i))
+			res, err := rc.ConcatObjects(gctx, group, opts.DstBucket, dstKey)
+			if err != nil {
+				return err
+			}
+			results[i] = res
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	tempKey := JoinKey(parentPartsKey, "output.zip.temp")
+	concatObj, err := concatObjects(ctx, svc, 0, results, opts.DstBucket, tempKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOverwrite(ctx, svc, opts); err != nil {
+		return nil, err
+	}
+	return redistribute(ctx, svc, concatObj, 0, opts.DstBucket, opts.DstKey, opts.storageClass, opts.ObjectTags)
+}
+
+// buildInMemoryZip is used when the whole archive is under the 5MB part
+// minimum, the objects are downloaded and the zip is uploaded in one request.
+func buildInMemoryZip(ctx context.Context, svc Backend, objectList []*S3Obj, opts *S3TarS3Options) (*S3Obj, error) {
+	contents := make([][]byte, len(objectList))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for i, o := range objectList {
+		i, o := i, o
+		g.Go(func() error {
+			if len(o.Data) > 0 {
+				contents[i] = o.Data
+				return nil
+			}
+			r, _, err := downloadS3Data(gctx, svc, o)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			contents[i], err = io.ReadAll(r)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	entries := make([]*zipEntry, len(objectList))
+	for i, o := range objectList {
+		entries[i] = &zipEntry{
+			Name:    o.entryName(),
+			Size:    int64(len(contents[i])),
+			CRC32:   crc32.ChecksumIEEE(contents[i]),
+			ModTime: *o.LastModified,
+			Offset:  int64(buf.Len()),
+		}
+		buf.Write(zipLocalHeader(entries[i]))
+		buf.Write(contents[i])
+	}
+	buf.Write(zipCentralDirectory(entries, int64(buf.Len())))
+	if err := checkOverwrite(ctx, svc, opts); err != nil {
+		return nil, err
+	}
+	return uploadObject(ctx, svc, opts.DstBucket, opts.DstKey, buf.Bytes(), nil, opts)
+}
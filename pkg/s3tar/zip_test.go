@@ -0,0 +1,161 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func buildTestZip(files map[string][]byte, names []string) []byte {
+	buf := &bytes.Buffer{}
+	entries := []*zipEntry{}
+	for _, name := range names {
+		e := &zipEntry{
+			Name:    name,
+			Size:    int64(len(files[name])),
+			CRC32:   crc32.ChecksumIEEE(files[name]),
+			ModTime: time.Date(2023, 5, 17, 10, 30, 20, 0, time.UTC),
+			Offset:  int64(buf.Len()),
+		}
+		entries = append(entries, e)
+		buf.Write(zipLocalHeader(e))
+		buf.Write(files[name])
+	}
+	buf.Write(zipCentralDirectory(entries, int64(buf.Len())))
+	return buf.Bytes()
+}
+
+func TestZipLayout(t *testing.T) {
+	files := map[string][]byte{
+		"a.txt":         []byte("hello world"),
+		"dir/b.bin":     bytes.Repeat([]byte{0xfe}, 5000),
+		"empty":         {},
+		"données/ü.txt": []byte("unicode"),
+	}
+	names := []string{"a.txt", "dir/b.bin", "empty", "données/ü.txt"}
+	data := buildTestZip(files, names)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(names) {
+		t.Fatalf("got %d files, want %d", len(zr.File), len(names))
+	}
+	for i, f := range zr.File {
+		if f.Name != names[i] {
+			t.Errorf("name = %q, want %q", f.Name, names[i])
+		}
+		if f.Method != zip.Store {
+			t.Errorf("%s: method = %d, want store", f.Name, f.Method)
+		}
+		if f.Modified.Year() != 2023 || f.Modified.Minute() != 30 {
+			t.Errorf("%s: modified = %s", f.Name, f.Modified)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r) // fails on a crc mismatch
+		if err != nil {
+			t.Fatalf("%s: %s", f.Name, err)
+		}
+		if !bytes.Equal(got, files[f.Name]) {
+			t.Errorf("%s: content mismatch", f.Name)
+		}
+	}
+}
+
+func TestZipLayoutZip64EntryCount(t *testing.T) {
+	files := map[string][]byte{}
+	names := []string{}
+	for i := 0; i < zipUint16Max+10; i++ {
+		name := fmt.Sprintf("f%06d", i)
+		files[name] = nil
+		names = append(names, name)
+	}
+	data := buildTestZip(files, names)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(names) {
+		t.Fatalf("got %d files, want %d", len(zr.File), len(names))
+	}
+}
+
+// crc32AttributesBackend answers GetObjectAttributes with the full-object
+// CRC-32 of the objects.
+type crc32AttributesBackend struct {
+	*MemoryBackend
+}
+
+func (b crc32AttributesBackend) GetObjectAttributes(ctx context.Context, params *s3.GetObjectAttributesInput, optFns ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	data := b.Get(*params.Bucket, *params.Key)
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(data))
+	return &s3.GetObjectAttributesOutput{
+		Checksum: &types.Checksum{ChecksumCRC32: aws.String(base64.StdEncoding.EncodeToString(sum))},
+	}, nil
+}
+
+func TestZipSlice(t *testing.T) {
+	const mb = 1024 * 1024
+	ctx := context.Background()
+	m := crc32AttributesBackend{NewMemoryBackend()}
+	data := make([]byte, 7*mb)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	m.Put("src-bucket", "big.bin", data)
+
+	// a slice over the 5MB part minimum so the zip is built with copies
+	obj := NewS3ObjOptions(WithBucketAndKey("src-bucket", "big.bin"), WithSize(int64(len(data))), WithETag("etag"))
+	obj.LastModified = aws.Time(time.Date(2023, 5, 17, 10, 30, 20, 0, time.UTC))
+	obj.Slice, obj.Offset, obj.Size = true, 1000, aws.Int64(6*mb)
+	obj.Name = "big.bin.1000"
+	want := data[1000 : 1000+6*mb]
+
+	opts := S3TarS3Options{SrcBucket: "src-bucket", DstBucket: "dst-bucket", DstKey: "archives/archive.zip", Threads: 4, Region: "us-east-1"}
+	opts.DstPrefix = KeyDir(opts.DstKey)
+	if err := CreateFromListWithBackend(ctx, m, []*S3Obj{obj}, &opts, WithTarFormat("zip")); err != nil {
+		t.Fatal(err)
+	}
+	archive := m.Get("dst-bucket", opts.DstKey)
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 {
+		t.Fatalf("got %d files, want 1", len(zr.File))
+	}
+	f := zr.File[0]
+	if f.CRC32 != crc32.ChecksumIEEE(want) {
+		t.Errorf("crc32 = %08x, want the one of the slice %08x", f.CRC32, crc32.ChecksumIEEE(want))
+	}
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r) // fails on a crc mismatch
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("the zip entry isn't the slice")
+	}
+}